@@ -0,0 +1,256 @@
+// Package action takes follow-up actions on analyzed messages, such as moving the
+// source file to a quarantine directory or reporting the message to the abuse contact of
+// its sender, according to the actions in the configuration.
+package action
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"mail-analyzer/audit"
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+	"mail-analyzer/mta"
+	"mail-analyzer/sink"
+)
+
+// Message is an analyzed message that actions are taken on.
+type Message struct {
+	*sink.Result
+	// Raw is the original message. It is written to the quarantine directory when
+	// there is no source file, e.g. when the message was read from stdin.
+	Raw []byte
+}
+
+// Engine runs the configured actions for each analyzed message.
+type Engine struct {
+	actions []config.Action
+	imap    *IMAPMover
+	dryRun  io.Writer
+	// httpClient looks up the abuse contacts of the arf action.
+	httpClient *http.Client
+	// deliverer sends the reports of the arf action, if not nil. It is replaced in tests.
+	deliverer mta.Deliverer
+}
+
+// New creates an Engine for the actions in cfg.
+func New(cfg *config.Config) (*Engine, error) {
+	e := &Engine{actions: cfg.Actions}
+	for _, a := range cfg.Actions {
+		if a.Type == config.ActionIMAPJunk && e.imap == nil {
+			mover, err := NewIMAPMover(cfg)
+			if err != nil {
+				return nil, err
+			}
+			e.imap = mover
+		}
+		if a.Type == config.ActionARF && e.httpClient == nil {
+			client, err := httpclient.New(cfg)
+			if err != nil {
+				return nil, err
+			}
+			e.httpClient = client
+		}
+	}
+	return e, nil
+}
+
+// SetDryRun makes the engine describe the actions it would take on w instead of taking them.
+func (e *Engine) SetDryRun(w io.Writer) {
+	e.dryRun = w
+}
+
+// Run takes every action matching the verdict of msg, in configuration order, and
+// returns the joined errors. A failed action does not prevent the following ones.
+func (e *Engine) Run(ctx context.Context, msg *Message) error {
+	var errs []error
+	for _, a := range e.actions {
+		if !Matches(&a, msg.Result) {
+			continue
+		}
+		if e.dryRun != nil {
+			fmt.Fprintf(e.dryRun, "Would %s\n", describe(&a, msg))
+			continue
+		}
+		log.Printf("DEBUG Action: %s", describe(&a, msg))
+		target, err := e.run(ctx, &a, msg)
+		audit.Record(ctx, audit.Entry{
+			AnalysisID: msg.AnalysisID,
+			MessageID:  msg.MessageID,
+			Tenant:     msg.Tenant,
+			Action:     a.Type,
+			Target:     target,
+			Error:      audit.ErrorString(err),
+		})
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s action: %w", a.Type, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// run takes the action a on msg, and returns what it was taken on or sent to, for the
+// audit log.
+func (e *Engine) run(ctx context.Context, a *config.Action, msg *Message) (string, error) {
+	switch a.Type {
+	case config.ActionMove, config.ActionCopy:
+		path, err := quarantine(a.Dir, msg, a.Type == config.ActionMove)
+		if err != nil {
+			return a.Dir, err
+		}
+		return path, nil
+	case config.ActionIMAPJunk:
+		return e.imap.junk, e.imap.MoveToJunk(ctx, msg.MessageID)
+	case config.ActionCommand:
+		return a.Command[0], runCommand(ctx, a.Command, msg.Result)
+	case config.ActionARF:
+		to, err := e.reportAbuse(ctx, a, msg)
+		return strings.Join(to, ", "), err
+	}
+	return "", fmt.Errorf("unknown action type %q", a.Type)
+}
+
+// Matches reports whether the verdict of r satisfies the When and MinConfidence of a.
+func Matches(a *config.Action, r *sink.Result) bool {
+	j := r.Judgment
+	if j == nil || j.ConfidenceScore < a.MinConfidence {
+		return false
+	}
+	switch strings.ToLower(a.When) {
+	case "any":
+		return true
+	case "suspicious":
+		return j.IsSuspicious
+	case "safe":
+		return !j.IsSuspicious
+	default:
+		return strings.EqualFold(a.When, j.Category)
+	}
+}
+
+// describe returns a human-readable description of the action, as used in dry-run output.
+func describe(a *config.Action, msg *Message) string {
+	switch a.Type {
+	case config.ActionMove, config.ActionCopy:
+		return fmt.Sprintf("%s %s to %s", a.Type, sourceName(msg), a.Dir)
+	case config.ActionIMAPJunk:
+		return fmt.Sprintf("move IMAP message %s to the junk mailbox", msg.MessageID)
+	case config.ActionCommand:
+		return fmt.Sprintf("run %q for %s", a.Command, sourceName(msg))
+	case config.ActionARF:
+		if len(a.To) > 0 {
+			return fmt.Sprintf("send an abuse report of %s to %s", sourceName(msg), strings.Join(a.To, ", "))
+		}
+		return fmt.Sprintf("send an abuse report of %s to the abuse contact of its source network", sourceName(msg))
+	}
+	return a.Type
+}
+
+func sourceName(msg *Message) string {
+	if hasSourceFile(msg) {
+		return msg.SourceFile
+	}
+	return "the message from stdin"
+}
+
+func hasSourceFile(msg *Message) bool {
+	return msg.SourceFile != "" && msg.SourceFile != "stdin"
+}
+
+// quarantine moves or copies the source file of msg into dir and returns the new path.
+// Existing files are never overwritten; a numeric suffix is added to the name instead.
+// Messages without a source file are written from msg.Raw.
+func quarantine(dir string, msg *Message, move bool) (string, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+
+	data := msg.Raw
+	name := ""
+	if hasSourceFile(msg) {
+		var err error
+		if data, err = os.ReadFile(msg.SourceFile); err != nil {
+			return "", err
+		}
+		name = filepath.Base(msg.SourceFile)
+	} else {
+		sum := sha256.Sum256(data)
+		name = hex.EncodeToString(sum[:8]) + ".eml"
+	}
+
+	target, f, err := createUnique(dir, name)
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(target)
+		return "", err
+	}
+
+	if move && hasSourceFile(msg) {
+		if err := os.Remove(msg.SourceFile); err != nil {
+			return target, fmt.Errorf("copied to %s but could not remove the source file: %w", target, err)
+		}
+	}
+	return target, nil
+}
+
+// createUnique creates a new file named name in dir, adding "-1", "-2", ... before the
+// extension while the name is taken.
+func createUnique(dir, name string) (string, *os.File, error) {
+	ext := filepath.Ext(name)
+	stem := strings.TrimSuffix(name, ext)
+	for i := 0; ; i++ {
+		candidate := name
+		if i > 0 {
+			candidate = stem + "-" + strconv.Itoa(i) + ext
+		}
+		path := filepath.Join(dir, candidate)
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			continue
+		}
+		return path, f, err
+	}
+}
+
+// runCommand runs command without a shell, passing the result as JSON on stdin and the
+// main fields in MAIL_ANALYZER_* environment variables.
+func runCommand(ctx context.Context, command []string, r *sink.Result) error {
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(r); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	cmd.Stdin = &body
+	cmd.Env = os.Environ()
+	for _, field := range []string{"source_file", "message_id", "category", "is_suspicious", "confidence", "tenant"} {
+		value, _ := r.Field(field)
+		cmd.Env = append(cmd.Env, "MAIL_ANALYZER_"+strings.ToUpper(field)+"="+value)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"mail-analyzer/audit"
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+	"mail-analyzer/sink"
+)
+
+func TestMatches(t *testing.T) {
+	phishing := &sink.Result{Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.8}}
+	tests := []struct {
+		action config.Action
+		want   bool
+	}{
+		{config.Action{When: "any"}, true},
+		{config.Action{When: "suspicious"}, true},
+		{config.Action{When: "safe"}, false},
+		{config.Action{When: "phishing"}, true},
+		{config.Action{When: "Spam"}, false},
+		{config.Action{When: "suspicious", MinConfidence: 0.9}, false},
+	}
+	for _, tt := range tests {
+		if got := Matches(&tt.action, phishing); got != tt.want {
+			t.Errorf("Matches(%+v) = %v, want %v", tt.action, got, tt.want)
+		}
+	}
+	if Matches(&config.Action{When: "any"}, &sink.Result{}) {
+		t.Error("Matches() = true for a result without a judgment")
+	}
+}
+
+func TestEngine_Run(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "mail.eml")
+	os.WriteFile(source, []byte("Subject: Hi\n\nBody\n"), 0o600)
+	quarantineDir := filepath.Join(dir, "quarantine")
+	os.MkdirAll(quarantineDir, 0o700)
+	os.WriteFile(filepath.Join(quarantineDir, "mail.eml"), []byte("older"), 0o600)
+
+	engine, err := New(&config.Config{Actions: []config.Action{
+		{When: "suspicious", Type: config.ActionCopy, Dir: filepath.Join(dir, "copies")},
+		{When: "suspicious", Type: config.ActionMove, Dir: quarantineDir},
+		{When: "safe", Type: config.ActionMove, Dir: filepath.Join(dir, "never")},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	msg := &Message{Result: &sink.Result{
+		SourceFile: source,
+		Judgment:   &llm.Judgment{IsSuspicious: true, Category: "Phishing"},
+	}}
+
+	var dryRun bytes.Buffer
+	engine.SetDryRun(&dryRun)
+	if err := engine.Run(context.Background(), msg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	want := "Would copy " + source + " to " + filepath.Join(dir, "copies") + "\n" +
+		"Would move " + source + " to " + quarantineDir + "\n"
+	if dryRun.String() != want {
+		t.Errorf("dry run output = %q, want %q", dryRun.String(), want)
+	}
+	if _, err := os.Stat(source); err != nil {
+		t.Fatal("dry run touched the source file")
+	}
+
+	engine.SetDryRun(nil)
+	if err := engine.Run(context.Background(), msg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	if _, err := os.Stat(source); !os.IsNotExist(err) {
+		t.Error("source file was not moved")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dir, "copies", "mail.eml")); string(data) != "Subject: Hi\n\nBody\n" {
+		t.Errorf("copy = %q", data)
+	}
+	if data, _ := os.ReadFile(filepath.Join(quarantineDir, "mail-1.eml")); string(data) != "Subject: Hi\n\nBody\n" {
+		t.Errorf("quarantined file = %q", data)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "never")); !os.IsNotExist(err) {
+		t.Error("non-matching action was taken")
+	}
+}
+
+func TestEngine_RunStdinMessage(t *testing.T) {
+	dir := t.TempDir()
+	engine, _ := New(&config.Config{Actions: []config.Action{{When: "any", Type: config.ActionMove, Dir: dir}}})
+	msg := &Message{
+		Result: &sink.Result{SourceFile: "stdin", Judgment: &llm.Judgment{Category: "Safe"}},
+		Raw:    []byte("Subject: From stdin\n\n"),
+	}
+	if err := engine.Run(context.Background(), msg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if len(files) != 1 {
+		t.Fatalf("expected one quarantined file, got %v", files)
+	}
+	if data, _ := os.ReadFile(files[0]); string(data) != "Subject: From stdin\n\n" {
+		t.Errorf("quarantined file = %q", data)
+	}
+}
+
+func TestEngine_RunCommand(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	engine, _ := New(&config.Config{Actions: []config.Action{
+		{When: "any", Type: config.ActionCommand, Command: []string{"sh", "-c", `echo "$MAIL_ANALYZER_CATEGORY $MAIL_ANALYZER_CONFIDENCE" > "$0"; cat >> "$0"`, out}},
+		{When: "any", Type: config.ActionCommand, Command: []string{"sh", "-c", "echo broken >&2; exit 3"}},
+	}})
+	msg := &Message{Result: &sink.Result{MessageID: "<1@example.com>", Judgment: &llm.Judgment{Category: "Spam", ConfidenceScore: 0.5}}}
+
+	err := engine.Run(context.Background(), msg)
+	if err == nil || !strings.Contains(err.Error(), "broken") {
+		t.Errorf("Run() error = %v, want the failing command's stderr", err)
+	}
+	data, _ := os.ReadFile(out)
+	if !strings.HasPrefix(string(data), "Spam 0.50\n{") || !strings.Contains(string(data), `"message_id":"<1@example.com>"`) {
+		t.Errorf("command saw %q", data)
+	}
+}
+
+func TestEngine_RunAudit(t *testing.T) {
+	dir := t.TempDir()
+	logPath := filepath.Join(dir, "audit.jsonl")
+	l, err := audit.Open(logPath)
+	if err != nil {
+		t.Fatalf("audit.Open() error = %v", err)
+	}
+	engine, _ := New(&config.Config{Actions: []config.Action{
+		{When: "any", Type: config.ActionCopy, Dir: filepath.Join(dir, "copies")},
+		{When: "any", Type: config.ActionCommand, Command: []string{"sh", "-c", "exit 3"}},
+	}})
+	msg := &Message{
+		Result: &sink.Result{AnalysisID: "a1", MessageID: "<1@example.com>", SourceFile: "stdin", Judgment: &llm.Judgment{Category: "Spam"}},
+		Raw:    []byte("Subject: Hi\n\n"),
+	}
+	engine.SetDryRun(io.Discard)
+	engine.Run(audit.WithLog(context.Background(), l), msg)
+	engine.SetDryRun(nil)
+	engine.Run(audit.WithLog(context.Background(), l), msg)
+	l.Close()
+
+	f, _ := os.Open(logPath)
+	defer f.Close()
+	entries, _ := audit.Read(f)
+	if len(entries) != 2 {
+		t.Fatalf("audit log = %+v, want the two actions taken, without the dry run", entries)
+	}
+	if e := entries[0]; e.AnalysisID != "a1" || e.MessageID != "<1@example.com>" || e.Action != config.ActionCopy ||
+		filepath.Dir(e.Target) != filepath.Join(dir, "copies") || e.Error != "" {
+		t.Errorf("copy entry = %+v", e)
+	}
+	if e := entries[1]; e.Action != config.ActionCommand || e.Target != "sh" || e.Error == "" {
+		t.Errorf("command entry = %+v", e)
+	}
+}
@@ -0,0 +1,224 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/netip"
+	"net/url"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/mta"
+	"mail-analyzer/sink"
+)
+
+// maxReportedURIs is the number of URLs of a message listed in an abuse report.
+const maxReportedURIs = 10
+
+// sendTimeout bounds the delivery of an abuse report.
+const sendTimeout = time.Minute
+
+// reportAbuse sends an ARF report of msg to the recipients of a, or else to the abuse
+// contact of the network that sent msg, and returns the recipients.
+func (e *Engine) reportAbuse(ctx context.Context, a *config.Action, msg *Message) ([]string, error) {
+	if len(msg.Raw) == 0 {
+		return nil, errors.New("no message to report")
+	}
+	original, err := mail.ReadMessage(bytes.NewReader(msg.Raw))
+	if err != nil {
+		return nil, fmt.Errorf("error reading the message: %w", err)
+	}
+	trusted, err := a.TrustedRelayPrefixes()
+	if err != nil {
+		return nil, err
+	}
+	sourceIP := email.SourceIP(original.Header["Received"], trusted)
+	to := a.To
+	if len(to) == 0 {
+		if !sourceIP.IsValid() {
+			return nil, errors.New("no abuse contact: the message has no public source address")
+		}
+		contact, err := abuseContact(ctx, e.httpClient, a.RDAPURL, sourceIP)
+		if err != nil {
+			return nil, err
+		}
+		to = []string{contact}
+	}
+
+	report := newFeedbackMessage(msg, original.Header, sourceIP)
+	if a.HeadersOnly {
+		report.Original, report.HeadersOnly = headerWithoutRecipients(msg.Raw), true
+	}
+	report.From = a.From
+	report.To = strings.Join(to, ", ")
+	deliverer := e.deliverer
+	if deliverer == nil {
+		deliverer = &mta.Sendmail{Path: "/usr/sbin/sendmail"}
+		if a.Relay != "" {
+			deliverer = &mta.SMTP{Address: a.Relay, Timeout: sendTimeout}
+		}
+	}
+	return to, deliverer.Deliver(ctx, a.From, to, report.Bytes())
+}
+
+// newFeedbackMessage returns the ARF report of msg, without its sender and recipients.
+func newFeedbackMessage(msg *Message, header mail.Header, sourceIP netip.Addr) *email.FeedbackMessage {
+	j := msg.Judgment
+	// Phishing is fraud, and anything else abuse, in the feedback types of RFC 5965.
+	feedbackType := "abuse"
+	if strings.Contains(strings.ToLower(j.Category), "phish") {
+		feedbackType = "fraud"
+	}
+	report := email.FeedbackReport{
+		FeedbackType:     feedbackType,
+		UserAgent:        "mail-analyzer/" + sink.Version,
+		OriginalMailFrom: strings.Trim(header.Get("Return-Path"), "<> "),
+		ReportedURI:      msg.URLs[:min(len(msg.URLs), maxReportedURIs)],
+	}
+	if !msg.ReceivedAt.IsZero() {
+		report.ArrivalDate = msg.ReceivedAt.Format(time.RFC1123Z)
+	}
+	if sourceIP.IsValid() {
+		report.SourceIP = sourceIP.String()
+	}
+	for _, from := range msg.From {
+		if addr, err := mail.ParseAddress(from); err == nil {
+			if _, domain, ok := strings.Cut(addr.Address, "@"); ok && !slices.Contains(report.ReportedDomain, domain) {
+				report.ReportedDomain = append(report.ReportedDomain, domain)
+			}
+		}
+	}
+
+	text := fmt.Sprintf("This is an abuse report for a message that we received, which was judged to be %s (confidence %.2f): %s\n",
+		j.Category, j.ConfidenceScore, j.Reason)
+	if sourceIP.IsValid() {
+		text += fmt.Sprintf("\nThe message was sent from %s, in your network.\n", sourceIP)
+	}
+	text += "\nThe message is attached.\n"
+	return &email.FeedbackMessage{
+		Subject:  "Abuse report: " + msg.Subject,
+		Date:     time.Now(),
+		Text:     text,
+		Report:   report,
+		Original: msg.Raw,
+	}
+}
+
+// recipientFields are the header fields that name the recipients of a message.
+var recipientFields = []string{
+	"To", "Cc", "Bcc", "Delivered-To", "X-Original-To", "Envelope-To", "X-Envelope-To", "Apparently-To",
+	"Resent-To", "Resent-Cc", "Resent-Bcc",
+}
+
+// receivedFor matches the "for" clause of a Received field, which names its recipient.
+var receivedFor = regexp.MustCompile(`(?i)\s+for\s+<?[^\s<>;]*@[^\s<>;]*>?`)
+
+// headerWithoutRecipients returns the header of raw without the fields that name its
+// recipients, and without the "for" clauses of its Received fields.
+func headerWithoutRecipients(raw []byte) []byte {
+	var out bytes.Buffer
+	skip := false
+	var received strings.Builder
+	flush := func() {
+		if received.Len() > 0 {
+			out.WriteString(receivedFor.ReplaceAllString(received.String(), ""))
+			received.Reset()
+		}
+	}
+	for _, line := range strings.SplitAfter(string(raw), "\n") {
+		if strings.TrimRight(line, "\r\n") == "" {
+			break
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			switch {
+			case skip:
+			case received.Len() > 0:
+				received.WriteString(line)
+			default:
+				out.WriteString(line)
+			}
+			continue
+		}
+		flush()
+		name, _, _ := strings.Cut(line, ":")
+		name = strings.TrimSpace(name)
+		skip = slices.ContainsFunc(recipientFields, func(f string) bool { return strings.EqualFold(f, name) })
+		switch {
+		case skip:
+		case strings.EqualFold(name, "Received"):
+			received.WriteString(line)
+		default:
+			out.WriteString(line)
+		}
+	}
+	flush()
+	return out.Bytes()
+}
+
+// abuseContact returns the e-mail address of the abuse contact of the network of ip,
+// from the RDAP service at baseURL (RFC 9082), which redirects to the service of the
+// registry of the network.
+func abuseContact(ctx context.Context, client *http.Client, baseURL string, ip netip.Addr) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(baseURL, "/")+"/ip/"+url.PathEscape(ip.String()), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/rdap+json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("error looking up the abuse contact of %s: %w", ip, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("error looking up the abuse contact of %s: RDAP status %s", ip, resp.Status)
+	}
+	var network rdapEntity
+	if err := json.NewDecoder(resp.Body).Decode(&network); err != nil {
+		return "", fmt.Errorf("error looking up the abuse contact of %s: %w", ip, err)
+	}
+	if contact := network.abuseEmail(); contact != "" {
+		return contact, nil
+	}
+	return "", fmt.Errorf("no abuse contact for %s in RDAP", ip)
+}
+
+// rdapEntity is an RDAP object, such as a network, with the entities related to it.
+type rdapEntity struct {
+	Roles []string `json:"roles"`
+	// VCardArray is a jCard (RFC 7095): ["vcard", [[name, parameters, type, value], ...]].
+	VCardArray []json.RawMessage `json:"vcardArray"`
+	Entities   []rdapEntity      `json:"entities"`
+}
+
+// abuseEmail returns the e-mail address of the first entity with the abuse role, among e
+// and the entities nested in it.
+func (e *rdapEntity) abuseEmail() string {
+	if slices.Contains(e.Roles, "abuse") {
+		if len(e.VCardArray) == 2 {
+			var properties [][]any
+			json.Unmarshal(e.VCardArray[1], &properties)
+			for _, p := range properties {
+				if len(p) == 4 && p[0] == "email" {
+					if address, ok := p[3].(string); ok && address != "" {
+						return address
+					}
+				}
+			}
+		}
+	}
+	for i := range e.Entities {
+		if address := e.Entities[i].abuseEmail(); address != "" {
+			return address
+		}
+	}
+	return ""
+}
@@ -0,0 +1,186 @@
+package action
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+	"mail-analyzer/sink"
+)
+
+// delivery is a message handed to a fakeDeliverer.
+type delivery struct {
+	from string
+	to   []string
+	msg  []byte
+}
+
+type fakeDeliverer struct {
+	deliveries []delivery
+}
+
+func (d *fakeDeliverer) Deliver(ctx context.Context, from string, to []string, msg []byte) error {
+	d.deliveries = append(d.deliveries, delivery{from, to, msg})
+	return nil
+}
+
+// rdapNetwork is the RDAP answer for a network whose abuse contact is an entity of its
+// registrant.
+const rdapNetwork = `{
+  "objectClassName": "ip network",
+  "entities": [{
+    "roles": ["registrant"],
+    "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["email", {}, "text", "noc@hoster.example"]]],
+    "entities": [{
+      "roles": ["abuse"],
+      "vcardArray": ["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "Abuse"], ["email", {"type": "work"}, "text", "abuse@hoster.example"]]]
+    }]
+  }]
+}`
+
+func TestEngine_RunARF(t *testing.T) {
+	var paths []string
+	rdap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		if r.URL.Path != "/ip/203.0.113.5" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(rdapNetwork))
+	}))
+	defer rdap.Close()
+
+	raw := "Received: from mx.corp.example ([10.1.1.1]) by mx2.corp.example\r\n" +
+		"Received: from mail.hoster.example (mail.hoster.example [203.0.113.5]) by mx.corp.example\r\n" +
+		"Return-Path: <bounce@evil.example>\r\nFrom: Bank <security@evil.example>\r\nSubject: Verify\r\n\r\nhttps://evil.example/login\r\n"
+	msg := &Message{
+		Result: &sink.Result{
+			Subject:  "Verify",
+			From:     []string{"Bank <security@evil.example>"},
+			URLs:     []string{"https://evil.example/login"},
+			Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 0.97},
+		},
+		Raw: []byte(raw),
+	}
+
+	tests := []struct {
+		name   string
+		action config.Action
+		wantTo []string
+	}{
+		{"Abuse contact", config.Action{When: "Phishing", Type: config.ActionARF, From: "reports@corp.example", RDAPURL: rdap.URL + "/"}, []string{"abuse@hoster.example"}},
+		{"Recipients", config.Action{When: "Phishing", Type: config.ActionARF, From: "reports@corp.example", To: []string{"cert@corp.example"}}, []string{"cert@corp.example"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			engine, err := New(&config.Config{Actions: []config.Action{tt.action}})
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			deliverer := &fakeDeliverer{}
+			engine.deliverer = deliverer
+			if err := engine.Run(context.Background(), msg); err != nil {
+				t.Fatalf("Run() error = %v", err)
+			}
+			if len(deliverer.deliveries) != 1 {
+				t.Fatalf("delivered %d reports, want 1", len(deliverer.deliveries))
+			}
+			d := deliverer.deliveries[0]
+			if d.from != "reports@corp.example" || strings.Join(d.to, ",") != strings.Join(tt.wantTo, ",") {
+				t.Errorf("delivered from %s to %v, want to %v", d.from, d.to, tt.wantTo)
+			}
+			report, original, err := email.ParseFeedbackReport(d.msg)
+			if err != nil || report == nil {
+				t.Fatalf("ParseFeedbackReport() = %v, %v", report, err)
+			}
+			if report.FeedbackType != "fraud" || report.SourceIP != "203.0.113.5" || report.OriginalMailFrom != "bounce@evil.example" ||
+				strings.Join(report.ReportedDomain, ",") != "evil.example" || strings.Join(report.ReportedURI, ",") != "https://evil.example/login" {
+				t.Errorf("report = %+v", report)
+			}
+			if !bytes.HasPrefix([]byte(raw), original) {
+				t.Errorf("reported message = %q, want the original", original)
+			}
+		})
+	}
+	if strings.Join(paths, ",") != "/ip/203.0.113.5" {
+		t.Errorf("RDAP requests = %v", paths)
+	}
+}
+
+func TestEngine_RunARFNoContact(t *testing.T) {
+	engine, _ := New(&config.Config{Actions: []config.Action{{When: "any", Type: config.ActionARF, From: "reports@corp.example", RDAPURL: "http://127.0.0.1:1"}}})
+	engine.deliverer = &fakeDeliverer{}
+	msg := &Message{
+		Result: &sink.Result{Judgment: &llm.Judgment{Category: "Spam"}},
+		Raw:    []byte("Received: from localhost ([127.0.0.1]) by mx.example\r\nSubject: Hi\r\n\r\n"),
+	}
+	if err := engine.Run(context.Background(), msg); err == nil || !strings.Contains(err.Error(), "no public source address") {
+		t.Errorf("Run() error = %v, want no abuse contact", err)
+	}
+}
+
+func TestEngine_RunARFTrustedRelay(t *testing.T) {
+	var paths []string
+	rdap := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.Path)
+		w.Write([]byte(rdapNetwork))
+	}))
+	defer rdap.Close()
+	engine, _ := New(&config.Config{Actions: []config.Action{{When: "any", Type: config.ActionARF, From: "reports@corp.example", RDAPURL: rdap.URL, TrustedRelays: []string{"192.0.2.0/24"}}}})
+	deliverer := &fakeDeliverer{}
+	engine.deliverer = deliverer
+	msg := &Message{
+		Result: &sink.Result{Judgment: &llm.Judgment{Category: "Spam"}},
+		Raw: []byte("Received: from filter.hosted.example ([192.0.2.20]) by mx.corp.example\r\n" +
+			"Received: from mail.hoster.example ([203.0.113.5]) by filter.hosted.example\r\nSubject: Hi\r\n\r\n"),
+	}
+	if err := engine.Run(context.Background(), msg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	// The hop from the hosted filter is skipped, and its network is not reported.
+	if strings.Join(paths, ",") != "/ip/203.0.113.5" || len(deliverer.deliveries) != 1 {
+		t.Errorf("RDAP requests = %v, deliveries = %d, want the network of the sender", paths, len(deliverer.deliveries))
+	}
+}
+
+func TestEngine_RunARFHeadersOnly(t *testing.T) {
+	raw := "Received: from mail.hoster.example (mail.hoster.example [203.0.113.5])\r\n" +
+		"\tby mx.corp.example with ESMTP id 1a2b\r\n\tfor <taro@corp.example>; Mon, 5 Oct 2026 10:00:00 +0000\r\n" +
+		"From: Bank <security@evil.example>\r\nTo: Taro <taro@corp.example>,\r\n Hanako <hanako@corp.example>\r\n" +
+		"Delivered-To: taro@corp.example\r\nSubject: Verify\r\n\r\nDear Taro, https://evil.example/login\r\n"
+	msg := &Message{
+		Result: &sink.Result{URLs: []string{"https://evil.example/login"}, Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.97}},
+		Raw:    []byte(raw),
+	}
+	engine, err := New(&config.Config{Actions: []config.Action{
+		{When: "Phishing", Type: config.ActionARF, From: "reports@corp.example", To: []string{"abuse@hoster.example"}, HeadersOnly: true},
+	}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	deliverer := &fakeDeliverer{}
+	engine.deliverer = deliverer
+	if err := engine.Run(context.Background(), msg); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+	report := string(deliverer.deliveries[0].msg)
+	if !strings.Contains(report, "Content-Type: text/rfc822-headers") {
+		t.Errorf("report = %q, want the header attached as text/rfc822-headers", report)
+	}
+	_, original, err := email.ParseFeedbackReport(deliverer.deliveries[0].msg)
+	if err != nil {
+		t.Fatalf("ParseFeedbackReport() error = %v", err)
+	}
+	want := "Received: from mail.hoster.example (mail.hoster.example [203.0.113.5])\r\n" +
+		"\tby mx.corp.example with ESMTP id 1a2b; Mon, 5 Oct 2026 10:00:00 +0000\r\n" +
+		"From: Bank <security@evil.example>\r\nSubject: Verify\r\n\r\n"
+	if string(original) != want {
+		t.Errorf("reported header = %q, want %q", original, want)
+	}
+}
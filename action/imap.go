@@ -0,0 +1,314 @@
+package action
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+)
+
+// IMAPMover moves messages, found by Message-ID, from the configured mailbox to the
+// junk mailbox. It uses the MOVE extension (RFC 6851) when the server supports it and
+// COPY, STORE \Deleted and UID EXPUNGE of the UIDPLUS extension (RFC 4315) otherwise,
+// so that only the moved messages are expunged. Servers with neither are refused.
+type IMAPMover struct {
+	address   string
+	startTLS  bool
+	tlsConfig *tls.Config
+	timeout   time.Duration
+
+	username, password string
+	mailbox, junk      string
+
+	// dial is replaced in tests.
+	dial func(ctx context.Context) (net.Conn, error)
+}
+
+// NewIMAPMover creates an IMAPMover from the imap_* settings in cfg.
+func NewIMAPMover(cfg *config.Config) (*IMAPMover, error) {
+	u, err := url.Parse(cfg.IMAPAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid IMAP address: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid IMAP address %q: expected imaps://host:port or imap://host:port", cfg.IMAPAddress)
+	}
+	m := &IMAPMover{
+		address:  u.Host,
+		timeout:  time.Duration(cfg.ConnectTimeout),
+		username: cfg.IMAPUsername,
+		password: cfg.IMAPPassword,
+		mailbox:  cfg.IMAPMailbox,
+		junk:     cfg.IMAPJunkMailbox,
+	}
+	switch u.Scheme {
+	case "imaps":
+		if u.Port() == "" {
+			m.address = net.JoinHostPort(u.Hostname(), "993")
+		}
+	case "imap":
+		m.startTLS = true
+		if u.Port() == "" {
+			m.address = net.JoinHostPort(u.Hostname(), "143")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported IMAP scheme %q", u.Scheme)
+	}
+	if m.tlsConfig, err = httpclient.TLSConfig(cfg); err != nil {
+		return nil, err
+	}
+	m.tlsConfig = m.tlsConfig.Clone()
+	m.tlsConfig.ServerName = u.Hostname()
+	m.dial = m.dialServer
+	return m, nil
+}
+
+func (m *IMAPMover) dialServer(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: m.timeout}
+	if m.startTLS {
+		return dialer.DialContext(ctx, "tcp", m.address)
+	}
+	return (&tls.Dialer{NetDialer: dialer, Config: m.tlsConfig}).DialContext(ctx, "tcp", m.address)
+}
+
+// MoveToJunk moves every message with the given Message-ID, with or without its angle
+// brackets, to the junk mailbox. It is an error if no such message exists.
+func (m *IMAPMover) MoveToJunk(ctx context.Context, messageID string) error {
+	if messageID = strings.Trim(messageID, "<> "); messageID == "" {
+		return errors.New("message has no Message-ID to search for")
+	}
+	messageID = "<" + messageID + ">"
+	conn, err := m.dial(ctx)
+	if err != nil {
+		return fmt.Errorf("imap: %w", err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	c := &imapConn{conn: conn, r: bufio.NewReader(conn)}
+	if err := m.session(c, messageID); err != nil {
+		return fmt.Errorf("imap: %w", err)
+	}
+	return nil
+}
+
+func (m *IMAPMover) session(c *imapConn, messageID string) error {
+	greeting, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(greeting, "* OK") {
+		return fmt.Errorf("unexpected greeting %q", greeting)
+	}
+	if m.startTLS {
+		if _, err := c.command("STARTTLS"); err != nil {
+			return err
+		}
+		tlsConn := tls.Client(c.conn, m.tlsConfig)
+		c.conn, c.r = tlsConn, bufio.NewReader(tlsConn)
+	}
+	if _, err := c.command("LOGIN " + quote(m.username) + " " + quote(m.password)); err != nil {
+		return err
+	}
+	defer c.command("LOGOUT")
+
+	untagged, err := c.command("CAPABILITY")
+	if err != nil {
+		return err
+	}
+	canMove := hasCapability(untagged, "MOVE")
+	canExpungeUIDs := hasCapability(untagged, "UIDPLUS")
+	if _, err := c.command("SELECT " + quote(m.mailbox)); err != nil {
+		return err
+	}
+
+	// SEARCH HEADER matches substrings, and the sender chooses the Message-ID: the
+	// header of each message found is checked before it is moved.
+	untagged, err = c.command("UID SEARCH HEADER Message-ID " + quote(messageID))
+	if err != nil {
+		return err
+	}
+	var found []string
+	for _, line := range untagged {
+		if rest, ok := strings.CutPrefix(line, "* SEARCH"); ok {
+			found = append(found, strings.Fields(rest)...)
+		}
+	}
+	var uids []string
+	if len(found) > 0 {
+		untagged, err = c.command("UID FETCH " + strings.Join(found, ",") + " (UID BODY.PEEK[HEADER.FIELDS (MESSAGE-ID)])")
+		if err != nil {
+			return err
+		}
+		uids = matchingUIDs(untagged, messageID)
+	}
+	if len(uids) == 0 {
+		return fmt.Errorf("message %s not found in %s", messageID, m.mailbox)
+	}
+	set := strings.Join(uids, ",")
+
+	if canMove {
+		_, err = c.command("UID MOVE " + set + " " + quote(m.junk))
+		return err
+	}
+	if !canExpungeUIDs {
+		return errors.New("the server supports neither MOVE nor UIDPLUS, so the message cannot be moved without expunging other deleted messages")
+	}
+	if _, err := c.command("UID COPY " + set + " " + quote(m.junk)); err != nil {
+		return err
+	}
+	if _, err := c.command(`UID STORE ` + set + ` +FLAGS.SILENT (\Deleted)`); err != nil {
+		return err
+	}
+	_, err = c.command("UID EXPUNGE " + set)
+	return err
+}
+
+var (
+	fetchUID     = regexp.MustCompile(`\bUID (\d+)`)
+	fetchLiteral = regexp.MustCompile(`\{(\d+)\}\r\n`)
+)
+
+// matchingUIDs returns the UIDs of the FETCH responses in untagged whose Message-ID
+// header is messageID.
+func matchingUIDs(untagged []string, messageID string) []string {
+	var uids []string
+	for _, line := range untagged {
+		if !strings.HasPrefix(line, "* ") || !strings.Contains(line, " FETCH ") {
+			continue
+		}
+		loc := fetchLiteral.FindStringSubmatchIndex(line)
+		if loc == nil {
+			continue
+		}
+		uid := fetchUID.FindStringSubmatch(line[:loc[0]])
+		if uid == nil {
+			uid = fetchUID.FindStringSubmatch(line[loc[1]:])
+		}
+		n, _ := strconv.Atoi(line[loc[2]:loc[3]])
+		if uid == nil || loc[1]+n > len(line) {
+			continue
+		}
+		header, err := textproto.NewReader(bufio.NewReader(strings.NewReader(line[loc[1] : loc[1]+n]))).ReadMIMEHeader()
+		if err != nil && !errors.Is(err, io.EOF) {
+			continue
+		}
+		for _, v := range header.Values("Message-Id") {
+			if strings.TrimSpace(v) == messageID {
+				uids = append(uids, uid[1])
+				break
+			}
+		}
+	}
+	return uids
+}
+
+// imapConn is a minimal IMAP4rev1 client connection. The literals of the responses are
+// kept in the lines, after their {n} and line break.
+type imapConn struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tag  int
+}
+
+// command sends cmd and returns the untagged responses. A tagged response other
+// than OK is returned as an error.
+func (c *imapConn) command(cmd string) ([]string, error) {
+	c.tag++
+	tag := fmt.Sprintf("A%03d", c.tag)
+	if _, err := fmt.Fprintf(c.conn, "%s %s\r\n", tag, cmd); err != nil {
+		return nil, err
+	}
+
+	var untagged []string
+	for {
+		line, err := c.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if rest, ok := strings.CutPrefix(line, tag+" "); ok {
+			if !strings.HasPrefix(rest, "OK") {
+				verb, _, _ := strings.Cut(cmd, " ")
+				return nil, fmt.Errorf("%s failed: %s", verb, rest)
+			}
+			return untagged, nil
+		}
+		untagged = append(untagged, line)
+	}
+}
+
+func (c *imapConn) readLine() (string, error) {
+	var b strings.Builder
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		b.WriteString(line)
+		n, ok := literalSize(line)
+		if !ok {
+			return b.String(), nil
+		}
+		if n > maxLiteral {
+			return "", fmt.Errorf("response literal of %d bytes is too large", n)
+		}
+		literal := make([]byte, n)
+		if _, err := io.ReadFull(c.r, literal); err != nil {
+			return "", err
+		}
+		b.WriteString("\r\n")
+		b.Write(literal)
+	}
+}
+
+// maxLiteral is the size of the largest literal read in a response, which is enough
+// for the headers that are fetched.
+const maxLiteral = 64 << 10
+
+// literalSize returns the size of the literal announced at the end of line, if any.
+func literalSize(line string) (int, bool) {
+	rest, ok := strings.CutSuffix(line, "}")
+	if !ok {
+		return 0, false
+	}
+	i := strings.LastIndexByte(rest, '{')
+	if i < 0 {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rest[i+1:])
+	return n, err == nil && n >= 0
+}
+
+// quote returns s as an IMAP quoted string.
+func quote(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\r", "", "\n", "").Replace(s)
+	return `"` + s + `"`
+}
+
+func hasCapability(untagged []string, name string) bool {
+	for _, line := range untagged {
+		if rest, ok := strings.CutPrefix(line, "* CAPABILITY "); ok {
+			for _, c := range strings.Fields(rest) {
+				if strings.EqualFold(c, name) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
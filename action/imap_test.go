@@ -0,0 +1,152 @@
+package action
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+// fakeIMAPServer answers commands on conn from a script of tagged replies keyed by
+// command verb and records the commands it received. The mailbox has a message with
+// each of messageIDs, whose UIDs are 7, 8 and so on, and searches find all of them.
+func fakeIMAPServer(conn net.Conn, capabilities string, messageIDs ...string) *[]string {
+	var commands []string
+	go func() {
+		defer conn.Close()
+		w := bufio.NewWriter(conn)
+		r := bufio.NewReader(conn)
+		w.WriteString("* OK IMAP4rev1 ready\r\n")
+		w.Flush()
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			tag, cmd, _ := strings.Cut(strings.TrimRight(line, "\r\n"), " ")
+			commands = append(commands, cmd)
+			switch {
+			case cmd == "CAPABILITY":
+				w.WriteString("* CAPABILITY IMAP4rev1 " + capabilities + "\r\n")
+			case strings.HasPrefix(cmd, "UID SEARCH"):
+				w.WriteString("* SEARCH")
+				for i := range messageIDs {
+					fmt.Fprintf(w, " %d", 7+i)
+				}
+				w.WriteString("\r\n")
+			case strings.HasPrefix(cmd, "UID FETCH"):
+				for i, id := range messageIDs {
+					header := "Message-ID: " + id + "\r\n\r\n"
+					fmt.Fprintf(w, "* %d FETCH (UID %d BODY[HEADER.FIELDS (MESSAGE-ID)] {%d}\r\n%s)\r\n", i+1, 7+i, len(header), header)
+				}
+			case strings.HasPrefix(cmd, "SELECT"):
+				w.WriteString("* 3 EXISTS\r\n")
+			}
+			w.WriteString(tag + " OK done\r\n")
+			w.Flush()
+			if cmd == "LOGOUT" {
+				return
+			}
+		}
+	}()
+	return &commands
+}
+
+func TestIMAPMover_MoveToJunk(t *testing.T) {
+	const fetch = "UID FETCH 7,8,9 (UID BODY.PEEK[HEADER.FIELDS (MESSAGE-ID)])"
+	tests := []struct {
+		name         string
+		capabilities string
+		messageIDs   []string
+		want         []string
+		wantErr      string
+	}{
+		{
+			name:         "MOVE extension",
+			capabilities: "MOVE UIDPLUS",
+			messageIDs:   []string{"<1@example.com>", "<other@example.com>", "<1@example.com>"},
+			want: []string{
+				`LOGIN "user" "p\"w"`, "CAPABILITY", `SELECT "INBOX"`,
+				`UID SEARCH HEADER Message-ID "<1@example.com>"`, fetch, `UID MOVE 7,9 "Junk"`, "LOGOUT",
+			},
+		},
+		{
+			name:         "Copy and UID expunge",
+			capabilities: "UIDPLUS",
+			messageIDs:   []string{"<1@example.com>", "<other@example.com>", "<1@example.com>"},
+			want: []string{
+				`LOGIN "user" "p\"w"`, "CAPABILITY", `SELECT "INBOX"`,
+				`UID SEARCH HEADER Message-ID "<1@example.com>"`, fetch, `UID COPY 7,9 "Junk"`,
+				`UID STORE 7,9 +FLAGS.SILENT (\Deleted)`, "UID EXPUNGE 7,9", "LOGOUT",
+			},
+		},
+		{
+			name:       "Neither MOVE nor UIDPLUS",
+			messageIDs: []string{"<1@example.com>"},
+			want: []string{
+				`LOGIN "user" "p\"w"`, "CAPABILITY", `SELECT "INBOX"`,
+				`UID SEARCH HEADER Message-ID "<1@example.com>"`,
+				"UID FETCH 7 (UID BODY.PEEK[HEADER.FIELDS (MESSAGE-ID)])", "LOGOUT",
+			},
+			wantErr: "neither MOVE nor UIDPLUS",
+		},
+		{
+			name:         "Substring matches only",
+			capabilities: "MOVE",
+			messageIDs:   []string{"<a1@example.com>", "<1@example.com.evil>"},
+			want: []string{
+				`LOGIN "user" "p\"w"`, "CAPABILITY", `SELECT "INBOX"`,
+				`UID SEARCH HEADER Message-ID "<1@example.com>"`,
+				"UID FETCH 7,8 (UID BODY.PEEK[HEADER.FIELDS (MESSAGE-ID)])", "LOGOUT",
+			},
+			wantErr: "not found",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mover, err := NewIMAPMover(&config.Config{
+				IMAPAddress: "imaps://mail.example.com", IMAPUsername: "user", IMAPPassword: `p"w`,
+				IMAPMailbox: "INBOX", IMAPJunkMailbox: "Junk",
+			})
+			if err != nil {
+				t.Fatalf("NewIMAPMover() error = %v", err)
+			}
+			if mover.address != "mail.example.com:993" {
+				t.Errorf("address = %q", mover.address)
+			}
+			client, server := net.Pipe()
+			commands := fakeIMAPServer(server, tt.capabilities, tt.messageIDs...)
+			mover.dial = func(context.Context) (net.Conn, error) { return client, nil }
+
+			// The parsed Message-ID has no angle brackets.
+			err = mover.MoveToJunk(context.Background(), "1@example.com")
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("MoveToJunk() error = %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Fatalf("MoveToJunk() error = %v, want %q", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*commands, tt.want) {
+				t.Errorf("commands =\n%q\nwant\n%q", *commands, tt.want)
+			}
+		})
+	}
+}
+
+func TestIMAPMover_MessageNotFound(t *testing.T) {
+	mover, _ := NewIMAPMover(&config.Config{IMAPAddress: "imaps://mail.example.com:1993", IMAPMailbox: "INBOX", IMAPJunkMailbox: "Junk"})
+	client, server := net.Pipe()
+	fakeIMAPServer(server, "MOVE")
+	mover.dial = func(context.Context) (net.Conn, error) { return client, nil }
+
+	err := mover.MoveToJunk(context.Background(), "<missing@example.com>")
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("MoveToJunk() error = %v, want not found", err)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// runAnalyze implements the "analyze" command, which analyzes a single message.
+func runAnalyze(args []string) error {
+	fs := newFlagSet("analyze", "[file.eml [config.json]]",
+		"Analyze one message. Without a file, the message is read from standard input, or,\n"+
+			"with --separator, a stream of messages is, each analyzed as soon as it is read.")
+	var pf pipelineFlags
+	pf.register(fs)
+	pf.registerDryRun(fs)
+	pf.registerTimeout(fs)
+	var of outputFlags
+	of.register(fs)
+	separator := fs.String("separator", "", "Read many messages from standard input, in mbox format (mbox) or separated by lines equal to `LINE`")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := of.check(); err != nil {
+		return err
+	}
+	switch fs.NArg() {
+	case 0, 1:
+	case 2:
+		// The configuration file was a positional argument before --config existed.
+		if pf.configPath != "" {
+			return usageErrorf("the configuration file is given both as an argument and with --config")
+		}
+		pf.configPath = fs.Arg(1)
+	default:
+		return usageErrorf("too many arguments")
+	}
+	if *separator != "" && fs.NArg() > 0 {
+		return usageErrorf("--separator only applies to messages read from standard input")
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+
+	var rawMessage []byte
+	sourceFile := "stdin" // Indicate source is stdin
+	if fs.NArg() == 0 && *separator == "" {
+		// Read from stdin if no file path is provided
+		log.Println("No EML file path provided. Reading from stdin...")
+		if rawMessage, err = io.ReadAll(os.Stdin); err != nil {
+			return fmt.Errorf("error reading from stdin: %w", err)
+		}
+	} else if fs.NArg() > 0 {
+		sourceFile = fs.Arg(0)
+		if rawMessage, err = os.ReadFile(sourceFile); err != nil {
+			return fmt.Errorf("error reading eml file: %w", err)
+		}
+	}
+
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	of.templates = p.templates
+	if cfg.Stream && isTerminal(os.Stderr) {
+		// Show live progress when a human is watching.
+		p.provider.OnStreamProgress(func(p llm.StreamProgress) {
+			fmt.Fprintf(os.Stderr, "\rReceiving analysis... %d tokens", p.Tokens)
+		})
+		defer fmt.Fprintln(os.Stderr)
+	}
+
+	ctx := context.Background()
+	if *separator != "" {
+		if *separator == "mbox" {
+			*separator = ""
+		}
+		return analyzeStream(ctx, p, &of, email.NewSplitter(os.Stdin, *separator), pf.dryRun)
+	}
+	result, err := p.analyze(ctx, rawMessage, sourceFile)
+	if pf.dryRun && errors.Is(err, llm.ErrDryRun) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	recordErr := p.record(ctx, result)
+
+	// The output file is only created once there is a result to write, so that a failed
+	// analysis leaves no temporary file behind.
+	out, err := of.open(sourceFile)
+	if err != nil {
+		return err
+	}
+	if err := out.Write(result); err != nil {
+		out.Abort()
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	// The result was still written, but report failed deliveries and actions. Actions run
+	// last, since moving the source file must not happen before the result is recorded.
+	closeErr := p.close()
+	return errors.Join(recordErr, closeErr, p.act(ctx, result))
+}
+
+// analyzeStream analyzes the messages read from standard input one at a time, and writes
+// each result as soon as it is available, as batch does for files. A message that cannot
+// be analyzed is reported on standard error and skipped.
+func analyzeStream(ctx context.Context, p *pipeline, of *outputFlags, messages *email.Splitter, dryRun bool) error {
+	var out *resultOutput
+	if !dryRun {
+		var err error
+		if out, err = of.open("stdin"); err != nil {
+			return err
+		}
+	}
+	var errs []error
+	n, failed := 0, 0
+	summary := newSummaryBuilder()
+	for {
+		rawMessage, err := messages.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("error reading from stdin: %w", err))
+			break
+		}
+		n++
+		if dryRun {
+			if n > 1 {
+				fmt.Println()
+			}
+			fmt.Printf("==> message %d <==\n", n)
+		}
+		result, err := p.analyze(ctx, rawMessage, "stdin")
+		if dryRun && errors.Is(err, llm.ErrDryRun) {
+			continue
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error analyzing message %d: %v\n", n, err)
+			failed++
+			summary.fail()
+			continue
+		}
+		summary.add(result)
+		if err := p.record(ctx, result); err != nil {
+			errs = append(errs, fmt.Errorf("message %d: %w", n, err))
+		}
+		if err := out.Write(result); err != nil {
+			out.Abort()
+			return fmt.Errorf("error writing output: %w", err)
+		}
+		if err := p.act(ctx, result); err != nil {
+			errs = append(errs, fmt.Errorf("message %d: %w", n, err))
+		}
+	}
+	if out != nil {
+		summary.setUsage(p.provider.Usage())
+		out.SetSummary(summary.build())
+		if err := out.Close(); err != nil {
+			return err
+		}
+	}
+
+	errs = append(errs, p.close())
+	if failed > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d messages could not be analyzed", failed, n))
+	}
+	return errors.Join(errs...)
+}
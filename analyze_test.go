@@ -0,0 +1,384 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/dkim"
+	"mail-analyzer/email"
+	"mail-analyzer/hook"
+	"mail-analyzer/llm"
+	"mail-analyzer/plugin"
+)
+
+func TestAnalyzeStream(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	input := "From alice@example.com Mon Jul  7 10:00:00 2025\nMessage-ID: <1@example.com>\nSubject: One\n\nHello\n\n" +
+		"From bob@example.com Mon Jul  7 11:00:00 2025\nMessage-ID: <2@example.com>\nSubject: Two\n\nBye\n"
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	of := outputFlags{format: FormatJSONL, path: path}
+	if err := analyzeStream(context.Background(), p, &of, email.NewSplitter(strings.NewReader(input), ""), false); err != nil {
+		t.Fatalf("analyzeStream() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("output = %q, want 2 results", data)
+	}
+	for i, line := range lines {
+		var record struct {
+			SourceFile string `json:"source_file"`
+			MessageID  string `json:"message_id"`
+		}
+		if err := json.Unmarshal([]byte(line), &record); err != nil {
+			t.Fatal(err)
+		}
+		if want := []string{"1@example.com", "2@example.com"}[i]; record.MessageID != want || record.SourceFile != "stdin" {
+			t.Errorf("result %d = %+v, want %s from stdin", i, record, want)
+		}
+	}
+}
+
+// fakePlugin judges every message with its judgment, or fails with its error.
+type fakePlugin struct {
+	judgment *llm.Judgment
+	err      error
+}
+
+func (fakePlugin) Name() string { return "fake" }
+
+func (f fakePlugin) Analyze(ctx context.Context, e *email.ParsedEmail) (*llm.Judgment, error) {
+	return f.judgment, f.err
+}
+
+func TestPipelinePlugins(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	raw := []byte("Message-ID: <1@example.com>\r\nSubject: Verify\r\n\r\nHello\r\n")
+
+	p.plugins = plugin.Set{fakePlugin{judgment: &llm.Judgment{Category: "Safe", Reason: "Known sender.", ConfidenceScore: 0.8}}}
+	result, err := p.analyze(context.Background(), raw, "stdin")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if result.Judgment.Reason != "Fake login." || len(result.Plugins) != 1 || result.Plugins[0].Name != "fake" || result.Plugins[0].Judgment.Reason != "Known sender." {
+		t.Errorf("judgment = %+v, plugins = %+v, want both verdicts", result.Judgment, result.Plugins)
+	}
+
+	// A failing plugin does not fail the analysis.
+	p.plugins = plugin.Set{fakePlugin{err: errors.New("model not loaded")}}
+	result, err = p.analyze(context.Background(), raw, "stdin")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if result.Judgment.Reason != "Fake login." || len(result.Plugins) != 1 || result.Plugins[0].Error != "model not loaded" {
+		t.Errorf("judgment = %+v, plugins = %+v, want the error of the plugin", result.Judgment, result.Plugins)
+	}
+}
+
+func TestPipelineHooks(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	p.hooks = hook.Chain{hook.Funcs{
+		Before: func(ctx context.Context, e *email.ParsedEmail) error {
+			e.Subject = "[Enriched] " + e.Subject
+			return nil
+		},
+		After: func(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error {
+			j.Reason += " Seen in " + e.Subject + "."
+			return nil
+		},
+	}}
+	result, err := p.analyze(context.Background(), []byte("Message-ID: <1@example.com>\r\nSubject: Verify\r\n\r\nHello\r\n"), "stdin")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if result.Subject != "[Enriched] Verify" || result.Judgment.Reason != "Fake login. Seen in [Enriched] Verify." {
+		t.Errorf("result = %+v, judgment = %+v", result, result.Judgment)
+	}
+
+	p.hooks = hook.Chain{hook.Funcs{After: func(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error {
+		return errors.New("reputation service unavailable")
+	}}}
+	if _, err := p.analyze(context.Background(), []byte("Subject: Verify\r\n\r\nHello\r\n"), "stdin"); err == nil || !strings.Contains(err.Error(), "reputation service unavailable") {
+		t.Errorf("analyze() error = %v, want the error of the hook", err)
+	}
+}
+
+func TestPipeline_SenderHistory(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{dbPath: filepath.Join(t.TempDir(), "results.sqlite")})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	ctx := context.Background()
+	raw := []byte("From: Billing <billing@vendor.example>\r\nSubject: Invoice\r\n\r\nPay now.\r\n")
+
+	var signals [][]string
+	for range 2 {
+		result, err := p.analyze(ctx, raw, "invoice.eml")
+		if err != nil {
+			t.Fatalf("analyze() error = %v", err)
+		}
+		if err := p.record(ctx, result); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+		var names []string
+		for _, r := range result.Enrichments {
+			if r.Name == "history" {
+				for _, s := range r.Signals {
+					names = append(names, s.Name+"="+s.Value)
+				}
+			}
+		}
+		signals = append(signals, names)
+	}
+	want := [][]string{
+		{"first_time_sender=true"},
+		{"first_time_sender=false", "previously_flagged_sender=1"},
+	}
+	if !reflect.DeepEqual(signals, want) {
+		t.Errorf("history signals = %v, want %v", signals, want)
+	}
+}
+
+func TestPipeline_RelatedMessages(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", Enrichments: []string{"related"}},
+		&pipelineFlags{dbPath: filepath.Join(t.TempDir(), "results.sqlite")})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	ctx := context.Background()
+	first, err := p.analyze(ctx, []byte("Message-ID: <1@vendor.example>\r\nSubject: Invoice\r\n\r\nPay now.\r\n"), "1.eml")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if err := p.record(ctx, first); err != nil {
+		t.Fatalf("record() error = %v", err)
+	}
+
+	reply := []byte("Message-ID: <2@vendor.example>\r\nIn-Reply-To: <1@vendor.example>\r\nSubject: Re: Invoice\r\n\r\nReminder.\r\n")
+	if _, err := p.analyze(ctx, reply, "2.eml"); err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	requests := llmServer.Requests()
+	prompt := requests[len(requests)-1].Messages[len(requests[len(requests)-1].Messages)-1].Content
+	if !strings.Contains(prompt, "--- Related Messages ---\n- The message 1@vendor.example, an earlier message of this thread,") ||
+		!strings.Contains(prompt, "was judged Phishing (confidence 0.90): Fake login.") {
+		t.Errorf("prompt of the reply = %q, want the verdict of the earlier message", prompt)
+	}
+}
+
+func TestPipeline_RelatedMessagesOfTenants(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	dir := t.TempDir()
+	cfg := &config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", Enrichments: []string{"related"},
+		Policies: []config.Policy{{Tenant: "acme", Domains: []string{"acme.example"}, ResultsDB: filepath.Join(dir, "acme.sqlite")}}}
+	p, err := newPipeline(cfg, &pipelineFlags{dbPath: filepath.Join(dir, "results.sqlite")})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	ctx := context.Background()
+	relatedPrompt := func(raw string) bool {
+		t.Helper()
+		result, err := p.analyze(ctx, []byte(raw), "")
+		if err != nil {
+			t.Fatalf("analyze() error = %v", err)
+		}
+		if err := p.record(ctx, result); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+		requests := llmServer.Requests()
+		prompt := requests[len(requests)-1].Messages[len(requests[len(requests)-1].Messages)-1].Content
+		return strings.Contains(prompt, "--- Related Messages ---")
+	}
+
+	relatedPrompt("Message-ID: <1@vendor.example>\r\nTo: user@other.example\r\nSubject: Invoice\r\n\r\nPay now.\r\n")
+	// The message of another tenant is in the shared database, which acme does not see.
+	if relatedPrompt("Message-ID: <2@vendor.example>\r\nIn-Reply-To: <1@vendor.example>\r\nTo: user@acme.example\r\nSubject: Re: Invoice\r\n\r\nReminder.\r\n") {
+		t.Error("the prompt of acme has the related messages of the shared database")
+	}
+	if !relatedPrompt("Message-ID: <3@vendor.example>\r\nIn-Reply-To: <2@vendor.example>\r\nTo: user@acme.example\r\nSubject: Re: Invoice\r\n\r\nLast reminder.\r\n") {
+		t.Error("the prompt of acme lacks the related messages of its own database")
+	}
+}
+
+func TestPipeline_AttachmentHashes(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	raw := []byte("Subject: Invoice\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee the attachments.\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice.pdf\r\nContent-Transfer-Encoding: base64\r\n\r\ndGVzdA==\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=copy.pdf\r\n\r\ntest\r\n--b--\r\n")
+	result, err := p.analyze(context.Background(), raw, "invoice.eml")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	// Both attachments contain "test".
+	if want := []string{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}; !reflect.DeepEqual(p.sinkResult(result).Hashes, want) {
+		t.Errorf("Hashes = %v, want %v", p.sinkResult(result).Hashes, want)
+	}
+}
+
+func TestPipeline_AttachmentText(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	cfg := &config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", AttachmentText: true, AttachmentTextMaxBytes: 2000,
+		AttachmentConverters: []config.AttachmentConverter{{Types: []string{".txt"}, Command: []string{"/bin/sh", "-c", "tr a-z A-Z"}, MaxBytes: 10}}}
+	p, err := newPipeline(cfg, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	raw := []byte("Subject: Voicemail\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee the attachments.\r\n" +
+		"--b\r\nContent-Type: text/html\r\nContent-Disposition: attachment; filename=voicemail.htm\r\n\r\n" +
+		"<script>alert(1)</script><p>Sign in to play your message</p>\r\n" +
+		"--b\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=notes.txt\r\n\r\ncall back before noon\r\n--b--\r\n")
+	if _, err := p.analyze(context.Background(), raw, "voicemail.eml"); err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	want := "--- Attachment Text ---\nThe following text was extracted from the attachments of the message:\n\n" +
+		"[voicemail.htm (text/html)]\nSign in to play your message\n\n[notes.txt (text/plain)]\nCALL BACK\n... (truncated)\n"
+	if prompt := llmServer.Requests()[0].Messages[1].Content; !strings.Contains(prompt, want) {
+		t.Errorf("prompt = %q, want the text of the attachments", prompt)
+	}
+}
+
+func TestPipeline_ImageText(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	cfg := &config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", OCRCommand: []string{"/bin/sh", "-c", "echo Your mailbox is full; cat"}}
+	p, err := newPipeline(cfg, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	raw := []byte("Subject: Mailbox\r\nContent-Type: multipart/related; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<img src=\"cid:notice\">\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Disposition: inline; filename=notice.png\r\nContent-Transfer-Encoding: base64\r\n\r\naHR0cHM6Ly9tYWlsLmV4YW1wbGUubmV0Lw==\r\n--b--\r\n")
+	if _, err := p.analyze(context.Background(), raw, "mailbox.eml"); err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	want := "--- Text in Images ---\nThe following text was recognized in the images of the message:\n\n" +
+		"[notice.png (image/png)]\nYour mailbox is full\nhttps://mail.example.net/\n"
+	if prompt := llmServer.Requests()[0].Messages[1].Content; !strings.Contains(prompt, want) {
+		t.Errorf("prompt = %q, want the text of the image", prompt)
+	}
+
+	// The images of messages judged by their header alone are not read.
+	p.headersOnly = true
+	if _, err := p.analyze(context.Background(), raw, "mailbox.eml"); err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if prompt := llmServer.Requests()[1].Messages[1].Content; strings.Contains(prompt, "Your mailbox is full") {
+		t.Errorf("header-only prompt = %q, want no text of the image", prompt)
+	}
+}
+
+func TestPipeline_HeadersOnly(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	cfg := &config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", ModelName: "large-model", HeadersOnlyModel: "small-model"}
+	p, err := newPipeline(cfg, &pipelineFlags{headersOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	raw := []byte("From: <alerts@example.org>\r\nSubject: Account locked\r\n\r\nSign in at https://bank.example.org/login to unlock your account.\r\n")
+	result, err := p.analyze(context.Background(), raw, "locked.eml")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if result.Model != "small-model" {
+		t.Errorf("Model = %q, want small-model", result.Model)
+	}
+	requests := llmServer.Requests()
+	if len(requests) != 1 || requests[0].Model != "small-model" {
+		t.Fatalf("requests = %+v, want one to small-model", requests)
+	}
+	if prompt := requests[0].Messages[1].Content; strings.Contains(prompt, "unlock your account") || !strings.Contains(prompt, "--- From Anomalies ---") {
+		t.Errorf("prompt = %q, want the header-only prompt", prompt)
+	}
+}
+
+func TestPipeline_HeaderBlock(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	defer p.close()
+	raw := []byte("\n   Received: from [203.0.113.7] by mx.example.net\n   Authentication-Results: mx.example.net; spf=fail smtp.mailfrom=example.org\n   From: <alerts@example.org>\n   Subject: Account locked\n")
+	result, err := p.analyze(context.Background(), raw, "headers.txt")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if result.Subject != "Account locked" || !reflect.DeepEqual(result.Warnings, []string{"the message has no body, so only its header was analyzed"}) {
+		t.Errorf("result = %+v", result)
+	}
+	if prompt := llmServer.Requests()[0].Messages[1].Content; !strings.Contains(prompt, "Authentication-Results: mx.example.net; spf=fail") || !strings.Contains(prompt, "--- Route (most recent hop first) ---") {
+		t.Errorf("prompt = %q, want the header-only prompt", prompt)
+	}
+}
+
+func TestPipelineSampleIntegrity(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	// The body hash of the signature is that of "Pay the invoice.", so the signature
+	// fails before its key is looked up.
+	bodyHash := sha256.Sum256([]byte("Pay the invoice.\r\n"))
+	sample := "DKIM-Signature: v=1; a=rsa-sha256; d=example.net; s=mail; h=from:subject;\r\n" +
+		" bh=" + base64.StdEncoding.EncodeToString(bodyHash[:]) + "; b=c2lnbmF0dXJl\r\n" +
+		"From: <billing@example.net>\r\nSubject: Invoice\r\n\r\nPay the invoice at http://evil.example.com.\r\n"
+	forwarded := "Message-ID: <fwd@example.org>\r\nSubject: Fwd: Invoice\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nIs this real?\r\n" +
+		"--b\r\nContent-Type: message/rfc822\r\n\r\n" + sample + "--b--\r\n"
+
+	result, err := p.analyze(context.Background(), []byte(forwarded), "stdin")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if got := result.SampleIntegrity; got == nil || got.Status != dkim.StatusBodyModified || len(got.Signatures) != 1 || got.Signatures[0].Domain != "example.net" {
+		t.Errorf("sample_integrity = %+v, want body_modified", got)
+	}
+
+	result, err = p.analyze(context.Background(), []byte(sample), "stdin")
+	if err != nil {
+		t.Fatalf("analyze() error = %v", err)
+	}
+	if result.SampleIntegrity != nil {
+		t.Errorf("sample_integrity of a message that is not a sample = %+v, want none", result.SampleIntegrity)
+	}
+}
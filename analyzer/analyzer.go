@@ -3,9 +3,11 @@ package analyzer
 import (
 	"context"
 	"fmt"
+	"log"
 	"strings"
 
 	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
 	"mail-analyzer/llm"
 )
 
@@ -14,9 +16,18 @@ type LLMProvider interface {
 	AnalyzeText(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error)
 }
 
+// MultimodalProvider is implemented by providers that can also accept images alongside the prompt.
+type MultimodalProvider interface {
+	AnalyzeContent(ctx context.Context, parts []llm.ContentPart, tools []llm.APITool, toolChoice string) (*llm.Judgment, error)
+}
+
 // EmailAnalyzer is responsible for analyzing emails.
 type EmailAnalyzer struct {
-	provider LLMProvider
+	provider  LLMProvider
+	maxImages int
+	prefilter *Prefilter
+	templates *Templates
+	enrichers enrichment.Pipeline
 }
 
 // NewEmailAnalyzer creates a new EmailAnalyzer.
@@ -24,24 +35,158 @@ func NewEmailAnalyzer(provider LLMProvider) *EmailAnalyzer {
 	return &EmailAnalyzer{provider: provider}
 }
 
-// Analyze performs the analysis of a single email.
-func (a *EmailAnalyzer) Analyze(ctx context.Context, email *email.ParsedEmail) (*llm.Judgment, error) {
-	prompt := buildPrompt(email)
-	tool := getAnalysisTool()
-	return a.provider.AnalyzeText(ctx, prompt, []llm.APITool{tool}, "auto")
+// SetMaxImages sets how many images from an email are submitted to the model.
+// Images are only sent when the provider implements MultimodalProvider. Zero disables images.
+func (a *EmailAnalyzer) SetMaxImages(n int) {
+	a.maxImages = n
+}
+
+// SetTemplates makes the analyzer build the user prompt from the templates of t, if the
+// directory has one.
+func (a *EmailAnalyzer) SetTemplates(t *Templates) {
+	a.templates = t
+}
+
+// SetEnrichers sets the enrichers whose facts are added to the user prompt.
+func (a *EmailAnalyzer) SetEnrichers(p enrichment.Pipeline) {
+	a.enrichers = p
+}
+
+// Enrich returns the facts that the enrichers selected by opts found about email. The
+// names of opts.Enrichments that are not those of enrichers are ignored; CheckOptions
+// reports them.
+func (a *EmailAnalyzer) Enrich(ctx context.Context, email *email.ParsedEmail, opts *AnalysisOptions) []enrichment.Result {
+	enrichers := a.enrichers
+	if opts != nil && opts.Enrichments != nil {
+		enrichers = enrichment.Pipeline{}
+		for _, name := range opts.Enrichments {
+			if selected, err := a.enrichers.Select([]string{name}); err == nil {
+				enrichers = append(enrichers, selected...)
+			}
+		}
+	}
+	return enrichers.Run(ctx, email)
+}
+
+// Analyze performs the analysis of a single email, with the settings of opts, which may
+// be nil.
+func (a *EmailAnalyzer) Analyze(ctx context.Context, email *email.ParsedEmail, opts *AnalysisOptions) (*llm.Judgment, error) {
+	if err := a.CheckOptions(opts); err != nil {
+		return nil, err
+	}
+	return a.AnalyzeEnriched(ctx, email, a.Enrich(ctx, email, opts), opts)
+}
+
+// AnalyzeEnriched analyzes email with the facts returned by Enrich.
+func (a *EmailAnalyzer) AnalyzeEnriched(ctx context.Context, email *email.ParsedEmail, enrichments []enrichment.Result, opts *AnalysisOptions) (*llm.Judgment, error) {
+	if err := a.CheckOptions(opts); err != nil {
+		return nil, err
+	}
+	templates := a.templates
+	if opts != nil && opts.Templates != nil {
+		templates = opts.Templates
+		ctx = llm.WithSystemPrompt(ctx, templates.SystemPrompt())
+	}
+	var prompt string
+	if opts.headersOnly() {
+		prompt = buildHeadersPrompt(email, enrichments, opts)
+	} else if templates != nil {
+		var err error
+		if prompt, err = templates.current().prompt(email, enrichments, opts); err != nil {
+			return nil, err
+		}
+	} else {
+		prompt = buildPrompt(email, enrichments, opts)
+	}
+	tool := analysisTool(opts)
+
+	var vector []float64
+	if a.prefilter != nil && !opts.changesJudgment() {
+		match, score, v, err := a.prefilter.lookup(ctx, email)
+		if err != nil {
+			// The pre-filter is an optimization; fall back to a full analysis.
+			log.Printf("ERROR: pre-filter lookup failed: %v", err)
+		}
+		vector = v
+		if match != nil {
+			if a.prefilter.Mode == PrefilterSkip && match.Judgment.IsSuspicious {
+				return reusedJudgment(match, score), nil
+			}
+			prompt = seedPrompt(prompt, match, score)
+		}
+	}
+
+	var judgment *llm.Judgment
+	var err error
+	if mp, ok := a.provider.(MultimodalProvider); ok && a.maxImages > 0 && len(email.Images) > 0 && !opts.headersOnly() {
+		judgment, err = mp.AnalyzeContent(ctx, buildContentParts(prompt, email.Images, a.maxImages), []llm.APITool{tool}, "auto")
+	} else {
+		judgment, err = a.provider.AnalyzeText(ctx, prompt, []llm.APITool{tool}, "auto")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !opts.needsContext() {
+		judgment.NeedsContext = nil
+	} else if len(judgment.NeedsContext) > maxQuestions {
+		judgment.NeedsContext = judgment.NeedsContext[:maxQuestions]
+	}
+
+	if vector != nil {
+		a.prefilter.remember(email, vector, judgment)
+	}
+	return judgment, nil
+}
+
+// buildContentParts combines the text prompt with up to max images from the email.
+func buildContentParts(prompt string, images []email.Image, max int) []llm.ContentPart {
+	if len(images) > max {
+		images = images[:max]
+	}
+
+	var names []string
+	for i, img := range images {
+		name := img.Filename
+		if name == "" {
+			name = fmt.Sprintf("image %d", i+1)
+		}
+		names = append(names, fmt.Sprintf("%s (%s)", name, img.ContentType))
+	}
+
+	parts := []llm.ContentPart{
+		llm.TextPart(prompt),
+		llm.TextPart(fmt.Sprintf("--- Attached Images ---\nThe email contains the following images: %s. Consider any text, logos, or QR codes they show as part of your analysis.", strings.Join(names, ", "))),
+	}
+	for _, img := range images {
+		parts = append(parts, llm.ImageDataPart(img.ContentType, img.Data))
+	}
+	return parts
+}
+
+// Prompt returns the text prompt that Analyze sends for email, before any pre-filter hint.
+func Prompt(email *email.ParsedEmail) string {
+	return buildPrompt(email, nil, nil)
 }
 
-func buildPrompt(email *email.ParsedEmail) string {
+// maxPromptBody is the length in bytes beyond which the body of a message is truncated
+// in the prompt.
+const maxPromptBody = 4000
+
+func buildPrompt(email *email.ParsedEmail, enrichments []enrichment.Result, opts *AnalysisOptions) string {
 	var promptBuilder strings.Builder
-	promptBuilder.WriteString("Please analyze the following email and determine if it is safe, spam, or phishing.\n\n")
+	if opts == nil || len(opts.Categories) == 0 {
+		promptBuilder.WriteString("Please analyze the following email and determine if it is safe, spam, or phishing.\n\n")
+	} else {
+		promptBuilder.WriteString(fmt.Sprintf("Please analyze the following email and determine which of these categories it belongs to: %s.\n\n", strings.Join(opts.Categories, ", ")))
+	}
 	promptBuilder.WriteString("--- Email Headers ---\n")
 	if len(email.From) > 0 {
-		promptBuilder.WriteString(fmt.Sprintf("From: %s\n", email.From[0].String()))
+		promptBuilder.WriteString(fmt.Sprintf("From: %s\n", formatAddress(email.From[0])))
 	}
 	if len(email.To) > 0 {
 		var toAddresses []string
 		for _, addr := range email.To {
-			toAddresses = append(toAddresses, addr.String())
+			toAddresses = append(toAddresses, formatAddress(addr))
 		}
 		promptBuilder.WriteString(fmt.Sprintf("To: %s\n", strings.Join(toAddresses, ", ")))
 	}
@@ -52,17 +197,13 @@ func buildPrompt(email *email.ParsedEmail) string {
 	if replyTo, err := email.Header.AddressList("Reply-To"); err == nil {
 		var replyToAddresses []string
 		for _, addr := range replyTo {
-			replyToAddresses = append(replyToAddresses, addr.String())
+			replyToAddresses = append(replyToAddresses, formatAddress(addr))
 		}
 		promptBuilder.WriteString(fmt.Sprintf("Reply-To: %s\n", strings.Join(replyToAddresses, ", ")))
 	}
 
 	promptBuilder.WriteString("\n--- Email Body ---\n")
-	body := email.Body
-	if len(body) > 4000 { // Truncate long bodies
-		body = body[:4000] + "\n... (truncated)"
-	}
-	promptBuilder.WriteString(body)
+	promptBuilder.WriteString(truncateBody(email.Body, opts.maxBody()))
 
 	promptBuilder.WriteString("\n\n--- Extracted URLs---\n")
 	if len(email.URLs) > 0 {
@@ -72,29 +213,89 @@ func buildPrompt(email *email.ParsedEmail) string {
 	} else {
 		promptBuilder.WriteString("No URLs found.\n")
 	}
+	if section := attachmentPrompt(email.AttachmentTexts); section != "" {
+		promptBuilder.WriteString("\n" + section)
+	}
+	if section := imagePrompt(email.ImageTexts); section != "" {
+		promptBuilder.WriteString("\n" + section)
+	}
+	if len(email.Warnings) > 0 {
+		promptBuilder.WriteString("\n--- Parsing Limits ---\n")
+		promptBuilder.WriteString("The message was too large or complex to be read in full, so it is only partly shown above:\n")
+		for _, w := range email.Warnings {
+			promptBuilder.WriteString("- " + w + "\n")
+		}
+	}
+	if sections := enrichment.Prompt(enrichments); sections != "" {
+		promptBuilder.WriteString("\n" + sections)
+	}
+	if section := contextPrompt(opts.answers()); section != "" {
+		promptBuilder.WriteString("\n" + section)
+	}
 
 	promptBuilder.WriteString("\n--- Analysis Instructions---\n")
 	promptBuilder.WriteString("Based on all the information above, call the 'report_analysis_result' function with your conclusion.")
+	promptBuilder.WriteString(contextInstructions(opts))
+	if language := opts.language(); language != "" {
+		promptBuilder.WriteString(fmt.Sprintf(" Write the reason in %s.", language))
+	}
 
 	return promptBuilder.String()
 }
 
-func getAnalysisTool() llm.APITool {
+// attachmentPrompt returns the section of the prompt with the text extracted from the
+// attachments, or "" if there is none.
+func attachmentPrompt(texts []email.AttachmentText) string {
+	return textPrompt("Attachment Text", "The following text was extracted from the attachments of the message:", texts)
+}
+
+// imagePrompt returns the section of the prompt with the text recognized in the images,
+// or "" if there is none.
+func imagePrompt(texts []email.AttachmentText) string {
+	return textPrompt("Text in Images", "The following text was recognized in the images of the message:", texts)
+}
+
+// textPrompt returns a section of the prompt with texts, each after the name and type
+// of its file, or "" if there are none.
+func textPrompt(title, intro string, texts []email.AttachmentText) string {
+	if len(texts) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s ---\n%s\n", title, intro)
+	for _, t := range texts {
+		fmt.Fprintf(&b, "\n[%s (%s)]\n%s\n", t.Filename, t.ContentType, t.Text)
+		if t.Truncated {
+			b.WriteString("... (truncated)\n")
+		}
+	}
+	return b.String()
+}
+
+// AnalysisTool returns the tool that the model calls to report its judgment.
+func AnalysisTool() llm.APITool {
+	return analysisTool(nil)
+}
+
+// analysisTool returns the tool for the categories and language of opts. Its parameters
+// are the schema of llm.Judgment, without needs_context unless opts let the model ask
+// for context.
+func analysisTool(opts *AnalysisOptions) llm.APITool {
+	params := llm.SchemaFor[llm.Judgment]()
+	params.Property("category").Enum = opts.categories()
+	if !opts.needsContext() {
+		delete(params.Properties, "needs_context")
+	}
+	if language := opts.language(); language != "" {
+		reason := params.Property("reason")
+		reason.Description = fmt.Sprintf("%s, in %s.", strings.TrimSuffix(reason.Description, "."), language)
+	}
 	return llm.APITool{
 		Type: "function",
 		Function: llm.APIFunctionDef{
 			Name:        "report_analysis_result",
 			Description: "Reports the analysis result of an email.",
-			Parameters: map[string]any{
-				"type": "object",
-				"properties": map[string]any{
-					"is_suspicious":     map[string]any{"type": "boolean", "description": "Whether the email is suspicious (phishing, spam, etc.)."},
-					"category":          map[string]any{"type": "string", "enum": []string{"Phishing", "Spam", "Safe"}, "description": "The category of the email."},
-					"reason":            map[string]any{"type": "string", "description": "A brief explanation for the judgment."}, 
-					"confidence_score":  map[string]any{"type": "number", "description": "Confidence score of the analysis from 0.0 to 1.0."},
-				},
-				"required": []string{"is_suspicious", "category", "reason", "confidence_score"},
-			},
+			Parameters:  params,
 		},
 	}
 }
\ No newline at end of file
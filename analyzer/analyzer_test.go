@@ -3,12 +3,14 @@ package analyzer
 import (
 	"context"
 	"errors"
+	"fmt"
 	"reflect"
 	"strings"
 	"testing"
 
 	"github.com/emersion/go-message/mail"
 	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
 	"mail-analyzer/llm"
 )
 
@@ -82,7 +84,7 @@ func TestEmailAnalyzer_Analyze(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			analyzer := NewEmailAnalyzer(tt.provider)
-			got, err := analyzer.Analyze(context.Background(), tt.parsedEmail)
+			got, err := analyzer.Analyze(context.Background(), tt.parsedEmail, nil)
 
 			if (err != nil) != tt.wantErr {
 				t.Errorf("EmailAnalyzer.Analyze() error = %v, wantErr %v", err, tt.wantErr)
@@ -93,4 +95,120 @@ func TestEmailAnalyzer_Analyze(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+// MockMultimodalProvider additionally implements MultimodalProvider.
+type MockMultimodalProvider struct {
+	MockLLMProvider
+	AnalyzeContentFunc func(ctx context.Context, parts []llm.ContentPart, tools []llm.APITool, toolChoice string) (*llm.Judgment, error)
+}
+
+func (m *MockMultimodalProvider) AnalyzeContent(ctx context.Context, parts []llm.ContentPart, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+	return m.AnalyzeContentFunc(ctx, parts, tools, toolChoice)
+}
+
+func TestEmailAnalyzer_Analyze_Images(t *testing.T) {
+	parsedEmail := &email.ParsedEmail{
+		Subject: "Scan to pay",
+		Header:  mail.Header{},
+		Images: []email.Image{
+			{Filename: "qr.png", ContentType: "image/png", Data: []byte("one")},
+			{Filename: "logo.png", ContentType: "image/png", Data: []byte("two")},
+		},
+	}
+	judgment := &llm.Judgment{Category: "Phishing"}
+
+	tests := []struct {
+		name          string
+		maxImages     int
+		wantImages    int
+		wantMultimode bool
+	}{
+		{name: "Images disabled", maxImages: 0, wantMultimode: false},
+		{name: "Images limited", maxImages: 1, wantImages: 1, wantMultimode: true},
+		{name: "All images", maxImages: 5, wantImages: 2, wantMultimode: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			usedMultimodal := false
+			provider := &MockMultimodalProvider{
+				MockLLMProvider: MockLLMProvider{
+					AnalyzeTextFunc: func(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+						return judgment, nil
+					},
+				},
+				AnalyzeContentFunc: func(ctx context.Context, parts []llm.ContentPart, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+					usedMultimodal = true
+					images := 0
+					for _, p := range parts {
+						if p.Type == "image_url" {
+							images++
+						}
+					}
+					if images != tt.wantImages {
+						t.Errorf("AnalyzeContent received %d images, want %d", images, tt.wantImages)
+					}
+					if !strings.Contains(parts[0].Text, "Subject: Scan to pay") {
+						t.Errorf("first part is not the prompt: %q", parts[0].Text)
+					}
+					return judgment, nil
+				},
+			}
+
+			a := NewEmailAnalyzer(provider)
+			a.SetMaxImages(tt.maxImages)
+			if _, err := a.Analyze(context.Background(), parsedEmail, nil); err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			if usedMultimodal != tt.wantMultimode {
+				t.Errorf("used multimodal = %v, want %v", usedMultimodal, tt.wantMultimode)
+			}
+		})
+	}
+}
+
+// fakeEnricher reports the subject of the message.
+type fakeEnricher struct{}
+
+func (fakeEnricher) Name() string { return "fake" }
+
+func (fakeEnricher) Enrich(ctx context.Context, e *email.ParsedEmail) (*enrichment.Result, error) {
+	return &enrichment.Result{Signals: []enrichment.Signal{{Name: "subject_length", Value: fmt.Sprint(len(e.Subject))}}}, nil
+}
+
+func TestEmailAnalyzer_Analyze_Enrichments(t *testing.T) {
+	var got string
+	a := NewEmailAnalyzer(&MockLLMProvider{
+		AnalyzeTextFunc: func(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+			got = prompt
+			return &llm.Judgment{Category: "Phishing"}, nil
+		},
+	})
+	a.SetEnrichers(enrichment.Pipeline{fakeEnricher{}})
+	parsedEmail := &email.ParsedEmail{Subject: "Password expiry", Header: mail.Header{}}
+	if _, err := a.Analyze(context.Background(), parsedEmail, nil); err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	want := "No URLs found.\n\n--- Enrichment: fake ---\n- subject_length: 15\n\n--- Analysis Instructions---\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("prompt = %q, want it to contain %q", got, want)
+	}
+}
+
+func TestEmailAnalyzer_Analyze_Warnings(t *testing.T) {
+	var got string
+	a := NewEmailAnalyzer(&MockLLMProvider{
+		AnalyzeTextFunc: func(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+			got = prompt
+			return &llm.Judgment{Category: "Spam"}, nil
+		},
+	})
+	parsedEmail := &email.ParsedEmail{Subject: "Newsletter", Header: mail.Header{}, Warnings: []string{"kept only the first 1000 distinct URLs"}}
+	if _, err := a.Analyze(context.Background(), parsedEmail, nil); err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	want := "\n--- Parsing Limits ---\nThe message was too large or complex to be read in full, so it is only partly shown above:\n- kept only the first 1000 distinct URLs\n"
+	if !strings.Contains(got, want) {
+		t.Errorf("prompt = %q, want it to contain %q", got, want)
+	}
+}
@@ -0,0 +1,85 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"mail-analyzer/llm"
+)
+
+// FallbackMaxConfidence caps the confidence score of judgments made by the fallback provider.
+const FallbackMaxConfidence = 0.5
+
+// FallbackProvider uses Primary and switches to Fallback when the primary endpoint
+// cannot be reached or is unavailable (llm.ErrProviderUnavailable). Other errors, such as
+// API errors, rate limits or unparsable output, are returned as-is.
+type FallbackProvider struct {
+	Primary  LLMProvider
+	Fallback LLMProvider
+}
+
+// NewFallbackProvider creates a FallbackProvider.
+func NewFallbackProvider(primary, fallback LLMProvider) *FallbackProvider {
+	return &FallbackProvider{Primary: primary, Fallback: fallback}
+}
+
+// AnalyzeText implements LLMProvider.
+func (f *FallbackProvider) AnalyzeText(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+	judgment, err := f.Primary.AnalyzeText(ctx, prompt, tools, toolChoice)
+	if err == nil || !isUnreachable(ctx, err) {
+		return judgment, err
+	}
+	return f.fallback(ctx, prompt, tools, toolChoice, err)
+}
+
+// AnalyzeContent implements MultimodalProvider. The fallback only receives the text parts.
+func (f *FallbackProvider) AnalyzeContent(ctx context.Context, parts []llm.ContentPart, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+	var texts []string
+	for _, part := range parts {
+		if part.Type == "text" {
+			texts = append(texts, part.Text)
+		}
+	}
+	prompt := strings.Join(texts, "\n\n")
+
+	mp, ok := f.Primary.(MultimodalProvider)
+	if !ok {
+		return f.AnalyzeText(ctx, prompt, tools, toolChoice)
+	}
+	judgment, err := mp.AnalyzeContent(ctx, parts, tools, toolChoice)
+	if err == nil || !isUnreachable(ctx, err) {
+		return judgment, err
+	}
+	return f.fallback(ctx, prompt, tools, toolChoice, err)
+}
+
+func (f *FallbackProvider) fallback(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string, primaryErr error) (*llm.Judgment, error) {
+	log.Printf("ERROR: LLM endpoint unreachable, using fallback classifier: %v", primaryErr)
+	// A model chosen for the primary endpoint may not exist on the fallback one.
+	ctx = llm.WithModel(ctx, "")
+	judgment, err := f.Fallback.AnalyzeText(ctx, prompt, tools, toolChoice)
+	if err != nil {
+		return nil, fmt.Errorf("%w (fallback classifier also failed: %v)", primaryErr, err)
+	}
+	if judgment.ConfidenceScore > FallbackMaxConfidence {
+		judgment.ConfidenceScore = FallbackMaxConfidence
+	}
+	judgment.Reason = "[Fallback classifier; LLM unavailable] " + judgment.Reason
+	return judgment, nil
+}
+
+// isUnreachable reports whether err means the request never got a response, e.g. a
+// refused connection, DNS failure, or timeout, or the endpoint reported itself unavailable.
+// A request stopped because ctx was canceled or expired, such as on an interrupt or at the
+// message_timeout, is not: the fallback would be given the same dead ctx.
+func isUnreachable(ctx context.Context, err error) bool {
+	if ctx.Err() != nil && (errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)) {
+		return false
+	}
+	var urlErr *url.Error
+	return errors.Is(err, llm.ErrProviderUnavailable) || errors.As(err, &urlErr)
+}
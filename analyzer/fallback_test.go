@@ -0,0 +1,73 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+
+	"mail-analyzer/llm"
+)
+
+func TestFallbackProvider_AnalyzeText(t *testing.T) {
+	unreachable := fmt.Errorf("HTTP request failed: %w", &url.Error{Op: "Post", URL: "http://localhost:1", Err: errors.New("connection refused")})
+	fallback := &MockLLMProvider{
+		AnalyzeTextFunc: func(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+			return &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Looks like phishing.", ConfidenceScore: 0.9}, nil
+		},
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name         string
+		ctx          context.Context
+		primaryErr   error
+		wantCategory string
+		wantErr      bool
+	}{
+		{name: "Primary succeeds", wantCategory: "Safe"},
+		{name: "Endpoint unreachable", primaryErr: unreachable, wantCategory: "Phishing"},
+		{name: "API error is not retried", primaryErr: errors.New("API error: [invalid_api_key] bad key"), wantErr: true},
+		{
+			name:       "Canceled request is not retried",
+			ctx:        canceled,
+			primaryErr: &url.Error{Op: "Post", URL: "http://localhost:1", Err: context.Canceled},
+			wantErr:    true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			primary := &MockLLMProvider{
+				AnalyzeTextFunc: func(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+					if tt.primaryErr != nil {
+						return nil, tt.primaryErr
+					}
+					return &llm.Judgment{Category: "Safe", ConfidenceScore: 0.9}, nil
+				},
+			}
+			ctx := tt.ctx
+			if ctx == nil {
+				ctx = context.Background()
+			}
+			got, err := NewFallbackProvider(primary, fallback).AnalyzeText(ctx, "prompt", nil, "auto")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AnalyzeText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got.Category != tt.wantCategory {
+				t.Errorf("AnalyzeText() category = %s, want %s", got.Category, tt.wantCategory)
+			}
+			if tt.primaryErr != nil {
+				if got.ConfidenceScore != FallbackMaxConfidence || !strings.HasPrefix(got.Reason, "[Fallback classifier") {
+					t.Errorf("fallback judgment not marked as lower confidence: %+v", got)
+				}
+			}
+		})
+	}
+}
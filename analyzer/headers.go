@@ -0,0 +1,168 @@
+package analyzer
+
+import (
+	"fmt"
+	"strings"
+
+	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
+)
+
+// maxPromptReceived is the number of Received headers, from the topmost, that a
+// header-only prompt shows; the hops beyond are those of the sender, which it controls.
+const maxPromptReceived = 8
+
+// maxPromptHeader is the length in bytes beyond which a header value is truncated in a
+// header-only prompt.
+const maxPromptHeader = 400
+
+// headerFields are the fields that a header-only prompt shows, in this order, besides
+// From, To and Subject.
+var headerFields = []string{
+	"Sender", "Reply-To", "Return-Path", "Date", "Message-ID", "X-Mailer", "User-Agent",
+	"Authentication-Results", "ARC-Authentication-Results", "Received-SPF",
+}
+
+// buildHeadersPrompt returns the prompt of a header-only analysis: the sender, the
+// authentication results and the route of email, and the anomalies of its From field,
+// without its body, URLs or attachments.
+func buildHeadersPrompt(email *email.ParsedEmail, enrichments []enrichment.Result, opts *AnalysisOptions) string {
+	var b strings.Builder
+	if opts == nil || len(opts.Categories) == 0 {
+		b.WriteString("Please pre-screen the following email from its header alone and determine if it is safe, spam, or phishing.")
+	} else {
+		b.WriteString(fmt.Sprintf("Please pre-screen the following email from its header alone and determine which of these categories it belongs to: %s.", strings.Join(opts.Categories, ", ")))
+	}
+	b.WriteString(" The body is not shown: judge the sender, the authentication results and the route of the message, and lower your confidence when they are not conclusive.\n\n")
+
+	b.WriteString("--- Email Headers ---\n")
+	for _, from := range email.From {
+		b.WriteString(fmt.Sprintf("From: %s\n", formatAddress(from)))
+	}
+	if len(email.To) > 0 {
+		var to []string
+		for _, addr := range email.To {
+			to = append(to, formatAddress(addr))
+		}
+		b.WriteString(fmt.Sprintf("To: %s\n", strings.Join(to, ", ")))
+	}
+	b.WriteString(fmt.Sprintf("Subject: %s\n", email.Subject))
+	for _, field := range headerFields {
+		for _, value := range email.Header.Values(field) {
+			b.WriteString(fmt.Sprintf("%s: %s\n", field, headerValue(value)))
+		}
+	}
+	for _, value := range email.Header.Values("DKIM-Signature") {
+		tags := dkimTags(value)
+		b.WriteString(fmt.Sprintf("DKIM-Signature: d=%s; s=%s\n", tags["d"], tags["s"]))
+	}
+
+	b.WriteString("\n--- Route (most recent hop first) ---\n")
+	received := email.Header.Values("Received")
+	if len(received) == 0 {
+		b.WriteString("No Received headers.\n")
+	}
+	for i, value := range received {
+		if i == maxPromptReceived {
+			b.WriteString(fmt.Sprintf("... (%d more hops)\n", len(received)-i))
+			break
+		}
+		b.WriteString(fmt.Sprintf("%d. %s\n", i+1, headerValue(value)))
+	}
+
+	b.WriteString("\n--- From Anomalies ---\n")
+	if anomalies := fromAnomalies(email); len(anomalies) > 0 {
+		for _, anomaly := range anomalies {
+			b.WriteString("- " + anomaly + "\n")
+		}
+	} else {
+		b.WriteString("None found.\n")
+	}
+	if sections := enrichment.Prompt(enrichments); sections != "" {
+		b.WriteString("\n" + sections)
+	}
+	if section := contextPrompt(opts.answers()); section != "" {
+		b.WriteString("\n" + section)
+	}
+
+	b.WriteString("\n--- Analysis Instructions---\n")
+	b.WriteString("Based on the header above, call the 'report_analysis_result' function with your conclusion.")
+	b.WriteString(contextInstructions(opts))
+	if language := opts.language(); language != "" {
+		b.WriteString(fmt.Sprintf(" Write the reason in %s.", language))
+	}
+	return b.String()
+}
+
+// headerValue returns a header value on one line, truncated to maxPromptHeader bytes.
+func headerValue(value string) string {
+	value = strings.Join(strings.Fields(value), " ")
+	if len(value) > maxPromptHeader {
+		return value[:maxPromptHeader] + "... (truncated)"
+	}
+	return value
+}
+
+// dkimTags returns the tags of a DKIM-Signature header value (RFC 6376), such as d, the
+// signing domain, and s, the selector.
+func dkimTags(value string) map[string]string {
+	tags := map[string]string{}
+	for _, tag := range strings.Split(value, ";") {
+		if name, value, ok := strings.Cut(tag, "="); ok {
+			tags[strings.TrimSpace(name)] = strings.Join(strings.Fields(value), "")
+		}
+	}
+	return tags
+}
+
+// fromAnomalies returns the signs that the From field of email impersonates another
+// sender: several authors, a display name with another address, and Sender, Reply-To
+// or Return-Path addresses of an unrelated domain.
+func fromAnomalies(email *email.ParsedEmail) []string {
+	if len(email.From) == 0 {
+		return []string{"The message has no From address."}
+	}
+	var anomalies []string
+	if len(email.From) > 1 {
+		anomalies = append(anomalies, fmt.Sprintf("The message has %d From addresses.", len(email.From)))
+	}
+	from := email.From[0]
+	fromDomain := addressDomain(from.Address)
+	for _, word := range strings.FieldsFunc(from.Name, func(r rune) bool { return strings.ContainsRune(" <>()\"',;", r) }) {
+		if strings.Contains(word, "@") && !strings.EqualFold(word, from.Address) {
+			anomalies = append(anomalies, fmt.Sprintf("The display name of From shows the address %s, but the address is %s.", word, from.Address))
+		}
+	}
+	for _, field := range []string{"Sender", "Reply-To"} {
+		addresses, err := email.Header.AddressList(field)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addresses {
+			if domain := addressDomain(addr.Address); domain != "" && !relatedDomains(domain, fromDomain) {
+				anomalies = append(anomalies, fmt.Sprintf("The %s domain %s differs from the From domain %s.", field, domain, fromDomain))
+			}
+		}
+	}
+	if returnPath, err := email.Header.Text("Return-Path"); err == nil {
+		address := strings.Trim(strings.TrimSpace(returnPath), "<>")
+		if domain := addressDomain(address); domain != "" && !relatedDomains(domain, fromDomain) {
+			anomalies = append(anomalies, fmt.Sprintf("The Return-Path domain %s differs from the From domain %s.", domain, fromDomain))
+		}
+	}
+	return anomalies
+}
+
+// addressDomain returns the lowercase domain of an email address, or "".
+func addressDomain(address string) string {
+	if i := strings.LastIndex(address, "@"); i >= 0 {
+		return strings.ToLower(address[i+1:])
+	}
+	return ""
+}
+
+// relatedDomains reports whether a and b are the same domain, or one is a subdomain of
+// the other, such as bounces.example.com and example.com.
+func relatedDomains(a, b string) bool {
+	return a == b || strings.HasSuffix(a, "."+b) || strings.HasSuffix(b, "."+a)
+}
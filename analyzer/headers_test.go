@@ -0,0 +1,94 @@
+package analyzer
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+func TestEmailAnalyzer_Analyze_HeadersOnly(t *testing.T) {
+	raw := "Received: from mx.example.org by mx.example.net; Mon, 5 Oct 2026 10:00:02 +0000\r\n" +
+		"Received: from [203.0.113.7] by mx.example.org; Mon, 5 Oct 2026 10:00:01 +0000\r\n" +
+		"Authentication-Results: mx.example.net; spf=fail smtp.mailfrom=bounce.example.org;\r\n dkim=none; dmarc=fail header.from=bank.example.com\r\n" +
+		"DKIM-Signature: v=1; a=rsa-sha256; d=example.org; s=mail;\r\n bh=abc=; b=def=\r\n" +
+		"From: \"support@bank.example.com\" <alerts@example.org>\r\n" +
+		"Reply-To: <payments@example.net>\r\n" +
+		"To: <alice@example.net>\r\n" +
+		"Subject: Account locked\r\n" +
+		"Content-Type: text/plain\r\n\r\n" +
+		"Sign in at https://bank.example.org/login to unlock your account.\r\n"
+	parsedEmail, err := email.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	var got string
+	a := NewEmailAnalyzer(&MockLLMProvider{
+		AnalyzeTextFunc: func(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+			got = prompt
+			return &llm.Judgment{Category: "Phishing"}, nil
+		},
+	})
+	if _, err := a.Analyze(context.Background(), parsedEmail, &AnalysisOptions{HeadersOnly: true}); err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	for _, want := range []string{
+		"From: \"support@bank.example.com\" <alerts@example.org>\n",
+		"Authentication-Results: mx.example.net; spf=fail smtp.mailfrom=bounce.example.org; dkim=none; dmarc=fail header.from=bank.example.com\n",
+		"DKIM-Signature: d=example.org; s=mail\n",
+		"--- Route (most recent hop first) ---\n1. from mx.example.org by mx.example.net; Mon, 5 Oct 2026 10:00:02 +0000\n2. from [203.0.113.7]",
+		"- The display name of From shows the address support@bank.example.com, but the address is alerts@example.org.\n",
+		"- The Reply-To domain example.net differs from the From domain example.org.\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("prompt = %q, want it to contain %q", got, want)
+		}
+	}
+	if strings.Contains(got, "unlock your account") || strings.Contains(got, "https://bank.example.org/login") {
+		t.Errorf("prompt = %q, want no body or URLs", got)
+	}
+}
+
+func TestFromAnomalies(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		want   []string
+	}{
+		{
+			name:   "aligned",
+			header: "From: Example <news@example.com>\r\nReply-To: <reply@lists.example.com>\r\nReturn-Path: <bounces@mail.example.com>\r\n",
+		},
+		{
+			name:   "no author",
+			header: "Subject: Hello\r\n",
+			want:   []string{"The message has no From address."},
+		},
+		{
+			name:   "several authors and a bounce domain",
+			header: "From: <a@example.com>, <b@example.com>\r\nReturn-Path: <bounce@mailer.example.net>\r\n",
+			want: []string{
+				"The message has 2 From addresses.",
+				"The Return-Path domain mailer.example.net differs from the From domain example.com.",
+			},
+		},
+		{
+			name:   "null return path",
+			header: "From: <a@example.com>\r\nReturn-Path: <>\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsedEmail, err := email.Parse(strings.NewReader(tt.header + "\r\nHello\r\n"))
+			if err != nil {
+				t.Fatalf("Parse() error = %v", err)
+			}
+			if got := fromAnomalies(parsedEmail); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("fromAnomalies() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+package analyzer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"mail-analyzer/llm"
+)
+
+// DefaultCategories are the categories that the model chooses from, unless
+// AnalysisOptions.Categories replaces them. They are the enum of llm.Judgment.Category.
+var DefaultCategories = llm.SchemaFor[llm.Judgment]().Property("category").Enum
+
+// AnalysisOptions are the settings of one analysis that can differ from those of the
+// analyzer, e.g. to honor the parameters of a request to a server. A nil *AnalysisOptions
+// and the zero value use those of the analyzer.
+type AnalysisOptions struct {
+	// Categories replace DefaultCategories as the categories that the model chooses from,
+	// e.g. to add "Scam" or to use the names of a ticketing system.
+	Categories []string
+	// Language is the language in which the model writes the reason, e.g. "Japanese".
+	// Empty leaves it to the model, which usually answers in English.
+	Language string
+	// MaxBodyBytes is the length in bytes beyond which the body of the message is
+	// truncated in the prompt. Zero uses the default of 4000.
+	MaxBodyBytes int
+	// Enrichments are the names of the enrichers of the analyzer to run, in this order.
+	// Nil runs all of them, and an empty slice none.
+	Enrichments []string
+	// Templates replace the templates of the analyzer, for both the user and the system
+	// prompt.
+	Templates *Templates
+	// HeadersOnly judges the message by its header alone, with a smaller prompt of its
+	// sender, authentication results, route and From anomalies, for the pre-screening
+	// of high volumes. The user prompt of the templates is not used.
+	HeadersOnly bool
+	// NeedsContext lets the model ask, in llm.Judgment.NeedsContext, for facts that only
+	// the organization of the recipient knows, for an analyst to answer.
+	NeedsContext bool
+	// Answers are the answers of an analyst to the questions of a previous analysis of
+	// the message, which the model takes into account.
+	Answers []Answer
+}
+
+// Answer is the answer of an analyst to a question of the model.
+type Answer struct {
+	Question string `json:"question"`
+	Answer   string `json:"answer"`
+}
+
+// maxQuestions is the number of questions of the model beyond which the others are
+// dropped.
+const maxQuestions = 5
+
+// CheckOptions reports the options that the analyzer cannot honor: enrichers that it
+// does not have, and invalid categories or truncation budgets.
+func (a *EmailAnalyzer) CheckOptions(opts *AnalysisOptions) error {
+	if opts == nil {
+		return nil
+	}
+	var errs []error
+	seen := map[string]bool{}
+	for _, c := range opts.Categories {
+		key := strings.ToLower(strings.TrimSpace(c))
+		switch {
+		case key == "":
+			errs = append(errs, errors.New("empty category"))
+		case seen[key]:
+			errs = append(errs, fmt.Errorf("duplicate category %q", c))
+		}
+		seen[key] = true
+	}
+	for _, answer := range opts.Answers {
+		if strings.TrimSpace(answer.Question) == "" {
+			errs = append(errs, errors.New("answer without a question"))
+		}
+	}
+	if opts.MaxBodyBytes < 0 {
+		errs = append(errs, fmt.Errorf("negative body budget %d", opts.MaxBodyBytes))
+	}
+	if opts.Enrichments != nil {
+		if _, err := a.enrichers.Select(opts.Enrichments); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// categories returns the categories that the model chooses from.
+func (o *AnalysisOptions) categories() []string {
+	if o == nil || len(o.Categories) == 0 {
+		return DefaultCategories
+	}
+	return o.Categories
+}
+
+// language returns the language of the reason, or "".
+func (o *AnalysisOptions) language() string {
+	if o == nil {
+		return ""
+	}
+	return o.Language
+}
+
+// maxBody returns the length beyond which the body is truncated in the prompt.
+func (o *AnalysisOptions) maxBody() int {
+	if o == nil || o.MaxBodyBytes == 0 {
+		return maxPromptBody
+	}
+	return o.MaxBodyBytes
+}
+
+// changesJudgment reports whether the options change the judgments of the model, which
+// then cannot be reused from or remembered in the pre-filter.
+func (o *AnalysisOptions) changesJudgment() bool {
+	return o != nil && (len(o.Categories) > 0 || o.Language != "" || o.HeadersOnly || len(o.Answers) > 0)
+}
+
+// headersOnly reports whether the message is judged by its header alone.
+func (o *AnalysisOptions) headersOnly() bool {
+	return o != nil && o.HeadersOnly
+}
+
+// needsContext reports whether the model may ask for context.
+func (o *AnalysisOptions) needsContext() bool {
+	return o != nil && o.NeedsContext
+}
+
+// answers returns the answers of the analyst.
+func (o *AnalysisOptions) answers() []Answer {
+	if o == nil {
+		return nil
+	}
+	return o.Answers
+}
+
+// contextPrompt returns the section of the prompt with the answers of the analyst, or "".
+func contextPrompt(answers []Answer) string {
+	if len(answers) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("--- Context from the Analyst ---\n")
+	b.WriteString("An analyst of the recipient's organization answered these questions about the email. Trust the answers over your assumptions:\n")
+	for _, a := range answers {
+		b.WriteString(fmt.Sprintf("Q: %s\nA: %s\n", oneLine(a.Question), oneLine(a.Answer)))
+	}
+	return b.String()
+}
+
+// contextInstructions returns the instructions on needs_context for opts, or "".
+func contextInstructions(opts *AnalysisOptions) string {
+	if !opts.needsContext() {
+		return ""
+	}
+	s := " If your verdict depends on facts that are not in the email but that the recipient's organization knows, such as whether a domain belongs to a known partner or whether a payment was expected, list them as short questions in needs_context and give your best verdict with a lower confidence."
+	if len(opts.answers()) > 0 {
+		s += " Do not ask again the questions that the analyst answered."
+	}
+	return s
+}
+
+// oneLine returns s with its runs of white space, including line breaks, replaced with
+// single spaces, so that an answer cannot start a section of its own in the prompt.
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// truncateBody returns body, truncated to max bytes.
+func truncateBody(body string, max int) string {
+	if len(body) > max {
+		return body[:max] + "\n... (truncated)"
+	}
+	return body
+}
@@ -0,0 +1,196 @@
+package analyzer
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
+	"mail-analyzer/llm"
+)
+
+// otherEnricher reports the number of URLs of the message.
+type otherEnricher struct{}
+
+func (otherEnricher) Name() string { return "other" }
+
+func (otherEnricher) Enrich(ctx context.Context, e *email.ParsedEmail) (*enrichment.Result, error) {
+	return &enrichment.Result{Signals: []enrichment.Signal{{Name: "url_count", Value: "0"}}}, nil
+}
+
+func TestEmailAnalyzer_Analyze_Options(t *testing.T) {
+	templatesDir := t.TempDir()
+	os.WriteFile(filepath.Join(templatesDir, UserPromptFile), []byte(`Classify as {{join .Categories " or "}} in {{.Language}}: {{.Body}}`), 0o600)
+	templates, err := LoadTemplates(templatesDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		opts        *AnalysisOptions
+		wantPrompt  []string
+		avoidPrompt []string
+		wantEnum    []string
+		wantReason  string
+		// wantQuestions is whether the tool lets the model ask for context.
+		wantQuestions bool
+	}{
+		{
+			name:        "Defaults",
+			wantPrompt:  []string{"determine if it is safe, spam, or phishing", "- subject_length: 15", "- url_count: 0", strings.Repeat("x", 4000) + "\n... (truncated)"},
+			avoidPrompt: []string{"Write the reason", "needs_context", "Context from the Analyst"},
+			wantEnum:    DefaultCategories,
+			wantReason:  "A brief explanation for the judgment.",
+		},
+		{
+			name: "Taxonomy and language",
+			opts: &AnalysisOptions{Categories: []string{"Phishing", "Scam", "Spam", "Safe"}, Language: "Japanese"},
+			wantPrompt: []string{
+				"determine which of these categories it belongs to: Phishing, Scam, Spam, Safe.",
+				"with your conclusion. Write the reason in Japanese.",
+			},
+			wantEnum:   []string{"Phishing", "Scam", "Spam", "Safe"},
+			wantReason: "A brief explanation for the judgment, in Japanese.",
+		},
+		{
+			name:        "Body budget and enrichments",
+			opts:        &AnalysisOptions{MaxBodyBytes: 10, Enrichments: []string{"other"}},
+			wantPrompt:  []string{"\nxxxxxxxxxx\n... (truncated)\n", "- url_count: 0"},
+			avoidPrompt: []string{"subject_length"},
+			wantEnum:    DefaultCategories,
+			wantReason:  "A brief explanation for the judgment.",
+		},
+		{
+			name:        "No enrichments",
+			opts:        &AnalysisOptions{Enrichments: []string{}},
+			avoidPrompt: []string{"subject_length", "url_count"},
+			wantEnum:    DefaultCategories,
+			wantReason:  "A brief explanation for the judgment.",
+		},
+		{
+			name:          "Needs context",
+			opts:          &AnalysisOptions{NeedsContext: true},
+			wantPrompt:    []string{"list them as short questions in needs_context"},
+			avoidPrompt:   []string{"Do not ask again"},
+			wantEnum:      DefaultCategories,
+			wantReason:    "A brief explanation for the judgment.",
+			wantQuestions: true,
+		},
+		{
+			name: "Answers",
+			opts: &AnalysisOptions{NeedsContext: true, Answers: []Answer{{Question: "Is acme.example a partner?", Answer: "Yes,\n\nsince 2019."}}},
+			wantPrompt: []string{
+				"--- Context from the Analyst ---\n",
+				"Q: Is acme.example a partner?\nA: Yes, since 2019.\n",
+				"Do not ask again the questions that the analyst answered.",
+			},
+			wantEnum:      DefaultCategories,
+			wantReason:    "A brief explanation for the judgment.",
+			wantQuestions: true,
+		},
+		{
+			name:       "Templates",
+			opts:       &AnalysisOptions{Templates: templates, Categories: []string{"Bad", "Good"}, Language: "French", MaxBodyBytes: 3},
+			wantPrompt: []string{"Classify as Bad or Good in French: xxx\n... (truncated)"},
+			wantEnum:   []string{"Bad", "Good"},
+			wantReason: "A brief explanation for the judgment, in French.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var prompt string
+			var tool llm.APITool
+			a := NewEmailAnalyzer(&MockLLMProvider{
+				AnalyzeTextFunc: func(ctx context.Context, p string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+					prompt, tool = p, tools[0]
+					return &llm.Judgment{Category: "Safe"}, nil
+				},
+			})
+			a.SetEnrichers(enrichment.Pipeline{fakeEnricher{}, otherEnricher{}})
+			parsedEmail := &email.ParsedEmail{Subject: "Password expiry", Body: strings.Repeat("x", 5000), Header: mail.Header{}}
+			if _, err := a.Analyze(context.Background(), parsedEmail, tt.opts); err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			for _, want := range tt.wantPrompt {
+				if !strings.Contains(prompt, want) {
+					t.Errorf("prompt = %q, want it to contain %q", prompt, want)
+				}
+			}
+			for _, avoid := range tt.avoidPrompt {
+				if strings.Contains(prompt, avoid) {
+					t.Errorf("prompt = %q, want it not to contain %q", prompt, avoid)
+				}
+			}
+			params := tool.Function.Parameters.(*llm.Schema)
+			if enum := params.Property("category").Enum; !slices.Equal(enum, tt.wantEnum) {
+				t.Errorf("category enum = %v, want %v", enum, tt.wantEnum)
+			}
+			if reason := params.Property("reason").Description; reason != tt.wantReason {
+				t.Errorf("reason description = %q, want %q", reason, tt.wantReason)
+			}
+			if questions := params.Property("needs_context") != nil; questions != tt.wantQuestions {
+				t.Errorf("needs_context in the tool = %t, want %t", questions, tt.wantQuestions)
+			}
+		})
+	}
+}
+
+func TestEmailAnalyzer_Analyze_NeedsContext(t *testing.T) {
+	a := NewEmailAnalyzer(&MockLLMProvider{
+		AnalyzeTextFunc: func(ctx context.Context, p string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+			return &llm.Judgment{Category: "Spam", NeedsContext: []string{"1", "2", "3", "4", "5", "6"}}, nil
+		},
+	})
+	parsedEmail := &email.ParsedEmail{Subject: "Invoice", Header: mail.Header{}}
+
+	// Questions that were not asked for are dropped.
+	judgment, err := a.Analyze(context.Background(), parsedEmail, nil)
+	if err != nil || judgment.NeedsContext != nil {
+		t.Errorf("Analyze() = %+v, %v, want no questions", judgment, err)
+	}
+	judgment, err = a.Analyze(context.Background(), parsedEmail, &AnalysisOptions{NeedsContext: true})
+	if err != nil || !slices.Equal(judgment.NeedsContext, []string{"1", "2", "3", "4", "5"}) {
+		t.Errorf("Analyze() = %+v, %v, want the first %d questions", judgment, err, maxQuestions)
+	}
+}
+
+func TestEmailAnalyzer_CheckOptions(t *testing.T) {
+	a := NewEmailAnalyzer(&MockLLMProvider{})
+	a.SetEnrichers(enrichment.Pipeline{fakeEnricher{}})
+	tests := []struct {
+		name    string
+		opts    *AnalysisOptions
+		wantErr string
+	}{
+		{name: "Nil"},
+		{name: "Valid", opts: &AnalysisOptions{Categories: []string{"Scam", "Safe"}, MaxBodyBytes: 100, Enrichments: []string{"fake"}}},
+		{name: "Duplicate category", opts: &AnalysisOptions{Categories: []string{"Spam", "spam"}}, wantErr: `duplicate category "spam"`},
+		{name: "Empty category", opts: &AnalysisOptions{Categories: []string{" "}}, wantErr: "empty category"},
+		{name: "Answer without question", opts: &AnalysisOptions{Answers: []Answer{{Answer: "Yes"}}}, wantErr: "answer without a question"},
+		{name: "Negative budget", opts: &AnalysisOptions{MaxBodyBytes: -1}, wantErr: "negative body budget"},
+		{name: "Unknown enrichment", opts: &AnalysisOptions{Enrichments: []string{"dns"}}, wantErr: `unknown or disabled enrichment "dns" (enabled: fake)`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := a.CheckOptions(tt.opts)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("CheckOptions() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("CheckOptions() error = %v, want %q", err, tt.wantErr)
+			}
+			if _, err := a.Analyze(context.Background(), &email.ParsedEmail{Header: mail.Header{}}, tt.opts); err == nil {
+				t.Error("Analyze() with invalid options succeeded")
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+package analyzer
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+	"mail-analyzer/vectorstore"
+)
+
+// Embedder is implemented by providers that can compute text embeddings.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float64, error)
+}
+
+// Prefilter modes decide what happens when a near-identical message was already judged.
+const (
+	// PrefilterSkip reuses a stored suspicious judgment and skips the LLM call. A match
+	// judged safe is passed to the model as with PrefilterSeed, since neither the
+	// authentication results nor the enrichments are compared, and a spoofed copy of a
+	// safe message would otherwise be judged safe too.
+	PrefilterSkip = "skip"
+	// PrefilterSeed calls the LLM but includes the stored judgment as a reference.
+	PrefilterSeed = "seed"
+)
+
+// Prefilter compares incoming messages against a store of previously judged emails.
+type Prefilter struct {
+	Embedder Embedder
+	Store    *vectorstore.Store
+	// Threshold is the minimum cosine similarity for a stored message to count as a match.
+	Threshold float64
+	Mode      string
+}
+
+// SetPrefilter enables the embedding-based pre-filter. Pass nil to disable it.
+func (a *EmailAnalyzer) SetPrefilter(p *Prefilter) {
+	a.prefilter = p
+}
+
+// lookup embeds the email and returns the closest stored entry above the threshold, if any,
+// along with the email's vector for storing the new judgment later.
+func (p *Prefilter) lookup(ctx context.Context, email *email.ParsedEmail) (*vectorstore.Entry, float64, []float64, error) {
+	vectors, err := p.Embedder.Embed(ctx, []string{embeddingText(email)})
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	vector := vectors[0]
+
+	match, score := p.Store.Nearest(vector)
+	if match == nil || score < p.Threshold {
+		return nil, score, vector, nil
+	}
+	log.Printf("DEBUG Pre-filter match: %s (similarity %.4f)", match.MessageID, score)
+	return match, score, vector, nil
+}
+
+// prefilterSaveEvery is the number of judgments remembered between two saves of the
+// store, which rewrite the whole file.
+const prefilterSaveEvery = 100
+
+// remember stores the judgment for email, and persists the store every
+// prefilterSaveEvery judgments. Close persists the others.
+func (p *Prefilter) remember(email *email.ParsedEmail, vector []float64, judgment *llm.Judgment) {
+	// The questions are about this message, and are not asked again for similar ones.
+	stored := *judgment
+	stored.NeedsContext = nil
+	p.Store.Add(vectorstore.Entry{MessageID: email.MessageID, Vector: vector, Judgment: stored})
+	if p.Store.Unsaved() < prefilterSaveEvery {
+		return
+	}
+	if err := p.Store.Save(); err != nil {
+		log.Printf("ERROR: could not save vector store: %v", err)
+	}
+}
+
+// Close persists the judgments remembered since the store was last saved.
+func (p *Prefilter) Close() error {
+	if p.Store.Unsaved() == 0 {
+		return nil
+	}
+	return p.Store.Save()
+}
+
+// embeddingText is the message content compared by the pre-filter. Recipient headers are
+// left out so that the same campaign sent to different people is still recognized.
+func embeddingText(email *email.ParsedEmail) string {
+	var b strings.Builder
+	if len(email.From) > 0 {
+		fmt.Fprintf(&b, "From: %s\n", formatAddress(email.From[0]))
+	}
+	fmt.Fprintf(&b, "Subject: %s\n\n", email.Subject)
+	body := email.Body
+	if len(body) > 4000 {
+		body = body[:4000]
+	}
+	b.WriteString(body)
+	for _, u := range email.URLs {
+		b.WriteString("\n" + u)
+	}
+	return b.String()
+}
+
+// reusedJudgment returns a copy of a stored judgment, noting where it came from.
+func reusedJudgment(match *vectorstore.Entry, score float64) *llm.Judgment {
+	judgment := match.Judgment
+	judgment.Reason = fmt.Sprintf("Near-identical to previously analyzed message %s (similarity %.2f). %s", match.MessageID, score, judgment.Reason)
+	return &judgment
+}
+
+// seedPrompt appends the stored judgment of a similar message to prompt as a reference.
+func seedPrompt(prompt string, match *vectorstore.Entry, score float64) string {
+	return prompt + fmt.Sprintf("\n\n--- Similar Previously Analyzed Email ---\n"+
+		"A very similar email (similarity %.2f) was previously judged as follows: category=%s, is_suspicious=%t, reason=%q.\n"+
+		"Use this as a reference, but base your conclusion on the email above.",
+		score, match.Judgment.Category, match.Judgment.IsSuspicious, match.Judgment.Reason)
+}
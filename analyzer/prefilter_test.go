@@ -0,0 +1,132 @@
+package analyzer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+	"mail-analyzer/vectorstore"
+)
+
+// MockEmbedder returns a fixed vector per subject.
+type MockEmbedder map[string][]float64
+
+func (m MockEmbedder) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	var vectors [][]float64
+	for _, text := range texts {
+		found := false
+		for subject, v := range m {
+			if strings.Contains(text, "Subject: "+subject+"\n") {
+				vectors = append(vectors, v)
+				found = true
+			}
+		}
+		if !found {
+			return nil, errors.New("unknown text")
+		}
+	}
+	return vectors, nil
+}
+
+func TestEmailAnalyzer_Analyze_Prefilter(t *testing.T) {
+	stored := llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login page.", ConfidenceScore: 0.9}
+	storedSafe := llm.Judgment{Category: "Safe", Reason: "Invoice from a known vendor.", ConfidenceScore: 0.9}
+	fresh := &llm.Judgment{Category: "Safe", Reason: "Newsletter.", ConfidenceScore: 0.7}
+	embedder := MockEmbedder{
+		"Verify your account":     {1, 0, 0},
+		"Verify your account now": {0.99, 0.05, 0},
+		"Team lunch":              {0, 1, 0},
+		"Your invoice":            {0, 0.05, 0.99},
+	}
+
+	tests := []struct {
+		name         string
+		subject      string
+		mode         string
+		wantCalls    int
+		wantCategory string
+		wantSeeded   bool
+	}{
+		{name: "Skip on match", subject: "Verify your account now", mode: PrefilterSkip, wantCalls: 0, wantCategory: "Phishing"},
+		{name: "Skip seeds a safe match", subject: "Your invoice", mode: PrefilterSkip, wantCalls: 1, wantCategory: "Safe", wantSeeded: true},
+		{name: "Seed on match", subject: "Verify your account now", mode: PrefilterSeed, wantCalls: 1, wantCategory: "Safe", wantSeeded: true},
+		{name: "No match", subject: "Team lunch", mode: PrefilterSkip, wantCalls: 1, wantCategory: "Safe"},
+		{name: "Embedding failure falls back to the LLM", subject: "Unknown", mode: PrefilterSkip, wantCalls: 1, wantCategory: "Safe"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := vectorstore.Open(filepath.Join(t.TempDir(), "store.json"))
+			if err != nil {
+				t.Fatalf("Open() error = %v", err)
+			}
+			store.Add(vectorstore.Entry{MessageID: "<old@example.com>", Vector: []float64{1, 0, 0}, Judgment: stored})
+			store.Add(vectorstore.Entry{MessageID: "<invoice@example.com>", Vector: []float64{0, 0, 1}, Judgment: storedSafe})
+
+			calls := 0
+			provider := &MockLLMProvider{
+				AnalyzeTextFunc: func(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+					calls++
+					if seeded := strings.Contains(prompt, "Similar Previously Analyzed Email"); seeded != tt.wantSeeded {
+						t.Errorf("prompt seeded = %v, want %v", seeded, tt.wantSeeded)
+					}
+					return fresh, nil
+				},
+			}
+			a := NewEmailAnalyzer(provider)
+			a.SetPrefilter(&Prefilter{Embedder: embedder, Store: store, Threshold: 0.95, Mode: tt.mode})
+
+			got, err := a.Analyze(context.Background(), &email.ParsedEmail{MessageID: "<new@example.com>", Subject: tt.subject, Header: mail.Header{}}, nil)
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			if calls != tt.wantCalls {
+				t.Errorf("expected %d LLM calls, got %d", tt.wantCalls, calls)
+			}
+			if got.Category != tt.wantCategory {
+				t.Errorf("Analyze() category = %s, want %s", got.Category, tt.wantCategory)
+			}
+
+			// New judgments are added to the store; reused and unembeddable ones are not.
+			wantLen := 2
+			if tt.wantCalls > 0 && tt.subject != "Unknown" {
+				wantLen = 3
+			}
+			if store.Len() != wantLen {
+				t.Errorf("store has %d entries, want %d", store.Len(), wantLen)
+			}
+		})
+	}
+}
+
+func TestPrefilter_Close(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, _ := vectorstore.Open(path)
+	p := &Prefilter{Store: store}
+	judgment := &llm.Judgment{Category: "Safe"}
+
+	for i := range prefilterSaveEvery - 1 {
+		p.remember(&email.ParsedEmail{MessageID: fmt.Sprintf("<%d@example.com>", i)}, []float64{1, 0}, judgment)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("the store was saved before %d judgments: %v", prefilterSaveEvery, err)
+	}
+	if err := p.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if reopened, _ := vectorstore.Open(path); reopened.Len() != prefilterSaveEvery-1 {
+		t.Errorf("saved store has %d entries, want %d", reopened.Len(), prefilterSaveEvery-1)
+	}
+
+	p.remember(&email.ParsedEmail{MessageID: "<last@example.com>"}, []float64{1, 0}, judgment)
+	if store.Unsaved() != 1 {
+		t.Errorf("Unsaved() = %d, want 1", store.Unsaved())
+	}
+}
@@ -0,0 +1,318 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
+	"mail-analyzer/llm"
+)
+
+// The files of a templates directory. Each is optional; the built-in prompts are used for
+// the missing ones.
+const (
+	// SystemPromptFile replaces the system prompt.
+	SystemPromptFile = "system.txt"
+	// UserPromptFile is a text/template of the user prompt, executed with PromptData.
+	UserPromptFile = "user.tmpl"
+	// ExamplesDir holds few-shot examples: messages NAME.eml, each with the expected
+	// judgment in NAME.json. They are added to the system prompt.
+	ExamplesDir = "examples"
+	// ReportFile is a text/template of the report output format.
+	ReportFile = "report.tmpl"
+)
+
+// templatesCheckInterval is how often a templates directory is checked for changes.
+const templatesCheckInterval = time.Second
+
+// Templates holds the prompts and report template of a templates directory. It reloads
+// them when the files change, so that prompts can be changed without restarting a server.
+type Templates struct {
+	dir string
+
+	mu      sync.Mutex
+	checked time.Time
+	stamp   string
+	set     *templateSet
+}
+
+// templateSet is the content of a templates directory.
+type templateSet struct {
+	// system is the system prompt followed by the examples, or empty for the built-in one.
+	system string
+	user   *template.Template
+	report *template.Template
+}
+
+// templateFuncs are the functions available to the templates besides the built-in ones.
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"join":  strings.Join,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// LoadTemplates loads the templates directory dir.
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{dir: dir}
+	stamp, err := t.currentStamp()
+	if err != nil {
+		return nil, err
+	}
+	if t.set, err = t.load(); err != nil {
+		return nil, err
+	}
+	t.stamp, t.checked = stamp, time.Now()
+	return t, nil
+}
+
+// current returns the templates, reloading them first if the files changed. If they
+// cannot be loaded, the previous templates are kept.
+func (t *Templates) current() *templateSet {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if time.Since(t.checked) < templatesCheckInterval {
+		return t.set
+	}
+	t.checked = time.Now()
+	stamp, err := t.currentStamp()
+	if err == nil && stamp == t.stamp {
+		return t.set
+	}
+	set, err := t.load()
+	if err != nil {
+		log.Printf("ERROR: could not reload templates from %s, keeping the previous ones: %v", t.dir, err)
+		return t.set
+	}
+	log.Printf("Reloaded templates from %s", t.dir)
+	t.set, t.stamp = set, stamp
+	return set
+}
+
+// currentStamp returns the names, sizes and modification times of the files of the
+// templates directory, which change when a file is edited, added or removed.
+func (t *Templates) currentStamp() (string, error) {
+	info, err := os.Stat(t.dir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", t.dir)
+	}
+	var stamp strings.Builder
+	for _, name := range []string{SystemPromptFile, UserPromptFile, ReportFile, ExamplesDir} {
+		if info, err := os.Stat(filepath.Join(t.dir, name)); err == nil {
+			fmt.Fprintf(&stamp, "%s %d %d\n", name, info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	entries, _ := os.ReadDir(filepath.Join(t.dir, ExamplesDir))
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil {
+			fmt.Fprintf(&stamp, "%s/%s %d %d\n", ExamplesDir, entry.Name(), info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return stamp.String(), nil
+}
+
+func (t *Templates) load() (*templateSet, error) {
+	set := &templateSet{}
+	system, err := t.readFile(SystemPromptFile)
+	if err != nil {
+		return nil, err
+	}
+	if set.user, err = t.parse(UserPromptFile); err != nil {
+		return nil, err
+	}
+	if set.report, err = t.parse(ReportFile); err != nil {
+		return nil, err
+	}
+	examples, err := set.examples(filepath.Join(t.dir, ExamplesDir))
+	if err != nil {
+		return nil, err
+	}
+	if system != nil || examples != "" {
+		set.system = strings.TrimSpace(string(system))
+		if set.system == "" {
+			set.system = llm.SystemPrompt
+		}
+		set.system += examples
+	}
+	return set, nil
+}
+
+// readFile returns the content of the file name of the directory, or nil if there is none.
+func (t *Templates) readFile(name string) ([]byte, error) {
+	data, err := os.ReadFile(filepath.Join(t.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
+
+// parse parses the template file name of the directory, or returns nil if there is none.
+func (t *Templates) parse(name string) (*template.Template, error) {
+	text, err := t.readFile(name)
+	if text == nil || err != nil {
+		return nil, err
+	}
+	tmpl, err := template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// examples returns the few-shot examples of dir as text for the system prompt: the
+// prompt of each message, followed by its expected judgment.
+func (s *templateSet) examples(dir string) (string, error) {
+	messages, err := filepath.Glob(filepath.Join(dir, "*.eml"))
+	if err != nil || len(messages) == 0 {
+		return "", err
+	}
+	slices.Sort(messages)
+	var text strings.Builder
+	text.WriteString("\n\nThe following examples show emails with the result you should report for them.")
+	for i, path := range messages {
+		raw, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		parsed, err := email.Parse(bytes.NewReader(raw))
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		judgmentPath := strings.TrimSuffix(path, ".eml") + ".json"
+		data, err := os.ReadFile(judgmentPath)
+		if err != nil {
+			return "", fmt.Errorf("example %s has no judgment: %w", filepath.Base(path), err)
+		}
+		var judgment llm.Judgment
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&judgment); err != nil {
+			return "", fmt.Errorf("%s: %w", judgmentPath, err)
+		}
+		prompt, err := s.prompt(parsed, nil, nil)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", path, err)
+		}
+		result, _ := json.Marshal(judgment)
+		fmt.Fprintf(&text, "\n\n=== Example %d ===\n%s\n=== Result of example %d ===\n%s", i+1, prompt, i+1, result)
+	}
+	return text.String(), nil
+}
+
+// prompt returns the user prompt for email, with the facts of the enrichers.
+func (s *templateSet) prompt(email *email.ParsedEmail, enrichments []enrichment.Result, opts *AnalysisOptions) (string, error) {
+	if s.user == nil {
+		return buildPrompt(email, enrichments, opts), nil
+	}
+	var prompt strings.Builder
+	if err := s.user.Execute(&prompt, promptData(email, enrichments, opts)); err != nil {
+		return "", fmt.Errorf("error executing %s: %w", UserPromptFile, err)
+	}
+	return prompt.String(), nil
+}
+
+// SystemPrompt returns the system prompt, with the few-shot examples.
+func (t *Templates) SystemPrompt() string {
+	if system := t.current().system; system != "" {
+		return system
+	}
+	return llm.SystemPrompt
+}
+
+// Report returns the template of the report output format, or nil if the directory has
+// none.
+func (t *Templates) Report() *template.Template {
+	return t.current().report
+}
+
+// PromptData is the data of the user prompt template of a templates directory.
+type PromptData struct {
+	// From is the first sender, formatted as an address.
+	From string
+	// To and ReplyTo are the formatted addresses of the headers.
+	To      []string
+	ReplyTo []string
+	Subject string
+	// ReturnPath is the Return-Path header.
+	ReturnPath string
+	// Body is the text of the message, truncated to 4000 bytes or the budget of the
+	// analysis options.
+	Body        string
+	URLs        []string
+	Attachments []email.Attachment
+	// AttachmentText is the section of the built-in prompt with the text extracted from
+	// the attachments, or empty; the texts are the AttachmentTexts of Email.
+	AttachmentText string
+	// ImageText is the section of the built-in prompt with the text recognized in the
+	// images by OCR, or empty; the texts are the ImageTexts of Email.
+	ImageText string
+	// Email is the whole parsed message, for its other headers.
+	Email *email.ParsedEmail
+	// Enrichments are the facts found by the enrichers, and EnrichmentText is their
+	// section of the built-in prompt.
+	Enrichments    []enrichment.Result
+	EnrichmentText string
+	// Categories are those that the model chooses from, and Language that of the reason,
+	// or empty, as set by the analysis options.
+	Categories []string
+	Language   string
+	// Answers are the answers of the analyst to the questions of a previous analysis, and
+	// AnswerText their section of the built-in prompt, or empty.
+	Answers    []Answer
+	AnswerText string
+}
+
+// formatAddress formats an address for the prompt, with its display name decoded. It is
+// declared here since the parameters named email hide the package.
+var formatAddress = email.FormatAddress
+
+// promptData returns the data of the prompt for email.
+func promptData(email *email.ParsedEmail, enrichments []enrichment.Result, opts *AnalysisOptions) *PromptData {
+	data := &PromptData{
+		Body:           truncateBody(email.Body, opts.maxBody()),
+		Subject:        email.Subject,
+		URLs:           email.URLs,
+		Attachments:    email.Attachments,
+		AttachmentText: attachmentPrompt(email.AttachmentTexts),
+		ImageText:      imagePrompt(email.ImageTexts),
+		Email:          email,
+		Enrichments:    enrichments,
+		EnrichmentText: enrichment.Prompt(enrichments),
+		Categories:     opts.categories(),
+		Language:       opts.language(),
+		Answers:        opts.answers(),
+		AnswerText:     contextPrompt(opts.answers()),
+	}
+	if len(email.From) > 0 {
+		data.From = formatAddress(email.From[0])
+	}
+	for _, addr := range email.To {
+		data.To = append(data.To, formatAddress(addr))
+	}
+	if returnPath, err := email.Header.Text("Return-Path"); err == nil {
+		data.ReturnPath = returnPath
+	}
+	if replyTo, err := email.Header.AddressList("Reply-To"); err == nil {
+		for _, addr := range replyTo {
+			data.ReplyTo = append(data.ReplyTo, formatAddress(addr))
+		}
+	}
+	return data
+}
@@ -0,0 +1,74 @@
+package analyzer
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+func TestTemplates(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0o755)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(SystemPromptFile, "You triage mail for ACME.\n")
+	write(UserPromptFile, "From {{.From}} about {{.Subject}}:\n{{.Body}}\nLinks: {{join .URLs \", \"}}")
+	write("examples/1-invoice.eml", "From: billing@evil.example\r\nSubject: Invoice\r\n\r\nPay now.\r\n")
+	write("examples/1-invoice.json", `{"is_suspicious": true, "category": "Phishing", "reason": "Fake invoice.", "confidence_score": 0.9}`)
+
+	templates, err := LoadTemplates(dir)
+	if err != nil {
+		t.Fatalf("LoadTemplates() error = %v", err)
+	}
+	system := templates.SystemPrompt()
+	for _, want := range []string{"You triage mail for ACME.", "=== Example 1 ===\nFrom <billing@evil.example> about Invoice:", `"category":"Phishing"`} {
+		if !strings.Contains(system, want) {
+			t.Errorf("SystemPrompt() = %q, want it to contain %q", system, want)
+		}
+	}
+
+	var prompt string
+	a := NewEmailAnalyzer(&MockLLMProvider{AnalyzeTextFunc: func(ctx context.Context, p string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+		prompt = p
+		return &llm.Judgment{Category: "Safe"}, nil
+	}})
+	a.SetTemplates(templates)
+	message, _ := email.Parse(bytes.NewReader([]byte("From: a@example.com\r\nSubject: Lunch\r\n\r\nSee https://example.com/menu\r\n")))
+	if _, err := a.Analyze(context.Background(), message, nil); err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if want := "From <a@example.com> about Lunch:\nSee https://example.com/menu\nLinks: https://example.com/menu"; prompt != want {
+		t.Errorf("prompt = %q, want %q", prompt, want)
+	}
+
+	// Changed files are reloaded, and broken ones leave the previous templates in use.
+	write(SystemPromptFile, "You triage mail for Globex.")
+	os.Remove(filepath.Join(dir, "examples/1-invoice.eml"))
+	templates.checked = time.Time{}
+	if got := templates.SystemPrompt(); got != "You triage mail for Globex." {
+		t.Errorf("SystemPrompt() after a change = %q", got)
+	}
+	write(UserPromptFile, "{{.Missing")
+	templates.checked = time.Time{}
+	if got, _ := templates.current().prompt(message, nil, nil); !strings.HasPrefix(got, "From <a@example.com> about Lunch") {
+		t.Errorf("prompt after a broken change = %q, want the previous template", got)
+	}
+
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("LoadTemplates() of a broken template succeeded")
+	}
+	if _, err := LoadTemplates(filepath.Join(dir, "missing")); err == nil {
+		t.Error("LoadTemplates() of a missing directory succeeded")
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"crypto/sha256"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+// apiClient is the tenant and the role of the clients of an API token.
+type apiClient struct {
+	policy *config.Policy
+	role   string
+}
+
+// apiTokens returns the clients of the API tokens of the policies of cfg, by the SHA-256
+// hashes of the tokens.
+func apiTokens(cfg *config.Config) (map[[sha256.Size]byte]apiClient, error) {
+	tokens := map[[sha256.Size]byte]apiClient{}
+	for i := range cfg.Policies {
+		policy := &cfg.Policies[i]
+		hashes, err := policy.TokenHashes()
+		if err != nil {
+			return nil, err
+		}
+		for j, hash := range hashes {
+			tokens[hash] = apiClient{policy: policy, role: policy.APITokens[j].Role}
+		}
+	}
+	return tokens, nil
+}
+
+// authenticate returns the client of the bearer token of r. It reports false if the
+// policies have API tokens and r has none of them; otherwise, requests need no token,
+// and their client has no policy and sees the whole results.
+func (p *pipeline) authenticate(r *http.Request) (apiClient, bool) {
+	return p.authenticateHeader(r.Header.Get("Authorization"))
+}
+
+// authenticateHeader is authenticate for the value of an Authorization header, or of the
+// authorization metadata of a gRPC call.
+func (p *pipeline) authenticateHeader(authorization string) (apiClient, bool) {
+	if len(p.tokens) == 0 {
+		return apiClient{}, true
+	}
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return apiClient{}, false
+	}
+	// Tokens are compared by their hashes, so that the time taken does not tell how
+	// much of a token is right.
+	client, ok := p.tokens[sha256.Sum256([]byte(strings.TrimSpace(token)))]
+	return client, ok
+}
+
+// resultFor returns result as the clients of role may see it: the whole result for
+// analysts, the result as redactResult masks it for RoleRedacted, and the verdict
+// alone for RoleVerdict, for integrations that must not receive the reason, which may
+// quote the message, or the addresses of the recipients.
+func resultFor(role string, result *AnalysisResult) *AnalysisResult {
+	switch role {
+	case config.RoleRedacted:
+		return redactResult(result)
+	case config.RoleVerdict:
+		verdict := &AnalysisResult{
+			AnalysisID: result.AnalysisID,
+			MessageID:  result.MessageID,
+			Model:      result.Model,
+			Provider:   result.Provider,
+			ReceivedAt: result.ReceivedAt,
+			AnalyzedAt: result.AnalyzedAt,
+			Tenant:     result.Tenant,
+		}
+		if j := result.Judgment; j != nil {
+			verdict.Judgment = &llm.Judgment{IsSuspicious: j.IsSuspicious, Category: j.Category, ConfidenceScore: j.ConfidenceScore}
+		}
+		return verdict
+	}
+	return result
+}
+
+// rateLimits are the rate limits of the tenants of a server, which are kept across the
+// reloads of the configuration.
+type rateLimits struct {
+	mu      sync.Mutex
+	buckets map[string]*rateBucket
+}
+
+// rateBucket holds the messages that a tenant can still submit, which refill over time.
+type rateBucket struct {
+	messages float64
+	last     time.Time
+}
+
+// take counts a message of tenant against its limit of perMinute messages per minute,
+// in bursts of up to as many. If the tenant reached the limit, it returns how long until
+// it can submit another message, and the message is not counted.
+func (l *rateLimits) take(tenant string, perMinute int, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buckets == nil {
+		l.buckets = map[string]*rateBucket{}
+	}
+	burst := float64(perMinute)
+	b := l.buckets[tenant]
+	if b == nil {
+		b = &rateBucket{messages: burst, last: now}
+		l.buckets[tenant] = b
+	}
+	perSecond := burst / 60
+	b.messages = min(burst, b.messages+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+	if b.messages < 1 {
+		return time.Duration((1 - b.messages) / perSecond * float64(time.Second))
+	}
+	b.messages--
+	return 0
+}
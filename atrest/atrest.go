@@ -0,0 +1,126 @@
+// Package atrest encrypts the data that mail-analyzer keeps on disk, such as the results
+// database and the vector store of the pre-filter, with the storage key of the
+// configuration, so that a copy of them, in a backup or on a shared analysis host, does
+// not disclose the mail they describe.
+package atrest
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// MinKeyLength is the length in bytes of the shortest storage key. Storage keys are not
+// stretched like passphrases, so they must be random, such as those printed by
+// "openssl rand -base64 32".
+const MinKeyLength = 32
+
+// keyInfo is the HKDF info of the encryption key derived from a storage key.
+const keyInfo = "mail-analyzer storage v1"
+
+// magic starts the data encrypted by a Key, followed by the nonce and the
+// XChaCha20-Poly1305 ciphertext.
+var magic = []byte("MAENC\x00\x01")
+
+// ErrNoKey is returned by Open for encrypted data when there is no storage key.
+var ErrNoKey = errors.New("the data is encrypted; set storage_key or storage_key_keychain to read it")
+
+// ErrPlaintext is returned by Open for data that is not encrypted when there is a
+// storage key, unless plaintext is allowed.
+var ErrPlaintext = errors.New("the data is not encrypted; set storage_allow_plaintext to read the data written before storage_key was set")
+
+// Key encrypts and decrypts data with a storage key. A nil Key leaves data as it is, so
+// that callers do not have to tell whether encryption is enabled.
+type Key struct {
+	aead cipher.AEAD
+	// allowPlaintext makes Open return the data that is not encrypted as it is.
+	allowPlaintext bool
+}
+
+// NewKey returns the Key of the storage key secret, or nil if secret is empty.
+func NewKey(secret string) (*Key, error) {
+	if secret == "" {
+		return nil, nil
+	}
+	if len(secret) < MinKeyLength {
+		return nil, fmt.Errorf("the storage key must be at least %d characters long", MinKeyLength)
+	}
+	key, err := hkdf.Key(sha256.New, []byte(secret), nil, keyInfo, chacha20poly1305.KeySize)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Key{aead: aead}, nil
+}
+
+// Seal returns plaintext encrypted. purpose names what the data is, such as
+// "vectorstore", and must be given to Open too, so that data encrypted for one purpose
+// cannot be passed off as another. With a nil k, Seal returns plaintext.
+func (k *Key) Seal(plaintext []byte, purpose string) []byte {
+	if k == nil {
+		return plaintext
+	}
+	buf := make([]byte, len(magic)+chacha20poly1305.NonceSizeX, len(magic)+chacha20poly1305.NonceSizeX+len(plaintext)+chacha20poly1305.Overhead)
+	copy(buf, magic)
+	nonce := buf[len(magic):]
+	if _, err := rand.Read(nonce); err != nil {
+		// crypto/rand does not fail on supported platforms.
+		panic(err)
+	}
+	return k.aead.Seal(buf, nonce, plaintext, []byte(purpose))
+}
+
+// AllowPlaintext makes Open return the data that is not encrypted as it is, such as the
+// data written before the storage key was set, while it is being migrated. Otherwise,
+// whoever can write to the store could replace encrypted data with forged plaintext.
+func (k *Key) AllowPlaintext() {
+	if k != nil {
+		k.allowPlaintext = true
+	}
+}
+
+// CheckPlaintext returns ErrPlaintext unless data that is not encrypted may be read
+// with k: if k is nil or allows plaintext.
+func (k *Key) CheckPlaintext() error {
+	if k != nil && !k.allowPlaintext {
+		return ErrPlaintext
+	}
+	return nil
+}
+
+// Open returns data decrypted. Data that was not encrypted is returned as it is if
+// CheckPlaintext allows it.
+func (k *Key) Open(data []byte, purpose string) ([]byte, error) {
+	if !IsSealed(data) {
+		if err := k.CheckPlaintext(); err != nil {
+			return nil, err
+		}
+		return data, nil
+	}
+	if k == nil {
+		return nil, ErrNoKey
+	}
+	data = data[len(magic):]
+	if len(data) < chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return nil, errors.New("malformed encrypted data")
+	}
+	plaintext, err := k.aead.Open(nil, data[:chacha20poly1305.NonceSizeX], data[chacha20poly1305.NonceSizeX:], []byte(purpose))
+	if err != nil {
+		return nil, errors.New("could not decrypt the data: wrong storage key, or the data was altered")
+	}
+	return plaintext, nil
+}
+
+// IsSealed reports whether data was encrypted by Seal.
+func IsSealed(data []byte) bool {
+	return bytes.HasPrefix(data, magic)
+}
@@ -0,0 +1,71 @@
+package atrest
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+const testSecret = "Zq0mWc1d8x7Yk3vB2nT5rP9sL4hJ6gF0"
+
+func TestKey(t *testing.T) {
+	key, err := NewKey(testSecret)
+	if err != nil {
+		t.Fatalf("NewKey() error = %v", err)
+	}
+	plaintext := []byte(`{"subject":"Your invoice"}`)
+	sealed := key.Seal(plaintext, "test")
+	if !IsSealed(sealed) || bytes.Contains(sealed, []byte("invoice")) {
+		t.Fatalf("Seal() = %q, want encrypted data", sealed)
+	}
+	if again := key.Seal(plaintext, "test"); bytes.Equal(again, sealed) {
+		t.Errorf("Seal() returned the same data twice, want a new nonce each time")
+	}
+	if got, err := key.Open(sealed, "test"); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Open() = %q, %v, want %q", got, err, plaintext)
+	}
+
+	// Data that was stored before the key was set is only read as it is once allowed.
+	if _, err := key.Open(plaintext, "test"); !errors.Is(err, ErrPlaintext) {
+		t.Errorf("Open() of plaintext error = %v, want ErrPlaintext", err)
+	}
+	if got, err := (*Key)(nil).Open(plaintext, "test"); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Open() of plaintext without a key = %q, %v, want it unchanged", got, err)
+	}
+	migrating, _ := NewKey(testSecret)
+	migrating.AllowPlaintext()
+	if got, err := migrating.Open(plaintext, "test"); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("Open() of plaintext with AllowPlaintext() = %q, %v, want it unchanged", got, err)
+	}
+
+	other, _ := NewKey(strings.Repeat("x", MinKeyLength))
+	for name, open := range map[string]func() ([]byte, error){
+		"another purpose": func() ([]byte, error) { return key.Open(sealed, "other") },
+		"another key":     func() ([]byte, error) { return other.Open(sealed, "test") },
+		"altered data": func() ([]byte, error) {
+			return key.Open(append(bytes.Clone(sealed[:len(sealed)-1]), sealed[len(sealed)-1]^1), "test")
+		},
+		"truncated data": func() ([]byte, error) { return key.Open(sealed[:len(magic)+4], "test") },
+		"no key":         func() ([]byte, error) { return (*Key)(nil).Open(sealed, "test") },
+	} {
+		if _, err := open(); err == nil {
+			t.Errorf("Open() with %s succeeded, want an error", name)
+		}
+	}
+	if _, err := (*Key)(nil).Open(sealed, "test"); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Open() without a key error = %v, want ErrNoKey", err)
+	}
+	if got := (*Key)(nil).Seal(plaintext, "test"); !bytes.Equal(got, plaintext) {
+		t.Errorf("Seal() without a key = %q, want the plaintext", got)
+	}
+}
+
+func TestNewKey(t *testing.T) {
+	if key, err := NewKey(""); key != nil || err != nil {
+		t.Errorf("NewKey(\"\") = %v, %v, want no key", key, err)
+	}
+	if _, err := NewKey("short"); err == nil {
+		t.Error("NewKey() of a short key succeeded, want an error")
+	}
+}
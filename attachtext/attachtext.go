@@ -0,0 +1,233 @@
+// Package attachtext extracts the text of the attachments of messages, such as Word
+// documents, Excel workbooks and HTML pages, so that the lures they carry are read by
+// the model rather than only hashed. Built-in converters read DOCX, XLSX and HTML files,
+// and external commands, such as pdftotext, convert other types. An OCR engine
+// recognizes the text of the images of messages.
+package attachtext
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os/exec"
+	"path"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+)
+
+// maxFiles is the number of attachments of a message whose text is extracted.
+const maxFiles = 10
+
+// maxFileSize is the size of the largest attachment whose text is extracted, so that
+// large attachments are only hashed rather than held in memory.
+const maxFileSize = 16 << 20
+
+// maxText is the length of the text that a converter reads from a file, whatever the
+// size allowed in the prompt, so that a crafted file cannot exhaust the memory.
+const maxText = 1 << 20
+
+// Converter extracts the text of a file.
+type Converter interface {
+	Convert(ctx context.Context, data []byte) (string, error)
+}
+
+// ConverterFunc is a function that implements Converter.
+type ConverterFunc func(ctx context.Context, data []byte) (string, error)
+
+// Convert implements Converter.
+func (f ConverterFunc) Convert(ctx context.Context, data []byte) (string, error) {
+	return f(ctx, data)
+}
+
+// Built-in converters, by name.
+var builtins = map[string]Converter{
+	config.ConverterDOCX: ConverterFunc(DOCX),
+	config.ConverterXLSX: ConverterFunc(XLSX),
+	config.ConverterHTML: ConverterFunc(HTML),
+}
+
+// builtinTypes are the types of the attachments read by the built-in converters.
+var builtinTypes = map[string][]string{
+	config.ConverterDOCX: {".docx", ".docm", "application/vnd.openxmlformats-officedocument.wordprocessingml.document"},
+	config.ConverterXLSX: {".xlsx", ".xlsm", "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"},
+	config.ConverterHTML: {".html", ".htm", ".shtml", ".xhtml", "text/html", "application/xhtml+xml"},
+}
+
+// Rule converts the attachments of some types.
+type Rule struct {
+	// Types are the media types, such as "application/pdf", and the file name
+	// extensions, such as ".pdf", of the attachments that the rule converts.
+	Types     []string
+	Converter Converter
+	// MaxBytes is the length in bytes of the text beyond which it is truncated.
+	MaxBytes int
+}
+
+// matches reports whether the rule converts f.
+func (r *Rule) matches(f *email.File) bool {
+	ext := strings.ToLower(path.Ext(f.Filename))
+	return slices.ContainsFunc(r.Types, func(t string) bool {
+		if strings.HasPrefix(t, ".") {
+			return ext != "" && strings.EqualFold(t, ext)
+		}
+		return strings.EqualFold(t, f.ContentType)
+	})
+}
+
+// Extractor extracts the text of the attachments of messages with the first of its
+// rules that matches each one.
+type Extractor struct {
+	Rules []Rule
+}
+
+// New returns the Extractor of the attachment_converters of cfg, followed by the built-in
+// converters, or nil if attachment_text is not set.
+func New(cfg *config.Config) *Extractor {
+	if !cfg.AttachmentText {
+		return nil
+	}
+	x := &Extractor{}
+	for _, c := range cfg.AttachmentConverters {
+		converter := builtins[c.Builtin]
+		if converter == nil {
+			converter = &Command{Args: c.Command, Timeout: time.Duration(c.Timeout)}
+		}
+		x.Rules = append(x.Rules, Rule{Types: c.Types, Converter: converter, MaxBytes: c.MaxBytes})
+	}
+	for _, name := range []string{config.ConverterDOCX, config.ConverterXLSX, config.ConverterHTML} {
+		x.Rules = append(x.Rules, Rule{Types: builtinTypes[name], Converter: builtins[name], MaxBytes: cfg.AttachmentTextMaxBytes})
+	}
+	return x
+}
+
+// MaxFileSize returns the size up to which the content of an attachment with filename
+// and contentType is needed by Extract: 0 if no rule converts it.
+func (x *Extractor) MaxFileSize(filename, contentType string) int64 {
+	f := &email.File{Filename: filename, ContentType: contentType}
+	if !slices.ContainsFunc(x.Rules, func(r Rule) bool { return r.matches(f) }) {
+		return 0
+	}
+	return maxFileSize
+}
+
+// Extract returns the texts of files, in order, for up to maxFiles of them. Files that
+// no rule converts, whose content was not kept, that have no text or that cannot be
+// converted are left out; the failures are logged, since a damaged attachment should
+// not fail the analysis.
+func (x *Extractor) Extract(ctx context.Context, files []email.File) []email.AttachmentText {
+	var texts []email.AttachmentText
+	var seen []string
+	for i := range files {
+		f := &files[i]
+		if len(texts) == maxFiles || ctx.Err() != nil {
+			break
+		}
+		i := slices.IndexFunc(x.Rules, func(r Rule) bool { return r.matches(f) })
+		if i < 0 || f.Data == nil || slices.Contains(seen, f.SHA256) {
+			continue
+		}
+		seen = append(seen, f.SHA256)
+		rule := &x.Rules[i]
+		text, err := rule.Converter.Convert(ctx, f.Data)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Warning: could not extract the text of attachment %q: %v", f.Filename, err)
+			}
+			continue
+		}
+		if t, ok := newText(f.Filename, f.ContentType, text, rule.MaxBytes); ok {
+			texts = append(texts, t)
+		}
+	}
+	return texts
+}
+
+// newText returns the cleaned text of a file, truncated to max bytes if max is not 0,
+// and whether it has any.
+func newText(filename, contentType, text string, max int) (email.AttachmentText, bool) {
+	if text = clean(text); text == "" {
+		return email.AttachmentText{}, false
+	}
+	t := email.AttachmentText{Filename: filename, ContentType: contentType, Text: text}
+	if max > 0 && len(text) > max {
+		t.Text, t.Truncated = strings.TrimRightFunc(cut(text, max), unicode.IsSpace), true
+	}
+	return t, true
+}
+
+// Command is a converter that runs a program with the file on its standard input, and
+// reads the text from its standard output.
+type Command struct {
+	Args    []string
+	Timeout time.Duration
+}
+
+// Convert implements Converter.
+func (c *Command) Convert(ctx context.Context, data []byte) (string, error) {
+	if c.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Timeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, c.Args[0], c.Args[1:]...)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &limitedBuffer{max: maxText, buf: &stdout}
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return "", fmt.Errorf("%s failed: %w: %s", c.Args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// limitedBuffer writes up to max bytes to buf, and discards the rest.
+type limitedBuffer struct {
+	max int
+	buf *bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// clean returns text as valid UTF-8, without control characters other than line breaks
+// and tabs, with its lines trimmed and no more than one blank line in a row.
+func clean(text string) string {
+	text = strings.ToValidUTF8(text, "�")
+	text = strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return ' '
+	}, text)
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" && (len(lines) == 0 || lines[len(lines)-1] == "") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// cut returns text cut to at most max bytes, without cutting a character.
+func cut(text string, max int) string {
+	for max > 0 && !utf8.RuneStart(text[max]) {
+		max--
+	}
+	return text[:max]
+}
@@ -0,0 +1,199 @@
+package attachtext
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+)
+
+// zipFile returns a zip archive with the files, by name.
+func zipFile(t *testing.T, files map[string]string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func file(name, contentType string, data []byte) email.File {
+	sum := sha256.Sum256(data)
+	return email.File{Filename: name, ContentType: contentType, Data: data, SHA256: hex.EncodeToString(sum[:])}
+}
+
+const document = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>
+<w:p><w:r><w:t>Your mailbox is </w:t></w:r><w:r><w:rPr><w:b/></w:rPr><w:t>full</w:t></w:r></w:p>
+<w:p><w:r><w:t>Sign in:</w:t><w:tab/><w:t>https://login.example.org/</w:t><w:br/><w:t>IT Desk</w:t></w:r></w:p>
+</w:body></w:document>`
+
+func TestDOCX(t *testing.T) {
+	got, err := DOCX(context.Background(), zipFile(t, map[string]string{"word/document.xml": document}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Your mailbox is full\nSign in:\thttps://login.example.org/\nIT Desk\n"; got != want {
+		t.Errorf("DOCX() = %q, want %q", got, want)
+	}
+	if _, err := DOCX(context.Background(), []byte("not a zip")); err == nil {
+		t.Error("DOCX() of a file that is not a zip: no error")
+	}
+	if _, err := DOCX(context.Background(), zipFile(t, map[string]string{"xl/workbook.xml": "<workbook/>"})); err == nil {
+		t.Error("DOCX() of a workbook: no error")
+	}
+}
+
+func TestXLSX(t *testing.T) {
+	data := zipFile(t, map[string]string{
+		"xl/sharedStrings.xml": `<sst xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main">
+<si><t>Invoice 4471</t></si><si><r><t>Pay to </t></r><r><t>IBAN DE89 3704</t></r></si></sst>`,
+		"xl/worksheets/sheet1.xml": `<worksheet xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main"><sheetData>
+<row><c t="s"><v>0</v></c><c><v>1250</v></c><c t="inlineStr"><is><t>Due today</t></is></c></row></sheetData></worksheet>`,
+	})
+	got, err := XLSX(context.Background(), data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Invoice 4471\nPay to IBAN DE89 3704\nDue today\n"; got != want {
+		t.Errorf("XLSX() = %q, want %q", got, want)
+	}
+}
+
+func TestHTML(t *testing.T) {
+	page := `<html><head><title>Secure Document</title><style>p { color: red }</style>
+<script>var k = "<p>not text</p>";</script></head>
+<body><p>Enter your password &amp; PIN</p><table><tr><td>Account</td><td>1234</td></tr></table><br>Thanks</body></html>`
+	got, err := HTML(context.Background(), []byte(page))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got = clean(got)
+	if want := "Secure Document\n\nEnter your password & PIN\n\nAccount\t1234\n\nThanks"; got != want {
+		t.Errorf("HTML() = %q, want %q", got, want)
+	}
+}
+
+func TestCommand_Convert(t *testing.T) {
+	tests := []struct {
+		name    string
+		command Command
+		want    string
+		wantErr string
+	}{
+		{name: "Output", command: Command{Args: []string{"/bin/sh", "-c", "tr a-z A-Z"}}, want: "PAY NOW"},
+		{name: "Failure", command: Command{Args: []string{"/bin/sh", "-c", "echo broken file >&2; exit 3"}}, wantErr: "broken file"},
+		{name: "Timeout", command: Command{Args: []string{"/bin/sh", "-c", "exec sleep 5"}, Timeout: 50 * time.Millisecond}, wantErr: "deadline exceeded"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.command.Convert(context.Background(), []byte("pay now"))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Convert() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("Convert() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	if x := New(&config.Config{}); x != nil {
+		t.Errorf("New() without attachment_text = %v, want nil", x)
+	}
+	x := New(&config.Config{
+		AttachmentText:         true,
+		AttachmentTextMaxBytes: 100,
+		AttachmentConverters: []config.AttachmentConverter{
+			{Types: []string{".pdf"}, Command: []string{"pdftotext", "-", "-"}, MaxBytes: 50, Timeout: config.Duration(time.Second)},
+			{Types: []string{"text/x-html"}, Builtin: config.ConverterHTML, MaxBytes: 100},
+		},
+	})
+	if len(x.Rules) != 5 {
+		t.Fatalf("New() has %d rules, want 5", len(x.Rules))
+	}
+	if c, ok := x.Rules[0].Converter.(*Command); !ok || c.Args[0] != "pdftotext" || c.Timeout != time.Second || x.Rules[0].MaxBytes != 50 {
+		t.Errorf("New() rule 0 = %+v, want the pdftotext command", x.Rules[0])
+	}
+	if _, ok := x.Rules[1].Converter.(*Command); ok {
+		t.Errorf("New() rule 1 = %+v, want the html converter", x.Rules[1])
+	}
+}
+
+func TestExtractor_Extract(t *testing.T) {
+	upper := ConverterFunc(func(ctx context.Context, data []byte) (string, error) {
+		return strings.ToUpper(string(data)), nil
+	})
+	x := &Extractor{Rules: []Rule{
+		{Types: []string{".txt"}, Converter: upper, MaxBytes: 8},
+		{Types: []string{"text/html"}, Converter: ConverterFunc(HTML), MaxBytes: 100},
+	}}
+	files := []email.File{
+		file("notes.TXT", "application/octet-stream", []byte("café au lait")),
+		file("image.png", "image/png", []byte("\x89PNG")),
+		file("page", "TEXT/HTML", []byte("<p>Verify\x01 your\n\n\n\naccount</p>")),
+		file("copy.txt", "text/plain", []byte("café au lait")),
+		file("empty.txt", "text/plain", []byte("  \n ")),
+		file("broken.docx", "", []byte("not a zip")),
+		{Filename: "large.txt", ContentType: "text/plain", Size: maxFileSize + 1, SHA256: "0"},
+	}
+	got := x.Extract(context.Background(), files)
+	want := []email.AttachmentText{
+		{Filename: "notes.TXT", ContentType: "application/octet-stream", Text: "CAFÉ AU", Truncated: true},
+		{Filename: "page", ContentType: "TEXT/HTML", Text: "Verify  your\n\naccount"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Extract() = %+v, want %+v", got, want)
+	}
+}
+
+func TestExtractor_MaxFileSize(t *testing.T) {
+	x := &Extractor{Rules: []Rule{{Types: []string{".txt", "text/html"}}}}
+	if got := x.MaxFileSize("notes.txt", "application/octet-stream"); got != maxFileSize {
+		t.Errorf("MaxFileSize() of a converted file = %d, want %d", got, maxFileSize)
+	}
+	if got := x.MaxFileSize("image.png", "image/png"); got != 0 {
+		t.Errorf("MaxFileSize() of a file that is not converted = %d, want 0", got)
+	}
+}
+
+func TestCut(t *testing.T) {
+	for _, tt := range []struct {
+		text string
+		max  int
+		want string
+	}{
+		{"abcdef", 3, "abc"},
+		{"abéc", 3, "ab"},
+		{"é", 1, ""},
+	} {
+		if got := cut(tt.text, tt.max); got != tt.want {
+			t.Errorf("cut(%q, %d) = %q, want %q", tt.text, tt.max, got, tt.want)
+		}
+	}
+}
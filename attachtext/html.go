@@ -0,0 +1,56 @@
+package attachtext
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// blockElements are the elements whose text is put on lines of its own.
+var blockElements = map[string]bool{
+	"p": true, "div": true, "br": true, "li": true, "tr": true, "h1": true, "h2": true,
+	"h3": true, "h4": true, "h5": true, "h6": true, "table": true, "form": true,
+	"title": true, "section": true, "article": true, "blockquote": true, "pre": true,
+}
+
+// HTML returns the text of an HTML page, without its scripts and styles, with the text
+// of the block elements on lines of their own.
+func HTML(ctx context.Context, data []byte) (string, error) {
+	z := html.NewTokenizer(bytes.NewReader(data))
+	var b strings.Builder
+	skip := 0
+	for b.Len() <= maxText {
+		tt := z.Next()
+		switch tt {
+		case html.ErrorToken:
+			if err := z.Err(); !errors.Is(err, io.EOF) {
+				return b.String(), err
+			}
+			return b.String(), nil
+		case html.TextToken:
+			if skip == 0 {
+				b.Write(z.Text())
+			}
+		case html.StartTagToken, html.SelfClosingTagToken, html.EndTagToken:
+			name, _ := z.TagName()
+			tag := string(name)
+			switch {
+			case tag == "script" || tag == "style" || tag == "noscript" || tag == "template":
+				if tt == html.StartTagToken {
+					skip++
+				} else if tt == html.EndTagToken && skip > 0 {
+					skip--
+				}
+			case blockElements[tag]:
+				b.WriteByte('\n')
+			case (tag == "td" || tag == "th") && tt == html.StartTagToken:
+				b.WriteByte('\t')
+			}
+		}
+	}
+	return b.String(), nil
+}
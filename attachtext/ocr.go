@@ -0,0 +1,65 @@
+package attachtext
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+)
+
+// OCR recognizes the text of the images of messages, such as screenshots of a sign-in
+// page sent without any text, with an OCR engine: a Converter that reads an image, such
+// as a Command running tesseract, or one that calls an OCR library.
+type OCR struct {
+	Engine Converter
+	// MaxBytes is the length in bytes of the text of an image beyond which it is
+	// truncated.
+	MaxBytes int
+}
+
+// NewOCR returns the OCR of the ocr_command of cfg, or nil if it is not set.
+func NewOCR(cfg *config.Config) *OCR {
+	if len(cfg.OCRCommand) == 0 {
+		return nil
+	}
+	return &OCR{Engine: &Command{Args: cfg.OCRCommand, Timeout: time.Duration(cfg.OCRTimeout)}, MaxBytes: cfg.OCRMaxBytes}
+}
+
+// Recognize returns the texts of images, in order, for up to maxFiles distinct images.
+// Images without text or that the engine fails to read are left out, and the failures
+// are logged.
+func (o *OCR) Recognize(ctx context.Context, images []email.Image) []email.AttachmentText {
+	var texts []email.AttachmentText
+	var seen [][sha256.Size]byte
+	for i, img := range images {
+		if len(texts) == maxFiles || ctx.Err() != nil {
+			break
+		}
+		// Logos are often repeated in a message.
+		sum := sha256.Sum256(img.Data)
+		if slices.Contains(seen, sum) {
+			continue
+		}
+		seen = append(seen, sum)
+		name := img.Filename
+		if name == "" {
+			name = fmt.Sprintf("image %d", i+1)
+		}
+		text, err := o.Engine.Convert(ctx, img.Data)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.Printf("Warning: could not recognize the text of image %q: %v", name, err)
+			}
+			continue
+		}
+		if t, ok := newText(name, img.ContentType, text, o.MaxBytes); ok {
+			texts = append(texts, t)
+		}
+	}
+	return texts
+}
@@ -0,0 +1,49 @@
+package attachtext
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+)
+
+func TestNewOCR(t *testing.T) {
+	if o := NewOCR(&config.Config{}); o != nil {
+		t.Errorf("NewOCR() without ocr_command = %v, want nil", o)
+	}
+	o := NewOCR(&config.Config{OCRCommand: []string{"tesseract", "-", "-"}, OCRTimeout: config.Duration(time.Second), OCRMaxBytes: 100})
+	if c, ok := o.Engine.(*Command); !ok || c.Args[0] != "tesseract" || c.Timeout != time.Second || o.MaxBytes != 100 {
+		t.Errorf("NewOCR() = %+v, want the tesseract command", o)
+	}
+}
+
+func TestOCR_Recognize(t *testing.T) {
+	o := &OCR{MaxBytes: 8, Engine: ConverterFunc(func(ctx context.Context, data []byte) (string, error) {
+		switch string(data) {
+		case "logo":
+			return "  \n", nil
+		case "broken":
+			return "", errors.New("unsupported image")
+		}
+		return "Scan to verify: " + string(data), nil
+	})}
+	images := []email.Image{
+		{Filename: "logo.png", ContentType: "image/png", Data: []byte("logo")},
+		{ContentType: "image/jpeg", Data: []byte("qr")},
+		{Filename: "copy.jpg", ContentType: "image/jpeg", Data: []byte("qr")},
+		{Filename: "broken.gif", ContentType: "image/gif", Data: []byte("broken")},
+		{Filename: "scan.png", ContentType: "image/png", Data: []byte("x")},
+	}
+	got := o.Recognize(context.Background(), images)
+	want := []email.AttachmentText{
+		{Filename: "image 2", ContentType: "image/jpeg", Text: "Scan to", Truncated: true},
+		{Filename: "scan.png", ContentType: "image/png", Text: "Scan to", Truncated: true},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Recognize() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,134 @@
+package attachtext
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+// maxXMLPart is the size of the largest part of an Office document that is read once
+// decompressed, so that a zip bomb is not inflated in full.
+const maxXMLPart = 16 << 20
+
+// DOCX returns the text of the paragraphs of the body of a Word document, one per line.
+func DOCX(ctx context.Context, data []byte) (string, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("not a DOCX file: %w", err)
+	}
+	var b strings.Builder
+	err = readXML(z, "word/document.xml", func(d *xml.Decoder, t xml.Token) error {
+		switch t := t.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "t":
+				var text string
+				if err := d.DecodeElement(&text, &t); err != nil {
+					return err
+				}
+				b.WriteString(text)
+			case "tab":
+				b.WriteByte('\t')
+			case "br", "cr":
+				b.WriteByte('\n')
+			}
+		case xml.EndElement:
+			if t.Name.Local == "p" {
+				b.WriteByte('\n')
+			}
+		}
+		if b.Len() > maxText {
+			return errEnough
+		}
+		return nil
+	})
+	return b.String(), err
+}
+
+// XLSX returns the strings of the cells of an Excel workbook, one per line: the shared
+// strings, in the order of the workbook, and the strings stored in the cells of the
+// sheets. Numbers and formulas are left out.
+func XLSX(ctx context.Context, data []byte) (string, error) {
+	z, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", fmt.Errorf("not an XLSX file: %w", err)
+	}
+	var b strings.Builder
+	// The text of a string is split into runs with their own formatting.
+	collect := func(item string) func(d *xml.Decoder, t xml.Token) error {
+		return func(d *xml.Decoder, t xml.Token) error {
+			switch t := t.(type) {
+			case xml.StartElement:
+				if t.Name.Local == "t" {
+					var text string
+					if err := d.DecodeElement(&text, &t); err != nil {
+						return err
+					}
+					b.WriteString(text)
+				}
+			case xml.EndElement:
+				if t.Name.Local == item {
+					b.WriteByte('\n')
+				}
+			}
+			if b.Len() > maxText {
+				return errEnough
+			}
+			return nil
+		}
+	}
+	if err := readXML(z, "xl/sharedStrings.xml", collect("si")); err != nil && !errors.Is(err, errNoPart) {
+		return b.String(), err
+	}
+	var sheets []string
+	for _, f := range z.File {
+		if strings.HasPrefix(f.Name, "xl/worksheets/") && strings.HasSuffix(f.Name, ".xml") {
+			sheets = append(sheets, f.Name)
+		}
+	}
+	slices.Sort(sheets)
+	for _, name := range sheets {
+		if err := readXML(z, name, collect("is")); err != nil {
+			return b.String(), err
+		}
+	}
+	return b.String(), nil
+}
+
+var (
+	// errEnough stops reading a part once enough text was read.
+	errEnough = errors.New("enough text")
+	// errNoPart is returned by readXML for a part that the document does not have.
+	errNoPart = errors.New("missing part")
+)
+
+// readXML calls f with the tokens of the XML part name of z, until it returns an error.
+// It returns errNoPart if z has no such part, and nil if f returns errEnough.
+func readXML(z *zip.Reader, name string, f func(d *xml.Decoder, t xml.Token) error) error {
+	file, err := z.Open(name)
+	if err != nil {
+		return fmt.Errorf("%w %s", errNoPart, name)
+	}
+	defer file.Close()
+	d := xml.NewDecoder(io.LimitReader(file, maxXMLPart))
+	for {
+		t, err := d.Token()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if err := f(d, t); errors.Is(err, errEnough) {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+}
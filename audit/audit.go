@@ -0,0 +1,119 @@
+// Package audit keeps an append-only log of the actions that mail-analyzer takes on the
+// messages it analyzes, such as moving them to quarantine, sending them to a webhook or
+// creating an alert in TheHive, so that operators can reconstruct what was done to a
+// message and why.
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is an action taken on an analyzed message.
+type Entry struct {
+	Time time.Time `json:"time"`
+	// AnalysisID is the analysis_id of the result that triggered the action.
+	AnalysisID string `json:"analysis_id,omitempty"`
+	MessageID  string `json:"message_id,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	// Action is the type of the action or of the sink that took it, such as "move" or
+	// "webhook".
+	Action string `json:"action"`
+	// Target is what the action was taken on or sent to, such as the quarantine file or
+	// the host of the webhook.
+	Target string `json:"target,omitempty"`
+	// Error is the error of the action, or "" if it succeeded.
+	Error string `json:"error,omitempty"`
+}
+
+// Log is an audit log file, to which entries are appended as JSON Lines. It is safe for
+// concurrent use, and several processes may append to the same file.
+type Log struct {
+	mu sync.Mutex
+	f  *os.File
+	// stderr is where Record reports the entries it cannot write.
+	stderr io.Writer
+}
+
+// Open opens the audit log at path for appending, creating it readable only by the
+// owner if it does not exist.
+func Open(path string) (*Log, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("could not open audit log: %w", err)
+	}
+	return &Log{f: f, stderr: os.Stderr}, nil
+}
+
+// Write appends e to l, with the current time if it has none. Each entry is written
+// with a single write, so that the entries of several processes are not interleaved.
+func (l *Log) Write(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	e.Time = e.Time.UTC()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, err := l.f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("could not write audit log: %w", err)
+	}
+	return nil
+}
+
+// Close closes l.
+func (l *Log) Close() error {
+	return l.f.Close()
+}
+
+type logKey struct{}
+
+// WithLog returns a copy of ctx in which the actions are recorded in l.
+func WithLog(ctx context.Context, l *Log) context.Context {
+	return context.WithValue(ctx, logKey{}, l)
+}
+
+// Record appends e to the audit log of ctx, if it has one. Since the action was taken
+// already, an entry that cannot be written is reported on stderr rather than returned,
+// even without debug logging, so that a full disk does not drop entries unnoticed.
+func Record(ctx context.Context, e Entry) {
+	l, _ := ctx.Value(logKey{}).(*Log)
+	if l == nil {
+		return
+	}
+	if err := l.Write(e); err != nil {
+		fmt.Fprintf(l.stderr, "Error: %v: %+v\n", err, e)
+	}
+}
+
+// ErrorString returns the text of err, or "" if it is nil, for Entry.Error.
+func ErrorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
+
+// Read returns the entries of the audit log r. Lines that are not entries, such as a
+// line cut short by a crash, are skipped.
+func Read(r io.Reader) ([]Entry, error) {
+	var entries []Entry
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var e Entry
+		if json.Unmarshal(scanner.Bytes(), &e) == nil && e.Action != "" {
+			entries = append(entries, e)
+		}
+	}
+	return entries, scanner.Err()
+}
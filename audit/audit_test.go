@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	ctx := WithLog(context.Background(), l)
+	Record(ctx, Entry{AnalysisID: "a1", MessageID: "<1@example.com>", Action: "move", Target: "/quarantine/mail.eml"})
+	Record(ctx, Entry{AnalysisID: "a2", Action: "webhook", Target: "hooks.example.com", Error: ErrorString(errors.New("status 500"))})
+	// Without a log, Record does nothing.
+	Record(context.Background(), Entry{Action: "move"})
+	l.Close()
+
+	// Entries are appended to the existing log, and partial lines are skipped.
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`{"time":"2024-01-`)
+	f.Close()
+
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("audit log mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+	f, _ = os.Open(path)
+	defer f.Close()
+	entries, err := Read(f)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("Read() = %+v, want 2 entries", entries)
+	}
+	if e := entries[0]; e.AnalysisID != "a1" || e.Action != "move" || e.Target != "/quarantine/mail.eml" || e.Error != "" ||
+		time.Since(e.Time) > time.Minute || e.Time.Location() != time.UTC {
+		t.Errorf("entries[0] = %+v", e)
+	}
+	if e := entries[1]; e.Action != "webhook" || e.Error != "status 500" {
+		t.Errorf("entries[1] = %+v", e)
+	}
+}
+
+func TestRecord_WriteError(t *testing.T) {
+	l, err := Open(filepath.Join(t.TempDir(), "audit.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var stderr bytes.Buffer
+	l.stderr = &stderr
+	l.Close()
+	Record(WithLog(context.Background(), l), Entry{AnalysisID: "a1", Action: "move"})
+	if got := stderr.String(); !strings.Contains(got, "could not write audit log") || !strings.Contains(got, "a1") {
+		t.Errorf("stderr = %q, want the entry that could not be written", got)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"mail-analyzer/audit"
+)
+
+// runAudit implements the "audit" subcommand, which lists the actions recorded in the
+// audit log.
+func runAudit(args []string, stdout io.Writer) error {
+	fs := newFlagSet("audit", "", "List the actions taken on the analyzed messages, from the audit log given with --log\n"+
+		"or the audit_log of the configuration, oldest first.")
+	logPath := fs.String("log", "", "Audit log to read")
+	configPath := fs.String("config", "", "Configuration file with audit_log, used when --log is not given")
+	analysisID := fs.String("analysis-id", "", "Only show the actions triggered by the analysis with this analysis_id")
+	messageID := fs.String("message-id", "", "Only show the actions taken on the message with this Message-ID")
+	since := fs.String("since", "", "Only show actions taken since a date (2006-01-02), timestamp (RFC 3339) or duration ago (24h)")
+	failed := fs.Bool("failed", false, "Only show the actions that failed")
+	asJSON := fs.Bool("json", false, "Print entries as JSON Lines instead of a table")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *logPath == "" {
+		cfg, err := loadConfig(*configPath)
+		if err != nil {
+			return err
+		}
+		if cfg.AuditLog == "" {
+			return usageErrorf("--log is required unless audit_log is configured")
+		}
+		*logPath = cfg.AuditLog
+	}
+	var sinceTime time.Time
+	if *since != "" {
+		var err error
+		if sinceTime, err = parseSince(*since, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.Open(*logPath)
+	if err != nil {
+		return fmt.Errorf("could not open audit log: %w", err)
+	}
+	defer f.Close()
+	entries, err := audit.Read(f)
+	if err != nil {
+		return fmt.Errorf("could not read audit log: %w", err)
+	}
+
+	var selected []audit.Entry
+	for _, e := range entries {
+		if (*analysisID == "" || e.AnalysisID == *analysisID) && (*messageID == "" || e.MessageID == *messageID) &&
+			!e.Time.Before(sinceTime) && (!*failed || e.Error != "") {
+			selected = append(selected, e)
+		}
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetEscapeHTML(false)
+		for _, e := range selected {
+			if err := enc.Encode(e); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TIME\tACTION\tTARGET\tRESULT\tANALYSIS-ID\tMESSAGE-ID")
+	for _, e := range selected {
+		result := "ok"
+		if e.Error != "" {
+			result = "failed: " + e.Error
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n",
+			e.Time.Local().Format("2006-01-02 15:04:05"), e.Action, tableField(e.Target), tableField(result),
+			e.AnalysisID, tableField(e.MessageID))
+	}
+	return tw.Flush()
+}
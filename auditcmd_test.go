@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/audit"
+)
+
+func TestRunAudit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, err := audit.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	ctx := audit.WithLog(context.Background(), l)
+	audit.Record(ctx, audit.Entry{Time: time.Now().Add(-48 * time.Hour), AnalysisID: "a0", Action: "move", Target: "/quarantine/old.eml"})
+	audit.Record(ctx, audit.Entry{AnalysisID: "a1", MessageID: "<1@example.com>", Action: "move", Target: "/quarantine/mail.eml"})
+	audit.Record(ctx, audit.Entry{AnalysisID: "a1", MessageID: "<1@example.com>", Action: "webhook", Target: "hooks.example.com", Error: "status 500"})
+	audit.Record(ctx, audit.Entry{AnalysisID: "a2", Action: "slack", Target: "hooks.slack.com"})
+	l.Close()
+
+	var out bytes.Buffer
+	if err := runAudit([]string{"--log", path, "--analysis-id", "a1"}, &out); err != nil {
+		t.Fatalf("runAudit() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[1], "/quarantine/mail.eml") || !strings.Contains(lines[2], "failed: status 500") {
+		t.Errorf("unexpected table output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runAudit([]string{"--log", path, "--json", "--since", "24h", "--failed"}, &out); err != nil {
+		t.Fatalf("runAudit() error = %v", err)
+	}
+	if !strings.Contains(out.String(), `"action":"webhook"`) || strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("unexpected JSON output: %s", out.String())
+	}
+
+	t.Setenv("AUDIT_LOG", "")
+	if err := runAudit([]string{"--config", filepath.Join(t.TempDir(), "config.json")}, &out); err == nil {
+		t.Error("expected an error without --log or audit_log")
+	}
+	t.Setenv("AUDIT_LOG", path)
+	out.Reset()
+	if err := runAudit([]string{"--config", filepath.Join(t.TempDir(), "config.json")}, &out); err != nil || strings.Count(out.String(), "\n") != 5 {
+		t.Errorf("runAudit() with audit_log = %v, output:\n%s", err, out.String())
+	}
+}
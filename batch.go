@@ -0,0 +1,398 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"mail-analyzer/llm"
+)
+
+// runBatch implements the "batch" command, which analyzes many messages in one run.
+func runBatch(args []string) error {
+	flags := newFlagSet("batch", "path...",
+		"Analyze every message in the given files and directories. Directories are searched\n"+
+			"recursively for *.eml files. A message that cannot be analyzed is reported on\n"+
+			"standard error and skipped.")
+	var pf pipelineFlags
+	pf.register(flags)
+	pf.registerDryRun(flags)
+	pf.registerFilter(flags)
+	pf.registerDedup(flags)
+	pf.registerTimeout(flags)
+	pf.registerDeadline(flags)
+	var of outputFlags
+	of.register(flags)
+	concurrency := flags.Int("concurrency", 1, "Number of messages to analyze in parallel")
+	quiet := flags.Bool("quiet", false, "Do not report progress on standard error")
+	sampleSize := flags.Int("sample", 0, "Analyze `N` messages chosen at random, and estimate the prevalence of each category")
+	sampleRate := flags.Float64("sample-rate", 0, "Analyze each message with this probability, such as 0.05, and estimate the prevalence of each category")
+	seed := flags.Uint64("seed", 0, "Seed of the random sample, to draw the same sample again (default random)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if err := of.check(); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageErrorf("no files or directories given")
+	}
+	if *concurrency < 1 {
+		return usageErrorf("--concurrency must be at least 1")
+	}
+	sampling := *sampleSize != 0 || *sampleRate != 0
+	switch {
+	case *sampleSize < 0:
+		return usageErrorf("--sample must be positive")
+	case *sampleRate < 0 || *sampleRate > 1:
+		return usageErrorf("--sample-rate must be between 0 and 1")
+	case *sampleSize > 0 && *sampleRate > 0:
+		return usageErrorf("--sample and --sample-rate cannot be used together")
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	files, err := collectMessageFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	population := len(files)
+	if sampling {
+		seedSet := false
+		flags.Visit(func(f *flag.Flag) { seedSet = seedSet || f.Name == "seed" })
+		if !seedSet {
+			*seed = rand.Uint64()
+		}
+		files = sampleFiles(files, *sampleSize, *sampleRate, *seed)
+		fmt.Fprintf(os.Stderr, "Sampled %d of %d messages (--seed %d)\n", len(files), population, *seed)
+	}
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	of.templates = p.templates
+	if pf.dryRun {
+		return dryRunFiles(p, files)
+	}
+
+	out, err := of.open(strings.Join(flags.Args(), " "))
+	if err != nil {
+		return err
+	}
+	// On SIGINT or SIGTERM, or at the deadline, messages being analyzed are abandoned, but
+	// the results so far are written.
+	ctx, stop := shutdownContext()
+	defer stop()
+	ctx, cancel := pf.withDeadline(ctx)
+	defer cancel()
+
+	pr := newProgress(os.Stderr, len(files), isTerminal(os.Stderr))
+	pr.quiet = *quiet
+	var errs []error
+	failed, analyzed, skipped, duplicates := 0, 0, 0, 0
+	categories := map[string]int{}
+	summary := newSummaryBuilder()
+	for o := range analyzeFiles(ctx, p, files, *concurrency) {
+		if ctx.Err() != nil {
+			continue // Drain the messages abandoned after an interruption.
+		}
+		if errors.Is(o.err, errFiltered) {
+			skipped++
+			pr.update(false)
+			continue
+		}
+		if o.err != nil {
+			pr.printf("Error analyzing %s: %v\n", o.file, o.err)
+			failed++
+			summary.fail()
+			pr.update(true)
+			continue
+		}
+		analyzed++
+		if o.result.DuplicateOf != "" {
+			duplicates++
+		}
+		categories[o.result.Judgment.Category]++
+		summary.add(o.result)
+		pr.update(false)
+		if err := p.record(ctx, o.result); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.file, err))
+		}
+		if err := out.Write(o.result); err != nil {
+			cancel()
+			out.Abort()
+			return fmt.Errorf("error writing output: %w", err)
+		}
+		if err := p.act(ctx, o.result); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", o.file, err))
+		}
+	}
+	pr.finish()
+	if skipped > 0 {
+		pr.printf("%d of %d messages did not match --filter and were skipped\n", skipped, len(files))
+	}
+	if duplicates > 0 {
+		pr.printf("%d of %d messages were duplicates of another one and were not analyzed again\n", duplicates, len(files))
+	}
+	interrupted := ctx.Err() != nil
+	if sampling && !interrupted && len(files) > 0 {
+		// Messages skipped by --filter are not part of the population either.
+		writePrevalence(os.Stderr, categories, population*(len(files)-skipped)/len(files))
+	}
+	if usage := p.provider.Usage(); usage.Requests > 0 {
+		pr.printf("%s\n", formatUsage(usage))
+		summary.setUsage(usage)
+	}
+	out.SetSummary(summary.build())
+	if err := out.Close(); err != nil {
+		return err
+	}
+
+	errs = append(errs, p.close())
+	if failed > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d messages could not be analyzed", failed, len(files)))
+	}
+	if interrupted {
+		errs = append(errs, stoppedError(ctx, "analyzing %d of %d messages", analyzed+failed+skipped, len(files)))
+	}
+	return errors.Join(errs...)
+}
+
+// dryRunFiles prints the LLM request for each file, one after the other.
+func dryRunFiles(p *pipeline, files []string) error {
+	failed := 0
+	for i, file := range files {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("==> %s <==\n", file)
+		_, err := analyzeFile(context.Background(), p, file)
+		if errors.Is(err, errFiltered) {
+			fmt.Println("Skipped: the message does not match --filter")
+			continue
+		}
+		if !errors.Is(err, llm.ErrDryRun) {
+			fmt.Fprintf(os.Stderr, "Error analyzing %s: %v\n", file, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d messages could not be analyzed", failed, len(files))
+	}
+	return nil
+}
+
+// batchOutcome is the result of analyzing one file of a batch.
+type batchOutcome struct {
+	file   string
+	result *AnalysisResult
+	err    error
+}
+
+// analyzeFiles analyzes files and returns their outcomes in the order of files. The
+// messages go through stages connected by bounded channels: the files are read one at a
+// time, parsed by as many workers as there are CPUs, up to concurrency, and enriched and
+// judged by concurrency workers each, so that messages are parsed while others wait for
+// the LLM. At most 2*concurrency messages are in the stages at once, which bounds how far
+// they can get ahead of a slow consumer. Unless dedup is disabled, a message with the
+// Message-ID or content of an earlier one is not analyzed again, and its result repeats
+// that of the earlier one. Once ctx is done, no more files are started, and the channel
+// is closed when the files already started are done. The caller must drain it.
+func analyzeFiles(ctx context.Context, p *pipeline, files []string, concurrency int) <-chan batchOutcome {
+	// Each message has its own channel for its outcome, queued in order.
+	pending := make(chan chan batchOutcome, 2*concurrency)
+	read := make(chan *batchItem, concurrency)
+	// The messages that were read, in order, to be deduplicated once they are parsed.
+	ordered := make(chan *batchItem, 2*concurrency)
+	go func() {
+		defer close(pending)
+		defer close(read)
+		defer close(ordered)
+		for _, file := range files {
+			if ctx.Err() != nil {
+				return
+			}
+			item := newBatchItem(file)
+			select {
+			case pending <- item.done:
+			case <-ctx.Done():
+				return
+			}
+			raw, err := os.ReadFile(file)
+			if err != nil {
+				item.finish(nil, err)
+				continue
+			}
+			item.raw = raw
+			read <- item
+			ordered <- item
+		}
+	}()
+
+	batchStage(min(concurrency, runtime.GOMAXPROCS(0)), read, func(item *batchItem) bool {
+		defer close(item.parsed)
+		var err error
+		if item.analysis, err = p.parse(ctx, item.raw, item.file, nil); err != nil {
+			item.finish(nil, err)
+		}
+		return false
+	})
+
+	// The messages are deduplicated in order, so that the first of the copies of a
+	// message is the one analyzed, whichever is parsed first.
+	parsed := make(chan *batchItem, concurrency)
+	go func() {
+		defer close(parsed)
+		originals := map[string]*batchItem{}
+		for item := range ordered {
+			<-item.parsed
+			if item.analysis == nil {
+				continue
+			}
+			if p.dedup {
+				keys := dedupKeys(item.analysis)
+				if original := firstOriginal(originals, keys); original != nil {
+					go item.repeat(p, original)
+					continue
+				}
+				for _, key := range keys {
+					originals[key] = item
+				}
+			}
+			parsed <- item
+		}
+	}()
+
+	enriched := batchStage(concurrency, parsed, func(item *batchItem) bool {
+		p.enrich(item.analysis)
+		return true
+	})
+	batchStage(concurrency, enriched, func(item *batchItem) bool {
+		item.finish(p.judge(item.analysis))
+		return false
+	})
+
+	outcomes := make(chan batchOutcome)
+	go func() {
+		defer close(outcomes)
+		for done := range pending {
+			outcomes <- <-done
+		}
+	}()
+	return outcomes
+}
+
+// firstOriginal returns the message registered under one of keys, or nil.
+func firstOriginal(originals map[string]*batchItem, keys []string) *batchItem {
+	for _, key := range keys {
+		if original := originals[key]; original != nil {
+			return original
+		}
+	}
+	return nil
+}
+
+// batchItem is a message of a batch on its way through the stages of analyzeFiles.
+type batchItem struct {
+	file     string
+	raw      []byte
+	analysis *analysis
+	// parsed is closed once the message is parsed, or failed to be.
+	parsed chan struct{}
+	// done receives the outcome, which is also kept in outcome once finished is closed.
+	done     chan batchOutcome
+	outcome  batchOutcome
+	finished chan struct{}
+}
+
+func newBatchItem(file string) *batchItem {
+	return &batchItem{file: file, parsed: make(chan struct{}), done: make(chan batchOutcome, 1), finished: make(chan struct{})}
+}
+
+func (item *batchItem) finish(result *AnalysisResult, err error) {
+	item.outcome = batchOutcome{item.file, result, err}
+	item.done <- item.outcome
+	close(item.finished)
+}
+
+// repeat finishes item, a duplicate of original, with the result of original once it is
+// analyzed.
+func (item *batchItem) repeat(p *pipeline, original *batchItem) {
+	<-original.finished
+	if err := original.outcome.err; err != nil {
+		item.finish(nil, fmt.Errorf("duplicate of %s, which could not be analyzed: %w", original.file, err))
+		return
+	}
+	item.finish(p.repeat(item.analysis, original.outcome.result))
+}
+
+// batchStage runs f on the items of in with the given number of workers, and sends the
+// items for which f returns true to the returned channel, which is closed once in is
+// closed and its items are done. f must finish the other items.
+func batchStage(workers int, in <-chan *batchItem, f func(*batchItem) bool) <-chan *batchItem {
+	out := make(chan *batchItem, workers)
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range in {
+				if f(item) {
+					out <- item
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
+
+func analyzeFile(ctx context.Context, p *pipeline, path string) (*AnalysisResult, error) {
+	rawMessage, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return p.analyze(ctx, rawMessage, path)
+}
+
+// collectMessageFiles expands paths into the message files to analyze: files are used
+// as given and directories are searched recursively for *.eml files, in lexical order.
+func collectMessageFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && strings.EqualFold(filepath.Ext(p), ".eml") {
+				files = append(files, p)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
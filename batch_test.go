@@ -0,0 +1,289 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/hook"
+)
+
+func TestCollectMessageFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.eml", "a/c.EML", "a/notes.txt", "single.msg"} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, nil, 0o600)
+	}
+
+	got, err := collectMessageFiles([]string{filepath.Join(dir, "single.msg"), dir})
+	if err != nil {
+		t.Fatalf("collectMessageFiles() error = %v", err)
+	}
+	want := []string{filepath.Join(dir, "single.msg"), filepath.Join(dir, "a/c.EML"), filepath.Join(dir, "b.eml")}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("collectMessageFiles() = %v, want %v", got, want)
+	}
+
+	if _, err := collectMessageFiles([]string{filepath.Join(dir, "missing")}); err == nil {
+		t.Error("expected an error for a missing path")
+	}
+}
+
+func TestAnalyzeFiles(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	dir := t.TempDir()
+	var files []string
+	for i := range 20 {
+		path := filepath.Join(dir, fmt.Sprintf("%02d.eml", i))
+		os.WriteFile(path, []byte(fmt.Sprintf("Message-ID: <%d@example.com>\r\nSubject: Test\r\n\r\nBody\r\n", i)), 0o600)
+		files = append(files, path)
+	}
+	files = append(files, filepath.Join(dir, "missing.eml"))
+
+	var got []batchOutcome
+	for o := range analyzeFiles(context.Background(), p, files, 4) {
+		got = append(got, o)
+	}
+	if len(got) != len(files) {
+		t.Fatalf("analyzeFiles() returned %d outcomes, want %d", len(got), len(files))
+	}
+	for i, o := range got[:20] {
+		if o.err != nil || o.file != files[i] || o.result.MessageID != fmt.Sprintf("%d@example.com", i) {
+			t.Errorf("outcome %d = %+v, want the result of %s", i, o, files[i])
+		}
+	}
+	if got[20].err == nil {
+		t.Error("expected an error for a missing file")
+	}
+
+	// After cancellation, no more files are started.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	n := 0
+	for range analyzeFiles(ctx, p, files, 4) {
+		n++
+	}
+	if n != 0 {
+		t.Errorf("analyzeFiles() with a canceled context returned %d outcomes", n)
+	}
+}
+
+func TestAnalyzeFiles_Timeout(t *testing.T) {
+	release := make(chan struct{})
+	llmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // The model never answers.
+	}))
+	defer llmServer.Close()
+	defer close(release)
+	cfg := &config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", MessageTimeout: config.Duration(50 * time.Millisecond)}
+	p, err := newPipeline(cfg, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	dir := t.TempDir()
+	var files []string
+	for i := range 3 {
+		path := filepath.Join(dir, fmt.Sprintf("%d.eml", i))
+		os.WriteFile(path, []byte(fmt.Sprintf("Subject: Test %d\r\n\r\nBody\r\n", i)), 0o600)
+		files = append(files, path)
+	}
+
+	// Each message is given up after the timeout, and the next one is analyzed.
+	n := 0
+	for o := range analyzeFiles(context.Background(), p, files, 1) {
+		n++
+		if o.err == nil || !strings.Contains(o.err.Error(), "gave up after 50ms") {
+			t.Errorf("outcome of %s: error = %v, want a timeout", o.file, o.err)
+		}
+	}
+	if n != len(files) {
+		t.Errorf("analyzeFiles() returned %d outcomes, want %d", n, len(files))
+	}
+
+	// At the deadline, the run stops although no message timed out.
+	cfg.MessageTimeout = 0
+	pf := pipelineFlags{deadline: time.Now().Add(50 * time.Millisecond)}
+	ctx, cancel := pf.withDeadline(context.Background())
+	defer cancel()
+	for range analyzeFiles(ctx, p, files, 1) {
+	}
+	err = stoppedError(ctx, "analyzing %d of %d messages", 0, len(files))
+	if !errors.Is(context.Cause(ctx), errDeadline) || err.Error() != "deadline reached after analyzing 0 of 3 messages" {
+		t.Errorf("stoppedError() at the deadline = %v", err)
+	}
+}
+
+func TestAnalyzeFiles_Stages(t *testing.T) {
+	// The model does not answer the first message until all of them are parsed, which
+	// they are, with a single worker, while the model is busy.
+	var mu sync.Mutex
+	parsed := 0
+	allParsed := make(chan struct{})
+	fake := newFakeLLM(t)
+	llmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-allParsed:
+		case <-time.After(2 * time.Second):
+			t.Error("the messages were not parsed while the model was busy")
+		}
+		time.Sleep(30 * time.Millisecond)
+		fake.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer llmServer.Close()
+	// Each message takes 30ms of its 50ms in the model, and the second one waits for longer
+	// than that, which is not counted against the timeout.
+	cfg := &config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", MessageTimeout: config.Duration(50 * time.Millisecond)}
+	p, err := newPipeline(cfg, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	p.hooks = hook.Chain{hook.Funcs{Before: func(ctx context.Context, e *email.ParsedEmail) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if parsed++; parsed == 2 {
+			close(allParsed)
+		}
+		return nil
+	}}}
+	dir := t.TempDir()
+	var files []string
+	for i := range 2 {
+		path := filepath.Join(dir, fmt.Sprintf("%d.eml", i))
+		os.WriteFile(path, []byte(fmt.Sprintf("Subject: Test %d\r\n\r\nBody\r\n", i)), 0o600)
+		files = append(files, path)
+	}
+
+	for o := range analyzeFiles(context.Background(), p, files, 1) {
+		if o.err != nil {
+			t.Errorf("outcome of %s: error = %v", o.file, o.err)
+		}
+	}
+}
+
+func TestAnalyzeFiles_Dedup(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	dir := t.TempDir()
+	message := "From: Prize <prize@example.com>\r\nSubject: You won\r\n\r\nClaim your prize at https://prize.example.com/\r\n"
+	messages := []string{
+		"Message-ID: <1@example.com>\r\n" + message,
+		// The same message, relayed by another server.
+		"Received: from relay.example.net\r\nMessage-ID: <1@example.com>\r\n" + message,
+		// The same content, reported by another recipient.
+		"Message-ID: <2@example.com>\r\nTo: bob@example.net\r\n" + strings.Replace(message, "Claim your", "Claim  your", 1) + "\r\n",
+		"Message-ID: <3@example.com>\r\nFrom: Bank <bank@example.org>\r\nSubject: Statement\r\n\r\nYour statement is ready.\r\n",
+	}
+	var files []string
+	for i, m := range messages {
+		path := filepath.Join(dir, fmt.Sprintf("%d.eml", i))
+		os.WriteFile(path, []byte(m), 0o600)
+		files = append(files, path)
+	}
+
+	for _, dedup := range []bool{true, false} {
+		before := len(llmServer.Requests())
+		p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{analyzeOnly: true, noDedup: !dedup})
+		if err != nil {
+			t.Fatalf("newPipeline() error = %v", err)
+		}
+		var got []*AnalysisResult
+		for o := range analyzeFiles(context.Background(), p, files, 2) {
+			if o.err != nil {
+				t.Fatalf("outcome of %s: error = %v", o.file, o.err)
+			}
+			got = append(got, o.result)
+		}
+
+		wantDuplicateOf := []string{"", files[0], files[0], ""}
+		wantRequests := 2
+		if !dedup {
+			wantDuplicateOf = []string{"", "", "", ""}
+			wantRequests = 4
+		}
+		for i, r := range got {
+			if r.DuplicateOf != wantDuplicateOf[i] || r.SourceFile != files[i] || r.Judgment.Category != "Phishing" {
+				t.Errorf("dedup %v: result %d = %+v, want a duplicate of %q", dedup, i, r, wantDuplicateOf[i])
+			}
+		}
+		if got[2].MessageID != "2@example.com" || !reflect.DeepEqual(got[2].To, []string{"<bob@example.net>"}) {
+			t.Errorf("dedup %v: the result of a duplicate has the headers of the original: %+v", dedup, got[2])
+		}
+		if n := len(llmServer.Requests()) - before; n != wantRequests {
+			t.Errorf("dedup %v: %d requests to the LLM, want %d", dedup, n, wantRequests)
+		}
+	}
+}
+
+func TestDedupKeys_Content(t *testing.T) {
+	p, err := newPipeline(&config.Config{}, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	message := func(attachment, image string) []byte {
+		return []byte("From: Billing <billing@example.com>\r\nSubject: Invoice\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nSee the attachment.\r\n" +
+			"--b\r\nContent-Type: image/png\r\nContent-Disposition: inline; filename=qr.png\r\n\r\n" + image + "\r\n" +
+			"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice.pdf\r\n\r\n" + attachment + "\r\n--b--\r\n")
+	}
+	key := func(raw []byte) string {
+		t.Helper()
+		a, err := p.parse(context.Background(), raw, "", nil)
+		if err != nil {
+			t.Fatalf("parse() error = %v", err)
+		}
+		return dedupKeys(a)[0]
+	}
+
+	original := key(message("%PDF-1 aaaa", "qr-1"))
+	if got := key(message("%PDF-1 aaaa", "qr-1")); got != original {
+		t.Errorf("the keys of copies differ: %q and %q", got, original)
+	}
+	// The same names and sizes, with other contents.
+	if got := key(message("%PDF-1 bbbb", "qr-1")); got == original {
+		t.Error("messages that differ in the content of an attachment have the same key")
+	}
+	if got := key(message("%PDF-1 aaaa", "qr-2")); got == original {
+		t.Error("messages that differ in the content of an image have the same key")
+	}
+}
+
+func TestParseDeadline(t *testing.T) {
+	now := time.Date(2025, 7, 2, 22, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "8h", want: now.Add(8 * time.Hour)},
+		{value: "2025-07-03T06:00:00Z", want: time.Date(2025, 7, 3, 6, 0, 0, 0, time.UTC)},
+		{value: "23:30", want: time.Date(2025, 7, 2, 23, 30, 0, 0, time.UTC)},
+		{value: "06:00", want: time.Date(2025, 7, 3, 6, 0, 0, 0, time.UTC)},
+		{value: "-1h", wantErr: true},
+		{value: "tomorrow", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDeadline(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseDeadline(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("parseDeadline(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"mail-analyzer/enrichment"
+	"mail-analyzer/vectorstore"
+)
+
+// runCache implements the "cache" command, which manages the pre-filter vector store
+// of previously judged messages (vector_store_path) and the cache of the lookups of the
+// enrichers (lookup_cache_dir).
+func runCache(args []string) error {
+	fs := newFlagSet("cache", "stats|clear",
+		"stats: Print the number of stored judgments per category, and of cached lookups.\n"+
+			"clear: Delete the vector store, so that every message is analyzed by the LLM again,\n"+
+			"and the cached lookups, so that they are made again.")
+	configPath := fs.String("config", "", configFlagHelp)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return usageErrorf("expected stats or clear")
+	}
+
+	cfg, err := loadConfig(*configPath)
+	if err != nil {
+		return err
+	}
+	if cfg.VectorStorePath == "" && cfg.LookupCacheDir == "" {
+		return errors.New("no cache is configured; set vector_store_path to enable the pre-filter, or lookup_cache_dir to keep the lookups of the enrichers")
+	}
+
+	switch fs.Arg(0) {
+	case "stats":
+		if cfg.VectorStorePath != "" {
+			key, err := storageKey(cfg)
+			if err != nil {
+				return err
+			}
+			store, err := vectorstore.OpenEncrypted(cfg.VectorStorePath, key)
+			if err != nil {
+				return err
+			}
+			counts := store.Categories()
+			categories := make([]string, 0, len(counts))
+			for c := range counts {
+				categories = append(categories, c)
+			}
+			sort.Strings(categories)
+
+			fmt.Printf("%s: %d entries\n", cfg.VectorStorePath, store.Len())
+			for _, c := range categories {
+				fmt.Printf("  %-12s %d\n", c, counts[c])
+			}
+		}
+		if cfg.LookupCacheDir != "" {
+			stats, err := enrichment.Stats(cfg.LookupCacheDir)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("%s: %d lookups, %d expired\n", cfg.LookupCacheDir, stats.Entries, stats.Expired)
+		}
+		return nil
+	case "clear":
+		if cfg.VectorStorePath != "" {
+			if err := os.Remove(cfg.VectorStorePath); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			fmt.Printf("Cleared %s\n", cfg.VectorStorePath)
+		}
+		if cfg.LookupCacheDir != "" {
+			if err := enrichment.Clear(cfg.LookupCacheDir); err != nil {
+				return err
+			}
+			fmt.Printf("Cleared %s\n", cfg.LookupCacheDir)
+		}
+		return nil
+	default:
+		return usageErrorf("unknown cache command %q", fs.Arg(0))
+	}
+}
@@ -0,0 +1,75 @@
+// Package classifier runs a local text classifier, such as a small ONNX model,
+// as an external command so that a verdict is available without network access.
+package classifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"mail-analyzer/llm"
+)
+
+// Provider sends the prompt to the classifier command on stdin and reads a JSON
+// result from stdout:
+//
+//	{"category": "Phishing", "confidence_score": 0.82}
+//
+// is_suspicious and reason may also be set; is_suspicious defaults to true for any
+// category other than "Safe". The command is executed directly, without a shell.
+type Provider struct {
+	command []string
+}
+
+// NewProvider creates a Provider for the given command and arguments.
+func NewProvider(command []string) (*Provider, error) {
+	if len(command) == 0 || command[0] == "" {
+		return nil, errors.New("classifier command is empty")
+	}
+	return &Provider{command: command}, nil
+}
+
+type result struct {
+	IsSuspicious    *bool   `json:"is_suspicious"`
+	Category        string  `json:"category"`
+	Reason          string  `json:"reason"`
+	ConfidenceScore float64 `json:"confidence_score"`
+}
+
+// AnalyzeText classifies the prompt. Tools are ignored; the classifier always reports a judgment.
+func (p *Provider) AnalyzeText(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+	cmd := exec.CommandContext(ctx, p.command[0], p.command[1:]...)
+	cmd.Stdin = strings.NewReader(prompt)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("classifier command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	var r result
+	if err := json.Unmarshal(stdout.Bytes(), &r); err != nil {
+		return nil, fmt.Errorf("could not decode classifier output: %w", err)
+	}
+	if r.Category == "" {
+		return nil, errors.New("classifier output has no category")
+	}
+
+	judgment := &llm.Judgment{
+		IsSuspicious:    r.Category != "Safe",
+		Category:        r.Category,
+		Reason:          r.Reason,
+		ConfidenceScore: r.ConfidenceScore,
+	}
+	if r.IsSuspicious != nil {
+		judgment.IsSuspicious = *r.IsSuspicious
+	}
+	if judgment.Reason == "" {
+		judgment.Reason = fmt.Sprintf("Classified as %s by the local classifier.", r.Category)
+	}
+	return judgment, nil
+}
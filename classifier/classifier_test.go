@@ -0,0 +1,64 @@
+package classifier
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mail-analyzer/llm"
+)
+
+// writeScript writes an executable shell script that prints output after reading stdin.
+func writeScript(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "classify.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\ncat > /dev/null\n"+body+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestProvider_AnalyzeText(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    *llm.Judgment
+		wantErr bool
+	}{
+		{
+			name:   "Category and score",
+			script: `echo '{"category": "Phishing", "confidence_score": 0.8}'`,
+			want:   &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Classified as Phishing by the local classifier.", ConfidenceScore: 0.8},
+		},
+		{
+			name:   "Safe with reason",
+			script: `echo '{"category": "Safe", "reason": "Known sender.", "confidence_score": 0.6}'`,
+			want:   &llm.Judgment{IsSuspicious: false, Category: "Safe", Reason: "Known sender.", ConfidenceScore: 0.6},
+		},
+		{name: "Missing category", script: `echo '{"confidence_score": 0.6}'`, wantErr: true},
+		{name: "Invalid output", script: `echo 'not json'`, wantErr: true},
+		{name: "Command fails", script: `echo 'model not found' >&2; exit 1`, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := NewProvider([]string{"/bin/sh", writeScript(t, tt.script)})
+			if err != nil {
+				t.Fatalf("NewProvider() error = %v", err)
+			}
+			got, err := p.AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AnalyzeText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("AnalyzeText() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := NewProvider(nil); err == nil {
+		t.Error("expected an error for an empty command")
+	}
+}
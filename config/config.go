@@ -2,48 +2,762 @@ package config
 
 import (
 	"encoding/json"
-	"os"
+	"errors"
+	"fmt"
+	"net/netip"
+	"strconv"
+	"time"
 
-	"github.com/kelseyhightower/envconfig"
+	"mail-analyzer/atrest"
 )
 
 // Config holds the application configuration.
 type Config struct {
+	// Provider is the LLM provider: "openai" (the default, which also serves any
+	// OpenAI-compatible endpoint), "anthropic", "ollama" or "bedrock". The settings of its
+	// section take precedence over the flat settings below, which are kept for earlier
+	// configuration files, and which hold the endpoint of the provider once the
+	// configuration is loaded. The sections of the other providers are ignored.
+	Provider  string           `json:"provider" envconfig:"LLM_PROVIDER"`
+	OpenAI    *OpenAIConfig    `json:"openai,omitempty" ignored:"true"`
+	Anthropic *AnthropicConfig `json:"anthropic,omitempty" ignored:"true"`
+	Ollama    *OllamaConfig    `json:"ollama,omitempty" ignored:"true"`
+	Bedrock   *BedrockConfig   `json:"bedrock,omitempty" ignored:"true"`
+
 	OpenAIAPIKey  string `json:"openai_api_key" envconfig:"OPENAI_API_KEY"`
 	OpenAIBaseURL string `json:"openai_base_url" envconfig:"OPENAI_BASE_URL"`
 	ModelName     string `json:"model_name" envconfig:"MODEL_NAME"`
+	// HeadersOnlyModel is the model of the header-only analyses of --headers-only,
+	// usually a faster and cheaper one of the same endpoint. Empty uses ModelName.
+	HeadersOnlyModel string `json:"headers_only_model" envconfig:"HEADERS_ONLY_MODEL"`
+	// OpenAIAPIKeyCommand is a command (program and arguments) that prints the API key,
+	// and OpenAIAPIKeyKeychain the service under which it is stored in the OS keychain,
+	// so that the key does not have to be stored in a file. They are only used when
+	// OpenAIAPIKey is empty.
+	OpenAIAPIKeyCommand  []string `json:"openai_api_key_command" envconfig:"OPENAI_API_KEY_COMMAND"`
+	OpenAIAPIKeyKeychain string   `json:"openai_api_key_keychain" envconfig:"OPENAI_API_KEY_KEYCHAIN"`
+	// ChatCompletionsPath is appended to OpenAIBaseURL unless the base URL already ends with it.
+	ChatCompletionsPath string `json:"chat_completions_path" envconfig:"CHAT_COMPLETIONS_PATH"`
+	// Optional OpenAI organization and project IDs sent as request headers.
+	OpenAIOrganization string `json:"openai_organization" envconfig:"OPENAI_ORG_ID"`
+	OpenAIProject      string `json:"openai_project" envconfig:"OPENAI_PROJECT_ID"`
+
+	// Network timeouts for the LLM API. A zero value disables the corresponding limit.
+	ConnectTimeout        Duration `json:"connect_timeout" envconfig:"CONNECT_TIMEOUT"`
+	ResponseHeaderTimeout Duration `json:"response_header_timeout" envconfig:"RESPONSE_HEADER_TIMEOUT"`
+	RequestTimeout        Duration `json:"request_timeout" envconfig:"REQUEST_TIMEOUT"`
+	// MessageTimeout bounds the total time spent analyzing a single message.
+	MessageTimeout Duration `json:"message_timeout" envconfig:"MESSAGE_TIMEOUT"`
+	// MaxParseTime, MaxDecodedBytes and MaxURLs limit the parsing of a message. A message
+	// that reaches them is analyzed from what was parsed so far, with warnings in its
+	// result. See email.Limits for the defaults.
+	MaxParseTime    Duration `json:"max_parse_time" envconfig:"MAX_PARSE_TIME"`
+	MaxDecodedBytes int64    `json:"max_decoded_bytes" envconfig:"MAX_DECODED_BYTES"`
+	MaxURLs         int      `json:"max_urls" envconfig:"MAX_URLS"`
+
+	// Stream enables server-sent event streaming of chat completions.
+	Stream bool `json:"stream" envconfig:"STREAM"`
+	// StreamIdleTimeout aborts a streaming response that delivers no data for this long.
+	StreamIdleTimeout Duration `json:"stream_idle_timeout" envconfig:"STREAM_IDLE_TIMEOUT"`
+
+	// PromptCacheControl adds explicit cache_control markers to the static system prompt
+	// and tool schema, for backends such as Anthropic that only cache marked prefixes.
+	PromptCacheControl bool `json:"prompt_cache_control" envconfig:"PROMPT_CACHE_CONTROL"`
+
+	// TemplatesDir is a directory of prompt and report templates that replace the built-in
+	// ones. See analyzer.Templates.
+	TemplatesDir string `json:"templates_dir" envconfig:"TEMPLATES_DIR"`
+	// TemplateSets are other templates directories, by name, which the clients of the
+	// servers can choose for a message instead of TemplatesDir.
+	TemplateSets map[string]string `json:"template_sets" ignored:"true"`
+	// OrgContextFile is a YAML file describing the organization, kept apart from this
+	// configuration so that the security team can maintain it. See org.Context.
+	OrgContextFile string `json:"org_context_file" envconfig:"ORG_CONTEXT_FILE"`
+	// Enrichments are the names of the enrichers whose facts are added to the prompt and
+	// the results, in order. See enrichment.FromConfig for the default.
+	Enrichments []string `json:"enrichments" envconfig:"ENRICHMENTS"`
+	// AuthServIDs are the authserv-ids of the Authentication-Results headers to trust,
+	// which are those added by the receiving mail servers. By default, the topmost header
+	// is trusted.
+	AuthServIDs []string `json:"auth_serv_ids" envconfig:"AUTH_SERV_IDS"`
+	// LookupCacheDir keeps the answers of the external lookups of the enrichers, such as
+	// DNS queries, across runs. Without it, they are only kept in memory. Answers expire
+	// after LookupCacheTTL, or LookupCacheNegativeTTL if nothing was found; see
+	// enrichment.NewCache for the defaults.
+	LookupCacheDir         string   `json:"lookup_cache_dir" envconfig:"LOOKUP_CACHE_DIR"`
+	LookupCacheTTL         Duration `json:"lookup_cache_ttl" envconfig:"LOOKUP_CACHE_TTL"`
+	LookupCacheNegativeTTL Duration `json:"lookup_cache_negative_ttl" envconfig:"LOOKUP_CACHE_NEGATIVE_TTL"`
+
+	// VectorStorePath enables the embedding-based pre-filter, which compares incoming
+	// messages against previously judged ones stored in this file.
+	VectorStorePath string `json:"vector_store_path" envconfig:"VECTOR_STORE_PATH"`
+	// VectorStoreMaxEntries is the number of judged messages kept in the vector store, the
+	// oldest being dropped first.
+	VectorStoreMaxEntries int `json:"vector_store_max_entries" envconfig:"VECTOR_STORE_MAX_ENTRIES"`
+	// StorageKey encrypts the content of the results databases and the vector store at
+	// rest. It must be random and at least atrest.MinKeyLength characters long. Like the
+	// API key, it may be stored in the OS keychain under StorageKeyKeychain instead, and is
+	// then only read from there when StorageKey is empty.
+	StorageKey         string `json:"storage_key" envconfig:"STORAGE_KEY"`
+	StorageKeyKeychain string `json:"storage_key_keychain" envconfig:"STORAGE_KEY_KEYCHAIN"`
+	// StorageAllowPlaintext reads the data that was stored in the clear, before StorageKey
+	// was set, which is otherwise an error, so that whoever can write to the stores cannot
+	// replace the encrypted data with forged plaintext. It is only meant for migrating.
+	StorageAllowPlaintext bool `json:"storage_allow_plaintext" envconfig:"STORAGE_ALLOW_PLAINTEXT"`
+	// EmbeddingModel is the model used by the embeddings API for the pre-filter.
+	EmbeddingModel string `json:"embedding_model" envconfig:"EMBEDDING_MODEL"`
+	// SimilarityThreshold is the cosine similarity above which a stored message is a match.
+	SimilarityThreshold float64 `json:"similarity_threshold" envconfig:"SIMILARITY_THRESHOLD"`
+	// PrefilterMode is "seed" to pass a matching judgment to the model, or "skip" to reuse
+	// it if it is suspicious.
+	PrefilterMode string `json:"prefilter_mode" envconfig:"PREFILTER_MODE"`
+
+	// FallbackClassifierCommand is a local classifier command (program and arguments) used
+	// when the LLM endpoint is unreachable. See package classifier for its protocol.
+	FallbackClassifierCommand []string `json:"fallback_classifier_command" envconfig:"FALLBACK_CLASSIFIER_COMMAND"`
+
+	// SyslogAddress enables the syslog sink, e.g. "udp://siem:514" or "tls://siem:6514".
+	SyslogAddress string `json:"syslog_address" envconfig:"SYSLOG_ADDRESS"`
+	// SyslogFormat is the event format, "cef" (the default) or "leef".
+	SyslogFormat string `json:"syslog_format" envconfig:"SYSLOG_FORMAT"`
+	// SyslogFieldMapping replaces the default mapping of event keys to result fields.
+	SyslogFieldMapping map[string]string `json:"syslog_field_mapping" envconfig:"SYSLOG_FIELD_MAPPING"`
+
+	// Splunk HTTP Event Collector sink. SplunkHECURL enables it and SplunkHECToken is required.
+	SplunkHECURL     string `json:"splunk_hec_url" envconfig:"SPLUNK_HEC_URL"`
+	SplunkHECToken   string `json:"splunk_hec_token" envconfig:"SPLUNK_HEC_TOKEN"`
+	SplunkIndex      string `json:"splunk_index" envconfig:"SPLUNK_INDEX"`
+	SplunkSourcetype string `json:"splunk_sourcetype" envconfig:"SPLUNK_SOURCETYPE"`
+	// SplunkBatchSize is the number of events sent per request.
+	SplunkBatchSize int `json:"splunk_batch_size" envconfig:"SPLUNK_BATCH_SIZE"`
+	// SplunkMaxRetries is the number of retries for transient HEC failures.
+	SplunkMaxRetries int `json:"splunk_max_retries" envconfig:"SPLUNK_MAX_RETRIES"`
+
+	// PostgresDSN stores every analysis in a shared PostgreSQL database. The schema is
+	// created and migrated automatically.
+	PostgresDSN string `json:"postgres_dsn" envconfig:"POSTGRES_DSN"`
+
+	// QueueURL is the message queue read by the worker command: redis:// or rediss:// for a
+	// Redis list, nats:// for NATS, or amqp:// or amqps:// for RabbitMQ and other AMQP 0-9-1
+	// brokers. Jobs are read from QueueJobs and results are published to QueueResults.
+	QueueURL     string `json:"queue_url" envconfig:"QUEUE_URL"`
+	QueueJobs    string `json:"queue_jobs" envconfig:"QUEUE_JOBS"`
+	QueueResults string `json:"queue_results" envconfig:"QUEUE_RESULTS"`
+
+	// WebhookURL enables the webhook sink, which POSTs each result as JSON.
+	WebhookURL string `json:"webhook_url" envconfig:"WEBHOOK_URL"`
+	// WebhookSecret signs request bodies with HMAC-SHA256.
+	WebhookSecret string `json:"webhook_secret" envconfig:"WEBHOOK_SECRET"`
+	// WebhookTemplate is a text/template file rendering the JSON body from the result.
+	WebhookTemplate string `json:"webhook_template" envconfig:"WEBHOOK_TEMPLATE"`
+	// WebhookOnlySuspicious and WebhookMinConfidence restrict which results are sent.
+	WebhookOnlySuspicious bool    `json:"webhook_only_suspicious" envconfig:"WEBHOOK_ONLY_SUSPICIOUS"`
+	WebhookMinConfidence  float64 `json:"webhook_min_confidence" envconfig:"WEBHOOK_MIN_CONFIDENCE"`
+	// WebhookMaxRetries is the number of retries for transient failures.
+	WebhookMaxRetries int `json:"webhook_max_retries" envconfig:"WEBHOOK_MAX_RETRIES"`
+
+	// Slack and Microsoft Teams incoming webhooks that receive an alert card for
+	// suspicious messages with at least AlertMinConfidence.
+	SlackWebhookURL    string  `json:"slack_webhook_url" envconfig:"SLACK_WEBHOOK_URL"`
+	TeamsWebhookURL    string  `json:"teams_webhook_url" envconfig:"TEAMS_WEBHOOK_URL"`
+	AlertMinConfidence float64 `json:"alert_min_confidence" envconfig:"ALERT_MIN_CONFIDENCE"`
+
+	// The servers send an outbreak alert to the sinks when OutbreakThreshold similar
+	// messages, which share a URL, their body or the network of their sender, arrive
+	// within OutbreakWindow. Zero disables the detection.
+	OutbreakThreshold int      `json:"outbreak_threshold" envconfig:"OUTBREAK_THRESHOLD"`
+	OutbreakWindow    Duration `json:"outbreak_window" envconfig:"OUTBREAK_WINDOW"`
+
+	// Actions are taken after analysis for matching verdicts, e.g. quarantining the
+	// source file. They can only be configured in the config file.
+	Actions []Action `json:"actions" ignored:"true"`
+	// AuditLog is a file to which the actions taken on the messages, and their deliveries
+	// to the webhook, chat and TheHive sinks, are appended as JSON Lines.
+	AuditLog string `json:"audit_log" envconfig:"AUDIT_LOG"`
+
+	// Hooks are commands run before each message is analyzed, to change the parsed
+	// message, and after, to change the judgment. They can only be configured in the
+	// config file.
+	Hooks []Hook `json:"hooks" ignored:"true"`
+
+	// AnalyzerPlugins are third-party analyzers, such as a custom ML model, whose verdicts
+	// are reported next to the judgment of the LLM. They can only be configured in the
+	// config file.
+	AnalyzerPlugins []AnalyzerPlugin `json:"analyzer_plugins" ignored:"true"`
+
+	// Policies override settings for the messages to the recipient domains of a tenant,
+	// so that one service can analyze the mail of several organizations. They can only be
+	// configured in the config file.
+	Policies []Policy `json:"policies" ignored:"true"`
+
+	// SandboxType enables the detonation of attachments in an external sandbox: "cape",
+	// "joe" (Joe Sandbox) or "hybrid_analysis". The hashes of the attachments are looked
+	// up at SandboxURL, and, if SandboxSubmit is set, the unknown files are uploaded. The
+	// verdicts are awaited for up to SandboxTimeout, checking every SandboxPollInterval,
+	// and a score of at least SandboxMinScore makes the message suspicious. See package
+	// sandbox for the default URLs.
+	SandboxType         string   `json:"sandbox_type" envconfig:"SANDBOX_TYPE"`
+	SandboxURL          string   `json:"sandbox_url" envconfig:"SANDBOX_URL"`
+	SandboxAPIKey       string   `json:"sandbox_api_key" envconfig:"SANDBOX_API_KEY"`
+	SandboxSubmit       bool     `json:"sandbox_submit" envconfig:"SANDBOX_SUBMIT"`
+	SandboxTimeout      Duration `json:"sandbox_timeout" envconfig:"SANDBOX_TIMEOUT"`
+	SandboxPollInterval Duration `json:"sandbox_poll_interval" envconfig:"SANDBOX_POLL_INTERVAL"`
+	SandboxMinScore     float64  `json:"sandbox_min_score" envconfig:"SANDBOX_MIN_SCORE"`
+
+	// AttachmentText adds the text of the attachments to the prompt, as extracted by the
+	// built-in converters of Word documents, Excel workbooks and HTML files, and by
+	// AttachmentConverters. The text of an attachment is truncated to
+	// AttachmentTextMaxBytes, unless its converter has a size of its own. See package
+	// attachtext.
+	AttachmentText         bool `json:"attachment_text" envconfig:"ATTACHMENT_TEXT"`
+	AttachmentTextMaxBytes int  `json:"attachment_text_max_bytes" envconfig:"ATTACHMENT_TEXT_MAX_BYTES"`
+	// AttachmentConverters convert other types of attachments, such as PDF files, or
+	// change the types or sizes of the built-in converters. They are tried before the
+	// built-in ones, and can only be configured in the config file.
+	AttachmentConverters []AttachmentConverter `json:"attachment_converters" ignored:"true"`
+	// OCRCommand is a command (program and arguments) that reads an image on its standard
+	// input and writes the text it recognizes, such as ["tesseract", "-", "-"]. It is run
+	// on the images of each message, and the text, truncated to OCRMaxBytes, is added to
+	// the prompt. Messages whose lure is only an image then do not reach the model with
+	// an empty body.
+	OCRCommand  []string `json:"ocr_command" envconfig:"OCR_COMMAND"`
+	OCRTimeout  Duration `json:"ocr_timeout" envconfig:"OCR_TIMEOUT"`
+	OCRMaxBytes int      `json:"ocr_max_bytes" envconfig:"OCR_MAX_BYTES"`
+
+	// IMAP account used by the "imap_junk" action. IMAPAddress has the form
+	// imaps://host:993 or imap://host:143 (which requires STARTTLS).
+	IMAPAddress     string `json:"imap_address" envconfig:"IMAP_ADDRESS"`
+	IMAPUsername    string `json:"imap_username" envconfig:"IMAP_USERNAME"`
+	IMAPPassword    string `json:"imap_password" envconfig:"IMAP_PASSWORD"`
+	IMAPMailbox     string `json:"imap_mailbox" envconfig:"IMAP_MAILBOX"`
+	IMAPJunkMailbox string `json:"imap_junk_mailbox" envconfig:"IMAP_JUNK_MAILBOX"`
+
+	// TheHiveURL enables alerts in TheHive for messages of TheHiveCategories with at
+	// least TheHiveMinConfidence. TheHiveAPIKey is required.
+	TheHiveURL           string   `json:"thehive_url" envconfig:"THEHIVE_URL"`
+	TheHiveAPIKey        string   `json:"thehive_api_key" envconfig:"THEHIVE_API_KEY"`
+	TheHiveOrganisation  string   `json:"thehive_organisation" envconfig:"THEHIVE_ORGANISATION"`
+	TheHiveCategories    []string `json:"thehive_categories" envconfig:"THEHIVE_CATEGORIES"`
+	TheHiveMinConfidence float64  `json:"thehive_min_confidence" envconfig:"THEHIVE_MIN_CONFIDENCE"`
+
+	// MaxConcurrentRequests limits the number of in-flight requests to the LLM provider.
+	// Zero (the default) means no limit.
+	MaxConcurrentRequests int `json:"max_concurrent_requests" envconfig:"MAX_CONCURRENT_REQUESTS"`
+
+	// MaxImages is the number of images from an email submitted to vision-capable models.
+	// Zero (the default) sends text only.
+	MaxImages int `json:"max_images" envconfig:"MAX_IMAGES"`
+
+	// DisableRepairRetry turns off the single corrective retry sent to the model
+	// when its output cannot be parsed or does not match the schema of the tool.
+	DisableRepairRetry bool `json:"disable_repair_retry" envconfig:"DISABLE_REPAIR_RETRY"`
+
+	// ProxyURL is an explicit proxy (http, https, socks5 or socks5h) for all outgoing
+	// requests. When empty, the standard HTTP_PROXY/HTTPS_PROXY/NO_PROXY variables apply.
+	ProxyURL string `json:"proxy_url" envconfig:"PROXY_URL"`
+	// NoProxy lists hosts that bypass ProxyURL, using the same syntax as NO_PROXY.
+	NoProxy string `json:"no_proxy" envconfig:"NO_PROXY"`
+
+	// TLS settings for endpoints behind a private PKI. CACertFile is a PEM bundle that is
+	// trusted in addition to the system roots; the client certificate enables mTLS.
+	CACertFile     string `json:"ca_cert_file" envconfig:"CA_CERT_FILE"`
+	ClientCertFile string `json:"client_cert_file" envconfig:"CLIENT_CERT_FILE"`
+	ClientKeyFile  string `json:"client_key_file" envconfig:"CLIENT_KEY_FILE"`
+
+	// Secret managers, for the secrets given as references such as
+	// "vault://secret/data/mail-analyzer#openai_api_key". See package secret.
+	VaultAddr      string `json:"vault_addr" envconfig:"VAULT_ADDR"`
+	VaultToken     string `json:"vault_token" envconfig:"VAULT_TOKEN"`
+	VaultTokenFile string `json:"vault_token_file" envconfig:"VAULT_TOKEN_FILE"`
+	VaultNamespace string `json:"vault_namespace" envconfig:"VAULT_NAMESPACE"`
+	AWSRegion      string `json:"aws_region" envconfig:"AWS_REGION"`
+	// SecretRefreshInterval is how long a secret from a secret manager is used before it
+	// is fetched again.
+	SecretRefreshInterval Duration `json:"secret_refresh_interval" envconfig:"SECRET_REFRESH_INTERVAL"`
 }
 
-// Load loads configuration from a file, then overrides with environment variables.
-func Load(path string) (*Config, error) {
-	var cfg Config
+// Default values applied by Load when a setting is not configured.
+const (
+	DefaultModelName           = "gpt-4-turbo"
+	DefaultChatCompletionsPath = "/chat/completions"
+	DefaultConnectTimeout      = Duration(30 * time.Second)
+	DefaultRequestTimeout      = Duration(90 * time.Second)
+
+	DefaultStreamIdleTimeout = Duration(30 * time.Second)
+
+	DefaultEmbeddingModel        = "text-embedding-3-small"
+	DefaultSimilarityThreshold   = 0.97
+	DefaultPrefilterMode         = "seed"
+	DefaultVectorStoreMaxEntries = 10000
+
+	DefaultSplunkSourcetype = "mail-analyzer:result"
+	DefaultSplunkBatchSize  = 50
+	DefaultSplunkMaxRetries = 3
+
+	DefaultWebhookMaxRetries = 3
+
+	DefaultQueueJobs    = "mail-analyzer.jobs"
+	DefaultQueueResults = "mail-analyzer.results"
+
+	DefaultTheHiveMinConfidence = 0.8
+
+	DefaultOutbreakWindow = Duration(10 * time.Minute)
+
+	DefaultSandboxTimeout      = Duration(5 * time.Minute)
+	DefaultSandboxPollInterval = Duration(15 * time.Second)
+	DefaultSandboxMinScore     = 0.7
+
+	DefaultIMAPMailbox     = "INBOX"
+	DefaultIMAPJunkMailbox = "Junk"
+
+	DefaultHookTimeout = Duration(30 * time.Second)
 
-	// Load from file first.
-	if path != "" {
-		file, err := os.Open(path)
+	DefaultPluginTimeout = Duration(30 * time.Second)
+
+	DefaultAttachmentTextMaxBytes = 2000
+	DefaultConverterTimeout       = Duration(30 * time.Second)
+)
+
+// Sandbox types.
+const (
+	SandboxCAPE           = "cape"
+	SandboxJoe            = "joe"
+	SandboxHybridAnalysis = "hybrid_analysis"
+)
+
+// Action types.
+const (
+	ActionMove     = "move"
+	ActionCopy     = "copy"
+	ActionIMAPJunk = "imap_junk"
+	ActionCommand  = "command"
+	ActionARF      = "arf"
+)
+
+// DefaultRDAPURL is the RDAP service that finds the abuse contact of a network for the
+// arf action.
+const DefaultRDAPURL = "https://rdap.org"
+
+// Action is a post-analysis action taken for results matching When and MinConfidence.
+type Action struct {
+	// When is "suspicious", "safe", "any" or a category name (case-insensitive).
+	When          string  `json:"when"`
+	MinConfidence float64 `json:"min_confidence"`
+	// Type is one of move, copy, imap_junk, command or arf.
+	Type string `json:"type"`
+	// Dir is the quarantine directory for move and copy.
+	Dir string `json:"dir,omitempty"`
+	// Command is the program and arguments run by the command action.
+	Command []string `json:"command,omitempty"`
+	// From is the sender of the abuse reports of the arf action, and To their recipients,
+	// which are otherwise the abuse contact of the network that sent the message, found
+	// with the RDAP service at RDAPURL.
+	From    string   `json:"from,omitempty"`
+	To      []string `json:"to,omitempty"`
+	RDAPURL string   `json:"rdap_url,omitempty"`
+	// Relay is the host:port of the SMTP server that the arf action sends the reports
+	// through, instead of the sendmail command.
+	Relay string `json:"relay,omitempty"`
+	// HeadersOnly is whether the reports of the arf action attach the header of the
+	// message alone, without the fields that name its recipients, rather than the whole
+	// message, whose body may name them too.
+	HeadersOnly bool `json:"headers_only,omitempty"`
+	// TrustedRelays are the addresses or networks (CIDR) of the servers that relay the
+	// mail to the recipients, such as a hosted filter, whose hops the arf action skips
+	// when it looks for the network that sent the message.
+	TrustedRelays []string `json:"trusted_relays,omitempty"`
+}
+
+// validate reports configuration errors in a.
+func (a *Action) validate() error {
+	if a.When == "" {
+		return fmt.Errorf("action %q: when is required", a.Type)
+	}
+	switch a.Type {
+	case ActionMove, ActionCopy:
+		if a.Dir == "" {
+			return fmt.Errorf("action %q: dir is required", a.Type)
+		}
+	case ActionIMAPJunk:
+	case ActionCommand:
+		if len(a.Command) == 0 || a.Command[0] == "" {
+			return fmt.Errorf("action %q: command is required", a.Type)
+		}
+	case ActionARF:
+		if a.From == "" {
+			return fmt.Errorf("action %q: from is required", a.Type)
+		}
+		if _, err := a.TrustedRelayPrefixes(); err != nil {
+			return fmt.Errorf("action %q: %w", a.Type, err)
+		}
+	default:
+		return fmt.Errorf("unknown action type %q", a.Type)
+	}
+	return nil
+}
+
+// TrustedRelayPrefixes returns the TrustedRelays of a as networks, an address being the
+// network of its own length.
+func (a *Action) TrustedRelayPrefixes() ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, relay := range a.TrustedRelays {
+		if addr, err := netip.ParseAddr(relay); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(relay)
 		if err != nil {
-			// Ignore file not found errors, as the path may not always exist.
-			if !os.IsNotExist(err) {
-				return nil, err
-			}
-		} else {
-			defer file.Close()
-			if err := json.NewDecoder(file).Decode(&cfg); err != nil {
-				return nil, err
-			}
+			return nil, fmt.Errorf("invalid trusted relay %q", relay)
 		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Hook stages.
+const (
+	HookBefore = "before"
+	HookAfter  = "after"
+)
+
+// Hook is a command run before or after the analysis of each message. See the hook
+// package.
+type Hook struct {
+	// Stage is "before" or "after".
+	Stage string `json:"stage"`
+	// Command is the program and arguments of the hook.
+	Command []string `json:"command"`
+	// Timeout is the time the command may take. Defaults to DefaultHookTimeout.
+	Timeout Duration `json:"timeout,omitempty"`
+	// IgnoreErrors logs the failures of the hook and goes on with the message unchanged,
+	// instead of failing its analysis.
+	IgnoreErrors bool `json:"ignore_errors,omitempty"`
+}
+
+// validate reports configuration errors in h.
+func (h *Hook) validate() error {
+	if h.Stage != HookBefore && h.Stage != HookAfter {
+		return fmt.Errorf("hook %q: stage must be %q or %q", h.Command, HookBefore, HookAfter)
+	}
+	if len(h.Command) == 0 || h.Command[0] == "" {
+		return fmt.Errorf("%s hook: command is required", h.Stage)
+	}
+	if h.Timeout < 0 {
+		return fmt.Errorf("hook %q: timeout must not be negative", h.Command)
+	}
+	return nil
+}
+
+// AnalyzerPlugin is a command that analyzes each message next to the LLM. See the plugin
+// package.
+type AnalyzerPlugin struct {
+	// Name identifies the plugin in the results.
+	Name string `json:"name"`
+	// Command is the program and arguments of the plugin.
+	Command []string `json:"command"`
+	// Persistent keeps one process of the command running, which answers one request per
+	// line, instead of running the command for each message. It suits models that are
+	// slow to load.
+	Persistent bool `json:"persistent,omitempty"`
+	// Timeout is the time the plugin may take for a message. Defaults to
+	// DefaultPluginTimeout.
+	Timeout Duration `json:"timeout,omitempty"`
+}
+
+// validate reports configuration errors in a.
+func (a *AnalyzerPlugin) validate() error {
+	if a.Name == "" {
+		return fmt.Errorf("analyzer plugin %q: name is required", a.Command)
+	}
+	if len(a.Command) == 0 || a.Command[0] == "" {
+		return fmt.Errorf("analyzer plugin %q: command is required", a.Name)
+	}
+	if a.Timeout < 0 {
+		return fmt.Errorf("analyzer plugin %q: timeout must not be negative", a.Name)
 	}
+	return nil
+}
+
+// Built-in attachment converters.
+const (
+	ConverterDOCX = "docx"
+	ConverterXLSX = "xlsx"
+	ConverterHTML = "html"
+)
+
+// AttachmentConverter extracts the text of the attachments of some types, with a command
+// or a built-in converter.
+type AttachmentConverter struct {
+	// Types are the media types, such as "application/pdf", and the file name
+	// extensions, such as ".pdf", of the attachments that the converter reads.
+	Types []string `json:"types"`
+	// Command is the program and arguments of a command that reads an attachment on its
+	// standard input and writes its text on its standard output, such as
+	// ["pdftotext", "-", "-"].
+	Command []string `json:"command,omitempty"`
+	// Builtin is the built-in converter to use instead of a command: "docx", "xlsx" or
+	// "html".
+	Builtin string `json:"builtin,omitempty"`
+	// MaxBytes is the length in bytes of the text beyond which it is truncated. Defaults
+	// to AttachmentTextMaxBytes.
+	MaxBytes int `json:"max_bytes,omitempty"`
+	// Timeout is the time the command may take for an attachment. Defaults to
+	// DefaultConverterTimeout.
+	Timeout Duration `json:"timeout,omitempty"`
+}
 
-	// Now, process environment variables. This will override any fields with values
-	// from the environment.
-	if err := envconfig.Process("", &cfg); err != nil {
+// validate reports configuration errors in c.
+func (c *AttachmentConverter) validate() error {
+	if len(c.Types) == 0 {
+		return errors.New("attachment converter: types are required")
+	}
+	switch {
+	case len(c.Command) > 0 && c.Builtin != "":
+		return fmt.Errorf("attachment converter for %q: command and builtin are exclusive", c.Types)
+	case c.Builtin != "":
+		if c.Builtin != ConverterDOCX && c.Builtin != ConverterXLSX && c.Builtin != ConverterHTML {
+			return fmt.Errorf("attachment converter for %q: unknown builtin %q; expected %s, %s or %s", c.Types, c.Builtin, ConverterDOCX, ConverterXLSX, ConverterHTML)
+		}
+	case len(c.Command) == 0 || c.Command[0] == "":
+		return fmt.Errorf("attachment converter for %q: command or builtin is required", c.Types)
+	}
+	if c.MaxBytes < 0 || c.Timeout < 0 {
+		return fmt.Errorf("attachment converter for %q: max_bytes and timeout must not be negative", c.Types)
+	}
+	return nil
+}
+
+// Duration is a time.Duration that can be configured as a Go duration string
+// (e.g. "90s", "2m") or as a number of seconds.
+type Duration time.Duration
+
+// UnmarshalJSON implements json.Unmarshaler.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch value := v.(type) {
+	case float64:
+		*d = Duration(value * float64(time.Second))
+		return nil
+	case string:
+		return d.Decode(value)
+	default:
+		return fmt.Errorf("invalid duration: %s", string(b))
+	}
+}
+
+// MarshalJSON implements json.Marshaler.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Decode implements envconfig.Decoder.
+func (d *Duration) Decode(value string) error {
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil {
+		*d = Duration(seconds * float64(time.Second))
+		return nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %w", value, err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// ErrConfig is wrapped by the errors of Load, LoadFiles and Resolve: a configuration file
+// cannot be read or decoded, or the settings are invalid.
+var ErrConfig = errors.New("error loading configuration")
+
+// Load loads configuration from a file, then overrides with environment variables.
+func Load(path string) (*Config, error) {
+	if path == "" {
+		return LoadFiles()
+	}
+	return LoadFiles(path)
+}
+
+// LoadFiles loads configuration from files, each overriding the settings of the previous
+// ones that it sets, then overrides with environment variables. Missing files are skipped.
+func LoadFiles(paths ...string) (*Config, error) {
+	r, err := Resolve(paths...)
+	if err != nil {
 		return nil, err
 	}
+	return r.Config, nil
+}
 
-	// Manually set default for ModelName if it's still empty.
-	if cfg.ModelName == "" {
-		cfg.ModelName = "gpt-4-turbo"
+// applyDefaults sets the settings that are still empty to their defaults, and checks the
+// settings that depend on each other.
+func applyDefaults(cfg *Config) error {
+	// Manually set defaults for settings that are still empty.
+	if err := applyProviderDefaults(cfg); err != nil {
+		return err
+	}
+	if cfg.ChatCompletionsPath == "" {
+		cfg.ChatCompletionsPath = DefaultChatCompletionsPath
+	}
+	if cfg.ConnectTimeout == 0 {
+		cfg.ConnectTimeout = DefaultConnectTimeout
+	}
+	if cfg.RequestTimeout == 0 {
+		cfg.RequestTimeout = DefaultRequestTimeout
+	}
+	if cfg.StreamIdleTimeout == 0 {
+		cfg.StreamIdleTimeout = DefaultStreamIdleTimeout
+	}
+	if cfg.SplunkHECURL != "" {
+		if cfg.SplunkSourcetype == "" {
+			cfg.SplunkSourcetype = DefaultSplunkSourcetype
+		}
+		if cfg.SplunkBatchSize == 0 {
+			cfg.SplunkBatchSize = DefaultSplunkBatchSize
+		}
+		if cfg.SplunkMaxRetries == 0 {
+			cfg.SplunkMaxRetries = DefaultSplunkMaxRetries
+		}
+	}
+	if cfg.WebhookURL != "" && cfg.WebhookMaxRetries == 0 {
+		cfg.WebhookMaxRetries = DefaultWebhookMaxRetries
+	}
+	if cfg.QueueURL != "" {
+		if cfg.QueueJobs == "" {
+			cfg.QueueJobs = DefaultQueueJobs
+		}
+		if cfg.QueueResults == "" {
+			cfg.QueueResults = DefaultQueueResults
+		}
+	}
+	if cfg.OutbreakThreshold > 0 && cfg.OutbreakWindow == 0 {
+		cfg.OutbreakWindow = DefaultOutbreakWindow
+	}
+	if cfg.SandboxType != "" {
+		switch cfg.SandboxType {
+		case SandboxCAPE, SandboxJoe, SandboxHybridAnalysis:
+		default:
+			return fmt.Errorf("unknown sandbox_type %q", cfg.SandboxType)
+		}
+		if cfg.SandboxTimeout == 0 {
+			cfg.SandboxTimeout = DefaultSandboxTimeout
+		}
+		if cfg.SandboxPollInterval == 0 {
+			cfg.SandboxPollInterval = DefaultSandboxPollInterval
+		}
+		if cfg.SandboxMinScore == 0 {
+			cfg.SandboxMinScore = DefaultSandboxMinScore
+		}
+	}
+	if cfg.TheHiveURL != "" {
+		if len(cfg.TheHiveCategories) == 0 {
+			cfg.TheHiveCategories = []string{"Phishing"}
+		}
+		if cfg.TheHiveMinConfidence == 0 {
+			cfg.TheHiveMinConfidence = DefaultTheHiveMinConfidence
+		}
+	}
+	if cfg.IMAPAddress != "" {
+		if cfg.IMAPMailbox == "" {
+			cfg.IMAPMailbox = DefaultIMAPMailbox
+		}
+		if cfg.IMAPJunkMailbox == "" {
+			cfg.IMAPJunkMailbox = DefaultIMAPJunkMailbox
+		}
+	}
+	for i := range cfg.Actions {
+		if err := cfg.Actions[i].validate(); err != nil {
+			return err
+		}
+		if cfg.Actions[i].Type == ActionIMAPJunk && cfg.IMAPAddress == "" {
+			return errors.New("the imap_junk action requires imap_address")
+		}
+		if cfg.Actions[i].Type == ActionARF && cfg.Actions[i].RDAPURL == "" {
+			cfg.Actions[i].RDAPURL = DefaultRDAPURL
+		}
+	}
+	for i := range cfg.Hooks {
+		if err := cfg.Hooks[i].validate(); err != nil {
+			return err
+		}
+		if cfg.Hooks[i].Timeout == 0 {
+			cfg.Hooks[i].Timeout = DefaultHookTimeout
+		}
+	}
+	if len(cfg.AttachmentConverters) > 0 && !cfg.AttachmentText {
+		return errors.New("attachment_converters require attachment_text")
+	}
+	if cfg.AttachmentTextMaxBytes < 0 {
+		return errors.New("attachment_text_max_bytes must not be negative")
+	}
+	if cfg.AttachmentText && cfg.AttachmentTextMaxBytes == 0 {
+		cfg.AttachmentTextMaxBytes = DefaultAttachmentTextMaxBytes
+	}
+	for i := range cfg.AttachmentConverters {
+		c := &cfg.AttachmentConverters[i]
+		if err := c.validate(); err != nil {
+			return err
+		}
+		if c.MaxBytes == 0 {
+			c.MaxBytes = cfg.AttachmentTextMaxBytes
+		}
+		if c.Timeout == 0 && c.Builtin == "" {
+			c.Timeout = DefaultConverterTimeout
+		}
+	}
+	if len(cfg.OCRCommand) > 0 && cfg.OCRCommand[0] == "" {
+		return errors.New("ocr_command: the program is empty")
+	}
+	if cfg.OCRTimeout < 0 || cfg.OCRMaxBytes < 0 {
+		return errors.New("ocr_timeout and ocr_max_bytes must not be negative")
+	}
+	if len(cfg.OCRCommand) > 0 {
+		if cfg.OCRTimeout == 0 {
+			cfg.OCRTimeout = DefaultConverterTimeout
+		}
+		if cfg.OCRMaxBytes == 0 {
+			cfg.OCRMaxBytes = DefaultAttachmentTextMaxBytes
+		}
+	}
+	plugins := map[string]bool{}
+	for i := range cfg.AnalyzerPlugins {
+		a := &cfg.AnalyzerPlugins[i]
+		if err := a.validate(); err != nil {
+			return err
+		}
+		if plugins[a.Name] {
+			return fmt.Errorf("duplicate analyzer plugin %q", a.Name)
+		}
+		plugins[a.Name] = true
+		if a.Timeout == 0 {
+			a.Timeout = DefaultPluginTimeout
+		}
+	}
+	// Pre-filter settings only matter once a vector store is configured.
+	if cfg.VectorStorePath != "" {
+		if cfg.EmbeddingModel == "" {
+			cfg.EmbeddingModel = DefaultEmbeddingModel
+		}
+		if cfg.SimilarityThreshold == 0 {
+			cfg.SimilarityThreshold = DefaultSimilarityThreshold
+		}
+		if cfg.VectorStoreMaxEntries == 0 {
+			cfg.VectorStoreMaxEntries = DefaultVectorStoreMaxEntries
+		}
+		if cfg.PrefilterMode == "" {
+			cfg.PrefilterMode = DefaultPrefilterMode
+		}
+		if cfg.PrefilterMode != "skip" && cfg.PrefilterMode != "seed" {
+			return fmt.Errorf("invalid prefilter_mode %q: must be \"skip\" or \"seed\"", cfg.PrefilterMode)
+		}
+	}
+	if cfg.StorageKey != "" && len(cfg.StorageKey) < atrest.MinKeyLength {
+		return fmt.Errorf("storage_key must be at least %d characters long; generate one with \"openssl rand -base64 32\"", atrest.MinKeyLength)
+	}
+	if err := validatePolicies(cfg); err != nil {
+		return err
 	}
 
-	return &cfg, nil
+	return nil
 }
@@ -1,18 +1,20 @@
 package config
 
 import (
+	"errors"
 	"os"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestLoad(t *testing.T) {
 	tests := []struct {
-		name     string
-		setup    func(t *testing.T) string // Returns the path to the config file, if any
-		want     *Config
-		wantErr  bool
+		name    string
+		setup   func(t *testing.T) string // Returns the path to the config file, if any
+		want    *Config
+		wantErr bool
 	}{
 		{
 			name: "Defaults and API Key from Env",
@@ -22,9 +24,14 @@ func TestLoad(t *testing.T) {
 				return ""
 			},
 			want: &Config{
-				OpenAIAPIKey:  "env-key",
-				OpenAIBaseURL: "https://api.example.com/v1",
-				ModelName:     "gpt-4-turbo",
+				Provider:            ProviderOpenAI,
+				OpenAIAPIKey:        "env-key",
+				OpenAIBaseURL:       "https://api.example.com/v1",
+				ModelName:           "gpt-4-turbo",
+				ChatCompletionsPath: DefaultChatCompletionsPath,
+				ConnectTimeout:      DefaultConnectTimeout,
+				RequestTimeout:      DefaultRequestTimeout,
+				StreamIdleTimeout:   DefaultStreamIdleTimeout,
 			},
 		},
 		{
@@ -45,9 +52,14 @@ func TestLoad(t *testing.T) {
 				return tmpfile.Name()
 			},
 			want: &Config{
-				OpenAIAPIKey:  "file-key",
-				OpenAIBaseURL: "http://localhost:8080",
-				ModelName:     "test-model",
+				Provider:            ProviderOpenAI,
+				OpenAIAPIKey:        "file-key",
+				OpenAIBaseURL:       "http://localhost:8080",
+				ModelName:           "test-model",
+				ChatCompletionsPath: DefaultChatCompletionsPath,
+				ConnectTimeout:      DefaultConnectTimeout,
+				RequestTimeout:      DefaultRequestTimeout,
+				StreamIdleTimeout:   DefaultStreamIdleTimeout,
 			},
 		},
 		{
@@ -66,11 +78,234 @@ func TestLoad(t *testing.T) {
 				return tmpfile.Name()
 			},
 			want: &Config{
-				OpenAIAPIKey:  "env-key-override",
-				OpenAIBaseURL: "", // Not set in file or env
-				ModelName:     "env-model-override",
+				Provider:            ProviderOpenAI,
+				OpenAIAPIKey:        "env-key-override",
+				OpenAIBaseURL:       "", // Not set in file or env
+				ModelName:           "env-model-override",
+				ChatCompletionsPath: DefaultChatCompletionsPath,
+				ConnectTimeout:      DefaultConnectTimeout,
+				RequestTimeout:      DefaultRequestTimeout,
+				StreamIdleTimeout:   DefaultStreamIdleTimeout,
 			},
 		},
+		{
+			name: "Timeouts from File and Env",
+			setup: func(t *testing.T) string {
+				content := `{"connect_timeout": 5, "request_timeout": "2m", "message_timeout": "150s"}`
+				tmpfile, err := os.CreateTemp("", "config-*.json")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+				tmpfile.Write([]byte(content))
+				tmpfile.Close()
+				t.Setenv("RESPONSE_HEADER_TIMEOUT", "45s")
+				return tmpfile.Name()
+			},
+			want: &Config{
+				Provider:              ProviderOpenAI,
+				ModelName:             "gpt-4-turbo",
+				ChatCompletionsPath:   DefaultChatCompletionsPath,
+				ConnectTimeout:        Duration(5 * time.Second),
+				ResponseHeaderTimeout: Duration(45 * time.Second),
+				RequestTimeout:        Duration(2 * time.Minute),
+				MessageTimeout:        Duration(150 * time.Second),
+				StreamIdleTimeout:     DefaultStreamIdleTimeout,
+			},
+		},
+		{
+			name: "Actions and IMAP Defaults",
+			setup: func(t *testing.T) string {
+				content := `{"imap_address": "imaps://mail.example.com", "actions": [{"when": "Phishing", "min_confidence": 0.8, "type": "imap_junk"}, {"when": "Phishing", "type": "arf", "from": "reports@example.com"}]}`
+				tmpfile, err := os.CreateTemp("", "config-*.json")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+				tmpfile.Write([]byte(content))
+				tmpfile.Close()
+				return tmpfile.Name()
+			},
+			want: &Config{
+				Provider:            ProviderOpenAI,
+				ModelName:           "gpt-4-turbo",
+				ChatCompletionsPath: DefaultChatCompletionsPath,
+				ConnectTimeout:      DefaultConnectTimeout,
+				RequestTimeout:      DefaultRequestTimeout,
+				StreamIdleTimeout:   DefaultStreamIdleTimeout,
+				Actions: []Action{
+					{When: "Phishing", MinConfidence: 0.8, Type: ActionIMAPJunk},
+					{When: "Phishing", Type: ActionARF, From: "reports@example.com", RDAPURL: DefaultRDAPURL},
+				},
+				IMAPAddress:     "imaps://mail.example.com",
+				IMAPMailbox:     DefaultIMAPMailbox,
+				IMAPJunkMailbox: DefaultIMAPJunkMailbox,
+			},
+		},
+		{
+			name: "Move Action Without Dir",
+			setup: func(t *testing.T) string {
+				tmpfile, err := os.CreateTemp("", "config-*.json")
+				if err != nil {
+					t.Fatal(err)
+				}
+				t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+				tmpfile.Write([]byte(`{"actions": [{"when": "suspicious", "type": "move"}]}`))
+				tmpfile.Close()
+				return tmpfile.Name()
+			},
+			wantErr: true,
+		},
+		{
+			name: "ARF Action Without From",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"actions": [{"when": "Phishing", "type": "arf"}]}`), 0o600)
+				return path
+			},
+			wantErr: true,
+		},
+		{
+			name: "ARF Action With Invalid Trusted Relay",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"actions": [{"when": "Phishing", "type": "arf", "from": "reports@example.com", "trusted_relays": ["192.0.2.0/24", "filter.example.com"]}]}`), 0o600)
+				return path
+			},
+			wantErr: true,
+		},
+		{
+			name: "Sandbox Defaults",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"sandbox_type": "joe", "sandbox_api_key": "jbx"}`), 0o600)
+				return path
+			},
+			want: &Config{
+				Provider:            ProviderOpenAI,
+				ModelName:           "gpt-4-turbo",
+				ChatCompletionsPath: DefaultChatCompletionsPath,
+				ConnectTimeout:      DefaultConnectTimeout,
+				RequestTimeout:      DefaultRequestTimeout,
+				StreamIdleTimeout:   DefaultStreamIdleTimeout,
+				SandboxType:         SandboxJoe,
+				SandboxAPIKey:       "jbx",
+				SandboxTimeout:      DefaultSandboxTimeout,
+				SandboxPollInterval: DefaultSandboxPollInterval,
+				SandboxMinScore:     DefaultSandboxMinScore,
+			},
+		},
+		{
+			name: "Unknown Sandbox Type",
+			setup: func(t *testing.T) string {
+				t.Setenv("SANDBOX_TYPE", "cuckoo")
+				return ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "Hook Without Stage",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"hooks": [{"command": ["/bin/enrich"]}]}`), 0o600)
+				return path
+			},
+			wantErr: true,
+		},
+		{
+			name: "Duplicate Analyzer Plugin",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"analyzer_plugins": [{"name": "ml", "command": ["/bin/a"]}, {"name": "ml", "command": ["/bin/b"]}]}`), 0o600)
+				return path
+			},
+			wantErr: true,
+		},
+		{
+			name: "Attachment Converters",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"attachment_text": true, "attachment_converters": [`+
+					`{"types": [".pdf"], "command": ["pdftotext", "-", "-"]}, {"types": [".docm"], "builtin": "docx", "max_bytes": 8000}]}`), 0o600)
+				return path
+			},
+			want: &Config{
+				Provider:               ProviderOpenAI,
+				ModelName:              "gpt-4-turbo",
+				ChatCompletionsPath:    DefaultChatCompletionsPath,
+				ConnectTimeout:         DefaultConnectTimeout,
+				RequestTimeout:         DefaultRequestTimeout,
+				StreamIdleTimeout:      DefaultStreamIdleTimeout,
+				AttachmentText:         true,
+				AttachmentTextMaxBytes: DefaultAttachmentTextMaxBytes,
+				AttachmentConverters: []AttachmentConverter{
+					{Types: []string{".pdf"}, Command: []string{"pdftotext", "-", "-"}, MaxBytes: DefaultAttachmentTextMaxBytes, Timeout: DefaultConverterTimeout},
+					{Types: []string{".docm"}, Builtin: ConverterDOCX, MaxBytes: 8000},
+				},
+			},
+		},
+		{
+			name: "Attachment Converter Without Command",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"attachment_text": true, "attachment_converters": [{"types": [".pdf"]}]}`), 0o600)
+				return path
+			},
+			wantErr: true,
+		},
+		{
+			name: "Attachment Converters Without Attachment Text",
+			setup: func(t *testing.T) string {
+				path := t.TempDir() + "/config.json"
+				os.WriteFile(path, []byte(`{"attachment_converters": [{"types": [".rtf"], "builtin": "html"}]}`), 0o600)
+				return path
+			},
+			wantErr: true,
+		},
+		{
+			name: "OCR Command",
+			setup: func(t *testing.T) string {
+				t.Setenv("OCR_COMMAND", "tesseract,-,-")
+				t.Setenv("OCR_MAX_BYTES", "500")
+				return ""
+			},
+			want: &Config{
+				Provider:            ProviderOpenAI,
+				ModelName:           "gpt-4-turbo",
+				ChatCompletionsPath: DefaultChatCompletionsPath,
+				ConnectTimeout:      DefaultConnectTimeout,
+				RequestTimeout:      DefaultRequestTimeout,
+				StreamIdleTimeout:   DefaultStreamIdleTimeout,
+				OCRCommand:          []string{"tesseract", "-", "-"},
+				OCRTimeout:          DefaultConverterTimeout,
+				OCRMaxBytes:         500,
+			},
+		},
+		{
+			name: "Negative OCR Timeout",
+			setup: func(t *testing.T) string {
+				t.Setenv("OCR_COMMAND", "tesseract")
+				t.Setenv("OCR_TIMEOUT", "-1s")
+				return ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "Short Storage Key",
+			setup: func(t *testing.T) string {
+				t.Setenv("STORAGE_KEY", "hunter2")
+				return ""
+			},
+			wantErr: true,
+		},
+		{
+			name: "Invalid Duration",
+			setup: func(t *testing.T) string {
+				t.Setenv("REQUEST_TIMEOUT", "soon")
+				return ""
+			},
+			wantErr: true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -95,9 +330,403 @@ func TestLoad(t *testing.T) {
 				t.Errorf("Load() error = %v, wantErr %v", err, tt.wantErr)
 				return
 			}
+			if err != nil && !errors.Is(err, ErrConfig) {
+				t.Errorf("Load() error = %v, want ErrConfig", err)
+			}
 			if !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("Load() = %+v, want %+v", got, tt.want)
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func TestCheckFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"valid", `{"model_name": "gpt-4o", "actions": [{"when": "phishing", "type": "move", "dir": "/q"}]}`, ""},
+		{"unknown setting", `{"modle_name": "gpt-4o"}`, `unknown setting "modle_name"`},
+		{"wrong type", `{"max_images": "3"}`, "max_images"},
+		{"trailing data", `{} {}`, "unexpected data"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := dir + "/config.json"
+			os.WriteFile(path, []byte(tt.content), 0o600)
+			err := CheckFile(path)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("CheckFile() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if err := CheckFile(dir + "/missing.json"); !os.IsNotExist(err) {
+		t.Errorf("CheckFile() of a missing file error = %v", err)
+	}
+}
+
+func TestCheckProjectFile(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{"allowed", `{"model_name": "llama3.1", "similarity_threshold": 0.9, "templates_dir": "prompts"}`, ""},
+		{"command", `{"model_name": "llama3.1", "openai_api_key_command": ["sh", "-c", "id"]}`, "cannot set openai_api_key_command"},
+		{"commands", `{"ocr_command": ["sh"], "fallback_classifier_command": ["sh"]}`, "cannot set fallback_classifier_command, ocr_command"},
+		{"endpoint", `{"openai_base_url": "https://evil.example.com"}`, "cannot set openai_base_url"},
+		{"section", `{"openai": {"base_url": "https://evil.example.com"}}`, "cannot set openai"},
+		{"actions", `{"actions": [{"when": "phishing", "type": "command", "command": ["sh"]}]}`, "cannot set actions"},
+		{"invalid", `{`, "unexpected end"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := dir + "/.mail-analyzer.json"
+			os.WriteFile(path, []byte(tt.content), 0o600)
+			err := CheckProjectFile(path)
+			if tt.wantErr == "" && err != nil || tt.wantErr != "" && (!errors.Is(err, ErrConfig) || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("CheckProjectFile() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if err := CheckProjectFile(dir + "/missing.json"); err != nil {
+		t.Errorf("CheckProjectFile() of a missing file error = %v", err)
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	t.Setenv("MODEL_NAME", "gpt-4o")
+	t.Setenv("OPENAI_API_KEY", "")
+	// Other variables, such as NO_PROXY, may be set in the test environment.
+	var got []string
+	for _, name := range EnvOverrides() {
+		if name == "OPENAI_API_KEY" || name == "MODEL_NAME" {
+			got = append(got, name)
+		}
+	}
+	if !reflect.DeepEqual(got, []string{"OPENAI_API_KEY", "MODEL_NAME"}) {
+		t.Errorf("EnvOverrides() = %v", got)
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	system := dir + "/system.json"
+	user := dir + "/user.json"
+	os.WriteFile(system, []byte(`{"model_name": "gpt-4o", "stream": true, "request_timeout": "2m"}`), 0o600)
+	os.WriteFile(user, []byte(`{"model_name": "llama3.1", "stream": false}`), 0o600)
+	t.Setenv("OPENAI_BASE_URL", "http://localhost:11434/v1")
+	for _, name := range []string{"MODEL_NAME", "STREAM", "REQUEST_TIMEOUT", "CONNECT_TIMEOUT"} {
+		t.Setenv(name, "") // Restored after the test.
+		os.Unsetenv(name)
+	}
+
+	r, err := Resolve(system, user, dir+"/missing.json")
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if r.ModelName != "llama3.1" || r.Stream || r.RequestTimeout != Duration(2*time.Minute) {
+		t.Errorf("Resolve() = %+v, want the user file to override the system file", r.Config)
+	}
+	if !reflect.DeepEqual(r.Files, []string{system, user}) {
+		t.Errorf("Files = %v", r.Files)
+	}
+	for name, want := range map[string]string{
+		"model_name":      user,
+		"stream":          user,
+		"request_timeout": system,
+		"openai_base_url": "env OPENAI_BASE_URL",
+		"connect_timeout": SourceDefault,
+	} {
+		if got := r.Sources[name]; got != want {
+			t.Errorf("Sources[%q] = %q, want %q", name, got, want)
+		}
+	}
+	if source, ok := r.Sources["webhook_url"]; ok {
+		t.Errorf("Sources[%q] = %q for a setting that is not set", "webhook_url", source)
+	}
+}
+
+func TestResolve_APIKeyCommand(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "")
+	os.Unsetenv("OPENAI_API_KEY")
+	path := t.TempDir() + "/config.json"
+	os.WriteFile(path, []byte(`{"openai_api_key_command": ["echo", "sk-from-command"]}`), 0o600)
+	r, err := Resolve(path)
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	if r.OpenAIAPIKey != "sk-from-command" || r.Sources["openai_api_key"] != "openai_api_key_command" {
+		t.Errorf("Resolve() key = %q from %q", r.OpenAIAPIKey, r.Sources["openai_api_key"])
+	}
+
+	// A key that is set is used as is.
+	t.Setenv("OPENAI_API_KEY", "sk-from-env")
+	if r, err := Resolve(path); err != nil || r.OpenAIAPIKey != "sk-from-env" {
+		t.Errorf("Resolve() with OPENAI_API_KEY = %v, %v", r, err)
+	}
+
+	t.Setenv("OPENAI_API_KEY", "")
+	os.WriteFile(path, []byte(`{"openai_api_key_command": ["false"]}`), 0o600)
+	if _, err := Resolve(path); err == nil || !strings.Contains(err.Error(), "openai_api_key_command") {
+		t.Errorf("Resolve() with a failing command error = %v", err)
+	}
+	os.WriteFile(path, []byte(`{"openai_api_key_command": ["true"]}`), 0o600)
+	if _, err := Resolve(path); err == nil || !strings.Contains(err.Error(), "empty secret") {
+		t.Errorf("Resolve() with a command printing nothing error = %v", err)
+	}
+}
+
+func TestPolicies(t *testing.T) {
+	path := t.TempDir() + "/config.json"
+	os.WriteFile(path, []byte(`{
+		"webhook_url": "https://soc.example.com/hook",
+		"alert_min_confidence": 0.5,
+		"policies": [
+			{"tenant": "acme", "domains": ["acme.com"], "settings": {"alert_min_confidence": 0.9, "thehive_url": "https://hive.acme.com", "thehive_api_key": "key"}},
+			{"tenant": "acme-eu", "domains": ["eu.acme.com"]},
+			{"tenant": "integrations", "api_tokens": ["t1", {"token": "t2", "role": "verdict"}]}
+		]
+	}`), 0o600)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	for domain, want := range map[string]string{"acme.com": "acme", "mail.acme.com": "acme", "eu.acme.com": "acme-eu", "notacme.com": ""} {
+		var got string
+		if p := cfg.PolicyFor(domain); p != nil {
+			got = p.Tenant
+		}
+		if got != want {
+			t.Errorf("PolicyFor(%q) = %q, want %q", domain, got, want)
+		}
+	}
+
+	if got, want := cfg.Policies[2].APITokens, []APIToken{{Token: "t1"}, {Token: "t2", Role: RoleVerdict}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("APITokens = %+v, want %+v", got, want)
+	}
+
+	tenant, err := cfg.Policies[0].Apply(cfg)
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if tenant.WebhookURL != cfg.WebhookURL || tenant.AlertMinConfidence != 0.9 || tenant.TheHiveMinConfidence != DefaultTheHiveMinConfidence || tenant.Policies != nil {
+		t.Errorf("Apply() = %+v, want the settings of the policy over the configuration", tenant)
+	}
+	if cfg.AlertMinConfidence != 0.5 || cfg.TheHiveURL != "" {
+		t.Errorf("Apply() changed the configuration: %+v", cfg)
+	}
+
+	// hashT1 is the SHA-256 hash of the API token "t1".
+	const hashT1 = "628b49d96dcde97a430dd4f597705899e09a968f793491e4b704cae33a40dc02"
+	for policies, want := range map[string]string{
+		`[{"domains": ["a.com"]}]`: "tenant is required",
+		`[{"tenant": "a"}]`:        "domains or api_tokens is required",
+		`[{"tenant": "a", "domains": ["a.com"]}, {"tenant": "b", "domains": ["A.com"]}]`:                    "also belongs to policy",
+		`[{"tenant": "a", "domains": ["a.com"], "min_confidence": 2}]`:                                      "min_confidence",
+		`[{"tenant": "a", "api_tokens": ["t1"], "rate_limit": -1}]`:                                         "rate_limit",
+		`[{"tenant": "a", "api_tokens": [""]}]`:                                                             "empty API token",
+		`[{"tenant": "a", "api_tokens": ["sha256:abc"]}]`:                                                   "invalid API token hash",
+		`[{"tenant": "a", "api_tokens": [{"token": "t1", "role": "admin"}]}]`:                               "unknown API token role",
+		`[{"tenant": "a", "api_tokens": ["t1"]}, {"tenant": "b", "api_tokens": ["sha256:` + hashT1 + `"]}]`: "also belongs to policy",
+		`[{"tenant": "a", "domains": ["a.com"], "settings": {"webhok_url": "x"}}]`:                          `unknown setting "webhok_url"`,
+		`[{"tenant": "a", "domains": ["a.com"], "settings": {"actions": [{"type": "x"}]}}]`:                 `policy "a"`,
+	} {
+		os.WriteFile(path, []byte(`{"policies": `+policies+`}`), 0o600)
+		if _, err := Load(path); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() of policies %s error = %v, want %q", policies, err, want)
+		}
+	}
+}
+
+func TestLoad_EnvInterpolation(t *testing.T) {
+	t.Setenv("LLM_HOST", "llm.internal")
+	t.Setenv("HOOK_SECRET", "s3cret")
+	t.Setenv("EMPTY", "")
+	os.Unsetenv("MISSING")
+	path := t.TempDir() + "/config.json"
+	os.WriteFile(path, []byte(`{
+		"openai_base_url": "https://${LLM_HOST}:8443/v1",
+		"webhook_url": "https://soc.example.com/${EMPTY}",
+		"webhook_secret": "${HOOK_SECRET}",
+		"model_name": "${MODEL:-gpt-4o}",
+		"splunk_index": "$${literal}",
+		"max_images": 2,
+		"actions": [{"when": "any", "type": "command", "command": ["/bin/notify", "--host=${LLM_HOST}"]}]
+	}`), 0o600)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.OpenAIBaseURL != "https://llm.internal:8443/v1" || cfg.WebhookURL != "https://soc.example.com/" || cfg.WebhookSecret != "s3cret" ||
+		cfg.ModelName != "gpt-4o" || cfg.SplunkIndex != "${literal}" || cfg.MaxImages != 2 || cfg.Actions[0].Command[1] != "--host=llm.internal" {
+		t.Errorf("Load() = %+v", cfg)
+	}
+
+	os.WriteFile(path, []byte(`{"webhook_secret": "${MISSING}", "actions": [{"when": "any", "type": "command", "command": ["${MISSING}"]}]}`), 0o600)
+	_, err = Load(path)
+	for _, want := range []string{"webhook_secret: environment variable MISSING is not set", "actions[0].command[0]: environment variable MISSING"} {
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load() with an unset variable error = %v, want %q", err, want)
+		}
+	}
+}
+
+func TestLoad_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := dir + "/key"
+	os.WriteFile(keyFile, []byte("correct horse battery staple\n"), 0o600)
+	apiKey, err := Encrypt("sk-encrypted", "correct horse battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(apiKey) || strings.Contains(apiKey, "sk-encrypted") {
+		t.Fatalf("Encrypt() = %q", apiKey)
+	}
+	secret, _ := Encrypt("s3cret", "correct horse battery staple")
+	path := dir + "/config.json"
+	os.WriteFile(path, []byte(`{"openai_api_key": "`+apiKey+`", "webhook_secret": "`+secret+`", "model_name": "gpt-4o"}`), 0o600)
+
+	t.Setenv("OPENAI_API_KEY", "")
+	os.Unsetenv("OPENAI_API_KEY")
+	t.Setenv(KeyFileEnv, keyFile)
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.OpenAIAPIKey != "sk-encrypted" || cfg.WebhookSecret != "s3cret" || cfg.ModelName != "gpt-4o" {
+		t.Errorf("Load() = %+v", cfg)
+	}
+
+	os.WriteFile(keyFile, []byte("wrong"), 0o600)
+	if _, err := Load(path); err == nil || !strings.Contains(err.Error(), "openai_api_key: could not decrypt the value") {
+		t.Errorf("Load() with the wrong passphrase error = %v", err)
+	}
+
+	// Without a key file, the passphrase is asked once.
+	t.Setenv(KeyFileEnv, "")
+	prompts := 0
+	PromptPassphrase = func() (string, error) {
+		prompts++
+		return "correct horse battery staple", nil
+	}
+	defer func() { PromptPassphrase = nil }()
+	for range 2 {
+		if cfg, err := Load(path); err != nil || cfg.OpenAIAPIKey != "sk-encrypted" {
+			t.Errorf("Load() with a prompted passphrase = %+v, %v", cfg, err)
+		}
+	}
+	if prompts != 1 {
+		t.Errorf("the passphrase was asked %d times, want once", prompts)
+	}
+}
+
+func TestProviderSections(t *testing.T) {
+	for _, env := range []string{"OPENAI_BASE_URL", "MODEL_NAME", "LLM_PROVIDER", "ANTHROPIC_API_KEY", "ANTHROPIC_BASE_URL", "OLLAMA_HOST", "AWS_BEARER_TOKEN_BEDROCK", "AWS_REGION", "AWS_DEFAULT_REGION"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+	t.Setenv("OPENAI_API_KEY", "sk-openai-env")
+	path := t.TempDir() + "/config.json"
+	sections := `"model_name": "flat-model", "openai_base_url": "https://flat.example.com/v1",
+		"openai": {"model": "gpt-4o", "organization": "org-1"},
+		"anthropic": {"model": "claude-sonnet-4-5"},
+		"ollama": {"host": "gpu-box:11434", "model": "llama3.1"},
+		"bedrock": {"region": "us-west-2", "model": "openai.gpt-oss-120b-1:0", "api_key": "bedrock-key"}`
+
+	tests := []struct {
+		name, provider, envProvider string
+		wantKey, wantURL, wantModel string
+	}{
+		{"openai", "openai", "", "sk-openai-env", "https://flat.example.com/v1", "gpt-4o"},
+		{"anthropic", "anthropic", "", "sk-ant-env", DefaultAnthropicBaseURL, "claude-sonnet-4-5"},
+		{"ollama from env", "openai", "ollama", "", "http://gpu-box:11434/v1", "llama3.1"},
+		{"bedrock", "bedrock", "", "bedrock-key", "https://bedrock-runtime.us-west-2.amazonaws.com/openai/v1", "openai.gpt-oss-120b-1:0"},
+	}
+	t.Setenv("ANTHROPIC_API_KEY", "sk-ant-env")
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.envProvider != "" {
+				t.Setenv("LLM_PROVIDER", tt.envProvider)
+			}
+			os.WriteFile(path, []byte(`{"provider": "`+tt.provider+`", `+sections+`}`), 0o600)
+			r, err := Resolve(path)
+			if err != nil {
+				t.Fatalf("Resolve() error = %v", err)
+			}
+			if r.OpenAIAPIKey != tt.wantKey || r.OpenAIBaseURL != tt.wantURL || r.ModelName != tt.wantModel {
+				t.Errorf("Resolve() key, URL, model = %q, %q, %q, want %q, %q, %q", r.OpenAIAPIKey, r.OpenAIBaseURL, r.ModelName, tt.wantKey, tt.wantURL, tt.wantModel)
+			}
+			if r.OpenAI != nil || r.Anthropic != nil || r.Ollama != nil || r.Bedrock != nil {
+				t.Error("Resolve() kept the provider sections")
+			}
+			if r.Sources["model_name"] != path {
+				t.Errorf("model_name source = %q, want %q", r.Sources["model_name"], path)
+			}
+		})
+	}
+
+	// Without the flat settings, each provider has its own default endpoint.
+	for provider, want := range map[string]string{
+		"anthropic": DefaultAnthropicBaseURL,
+		"ollama":    "http://localhost:11434/v1",
+		"bedrock":   "https://bedrock-runtime.eu-central-1.amazonaws.com/openai/v1",
+	} {
+		os.WriteFile(path, []byte(`{"provider": "`+provider+`", "aws_region": "eu-central-1", "model_name": "m"}`), 0o600)
+		if cfg, err := Load(path); err != nil || cfg.OpenAIBaseURL != want {
+			t.Errorf("Load() of %s = %v, want the endpoint %s", provider, err, want)
+		}
+	}
+
+	for config, want := range map[string]string{
+		`{"provider": "azure"}`:                      `unknown provider "azure"`,
+		`{"provider": "anthropic"}`:                  "the anthropic provider requires anthropic.model or model_name",
+		`{"provider": "bedrock", "model_name": "m"}`: "the bedrock provider requires bedrock.region or AWS_REGION",
+		`{"openai": {"modle": "gpt-4o"}}`:            "unknown setting",
+	} {
+		os.WriteFile(path, []byte(config), 0o600)
+		err := CheckFile(path)
+		if err == nil {
+			_, err = Load(path)
+		}
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Load(%s) error = %v, want %q", config, err, want)
+		}
+	}
+}
+
+func TestResolveWith(t *testing.T) {
+	for _, env := range []string{"OPENAI_BASE_URL", "MODEL_NAME", "OLLAMA_HOST"} {
+		t.Setenv(env, "")
+		os.Unsetenv(env)
+	}
+	t.Setenv("OPENAI_API_KEY", "sk-env")
+	t.Setenv("LLM_PROVIDER", "openai")
+	path := t.TempDir() + "/config.json"
+	os.WriteFile(path, []byte(`{"model_name": "gpt-4o", "openai_base_url": "https://flat.example.com/v1", "ollama": {"model": "llama3.1"}}`), 0o600)
+
+	r, err := ResolveWith(Overrides{Provider: "ollama"}, path)
+	if err != nil {
+		t.Fatalf("ResolveWith() error = %v", err)
+	}
+	if r.Provider != "ollama" || r.ModelName != "llama3.1" || r.OpenAIBaseURL != "http://localhost:11434/v1" || r.OpenAIAPIKey != "" {
+		t.Errorf("ResolveWith(ollama) = %+v", r.Config)
+	}
+	if r.Sources["provider"] != "flag --provider" {
+		t.Errorf("provider source = %q", r.Sources["provider"])
+	}
+
+	r, err = ResolveWith(Overrides{Model: "gpt-4.1-mini", BaseURL: "http://localhost:4000/v1"}, path)
+	if err != nil {
+		t.Fatalf("ResolveWith() error = %v", err)
+	}
+	if r.ModelName != "gpt-4.1-mini" || r.OpenAIBaseURL != "http://localhost:4000/v1" || r.OpenAIAPIKey != "sk-env" {
+		t.Errorf("ResolveWith(model, base URL) = %+v", r.Config)
+	}
+	if r.Sources["model_name"] != "flag --model" || r.Sources["openai_base_url"] != "flag --base-url" {
+		t.Errorf("sources = %v", r.Sources)
+	}
+}
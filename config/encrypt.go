@@ -0,0 +1,200 @@
+package config
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/scrypt"
+)
+
+// KeyFileEnv is the environment variable with the path of the file holding the passphrase
+// of encrypted configuration values.
+const KeyFileEnv = "MAIL_ANALYZER_KEY_FILE"
+
+// Encrypted values are ENC[v1,BASE64], where BASE64 encodes the scrypt salt, the nonce and
+// the XChaCha20-Poly1305 ciphertext.
+const (
+	encryptedPrefix = "ENC[v1,"
+	encryptedSuffix = "]"
+	saltSize        = 16
+	scryptN         = 1 << 15
+)
+
+// PromptPassphrase asks for the passphrase of encrypted values when KeyFileEnv is not set.
+// It is nil if there is no one to ask.
+var PromptPassphrase func() (string, error)
+
+// passphraseCache holds the passphrase entered with PromptPassphrase, so that it is asked
+// once when the configuration is reloaded, and the keys derived from the passphrase by
+// salt.
+var passphraseCache struct {
+	sync.Mutex
+	prompted string
+	keys     map[string][]byte
+}
+
+// Encrypt returns plaintext encrypted with passphrase, as a value to put in a
+// configuration file in place of plaintext.
+func Encrypt(plaintext, passphrase string) (string, error) {
+	if passphrase == "" {
+		return "", errors.New("the passphrase is empty")
+	}
+	buf := make([]byte, saltSize+chacha20poly1305.NonceSizeX, saltSize+chacha20poly1305.NonceSizeX+len(plaintext)+chacha20poly1305.Overhead)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	salt, nonce := buf[:saltSize], buf[saltSize:]
+	key, err := scrypt.Key([]byte(passphrase), salt, scryptN, 8, 1, chacha20poly1305.KeySize)
+	if err != nil {
+		return "", err
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+	buf = aead.Seal(buf, nonce, []byte(plaintext), nil)
+	return encryptedPrefix + base64.RawStdEncoding.EncodeToString(buf) + encryptedSuffix, nil
+}
+
+// IsEncrypted reports whether value is an encrypted value.
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, encryptedPrefix) && strings.HasSuffix(value, encryptedSuffix)
+}
+
+// decryptValues decrypts the encrypted string values of the JSON document data. The
+// passphrase is read from the file of KeyFileEnv, or asked with PromptPassphrase, only if
+// there are encrypted values.
+func decryptValues(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(encryptedPrefix)) {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	d := &decrypter{}
+	doc = d.value(doc, "")
+	if len(d.errs) > 0 {
+		return nil, errors.New(strings.Join(d.errs, "; "))
+	}
+	return json.Marshal(doc)
+}
+
+type decrypter struct {
+	passphrase string
+	prompted   bool
+	errs       []string
+}
+
+// value decrypts the strings in v, the setting name.
+func (d *decrypter) value(v any, name string) any {
+	switch v := v.(type) {
+	case string:
+		if !IsEncrypted(v) {
+			return v
+		}
+		plaintext, err := d.decrypt(v)
+		if err != nil {
+			d.errs = append(d.errs, fmt.Sprintf("%s: %v", name, err))
+		}
+		return plaintext
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys) // Report errors in a stable order.
+		for _, key := range keys {
+			v[key] = d.value(v[key], joinSetting(name, key))
+		}
+	case []any:
+		for i := range v {
+			v[i] = d.value(v[i], fmt.Sprintf("%s[%d]", name, i))
+		}
+	}
+	return v
+}
+
+func (d *decrypter) decrypt(value string) (string, error) {
+	data, err := base64.RawStdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(value, encryptedPrefix), encryptedSuffix))
+	if err != nil || len(data) < saltSize+chacha20poly1305.NonceSizeX+chacha20poly1305.Overhead {
+		return "", errors.New("malformed encrypted value")
+	}
+	salt, nonce, ciphertext := data[:saltSize], data[saltSize:saltSize+chacha20poly1305.NonceSizeX], data[saltSize+chacha20poly1305.NonceSizeX:]
+	if d.passphrase == "" {
+		if d.passphrase, d.prompted, err = readPassphrase(); err != nil {
+			return "", err
+		}
+	}
+
+	passphraseCache.Lock()
+	defer passphraseCache.Unlock()
+	cacheKey := d.passphrase + "\x00" + string(salt)
+	key := passphraseCache.keys[cacheKey]
+	if key == nil {
+		if key, err = scrypt.Key([]byte(d.passphrase), salt, scryptN, 8, 1, chacha20poly1305.KeySize); err != nil {
+			return "", err
+		}
+		if passphraseCache.keys == nil {
+			passphraseCache.keys = map[string][]byte{}
+		}
+		passphraseCache.keys[cacheKey] = key
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return "", err
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		if d.prompted {
+			passphraseCache.prompted = "" // Ask again next time.
+		}
+		return "", errors.New("could not decrypt the value: wrong passphrase, or the value was altered")
+	}
+	return string(plaintext), nil
+}
+
+// readPassphrase returns the passphrase of the key file of KeyFileEnv, or else the one
+// entered with PromptPassphrase, and whether it was entered.
+func readPassphrase() (string, bool, error) {
+	if path := os.Getenv(KeyFileEnv); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", false, fmt.Errorf("error reading the key file: %w", err)
+		}
+		passphrase := strings.TrimSpace(string(data))
+		if passphrase == "" {
+			return "", false, fmt.Errorf("the key file %s is empty", path)
+		}
+		return passphrase, false, nil
+	}
+
+	passphraseCache.Lock()
+	defer passphraseCache.Unlock()
+	if passphraseCache.prompted != "" {
+		return passphraseCache.prompted, true, nil
+	}
+	if PromptPassphrase == nil {
+		return "", false, fmt.Errorf("the value is encrypted; set %s to the file of the passphrase", KeyFileEnv)
+	}
+	passphrase, err := PromptPassphrase()
+	if err != nil {
+		return "", false, fmt.Errorf("error reading the passphrase: %w", err)
+	}
+	if passphrase == "" {
+		return "", false, errors.New("the passphrase is empty")
+	}
+	passphraseCache.prompted = passphrase
+	return passphrase, true, nil
+}
@@ -0,0 +1,80 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// envReference matches ${VAR} and ${VAR:-default} in configuration values, and the
+// escaped $${, which stands for a literal ${.
+var envReference = regexp.MustCompile(`\$\$\{|\$\{([A-Za-z_][A-Za-z0-9_]*)(:-[^}]*)?\}`)
+
+// expandEnv replaces the references to environment variables in the string values of
+// the JSON document data: ${VAR} with the value of VAR, which must be set, and
+// ${VAR:-default} with default if VAR is unset or empty.
+func expandEnv(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte("${")) {
+		return data, nil
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var doc any
+	if err := dec.Decode(&doc); err != nil {
+		return nil, err
+	}
+	var unset []string
+	doc = expandValue(doc, "", &unset)
+	if len(unset) > 0 {
+		return nil, errors.New(strings.Join(unset, "; "))
+	}
+	return json.Marshal(doc)
+}
+
+// expandValue expands the strings in v, the setting name, and adds the settings that
+// refer to unset variables to unset.
+func expandValue(v any, name string, unset *[]string) any {
+	switch v := v.(type) {
+	case string:
+		return envReference.ReplaceAllStringFunc(v, func(ref string) string {
+			if ref == "$${" {
+				return "${"
+			}
+			m := envReference.FindStringSubmatch(ref)
+			value, ok := os.LookupEnv(m[1])
+			if fallback, hasDefault := strings.CutPrefix(m[2], ":-"); hasDefault && value == "" {
+				return fallback
+			}
+			if !ok {
+				*unset = append(*unset, fmt.Sprintf("%s: environment variable %s is not set", name, m[1]))
+			}
+			return value
+		})
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		slices.Sort(keys) // Report errors in a stable order.
+		for _, key := range keys {
+			v[key] = expandValue(v[key], joinSetting(name, key), unset)
+		}
+	case []any:
+		for i := range v {
+			v[i] = expandValue(v[i], fmt.Sprintf("%s[%d]", name, i), unset)
+		}
+	}
+	return v
+}
+
+func joinSetting(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
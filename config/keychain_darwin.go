@@ -0,0 +1,10 @@
+package config
+
+import "context"
+
+// readKeychain reads a generic password from the macOS Keychain.
+func readKeychain(service, account string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+	return commandOutput(ctx, "security", "find-generic-password", "-s", service, "-a", account, "-w")
+}
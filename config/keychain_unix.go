@@ -0,0 +1,20 @@
+//go:build !darwin && !windows
+
+package config
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+)
+
+// readKeychain reads a secret from the Secret Service (GNOME Keyring, KWallet) with the
+// secret-tool command of libsecret.
+func readKeychain(service, account string) (string, error) {
+	if _, err := exec.LookPath("secret-tool"); err != nil {
+		return "", fmt.Errorf("secret-tool, of libsecret, is required to read the keychain: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+	return commandOutput(ctx, "secret-tool", "lookup", "service", service, "account", account)
+}
@@ -0,0 +1,68 @@
+package config
+
+import (
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+	"unsafe"
+)
+
+var (
+	advapi32      = syscall.NewLazyDLL("advapi32.dll")
+	procCredReadW = advapi32.NewProc("CredReadW")
+	procCredFree  = advapi32.NewProc("CredFree")
+)
+
+const credTypeGeneric = 1
+
+// credential is the CREDENTIALW structure of the Windows Credential Manager.
+type credential struct {
+	Flags              uint32
+	Type               uint32
+	TargetName         *uint16
+	Comment            *uint16
+	LastWritten        syscall.Filetime
+	CredentialBlobSize uint32
+	CredentialBlob     *byte
+	Persist            uint32
+	AttributeCount     uint32
+	Attributes         uintptr
+	TargetAlias        *uint16
+	UserName           *uint16
+}
+
+// readKeychain reads the generic credential named service from the Windows Credential
+// Manager, as stored by "cmdkey /generic:service /user:account /pass". The account is not
+// checked, since the name identifies the credential.
+func readKeychain(service, account string) (string, error) {
+	target, err := syscall.UTF16PtrFromString(service)
+	if err != nil {
+		return "", err
+	}
+	var cred *credential
+	if r, _, err := procCredReadW.Call(uintptr(unsafe.Pointer(target)), credTypeGeneric, 0, uintptr(unsafe.Pointer(&cred))); r == 0 {
+		return "", fmt.Errorf("cannot read credential %s from the Credential Manager: %w", service, err)
+	}
+	defer procCredFree.Call(uintptr(unsafe.Pointer(cred)))
+	blob := unsafe.Slice(cred.CredentialBlob, cred.CredentialBlobSize)
+	return decodeCredentialBlob(blob), nil
+}
+
+// decodeCredentialBlob returns the secret of a credential, which cmdkey and the control
+// panel store in UTF-16 and other programs often in UTF-8.
+func decodeCredentialBlob(blob []byte) string {
+	if len(blob)%2 == 0 {
+		utf16Encoded := true
+		for i := 1; i < len(blob); i += 2 {
+			utf16Encoded = utf16Encoded && blob[i] == 0
+		}
+		if utf16Encoded {
+			units := make([]uint16, len(blob)/2)
+			for i := range units {
+				units[i] = uint16(blob[2*i]) | uint16(blob[2*i+1])<<8
+			}
+			return string(utf16.Decode(units))
+		}
+	}
+	return string(blob)
+}
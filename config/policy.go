@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Policy overrides the configuration for the messages to the recipient domains of a
+// tenant, such as a customer of a managed service provider, or for the messages that the
+// tenant submits to the server with its API tokens.
+type Policy struct {
+	// Tenant names the policy. It is recorded in the results of its messages.
+	Tenant string `json:"tenant"`
+	// Domains are the recipient domains of the tenant. Subdomains match too.
+	Domains []string `json:"domains"`
+	// APITokens are the bearer tokens with which the tenant submits messages to serve.
+	// Once a policy has tokens, serve only accepts messages with a token, and analyzes
+	// them under the policy of the token, whatever their recipients.
+	APITokens []APIToken `json:"api_tokens,omitempty"`
+	// RateLimit is the number of messages per minute that the tenant can submit to
+	// serve, in bursts of up to as many. Zero is unlimited.
+	RateLimit int `json:"rate_limit,omitempty"`
+	// ResultsDB is a SQLite database where the results of the tenant are stored instead
+	// of the results databases of the server. The results of tenants with API tokens are
+	// only stored in their own database.
+	ResultsDB string `json:"results_db,omitempty"`
+	// MinConfidence is the confidence below which a suspicious verdict is reported as not
+	// suspicious, for tenants that tolerate more.
+	MinConfidence float64 `json:"min_confidence,omitempty"`
+	// Categories renames the categories of the verdicts to those of the tenant, e.g.
+	// {"Spam": "Bulk"}. Categories are matched case-insensitively.
+	Categories map[string]string `json:"categories,omitempty"`
+	// AllowedSenders are sender domains whose messages are judged safe without being
+	// analyzed. Subdomains match too.
+	AllowedSenders []string `json:"allowed_senders,omitempty"`
+	// Settings override settings of the configuration for the tenant, such as its output
+	// sinks, their thresholds and its actions. Only the output and action settings apply;
+	// the messages of every tenant are analyzed with the same model.
+	Settings json.RawMessage `json:"settings,omitempty"`
+}
+
+// Apply returns a copy of base with the settings of p.
+func (p *Policy) Apply(base *Config) (*Config, error) {
+	data, err := json.Marshal(base)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := json.Unmarshal(data, cfg); err != nil {
+		return nil, err
+	}
+	cfg.Policies = nil
+	if len(p.Settings) > 0 {
+		dec := json.NewDecoder(bytes.NewReader(p.Settings))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(cfg); err != nil {
+			if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+				return nil, fmt.Errorf("policy %q: unknown setting %s", p.Tenant, name)
+			}
+			return nil, fmt.Errorf("policy %q: %w", p.Tenant, err)
+		}
+		if len(cfg.Policies) > 0 {
+			return nil, fmt.Errorf("policy %q: policies cannot be nested", p.Tenant)
+		}
+		// The endpoint of the base configuration is kept unless the policy changes the
+		// provider, or sets its section.
+		if cfg.Provider != base.Provider {
+			clearOpenAISettings(cfg, nil)
+		}
+		applySection(cfg, nil)
+	}
+	if err := applyDefaults(cfg); err != nil {
+		return nil, fmt.Errorf("policy %q: %w", p.Tenant, err)
+	}
+	return cfg, nil
+}
+
+// APIToken is an API token of a tenant. It is written as the token alone, or as an
+// object with the role of the clients that use it: {"token": "...", "role": "verdict"}.
+type APIToken struct {
+	// Token is the token, or its SHA-256 hash as "sha256:<hex>".
+	Token string `json:"token"`
+	// Role shapes the responses of serve to the clients of the token: RoleAnalyst, the
+	// default, RoleRedacted or RoleVerdict.
+	Role string `json:"role,omitempty"`
+}
+
+// The roles of API tokens.
+const (
+	// RoleAnalyst receives the whole results.
+	RoleAnalyst = "analyst"
+	// RoleRedacted receives the results with the recipients masked, like --redact.
+	RoleRedacted = "redacted"
+	// RoleVerdict receives the verdicts alone, without their reason or anything about
+	// the message but its Message-ID.
+	RoleVerdict = "verdict"
+)
+
+// UnmarshalJSON implements json.Unmarshaler, for tokens written as a string.
+func (t *APIToken) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &t.Token); err == nil {
+		t.Role = ""
+		return nil
+	}
+	type plain APIToken
+	return json.Unmarshal(data, (*plain)(t))
+}
+
+// MarshalJSON implements json.Marshaler, writing tokens without a role as a string.
+func (t APIToken) MarshalJSON() ([]byte, error) {
+	if t.Role == "" {
+		return json.Marshal(t.Token)
+	}
+	type plain APIToken
+	return json.Marshal(plain(t))
+}
+
+// IsHash reports whether t is given by its SHA-256 hash rather than the token itself.
+func (t APIToken) IsHash() bool {
+	return strings.HasPrefix(t.Token, tokenHashPrefix)
+}
+
+// tokenHashPrefix marks the API tokens of policies that are given by their hash.
+const tokenHashPrefix = "sha256:"
+
+// TokenHashes returns the SHA-256 hashes of the API tokens of p, in order.
+func (p *Policy) TokenHashes() ([][sha256.Size]byte, error) {
+	var hashes [][sha256.Size]byte
+	for _, token := range p.APITokens {
+		var hash [sha256.Size]byte
+		switch {
+		case token.IsHash():
+			b, err := hex.DecodeString(strings.TrimPrefix(token.Token, tokenHashPrefix))
+			if err != nil || len(b) != sha256.Size {
+				return nil, fmt.Errorf("policy %q: invalid API token hash %q", p.Tenant, token.Token)
+			}
+			copy(hash[:], b)
+		case token.Token == "":
+			return nil, fmt.Errorf("policy %q: empty API token", p.Tenant)
+		default:
+			hash = sha256.Sum256([]byte(token.Token))
+		}
+		switch token.Role {
+		case "", RoleAnalyst, RoleRedacted, RoleVerdict:
+		default:
+			return nil, fmt.Errorf("policy %q: unknown API token role %q (use %s, %s or %s)", p.Tenant, token.Role, RoleAnalyst, RoleRedacted, RoleVerdict)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+// AllowsSender reports whether messages from domain are judged safe without analysis.
+func (p *Policy) AllowsSender(domain string) bool {
+	return matchDomain(p.AllowedSenders, domain)
+}
+
+// Category returns the name of category for the tenant.
+func (p *Policy) Category(category string) string {
+	for from, to := range p.Categories {
+		if strings.EqualFold(from, category) {
+			return to
+		}
+	}
+	return category
+}
+
+func matchDomain(domains []string, domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	for _, d := range domains {
+		d = strings.ToLower(strings.TrimSpace(d))
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePolicies checks that the policies of cfg are complete, that no recipient domain
+// belongs to two tenants, and that their settings apply.
+func validatePolicies(cfg *Config) error {
+	tenants := map[string]bool{}
+	domains := map[string]string{}
+	tokens := map[[sha256.Size]byte]string{}
+	for i := range cfg.Policies {
+		p := &cfg.Policies[i]
+		switch {
+		case p.Tenant == "":
+			return fmt.Errorf("policy %d: tenant is required", i+1)
+		case tenants[p.Tenant]:
+			return fmt.Errorf("policy %q is defined twice", p.Tenant)
+		case len(p.Domains) == 0 && len(p.APITokens) == 0:
+			return fmt.Errorf("policy %q: domains or api_tokens is required", p.Tenant)
+		case p.MinConfidence < 0 || p.MinConfidence > 1:
+			return fmt.Errorf("policy %q: min_confidence must be between 0 and 1", p.Tenant)
+		case p.RateLimit < 0:
+			return fmt.Errorf("policy %q: rate_limit must not be negative", p.Tenant)
+		}
+		tenants[p.Tenant] = true
+		for _, d := range p.Domains {
+			d = strings.ToLower(strings.TrimSpace(d))
+			if d == "" {
+				return fmt.Errorf("policy %q: empty domain", p.Tenant)
+			}
+			if other, ok := domains[d]; ok {
+				return fmt.Errorf("policy %q: domain %s also belongs to policy %q", p.Tenant, d, other)
+			}
+			domains[d] = p.Tenant
+		}
+		hashes, err := p.TokenHashes()
+		if err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if other, ok := tokens[hash]; ok {
+				return fmt.Errorf("policy %q: an API token also belongs to policy %q", p.Tenant, other)
+			}
+			tokens[hash] = p.Tenant
+		}
+		if _, err := p.Apply(cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PolicyFor returns the policy of the first of the recipient domains that belongs to a
+// tenant, or nil. The most specific domain wins when a subdomain has its own policy.
+func (cfg *Config) PolicyFor(recipientDomains ...string) *Policy {
+	for _, domain := range recipientDomains {
+		var best *Policy
+		bestLen := -1
+		for i := range cfg.Policies {
+			p := &cfg.Policies[i]
+			for _, d := range p.Domains {
+				if matchDomain([]string{d}, domain) && len(d) > bestLen {
+					best, bestLen = p, len(d)
+				}
+			}
+		}
+		if best != nil {
+			return best
+		}
+	}
+	return nil
+}
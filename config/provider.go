@@ -0,0 +1,253 @@
+package config
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Providers of the LLM API. Every provider is used through its OpenAI-compatible chat
+// completions endpoint.
+const (
+	ProviderOpenAI    = "openai"
+	ProviderAnthropic = "anthropic"
+	ProviderOllama    = "ollama"
+	ProviderBedrock   = "bedrock"
+)
+
+// Default endpoints of the providers other than OpenAI, whose default is llm.DefaultBaseURL.
+const (
+	DefaultAnthropicBaseURL = "https://api.anthropic.com/v1"
+	DefaultOllamaHost       = "http://localhost:11434"
+)
+
+// OpenAIConfig is the section of the OpenAI provider, which also serves any
+// OpenAI-compatible endpoint, such as Azure OpenAI, LiteLLM or vLLM.
+type OpenAIConfig struct {
+	APIKey              string   `json:"api_key,omitempty"`
+	APIKeyCommand       []string `json:"api_key_command,omitempty"`
+	APIKeyKeychain      string   `json:"api_key_keychain,omitempty"`
+	BaseURL             string   `json:"base_url,omitempty"`
+	Model               string   `json:"model,omitempty"`
+	ChatCompletionsPath string   `json:"chat_completions_path,omitempty"`
+	Organization        string   `json:"organization,omitempty"`
+	Project             string   `json:"project,omitempty"`
+}
+
+// AnthropicConfig is the section of the Anthropic API. The API key defaults to
+// ANTHROPIC_API_KEY.
+type AnthropicConfig struct {
+	APIKey         string   `json:"api_key,omitempty"`
+	APIKeyCommand  []string `json:"api_key_command,omitempty"`
+	APIKeyKeychain string   `json:"api_key_keychain,omitempty"`
+	BaseURL        string   `json:"base_url,omitempty"`
+	Model          string   `json:"model,omitempty"`
+}
+
+// OllamaConfig is the section of an Ollama server. Host defaults to OLLAMA_HOST, or else
+// DefaultOllamaHost.
+type OllamaConfig struct {
+	Host  string `json:"host,omitempty"`
+	Model string `json:"model,omitempty"`
+}
+
+// BedrockConfig is the section of Amazon Bedrock, used with a Bedrock API key, which
+// defaults to AWS_BEARER_TOKEN_BEDROCK. Region sets aws_region, and the endpoint defaults
+// to the one of the region.
+type BedrockConfig struct {
+	APIKey  string `json:"api_key,omitempty"`
+	Region  string `json:"region,omitempty"`
+	BaseURL string `json:"base_url,omitempty"`
+	Model   string `json:"model,omitempty"`
+}
+
+// ProviderKeyEnv returns the environment variable that the API key of provider is read
+// from, or "" if the provider does not need one.
+func ProviderKeyEnv(provider string) string {
+	switch provider {
+	case ProviderAnthropic:
+		return "ANTHROPIC_API_KEY"
+	case ProviderOllama:
+		return ""
+	case ProviderBedrock:
+		return "AWS_BEARER_TOKEN_BEDROCK"
+	}
+	return "OPENAI_API_KEY"
+}
+
+// CheckCredentials reports a missing API key of a provider that requires one, or for the
+// OpenAI provider, a missing API key and endpoint, since local endpoints need no key.
+func (cfg *Config) CheckCredentials() error {
+	switch cfg.Provider {
+	case ProviderAnthropic, ProviderBedrock:
+		if cfg.OpenAIAPIKey == "" {
+			return fmt.Errorf("the %s provider requires an API key; set %s.api_key or %s", cfg.Provider, cfg.Provider, ProviderKeyEnv(cfg.Provider))
+		}
+	case ProviderOllama:
+	default:
+		if cfg.OpenAIAPIKey == "" && cfg.OpenAIBaseURL == "" {
+			return errors.New("OPENAI_API_KEY or OPENAI_BASE_URL must be set in the config file or environment")
+		}
+	}
+	return nil
+}
+
+// clearOpenAISettings clears the flat openai_* settings, which are those of the OpenAI
+// provider, when cfg has another provider, along with their sources.
+func clearOpenAISettings(cfg *Config, sources map[string]string) {
+	if cmp.Or(cfg.Provider, ProviderOpenAI) == ProviderOpenAI {
+		return
+	}
+	cfg.OpenAIAPIKey, cfg.OpenAIAPIKeyCommand, cfg.OpenAIAPIKeyKeychain = "", nil, ""
+	cfg.OpenAIBaseURL, cfg.OpenAIOrganization, cfg.OpenAIProject = "", "", ""
+	for _, name := range []string{"openai_api_key", "openai_api_key_command", "openai_api_key_keychain", "openai_base_url", "openai_organization", "openai_project"} {
+		delete(sources, name)
+	}
+}
+
+// applySection sets the endpoint settings from the section of the provider of cfg, which
+// take precedence over the flat settings, and clears the sections, so that the flat
+// settings hold the endpoint from then on. If sources is not nil, the settings are
+// recorded as coming from the file of the section.
+func applySection(cfg *Config, sources map[string]string) {
+	section := cmp.Or(cfg.Provider, ProviderOpenAI)
+	set := func(name string, dst *string, value string) {
+		if value != "" {
+			*dst = value
+			if sources != nil {
+				sources[name] = sources[section]
+			}
+		}
+	}
+	setList := func(name string, dst *[]string, value []string) {
+		if len(value) > 0 {
+			*dst = value
+			if sources != nil {
+				sources[name] = sources[section]
+			}
+		}
+	}
+
+	switch section {
+	case ProviderOpenAI:
+		if s := cfg.OpenAI; s != nil {
+			set("openai_api_key", &cfg.OpenAIAPIKey, s.APIKey)
+			setList("openai_api_key_command", &cfg.OpenAIAPIKeyCommand, s.APIKeyCommand)
+			set("openai_api_key_keychain", &cfg.OpenAIAPIKeyKeychain, s.APIKeyKeychain)
+			set("openai_base_url", &cfg.OpenAIBaseURL, s.BaseURL)
+			set("model_name", &cfg.ModelName, s.Model)
+			set("chat_completions_path", &cfg.ChatCompletionsPath, s.ChatCompletionsPath)
+			set("openai_organization", &cfg.OpenAIOrganization, s.Organization)
+			set("openai_project", &cfg.OpenAIProject, s.Project)
+		}
+	case ProviderAnthropic:
+		if s := cfg.Anthropic; s != nil {
+			set("openai_api_key", &cfg.OpenAIAPIKey, s.APIKey)
+			setList("openai_api_key_command", &cfg.OpenAIAPIKeyCommand, s.APIKeyCommand)
+			set("openai_api_key_keychain", &cfg.OpenAIAPIKeyKeychain, s.APIKeyKeychain)
+			set("openai_base_url", &cfg.OpenAIBaseURL, s.BaseURL)
+			set("model_name", &cfg.ModelName, s.Model)
+		}
+	case ProviderOllama:
+		if s := cfg.Ollama; s != nil {
+			if s.Host != "" {
+				set("openai_base_url", &cfg.OpenAIBaseURL, ollamaBaseURL(s.Host))
+			}
+			set("model_name", &cfg.ModelName, s.Model)
+		}
+	case ProviderBedrock:
+		if s := cfg.Bedrock; s != nil {
+			set("openai_api_key", &cfg.OpenAIAPIKey, s.APIKey)
+			set("aws_region", &cfg.AWSRegion, s.Region)
+			set("openai_base_url", &cfg.OpenAIBaseURL, s.BaseURL)
+			set("model_name", &cfg.ModelName, s.Model)
+		}
+	}
+	cfg.OpenAI, cfg.Anthropic, cfg.Ollama, cfg.Bedrock = nil, nil, nil, nil
+	for _, name := range []string{ProviderOpenAI, ProviderAnthropic, ProviderOllama, ProviderBedrock} {
+		delete(sources, name)
+	}
+}
+
+// openAIEnv returns the settings of cfg that are set by the environment variables named
+// after OpenAI, which are ignored for the other providers.
+func openAIEnv(cfg *Config) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+	for i := range t.NumField() {
+		if env := t.Field(i).Tag.Get("envconfig"); strings.HasPrefix(env, "OPENAI_") {
+			fields[env] = v.Field(i)
+		}
+	}
+	return fields
+}
+
+// applyProviderEnv sets the endpoint settings of cfg that are still empty from the
+// environment variables of its provider, and records them in sources.
+func applyProviderEnv(cfg *Config, sources map[string]string) {
+	set := func(name string, dst *string, env string, convert func(string) string) {
+		if value := os.Getenv(env); *dst == "" && value != "" {
+			*dst = convert(value)
+			sources[name] = "env " + env
+		}
+	}
+	same := func(s string) string { return s }
+	switch cfg.Provider {
+	case ProviderAnthropic:
+		set("openai_api_key", &cfg.OpenAIAPIKey, "ANTHROPIC_API_KEY", same)
+		set("openai_base_url", &cfg.OpenAIBaseURL, "ANTHROPIC_BASE_URL", same)
+	case ProviderOllama:
+		set("openai_base_url", &cfg.OpenAIBaseURL, "OLLAMA_HOST", ollamaBaseURL)
+	case ProviderBedrock:
+		set("openai_api_key", &cfg.OpenAIAPIKey, "AWS_BEARER_TOKEN_BEDROCK", same)
+		set("aws_region", &cfg.AWSRegion, "AWS_DEFAULT_REGION", same)
+	}
+}
+
+// applyProviderDefaults checks the provider of cfg and sets the default endpoint and model.
+func applyProviderDefaults(cfg *Config) error {
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderOpenAI
+	}
+	switch cfg.Provider {
+	case ProviderOpenAI:
+		if cfg.ModelName == "" {
+			cfg.ModelName = DefaultModelName
+		}
+		return nil
+	case ProviderAnthropic:
+		if cfg.OpenAIBaseURL == "" {
+			cfg.OpenAIBaseURL = DefaultAnthropicBaseURL
+		}
+	case ProviderOllama:
+		if cfg.OpenAIBaseURL == "" {
+			cfg.OpenAIBaseURL = ollamaBaseURL(DefaultOllamaHost)
+		}
+	case ProviderBedrock:
+		if cfg.OpenAIBaseURL == "" {
+			if cfg.AWSRegion == "" {
+				return fmt.Errorf("the %s provider requires bedrock.region or AWS_REGION", cfg.Provider)
+			}
+			cfg.OpenAIBaseURL = "https://bedrock-runtime." + cfg.AWSRegion + ".amazonaws.com/openai/v1"
+		}
+	default:
+		return fmt.Errorf("unknown provider %q; expected %s, %s, %s or %s", cfg.Provider, ProviderOpenAI, ProviderAnthropic, ProviderOllama, ProviderBedrock)
+	}
+	if cfg.ModelName == "" {
+		return fmt.Errorf("the %s provider requires %s.model or model_name", cfg.Provider, cfg.Provider)
+	}
+	return nil
+}
+
+// ollamaBaseURL returns the OpenAI-compatible endpoint of the Ollama server host, which
+// may be given as OLLAMA_HOST is, without a scheme.
+func ollamaBaseURL(host string) string {
+	if !strings.Contains(host, "://") {
+		host = "http://" + host
+	}
+	return strings.TrimRight(host, "/") + "/v1"
+}
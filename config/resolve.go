@@ -0,0 +1,169 @@
+package config
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/kelseyhightower/envconfig"
+)
+
+// SourceDefault is the source of the settings that have their built-in default value.
+const SourceDefault = "default"
+
+// Resolved is a configuration along with where each of its settings came from.
+type Resolved struct {
+	*Config
+	// Files are the configuration files that were found and read, in order.
+	Files []string
+	// Sources maps the JSON name of every setting that is set, or is not empty, to where
+	// its value came from: the path of a file, "env" followed by the name of an environment
+	// variable, the setting of the command or keychain the API key was read with, or
+	// SourceDefault.
+	Sources map[string]string
+}
+
+// Overrides are settings given for a single run, such as with command-line flags, which
+// take precedence over the files and the environment. Empty fields are not overridden.
+type Overrides struct {
+	Provider string
+	Model    string
+	BaseURL  string
+}
+
+// Resolve loads configuration as LoadFiles does, and records where each setting came from.
+func Resolve(paths ...string) (*Resolved, error) {
+	return ResolveWith(Overrides{}, paths...)
+}
+
+// ResolveWith is Resolve, with the settings of overrides. Their source is "flag" followed
+// by the name of the flag.
+func ResolveWith(overrides Overrides, paths ...string) (*Resolved, error) {
+	r, err := resolve(overrides, paths)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+	return r, nil
+}
+
+func resolve(overrides Overrides, paths []string) (*Resolved, error) {
+	r := &Resolved{Config: &Config{}, Sources: map[string]string{}}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// Ignore file not found errors, as the path may not always exist.
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, err
+		}
+		if data, err = expandEnv(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if data, err = decryptValues(data); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		// Decoding into the same Config only changes the settings present in the file.
+		if err := json.Unmarshal(data, r.Config); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		var settings map[string]json.RawMessage
+		if err := json.Unmarshal(data, &settings); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		for name := range settings {
+			r.Sources[name] = path
+		}
+		r.Files = append(r.Files, path)
+	}
+
+	// The provider selects the section that sets the endpoint, which the environment
+	// variables then override.
+	if provider, ok := os.LookupEnv("LLM_PROVIDER"); ok {
+		r.Config.Provider = provider
+	}
+	if overrides.Provider != "" {
+		r.Config.Provider = overrides.Provider
+	}
+	clearOpenAISettings(r.Config, r.Sources)
+	applySection(r.Config, r.Sources)
+
+	// Now, process environment variables. This will override any fields with values
+	// from the environment. The variables named after OpenAI only apply to it, since they
+	// are often set for other tools.
+	openAIOnly := cmp.Or(r.Config.Provider, ProviderOpenAI) != ProviderOpenAI
+	saved := map[string]any{}
+	for env, field := range openAIEnv(r.Config) {
+		saved[env] = field.Interface()
+	}
+	if err := envconfig.Process("", r.Config); err != nil {
+		return nil, err
+	}
+	t := reflect.TypeOf(Config{})
+	fields := openAIEnv(r.Config)
+	for i := range t.NumField() {
+		field := t.Field(i)
+		env := field.Tag.Get("envconfig")
+		if env == "" || field.Tag.Get("ignored") == "true" {
+			continue
+		}
+		if openAIOnly && strings.HasPrefix(env, "OPENAI_") {
+			fields[env].Set(reflect.ValueOf(saved[env]))
+			continue
+		}
+		if _, ok := os.LookupEnv(env); ok {
+			r.Sources[jsonName(field)] = "env " + env
+		}
+	}
+	if overrides.Provider != "" {
+		// The provider was set again from LLM_PROVIDER.
+		r.Config.Provider = overrides.Provider
+		r.Sources["provider"] = "flag --provider"
+	}
+	applyProviderEnv(r.Config, r.Sources)
+	if overrides.Model != "" {
+		r.Config.ModelName = overrides.Model
+		r.Sources["model_name"] = "flag --model"
+	}
+	if overrides.BaseURL != "" {
+		r.Config.OpenAIBaseURL = overrides.BaseURL
+		r.Sources["openai_base_url"] = "flag --base-url"
+	}
+
+	source, err := resolveAPIKey(r.Config)
+	if err != nil {
+		return nil, err
+	}
+	if source != "" {
+		r.Sources["openai_api_key"] = source
+	}
+	if source, err = resolveStorageKey(r.Config); err != nil {
+		return nil, err
+	}
+	if source != "" {
+		r.Sources["storage_key"] = source
+	}
+
+	if err := applyDefaults(r.Config); err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(r.Config).Elem()
+	for i := range t.NumField() {
+		name := jsonName(t.Field(i))
+		if _, ok := r.Sources[name]; !ok && !v.Field(i).IsZero() {
+			r.Sources[name] = SourceDefault
+		}
+	}
+	return r, nil
+}
+
+// jsonName returns the name of the setting of field in configuration files.
+func jsonName(field reflect.StructField) string {
+	if name, _, _ := strings.Cut(field.Tag.Get("json"), ","); name != "" {
+		return name
+	}
+	return field.Name
+}
@@ -0,0 +1,97 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Accounts of the API key and the storage key in the OS keychain.
+const (
+	keychainAccount        = "openai_api_key"
+	storageKeychainAccount = "storage_key"
+)
+
+// secretCommandTimeout bounds the commands that print secrets, which may wait for the user
+// to unlock a password manager.
+const secretCommandTimeout = time.Minute
+
+// errNoSecret is returned when a secret command or the keychain has an empty secret.
+var errNoSecret = errors.New("empty secret")
+
+// resolveAPIKey sets the API key from its command or the OS keychain if it is not set,
+// and returns where it came from, or "" if it was left alone.
+func resolveAPIKey(cfg *Config) (string, error) {
+	if len(cfg.OpenAIAPIKeyCommand) > 0 && cfg.OpenAIAPIKeyKeychain != "" {
+		return "", errors.New("openai_api_key_command and openai_api_key_keychain cannot both be set")
+	}
+	if cfg.OpenAIAPIKey != "" {
+		return "", nil
+	}
+	var err error
+	switch {
+	case len(cfg.OpenAIAPIKeyCommand) > 0:
+		if cfg.OpenAIAPIKey, err = runSecretCommand(cfg.OpenAIAPIKeyCommand); err != nil {
+			return "", fmt.Errorf("openai_api_key_command: %w", err)
+		}
+		return "openai_api_key_command", nil
+	case cfg.OpenAIAPIKeyKeychain != "":
+		if cfg.OpenAIAPIKey, err = readKeychain(cfg.OpenAIAPIKeyKeychain, keychainAccount); err == nil && cfg.OpenAIAPIKey == "" {
+			err = errNoSecret
+		}
+		if err != nil {
+			return "", fmt.Errorf("openai_api_key_keychain: %w", err)
+		}
+		return "keychain " + cfg.OpenAIAPIKeyKeychain, nil
+	}
+	return "", nil
+}
+
+// resolveStorageKey sets the storage key from the OS keychain if it is not set, and
+// returns where it came from, or "" if it was left alone.
+func resolveStorageKey(cfg *Config) (string, error) {
+	if cfg.StorageKey != "" || cfg.StorageKeyKeychain == "" {
+		return "", nil
+	}
+	var err error
+	if cfg.StorageKey, err = readKeychain(cfg.StorageKeyKeychain, storageKeychainAccount); err == nil && cfg.StorageKey == "" {
+		err = errNoSecret
+	}
+	if err != nil {
+		return "", fmt.Errorf("storage_key_keychain: %w", err)
+	}
+	return "keychain " + cfg.StorageKeyKeychain, nil
+}
+
+// runSecretCommand runs command and returns the first line of its output.
+func runSecretCommand(command []string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+	return commandOutput(ctx, command[0], command[1:]...)
+}
+
+// commandOutput runs a program and returns the first line of its output. Its standard
+// error is included in the error if it fails.
+func commandOutput(ctx context.Context, name string, args ...string) (string, error) {
+	cmd := exec.CommandContext(ctx, name, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	// Password managers such as pass print the secret on the first line.
+	line, _, _ := strings.Cut(string(out), "\n")
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", errNoSecret
+	}
+	return line, nil
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// CheckFile reports problems in the config file at path that Load tolerates: a missing
+// file, and settings with unknown names, which are usually typos and otherwise silently
+// ignored.
+func CheckFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	var cfg Config
+	if err := dec.Decode(&cfg); err != nil {
+		if name, ok := strings.CutPrefix(err.Error(), "json: unknown field "); ok {
+			return fmt.Errorf("%s: unknown setting %s", path, name)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := dec.Token(); !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: unexpected data after the configuration object", path)
+	}
+	return nil
+}
+
+// ProjectSettings are the settings that a project file may set. Project files are found
+// in the current directory and its parents, which may hold untrusted samples, so they
+// may not set commands, endpoints, secrets, sinks or actions, which would run code or
+// send the messages and the API key elsewhere.
+var ProjectSettings = []string{
+	"model_name", "headers_only_model", "templates_dir", "prompt_cache_control",
+	"stream", "disable_repair_retry", "max_images",
+	"message_timeout", "request_timeout", "max_parse_time", "max_decoded_bytes", "max_urls",
+	"attachment_text", "attachment_text_max_bytes", "ocr_timeout", "ocr_max_bytes",
+	"similarity_threshold", "prefilter_mode", "alert_min_confidence", "webhook_min_confidence",
+	"thehive_min_confidence", "sandbox_min_score",
+}
+
+// CheckProjectFile returns an error if the project file at path sets settings other
+// than ProjectSettings. A missing file has none.
+func CheckProjectFile(path string) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrConfig, err)
+	}
+	var settings map[string]json.RawMessage
+	if err := json.Unmarshal(data, &settings); err != nil {
+		return fmt.Errorf("%w: %s: %w", ErrConfig, path, err)
+	}
+	var rejected []string
+	for name := range settings {
+		if !slices.Contains(ProjectSettings, name) {
+			rejected = append(rejected, name)
+		}
+	}
+	if len(rejected) > 0 {
+		slices.Sort(rejected)
+		return fmt.Errorf("%w: %s: a project file cannot set %s; set them in the user or system file, or pass the file with --config",
+			ErrConfig, path, strings.Join(rejected, ", "))
+	}
+	return nil
+}
+
+// EnvOverrides returns the names of the environment variables that are set and override
+// settings of the config file, in the order of the settings.
+func EnvOverrides() []string {
+	var names []string
+	t := reflect.TypeOf(Config{})
+	for i := range t.NumField() {
+		field := t.Field(i)
+		name := field.Tag.Get("envconfig")
+		if name == "" || field.Tag.Get("ignored") == "true" {
+			continue
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,518 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"maps"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/charmbracelet/x/term"
+
+	"mail-analyzer/analyzer"
+	"mail-analyzer/config"
+	"mail-analyzer/enrichment"
+	"mail-analyzer/httpclient"
+	"mail-analyzer/llm"
+	"mail-analyzer/secret"
+)
+
+// maskedSecret replaces secrets in the output of "config show".
+const maskedSecret = "********"
+
+// connectivityTimeout bounds the connectivity test of "config validate".
+const connectivityTimeout = 30 * time.Second
+
+const configUsage = `Usage: mail-analyzer config <command> [flags]
+
+Commands:
+  init      Create a configuration file interactively
+  validate  Check the configuration and test the connection to the LLM endpoint
+  show      Print the effective configuration, after environment overrides and defaults,
+            with secrets masked, and with --resolved, where each setting came from
+  path      Print the path of the default configuration file
+  encrypt   Encrypt a value read from the standard input, to put it in a configuration file
+
+Run "mail-analyzer config <command> -h" for the flags of a command.
+`
+
+// runConfig implements the "config" command.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		fmt.Fprint(os.Stderr, configUsage)
+		return usageErrorf("expected init, validate, show, path or encrypt")
+	}
+	switch args[0] {
+	case "-h", "-help", "--help", "help":
+		fmt.Print(configUsage)
+		return nil
+	case "init":
+		fs := newFlagSet("config init", "", "Create a configuration file by answering a few questions.")
+		configPath := fs.String("config", "", "File to create (default: the user file printed by config path)")
+		force := fs.Bool("force", false, "Overwrite an existing file")
+		if err := parseConfigFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		path, err := configFilePath(*configPath)
+		if err != nil {
+			return err
+		}
+		return runConfigInit(os.Stdin, os.Stdout, path, *force)
+	case "validate":
+		fs := newFlagSet("config validate", "",
+			"Check the configuration file for errors and unknown settings, list the environment\n"+
+				"variables that override it, and test the connection to the LLM endpoint.")
+		configPath := fs.String("config", "", configFlagHelp)
+		offline := fs.Bool("offline", false, "Do not test the connection to the LLM endpoint")
+		if err := parseConfigFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		return runConfigValidate(os.Stdout, *configPath, *offline)
+	case "show":
+		fs := newFlagSet("config show", "",
+			"Print the effective configuration, with secrets masked. Without --config, it merges\n"+
+				"the system file ("+systemConfigPath()+"), the user file, and the\n"+
+				"project file ("+projectConfigName+" in the current directory or a parent), in that\n"+
+				"order, then the environment variables and defaults.")
+		configPath := fs.String("config", "", configFlagHelp)
+		resolved := fs.Bool("resolved", false, "Print every setting with the file, environment variable or default it came from")
+		if err := parseConfigFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		return runConfigShow(os.Stdout, *configPath, *resolved)
+	case "path":
+		fs := newFlagSet("config path", "", "Print the path of the default configuration file.")
+		if err := parseConfigFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		path, err := defaultConfigPath()
+		if err != nil {
+			return err
+		}
+		fmt.Println(path)
+		return nil
+	case "encrypt":
+		fs := newFlagSet("config encrypt", "",
+			"Encrypt a secret read from the standard input, and print the value to put in a\n"+
+				"configuration file in its place. Encrypted values are decrypted when the\n"+
+				"configuration is loaded, with the passphrase of the file of "+config.KeyFileEnv+",\n"+
+				"or else one asked on the terminal.")
+		keyFile := fs.String("key-file", os.Getenv(config.KeyFileEnv), "File holding the passphrase (default $"+config.KeyFileEnv+", or else it is asked)")
+		if err := parseConfigFlags(fs, args[1:]); err != nil {
+			return err
+		}
+		return runConfigEncrypt(os.Stdin, os.Stdout, *keyFile)
+	default:
+		fmt.Fprint(os.Stderr, configUsage)
+		return usageErrorf("unknown config command %q", args[0])
+	}
+}
+
+func parseConfigFlags(fs *flag.FlagSet, args []string) error {
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+	return nil
+}
+
+// configFilePath returns path, or the default configuration file if path is empty.
+func configFilePath(path string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	return defaultConfigPath()
+}
+
+// runConfigEncrypt prints the secret read from in, encrypted with the passphrase of
+// keyFile, or one asked on the terminal if keyFile is empty.
+func runConfigEncrypt(in io.Reader, out io.Writer, keyFile string) error {
+	var passphrase string
+	if keyFile != "" {
+		data, err := os.ReadFile(keyFile)
+		if err != nil {
+			return fmt.Errorf("error reading the key file: %w", err)
+		}
+		passphrase = strings.TrimSpace(string(data))
+	} else {
+		var err error
+		if passphrase, err = readSecret(os.Stdin, "Passphrase: "); err != nil {
+			return fmt.Errorf("error reading the passphrase: %w", err)
+		}
+		confirmation, err := readSecret(os.Stdin, "Passphrase again: ")
+		if err != nil {
+			return fmt.Errorf("error reading the passphrase: %w", err)
+		}
+		if confirmation != passphrase {
+			return errors.New("the passphrases differ")
+		}
+	}
+
+	var value string
+	if f, ok := in.(*os.File); ok && term.IsTerminal(f.Fd()) {
+		var err error
+		if value, err = readSecret(f, "Value to encrypt: "); err != nil {
+			return err
+		}
+	} else {
+		data, err := io.ReadAll(in)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(data), "\r\n")
+	}
+	if value == "" {
+		return errors.New("nothing to encrypt")
+	}
+	encrypted, err := config.Encrypt(value, passphrase)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, encrypted)
+	return nil
+}
+
+// promptPassphrase asks for the passphrase of the encrypted configuration values on the
+// terminal.
+func promptPassphrase() (string, error) {
+	return readSecret(os.Stdin, "Passphrase of the encrypted configuration values: ")
+}
+
+// readSecret reads a line from the terminal f without echoing it, after printing prompt.
+func readSecret(f *os.File, prompt string) (string, error) {
+	if !term.IsTerminal(f.Fd()) {
+		return "", fmt.Errorf("no terminal to enter it on; set %s to a file holding the passphrase", config.KeyFileEnv)
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(f.Fd())
+	fmt.Fprintln(os.Stderr)
+	return string(secret), err
+}
+
+func runConfigShow(out io.Writer, configPath string, resolved bool) error {
+	r, err := resolveConfig(configPath, config.Overrides{})
+	if err != nil {
+		return err
+	}
+	masked := maskSecrets(*r.Config)
+	if !resolved {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		return enc.Encode(masked)
+	}
+
+	if len(r.Files) == 0 {
+		fmt.Fprintln(out, "Config files: none found")
+	} else {
+		fmt.Fprintf(out, "Config files: %s\n", strings.Join(r.Files, ", "))
+	}
+	// The settings are printed in the order of their fields, which groups related ones.
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(masked); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(&buf)
+	dec.Token() // {
+	tw := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tVALUE\tSOURCE")
+	for dec.More() {
+		name, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return err
+		}
+		if source, ok := r.Sources[name.(string)]; ok {
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", name, value, source)
+		}
+	}
+	return tw.Flush()
+}
+
+// maskSecrets returns cfg with its secrets, and the passwords in its URLs, masked.
+func maskSecrets(cfg config.Config) config.Config {
+	secrets := []*string{&cfg.VaultToken, &cfg.StorageKey}
+	for _, s := range secretSettings(&cfg) {
+		secrets = append(secrets, s.value)
+	}
+	for _, value := range secrets {
+		// References to a secret manager are not secret.
+		if *value != "" && !secret.IsReference(*value) {
+			*value = maskedSecret
+		}
+	}
+	for _, dsn := range []*string{&cfg.PostgresDSN, &cfg.QueueURL} {
+		if u, err := url.Parse(*dsn); err == nil && u.User != nil {
+			if _, ok := u.User.Password(); ok {
+				u.User = url.UserPassword(u.User.Username(), maskedSecret)
+				*dsn = u.String()
+			}
+		}
+	}
+	// The settings of policies may hold secrets of the sinks of the tenant too, and
+	// their API tokens are secrets unless given by their hashes.
+	cfg.Policies = slices.Clone(cfg.Policies)
+	for i := range cfg.Policies {
+		tokens := slices.Clone(cfg.Policies[i].APITokens)
+		for j, token := range tokens {
+			if !token.IsHash() {
+				tokens[j].Token = maskedSecret
+			}
+		}
+		cfg.Policies[i].APITokens = tokens
+		var settings map[string]any
+		if json.Unmarshal(cfg.Policies[i].Settings, &settings) != nil {
+			continue
+		}
+		for _, s := range append(secretSettings(&config.Config{}), secretSetting{name: "vault_token"}) {
+			if value, ok := settings[s.name].(string); ok && value != "" && !secret.IsReference(value) {
+				settings[s.name] = maskedSecret
+			}
+		}
+		cfg.Policies[i].Settings, _ = json.Marshal(settings)
+	}
+	return cfg
+}
+
+// initProvider is a provider choice of "config init".
+type initProvider struct {
+	name     string
+	provider string
+	askURL   bool // Whether to ask for the endpoint
+	model    string
+}
+
+var initProviders = []initProvider{
+	{"OpenAI", config.ProviderOpenAI, false, config.DefaultModelName},
+	{"Other OpenAI-compatible endpoint (Azure OpenAI, LiteLLM, vLLM, ...)", config.ProviderOpenAI, true, config.DefaultModelName},
+	{"Ollama on this machine", config.ProviderOllama, false, "llama3.1"},
+	{"Anthropic", config.ProviderAnthropic, false, "claude-sonnet-4-5"},
+}
+
+// runConfigInit asks for the settings needed to get started and writes them to path.
+func runConfigInit(in io.Reader, out io.Writer, path string, force bool) error {
+	if _, err := os.Stat(path); err == nil && !force {
+		return fmt.Errorf("%s already exists; use --force to overwrite it", path)
+	}
+	q := &questioner{in: bufio.NewScanner(in), out: out}
+
+	fmt.Fprintf(out, "Creating %s\n\nLLM provider:\n", path)
+	var choices []string
+	for _, p := range initProviders {
+		choices = append(choices, p.name)
+	}
+	provider := initProviders[q.choose(choices, 1)]
+
+	section := map[string]any{}
+	settings := map[string]any{"provider": provider.provider, provider.provider: section}
+	for provider.askURL && section["base_url"] == nil && q.err == nil {
+		baseURL := q.ask("Endpoint base URL (e.g. https://llm.example.com/v1)", "")
+		if u, err := url.Parse(baseURL); baseURL == "" || err != nil || u.Scheme == "" || u.Host == "" {
+			if baseURL != "" {
+				fmt.Fprintf(out, "Not a valid URL: %s\n", baseURL)
+			}
+			continue
+		}
+		section["base_url"] = baseURL
+	}
+	section["model"] = q.ask("Model", provider.model)
+
+	keyEnv := config.ProviderKeyEnv(provider.provider)
+	keyChoice := 2
+	if keyEnv != "" {
+		fmt.Fprintf(out, "\nAPI key:\n")
+		keyChoice = q.choose([]string{
+			"Read it from the " + keyEnv + " environment variable (recommended)",
+			"Store it in the configuration file",
+			"None; the endpoint does not require a key",
+		}, 1)
+	}
+	if keyChoice == 1 {
+		for section["api_key"] == nil && q.err == nil {
+			if key := q.ask("API key", ""); key != "" {
+				section["api_key"] = key
+			}
+		}
+	}
+	if q.err != nil {
+		return q.err
+	}
+
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// The file may contain the API key.
+	if err := os.WriteFile(path, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "\nWrote %s.\n", path)
+	if keyChoice == 0 {
+		fmt.Fprintf(out, "Set %s in the environment of mail-analyzer.\n", keyEnv)
+	}
+	fmt.Fprintf(out, "Run \"mail-analyzer config validate\" to test the configuration.\n")
+	return nil
+}
+
+// questioner asks questions on out and reads the answers from in. After the first
+// error, such as the end of the input, every answer is empty and err is set.
+type questioner struct {
+	in  *bufio.Scanner
+	out io.Writer
+	err error
+}
+
+// ask returns the answer to a question, or def if the answer is empty.
+func (q *questioner) ask(question, def string) string {
+	if q.err != nil {
+		return ""
+	}
+	if def != "" {
+		fmt.Fprintf(q.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(q.out, "%s: ", question)
+	}
+	if !q.in.Scan() {
+		q.err = q.in.Err()
+		if q.err == nil {
+			q.err = errors.New("unexpected end of input")
+		}
+		return ""
+	}
+	if answer := strings.TrimSpace(q.in.Text()); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// choose returns the index of the chosen option; def is the 1-based default.
+func (q *questioner) choose(options []string, def int) int {
+	for i, option := range options {
+		fmt.Fprintf(q.out, "  %d) %s\n", i+1, option)
+	}
+	for q.err == nil {
+		answer := q.ask("Choose", fmt.Sprint(def))
+		for i := range options {
+			if answer == fmt.Sprint(i+1) {
+				return i
+			}
+		}
+		if q.err == nil {
+			fmt.Fprintf(q.out, "Enter a number from 1 to %d.\n", len(options))
+		}
+	}
+	return def - 1
+}
+
+// runConfigValidate checks the configuration and reports every problem found.
+func runConfigValidate(out io.Writer, configPath string, offline bool) error {
+	paths, err := configPaths(configPath)
+	if err != nil {
+		return err
+	}
+	problems := 0
+	report := func(format string, args ...any) {
+		problems++
+		fmt.Fprintf(out, "ERROR: "+format+"\n", args...)
+	}
+
+	found := 0
+	for _, path := range paths {
+		switch err := config.CheckFile(path); {
+		case err == nil:
+			found++
+			fmt.Fprintf(out, "Config file: %s\n", path)
+		case os.IsNotExist(err) && configPath == "":
+		default:
+			report("%v", err)
+		}
+	}
+	if found == 0 && configPath == "" {
+		fmt.Fprintf(out, "Config file: none found in %s (using the environment and defaults)\n", strings.Join(paths, ", "))
+	}
+	if names := config.EnvOverrides(); len(names) > 0 {
+		fmt.Fprintf(out, "Environment overrides: %s\n", strings.Join(names, ", "))
+	}
+
+	cfg, err := config.LoadFiles(paths...)
+	if err != nil {
+		report("%v", err)
+		return fmt.Errorf("the configuration has %d problem(s)", problems)
+	}
+	if _, err := resolveSecrets(context.Background(), cfg); err != nil {
+		report("%v", err)
+	}
+	fmt.Fprintf(out, "Model: %s\n", cfg.ModelName)
+	fmt.Fprintf(out, "Endpoint: %s\n", strings.TrimRight(cmp.Or(cfg.OpenAIBaseURL, llm.DefaultBaseURL), "/"))
+	if err := cfg.CheckCredentials(); err != nil {
+		report("%v", err)
+	}
+	if cfg.TemplatesDir != "" {
+		if _, err := analyzer.LoadTemplates(cfg.TemplatesDir); err != nil {
+			report("templates_dir: %v", err)
+		} else {
+			fmt.Fprintf(out, "Templates: %s\n", cfg.TemplatesDir)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(cfg.TemplateSets)) {
+		if _, err := analyzer.LoadTemplates(cfg.TemplateSets[name]); err != nil {
+			report("template_sets.%s: %v", name, err)
+		} else {
+			fmt.Fprintf(out, "Template set %s: %s\n", name, cfg.TemplateSets[name])
+		}
+	}
+	if enrichers, err := enrichment.FromConfig(cfg); err != nil {
+		report("%v", err)
+	} else if len(enrichers) > 0 {
+		fmt.Fprintf(out, "Enrichments: %s\n", strings.Join(enrichers.Names(), ", "))
+	}
+	httpClient, err := httpclient.New(cfg)
+	if err != nil {
+		report("%v", err)
+	}
+
+	if !offline && httpClient != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), connectivityTimeout)
+		defer cancel()
+		models, err := llm.NewOpenAIProviderWithClient(cfg, httpClient).ListModels(ctx)
+		switch {
+		case errors.Is(err, llm.ErrModelsNotSupported):
+			fmt.Fprintf(out, "Connection: ok (the endpoint does not list its models)\n")
+		case err != nil:
+			report("cannot use the LLM endpoint: %v", err)
+		case len(models) > 0 && !slices.Contains(models, cfg.ModelName):
+			fmt.Fprintf(out, "Connection: ok\nWARNING: model %s is not among the %d models offered by the endpoint\n", cfg.ModelName, len(models))
+		default:
+			fmt.Fprintf(out, "Connection: ok\n")
+		}
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("the configuration has %d problem(s)", problems)
+	}
+	fmt.Fprintln(out, "The configuration is valid.")
+	return nil
+}
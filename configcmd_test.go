@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestConfigInit(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]any
+	}{
+		{"openai with env key", "\ngpt-4o\n\n", map[string]any{"provider": "openai", "openai": map[string]any{"model": "gpt-4o"}}},
+		{"custom endpoint with stored key", "2\nnot a url\nhttps://llm.example.com/v1\n\n9\n2\nsk-test\n",
+			map[string]any{"provider": "openai", "openai": map[string]any{"base_url": "https://llm.example.com/v1", "model": "gpt-4-turbo", "api_key": "sk-test"}}},
+		{"ollama", "3\n\n", map[string]any{"provider": "ollama", "ollama": map[string]any{"model": "llama3.1"}}},
+		{"anthropic with env key", "4\n\n\n", map[string]any{"provider": "anthropic", "anthropic": map[string]any{"model": "claude-sonnet-4-5"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name, "config.json")
+			var out bytes.Buffer
+			if err := runConfigInit(strings.NewReader(tt.input), &out, path, false); err != nil {
+				t.Fatalf("runConfigInit() error = %v\n%s", err, out.String())
+			}
+			data, _ := os.ReadFile(path)
+			var got map[string]any
+			json.Unmarshal(data, &got)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("config = %v, want %v", got, tt.want)
+			}
+			if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+				t.Errorf("config file mode = %v, want 0600", info.Mode().Perm())
+			}
+
+			if err := runConfigInit(strings.NewReader(tt.input), &out, path, false); err == nil {
+				t.Error("runConfigInit() overwrote an existing file without --force")
+			}
+		})
+	}
+
+	if err := runConfigInit(strings.NewReader("2\n"), &bytes.Buffer{}, filepath.Join(dir, "eof.json"), false); err == nil {
+		t.Error("runConfigInit() with incomplete input succeeded")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": [{"id": "gpt-4o"}]}`))
+	}))
+	defer server.Close()
+	t.Setenv("OPENAI_API_KEY", "")
+	t.Setenv("MODEL_NAME", "")
+	os.Unsetenv("OPENAI_API_KEY")
+	os.Unsetenv("MODEL_NAME")
+
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		content string
+		wantErr bool
+		want    string
+	}{
+		{"valid", `{"openai_base_url": "` + server.URL + `", "model_name": "gpt-4o"}`, false, "Connection: ok\nThe configuration is valid.\n"},
+		{"unknown model", `{"openai_base_url": "` + server.URL + `", "model_name": "gpt-5"}`, false, "WARNING: model gpt-5 is not among the 1 models"},
+		{"typo", `{"openai_base_url": "` + server.URL + `", "modle_name": "gpt-4o"}`, true, `unknown setting "modle_name"`},
+		{"unreachable", `{"openai_base_url": "http://127.0.0.1:1"}`, true, "ERROR: cannot use the LLM endpoint"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(dir, tt.name+".json")
+			os.WriteFile(path, []byte(tt.content), 0o600)
+			var out bytes.Buffer
+			err := runConfigValidate(&out, path, false)
+			if (err != nil) != tt.wantErr || !strings.Contains(out.String(), tt.want) {
+				t.Errorf("runConfigValidate() error = %v, output:\n%s\nwant %q", err, out.String(), tt.want)
+			}
+		})
+	}
+
+	if err := runConfigValidate(&bytes.Buffer{}, filepath.Join(dir, "missing.json"), true); err == nil {
+		t.Error("runConfigValidate() of a missing file succeeded")
+	}
+}
+
+func TestConfigShowResolved(t *testing.T) {
+	t.Setenv("MODEL_NAME", "")
+	os.Unsetenv("MODEL_NAME")
+	t.Setenv("OPENAI_API_KEY", "sk-secret")
+	home := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", home)
+	os.MkdirAll(filepath.Join(home, "mail-analyzer"), 0o700)
+	userPath := filepath.Join(home, "mail-analyzer", "config.json")
+	os.WriteFile(userPath, []byte(`{"model_name": "gpt-4o", "request_timeout": "2m"}`), 0o600)
+	project := t.TempDir()
+	projectPath := filepath.Join(project, projectConfigName)
+	os.WriteFile(projectPath, []byte(`{"model_name": "llama3.1"}`), 0o600)
+	os.MkdirAll(filepath.Join(project, "sub"), 0o700)
+	t.Chdir(filepath.Join(project, "sub"))
+
+	var out bytes.Buffer
+	if err := runConfigShow(&out, "", true); err != nil {
+		t.Fatalf("runConfigShow() error = %v", err)
+	}
+	for _, want := range []*regexp.Regexp{
+		regexp.MustCompile(`(?m)^model_name +"llama3.1" +` + regexp.QuoteMeta(projectPath) + `$`),
+		regexp.MustCompile(`(?m)^request_timeout +"2m0s" +` + regexp.QuoteMeta(userPath) + `$`),
+		regexp.MustCompile(`(?m)^openai_api_key +"\*+" +env OPENAI_API_KEY$`),
+		regexp.MustCompile(`(?m)^chat_completions_path +"/chat/completions" +default$`),
+	} {
+		if !want.MatchString(out.String()) {
+			t.Errorf("output:\n%s\nwant a line matching %s", out.String(), want)
+		}
+	}
+	if strings.Contains(out.String(), "sk-secret") {
+		t.Errorf("output:\n%s\nshows the API key", out.String())
+	}
+}
+
+func TestLoadConfig_ProjectFile(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	project := t.TempDir()
+	projectPath := filepath.Join(project, projectConfigName)
+	os.WriteFile(projectPath, []byte(`{"model_name": "llama3.1", "openai_api_key_command": ["echo", "sk-test"]}`), 0o600)
+	t.Chdir(project)
+
+	if _, err := loadConfig(""); !errors.Is(err, config.ErrConfig) || !strings.Contains(err.Error(), "openai_api_key_command") {
+		t.Errorf("loadConfig() with a project file setting a command error = %v", err)
+	}
+	if cfg, err := loadConfig(projectPath); err != nil || len(cfg.OpenAIAPIKeyCommand) != 2 {
+		t.Errorf("loadConfig() of the file given with --config = %v, %v", cfg, err)
+	}
+}
+
+func TestUserConfigPath(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("AppData", filepath.Join(home, "AppData", "Roaming"))
+	library := filepath.Join(home, "Library", "Application Support", "mail-analyzer", "config.json")
+	legacy := filepath.Join(home, ".config", "mail-analyzer", "config.json")
+
+	for _, tt := range []struct {
+		goos, want string
+	}{
+		{"linux", legacy},
+		{"windows", filepath.Join(home, "AppData", "Roaming", "mail-analyzer", "config.json")},
+		{"darwin", library},
+	} {
+		if got, err := userConfigPathFor(tt.goos); err != nil || got != tt.want {
+			t.Errorf("userConfigPathFor(%q) = %q, %v, want %q", tt.goos, got, err, tt.want)
+		}
+	}
+
+	// On macOS, the file of earlier versions is used until one is created in Library.
+	os.MkdirAll(filepath.Dir(legacy), 0o700)
+	os.WriteFile(legacy, []byte(`{}`), 0o600)
+	if got, _ := userConfigPathFor("darwin"); got != legacy {
+		t.Errorf("userConfigPathFor(darwin) = %q, want the existing %q", got, legacy)
+	}
+	os.MkdirAll(filepath.Dir(library), 0o700)
+	os.WriteFile(library, []byte(`{}`), 0o600)
+	if got, _ := userConfigPathFor("darwin"); got != library {
+		t.Errorf("userConfigPathFor(darwin) = %q, want %q", got, library)
+	}
+
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+	for _, goos := range []string{"linux", "windows", "darwin"} {
+		if got, _ := userConfigPathFor(goos); got != filepath.Join(home, "xdg", "mail-analyzer", "config.json") {
+			t.Errorf("userConfigPathFor(%q) = %q, want it in $XDG_CONFIG_HOME", goos, got)
+		}
+	}
+}
+
+func TestConfigEncrypt(t *testing.T) {
+	dir := t.TempDir()
+	keyFile := filepath.Join(dir, "key")
+	os.WriteFile(keyFile, []byte("passphrase\n"), 0o600)
+	var out bytes.Buffer
+	if err := runConfigEncrypt(strings.NewReader("sk-secret\n"), &out, keyFile); err != nil {
+		t.Fatalf("runConfigEncrypt() error = %v", err)
+	}
+	value := strings.TrimSpace(out.String())
+	path := filepath.Join(dir, "config.json")
+	os.WriteFile(path, []byte(`{"openai_api_key": "`+value+`"}`), 0o600)
+	t.Setenv(config.KeyFileEnv, keyFile)
+	if cfg, err := config.Load(path); err != nil || cfg.OpenAIAPIKey != "sk-secret" {
+		t.Errorf("Load() of the encrypted value %s = %+v, %v", value, cfg, err)
+	}
+
+	if err := runConfigEncrypt(strings.NewReader(""), &out, keyFile); err == nil {
+		t.Error("runConfigEncrypt() of an empty value succeeded")
+	}
+}
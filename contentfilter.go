@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"mail-analyzer/email"
+	"mail-analyzer/mta"
+)
+
+// reinjectTimeout bounds the handover of a filtered message to the MTA.
+const reinjectTimeout = 5 * time.Minute
+
+// runContentFilter implements the "content-filter" command, for use as a Postfix
+// content_filter with the pipe delivery agent.
+func runContentFilter(args []string) error {
+	fs := newFlagSet("content-filter", "-f sender [--] recipient...",
+		"Read a message from standard input, add the verdict as X-Mail-Analyzer-* headers and\n"+
+			"reinject it with sendmail or over SMTP. If the message cannot be analyzed, it is\n"+
+			"reinjected without a verdict unless --fail-closed is set. The exit status follows\n"+
+			"sysexits.h: 75 defers the message, 69 bounces it.")
+	var pf pipelineFlags
+	pf.register(fs)
+	sender := fs.String("f", "", "Envelope sender of the message (Postfix ${sender})")
+	relay := fs.String("relay", "", "Reinject over SMTP to this host:port instead of with sendmail")
+	sendmail := fs.String("sendmail", "/usr/sbin/sendmail", "sendmail command used to reinject messages without --relay")
+	failClosed := fs.Bool("fail-closed", false, "Defer messages that cannot be analyzed instead of passing them through")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() == 0 {
+		return usageErrorf("no recipients given")
+	}
+	recipients := fs.Args()
+
+	// Every failure from here on defers the message, so that a problem with the filter
+	// never loses or bounces mail.
+	rawMessage, err := io.ReadAll(os.Stdin)
+	if err != nil {
+		return exitCodeError{exTempFail, fmt.Errorf("error reading from stdin: %w", err)}
+	}
+	cfg, err := pf.setup()
+	if err != nil {
+		return exitCodeError{exTempFail, err}
+	}
+
+	ctx := context.Background()
+	var filtered []byte
+	var result *AnalysisResult
+	p, err := newPipeline(cfg, &pf)
+	if err == nil {
+		filtered, result, err = filterMessage(ctx, p, rawMessage, "stdin", *failClosed)
+	} else if !*failClosed {
+		fmt.Fprintf(os.Stderr, "Error: %v; passing the message through without a verdict\n", err)
+		filtered, err = email.Annotate(rawMessage, nil), nil
+	}
+	if err != nil {
+		return exitCodeError{exTempFail, err}
+	}
+
+	var deliverer mta.Deliverer = &mta.Sendmail{Path: *sendmail}
+	if *relay != "" {
+		deliverer = &mta.SMTP{Address: *relay, Timeout: reinjectTimeout}
+	}
+	if err := deliverer.Deliver(ctx, *sender, recipients, filtered); err != nil {
+		err = fmt.Errorf("error reinjecting message: %w", err)
+		if mta.IsPermanent(err) {
+			return exitCodeError{exUnavailable, err}
+		}
+		return exitCodeError{exTempFail, err}
+	}
+
+	if p == nil {
+		return nil
+	}
+	var actErr error
+	if result != nil {
+		actErr = p.act(ctx, result)
+	}
+	if err := errors.Join(p.close(), actErr); err != nil {
+		// The message has been delivered, so a failure here must not make Postfix retry it.
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return nil
+}
+
+// filterMessage analyzes rawMessage, records the result and returns the message with the
+// verdict headers added. If the message cannot be analyzed, it returns the error when
+// failClosed is set, and otherwise the message without a verdict. Forged verdict headers
+// are removed either way.
+func filterMessage(ctx context.Context, p *pipeline, rawMessage []byte, sourceFile string, failClosed bool) ([]byte, *AnalysisResult, error) {
+	result, err := p.analyze(ctx, rawMessage, sourceFile)
+	if err != nil {
+		if failClosed {
+			return nil, nil, err
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v; passing the message through without a verdict\n", err)
+		// Annotate without headers only removes forged verdict headers.
+		return email.Annotate(rawMessage, nil), nil, nil
+	}
+	if err := p.record(ctx, result); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+	return email.Annotate(rawMessage, annotationHeaders(result)), result, nil
+}
@@ -16,6 +16,10 @@ import (
 
 // ConvertToUTF8 reads email content from r, detects its charset, and converts it to UTF-8.
 // It returns a new io.Reader containing the UTF-8 encoded content.
+//
+// Deprecated: email.Parse decodes the transfer encoding and charset of each part of a
+// message as it reads it. ConvertToUTF8 only converts messages with a single part, and
+// loses the boundaries and charsets of the parts of multipart messages.
 func ConvertToUTF8(r io.Reader) (io.Reader, error) {
 	contentBytes, err := io.ReadAll(r)
 	if err != nil {
@@ -0,0 +1,29 @@
+package converter
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func FuzzConvertToUTF8(f *testing.F) {
+	f.Add("Subject: Hi\r\nContent-Type: text/plain; charset=iso-2022-jp\r\n\r\n\x1b$B$3$s\x1b(B\r\n")
+	f.Add("Content-Type: text/plain; charset=shift_jis\r\n\r\n\x82\xa0\r\n")
+	f.Add("Content-Type: text/plain; charset=euc-jp\r\n\r\n\xa4\xa2\r\n")
+	f.Add(" folded\r\nContent-Type: text/plain; charset=iso-2022-jp\r\n")
+	f.Fuzz(func(t *testing.T, raw string) {
+		r, err := ConvertToUTF8(strings.NewReader(raw))
+		if err != nil {
+			return
+		}
+		converted, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("reading the converted message: %v", err)
+		}
+		// A message is either left as is or converted to UTF-8.
+		if string(converted) != raw && !utf8.Valid(converted) {
+			t.Errorf("ConvertToUTF8(%q) = %q, which is not UTF-8", raw, converted)
+		}
+	})
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// dedupKeys returns the keys under which a message is a duplicate of another message of a
+// batch: its Message-ID, if it has one, and a hash of its content, the sender, subject,
+// body, URLs, and the contents of the attachments and images, which copies of a message
+// relayed or reported by different users share although their other headers differ. The keys are qualified by the tenant,
+// whose policy changes the result.
+func dedupKeys(a *analysis) []string {
+	tenant := ""
+	if a.policy != nil {
+		tenant = a.policy.Tenant
+	}
+	e := a.email
+	h := sha256.New()
+	for _, addr := range e.From {
+		fmt.Fprintf(h, "from %s\n", strings.ToLower(addr.Address))
+	}
+	fmt.Fprintf(h, "subject %s\n", strings.Join(strings.Fields(e.Subject), " "))
+	fmt.Fprintf(h, "body %s\n", strings.Join(strings.Fields(e.Body), " "))
+	for _, u := range e.URLs {
+		fmt.Fprintf(h, "url %s\n", u)
+	}
+	for _, att := range e.Attachments {
+		fmt.Fprintf(h, "attachment %s %s %d\n", att.Filename, att.ContentType, att.Size)
+	}
+	for _, hash := range a.hashes {
+		fmt.Fprintf(h, "attachment sha256 %s\n", hash)
+	}
+	for _, img := range e.Images {
+		fmt.Fprintf(h, "image %s %x\n", img.ContentType, sha256.Sum256(img.Data))
+	}
+	keys := []string{tenant + "\x00content:" + hex.EncodeToString(h.Sum(nil))}
+	if e.MessageID != "" {
+		keys = append(keys, tenant+"\x00id:"+e.MessageID)
+	}
+	return keys
+}
+
+// repeat returns the result of a, a duplicate of the message of original in a batch,
+// without analyzing it again: the judgment, enrichments and plugin verdicts are those of
+// original, and the after hooks are called with them.
+func (p *pipeline) repeat(a *analysis, original *AnalysisResult) (*AnalysisResult, error) {
+	judgment := *original.Judgment
+	if err := p.hooks.AfterAnalysis(a.ctx, a.email, &judgment); err != nil {
+		return nil, fmt.Errorf("error running hooks (Message-ID: %s): %w", a.email.MessageID, err)
+	}
+	a.judgment = &judgment
+	result := p.newResult(a, original.Plugins)
+	// The policy was applied to the judgment of original already.
+	result.Judgment = &judgment
+	result.Enrichments = original.Enrichments
+	result.Model, result.Provider = original.Model, original.Provider
+	result.DuplicateOf = original.SourceFile
+	return result, nil
+}
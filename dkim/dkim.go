@@ -0,0 +1,430 @@
+// Package dkim verifies the DKIM signatures (RFC 6376) of the messages that users forward
+// as samples, to tell whether a sample is still the message that its sender signed, or
+// was modified since, by the user who reported it or on its way.
+package dkim
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Statuses of an Integrity.
+const (
+	// StatusIntact means that a signature of the message verifies: neither its body nor
+	// its signed header fields were modified.
+	StatusIntact = "intact"
+	// StatusBodyAppended means that a signature verifies, but its l= tag only covers the
+	// start of the body, and the message has content after it that was not signed, such
+	// as a footer or a link added to the end.
+	StatusBodyAppended = "body_appended"
+	// StatusBodyModified means that the body of the message no longer has the hash of
+	// any of its signatures.
+	StatusBodyModified = "body_modified"
+	// StatusHeaderModified means that the body of the message is unchanged, but a signed
+	// header field was modified.
+	StatusHeaderModified = "header_modified"
+	// StatusUnsigned means that the message has no DKIM signature.
+	StatusUnsigned = "unsigned"
+	// StatusUnverified means that the signatures could not be verified, such as when the
+	// key of the signer is no longer published, although the body is unchanged.
+	StatusUnverified = "unverified"
+)
+
+// Results of a Signature, as in the Authentication-Results header field (RFC 8601).
+const (
+	ResultPass      = "pass"
+	ResultFail      = "fail"
+	ResultNeutral   = "neutral"
+	ResultTempError = "temperror"
+	ResultPermError = "permerror"
+)
+
+// maxSignatures is the number of signatures of a message that are verified, so that a
+// crafted sample cannot cause any number of key lookups.
+const maxSignatures = 5
+
+// minRSABits is the size of the smallest RSA key accepted (RFC 8301).
+const minRSABits = 1024
+
+// Integrity is what the DKIM signatures of a message tell of whether it was modified
+// since it was signed.
+type Integrity struct {
+	// Status is one of the Status constants.
+	Status     string      `json:"status"`
+	Signatures []Signature `json:"signatures,omitempty"`
+}
+
+// Signature is the verification of a DKIM-Signature header field.
+type Signature struct {
+	// Domain and Selector are the d= and s= tags of the signature, which name its key.
+	Domain   string `json:"domain,omitempty"`
+	Selector string `json:"selector,omitempty"`
+	// Result is one of the Result constants.
+	Result string `json:"result"`
+	// BodyHash is "match" or "mismatch", or "" if the body was not hashed because the
+	// signature is malformed.
+	BodyHash string `json:"body_hash,omitempty"`
+	// UnsignedBytes is the length of the canonical body after the length signed with the
+	// l= tag, which the signature does not cover.
+	UnsignedBytes int64 `json:"unsigned_bytes,omitempty"`
+	// Reason explains a result other than pass.
+	Reason string `json:"reason,omitempty"`
+}
+
+// Verifier verifies the DKIM signatures of messages.
+type Verifier struct {
+	// Resolver is used to look up the keys of the signers, or net.DefaultResolver if nil.
+	Resolver *net.Resolver
+}
+
+// Verify returns the integrity of the message raw, whose lines may end with LF alone, as
+// in most files. The expiration of the signatures is not checked, since samples are
+// usually verified some time after they were received.
+func (v *Verifier) Verify(ctx context.Context, raw []byte) *Integrity {
+	raw = bytes.ReplaceAll(bytes.ReplaceAll(raw, []byte("\r\n"), []byte("\n")), []byte("\n"), []byte("\r\n"))
+	header, body, _ := bytes.Cut(raw, []byte("\r\n\r\n"))
+	fields := splitFields(append(header, "\r\n"...))
+
+	integrity := &Integrity{Status: StatusUnsigned}
+	// The signatures are verified from the topmost, which was added last.
+	for _, f := range fields {
+		if strings.EqualFold(f.name, "DKIM-Signature") && len(integrity.Signatures) < maxSignatures {
+			integrity.Signatures = append(integrity.Signatures, v.verify(ctx, fields, f, body))
+		}
+	}
+	if len(integrity.Signatures) > 0 {
+		integrity.Status = status(integrity.Signatures)
+	}
+	return integrity
+}
+
+// status returns the status of a message with signatures.
+func status(signatures []Signature) string {
+	bodyAppended, bodyModified, headerModified := false, false, false
+	for _, s := range signatures {
+		switch {
+		case s.Result == ResultPass && s.UnsignedBytes == 0:
+			return StatusIntact
+		case s.Result == ResultPass:
+			bodyAppended = true
+		case s.BodyHash == "mismatch":
+			bodyModified = true
+		case s.Result == ResultFail:
+			headerModified = true
+		}
+	}
+	switch {
+	case bodyAppended:
+		return StatusBodyAppended
+	case bodyModified:
+		return StatusBodyModified
+	case headerModified:
+		return StatusHeaderModified
+	}
+	return StatusUnverified
+}
+
+// field is a header field, with its name and its text, continuation lines and final CRLF
+// included, as it appears in the message.
+type field struct {
+	name, text string
+}
+
+// splitFields returns the fields of header, which ends with CRLF.
+func splitFields(header []byte) []field {
+	var fields []field
+	for _, line := range strings.SplitAfter(string(header), "\r\n") {
+		switch {
+		case line == "":
+		case (line[0] == ' ' || line[0] == '\t') && len(fields) > 0:
+			fields[len(fields)-1].text += line
+		default:
+			name, _, _ := strings.Cut(line, ":")
+			fields = append(fields, field{name: strings.TrimRight(name, " \t"), text: line})
+		}
+	}
+	return fields
+}
+
+// verify verifies the signature of the field sig.
+func (v *Verifier) verify(ctx context.Context, fields []field, sig field, body []byte) Signature {
+	_, value, _ := strings.Cut(sig.text, ":")
+	tags, err := parseTags(value)
+	if err != nil {
+		return Signature{Result: ResultPermError, Reason: err.Error()}
+	}
+	s := Signature{Domain: tags["d"], Selector: tags["s"]}
+	fail := func(result, format string, args ...any) Signature {
+		s.Result, s.Reason = result, fmt.Sprintf(format, args...)
+		return s
+	}
+	for _, tag := range []string{"v", "a", "b", "bh", "d", "h", "s"} {
+		if _, ok := tags[tag]; !ok {
+			return fail(ResultPermError, "the signature has no %s= tag", tag)
+		}
+	}
+	if tags["v"] != "1" {
+		return fail(ResultPermError, "unsupported version %q", tags["v"])
+	}
+	var keyType string
+	switch strings.ToLower(tags["a"]) {
+	case "rsa-sha256":
+		keyType = "rsa"
+	case "ed25519-sha256":
+		keyType = "ed25519"
+	case "rsa-sha1":
+		return fail(ResultPermError, "rsa-sha1 signatures are no longer accepted (RFC 8301)")
+	default:
+		return fail(ResultNeutral, "unsupported algorithm %q", tags["a"])
+	}
+	headerCanon, bodyCanon, _ := strings.Cut(strings.ToLower(tags["c"]), "/")
+	if headerCanon == "" {
+		headerCanon = "simple"
+	}
+	if bodyCanon == "" {
+		bodyCanon = "simple"
+	}
+	if (headerCanon != "simple" && headerCanon != "relaxed") || (bodyCanon != "simple" && bodyCanon != "relaxed") {
+		return fail(ResultPermError, "unsupported canonicalization %q", tags["c"])
+	}
+	signed := strings.Split(tags["h"], ":")
+	signsFrom := false
+	for i := range signed {
+		signed[i] = strings.TrimSpace(signed[i])
+		signsFrom = signsFrom || strings.EqualFold(signed[i], "From")
+	}
+	if !signsFrom {
+		return fail(ResultPermError, "the signature does not cover the From field")
+	}
+
+	canonical := canonicalBody(body, bodyCanon)
+	if l, ok := tags["l"]; ok {
+		n, err := strconv.ParseInt(l, 10, 64)
+		if err != nil || n < 0 {
+			return fail(ResultPermError, "invalid body length %q", l)
+		}
+		if n > int64(len(canonical)) {
+			s.BodyHash = "mismatch"
+			return fail(ResultFail, "the body is shorter than the signed length")
+		}
+		s.UnsignedBytes = int64(len(canonical)) - n
+		canonical = canonical[:n]
+	}
+	bodyHash := sha256.Sum256(canonical)
+	if base64.StdEncoding.EncodeToString(bodyHash[:]) != tags["bh"] {
+		s.BodyHash = "mismatch"
+		return fail(ResultFail, "the body hash does not match")
+	}
+	s.BodyHash = "match"
+
+	key, err := v.lookupKey(ctx, tags["s"], tags["d"], keyType)
+	if err != nil {
+		var keyErr *keyError
+		if errors.As(err, &keyErr) {
+			return fail(keyErr.result, "%s", keyErr.reason)
+		}
+		return fail(ResultTempError, "%v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		return fail(ResultPermError, "invalid signature: %v", err)
+	}
+	h := sha256.New()
+	for _, f := range signedFields(fields, signed) {
+		h.Write([]byte(canonicalField(f.text, headerCanon)))
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalField(stripSignature(sig.text), headerCanon), "\r\n")))
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		err = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest, signature)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, digest, signature) {
+			err = errors.New("invalid signature")
+		}
+	}
+	if err != nil {
+		return fail(ResultFail, "the signature does not match the signed header fields")
+	}
+	s.Result = ResultPass
+	return s
+}
+
+// parseTags returns the tags of a tag list (RFC 6376 section 3.2), with the white space
+// of their values removed, which is only significant in none of the tags used.
+func parseTags(list string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, spec := range strings.Split(list, ";") {
+		if strings.TrimSpace(spec) == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(spec, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("malformed tag %q", strings.TrimSpace(spec))
+		}
+		if _, dup := tags[name]; dup {
+			return nil, fmt.Errorf("duplicate tag %s=", name)
+		}
+		tags[name] = strings.Join(strings.Fields(value), "")
+	}
+	return tags, nil
+}
+
+// signedFields returns the fields named by the h= tag of a signature, in order. A name
+// listed several times selects the instances of the field from the bottom up, and a
+// name without an instance left selects nothing.
+func signedFields(fields []field, names []string) []field {
+	used := map[int]bool{}
+	var selected []field
+	for _, name := range names {
+		for i := len(fields) - 1; i >= 0; i-- {
+			if !used[i] && strings.EqualFold(fields[i].name, name) {
+				used[i] = true
+				selected = append(selected, fields[i])
+				break
+			}
+		}
+	}
+	return selected
+}
+
+// stripSignature returns the DKIM-Signature field text with the value of its b= tag
+// removed, as it was when it was signed.
+func stripSignature(text string) string {
+	name, value, _ := strings.Cut(text, ":")
+	specs := strings.Split(value, ";")
+	for i, spec := range specs {
+		tag, _, ok := strings.Cut(spec, "=")
+		if ok && strings.TrimSpace(tag) == "b" {
+			specs[i] = spec[:strings.Index(spec, "=")+1]
+			if strings.HasSuffix(spec, "\r\n") && i == len(specs)-1 {
+				specs[i] += "\r\n"
+			}
+		}
+	}
+	return name + ":" + strings.Join(specs, ";")
+}
+
+// canonicalField returns the text of a header field in the canonicalization c.
+func canonicalField(text, c string) string {
+	if c == "simple" {
+		return text
+	}
+	name, value, _ := strings.Cut(text, ":")
+	value = strings.NewReplacer("\r\n", "").Replace(value)
+	value = strings.Join(strings.FieldsFunc(value, isWSP), " ")
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + value + "\r\n"
+}
+
+// canonicalBody returns body, without the blank line that ends the header, in the
+// canonicalization c.
+func canonicalBody(body []byte, c string) []byte {
+	lines := strings.Split(string(body), "\r\n")
+	if c == "relaxed" {
+		for i, line := range lines {
+			line = strings.TrimRight(line, " \t")
+			var b strings.Builder
+			space := false
+			for _, r := range line {
+				if isWSP(r) {
+					space = true
+					continue
+				}
+				if space {
+					b.WriteByte(' ')
+					space = false
+				}
+				b.WriteRune(r)
+			}
+			lines[i] = b.String()
+		}
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		if c == "relaxed" {
+			return nil
+		}
+		return []byte("\r\n")
+	}
+	return []byte(strings.Join(lines, "\r\n") + "\r\n")
+}
+
+func isWSP(r rune) bool { return r == ' ' || r == '\t' }
+
+// keyError is a lookup of a key that failed with the given result.
+type keyError struct {
+	result, reason string
+}
+
+func (e *keyError) Error() string { return e.reason }
+
+// lookupKey returns the public key of type keyType published by domain under selector.
+func (v *Verifier) lookupKey(ctx context.Context, selector, domain, keyType string) (crypto.PublicKey, error) {
+	resolver := v.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	name := selector + "._domainkey." + domain
+	records, err := resolver.LookupTXT(ctx, name)
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return nil, &keyError{ResultPermError, "no key is published at " + name}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error looking up the key at %s: %w", name, err)
+	}
+	if len(records) == 0 {
+		return nil, &keyError{ResultPermError, "no key is published at " + name}
+	}
+	tags, err := parseTags(records[0])
+	if err != nil {
+		return nil, &keyError{ResultPermError, fmt.Sprintf("malformed key at %s: %v", name, err)}
+	}
+	if v, ok := tags["v"]; ok && v != "DKIM1" {
+		return nil, &keyError{ResultPermError, fmt.Sprintf("unsupported key version %q at %s", v, name)}
+	}
+	if k := strings.ToLower(tags["k"]); k != keyType && !(k == "" && keyType == "rsa") {
+		return nil, &keyError{ResultPermError, fmt.Sprintf("the key at %s is not an %s key", name, keyType)}
+	}
+	if tags["p"] == "" {
+		return nil, &keyError{ResultPermError, "the key at " + name + " was revoked"}
+	}
+	data, err := base64.StdEncoding.DecodeString(tags["p"])
+	if err != nil {
+		return nil, &keyError{ResultPermError, fmt.Sprintf("malformed key at %s: %v", name, err)}
+	}
+	if keyType == "ed25519" {
+		if len(data) != ed25519.PublicKeySize {
+			return nil, &keyError{ResultPermError, "malformed ed25519 key at " + name}
+		}
+		return ed25519.PublicKey(data), nil
+	}
+	var key *rsa.PublicKey
+	if parsed, err := x509.ParsePKIXPublicKey(data); err == nil {
+		key, _ = parsed.(*rsa.PublicKey)
+	} else {
+		key, _ = x509.ParsePKCS1PublicKey(data)
+	}
+	if key == nil {
+		return nil, &keyError{ResultPermError, "malformed RSA key at " + name}
+	}
+	if key.N.BitLen() < minRSABits {
+		return nil, &keyError{ResultPermError, fmt.Sprintf("the key at %s has fewer than %d bits", name, minRSABits)}
+	}
+	return key, nil
+}
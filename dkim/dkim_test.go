@@ -0,0 +1,195 @@
+package dkim
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// signedMessage is the example of RFC 8463, signed with ed25519 by
+// brisbane._domainkey.football.example.com.
+const signedMessage = "DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n" +
+	" d=football.example.com; i=@football.example.com;\r\n" +
+	" q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n" +
+	" subject : date : message-id : from : subject : date;\r\n" +
+	" bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n" +
+	" b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11Bus\r\n" +
+	" Fa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==\r\n" +
+	"From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+const brisbaneKey = "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+
+// newFakeResolver returns a resolver that queries a DNS server answering with the TXT
+// records of zone, and NXDOMAIN for other names.
+func newFakeResolver(t *testing.T, zone map[string]string) *net.Resolver {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 4096)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) == 0 {
+				continue
+			}
+			q := query.Questions[0]
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			record, ok := zone[strings.TrimSuffix(strings.ToLower(q.Name.String()), ".")]
+			switch {
+			case !ok:
+				reply.RCode = dnsmessage.RCodeNameError
+			case q.Type == dnsmessage.TypeTXT:
+				// Character strings are at most 255 bytes long.
+				var txt []string
+				for len(record) > 255 {
+					txt, record = append(txt, record[:255]), record[255:]
+				}
+				reply.Answers = append(reply.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: q.Name, Type: dnsmessage.TypeTXT, Class: dnsmessage.ClassINET},
+					Body:   &dnsmessage.TXTResource{TXT: append(txt, record)},
+				})
+			}
+			packed, err := reply.Pack()
+			if err == nil {
+				conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func TestVerifier_Verify(t *testing.T) {
+	v := &Verifier{Resolver: newFakeResolver(t, map[string]string{
+		"brisbane._domainkey.football.example.com": brisbaneKey,
+	})}
+	tests := []struct {
+		name       string
+		message    string
+		wantStatus string
+		wantResult string
+	}{
+		{"Intact", signedMessage, StatusIntact, ResultPass},
+		{"Line feeds", strings.ReplaceAll(signedMessage, "\r\n", "\n"), StatusIntact, ResultPass},
+		{"White space changed", strings.Replace(signedMessage, "Subject: Is dinner", "Subject:   Is  dinner", 1), StatusIntact, ResultPass},
+		{"Unsigned header added", "X-Forwarded-By: reporter\r\n" + signedMessage, StatusIntact, ResultPass},
+		{"Body modified", strings.Replace(signedMessage, "We lost", "We won", 1), StatusBodyModified, ResultFail},
+		{"Header modified", strings.Replace(signedMessage, "Is dinner ready?", "Is lunch ready?", 1), StatusHeaderModified, ResultFail},
+		{"Key not found", strings.Replace(signedMessage, "s=brisbane", "s=sydney", 1), StatusUnverified, ResultPermError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := v.Verify(context.Background(), []byte(tt.message))
+			if got.Status != tt.wantStatus || len(got.Signatures) != 1 || got.Signatures[0].Result != tt.wantResult {
+				t.Errorf("Verify() = %+v, want status %s and result %s", got, tt.wantStatus, tt.wantResult)
+			}
+		})
+	}
+
+	if got := v.Verify(context.Background(), []byte("From: a@example.com\r\n\r\nHi.\r\n")); got.Status != StatusUnsigned || got.Signatures != nil {
+		t.Errorf("Verify() of an unsigned message = %+v", got)
+	}
+	s := v.Verify(context.Background(), []byte(strings.NewReplacer("h=from :", "h=", "from : subject", "subject").Replace(signedMessage))).Signatures[0]
+	if s.Result != ResultPermError || !strings.Contains(s.Reason, "From") {
+		t.Errorf("signature without From = %+v, want permerror", s)
+	}
+}
+
+// sign returns message with a DKIM signature by key, with the canonicalization c and
+// the tags extra.
+func sign(t *testing.T, key *rsa.PrivateKey, message, c, extra string) string {
+	t.Helper()
+	message = strings.ReplaceAll(message, "\n", "\r\n")
+	header, body, _ := strings.Cut(message, "\r\n\r\n")
+	_, bodyCanon, _ := strings.Cut(c, "/")
+	bh := sha256.Sum256(canonicalBody([]byte(body), bodyCanon))
+	sig := "DKIM-Signature: v=1; a=rsa-sha256; c=" + c + "; d=example.com; s=mail;" + extra + "\r\n" +
+		" h=From:Subject; bh=" + base64.StdEncoding.EncodeToString(bh[:]) + "; b="
+	headerCanon, _, _ := strings.Cut(c, "/")
+	h := sha256.New()
+	for _, f := range signedFields(splitFields([]byte(header+"\r\n")), []string{"From", "Subject"}) {
+		h.Write([]byte(canonicalField(f.text, headerCanon)))
+	}
+	h.Write([]byte(strings.TrimSuffix(canonicalField(sig, headerCanon), "\r\n")))
+	b, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, h.Sum(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sig + base64.StdEncoding.EncodeToString(b) + "\r\n" + message
+}
+
+func TestVerifier_VerifyRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	v := &Verifier{Resolver: newFakeResolver(t, map[string]string{
+		"mail._domainkey.example.com":    "v=DKIM1; p=" + base64.StdEncoding.EncodeToString(der),
+		"revoked._domainkey.example.com": "v=DKIM1; p=",
+	})}
+	message := "From: Billing <billing@example.com>\nSubject: Invoice 42\n\nPlease find the invoice at https://example.com/i/42.\n"
+
+	for _, c := range []string{"simple/simple", "relaxed/simple", "simple/relaxed"} {
+		if got := v.Verify(context.Background(), []byte(sign(t, key, message, c, ""))); got.Status != StatusIntact {
+			t.Errorf("Verify() with %s = %+v, want intact", c, got)
+		}
+	}
+
+	// With l=, the signature still verifies when text is added after the signed part of
+	// the body, but the sample is not intact.
+	body := "Please find the invoice at https://example.com/i/42.\r\n"
+	signed := sign(t, key, message, "simple/simple", " l="+strconv.Itoa(len(body))+";")
+	if got := v.Verify(context.Background(), []byte(signed)); got.Status != StatusIntact {
+		t.Errorf("Verify() with l= covering the body = %+v, want intact", got)
+	}
+	appended := "Pay now at https://example.net/pay\n"
+	got := v.Verify(context.Background(), []byte(signed+appended))
+	if got.Status != StatusBodyAppended || got.Signatures[0].Result != ResultPass || got.Signatures[0].UnsignedBytes != int64(len(appended)+1) {
+		t.Errorf("Verify() with l= and appended text = %+v, want body_appended", got)
+	}
+	if got := v.Verify(context.Background(), []byte(strings.Replace(signed, "i/42", "i/43", 1))); got.Status != StatusBodyModified {
+		t.Errorf("Verify() of a modified URL = %+v, want body_modified", got)
+	}
+
+	revoked := strings.Replace(sign(t, key, message, "simple/simple", ""), "s=mail", "s=revoked", 1)
+	got = v.Verify(context.Background(), []byte(revoked))
+	if got.Status != StatusUnverified || got.Signatures[0].BodyHash != "match" || !strings.Contains(got.Signatures[0].Reason, "revoked") {
+		t.Errorf("Verify() with a revoked key = %+v, want unverified", got)
+	}
+}
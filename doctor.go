@@ -0,0 +1,121 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"mail-analyzer/analyzer"
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/httpclient"
+	"mail-analyzer/llm"
+)
+
+// doctorMessage is the message that doctor asks the model to analyze. It is an obvious
+// phishing attempt, so that any working model should flag it.
+const doctorMessage = "From: \"Account Security\" <security@examp1e-bank.com>\r\n" +
+	"To: user@example.com\r\n" +
+	"Subject: Your account has been suspended\r\n" +
+	"Message-ID: <doctor@mail-analyzer.invalid>\r\n" +
+	"\r\n" +
+	"We detected unusual activity. Verify your password within 24 hours or your account\r\n" +
+	"will be closed: http://examp1e-bank.com.login-verify.example.net/\r\n"
+
+func runDoctor(args []string) error {
+	fs := newFlagSet("doctor", "",
+		"Send a test message to the configured LLM endpoint and check that the model is\n"+
+			"available, calls the analysis tool and answers in time, before analyzing real mail.")
+	configPath := fs.String("config", "", configFlagHelp)
+	debug := fs.Bool("debug", false, "Enable debug logging")
+	timeout := fs.Duration("timeout", 2*time.Minute, "Give up on the endpoint after this long")
+	var overrides config.Overrides
+	registerEndpointFlags(fs, &overrides)
+	if err := parseConfigFlags(fs, args); err != nil {
+		return err
+	}
+	setupLogging(*debug)
+	r, err := resolveConfig(*configPath, overrides)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return runDoctorChecks(ctx, os.Stdout, r.Config)
+}
+
+// runDoctorChecks tests the LLM endpoint of cfg and reports every problem found.
+func runDoctorChecks(ctx context.Context, out io.Writer, cfg *config.Config) error {
+	failed := 0
+	report := func(format string, args ...any) {
+		failed++
+		fmt.Fprintf(out, "ERROR: "+format+"\n", args...)
+	}
+
+	fmt.Fprintf(out, "Endpoint: %s\n", strings.TrimRight(cmp.Or(cfg.OpenAIBaseURL, llm.DefaultBaseURL), "/"))
+	fmt.Fprintf(out, "Model: %s\n", cfg.ModelName)
+	if err := cfg.CheckCredentials(); err != nil {
+		report("%v", err)
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	httpClient, err := httpclient.New(cfg)
+	if err != nil {
+		report("%v", err)
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	provider := llm.NewOpenAIProviderWithClient(cfg, httpClient)
+
+	models, err := provider.ListModels(ctx)
+	switch {
+	case errors.Is(err, llm.ErrModelsNotSupported):
+		fmt.Fprintf(out, "Model availability: unknown (the endpoint does not list its models)\n")
+	case err != nil:
+		report("cannot list the models of the endpoint: %v", err)
+	case len(models) > 0 && !slices.Contains(models, cfg.ModelName):
+		report("model %s is not among the %d models offered by the endpoint", cfg.ModelName, len(models))
+	default:
+		fmt.Fprintf(out, "Model availability: ok\n")
+	}
+
+	parsed, err := email.Parse(strings.NewReader(doctorMessage))
+	if err != nil {
+		return err
+	}
+	result, err := provider.Probe(ctx, analyzer.Prompt(parsed), []llm.APITool{analyzer.AnalysisTool()}, "auto")
+	if result == nil {
+		report("test analysis failed: %v", err)
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	latency := fmt.Sprintf("Latency: %s", result.Latency.Round(time.Millisecond))
+	if result.Usage != nil {
+		latency += fmt.Sprintf(" (%d prompt and %d completion tokens)", result.Usage.PromptTokens, result.Usage.CompletionTokens)
+	}
+	fmt.Fprintln(out, latency)
+	switch {
+	case err != nil:
+		report("the response to the test message contains no valid judgment: %v", err)
+	case !result.ToolCall:
+		fmt.Fprintf(out, "Tool calling: WARNING: the model answered in the message content instead of calling the tool;\n"+
+			"  the judgment could be recovered this time, but results may be unreliable\n")
+	default:
+		fmt.Fprintf(out, "Tool calling: ok\n")
+	}
+	if j := result.Judgment; j != nil {
+		fmt.Fprintf(out, "Test judgment: %s (confidence %.2f)\n", j.Category, j.ConfidenceScore)
+		if !j.IsSuspicious {
+			fmt.Fprintf(out, "WARNING: the model judged an obvious phishing message as not suspicious\n")
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	fmt.Fprintln(out, "All checks passed.")
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+func TestRunDoctorChecks(t *testing.T) {
+	args := `{"is_suspicious": true, "category": "Phishing", "reason": "Fake login.", "confidence_score": 0.9}`
+	tests := []struct {
+		name    string
+		models  string
+		message llm.Message
+		wantErr bool
+		want    string
+	}{
+		{
+			name:    "tool call",
+			models:  `{"data": [{"id": "gpt-4o"}]}`,
+			message: llm.Message{ToolCalls: []llm.ToolCall{{Function: llm.FunctionCall{Name: "report_analysis_result", Arguments: args}}}},
+			want:    "Model availability: ok\n",
+		},
+		{
+			name:    "content",
+			models:  `{"data": [{"id": "gpt-4o"}]}`,
+			message: llm.Message{Content: "```json\n" + args + "\n```"},
+			want:    "Tool calling: WARNING",
+		},
+		{
+			name:    "unknown model",
+			models:  `{"data": [{"id": "llama3.1"}]}`,
+			message: llm.Message{ToolCalls: []llm.ToolCall{{Function: llm.FunctionCall{Name: "report_analysis_result", Arguments: args}}}},
+			wantErr: true,
+			want:    "ERROR: model gpt-4o is not among the 1 models",
+		},
+		{
+			name:    "no judgment",
+			models:  `{"data": [{"id": "gpt-4o"}]}`,
+			message: llm.Message{Content: "I cannot help with that."},
+			wantErr: true,
+			want:    "ERROR: the response to the test message contains no valid judgment",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path == "/models" {
+					w.Write([]byte(tt.models))
+					return
+				}
+				json.NewEncoder(w).Encode(llm.APIResponse{Choices: []llm.Choice{{Message: tt.message}}})
+			}))
+			defer server.Close()
+
+			var out bytes.Buffer
+			cfg := &config.Config{OpenAIBaseURL: server.URL, ChatCompletionsPath: "/chat/completions", ModelName: "gpt-4o"}
+			err := runDoctorChecks(context.Background(), &out, cfg)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runDoctorChecks() error = %v, wantErr %t", err, tt.wantErr)
+			}
+			if !strings.Contains(out.String(), tt.want) {
+				t.Errorf("output = %q, want it to contain %q", out.String(), tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"mail-analyzer/llm/llmtest"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of TestGolden")
+
+// volatileFields matches the fields of the output that change on every run, whose values
+// are replaced before the output is compared.
+var volatileFields = regexp.MustCompile(`("(?:analysis_id|received_at|analyzed_at)": )"[^"]*"`)
+
+// TestGolden runs the analyze command, with a fake LLM endpoint, on every message of
+// testdata/e2e, and compares its output and the prompt sent to the model with the golden
+// files of the message: name.json and name.prompt.txt. After an intended change of the
+// parsing or of the prompt, rewrite them with
+//
+//	go test -run TestGolden -update
+//
+// and review their diff.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "e2e", "*.eml"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no messages in testdata/e2e: %v", err)
+	}
+	for _, file := range files {
+		name := strings.TrimSuffix(file, ".eml")
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			server := llmtest.NewServer(llmtest.Verdict("Safe", 0.8, "Golden test verdict."))
+			defer server.Close()
+			dir := t.TempDir()
+			configPath := filepath.Join(dir, "config.json")
+			config := fmt.Sprintf(`{"openai_base_url": %q, "chat_completions_path": "/chat/completions", "model_name": "golden-model"}`, server.URL)
+			if err := os.WriteFile(configPath, []byte(config), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			output := filepath.Join(dir, "result.json")
+
+			if err := runAnalyze([]string{"--config", configPath, "--output", output, file}); err != nil {
+				t.Fatalf("analyze %s: %v", file, err)
+			}
+			result, err := os.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(volatileFields.FindAll(result, -1)); n != 3 {
+				t.Errorf("output has %d of analysis_id, received_at and analyzed_at, want 3", n)
+			}
+			result = volatileFields.ReplaceAll(result, []byte(`$1"..."`))
+			requests := server.Requests()
+			if len(requests) != 1 {
+				t.Fatalf("analyze %s sent %d requests, want 1", file, len(requests))
+			}
+			var prompt strings.Builder
+			for _, m := range requests[0].Messages {
+				fmt.Fprintf(&prompt, "--- %s ---\n%s\n", m.Role, m.Content)
+			}
+
+			checkGolden(t, name+".json", result)
+			checkGolden(t, name+".prompt.txt", []byte(prompt.String()))
+		})
+	}
+}
+
+// checkGolden compares got with the golden file path, or rewrites it with -update.
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v; run go test -run TestGolden -update to create it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("%s differs from the output:\n--- got ---\n%s\n--- want ---\n%s\nRun go test -run TestGolden -update if the change is intended.", path, got, want)
+	}
+}
@@ -0,0 +1,105 @@
+package email
+
+import (
+	"bytes"
+	"mime"
+	"strings"
+)
+
+// AnnotationPrefix is the prefix of all headers added by Annotate.
+const AnnotationPrefix = "X-Mail-Analyzer-"
+
+// Header is a single header field to add with Annotate.
+type Header struct {
+	Name  string
+	Value string
+}
+
+// EncodedValue returns the value of h as it is written in a message, without line breaks
+// and RFC 2047-encoded when needed, for the programs that add h to a message themselves.
+func (h Header) EncodedValue() string {
+	return encodeHeaderValue(h.Value)
+}
+
+// Annotate returns raw with headers prepended to its header section. Existing headers with
+// AnnotationPrefix are removed first, so a sender cannot forge a verdict. The rest of the
+// message is left byte-for-byte intact, keeping DKIM signatures valid. Values are
+// RFC 2047-encoded when needed and folded to keep lines short.
+func Annotate(raw []byte, headers []Header) []byte {
+	newline := []byte("\n")
+	if i := bytes.IndexByte(raw, '\n'); i > 0 && raw[i-1] == '\r' {
+		newline = []byte("\r\n")
+	}
+
+	var out bytes.Buffer
+	for _, h := range headers {
+		out.WriteString(foldHeader(h.Name+": "+encodeHeaderValue(h.Value), string(newline)))
+		out.Write(newline)
+	}
+
+	// Copy the header section, skipping existing annotation fields and their continuation lines.
+	rest := raw
+	skipping := false
+	for len(rest) > 0 {
+		end := bytes.IndexByte(rest, '\n') + 1
+		if end == 0 {
+			end = len(rest)
+		}
+		line := rest[:end]
+		if len(bytes.TrimRight(line, "\r\n")) == 0 {
+			break // End of the header section
+		}
+		if line[0] == ' ' || line[0] == '\t' {
+			if !skipping {
+				out.Write(line)
+			}
+		} else {
+			skipping = hasAnnotationPrefix(line)
+			if !skipping {
+				out.Write(line)
+			}
+		}
+		rest = rest[end:]
+	}
+	out.Write(rest)
+	return out.Bytes()
+}
+
+func hasAnnotationPrefix(line []byte) bool {
+	return len(line) >= len(AnnotationPrefix) && strings.EqualFold(string(line[:len(AnnotationPrefix)]), AnnotationPrefix)
+}
+
+// encodeHeaderValue removes line breaks and applies RFC 2047 encoding to non-ASCII values.
+func encodeHeaderValue(v string) string {
+	v = strings.Join(strings.Fields(v), " ")
+	for _, r := range v {
+		if r > 0x7e {
+			return mime.QEncoding.Encode("utf-8", v)
+		}
+	}
+	return v
+}
+
+// foldHeader folds a header field at spaces so that lines stay within 78 characters where possible.
+func foldHeader(field, newline string) string {
+	const maxLine = 78
+	if len(field) <= maxLine {
+		return field
+	}
+	var b strings.Builder
+	lineLen := 0
+	for i, word := range strings.Split(field, " ") {
+		if i > 0 {
+			if lineLen+1+len(word) > maxLine {
+				b.WriteString(newline + " ")
+				lineLen = 1
+			} else {
+				b.WriteString(" ")
+				lineLen++
+			}
+		}
+		b.WriteString(word)
+		lineLen += len(word)
+	}
+	return b.String()
+}
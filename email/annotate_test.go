@@ -0,0 +1,75 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAnnotate(t *testing.T) {
+	headers := []Header{
+		{Name: "X-Mail-Analyzer-Category", Value: "Phishing"},
+		{Name: "X-Mail-Analyzer-Score", Value: "0.90"},
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+		want string
+	}{
+		{
+			name: "LF line endings",
+			raw:  "From: a@example.com\nSubject: Hi\n\nBody\n",
+			want: "X-Mail-Analyzer-Category: Phishing\nX-Mail-Analyzer-Score: 0.90\nFrom: a@example.com\nSubject: Hi\n\nBody\n",
+		},
+		{
+			name: "CRLF line endings",
+			raw:  "From: a@example.com\r\n\r\nBody\r\n",
+			want: "X-Mail-Analyzer-Category: Phishing\r\nX-Mail-Analyzer-Score: 0.90\r\nFrom: a@example.com\r\n\r\nBody\r\n",
+		},
+		{
+			name: "Forged annotations are removed",
+			raw:  "x-mail-analyzer-category: Safe\nFrom: a@example.com\nX-Mail-Analyzer-Reason: looks\n fine\nSubject: Hi\n\nX-Mail-Analyzer-Category: in body\n",
+			want: "X-Mail-Analyzer-Category: Phishing\nX-Mail-Analyzer-Score: 0.90\nFrom: a@example.com\nSubject: Hi\n\nX-Mail-Analyzer-Category: in body\n",
+		},
+		{
+			name: "Folded headers are kept",
+			raw:  "Subject: a\n long subject\n\nBody",
+			want: "X-Mail-Analyzer-Category: Phishing\nX-Mail-Analyzer-Score: 0.90\nSubject: a\n long subject\n\nBody",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Annotate([]byte(tt.raw), headers)); got != tt.want {
+				t.Errorf("Annotate() =\n%q\nwant\n%q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAnnotate_EncodesValues(t *testing.T) {
+	reason := "偽のログインページへ誘導しています。\r\nInjected: header " + strings.Repeat("long reason ", 10)
+	got := Annotate([]byte("From: a@example.com\n\nBody"), []Header{{Name: "X-Mail-Analyzer-Reason", Value: reason}})
+
+	headerSection := got[:bytes.Index(got, []byte("\n\n"))]
+	for _, line := range strings.Split(string(headerSection), "\n") {
+		if len(line) > 78 {
+			t.Errorf("line longer than 78 characters: %q", line)
+		}
+		if strings.HasPrefix(line, "Injected:") {
+			t.Errorf("header injection: %q", line)
+		}
+	}
+
+	parsed, err := Parse(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	decoded, err := parsed.Header.Text("X-Mail-Analyzer-Reason")
+	if err != nil {
+		t.Fatalf("Text() error = %v", err)
+	}
+	if !strings.HasPrefix(decoded, "偽のログインページへ誘導しています。 Injected: header long reason") {
+		t.Errorf("decoded reason = %q", decoded)
+	}
+}
@@ -0,0 +1,214 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/netip"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message"
+)
+
+// FeedbackReport is the machine-readable part of an abuse report in the Abuse Reporting
+// Format (ARF, RFC 5965): a message/feedback-report part, sent by feedback loops and
+// mailbox providers along with the reported message.
+type FeedbackReport struct {
+	// FeedbackType is abuse, fraud, virus, not-spam, other, or an extension.
+	FeedbackType     string   `json:"feedback_type"`
+	UserAgent        string   `json:"user_agent,omitempty"`
+	SourceIP         string   `json:"source_ip,omitempty"`
+	ArrivalDate      string   `json:"arrival_date,omitempty"`
+	OriginalMailFrom string   `json:"original_mail_from,omitempty"`
+	OriginalRcptTo   []string `json:"original_rcpt_to,omitempty"`
+	ReportedDomain   []string `json:"reported_domain,omitempty"`
+	ReportedURI      []string `json:"reported_uri,omitempty"`
+}
+
+// ParseFeedbackReport returns the feedback report of raw and the reported message it
+// embeds, if raw is an ARF report, or nil if it is any other message. A report that
+// embeds only the header of the message (text/rfc822-headers) yields a message without
+// a body.
+func ParseFeedbackReport(raw []byte) (*FeedbackReport, []byte, error) {
+	entity, err := message.Read(bytes.NewReader(raw))
+	if err != nil && !message.IsUnknownCharset(err) && !message.IsUnknownEncoding(err) {
+		return nil, nil, nil
+	}
+	mediaType, params, err := entity.Header.ContentType()
+	if err != nil || mediaType != "multipart/report" || !strings.EqualFold(params["report-type"], "feedback-report") {
+		return nil, nil, nil
+	}
+	mr := entity.MultipartReader()
+	if mr == nil {
+		return nil, nil, nil
+	}
+
+	var report *FeedbackReport
+	var original []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: feedback report: %w", ErrParse, err)
+		}
+		partType, _, _ := part.Header.ContentType()
+		switch partType {
+		case "message/feedback-report":
+			if report, err = readFeedbackFields(part.Body); err != nil {
+				return nil, nil, fmt.Errorf("%w: feedback report: %w", ErrParse, err)
+			}
+		case "message/rfc822", "text/rfc822-headers":
+			if original, err = io.ReadAll(part.Body); err != nil {
+				return nil, nil, fmt.Errorf("%w: feedback report: %w", ErrParse, err)
+			}
+			if partType == "text/rfc822-headers" {
+				original = append(bytes.TrimRight(original, "\r\n"), "\r\n\r\n"...)
+			}
+		}
+	}
+	if report == nil || original == nil {
+		return nil, nil, fmt.Errorf("%w: feedback report without a message/feedback-report part or a reported message", ErrParse)
+	}
+	return report, original, nil
+}
+
+// readFeedbackFields reads the fields of a message/feedback-report part.
+func readFeedbackFields(r io.Reader) (*FeedbackReport, error) {
+	// The fields have the syntax of a header, which may lack the final blank line.
+	fields, err := textproto.NewReader(bufio.NewReader(io.MultiReader(r, strings.NewReader("\r\n\r\n")))).ReadMIMEHeader()
+	if err != nil {
+		return nil, err
+	}
+	report := &FeedbackReport{
+		FeedbackType:     fields.Get("Feedback-Type"),
+		UserAgent:        fields.Get("User-Agent"),
+		SourceIP:         fields.Get("Source-IP"),
+		ArrivalDate:      fields.Get("Arrival-Date"),
+		OriginalMailFrom: strings.Trim(fields.Get("Original-Mail-From"), "<> "),
+		ReportedDomain:   fields.Values("Reported-Domain"),
+		ReportedURI:      fields.Values("Reported-URI"),
+	}
+	for _, rcpt := range fields.Values("Original-Rcpt-To") {
+		report.OriginalRcptTo = append(report.OriginalRcptTo, strings.Trim(rcpt, "<> "))
+	}
+	if report.FeedbackType == "" {
+		return nil, fmt.Errorf("missing Feedback-Type")
+	}
+	return report, nil
+}
+
+// receivedFromIP matches the address in brackets of the "from" clause of a Received field,
+// such as "from mail.example.com (mail.example.com [203.0.113.5])".
+var receivedFromIP = regexp.MustCompile(`\[(?:IPv6:)?([0-9A-Fa-f:.]+)\]`)
+
+// SourceIP returns the address of the server that sent the message, from its Received
+// fields: the first public address of a "from" clause that is not in trusted, starting
+// with the newest field, so that the hops between the internal servers of the recipient
+// and its relays, such as a hosted filter, are skipped. It returns an invalid address if
+// there is none.
+func SourceIP(received []string, trusted []netip.Prefix) netip.Addr {
+	for _, field := range received {
+		from, _, _ := strings.Cut(field, " by ")
+		for _, m := range receivedFromIP.FindAllStringSubmatch(from, -1) {
+			addr, err := netip.ParseAddr(m[1])
+			if err != nil || !addr.IsGlobalUnicast() || addr.IsPrivate() {
+				continue
+			}
+			addr = addr.Unmap()
+			if !slices.ContainsFunc(trusted, func(p netip.Prefix) bool { return p.Contains(addr) }) {
+				return addr
+			}
+		}
+	}
+	return netip.Addr{}
+}
+
+// FeedbackMessage is an ARF report to send about a message.
+type FeedbackMessage struct {
+	From    string
+	To      string
+	Subject string
+	Date    time.Time
+	// Text is the part of the report for people to read.
+	Text     string
+	Report   FeedbackReport
+	Original []byte
+	// HeadersOnly is whether Original is the header of the message alone, which is
+	// attached as text/rfc822-headers.
+	HeadersOnly bool
+}
+
+// Bytes returns the report as a multipart/report message.
+func (m *FeedbackMessage) Bytes() []byte {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	text, _ := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"text/plain; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	io.WriteString(text, crlf(m.Text))
+
+	fields, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/feedback-report"}})
+	r := &m.Report
+	writeField(fields, "Feedback-Type", r.FeedbackType)
+	writeField(fields, "User-Agent", r.UserAgent)
+	writeField(fields, "Version", "1")
+	writeField(fields, "Original-Mail-From", r.OriginalMailFrom)
+	for _, rcpt := range r.OriginalRcptTo {
+		writeField(fields, "Original-Rcpt-To", rcpt)
+	}
+	writeField(fields, "Arrival-Date", r.ArrivalDate)
+	writeField(fields, "Source-IP", r.SourceIP)
+	for _, domain := range r.ReportedDomain {
+		writeField(fields, "Reported-Domain", domain)
+	}
+	for _, uri := range r.ReportedURI {
+		writeField(fields, "Reported-URI", uri)
+	}
+
+	originalType := "message/rfc822"
+	if m.HeadersOnly {
+		originalType = "text/rfc822-headers"
+	}
+	original, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {originalType}})
+	original.Write(m.Original)
+	mw.Close()
+
+	var out bytes.Buffer
+	writeField(&out, "From", m.From)
+	writeField(&out, "To", m.To)
+	writeField(&out, "Subject", mime.QEncoding.Encode("utf-8", m.Subject))
+	if !m.Date.IsZero() {
+		writeField(&out, "Date", m.Date.Format(time.RFC1123Z))
+	}
+	writeField(&out, "MIME-Version", "1.0")
+	writeField(&out, "Content-Type", mime.FormatMediaType("multipart/report", map[string]string{
+		"report-type": "feedback-report",
+		"boundary":    mw.Boundary(),
+	}))
+	out.WriteString("\r\n")
+	out.Write(body.Bytes())
+	return out.Bytes()
+}
+
+// writeField writes a header field, unless value is empty.
+func writeField(w io.Writer, name, value string) {
+	if value != "" {
+		fmt.Fprintf(w, "%s: %s\r\n", name, strings.NewReplacer("\r", " ", "\n", " ").Replace(value))
+	}
+}
+
+// crlf returns s with CRLF line breaks.
+func crlf(s string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(s, "\r\n", "\n"), "\n", "\r\n")
+}
@@ -0,0 +1,124 @@
+package email
+
+import (
+	"errors"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFeedbackReport(t *testing.T) {
+	const original = "From: a@evil.example\r\nSubject: Win\r\n\r\nhttps://evil.example/win\r\n"
+	report := func(parts string) []byte {
+		return []byte("From: fbl@isp.example\r\nContent-Type: multipart/report; report-type=feedback-report; boundary=b\r\n\r\n" +
+			"--b\r\nContent-Type: text/plain\r\n\r\nAn abuse report.\r\n" + parts + "--b--\r\n")
+	}
+	fields := "--b\r\nContent-Type: message/feedback-report\r\n\r\nFeedback-Type: abuse\r\nUser-Agent: FBL/1\r\nVersion: 1\r\nSource-IP: 198.51.100.1\r\nOriginal-Rcpt-To: <a@isp.example>\r\nOriginal-Rcpt-To: <b@isp.example>\r\nReported-URI: https://evil.example/win\r\n"
+
+	tests := []struct {
+		name         string
+		raw          []byte
+		want         *FeedbackReport
+		wantOriginal string
+		wantErr      bool
+	}{
+		{
+			name: "Message",
+			raw:  report(fields + "--b\r\nContent-Type: message/rfc822\r\n\r\n" + original),
+			want: &FeedbackReport{FeedbackType: "abuse", UserAgent: "FBL/1", SourceIP: "198.51.100.1", OriginalRcptTo: []string{"a@isp.example", "b@isp.example"}, ReportedURI: []string{"https://evil.example/win"}},
+			// The line break before a boundary belongs to the boundary.
+			wantOriginal: strings.TrimSuffix(original, "\r\n"),
+		},
+		{
+			name:         "Header only",
+			raw:          report(fields + "--b\r\nContent-Type: text/rfc822-headers\r\n\r\nFrom: a@evil.example\r\nSubject: Win\r\n"),
+			want:         &FeedbackReport{FeedbackType: "abuse", UserAgent: "FBL/1", SourceIP: "198.51.100.1", OriginalRcptTo: []string{"a@isp.example", "b@isp.example"}, ReportedURI: []string{"https://evil.example/win"}},
+			wantOriginal: "From: a@evil.example\r\nSubject: Win\r\n\r\n",
+		},
+		{name: "Plain message", raw: []byte(original)},
+		{name: "Delivery report", raw: []byte("Content-Type: multipart/report; report-type=delivery-status; boundary=b\r\n\r\n--b\r\n\r\nBounced.\r\n--b--\r\n")},
+		{name: "No reported message", raw: report(fields), wantErr: true},
+		{name: "No feedback type", raw: report("--b\r\nContent-Type: message/feedback-report\r\n\r\nVersion: 1\r\n--b\r\nContent-Type: message/rfc822\r\n\r\n" + original), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, gotOriginal, err := ParseFeedbackReport(tt.raw)
+			if tt.wantErr {
+				if !errors.Is(err, ErrParse) {
+					t.Errorf("ParseFeedbackReport() error = %v, want ErrParse", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFeedbackReport() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) || string(gotOriginal) != tt.wantOriginal {
+				t.Errorf("ParseFeedbackReport() = %+v, %q, want %+v, %q", got, gotOriginal, tt.want, tt.wantOriginal)
+			}
+		})
+	}
+}
+
+func TestSourceIP(t *testing.T) {
+	filtered := []string{
+		"from filter.hosted.example (filter.hosted.example [192.0.2.20]) by mx.corp.example",
+		"from mail.sender.example (mail.sender.example [203.0.113.5]) by filter.hosted.example",
+	}
+	tests := []struct {
+		received []string
+		trusted  []netip.Prefix
+		want     string
+	}{
+		{[]string{
+			"from relay.corp.example (relay.corp.example [10.0.0.5]) by mx2.corp.example",
+			"from mail.sender.example (mail.sender.example [203.0.113.5]) by relay.corp.example",
+			"from client (unknown [198.51.100.9]) by mail.sender.example",
+		}, nil, "203.0.113.5"},
+		{[]string{"from mail.sender.example ([IPv6:2001:db8::25]) by mx.example"}, nil, "2001:db8::25"},
+		{[]string{"from localhost ([127.0.0.1]) by mx.example (Postfix) with ESMTP [203.0.113.7]"}, nil, "invalid IP"},
+		{nil, nil, "invalid IP"},
+		{filtered, nil, "192.0.2.20"},
+		{filtered, []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, "203.0.113.5"},
+		{filtered[:1], []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")}, "invalid IP"},
+	}
+	for _, tt := range tests {
+		if got := SourceIP(tt.received, tt.trusted).String(); got != tt.want {
+			t.Errorf("SourceIP(%q, %v) = %s, want %s", tt.received, tt.trusted, got, tt.want)
+		}
+	}
+}
+
+func TestFeedbackMessage_Bytes(t *testing.T) {
+	original := "From: a@evil.example\r\nSubject: Win\r\n\r\nhttps://evil.example/win\r\n"
+	m := &FeedbackMessage{
+		From:    "reports@example.org",
+		To:      "abuse@isp.example",
+		Subject: "Abuse report: Gewinn für Sie",
+		Date:    time.Date(2025, 10, 13, 9, 0, 0, 0, time.UTC),
+		Text:    "Phishing.\nSee the attached message.",
+		Report: FeedbackReport{
+			FeedbackType:   "fraud",
+			UserAgent:      "mail-analyzer/1.0",
+			SourceIP:       netip.MustParseAddr("203.0.113.5").String(),
+			ReportedDomain: []string{"evil.example"},
+			ReportedURI:    []string{"https://evil.example/win"},
+		},
+		Original: []byte(original),
+	}
+	raw := m.Bytes()
+	for _, want := range []string{"To: abuse@isp.example\r\n", "Subject: =?utf-8?q?", "Date: Mon, 13 Oct 2025 09:00:00 +0000\r\n", "Phishing.\r\nSee the attached message.", "Version: 1\r\n"} {
+		if !strings.Contains(string(raw), want) {
+			t.Errorf("Bytes() has no %q:\n%s", want, raw)
+		}
+	}
+
+	report, gotOriginal, err := ParseFeedbackReport(raw)
+	if err != nil {
+		t.Fatalf("ParseFeedbackReport(Bytes()) error = %v", err)
+	}
+	if !reflect.DeepEqual(*report, m.Report) || string(gotOriginal) != original {
+		t.Errorf("ParseFeedbackReport(Bytes()) = %+v, %q, want %+v, %q", report, gotOriginal, m.Report, original)
+	}
+}
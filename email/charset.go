@@ -0,0 +1,100 @@
+package email
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io"
+	"log"
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message"
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/textproto"
+	"golang.org/x/text/encoding/japanese"
+)
+
+// The charsets of a message are decoded part by part as it is parsed: go-message decodes
+// the body of a single-part message, and decodeReader each text part of a multipart one.
+// The message itself is never rewritten, so its structure is read as it was sent.
+
+func init() {
+	// Misspellings of Japanese charsets that mailers send and the IANA index does not
+	// know.
+	charset.RegisterEncoding("shift-jis", japanese.ShiftJIS)
+	charset.RegisterEncoding("euc_jp", japanese.EUCJP)
+}
+
+// readMessage reads the header and the body of a message. Header fields in raw 8-bit or
+// ISO-2022-JP text, rather than encoded words, are decoded from the charset of the
+// body, since that is how some mailers write the subject.
+func readMessage(r io.Reader) (*message.Entity, error) {
+	entity, err := message.Read(r)
+	if entity != nil {
+		entity.Header = message.Header{Header: decodeRawHeader(entity.Header.Header)}
+	}
+	return entity, err
+}
+
+// decodeRawHeader returns header with its fields that are not encoded words decoded from
+// the charset of its Content-Type. The other fields, and their order, are kept as is.
+func decodeRawHeader(header textproto.Header) textproto.Header {
+	_, params, _ := mime.ParseMediaType(header.Get("Content-Type"))
+	name := strings.ToLower(params["charset"])
+	if name == "" || name == "utf-8" || name == "us-ascii" {
+		return header
+	}
+
+	var fields [][]byte
+	decoded := false
+	for f := header.Fields(); f.Next(); {
+		raw, err := f.Raw()
+		if err != nil {
+			return header
+		}
+		if utf8.Valid(raw) && bytes.IndexByte(raw, '\x1b') < 0 {
+			fields = append(fields, raw)
+			continue
+		}
+		r, err := charset.Reader(name, bytes.NewReader(raw))
+		if err != nil {
+			return header
+		}
+		field, err := io.ReadAll(r)
+		if err != nil || bytes.IndexByte(field, ':') < 0 {
+			fields = append(fields, raw)
+			continue
+		}
+		fields = append(fields, field)
+		decoded = true
+	}
+	if !decoded {
+		return header
+	}
+	// Fields are added in front of the others.
+	var h textproto.Header
+	for i := len(fields) - 1; i >= 0; i-- {
+		h.AddRaw(fields[i])
+	}
+	return h
+}
+
+// decodeReader returns a reader of the text of a part read from r, decoded from its
+// transfer encoding and from charset to UTF-8. mime/multipart only decodes
+// quoted-printable parts itself. Text in charsets that cannot be decoded is read as is.
+func decodeReader(r io.Reader, transferEncoding, name string) io.Reader {
+	if strings.EqualFold(strings.TrimSpace(transferEncoding), "base64") {
+		r = base64.NewDecoder(base64.StdEncoding, r)
+	}
+	switch strings.ToLower(name) {
+	case "", "utf-8", "us-ascii":
+		return r
+	}
+	decoded, err := charset.Reader(name, r)
+	if err != nil {
+		log.Printf("Warning: Failed to decode charset %s: %v", name, err)
+		return r
+	}
+	return decoded
+}
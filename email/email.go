@@ -1,22 +1,20 @@
 package email
 
 import (
+	"bytes"
+	"context"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"mime"
 	"mime/multipart"
-	"regexp"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/emersion/go-message"
 	"github.com/emersion/go-message/mail"
-	"golang.org/x/text/encoding"
-	"golang.org/x/text/encoding/japanese"
-	"golang.org/x/text/transform"
-
-	"mail-analyzer/converter"
 )
 
 // ParsedEmail holds the extracted information from an email.
@@ -28,18 +26,109 @@ type ParsedEmail struct {
 	Body      string
 	URLs      []string
 	Header    mail.Header
+	// Images holds image parts (inline or attached) found in a multipart message.
+	Images []Image
+	// Attachments describes the parts of a multipart message that are attachments.
+	Attachments []Attachment
+	// Forms are the HTML forms of the text parts, and their input fields, such as those
+	// asking for a password.
+	Forms []Form
+	// AttachmentTexts are the texts of the attachments that could be converted to text,
+	// which Parse does not do; see package attachtext.
+	AttachmentTexts []AttachmentText
+	// ImageTexts are the texts recognized in Images by OCR, which Parse does not do
+	// either.
+	ImageTexts []AttachmentText
+	// Warnings tell what was left out of the message because of the limits of the
+	// parsing, in which case the message was only partly analyzed.
+	Warnings []string
+}
+
+// Attachment is a part of an email with an attachment disposition, or a file name and
+// no inline disposition.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	// Size is the size of the encoded part.
+	Size int
+}
+
+// AttachmentText is the text extracted from an attachment, or recognized in an image.
+type AttachmentText struct {
+	Filename    string
+	ContentType string
+	Text        string
+	// Truncated is whether the text was cut to the size allowed for the type of the
+	// attachment.
+	Truncated bool
 }
 
+// Image is an image part extracted from an email.
+type Image struct {
+	Filename    string
+	ContentType string
+	Data        []byte
+}
+
+// maxImageSize is the largest image part that is kept in ParsedEmail.Images.
+const maxImageSize = 5 * 1024 * 1024
+
+// ErrParse is wrapped by the errors of Parse: the message is malformed or uses an
+// encoding that cannot be decoded, so analyzing it again will fail the same way.
+var ErrParse = errors.New("error parsing email")
+
 // Parse reads an email from an io.Reader and extracts key information.
 func Parse(r io.Reader) (*ParsedEmail, error) {
-	// Convert input reader to UTF-8 using the converter module
-	utf8Reader, err := converter.ConvertToUTF8(r)
+	return ParseContext(context.Background(), r)
+}
+
+// ParseContext is like Parse, but stops reading and decoding the message once ctx is
+// done, in which case it returns the error of ctx rather than one of ErrParse, since the
+// message may well be valid.
+func ParseContext(ctx context.Context, r io.Reader) (*ParsedEmail, error) {
+	return ParseWithLimits(ctx, r, Limits{})
+}
+
+// ParseWithLimits is like ParseContext, with limits other than the default ones.
+func ParseWithLimits(ctx context.Context, r io.Reader, limits Limits) (*ParsedEmail, error) {
+	limits = limits.withDefaults()
+	parseCtx, cancel := context.WithTimeoutCause(ctx, limits.ParseTime, errParseTime)
+	defer cancel()
+	parsed, err := parse(parseCtx, &contextReader{ctx: parseCtx, r: r}, limits)
 	if err != nil {
-		return nil, fmt.Errorf("failed to convert email to UTF-8: %w", err)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if context.Cause(parseCtx) == errParseTime {
+			// The headers could not even be read in time.
+			return nil, fmt.Errorf("%w: gave up after %s: %w", ErrParse, limits.ParseTime, err)
+		}
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
 	}
+	return parsed, nil
+}
 
-	entity, err := message.Read(utf8Reader) // Use the UTF-8 reader here
-	if err != nil {
+// contextReader is a reader that fails once its context is done, so that a large
+// message is not read to the end after the analysis was cancelled.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func parse(ctx context.Context, r io.Reader, limits Limits) (*ParsedEmail, error) {
+	entity, err := readMessage(r)
+	if message.IsUnknownCharset(err) || message.IsUnknownEncoding(err) {
+		// The body is read as is, which is better than no analysis of a message that
+		// may well use an unusual encoding on purpose.
+		log.Printf("Warning: %v", err)
+	} else if err != nil {
 		return nil, fmt.Errorf("failed to read message entity: %w", err)
 	}
 
@@ -51,172 +140,311 @@ func Parse(r io.Reader) (*ParsedEmail, error) {
 	subject, _ := header.Subject()
 	messageID, _ := header.MessageID()
 
-	body, urls, err := extractBodyAndURLs(entity)
+	parsed, err := extractBodyAndURLs(ctx, entity, limits)
 	if err != nil {
 		return nil, err
 	}
+	parsed.MessageID = strings.Trim(messageID, "<> ")
+	parsed.From = from
+	parsed.To = to
+	parsed.Subject = subject
+	parsed.Header = header
+	return parsed, nil
+}
 
-	return &ParsedEmail{
-		MessageID: strings.Trim(messageID, "<> "),
-		From:      from,
-		To:        to,
-		Subject:   subject,
-		Body:      body,
-		URLs:      urls,
-		Header:    header,
-	}, nil
+// Limits on what is kept of a message in memory. Parts are read as streams, so the
+// memory used to parse a message does not grow with the size of its attachments.
+const (
+	// maxBodySize is the size of the text of the body that is kept in ParsedEmail.Body.
+	// The rest of the text is still searched for URLs.
+	maxBodySize = 1024 * 1024
+	// maxEncodedImageSize is the size of the encoded image parts that are read to be
+	// decoded, large enough for base64 with line breaks of an image of maxImageSize.
+	maxEncodedImageSize = maxImageSize*3/2 + 1024
+	// textChunkSize is the size of the chunks of text that are searched for URLs.
+	textChunkSize = 64 * 1024
+	// maxParts is the number of parts of a multipart message that are read, and
+	// maxPartDepth how deeply multipart parts may be nested.
+	maxParts     = 1000
+	maxPartDepth = 10
+)
+
+// extraction accumulates what is extracted from the parts of a message.
+type extraction struct {
+	body        strings.Builder
+	urls        []string
+	images      []Image
+	attachments []Attachment
+	// parts is the number of parts read so far.
+	parts int
+	forms []Form
+	// inForm is whether the last form of forms is not closed yet.
+	inForm bool
+	// stripped is the buffer of the text of an HTML chunk without its tags.
+	stripped []byte
+	// seen are the distinct URLs of urls.
+	seen map[string]bool
+
+	limits Limits
+	// budget is the number of bytes that may still be decoded.
+	budget   int64
+	warnings []string
 }
 
-func extractBodyAndURLs(entity *message.Entity) (string, []string, error) {
+// extractBodyAndURLs returns the body, URLs, images, attachments and warnings of a
+// message. If ctx runs out of the parse time of limits, what was extracted so far is
+// returned, with a warning.
+func extractBodyAndURLs(ctx context.Context, entity *message.Entity, limits Limits) (*ParsedEmail, error) {
 	mediaType, params, err := entity.Header.ContentType()
 	if err != nil {
 		mediaType = "text/plain"
 		params = make(map[string]string)
 	}
 
-	var bodyBuilder strings.Builder
-	var urls []string
-
-	hrefRegex := regexp.MustCompile(`href\s*=\s*["'](https?://[^"]+)["']`)
-	urlRegex := regexp.MustCompile(`https?://[^\s"<>]*[^\s"<>,.?!;)]`)
-
+	x := &extraction{seen: map[string]bool{}, limits: limits, budget: limits.DecodedBytes}
+	var extractErr error
 	if strings.HasPrefix(mediaType, "multipart/") {
 		boundary := params["boundary"]
 		if boundary == "" {
-			content, _ := io.ReadAll(entity.Body)
-			bodyBuilder.WriteString(string(content))
+			content, _ := io.ReadAll(io.LimitReader(entity.Body, maxBodySize))
+			x.writeBody(content)
 		} else {
-			mr := multipart.NewReader(entity.Body, boundary)
-			for {
-				part, err := mr.NextPart()
-				if errors.Is(err, io.EOF) {
-					break
-				}
-				if err != nil {
-					log.Printf("Warning: could not read multipart part: %v", err)
-					continue
-				}
-				defer part.Close()
-
-				partMediaType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
-				if err != nil {
-					log.Printf("Warning: could not parse content type of multipart part: %v", err)
-					continue
-				}
-
-				partContent, err := io.ReadAll(part)
-				if err != nil {
-					log.Printf("Warning: could not read content of multipart part: %v", err)
-					continue
-				}
-
-				// Decode charset if specified
-			charset := partParams["charset"]
-			if charset != "" {
-					log.Printf("DEBUG: Decoding part with charset: %s", charset)
-					decodedContent, decodeErr := decodeCharset(partContent, charset)
-					if decodeErr == nil {
-						partContent = decodedContent
-					} else {
-						log.Printf("Warning: Failed to decode charset %s: %v", charset, decodeErr)
-					}
-				}
-
-				partBodyText := string(partContent)
-
-				if partMediaType == "text/html" || partMediaType == "text/plain" {
-					hrefMatches := hrefRegex.FindAllStringSubmatch(partBodyText, -1)
-					for _, match := range hrefMatches {
-						if len(match) > 1 {
-							urls = append(urls, match[1])
-						}
-					}
-
-					if partMediaType == "text/html" {
-						re := regexp.MustCompile(`<.*?>`)
-						partBodyText = re.ReplaceAllString(partBodyText, " ")
-					}
-
-					foundUrls := urlRegex.FindAllString(partBodyText, -1)
-					urls = append(urls, foundUrls...)
-
-					bodyBuilder.WriteString(partBodyText)
-					bodyBuilder.WriteString("\n")
-				}
-			}
+			extractErr = x.extractMultipart(ctx, entity.Body, boundary, 1)
 		}
 	} else if mediaType == "text/plain" || mediaType == "text/html" {
-		content, err := io.ReadAll(entity.Body)
-			if err != nil {
-				return "", nil, err
-			}
+		// message.Read decoded the charset of the body already, if it knows it.
+		extractErr = x.scanText(x.decoded(entity.Body), mediaType == "text/html")
+	}
+	if context.Cause(ctx) == errParseTime {
+		x.warn("stopped parsing after %s", limits.ParseTime)
+	} else if extractErr != nil {
+		return nil, extractErr
+	}
 
-			// Decode charset if specified
-			charset := params["charset"]
-			if charset != "" {
-				log.Printf("DEBUG: Decoding main body with charset: %s", charset)
-				decodedContent, decodeErr := decodeCharset(content, charset)
-				if decodeErr == nil {
-					content = decodedContent
-				} else {
-					log.Printf("Warning: Failed to decode charset %s: %v", charset, decodeErr)
-				}
-			}
+	return &ParsedEmail{
+		Body:        strings.TrimSpace(x.body.String()),
+		URLs:        x.urls,
+		Images:      x.images,
+		Attachments: x.attachments,
+		Forms:       x.forms,
+		Warnings:    x.warnings,
+	}, nil
+}
+
+// extractMultipart adds the parts of a multipart body, nested depth levels deep in the
+// message, to x. Parts nested deeper than maxPartDepth, and the parts after the first
+// maxParts of the message, are skipped, since hostile messages can have any number.
+func (x *extraction) extractMultipart(ctx context.Context, body io.Reader, boundary string, depth int) error {
+	mr := multipart.NewReader(body, boundary)
+	for {
+		// Each part is decoded and searched for URLs, which can take a while for
+		// messages with many large parts.
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if x.parts >= maxParts {
+			x.warn("skipped the parts after the first %d", maxParts)
+			return nil
+		}
+		// Parts that cannot be read count too, since the reader may fail on every
+		// boundary of a malformed message.
+		x.parts++
+		part, err := mr.NextPart()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			log.Printf("Warning: could not read multipart part: %v", err)
+			continue
+		}
+		if err := x.extractPart(ctx, part, depth); err != nil {
+			return err
+		}
+	}
+}
 
-			bodyText := string(content)
+// extractPart adds a part of a multipart message to x. The part is read to its end
+// once, whatever its size, and only images up to maxEncodedImageSize are held in memory.
+func (x *extraction) extractPart(ctx context.Context, part *multipart.Part, depth int) error {
+	partMediaType, partParams, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if err != nil {
+		log.Printf("Warning: could not parse content type of multipart part: %v", err)
+		return nil
+	}
+	if strings.HasPrefix(partMediaType, "multipart/") {
+		if depth >= maxPartDepth || partParams["boundary"] == "" {
+			x.warn("skipped %s part nested %d levels deep", partMediaType, depth+1)
+			return nil
+		}
+		return x.extractMultipart(ctx, part, partParams["boundary"], depth+1)
+	}
+	x.extractLeaf(part, partMediaType, partParams)
+	return nil
+}
 
-			hrefMatches := hrefRegex.FindAllStringSubmatch(bodyText, -1)
-			for _, match := range hrefMatches {
-				if len(match) > 1 {
-					urls = append(urls, match[1])
-				}
-			}
+// extractLeaf adds a part of a multipart message that is not itself multipart to x.
+func (x *extraction) extractLeaf(part *multipart.Part, partMediaType string, partParams map[string]string) {
+	// The size of an attachment is counted as the part is read, and reported even if
+	// it cannot be read to the end.
+	counter := &countingReader{r: part}
+	defer func() {
+		if _, err := io.Copy(io.Discard, counter); err != nil {
+			log.Printf("Warning: could not read content of multipart part: %v", err)
+		}
+		// Inline parts with a file name, such as logos, are not attachments.
+		filename := partFilename(part.Header)
+		if disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition")); disposition == "attachment" || disposition != "inline" && filename != "" {
+			x.attachments = append(x.attachments, Attachment{Filename: filename, ContentType: partMediaType, Size: int(counter.n)})
+		}
+	}()
+
+	switch {
+	case strings.HasPrefix(partMediaType, "image/"):
+		content, err := io.ReadAll(io.LimitReader(x.decoded(counter), maxEncodedImageSize+1))
+		if err != nil {
+			log.Printf("Warning: could not read content of multipart part: %v", err)
+			return
+		}
+		if x.budget <= 0 {
+			// The image was cut at the end of the budget.
+			x.warn("stopped decoding the parts after the first %d bytes", x.limits.DecodedBytes)
+			return
+		}
+		if len(content) > maxEncodedImageSize {
+			x.warn("skipped image part larger than %d bytes", maxEncodedImageSize)
+			return
+		}
+		if image, ok := extractImage(part, partMediaType, content); ok {
+			x.images = append(x.images, image)
+		}
+	case partMediaType == "text/html" || partMediaType == "text/plain":
+		if cs := partParams["charset"]; cs != "" {
+			log.Printf("DEBUG: Decoding part with charset: %s", cs)
+		}
+		text := decodeReader(x.decoded(counter), part.Header.Get("Content-Transfer-Encoding"), partParams["charset"])
+		if err := x.scanText(text, partMediaType == "text/html"); err != nil {
+			log.Printf("Warning: could not read content of multipart part: %v", err)
+		}
+		x.writeBody([]byte{'\n'})
+	}
+}
 
-			if mediaType == "text/html" {
-				re := regexp.MustCompile(`<.*?>`)
-				bodyText = re.ReplaceAllString(bodyText, " ")
+// scanText adds the text read from r to the body, without its tags if it is HTML, and
+// the URLs in it to the URLs. The text is read in chunks of whole lines, or of words for
+// lines longer than textChunkSize, so that no more than a chunk is held in memory.
+func (x *extraction) scanText(r io.Reader, html bool) error {
+	buf := make([]byte, textChunkSize)
+	n := 0
+	for {
+		m, err := io.ReadFull(r, buf[n:])
+		n += m
+		end := n
+		if err == nil {
+			// The buffer is full: the chunk ends after its last line, or its last word
+			// or tag, and the rest of it is kept for the next chunk.
+			if i := bytes.LastIndexByte(buf[:n], '\n'); i >= 0 {
+				end = i + 1
+			} else if i := bytes.LastIndexAny(buf[:n], " \t\r>"); i >= 0 {
+				end = i + 1
 			}
+		}
+		x.scanChunk(buf[:end], html)
+		n = copy(buf, buf[end:n])
+
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
 
-			foundUrls := urlRegex.FindAllString(bodyText, -1)
-			urls = append(urls, foundUrls...)
+// scanChunk adds a chunk of text to x. The URLs of its text and of the href attributes
+// of its tags are added in the order they appear, so that the URLs of a message are in
+// the order of their first appearance.
+func (x *extraction) scanChunk(text []byte, html bool) {
+	n := len(x.urls)
+	if !html {
+		x.urls = appendURLs(x.urls, text)
+		x.keepURLs(n)
+		x.writeBody(text)
+		return
+	}
+	x.stripped = x.stripped[:0]
+	splitTags(text, func(segment []byte, tag bool) {
+		x.urls = appendHrefs(x.urls, segment)
+		if tag {
+			x.stripped = append(x.stripped, ' ')
+			return
+		}
+		x.urls = appendURLs(x.urls, segment)
+		x.stripped = append(x.stripped, segment...)
+	})
+	x.keepURLs(n)
+	x.writeBody(x.stripped)
+	if hasFields(text) {
+		x.scanForms(text)
+	}
+}
 
-			bodyBuilder.WriteString(bodyText)
+// keepURLs removes the URLs of x.urls after the first n that were found before, and
+// those beyond the limit of URLs.
+func (x *extraction) keepURLs(n int) {
+	kept := x.urls[:n]
+	for _, u := range x.urls[n:] {
+		u = strings.TrimRight(u, urlTrailer)
+		if x.seen[u] {
+			continue
+		}
+		if len(kept) == x.limits.URLs {
+			x.warn("kept only the first %d distinct URLs", x.limits.URLs)
+			break
+		}
+		x.seen[u] = true
+		kept = append(kept, u)
 	}
+	clear(x.urls[len(kept):])
+	x.urls = kept
+}
 
-	uniqueUrls := make(map[string]bool)
-	var resultUrls []string
-	for _, u := range urls {
-		u = strings.TrimRight(u, ".?!,;)")
-		if !uniqueUrls[u] {
-			uniqueUrls[u] = true
-			resultUrls = append(resultUrls, u)
+// writeBody adds text to the body, up to maxBodySize, without cutting a character.
+func (x *extraction) writeBody(text []byte) {
+	if room := maxBodySize - x.body.Len(); len(text) > room {
+		for room > 0 && !utf8.RuneStart(text[room]) {
+			room--
 		}
+		text = text[:max(room, 0)]
 	}
+	x.body.Write(text)
+}
 
-	return strings.TrimSpace(bodyBuilder.String()), resultUrls, nil
+// countingReader counts the bytes read from r.
+type countingReader struct {
+	r io.Reader
+	n int64
 }
 
-// decodeCharset decodes content from a given charset to UTF-8.
-func decodeCharset(content []byte, charset string) ([]byte, error) {
-	charset = strings.ToLower(charset)
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
 
-	var decoder *encoding.Decoder
-	switch charset {
-	case "iso-2022-jp":
-		decoder = japanese.ISO2022JP.NewDecoder()
-	case "shift_jis", "shift-jis":
-		decoder = japanese.ShiftJIS.NewDecoder()
-	case "euc-jp", "euc_jp":
-		decoder = japanese.EUCJP.NewDecoder()
-	case "utf-8":
-		return content, nil // Already UTF-8
-	default:
-		return nil, fmt.Errorf("unsupported charset: %s", charset)
+// extractImage decodes an image part. Parts that cannot be decoded or exceed
+// maxImageSize are skipped.
+func extractImage(part *multipart.Part, mediaType string, content []byte) (Image, bool) {
+	if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+		decoded, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.Join(bytes.Fields(content), nil))))
+		if err != nil {
+			log.Printf("Warning: could not decode base64 image part: %v", err)
+			return Image{}, false
+		}
+		content = decoded
 	}
-
-	decoded, _, err := transform.Bytes(decoder, content)
-	if err != nil {
-		return nil, fmt.Errorf("failed to decode content from %s: %w", charset, err)
+	if len(content) == 0 || len(content) > maxImageSize {
+		log.Printf("Warning: skipping image part of %d bytes", len(content))
+		return Image{}, false
 	}
-	return decoded, nil
-}
\ No newline at end of file
+	return Image{Filename: partFilename(part.Header), ContentType: mediaType, Data: content}, true
+}
@@ -1,10 +1,15 @@
 package email
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"reflect"
 	"sort"
 	"strings"
 	"testing"
+
+	"github.com/emersion/go-message"
 )
 
 func TestParse(t *testing.T) {
@@ -79,6 +84,11 @@ Content-Type: text/html; charset="utf-8"
 			wantURLs:      []string{"http://plain.com", "http://html.com"},
 			wantErr:       false,
 		},
+		{
+			name:     "Malformed Header",
+			rawEmail: "Subject: Test Subject\nthis line is not a header\n\nBody",
+			wantErr:  true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -91,6 +101,9 @@ Content-Type: text/html; charset="utf-8"
 				return
 			}
 			if err != nil {
+				if !errors.Is(err, ErrParse) {
+					t.Errorf("Parse() error = %v, want ErrParse", err)
+				}
 				return
 			}
 
@@ -163,4 +176,381 @@ Check this out: http://example.com/page, or this one: http://google.com).`
 	if !reflect.DeepEqual(parsed.URLs, wantURLs) {
 		t.Errorf("Expected URLs to be trimmed. got %v, want %v", parsed.URLs, wantURLs)
 	}
-}
\ No newline at end of file
+}
+func TestParse_URLOrder(t *testing.T) {
+	raw := "Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nSee https://c.example.com/ and https://a.example.com/\r\n" +
+		"--b\r\nContent-Type: text/html\r\n\r\n<p>See https://b.example.com/ <a href=\"https://d.example.com/\">and</a> <a href=\"https://a.example.com/\">this</a></p>\r\n" +
+		"--b--\r\n"
+	parsed, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	// URLs are in the order of their first appearance, whether in text or in attributes.
+	want := []string{"https://c.example.com/", "https://a.example.com/", "https://b.example.com/", "https://d.example.com/"}
+	if !reflect.DeepEqual(parsed.URLs, want) {
+		t.Errorf("URLs = %v, want %v", parsed.URLs, want)
+	}
+}
+
+func TestParse_Images(t *testing.T) {
+	rawEmail := `From: images@example.com
+To: recipient@example.com
+Subject: Image Test
+Message-ID: <images@example.com>
+Content-Type: multipart/related; boundary=boundary
+
+--boundary
+Content-Type: text/html; charset="utf-8"
+
+<p>Scan the code: <img src="cid:qr"></p>
+--boundary
+Content-Type: image/png; name="qr.png"
+Content-Disposition: inline; filename="qr.png"
+Content-Transfer-Encoding: base64
+
+iVBORw0K
+GgoAAAAN
+--boundary--
+`
+	rawEmailWithCRLF := strings.ReplaceAll(rawEmail, "\n", "\r\n")
+	parsed, err := Parse(strings.NewReader(rawEmailWithCRLF))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	if len(parsed.Images) != 1 {
+		t.Fatalf("expected 1 image, got %d", len(parsed.Images))
+	}
+	img := parsed.Images[0]
+	if img.Filename != "qr.png" || img.ContentType != "image/png" {
+		t.Errorf("unexpected image metadata: %q %q", img.Filename, img.ContentType)
+	}
+	if want := "\x89PNG\r\n\x1a\n\x00\x00\x00\x0d"; string(img.Data) != want {
+		t.Errorf("image data = %q, want %q", img.Data, want)
+	}
+	if strings.Contains(parsed.Body, "iVBOR") {
+		t.Errorf("image data leaked into body: %q", parsed.Body)
+	}
+}
+
+func TestParse_Attachments(t *testing.T) {
+	rawEmail := `From: attachments@example.com
+To: recipient@example.com
+Subject: Attachment Test
+Message-ID: <attachments@example.com>
+Content-Type: multipart/mixed; boundary=boundary
+
+--boundary
+Content-Type: text/plain; charset="utf-8"
+
+See the invoice.
+--boundary
+Content-Type: image/png
+Content-Disposition: inline; filename="logo.png"
+
+PNG
+--boundary
+Content-Type: application/pdf; name="invoice.pdf"
+Content-Disposition: attachment; filename="invoice.pdf"
+
+%PDF-1.4
+--boundary
+Content-Type: application/octet-stream; name="payload.bin"
+
+data
+--boundary--
+`
+	rawEmailWithCRLF := strings.ReplaceAll(rawEmail, "\n", "\r\n")
+	parsed, err := Parse(strings.NewReader(rawEmailWithCRLF))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+
+	want := []Attachment{
+		{Filename: "invoice.pdf", ContentType: "application/pdf", Size: 8},
+		{Filename: "payload.bin", ContentType: "application/octet-stream", Size: 4},
+	}
+	if !reflect.DeepEqual(parsed.Attachments, want) {
+		t.Errorf("Attachments = %+v, want %+v", parsed.Attachments, want)
+	}
+}
+
+func TestParse_EncodedHeaders(t *testing.T) {
+	rawEmail := `From: =?ISO-2022-JP?B?GyRCQW1MM0l0GyhC?= <soumu@example.co.jp>
+To: user@example.co.jp
+Subject: =?ISO-2022-JP?B?GyRCMnE1RCROJCpDTiRpJDsbKEI=?=
+Content-Type: multipart/mixed; boundary=boundary
+
+--boundary
+Content-Type: text/plain; charset=windows-1252
+Content-Transfer-Encoding: quoted-printable
+
+Caf=E9 https://example.co.jp/
+--boundary
+Content-Type: application/pdf; name="=?ISO-2022-JP?B?GyRCQEE1YT1xGyhCLnBkZg==?="
+Content-Disposition: attachment; filename="=?ISO-2022-JP?B?GyRCQEE1YT1xGyhCLnBkZg==?="
+
+%PDF-1.4
+--boundary
+Content-Type: application/pdf
+Content-Disposition: attachment;
+ filename*0*=iso-2022-jp''%1B%24B%40A5a;
+ filename*1*=%3Dq%1B%28B2.pdf
+
+%PDF-1.4
+--boundary--
+`
+	parsed, err := Parse(strings.NewReader(strings.ReplaceAll(rawEmail, "\n", "\r\n")))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if parsed.Subject != "会議のお知らせ" {
+		t.Errorf("Subject = %q, want %q", parsed.Subject, "会議のお知らせ")
+	}
+	if len(parsed.From) != 1 || parsed.From[0].Name != "総務部" || FormatAddress(parsed.From[0]) != `"総務部" <soumu@example.co.jp>` {
+		t.Errorf("From = %+v, want 総務部", parsed.From)
+	}
+	if parsed.Body != "Café https://example.co.jp/" {
+		t.Errorf("Body = %q", parsed.Body)
+	}
+	var filenames []string
+	for _, a := range parsed.Attachments {
+		filenames = append(filenames, a.Filename)
+	}
+	if want := []string{"請求書.pdf", "請求書2.pdf"}; !reflect.DeepEqual(filenames, want) {
+		t.Errorf("attachment file names = %q, want %q", filenames, want)
+	}
+}
+
+func TestParse_Charsets(t *testing.T) {
+	// Each part is decoded from its own transfer encoding and charset.
+	raw := "Content-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=iso-2022-jp\r\nContent-Transfer-Encoding: base64\r\n\r\nGyRCJDMkcyRLJEEkTxsoQiBodHRwOi8veC5leGFtcGxl\r\n" +
+		"--b\r\nContent-Type: text/html; charset=shift-jis\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\n<p>=82=A0</p>\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n\xe3\x81\x84\r\n--b--\r\n"
+	parsed, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if want := "こんにちは http://x.example\n あ \nい"; strings.ReplaceAll(parsed.Body, "\r", "") != want {
+		t.Errorf("Body = %q, want %q", parsed.Body, want)
+	}
+	if want := []string{"http://x.example"}; !reflect.DeepEqual(parsed.URLs, want) {
+		t.Errorf("URLs = %v, want %v", parsed.URLs, want)
+	}
+
+	// Raw 8-bit header fields are decoded from the charset of the body, and the fields
+	// are kept once each and in order.
+	raw = "Subject: \x82\xa0\r\nX-First: 1\r\nContent-Type: text/plain; charset=shift_jis\r\nX-Last: 2\r\n\r\n\x82\xa2\r\n"
+	parsed, err = Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if parsed.Subject != "あ" || parsed.Body != "い" {
+		t.Errorf("Subject, Body = %q, %q, want あ, い", parsed.Subject, parsed.Body)
+	}
+	var keys []string
+	for f := parsed.Header.Fields(); f.Next(); {
+		keys = append(keys, f.Key())
+	}
+	if want := []string{"Subject", "X-First", "Content-Type", "X-Last"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("header fields = %q, want %q", keys, want)
+	}
+}
+
+func TestParseContext_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	raw := "From: a@example.com\r\nSubject: Hi\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nHello\r\n--b--\r\n"
+	_, err := ParseContext(ctx, strings.NewReader(raw))
+	if !errors.Is(err, context.Canceled) || errors.Is(err, ErrParse) {
+		t.Errorf("ParseContext() error = %v, want context.Canceled and not ErrParse", err)
+	}
+
+	// The parts are not decoded once the context is done.
+	ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	entity, err := message.Read(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	if _, err := extractBodyAndURLs(ctx, entity, Limits{}.withDefaults()); !errors.Is(err, context.Canceled) {
+		t.Errorf("extractBodyAndURLs() error = %v, want context.Canceled", err)
+	}
+}
+
+func TestParse_LargeParts(t *testing.T) {
+	// The text is one long line, so that it is split into chunks within the line.
+	text := strings.Repeat("filler ", 2*maxBodySize/7) + "https://late.example.com/login"
+	attachment := strings.Repeat("A", 3*maxBodySize)
+	image := strings.Repeat("QUFB\r\n", maxEncodedImageSize/6+1)
+	raw := "From: large@example.com\r\nSubject: Large\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\n" + text + "\r\n" +
+		"--b\r\nContent-Type: application/octet-stream\r\nContent-Disposition: attachment; filename=\"blob.bin\"\r\n\r\n" + attachment + "\r\n" +
+		"--b\r\nContent-Type: image/png\r\nContent-Transfer-Encoding: base64\r\n\r\n" + image + "\r\n" +
+		"--b--\r\n"
+
+	parsed, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(parsed.Body) > maxBodySize || !strings.HasPrefix(parsed.Body, "filler filler") {
+		t.Errorf("Body has %d bytes, want at most %d", len(parsed.Body), maxBodySize)
+	}
+	if want := []string{"https://late.example.com/login"}; !reflect.DeepEqual(parsed.URLs, want) {
+		t.Errorf("URLs = %v, want %v", parsed.URLs, want)
+	}
+	if want := []Attachment{{Filename: "blob.bin", ContentType: "application/octet-stream", Size: len(attachment)}}; !reflect.DeepEqual(parsed.Attachments, want) {
+		t.Errorf("Attachments = %+v, want %+v", parsed.Attachments, want)
+	}
+	if len(parsed.Images) != 0 {
+		t.Errorf("an image larger than the limit was kept: %d images", len(parsed.Images))
+	}
+}
+
+func TestParse_Hostile(t *testing.T) {
+	nested := func(depth int) string {
+		var b strings.Builder
+		b.WriteString("Content-Type: multipart/mixed; boundary=b0\r\n\r\n")
+		for i := 1; i < depth; i++ {
+			fmt.Fprintf(&b, "--b%d\r\nContent-Type: multipart/mixed; boundary=b%d\r\n\r\n", i-1, i)
+		}
+		fmt.Fprintf(&b, "--b%d\r\nContent-Type: text/plain\r\n\r\nhttps://deep.example.com/\r\n", depth-1)
+		return b.String()
+	}
+	tests := []struct {
+		name     string
+		raw      string
+		wantURLs []string
+		wantBody string
+	}{
+		{
+			name:     "Nested multipart",
+			raw:      nested(3),
+			wantURLs: []string{"https://deep.example.com/"},
+			wantBody: "https://deep.example.com/",
+		},
+		{name: "Deep nesting", raw: nested(5000)},
+		{
+			name:     "Many parts",
+			raw:      "Content-Type: multipart/mixed; boundary=b\r\n\r\n" + strings.Repeat("--b\r\nContent-Type: text/plain\r\n\r\nx\r\n", 100*maxParts) + "--b\r\nContent-Type: text/plain\r\n\r\nhttps://last.example.com/\r\n--b--\r\n",
+			wantBody: strings.TrimSpace(strings.Repeat("x\n", maxParts)),
+		},
+		{
+			name:     "Unknown charset",
+			raw:      "Content-Type: text/plain; charset=x-unknown\r\n\r\nVisit https://a.example.com/\r\n",
+			wantURLs: []string{"https://a.example.com/"},
+			wantBody: "Visit https://a.example.com/",
+		},
+		{
+			name:     "Unknown transfer encoding",
+			raw:      "Content-Type: text/plain\r\nContent-Transfer-Encoding: x-unknown\r\n\r\nVisit https://a.example.com/\r\n",
+			wantURLs: []string{"https://a.example.com/"},
+			wantBody: "Visit https://a.example.com/",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := Parse(strings.NewReader(tt.raw))
+			if err != nil {
+				t.Fatalf("Parse() failed: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.URLs, tt.wantURLs) {
+				t.Errorf("URLs = %v, want %v", parsed.URLs, tt.wantURLs)
+			}
+			if body := strings.ReplaceAll(parsed.Body, "\r", ""); body != tt.wantBody {
+				t.Errorf("Body = %.80q, want %.80q", body, tt.wantBody)
+			}
+		})
+	}
+
+	// Headers are limited in size.
+	if _, err := Parse(strings.NewReader("Subject: " + strings.Repeat("a", 2<<20) + "\r\n\r\nBody\r\n")); !errors.Is(err, ErrParse) {
+		t.Errorf("Parse() of a huge header: error = %v, want ErrParse", err)
+	}
+}
+
+func TestParse_Limits(t *testing.T) {
+	raw := "Content-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nhttps://a.example.com/ https://b.example.com/ https://a.example.com/ https://c.example.com/\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nhttps://d.example.com/\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=a.pdf\r\n\r\n%PDF\r\n" +
+		"--b--\r\n"
+	tests := []struct {
+		name         string
+		limits       Limits
+		wantURLs     []string
+		wantWarnings []string
+	}{
+		{
+			name:     "Defaults",
+			wantURLs: []string{"https://a.example.com/", "https://b.example.com/", "https://c.example.com/", "https://d.example.com/"},
+		},
+		{
+			name:         "URLs",
+			limits:       Limits{URLs: 2},
+			wantURLs:     []string{"https://a.example.com/", "https://b.example.com/"},
+			wantWarnings: []string{"kept only the first 2 distinct URLs"},
+		},
+		{
+			name:         "Decoded bytes",
+			limits:       Limits{DecodedBytes: 50},
+			wantURLs:     []string{"https://a.example.com/", "https://b.example.com/"},
+			wantWarnings: []string{"stopped decoding the parts after the first 50 bytes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			parsed, err := ParseWithLimits(context.Background(), strings.NewReader(raw), tt.limits)
+			if err != nil {
+				t.Fatalf("ParseWithLimits() failed: %v", err)
+			}
+			if !reflect.DeepEqual(parsed.URLs, tt.wantURLs) {
+				t.Errorf("URLs = %v, want %v", parsed.URLs, tt.wantURLs)
+			}
+			if !reflect.DeepEqual(parsed.Warnings, tt.wantWarnings) {
+				t.Errorf("Warnings = %q, want %q", parsed.Warnings, tt.wantWarnings)
+			}
+			// The attachment is listed whatever the limits.
+			if len(parsed.Attachments) != 1 {
+				t.Errorf("Attachments = %+v, want a.pdf", parsed.Attachments)
+			}
+		})
+	}
+
+	// Once the parse time runs out, what was extracted so far is kept.
+	entity, err := message.Read(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancelCause(context.Background())
+	cancel(errParseTime)
+	parsed, err := extractBodyAndURLs(ctx, entity, Limits{}.withDefaults())
+	if err != nil {
+		t.Fatalf("extractBodyAndURLs() after the parse time: error = %v", err)
+	}
+	if want := []string{"stopped parsing after " + DefaultMaxParseTime.String()}; !reflect.DeepEqual(parsed.Warnings, want) {
+		t.Errorf("Warnings = %q, want %q", parsed.Warnings, want)
+	}
+}
+
+func FuzzParse(f *testing.F) {
+	f.Add("From: a@example.com\r\nSubject: Hi\r\n\r\nhttps://example.com/\r\n")
+	f.Add("Content-Type: multipart/mixed; boundary=b\r\n\r\n--b\r\nContent-Type: text/html; charset=iso-2022-jp\r\n\r\n<a href=\"http://x\">\x1b$B$3\x1b(B</a>\r\n--b\r\nContent-Type: image/png\r\nContent-Transfer-Encoding: base64\r\n\r\niVBORw0K\r\n--b--\r\n")
+	f.Add("Content-Type: multipart/mixed; boundary=a\r\n\r\n--a\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n--b\r\nContent-Type: text/plain\r\n\r\nHi\r\n--b--\r\n--a--\r\n")
+	f.Add("Content-Type: text/plain; charset=x-unknown\r\nSubject: =?x-unknown?B?AAAA?=\r\n\r\nBody\r\n")
+	f.Fuzz(func(t *testing.T, raw string) {
+		parsed, err := Parse(strings.NewReader(raw))
+		if err != nil {
+			if !errors.Is(err, ErrParse) {
+				t.Errorf("Parse() error = %v, want ErrParse", err)
+			}
+			return
+		}
+		if len(parsed.Body) > maxBodySize {
+			t.Errorf("Body has %d bytes, more than %d", len(parsed.Body), maxBodySize)
+		}
+	})
+}
@@ -0,0 +1,180 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// File is an attachment of a message.
+type File struct {
+	Filename    string
+	ContentType string
+	// Data is the content of the file, or nil if it is larger than what was kept.
+	Data []byte
+	// Size is the size of the file in bytes.
+	Size int64
+	// SHA256 is the hex SHA-256 hash of the content of the file.
+	SHA256 string
+}
+
+// ReadAttachments returns the content of the attachments of the message raw, the same
+// parts as the Attachments of its ParsedEmail, decoded from their transfer encoding but
+// not from their charset, so that their hashes are those of the attached files.
+// Attachments larger than maxSize are left out.
+func ReadAttachments(raw []byte, maxSize int64) ([]File, error) {
+	files, err := ReadAttachmentsWithLimits(context.Background(), raw, Limits{}, func(string, string) int64 { return maxSize })
+	kept := files[:0]
+	for _, f := range files {
+		if f.Data != nil {
+			kept = append(kept, f)
+		} else {
+			log.Printf("Warning: skipped attachment %s larger than %d bytes", f.Filename, maxSize)
+		}
+	}
+	if len(kept) == 0 {
+		kept = nil
+	}
+	return kept, err
+}
+
+// ReadAttachmentsWithLimits returns the attachments of the message raw as ReadAttachments
+// does, hashing each one as it is decoded. The content of an attachment is only kept if
+// it is no larger than what keep returns for its file name and media type, so that large
+// attachments are never held in memory; a nil keep keeps none. The ParseTime and
+// DecodedBytes of limits bound the reading as they bound the parsing: once one is
+// reached, the attachments read so far are returned along with an error that tells what
+// was left out.
+func ReadAttachmentsWithLimits(ctx context.Context, raw []byte, limits Limits, keep func(filename, contentType string) int64) ([]File, error) {
+	limits = limits.withDefaults()
+	ctx, cancel := context.WithTimeoutCause(ctx, limits.ParseTime, errParseTime)
+	defer cancel()
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	header, err := r.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%w: %w", ErrParse, err)
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil, nil
+	}
+	if keep == nil {
+		keep = func(string, string) int64 { return 0 }
+	}
+	w := &fileWalker{ctx: ctx, keep: keep, limits: limits, budget: limits.DecodedBytes}
+	w.walk(&contextReader{ctx: ctx, r: r.R}, params["boundary"], 1)
+	return w.files, w.err
+}
+
+// fileWalker collects the attachments of the parts of a message.
+type fileWalker struct {
+	ctx    context.Context
+	keep   func(filename, contentType string) int64
+	limits Limits
+	// budget is the number of bytes that may still be decoded.
+	budget int64
+	files  []File
+	// parts is the number of parts read so far, which is bounded like in Parse.
+	parts int
+	// err is set once a limit is reached, which stops the walk.
+	err error
+}
+
+func (w *fileWalker) walk(body io.Reader, boundary string, depth int) {
+	mr := multipart.NewReader(body, boundary)
+	for w.err == nil && w.parts < maxParts {
+		w.parts++
+		part, err := mr.NextPart()
+		if err != nil {
+			w.checkTime()
+			return
+		}
+		mediaType, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(mediaType, "multipart/") {
+			if depth < maxPartDepth && params["boundary"] != "" {
+				w.walk(part, params["boundary"], depth+1)
+			}
+			continue
+		}
+		filename := partFilename(part.Header)
+		disposition, _, _ := mime.ParseMediaType(part.Header.Get("Content-Disposition"))
+		if disposition != "attachment" && (disposition == "inline" || filename == "") {
+			continue
+		}
+		var content io.Reader = part
+		if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+			content = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		h := sha256.New()
+		data := &cappedBuffer{max: w.keep(filename, mediaType)}
+		n, err := io.Copy(io.MultiWriter(h, data), io.LimitReader(content, w.budget+1))
+		if err != nil {
+			if !w.checkTime() {
+				log.Printf("Warning: could not read attachment %s: %v", filename, err)
+			}
+			continue
+		}
+		if n > w.budget {
+			w.err = fmt.Errorf("stopped reading the attachments after the first %d decoded bytes", w.limits.DecodedBytes)
+			return
+		}
+		w.budget -= n
+		w.files = append(w.files, File{Filename: filename, ContentType: mediaType, Data: data.bytes(), Size: n, SHA256: hex.EncodeToString(h.Sum(nil))})
+	}
+}
+
+// checkTime sets w.err and returns true if the reading ran out of time.
+func (w *fileWalker) checkTime() bool {
+	if w.ctx.Err() == nil {
+		return false
+	}
+	if context.Cause(w.ctx) == errParseTime {
+		w.err = fmt.Errorf("stopped reading the attachments after %s", w.limits.ParseTime)
+	} else {
+		w.err = w.ctx.Err()
+	}
+	return true
+}
+
+// cappedBuffer keeps what is written to it as long as it is no longer than max bytes.
+type cappedBuffer struct {
+	max  int64
+	data []byte
+	over bool
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if !b.over {
+		if int64(len(b.data)+len(p)) > b.max {
+			b.over, b.data = true, nil
+		} else {
+			b.data = append(b.data, p...)
+		}
+	}
+	return len(p), nil
+}
+
+// bytes returns what was kept, or nil if more than max bytes were written.
+func (b *cappedBuffer) bytes() []byte {
+	if b.over {
+		return nil
+	}
+	if b.data == nil {
+		return []byte{}
+	}
+	return b.data
+}
@@ -0,0 +1,89 @@
+package email
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestReadAttachments(t *testing.T) {
+	raw := []byte("From: a@example.com\r\nContent-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+		"--outer\r\nContent-Type: multipart/alternative; boundary=inner\r\n\r\n" +
+		"--inner\r\nContent-Type: text/plain\r\n\r\nSee the invoice.\r\n" +
+		"--inner\r\nContent-Type: text/html\r\n\r\n<p>See the invoice.</p>\r\n" +
+		"--inner--\r\n" +
+		"--outer\r\nContent-Type: application/pdf; name=\"invoice.pdf\"\r\nContent-Disposition: attachment; filename=\"invoice.pdf\"\r\nContent-Transfer-Encoding: base64\r\n\r\nJVBERi0xLjQK\r\n" +
+		"--outer\r\nContent-Type: text/plain\r\nContent-Disposition: attachment; filename=\"notes.txt\"\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nca=3Dfe\r\n" +
+		"--outer\r\nContent-Type: application/zip\r\nContent-Disposition: attachment; filename=\"big.zip\"\r\n\r\n0123456789abcdef0123456789abcdef\r\n" +
+		"--outer--\r\n")
+
+	got, err := ReadAttachments(raw, 16)
+	if err != nil {
+		t.Fatalf("ReadAttachments() error = %v", err)
+	}
+	file := func(name, contentType, data string) File {
+		sum := sha256.Sum256([]byte(data))
+		return File{Filename: name, ContentType: contentType, Data: []byte(data), Size: int64(len(data)), SHA256: hex.EncodeToString(sum[:])}
+	}
+	want := []File{
+		file("invoice.pdf", "application/pdf", "%PDF-1.4\n"),
+		file("notes.txt", "text/plain", "ca=fe"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAttachments() = %+v, want %+v", got, want)
+	}
+
+	if got, err := ReadAttachments([]byte("Subject: Plain\r\n\r\nNo attachments.\r\n"), 16); err != nil || got != nil {
+		t.Errorf("ReadAttachments(plain) = %v, %v, want nil", got, err)
+	}
+}
+
+func TestReadAttachmentsWithLimits(t *testing.T) {
+	raw := []byte("From: a@example.com\r\nContent-Type: multipart/mixed; boundary=outer\r\n\r\n" +
+		"--outer\r\nContent-Type: text/plain\r\n\r\nSee the attachments.\r\n" +
+		"--outer\r\nContent-Type: text/html\r\nContent-Disposition: attachment; filename=\"login.html\"\r\n\r\n<form>\r\n" +
+		"--outer\r\nContent-Type: application/zip\r\nContent-Disposition: attachment; filename=\"big.zip\"\r\n\r\n0123456789abcdef\r\n" +
+		"--outer\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=\"last.pdf\"\r\n\r\n%PDF-1.4\r\n" +
+		"--outer--\r\n")
+	hash := func(data string) string {
+		sum := sha256.Sum256([]byte(data))
+		return hex.EncodeToString(sum[:])
+	}
+	keep := func(filename, contentType string) int64 {
+		if contentType == "text/html" {
+			return 100
+		}
+		return 0
+	}
+
+	got, err := ReadAttachmentsWithLimits(context.Background(), raw, Limits{}, keep)
+	if err != nil {
+		t.Fatalf("ReadAttachmentsWithLimits() error = %v", err)
+	}
+	want := []File{
+		{Filename: "login.html", ContentType: "text/html", Data: []byte("<form>"), Size: 6, SHA256: hash("<form>")},
+		{Filename: "big.zip", ContentType: "application/zip", Size: 16, SHA256: hash("0123456789abcdef")},
+		{Filename: "last.pdf", ContentType: "application/pdf", Size: 8, SHA256: hash("%PDF-1.4")},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadAttachmentsWithLimits() = %+v, want %+v", got, want)
+	}
+
+	got, err = ReadAttachmentsWithLimits(context.Background(), raw, Limits{DecodedBytes: 20}, keep)
+	if err == nil || !strings.Contains(err.Error(), "after the first 20 decoded bytes") {
+		t.Errorf("ReadAttachmentsWithLimits() beyond DecodedBytes error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want[:1]) {
+		t.Errorf("ReadAttachmentsWithLimits() beyond DecodedBytes = %+v, want %+v", got, want[:1])
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := ReadAttachmentsWithLimits(ctx, raw, Limits{}, keep); !errors.Is(err, context.Canceled) {
+		t.Errorf("ReadAttachmentsWithLimits() with a cancelled context error = %v", err)
+	}
+}
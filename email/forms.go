@@ -0,0 +1,153 @@
+package email
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// maxForms is the number of forms of a message that are kept in ParsedEmail.Forms.
+const maxForms = 20
+
+// Form is an HTML form of the body of a message, or the input fields of the body that
+// are not in one.
+type Form struct {
+	// Action is the URL that the form is submitted to, as written, or "" if it has none.
+	Action string
+	// Method is the method of the form in upper case, GET by default.
+	Method string
+	// Standalone is whether the fields are not in a form element, such as those that a
+	// script submits.
+	Standalone bool
+	// Fields is the number of the fields that the user fills in, PasswordFields the
+	// number of password fields and CardFields that of payment card fields, such as the
+	// number, expiry date and security code of a card.
+	Fields         int
+	PasswordFields int
+	CardFields     int
+}
+
+// External reports whether the form is submitted to a web site, with an absolute http or
+// https action, rather than nowhere or to a relative URL, which has no site to resolve
+// against in a message.
+func (f *Form) External() bool {
+	u, err := url.Parse(strings.TrimSpace(f.Action))
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Host returns the host of the action of the form, or "".
+func (f *Form) Host() string {
+	if u, err := url.Parse(strings.TrimSpace(f.Action)); err == nil {
+		return u.Hostname()
+	}
+	return ""
+}
+
+// cardFieldNames are parts of the names and ids of the fields of payment cards.
+var cardFieldNames = []string{
+	"cardnumber", "card_number", "card-number", "cardnum", "ccnum", "ccnumber", "cc_number", "cc-number",
+	"creditcard", "credit_card", "credit-card", "cvv", "cvc", "csc", "ccexp", "cc_exp", "cc-exp", "expdate",
+	"exp_date", "exp-date",
+}
+
+// hasFields reports whether html has form, input, select or textarea tags, so that only
+// the chunks with fields are tokenized.
+func hasFields(html []byte) bool {
+	for {
+		i := bytes.IndexByte(html, '<')
+		if i < 0 {
+			return false
+		}
+		html = html[i+1:]
+		for _, tag := range []string{"form", "input", "select", "textarea"} {
+			if len(html) >= len(tag) && bytes.EqualFold(html[:len(tag)], []byte(tag)) {
+				return true
+			}
+		}
+	}
+}
+
+// scanForms adds the forms and fields of a chunk of HTML to x. A form that is not closed
+// in the chunk gets the fields of the next ones.
+func (x *extraction) scanForms(text []byte) {
+	z := html.NewTokenizer(bytes.NewReader(text))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			return
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken && tt != html.EndTagToken {
+			continue
+		}
+		name, hasAttr := z.TagName()
+		attrs := map[string]string{}
+		for hasAttr {
+			var key, value []byte
+			key, value, hasAttr = z.TagAttr()
+			attrs[string(key)] = string(value)
+		}
+		switch string(name) {
+		case "form":
+			if tt == html.EndTagToken {
+				x.inForm = false
+				continue
+			}
+			method := strings.ToUpper(strings.TrimSpace(attrs["method"]))
+			if method == "" {
+				method = "GET"
+			}
+			x.inForm = x.addForm(Form{Action: strings.TrimSpace(attrs["action"]), Method: method})
+		case "input", "select", "textarea":
+			if tt == html.EndTagToken {
+				continue
+			}
+			inputType := strings.ToLower(strings.TrimSpace(attrs["type"]))
+			if string(name) == "input" && (inputType == "hidden" || inputType == "submit" || inputType == "button" ||
+				inputType == "reset" || inputType == "image") {
+				continue
+			}
+			if !x.inForm && (len(x.forms) == 0 || !x.forms[len(x.forms)-1].Standalone) {
+				if !x.addForm(Form{Standalone: true}) {
+					continue
+				}
+			}
+			f := &x.forms[len(x.forms)-1]
+			f.Fields++
+			if inputType == "password" {
+				f.PasswordFields++
+			} else if isCardField(attrs) {
+				f.CardFields++
+			}
+		}
+	}
+}
+
+// addForm adds f to the forms of x, and reports whether it was added, since only the
+// first maxForms are kept. The others are not worth a warning: the first ones tell
+// enough about the message.
+func (x *extraction) addForm(f Form) bool {
+	if len(x.forms) == maxForms {
+		return false
+	}
+	x.forms = append(x.forms, f)
+	return true
+}
+
+// isCardField reports whether the field with attrs is for a payment card, by its
+// autocomplete attribute or its name and id.
+func isCardField(attrs map[string]string) bool {
+	if strings.HasPrefix(strings.ToLower(strings.TrimSpace(attrs["autocomplete"])), "cc-") {
+		return true
+	}
+	for _, a := range []string{attrs["name"], attrs["id"]} {
+		a = strings.ToLower(a)
+		for _, n := range cardFieldNames {
+			if strings.Contains(a, n) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,95 @@
+package email
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse_Forms(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want []Form
+	}{
+		{
+			name: "Credential form",
+			body: `<p>Your session expired.</p>
+<FORM action="https://login.example.org/auth.php" method="post">
+<input type="email" name="user"><input
+  type="password" name="pass">
+<input type="hidden" name="id" value="42"><input type="submit" value="Sign in">
+</FORM>`,
+			want: []Form{{Action: "https://login.example.org/auth.php", Method: "POST", Fields: 2, PasswordFields: 1}},
+		},
+		{
+			name: "Card fields without a form",
+			body: `<div><input name="CardNumber"><input autocomplete="cc-exp"><input id="cvv2">
+<select name="country"></select></div>`,
+			want: []Form{{Standalone: true, Fields: 4, CardFields: 3}},
+		},
+		{
+			name: "Several forms",
+			body: `<form><textarea name="feedback"></textarea></form><input type="password"><form action="#"></form>`,
+			want: []Form{
+				{Method: "GET", Fields: 1},
+				{Standalone: true, Fields: 1, PasswordFields: 1},
+				{Action: "#", Method: "GET"},
+			},
+		},
+		{name: "No form", body: `<p>Use the <b>input</b> of the form below &lt;form&gt;.</p>`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw := "From: it@example.org\r\nSubject: Session\r\nContent-Type: text/html\r\n\r\n" + strings.ReplaceAll(tt.body, "\n", "\r\n")
+			parsed, err := Parse(strings.NewReader(raw))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parsed.Forms, tt.want) {
+				t.Errorf("Forms = %+v, want %+v", parsed.Forms, tt.want)
+			}
+		})
+	}
+}
+
+func TestParse_FormsInTextParts(t *testing.T) {
+	// Forms are only looked for in HTML, and the fields of a form in an attached page
+	// count too.
+	raw := "Subject: Invoice\r\nContent-Type: multipart/mixed; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\n<form><input type=password></form>\r\n" +
+		"--b\r\nContent-Type: text/html\r\nContent-Disposition: attachment; filename=invoice.htm\r\n\r\n" +
+		"<form action='http://pay.example.net/'><input name=cc_number><input type=password></form>\r\n--b--\r\n"
+	parsed, err := Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Form{{Action: "http://pay.example.net/", Method: "GET", Fields: 2, PasswordFields: 1, CardFields: 1}}
+	if !reflect.DeepEqual(parsed.Forms, want) {
+		t.Errorf("Forms = %+v, want %+v", parsed.Forms, want)
+	}
+}
+
+func TestForm_External(t *testing.T) {
+	tests := []struct {
+		action string
+		want   bool
+		host   string
+	}{
+		{"https://login.example.org/auth.php", true, "login.example.org"},
+		{" HTTP://Pay.Example.net:8080/ ", true, "Pay.Example.net"},
+		{"/login", false, ""},
+		{"#", false, ""},
+		{"mailto:it@example.org", false, ""},
+		{"", false, ""},
+	}
+	for _, tt := range tests {
+		f := Form{Action: tt.action}
+		if got := f.External(); got != tt.want {
+			t.Errorf("External() of %q = %v, want %v", tt.action, got, tt.want)
+		}
+		if got := f.Host(); got != tt.host {
+			t.Errorf("Host() of %q = %q, want %q", tt.action, got, tt.host)
+		}
+	}
+}
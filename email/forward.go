@@ -0,0 +1,62 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/textproto"
+	"strings"
+)
+
+// ForwardedMessage returns the first message attached to the message raw, as when a user
+// forwards a message "as attachment" to report it, decoded from its transfer encoding, or
+// nil if raw has none. Messages attached to attached messages are not looked for.
+func ForwardedMessage(raw []byte) []byte {
+	r := textproto.NewReader(bufio.NewReader(bytes.NewReader(raw)))
+	header, err := r.ReadMIMEHeader()
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil
+	}
+	mediaType, params, err := mime.ParseMediaType(header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return nil
+	}
+	parts := 0
+	return forwardedPart(r.R, params["boundary"], 1, &parts)
+}
+
+// forwardedPart returns the first message/rfc822 part of a multipart body, counting the
+// parts read in parts, which is bounded like in Parse.
+func forwardedPart(body io.Reader, boundary string, depth int, parts *int) []byte {
+	mr := multipart.NewReader(body, boundary)
+	for *parts < maxParts {
+		*parts++
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil
+		}
+		mediaType, params, err := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		switch {
+		case err != nil:
+		case strings.HasPrefix(mediaType, "multipart/"):
+			if depth < maxPartDepth && params["boundary"] != "" {
+				if data := forwardedPart(part, params["boundary"], depth+1, parts); data != nil {
+					return data
+				}
+			}
+		case mediaType == "message/rfc822":
+			var content io.Reader = part
+			if strings.EqualFold(strings.TrimSpace(part.Header.Get("Content-Transfer-Encoding")), "base64") {
+				content = base64.NewDecoder(base64.StdEncoding, part)
+			}
+			if data, err := io.ReadAll(content); err == nil && len(data) > 0 {
+				return data
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package email
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestForwardedMessage(t *testing.T) {
+	raw, err := os.ReadFile(filepath.Join("..", "testdata", "e2e", "forwarded-rfc822.eml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(ForwardedMessage(raw))
+	if !strings.HasPrefix(got, "From: \"Mail Admin\" <admin@mailbox-quota.example.net>\r\n") || !strings.HasSuffix(got, "to keep receiving mail.</p>\r\n") {
+		t.Errorf("ForwardedMessage() = %q", got)
+	}
+
+	base64Nested := "Content-Type: multipart/mixed; boundary=outer\n\n" +
+		"--outer\nContent-Type: multipart/alternative; boundary=inner\n\n--inner\nContent-Type: text/plain\n\nSee attached.\n--inner--\n" +
+		"--outer\nContent-Type: message/rfc822\nContent-Transfer-Encoding: base64\n\nU3ViamVjdDogSGkKCkJvZHkK\n--outer--\n"
+	if got := string(ForwardedMessage([]byte(base64Nested))); got != "Subject: Hi\n\nBody\n" {
+		t.Errorf("ForwardedMessage() of a base64 message = %q", got)
+	}
+
+	for _, raw := range []string{
+		"Subject: Hi\n\nBody\n",
+		"Content-Type: multipart/mixed; boundary=b\n\n--b\nContent-Type: text/plain\n\nHi\n--b--\n",
+	} {
+		if got := ForwardedMessage([]byte(raw)); got != nil {
+			t.Errorf("ForwardedMessage(%q) = %q, want nil", raw, got)
+		}
+	}
+}
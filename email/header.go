@@ -0,0 +1,182 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"net/textproto"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+)
+
+// wordDecoder decodes the RFC 2047 encoded-words of headers in every charset that
+// charset.Reader knows. Importing the charset package also lets go-message decode the
+// subject and display names in those charsets, such as ISO-2022-JP, rather than leaving
+// them encoded.
+var wordDecoder = &mime.WordDecoder{CharsetReader: charset.Reader}
+
+// decodeText decodes the encoded-words of a header value, or returns it as is if they
+// cannot be decoded.
+func decodeText(s string) string {
+	decoded, err := wordDecoder.DecodeHeader(s)
+	if err != nil {
+		return s
+	}
+	return decoded
+}
+
+// FormatAddress formats addr as "Name" <address>, like its String method, but leaves
+// display names that are not ASCII decoded, for people and models to read.
+func FormatAddress(addr *mail.Address) string {
+	if addr.Name == "" || isASCII(addr.Name) {
+		return addr.String()
+	}
+	name := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(addr.Name)
+	return `"` + name + `" <` + addr.Address + `>`
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// partFilename returns the file name of a part, from the filename parameter of its
+// Content-Disposition, or else the name parameter of its Content-Type.
+func partFilename(h textproto.MIMEHeader) string {
+	if filename := headerParam(h.Get("Content-Disposition"), "filename"); filename != "" {
+		return filename
+	}
+	return headerParam(h.Get("Content-Type"), "name")
+}
+
+// headerParam returns the parameter name of a header value with parameters, such as
+// Content-Disposition. Unlike mime.ParseMediaType, it decodes RFC 2231 values split in
+// sections and in any charset, such as filename*0*=iso-2022-jp'ja'..., and the RFC 2047
+// encoded-words that many mail clients put in quoted values instead, and it keeps the
+// parameters before a syntax error.
+func headerParam(value, name string) string {
+	type section struct {
+		index   int
+		value   string
+		encoded bool
+	}
+	var plain string
+	var sections []section
+	_, rest, _ := strings.Cut(value, ";")
+	for rest != "" {
+		var key, v string
+		key, v, rest = nextParam(rest)
+		if key == "" {
+			break
+		}
+		key = strings.ToLower(key)
+		switch {
+		case key == strings.ToLower(name):
+			plain = v
+		case strings.HasPrefix(key, strings.ToLower(name)+"*"):
+			suffix := strings.TrimPrefix(key, strings.ToLower(name)+"*")
+			encoded := strings.HasSuffix(suffix, "*") || suffix == ""
+			index := 0
+			if n := strings.TrimSuffix(suffix, "*"); n != "" {
+				var err error
+				if index, err = strconv.Atoi(n); err != nil {
+					continue
+				}
+			}
+			sections = append(sections, section{index, v, encoded})
+		}
+	}
+	if len(sections) == 0 {
+		return decodeText(plain)
+	}
+
+	slices.SortFunc(sections, func(a, b section) int { return a.index - b.index })
+	var cs string
+	var b []byte
+	for i, s := range sections {
+		if s.index != i {
+			break // A missing section ends the value.
+		}
+		v := s.value
+		if i == 0 && s.encoded {
+			// The first section starts with the charset and language: charset'lang'value.
+			parts := strings.SplitN(v, "'", 3)
+			if len(parts) == 3 {
+				cs, v = parts[0], parts[2]
+			}
+		}
+		if s.encoded {
+			b = append(b, percentDecode(v)...)
+		} else {
+			b = append(b, v...)
+		}
+	}
+	switch strings.ToLower(cs) {
+	case "", "utf-8", "us-ascii":
+		return string(b)
+	}
+	r, err := charset.Reader(cs, bytes.NewReader(b))
+	if err != nil {
+		return string(b)
+	}
+	decoded, err := io.ReadAll(r)
+	if err != nil {
+		return string(b)
+	}
+	return string(decoded)
+}
+
+// nextParam parses the first parameter of s, key=value or key="value", and returns the
+// rest of s after it. key is empty if s has no parameter.
+func nextParam(s string) (key, value, rest string) {
+	s = strings.TrimLeft(s, " \t\r\n;")
+	eq := strings.IndexByte(s, '=')
+	if eq < 0 {
+		return "", "", ""
+	}
+	key = strings.TrimSpace(s[:eq])
+	s = strings.TrimLeft(s[eq+1:], " \t\r\n")
+	if !strings.HasPrefix(s, `"`) {
+		value, rest, _ = strings.Cut(s, ";")
+		return key, strings.TrimSpace(value), rest
+	}
+	var v strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case c == '\\' && i+1 < len(s):
+			i++
+			v.WriteByte(s[i])
+		case c == '"':
+			_, rest, _ = strings.Cut(s[i+1:], ";")
+			return key, v.String(), rest
+		default:
+			v.WriteByte(c)
+		}
+	}
+	return key, v.String(), "" // An unterminated quoted value ends with the header.
+}
+
+// percentDecode decodes the %XX escapes of an RFC 2231 value, and leaves invalid ones.
+func percentDecode(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '%' && i+2 < len(s) {
+			if n, err := strconv.ParseUint(s[i+1:i+3], 16, 8); err == nil {
+				b = append(b, byte(n))
+				i += 2
+				continue
+			}
+		}
+		b = append(b, s[i])
+	}
+	return b
+}
@@ -0,0 +1,59 @@
+package email
+
+import (
+	"testing"
+
+	"github.com/emersion/go-message/mail"
+)
+
+func TestHeaderParam(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		param string
+		want  string
+	}{
+		{name: "Plain", value: `attachment; filename="invoice.pdf"`, param: "filename", want: "invoice.pdf"},
+		{name: "Token", value: `attachment; size=10; filename=invoice.pdf`, param: "filename", want: "invoice.pdf"},
+		{name: "Escaped quote", value: `attachment; filename="a \"b\".pdf"`, param: "filename", want: `a "b".pdf`},
+		{name: "Case-insensitive name", value: `attachment; FileName="invoice.pdf"`, param: "filename", want: "invoice.pdf"},
+		{name: "RFC 2047 in quotes", value: `attachment; filename="=?ISO-2022-JP?B?GyRCQEE1YT1xGyhCLnBkZg==?="`, param: "filename", want: "請求書.pdf"},
+		{name: "RFC 2231 UTF-8", value: `attachment; filename*=UTF-8''%E8%AB%8B%E6%B1%82%E6%9B%B8.pdf`, param: "filename", want: "請求書.pdf"},
+		{name: "RFC 2231 ISO-2022-JP", value: `attachment; filename*=iso-2022-jp'ja'%1B%24B%40A5a%3Dq%1B%28B.pdf`, param: "filename", want: "請求書.pdf"},
+		{
+			name:  "RFC 2231 sections",
+			value: "attachment;\r\n filename*1*=%3Dq%1B%28B.pdf;\r\n filename*0*=iso-2022-jp''%1B%24B%40A5a",
+			param: "filename",
+			want:  "請求書.pdf",
+		},
+		{name: "RFC 2231 unencoded sections", value: `attachment; filename*0="long-"; filename*1="name.pdf"`, param: "filename", want: "long-name.pdf"},
+		{name: "Extended value wins", value: `attachment; filename="fallback.pdf"; filename*=UTF-8''real.pdf`, param: "filename", want: "real.pdf"},
+		{name: "Unterminated quote", value: `attachment; filename="invoice.pdf`, param: "filename", want: "invoice.pdf"},
+		{name: "Missing", value: `attachment; name="x"`, param: "filename", want: ""},
+		{name: "Unknown charset", value: `attachment; filename*=x-unknown''abc`, param: "filename", want: "abc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := headerParam(tt.value, tt.param); got != tt.want {
+				t.Errorf("headerParam(%q, %q) = %q, want %q", tt.value, tt.param, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFormatAddress(t *testing.T) {
+	tests := []struct {
+		addr mail.Address
+		want string
+	}{
+		{mail.Address{Address: "soumu@example.co.jp"}, "<soumu@example.co.jp>"},
+		{mail.Address{Name: "General Affairs", Address: "soumu@example.co.jp"}, `"General Affairs" <soumu@example.co.jp>`},
+		{mail.Address{Name: "総務部", Address: "soumu@example.co.jp"}, `"総務部" <soumu@example.co.jp>`},
+		{mail.Address{Name: `総務 "部"`, Address: "soumu@example.co.jp"}, `"総務 \"部\"" <soumu@example.co.jp>`},
+	}
+	for _, tt := range tests {
+		if got := FormatAddress(&tt.addr); got != tt.want {
+			t.Errorf("FormatAddress(%+v) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
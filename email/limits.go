@@ -0,0 +1,82 @@
+package email
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"slices"
+	"time"
+)
+
+// Default limits of the parsing of a message.
+const (
+	DefaultMaxParseTime    = 30 * time.Second
+	DefaultMaxDecodedBytes = 64 * 1024 * 1024
+	DefaultMaxURLs         = 1000
+)
+
+// Limits bound the work of parsing a message, so that a pathological message cannot
+// stall a server. Parsing does not fail when a limit is reached: the parts of the
+// message read so far are kept, and ParsedEmail.Warnings tells what was left out. Zero
+// values are replaced with the defaults.
+type Limits struct {
+	// ParseTime is the time the parsing may take.
+	ParseTime time.Duration
+	// DecodedBytes is the number of bytes of the text and image parts that are decoded.
+	// The parts after it are still listed as attachments.
+	DecodedBytes int64
+	// URLs is the number of distinct URLs that are kept.
+	URLs int
+}
+
+func (l Limits) withDefaults() Limits {
+	if l.ParseTime <= 0 {
+		l.ParseTime = DefaultMaxParseTime
+	}
+	if l.DecodedBytes <= 0 {
+		l.DecodedBytes = DefaultMaxDecodedBytes
+	}
+	if l.URLs <= 0 {
+		l.URLs = DefaultMaxURLs
+	}
+	return l
+}
+
+// errParseTime is the cause of the context of a parsing that ran out of time.
+var errParseTime = errors.New("parse time limit reached")
+
+// warn logs a warning about what was left out of the message, and adds it to the
+// warnings of the message once.
+func (x *extraction) warn(format string, args ...any) {
+	warning := fmt.Sprintf(format, args...)
+	log.Printf("Warning: %s", warning)
+	if !slices.Contains(x.warnings, warning) {
+		x.warnings = append(x.warnings, warning)
+	}
+}
+
+// decoded returns a reader of r that ends once the message has used up its budget of
+// decoded bytes.
+func (x *extraction) decoded(r io.Reader) io.Reader {
+	return &budgetReader{x: x, r: r}
+}
+
+// budgetReader is a reader that counts the bytes read against the budget of its message.
+type budgetReader struct {
+	x *extraction
+	r io.Reader
+}
+
+func (r *budgetReader) Read(p []byte) (int, error) {
+	if r.x.budget <= 0 {
+		r.x.warn("stopped decoding the parts after the first %d bytes", r.x.limits.DecodedBytes)
+		return 0, io.EOF
+	}
+	if int64(len(p)) > r.x.budget {
+		p = p[:r.x.budget]
+	}
+	n, err := r.r.Read(p)
+	r.x.budget -= int64(n)
+	return n, err
+}
@@ -0,0 +1,226 @@
+package email
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Kinds of the suspicious code points of a subject.
+const (
+	// KindMathematical are the mathematical alphanumeric symbols, such as bold or script
+	// letters, which spell words in another font.
+	KindMathematical = "mathematical"
+	// KindFullwidth are the fullwidth forms of ASCII characters.
+	KindFullwidth = "fullwidth"
+	// KindEnclosed are the Latin letters in circles, squares or parentheses.
+	KindEnclosed = "enclosed"
+	// KindRegionalIndicator are the regional indicator symbols, which are shown as
+	// letters in boxes unless they make up a flag.
+	KindRegionalIndicator = "regional_indicator"
+	// KindSmallCapital are the small capitals and other phonetic letters.
+	KindSmallCapital = "small_capital"
+	// KindMixedScript are the letters of another script, such as Cyrillic, in a word of
+	// Latin letters.
+	KindMixedScript = "mixed_script"
+	// KindCombining are the combining marks that strike through or underline letters.
+	KindCombining = "combining"
+	// KindInvisible are the zero-width characters that split words without being seen.
+	KindInvisible = "invisible"
+	// KindBidi are the controls that change the direction of the text.
+	KindBidi = "bidi"
+)
+
+// maxCodepoints is the number of distinct suspicious code points that are reported.
+const maxCodepoints = 20
+
+// Obfuscation describes the characters of a subject that make it read like another one
+// while defeating the rules that match its text, such as mathematical bold letters
+// spelling a brand.
+type Obfuscation struct {
+	// Scripts are the Unicode scripts of the letters of the subject, such as "Latin" and
+	// "Cyrillic", in the order of their first letter, without the characters common to
+	// several scripts.
+	Scripts []string `json:"scripts"`
+	// Normalized is the subject with the suspicious characters replaced with the ASCII
+	// letters they imitate, or removed if they are invisible.
+	Normalized string `json:"normalized"`
+	// Codepoints are the distinct suspicious code points, in the order of their first use.
+	Codepoints []Codepoint `json:"codepoints"`
+}
+
+// Codepoint is a suspicious code point of a subject.
+type Codepoint struct {
+	// Codepoint is the code point in the U+XXXX notation.
+	Codepoint string `json:"codepoint"`
+	Char      string `json:"char"`
+	// Kind is one of the Kind constants.
+	Kind string `json:"kind"`
+	// Count is the number of times it is used.
+	Count int `json:"count"`
+}
+
+// lookalikes are the letters of other scripts that look like Latin letters, which are
+// suspicious in Latin words, and the Latin letters they imitate.
+var lookalikes = map[rune]rune{
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't',
+	'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ј': 'j', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ո': 'n',
+	'А': 'A', 'В': 'B', 'Е': 'E', 'К': 'K', 'М': 'M', 'Н': 'H', 'О': 'O', 'Р': 'P', 'С': 'C', 'Т': 'T',
+	'У': 'Y', 'Х': 'X', 'Ѕ': 'S', 'І': 'I', 'Ј': 'J',
+	// Greek
+	'α': 'a', 'ο': 'o', 'ρ': 'p', 'ν': 'v', 'τ': 't', 'ι': 'i', 'κ': 'k', 'υ': 'u',
+	'Α': 'A', 'Β': 'B', 'Ε': 'E', 'Ζ': 'Z', 'Η': 'H', 'Ι': 'I', 'Κ': 'K', 'Μ': 'M', 'Ν': 'N', 'Ο': 'O',
+	'Ρ': 'P', 'Τ': 'T', 'Υ': 'Y', 'Χ': 'X',
+}
+
+// smallCapitals are the small capitals and the letters they imitate.
+var smallCapitals = map[rune]rune{
+	'ᴀ': 'A', 'ʙ': 'B', 'ᴄ': 'C', 'ᴅ': 'D', 'ᴇ': 'E', 'ꜰ': 'F', 'ɢ': 'G', 'ʜ': 'H', 'ɪ': 'I', 'ᴊ': 'J',
+	'ᴋ': 'K', 'ʟ': 'L', 'ᴍ': 'M', 'ɴ': 'N', 'ᴏ': 'O', 'ᴘ': 'P', 'ʀ': 'R', 'ꜱ': 'S', 'ᴛ': 'T', 'ᴜ': 'U',
+	'ᴠ': 'V', 'ᴡ': 'W', 'ʏ': 'Y', 'ᴢ': 'Z',
+}
+
+// SubjectObfuscation returns what the characters of subject tell of an attempt to make
+// it read like another one, or nil if it has no suspicious code point. Letters of other
+// scripts are only suspicious in words of Latin letters, so that subjects in Russian or
+// Greek, for example, are not reported.
+func SubjectObfuscation(subject string) *Obfuscation {
+	runes := []rune(subject)
+	kinds := make([]string, len(runes))
+	for i, r := range runes {
+		kinds[i] = codepointKind(r)
+	}
+	adjustKinds(runes, kinds)
+
+	o := &Obfuscation{Scripts: []string{}}
+	var normalized strings.Builder
+	for i, r := range runes {
+		if script := scriptOf(r); script != "" && !slices.Contains(o.Scripts, script) {
+			o.Scripts = append(o.Scripts, script)
+		}
+		kind := kinds[i]
+		if kind == "" {
+			normalized.WriteRune(r)
+			continue
+		}
+		normalized.WriteString(replacement(r, kind))
+		j := slices.IndexFunc(o.Codepoints, func(c Codepoint) bool { return c.Char == string(r) })
+		if j >= 0 {
+			o.Codepoints[j].Count++
+		} else if len(o.Codepoints) < maxCodepoints {
+			o.Codepoints = append(o.Codepoints, Codepoint{Codepoint: fmt.Sprintf("U+%04X", r), Char: string(r), Kind: kind, Count: 1})
+		}
+	}
+	if len(o.Codepoints) == 0 {
+		return nil
+	}
+	o.Normalized = normalized.String()
+	return o
+}
+
+// codepointKind returns the kind of r if it is suspicious in any subject, or "".
+func codepointKind(r rune) string {
+	switch {
+	case r >= 0x1D400 && r <= 0x1D7FF:
+		return KindMathematical
+	case r >= 0xFF01 && r <= 0xFF5E:
+		return KindFullwidth
+	case r >= 0x249C && r <= 0x24E9, r >= 0x1F110 && r <= 0x1F189:
+		return KindEnclosed
+	case r >= 0x1F1E6 && r <= 0x1F1FF:
+		return KindRegionalIndicator
+	case smallCapitals[r] != 0:
+		return KindSmallCapital
+	case r >= 0x0300 && r <= 0x036F, r >= 0x1AB0 && r <= 0x1AFF, r >= 0x1DC0 && r <= 0x1DFF, r >= 0x20D0 && r <= 0x20FF:
+		return KindCombining
+	case r == 0x00AD, r == 0x034F, r == 0x180E, r >= 0x200B && r <= 0x200D, r >= 0x2060 && r <= 0x2064, r == 0xFEFF:
+		return KindInvisible
+	case r == 0x200E, r == 0x200F, r >= 0x202A && r <= 0x202E, r >= 0x2066 && r <= 0x2069:
+		return KindBidi
+	}
+	return ""
+}
+
+// adjustKinds sets the kind of the lookalike letters of the words that have Latin
+// letters to KindMixedScript, and clears that of the characters that are not suspicious
+// where they are: fullwidth forms in subjects in Chinese, Japanese or Korean, which use
+// them as a matter of course, invisible characters that are not next to a letter or a
+// digit, such as the joiners of emoji sequences, and flags, made of two regional
+// indicators.
+func adjustKinds(runes []rune, kinds []string) {
+	cjk := slices.ContainsFunc(runes, func(r rune) bool {
+		return unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul)
+	})
+	alnum := func(i int) bool {
+		return i >= 0 && i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]))
+	}
+	for i := range runes {
+		switch {
+		case kinds[i] == KindFullwidth && cjk:
+			kinds[i] = ""
+		case kinds[i] == KindInvisible && !alnum(i-1) && !alnum(i+1):
+			kinds[i] = ""
+		}
+	}
+	for start := 0; start < len(runes); {
+		end := start
+		latin := false
+		for end < len(runes) && (unicode.IsLetter(runes[end]) || kinds[end] != "") {
+			latin = latin || unicode.Is(unicode.Latin, runes[end])
+			end++
+		}
+		for i := start; i < end; i++ {
+			if latin && lookalikes[runes[i]] != 0 {
+				kinds[i] = KindMixedScript
+			}
+		}
+		start = end + 1
+	}
+	for i := 0; i+1 < len(runes); i++ {
+		if kinds[i] == KindRegionalIndicator && kinds[i+1] == KindRegionalIndicator &&
+			(i+2 == len(runes) || kinds[i+2] != KindRegionalIndicator) && (i == 0 || kinds[i-1] != KindRegionalIndicator) {
+			kinds[i], kinds[i+1] = "", ""
+		}
+	}
+}
+
+// replacement returns what r of kind is replaced with in the normalized subject.
+func replacement(r rune, kind string) string {
+	switch kind {
+	case KindMixedScript:
+		return string(lookalikes[r])
+	case KindSmallCapital:
+		return string(smallCapitals[r])
+	case KindRegionalIndicator:
+		return string('A' + r - 0x1F1E6)
+	case KindEnclosed:
+		switch {
+		case r >= 0x1F150 && r <= 0x1F169:
+			return string('A' + r - 0x1F150)
+		case r >= 0x1F170 && r <= 0x1F189:
+			return string('A' + r - 0x1F170)
+		}
+		return strings.Trim(norm.NFKC.String(string(r)), "()")
+	case KindCombining, KindInvisible, KindBidi:
+		return ""
+	}
+	return norm.NFKC.String(string(r))
+}
+
+// scriptOf returns the name of the script of r, or "" if r is not a letter or belongs to
+// several scripts.
+func scriptOf(r rune) string {
+	if !unicode.IsLetter(r) || unicode.Is(unicode.Common, r) || unicode.Is(unicode.Inherited, r) {
+		return ""
+	}
+	for name, table := range unicode.Scripts {
+		if unicode.Is(table, r) {
+			return name
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package email
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSubjectObfuscation(t *testing.T) {
+	tests := []struct {
+		name    string
+		subject string
+		want    *Obfuscation
+	}{
+		{name: "Plain", subject: "Your invoice is ready"},
+		{name: "Accents", subject: "Votre facture est prête"},
+		{name: "Russian", subject: "Ваш счёт готов"},
+		{name: "Japanese with fullwidth forms", subject: "【重要】ご請求のお知らせ（ＩＤ：１２３）！"},
+		{name: "Emoji", subject: "Summer sale 👨‍👩‍👧 🇫🇷 ☀️"},
+		{
+			name:    "Mathematical bold",
+			subject: "𝐏𝐚𝐲𝐏𝐚𝐥: account on hold",
+			want: &Obfuscation{
+				Scripts:    []string{"Latin"},
+				Normalized: "PayPal: account on hold",
+				Codepoints: []Codepoint{
+					{Codepoint: "U+1D40F", Char: "𝐏", Kind: KindMathematical, Count: 2},
+					{Codepoint: "U+1D41A", Char: "𝐚", Kind: KindMathematical, Count: 2},
+					{Codepoint: "U+1D432", Char: "𝐲", Kind: KindMathematical, Count: 1},
+					{Codepoint: "U+1D425", Char: "𝐥", Kind: KindMathematical, Count: 1},
+				},
+			},
+		},
+		{
+			name:    "Cyrillic in a Latin word",
+			subject: "Аpple ID locked",
+			want: &Obfuscation{
+				Scripts:    []string{"Cyrillic", "Latin"},
+				Normalized: "Apple ID locked",
+				Codepoints: []Codepoint{{Codepoint: "U+0410", Char: "А", Kind: KindMixedScript, Count: 1}},
+			},
+		},
+		{
+			name:    "Invisible and enclosed",
+			subject: "Micro​soft 🅿ay ⓝow ＶＩＰ ᴘᴀʏ",
+			want: &Obfuscation{
+				Scripts:    []string{"Latin"},
+				Normalized: "Microsoft Pay now VIP PAY",
+				Codepoints: []Codepoint{
+					{Codepoint: "U+200B", Char: "​", Kind: KindInvisible, Count: 1},
+					{Codepoint: "U+1F17F", Char: "🅿", Kind: KindEnclosed, Count: 1},
+					{Codepoint: "U+24DD", Char: "ⓝ", Kind: KindEnclosed, Count: 1},
+					{Codepoint: "U+FF36", Char: "Ｖ", Kind: KindFullwidth, Count: 1},
+					{Codepoint: "U+FF29", Char: "Ｉ", Kind: KindFullwidth, Count: 1},
+					{Codepoint: "U+FF30", Char: "Ｐ", Kind: KindFullwidth, Count: 1},
+					{Codepoint: "U+1D18", Char: "ᴘ", Kind: KindSmallCapital, Count: 1},
+					{Codepoint: "U+1D00", Char: "ᴀ", Kind: KindSmallCapital, Count: 1},
+					{Codepoint: "U+028F", Char: "ʏ", Kind: KindSmallCapital, Count: 1},
+				},
+			},
+		},
+		{
+			name:    "Regional indicators and bidi",
+			subject: "🇵🇦🇾 now ‮gnp.exe",
+			want: &Obfuscation{
+				Normalized: "PAY now gnp.exe",
+				Scripts:    []string{"Latin"},
+				Codepoints: []Codepoint{
+					{Codepoint: "U+1F1F5", Char: "🇵", Kind: KindRegionalIndicator, Count: 1},
+					{Codepoint: "U+1F1E6", Char: "🇦", Kind: KindRegionalIndicator, Count: 1},
+					{Codepoint: "U+1F1FE", Char: "🇾", Kind: KindRegionalIndicator, Count: 1},
+					{Codepoint: "U+202E", Char: "‮", Kind: KindBidi, Count: 1},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := SubjectObfuscation(tt.subject); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("SubjectObfuscation(%q) = %+v, want %+v", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package email
+
+import (
+	"bytes"
+	"strings"
+)
+
+// HeaderBlock reports whether raw is a header block without a body, such as the "full
+// headers" that users copy from their mail client and forward, and returns it as a
+// message with an empty body. Blank lines around the block and an indentation of every
+// line, which pasting adds, are removed. raw is not a header block if it has a body, or
+// a line that is neither a header field nor the continuation of one.
+func HeaderBlock(raw []byte) ([]byte, bool) {
+	raw = bytes.TrimPrefix(raw, []byte("\ufeff"))
+	// Most messages are told apart at the blank line after their header, without
+	// splitting the lines of their body.
+	trimmed := bytes.TrimSpace(raw)
+	if len(trimmed) == 0 || bytes.Contains(trimmed, []byte("\n\n")) || bytes.Contains(trimmed, []byte("\n\r\n")) {
+		return nil, false
+	}
+	// The indentation of the first line, which was trimmed along with the blank lines
+	// before it, is removed from the others.
+	start := len(raw) - len(bytes.TrimLeft(raw, " \t\r\n"))
+	indent := string(raw[bytes.LastIndexByte(raw[:start], '\n')+1 : start])
+	lines := strings.Split(strings.ReplaceAll(string(trimmed), "\r\n", "\n"), "\n")
+	var b bytes.Buffer
+	for i, line := range lines {
+		line = strings.TrimPrefix(strings.TrimRight(line, " \t"), indent)
+		switch {
+		case line == "":
+			// The blank line that ends the header, followed by a body.
+			return nil, false
+		case line[0] == ' ' || line[0] == '\t':
+			if i == 0 {
+				return nil, false
+			}
+		case !isFieldLine(line):
+			return nil, false
+		}
+		b.WriteString(line + "\r\n")
+	}
+	b.WriteString("\r\n")
+	return b.Bytes(), true
+}
+
+// isFieldLine reports whether line starts a header field: a name of printable ASCII
+// characters other than the colon, followed by a colon (RFC 5322 section 2.2).
+func isFieldLine(line string) bool {
+	name, _, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if name[i] < 33 || name[i] > 126 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,65 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHeaderBlock(t *testing.T) {
+	tests := []struct {
+		name   string
+		raw    string
+		want   string
+		wantOK bool
+	}{
+		{
+			name:   "pasted with blank lines and indentation",
+			raw:    "\n\n    Received: from mx.example.org\n        by mx.example.net; Mon, 5 Oct 2026 10:00:02 +0000\n    From: <alerts@example.org>\n    Subject: Account locked\n\n",
+			want:   "Received: from mx.example.org\r\n    by mx.example.net; Mon, 5 Oct 2026 10:00:02 +0000\r\nFrom: <alerts@example.org>\r\nSubject: Account locked\r\n\r\n",
+			wantOK: true,
+		},
+		{
+			name:   "message without a body",
+			raw:    "From: <alerts@example.org>\r\nSubject: Account locked\r\n\r\n",
+			want:   "From: <alerts@example.org>\r\nSubject: Account locked\r\n\r\n",
+			wantOK: true,
+		},
+		{
+			name:   "byte order mark",
+			raw:    "\ufeffFrom: <alerts@example.org>",
+			want:   "From: <alerts@example.org>\r\n\r\n",
+			wantOK: true,
+		},
+		{name: "message", raw: "From: <alerts@example.org>\r\nSubject: Account locked\r\n\r\nSign in.\r\n"},
+		{name: "blank line with spaces before a body", raw: "From: <alerts@example.org>\n  \nSign in.\n"},
+		{name: "text", raw: "Please check the message below:\nFrom: <alerts@example.org>\n"},
+		{name: "mbox separator", raw: "From alerts@example.org Mon Oct  5 10:00:02 2026\nSubject: Account locked\n"},
+		{name: "continuation first", raw: "  by mx.example.net\nFrom: <alerts@example.org>\n"},
+		{name: "empty", raw: "\r\n \r\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := HeaderBlock([]byte(tt.raw))
+			if ok != tt.wantOK || string(got) != tt.want {
+				t.Errorf("HeaderBlock() = %q, %v, want %q, %v", got, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestHeaderBlock_Parse(t *testing.T) {
+	header, ok := HeaderBlock([]byte("  Received: from mx.example.org\n      by mx.example.net\n  From: Alerts <alerts@example.org>\n  Subject: Account locked\n"))
+	if !ok {
+		t.Fatal("HeaderBlock() = false, want true")
+	}
+	parsed, err := Parse(strings.NewReader(string(header)))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if parsed.Subject != "Account locked" || len(parsed.From) != 1 || parsed.From[0].Address != "alerts@example.org" || parsed.Body != "" {
+		t.Errorf("Parse() = %+v", parsed)
+	}
+	if got := parsed.Header.Values("Received"); len(got) != 1 || strings.Join(strings.Fields(got[0]), " ") != "from mx.example.org by mx.example.net" {
+		t.Errorf("Received = %q", got)
+	}
+}
@@ -0,0 +1,379 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Replacements of what Sanitize masks, which keep addresses and header fields valid.
+const (
+	maskedLocalPart = "recipient"
+	maskedName      = "Recipient"
+	maskedText      = "[redacted]"
+)
+
+// minMaskedLength is the length of the shortest name or local part that is masked in
+// text, so that very short names do not mask unrelated words.
+const minMaskedLength = 3
+
+// recipientFields are the header fields with the addresses of the recipients.
+var recipientFields = []string{
+	"To", "Cc", "Bcc", "Resent-To", "Resent-Cc", "Resent-Bcc",
+	"Delivered-To", "X-Original-To", "Envelope-To", "X-Envelope-To",
+}
+
+// receivedFor matches the recipient in the "for" clause of a Received field.
+var receivedFor = regexp.MustCompile(`(?i)\bfor\s+<?([^\s<>;@]+@[^\s<>;]+?)>?(?:;|\s|$)`)
+
+// accountNumber matches IBANs, card numbers in groups of four digits, and runs of eight
+// or more digits, such as account and customer numbers.
+var accountNumber = regexp.MustCompile(`\b(?:[A-Z]{2}\d{2}(?: ?[A-Z0-9]{4}){3,7}(?: ?[A-Z0-9]{1,3})?|\d{4}(?:[ -]\d{4}){2,3}(?:[ -]\d{1,4})?|\d{8,})\b`)
+
+// sanitizedURL matches the URLs that Sanitize leaves as they are.
+var sanitizedURL = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s"'<>]+`)
+
+// Sanitize returns a copy of the message raw that can be shared outside the organization,
+// such as with a vendor or in a public bug report. The addresses of the recipients are
+// masked except for their domain (recipient@example.com), their names are replaced with
+// "Recipient" in the header and the text, and so are extra strings, such as the names of
+// colleagues, with "[redacted]". Account and card numbers in the subject and the text are
+// replaced with Xs. The structure of the message, the other header fields, the URLs and
+// the attachments that are not text are kept: text parts are decoded and encoded again
+// only if something in them was masked, and the rest of the message is left byte for
+// byte.
+func Sanitize(raw []byte, extra []string) []byte {
+	s := &sanitizer{newline: "\n", replacements: map[string]string{}}
+	if i := bytes.IndexByte(raw, '\n'); i > 0 && raw[i-1] == '\r' {
+		s.newline = "\r\n"
+	}
+	header, _ := splitHeader(raw)
+	s.addRecipients(header)
+	for _, e := range extra {
+		s.add(e, maskedText)
+	}
+	s.compile()
+	return s.entity(raw, 0)
+}
+
+// sanitizer masks the details of the recipients of a message.
+type sanitizer struct {
+	newline string
+	// replacements are the replacements of the strings to mask, in lower case.
+	replacements map[string]string
+	tokens       *regexp.Regexp
+	// parts is the number of parts rewritten so far, which is bounded like in Parse.
+	parts int
+}
+
+// addRecipients adds the addresses and names of the recipients in header to the strings
+// to mask.
+func (s *sanitizer) addRecipients(header []byte) {
+	fields, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(slices.Clip(header), s.newline+s.newline...)))).ReadMIMEHeader()
+	parser := &mail.AddressParser{WordDecoder: wordDecoder}
+	for _, name := range recipientFields {
+		for _, value := range fields.Values(name) {
+			addrs, err := parser.ParseList(value)
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				s.add(addr.Name, maskedName)
+				for _, word := range strings.Fields(addr.Name) {
+					s.add(strings.Trim(word, `",.'`), maskedName)
+				}
+				s.addAddress(addr.Address)
+			}
+		}
+	}
+	for _, value := range fields.Values("Received") {
+		for _, m := range receivedFor.FindAllStringSubmatch(value, -1) {
+			s.addAddress(m[1])
+		}
+	}
+}
+
+func (s *sanitizer) addAddress(address string) {
+	local, domain, ok := strings.Cut(address, "@")
+	if !ok {
+		return
+	}
+	s.add(address, maskedLocalPart+"@"+domain)
+	s.add(local, maskedLocalPart)
+}
+
+func (s *sanitizer) add(token, replacement string) {
+	if token = strings.TrimSpace(token); utf8.RuneCountInString(token) >= minMaskedLength {
+		if _, ok := s.replacements[strings.ToLower(token)]; !ok {
+			s.replacements[strings.ToLower(token)] = replacement
+		}
+	}
+}
+
+// compile builds the regexp of the strings to mask, longest first.
+func (s *sanitizer) compile() {
+	var quoted []string
+	for token := range s.replacements {
+		quoted = append(quoted, regexp.QuoteMeta(token))
+	}
+	if len(quoted) == 0 {
+		return
+	}
+	sort.Slice(quoted, func(i, j int) bool {
+		if len(quoted[i]) != len(quoted[j]) {
+			return len(quoted[i]) > len(quoted[j])
+		}
+		return quoted[i] < quoted[j]
+	})
+	s.tokens = regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
+
+// mask returns text with the strings of s masked, and its account numbers too if numbers
+// is set, outside of its URLs.
+func (s *sanitizer) mask(text string, numbers bool) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range sanitizedURL.FindAllStringIndex(text, -1) {
+		b.WriteString(s.maskSegment(text[last:loc[0]], numbers))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(s.maskSegment(text[last:], numbers))
+	return b.String()
+}
+
+func (s *sanitizer) maskSegment(text string, numbers bool) string {
+	if s.tokens != nil {
+		var b strings.Builder
+		last := 0
+		for _, loc := range s.tokens.FindAllStringIndex(text, -1) {
+			// Only whole words are masked: "ann" is not masked in "annual".
+			before, _ := utf8.DecodeLastRuneInString(text[:loc[0]])
+			after, _ := utf8.DecodeRuneInString(text[loc[1]:])
+			if isWordRune(before) || isWordRune(after) {
+				continue
+			}
+			b.WriteString(text[last:loc[0]])
+			b.WriteString(s.replacements[strings.ToLower(text[loc[0]:loc[1]])])
+			last = loc[1]
+		}
+		b.WriteString(text[last:])
+		text = b.String()
+	}
+	if numbers {
+		text = accountNumber.ReplaceAllStringFunc(text, func(n string) string {
+			return strings.Map(func(r rune) rune {
+				if r >= '0' && r <= '9' {
+					return 'X'
+				}
+				return r
+			}, n)
+		})
+	}
+	return text
+}
+
+func isWordRune(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// entity returns the entity raw, a message or a part, with its header and text masked.
+func (s *sanitizer) entity(raw []byte, depth int) []byte {
+	header, body := splitHeader(raw)
+	fields, _ := textproto.NewReader(bufio.NewReader(bytes.NewReader(append(slices.Clip(header), s.newline+s.newline...)))).ReadMIMEHeader()
+	var out bytes.Buffer
+	out.Write(s.header(header))
+	out.Write(raw[len(header) : len(raw)-len(body)])
+
+	mediaType, params, err := mime.ParseMediaType(fields.Get("Content-Type"))
+	if err != nil {
+		mediaType = "text/plain"
+	}
+	encoding := strings.ToLower(strings.TrimSpace(fields.Get("Content-Transfer-Encoding")))
+	switch {
+	case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" && depth < maxPartDepth:
+		out.Write(s.multipart(body, params["boundary"], depth+1))
+	case mediaType == "message/rfc822" && encoding != "base64" && encoding != "quoted-printable" && depth < maxPartDepth:
+		out.Write(s.entity(body, depth+1))
+	case strings.HasPrefix(mediaType, "text/"):
+		out.Write(s.text(body, encoding))
+	default:
+		out.Write(body)
+	}
+	return out.Bytes()
+}
+
+// header returns the header section with the recipients masked in its fields, and the
+// account numbers in its subject.
+func (s *sanitizer) header(header []byte) []byte {
+	var out bytes.Buffer
+	parser := &mail.AddressParser{WordDecoder: wordDecoder}
+	for len(header) > 0 {
+		// A field ends before the next line that does not start with a space or a tab.
+		end := 0
+		for {
+			i := bytes.IndexByte(header[end:], '\n')
+			if i < 0 {
+				end = len(header)
+				break
+			}
+			end += i + 1
+			if end == len(header) || header[end] != ' ' && header[end] != '\t' {
+				break
+			}
+		}
+		field := header[:end]
+		header = header[end:]
+
+		name, value, ok := strings.Cut(string(field), ":")
+		value = strings.TrimSpace(strings.NewReplacer("\r\n", "", "\n", "").Replace(value))
+		switch {
+		case !ok || strings.EqualFold(name, "Content-Type"):
+			// The boundary of the parts must not change.
+			out.Write(field)
+		case slices.ContainsFunc(recipientFields, func(f string) bool { return strings.EqualFold(f, name) }):
+			addrs, err := parser.ParseList(value)
+			if err != nil {
+				out.WriteString(s.mask(string(field), false))
+				continue
+			}
+			var masked []string
+			for _, addr := range addrs {
+				_, domain, _ := strings.Cut(addr.Address, "@")
+				a := &mail.Address{Address: maskedLocalPart + "@" + domain}
+				if addr.Name != "" {
+					a.Name = maskedName
+				}
+				masked = append(masked, a.String())
+			}
+			out.WriteString(foldHeader(name+": "+strings.Join(masked, ", "), s.newline) + s.newline)
+		case strings.EqualFold(name, "Subject"):
+			decoded := decodeText(value)
+			if masked := s.mask(decoded, true); masked != decoded {
+				out.WriteString(foldHeader(name+": "+encodeHeaderValue(masked), s.newline) + s.newline)
+			} else {
+				out.Write(field)
+			}
+		default:
+			out.WriteString(s.mask(string(field), false))
+		}
+	}
+	return out.Bytes()
+}
+
+// multipart returns the body of a multipart entity with its parts masked, keeping its
+// preamble, epilogue and delimiters.
+func (s *sanitizer) multipart(body []byte, boundary string, depth int) []byte {
+	delimiter := []byte("--" + boundary)
+	var out bytes.Buffer
+	start := -1 // The offset of the current part, or -1 in the preamble.
+	for offset := 0; offset < len(body); {
+		end := bytes.IndexByte(body[offset:], '\n') + 1
+		if end == 0 {
+			end = len(body) - offset
+		}
+		line := body[offset : offset+end]
+		trimmed := bytes.TrimRight(line, " \t\r\n")
+		closing := bytes.Equal(trimmed, append(slices.Clip(delimiter), "--"...))
+		if closing || bytes.Equal(trimmed, delimiter) {
+			if start < 0 {
+				out.Write(body[:offset])
+			} else {
+				out.Write(s.part(body[start:offset], depth))
+			}
+			out.Write(line)
+			if closing {
+				out.Write(body[offset+end:])
+				return out.Bytes()
+			}
+			start = offset + end
+		}
+		offset += end
+	}
+	if start < 0 {
+		return body
+	}
+	out.Write(s.part(body[start:], depth))
+	return out.Bytes()
+}
+
+// part returns a part of a multipart body masked, unless there are too many parts. The
+// line break before the next delimiter belongs to the delimiter, and is kept.
+func (s *sanitizer) part(raw []byte, depth int) []byte {
+	s.parts++
+	if s.parts > maxParts {
+		return raw
+	}
+	content := bytes.TrimSuffix(bytes.TrimSuffix(raw, []byte("\n")), []byte("\r"))
+	return append(s.entity(content, depth), raw[len(content):]...)
+}
+
+// text returns the body of a text entity with its text masked, in its transfer encoding.
+func (s *sanitizer) text(body []byte, encoding string) []byte {
+	var decoded []byte
+	var err error
+	switch encoding {
+	case "base64":
+		decoded, err = io.ReadAll(base64.NewDecoder(base64.StdEncoding, bytes.NewReader(bytes.Join(bytes.Fields(body), nil))))
+	case "quoted-printable":
+		decoded, err = io.ReadAll(quotedprintable.NewReader(bytes.NewReader(body)))
+	default:
+		decoded = body
+	}
+	if err != nil {
+		return body
+	}
+	masked := s.mask(string(decoded), true)
+	if masked == string(decoded) {
+		return body
+	}
+
+	var out bytes.Buffer
+	switch encoding {
+	case "base64":
+		encoded := base64.StdEncoding.EncodeToString([]byte(masked))
+		for len(encoded) > 76 {
+			out.WriteString(encoded[:76] + s.newline)
+			encoded = encoded[76:]
+		}
+		out.WriteString(encoded)
+		out.Write(body[len(bytes.TrimRight(body, "\r\n")):])
+	case "quoted-printable":
+		w := quotedprintable.NewWriter(&out)
+		io.WriteString(w, masked)
+		w.Close()
+		if s.newline == "\n" {
+			return bytes.ReplaceAll(out.Bytes(), []byte("\r\n"), []byte("\n"))
+		}
+	default:
+		out.WriteString(masked)
+	}
+	return out.Bytes()
+}
+
+// splitHeader returns the header section of the entity raw, up to the end of its last
+// field, and its body, after the blank line.
+func splitHeader(raw []byte) (header, body []byte) {
+	for offset := 0; offset < len(raw); {
+		end := bytes.IndexByte(raw[offset:], '\n') + 1
+		if end == 0 {
+			return raw, nil
+		}
+		if len(bytes.TrimRight(raw[offset:offset+end], "\r\n")) == 0 {
+			return raw[:offset], raw[offset+end:]
+		}
+		offset += end
+	}
+	return raw, nil
+}
@@ -0,0 +1,65 @@
+package email
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name  string
+		raw   string
+		extra []string
+		want  string
+	}{
+		{
+			name: "Recipients, names and account numbers",
+			raw: "Received: from mx.evil.example by mx.corp.example for <alice.smith@corp.example>; Tue, 1 Jul 2025 12:00:00 +0000\n" +
+				"From: Bank <alerts@evil.example>\n" +
+				"To: \"Smith, Alice\" <alice.smith@corp.example>, bob@corp.example\n" +
+				"Subject: Account 12345678 suspended\n" +
+				"Message-ID: <20250701120000.1234@evil.example>\n\n" +
+				"Dear Alice Smith,\n\nYour card 4111 1111 1111 1111 and account DE89 3704 0044 0532 0130 00 are locked.\n" +
+				"Verify at https://evil.example/verify?user=alice.smith@corp.example&id=12345678 before 2025-07-02.\n" +
+				"Forward this to bob@corp.example or Carol, not to malice@evil.example.\n",
+			extra: []string{"Carol"},
+			want: "Received: from mx.evil.example by mx.corp.example for <recipient@corp.example>; Tue, 1 Jul 2025 12:00:00 +0000\n" +
+				"From: Bank <alerts@evil.example>\n" +
+				"To: \"Recipient\" <recipient@corp.example>, <recipient@corp.example>\n" +
+				"Subject: Account XXXXXXXX suspended\n" +
+				"Message-ID: <20250701120000.1234@evil.example>\n\n" +
+				"Dear Recipient Recipient,\n\nYour card XXXX XXXX XXXX XXXX and account DEXX XXXX XXXX XXXX XXXX XX are locked.\n" +
+				"Verify at https://evil.example/verify?user=alice.smith@corp.example&id=12345678 before 2025-07-02.\n" +
+				"Forward this to recipient@corp.example or [redacted], not to malice@evil.example.\n",
+		},
+		{
+			name: "Nothing to mask",
+			raw:  "From: a@example.com\r\nSubject: Hi\r\n\r\nBody\r\n",
+			want: "From: a@example.com\r\nSubject: Hi\r\n\r\nBody\r\n",
+		},
+		{
+			name: "Encoded parts keep their encoding and the rest of the structure",
+			raw: "To: =?utf-8?q?J=C3=BCrgen_M=C3=BCller?= <jm@corp.example>\r\n" +
+				"Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+				"Preamble\r\n" +
+				"--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nHallo J=C3=BCrgen, Konto 98765432.\r\n" +
+				"--b\r\nContent-Type: text/html\r\nContent-Transfer-Encoding: base64\r\n\r\n" + "PHA+SGkgSsO8cmdlbjwvcD4=" + "\r\n" +
+				"--b\r\nContent-Type: application/pdf; name=\"a.pdf\"\r\nContent-Transfer-Encoding: base64\r\n\r\nMTIzNDU2Nzg5MA==\r\n" +
+				"--b--\r\nEpilogue\r\n",
+			want: "To: \"Recipient\" <recipient@corp.example>\r\n" +
+				"Content-Type: multipart/mixed; boundary=\"b\"\r\n\r\n" +
+				"Preamble\r\n" +
+				"--b\r\nContent-Type: text/plain; charset=utf-8\r\nContent-Transfer-Encoding: quoted-printable\r\n\r\nHallo Recipient, Konto XXXXXXXX.\r\n" +
+				"--b\r\nContent-Type: text/html\r\nContent-Transfer-Encoding: base64\r\n\r\n" + "PHA+SGkgUmVjaXBpZW50PC9wPg==" + "\r\n" +
+				"--b\r\nContent-Type: application/pdf; name=\"a.pdf\"\r\nContent-Transfer-Encoding: base64\r\n\r\nMTIzNDU2Nzg5MA==\r\n" +
+				"--b--\r\nEpilogue\r\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := string(Sanitize([]byte(tt.raw), tt.extra)); got != tt.want {
+				t.Errorf("Sanitize() =\n%s\nwant\n%s", strings.ReplaceAll(got, "\r", `\r`), strings.ReplaceAll(tt.want, "\r", `\r`))
+			}
+		})
+	}
+}
@@ -0,0 +1,96 @@
+package email
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"regexp"
+)
+
+// fromLine matches the "From " line that starts a message in an mbox file, which has the
+// sender and the date, such as "From alice@example.com Mon Jul  7 10:00:00 2025". The time
+// of day tells it from a body line that starts with "From " but was not escaped.
+var fromLine = regexp.MustCompile(`^From \S+ .*\d:\d\d`)
+
+// Splitter reads the messages of a stream of concatenated messages.
+type Splitter struct {
+	r *bufio.Reader
+	// separator is the line between two messages, or nil for mbox "From " lines.
+	separator []byte
+	// afterBlank is whether the last line read was blank, or nothing was read yet.
+	afterBlank bool
+	// inMbox is whether a "From " line was read, after which ">From " lines are unescaped.
+	inMbox bool
+}
+
+// NewSplitter returns a Splitter that reads the messages of r, separated by lines equal to
+// separator, or, if separator is empty, in mbox format: every message starts with a "From "
+// line with the sender and date, at the start of the input or after a blank line, which is
+// left out with that blank line, and ">From " lines in the messages are unescaped. Input
+// before the first "From " line is a message too, so that a single message is read as is.
+func NewSplitter(r io.Reader, separator string) *Splitter {
+	s := &Splitter{r: bufio.NewReader(r), afterBlank: true}
+	if separator != "" {
+		s.separator = []byte(separator)
+	}
+	return s
+}
+
+// Next returns the next message, or io.EOF once there are none left. Empty messages are
+// skipped.
+func (s *Splitter) Next() ([]byte, error) {
+	var msg []byte
+	for {
+		line, err := s.r.ReadBytes('\n')
+		if len(line) > 0 {
+			if s.isSeparator(line) {
+				s.afterBlank = false
+				if msg = s.trim(msg); msg != nil {
+					return msg, nil
+				}
+				continue
+			}
+			s.afterBlank = len(bytes.TrimRight(line, "\r\n")) == 0
+			if s.inMbox && line[0] == '>' && bytes.HasPrefix(bytes.TrimLeft(line, ">"), []byte("From ")) {
+				line = line[1:]
+			}
+			msg = append(msg, line...)
+		}
+		if err == io.EOF {
+			if msg = s.trim(msg); msg != nil {
+				return msg, nil
+			}
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+func (s *Splitter) isSeparator(line []byte) bool {
+	if s.separator != nil {
+		return bytes.Equal(bytes.TrimRight(line, "\r\n"), s.separator)
+	}
+	if s.afterBlank && fromLine.Match(line) {
+		s.inMbox = true
+		return true
+	}
+	return false
+}
+
+// trim removes the blank line that precedes an mbox "From " line from msg, and returns nil
+// if msg is empty.
+func (s *Splitter) trim(msg []byte) []byte {
+	if len(bytes.TrimSpace(msg)) == 0 {
+		return nil
+	}
+	if s.separator == nil && s.inMbox {
+		for _, blank := range []string{"\r\n\r\n", "\n\n"} {
+			if bytes.HasSuffix(msg, []byte(blank)) {
+				return msg[:len(msg)-len(blank)/2]
+			}
+		}
+	}
+	return msg
+}
@@ -0,0 +1,67 @@
+package email
+
+import (
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitter(t *testing.T) {
+	tests := []struct {
+		name      string
+		input     string
+		separator string
+		want      []string
+	}{
+		{
+			name: "mbox",
+			input: "From alice@example.com Mon Jul  7 10:00:00 2025\nSubject: One\n\nHello\n>From the team\n>>From here\n\n" +
+				"From bob@example.com Mon Jul  7 11:00:00 2025\nSubject: Two\n\nFrom now on, reply here.\n",
+			want: []string{
+				"Subject: One\n\nHello\nFrom the team\n>From here\n",
+				"Subject: Two\n\nFrom now on, reply here.\n",
+			},
+		},
+		{
+			name:  "mbox with CRLF line endings",
+			input: "From a Mon Jul  7 10:00:00 2025\r\nSubject: One\r\n\r\nHello\r\n\r\nFrom b Mon Jul  7 11:00:00 2025\r\nSubject: Two\r\n\r\nBye\r\n",
+			want:  []string{"Subject: One\r\n\r\nHello\r\n", "Subject: Two\r\n\r\nBye\r\n"},
+		},
+		{
+			name:  "single message without a From line",
+			input: "Subject: One\n\n>From is left alone\n",
+			want:  []string{"Subject: One\n\n>From is left alone\n"},
+		},
+		{
+			name:      "separator line",
+			input:     "%%\nSubject: One\n\nHello\n%%\r\n%%\nSubject: Two\n\nBye",
+			separator: "%%",
+			want:      []string{"Subject: One\n\nHello\n", "Subject: Two\n\nBye"},
+		},
+		{
+			name:  "empty input",
+			input: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSplitter(strings.NewReader(tt.input), tt.separator)
+			var got []string
+			for {
+				msg, err := s.Next()
+				if errors.Is(err, io.EOF) {
+					break
+				}
+				if err != nil {
+					t.Fatalf("Next() error = %v", err)
+				}
+				got = append(got, string(msg))
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("messages = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
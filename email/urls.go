@@ -0,0 +1,134 @@
+package email
+
+import "bytes"
+
+// The scanners of this file find URLs and tags in text in a single pass, without
+// regular expressions or copies of the text, since they run over every byte of every
+// text part of a message.
+
+// urlTrailer is the punctuation that ends a sentence or a parenthesis after a URL more
+// often than it ends the URL, and is not taken as part of it.
+const urlTrailer = ",.?!;)"
+
+// isURLByte reports whether c can be part of a URL found in text: anything but white
+// space, quotes and angle brackets, which delimit URLs in text and in HTML.
+func isURLByte(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\f', '\r', '"', '<', '>':
+		return false
+	}
+	return true
+}
+
+// isSpace reports whether c is white space in HTML.
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+// schemeLength returns the length of the http:// or https:// prefix of text, or 0.
+func schemeLength(text []byte) int {
+	switch {
+	case bytes.HasPrefix(text, []byte("http://")):
+		return len("http://")
+	case bytes.HasPrefix(text, []byte("https://")):
+		return len("https://")
+	}
+	return 0
+}
+
+// appendURLs appends the http and https URLs in text to dst, without the punctuation
+// that follows them.
+func appendURLs(dst []string, text []byte) []string {
+	for {
+		i := bytes.Index(text, []byte("http"))
+		if i < 0 {
+			return dst
+		}
+		text = text[i:]
+		n := schemeLength(text)
+		if n == 0 {
+			text = text[len("http"):]
+			continue
+		}
+		end := n
+		for end < len(text) && isURLByte(text[end]) {
+			end++
+		}
+		url := bytes.TrimRight(text[:end], urlTrailer)
+		if len(url) > n {
+			dst = append(dst, string(url))
+			text = text[len(url):]
+		} else {
+			text = text[len("http"):]
+		}
+	}
+}
+
+// appendHrefs appends the http and https URLs of the quoted href attributes in html to
+// dst.
+func appendHrefs(dst []string, html []byte) []string {
+	for {
+		i := bytes.Index(html, []byte("href"))
+		if i < 0 {
+			return dst
+		}
+		html = html[i+len("href"):]
+		j := 0
+		for j < len(html) && isSpace(html[j]) {
+			j++
+		}
+		if j == len(html) || html[j] != '=' {
+			continue
+		}
+		j++
+		for j < len(html) && isSpace(html[j]) {
+			j++
+		}
+		if j == len(html) || html[j] != '"' && html[j] != '\'' {
+			continue
+		}
+		quote := html[j]
+		value := html[j+1:]
+		end := bytes.IndexByte(value, quote)
+		if end < 0 {
+			continue
+		}
+		value = value[:end]
+		if n := schemeLength(value); n > 0 && len(value) > n && bytes.IndexByte(value, '"') < 0 {
+			dst = append(dst, string(value))
+			html = html[j+1+end+1:]
+		}
+	}
+}
+
+// splitTags calls f with the text and the tags of html, in order. A tag ends at the
+// first '>' after its '<', on the same line; a '<' without one is part of the text.
+func splitTags(html []byte, f func(segment []byte, tag bool)) {
+	for len(html) > 0 {
+		i := bytes.IndexByte(html, '<')
+		if i < 0 {
+			f(html, false)
+			return
+		}
+		end := bytes.IndexByte(html[i:], '>')
+		if end < 0 {
+			end = len(html) - i
+		}
+		if nl := bytes.IndexByte(html[i:i+end], '\n'); nl >= 0 || end == len(html)-i {
+			// Not a tag: the text up to the end of the line has no tags either.
+			if nl >= 0 {
+				end = nl
+			} else {
+				end = len(html) - i - 1
+			}
+			f(html[:i+end+1], false)
+			html = html[i+end+1:]
+			continue
+		}
+		if i > 0 {
+			f(html[:i], false)
+		}
+		f(html[i:i+end+1], true)
+		html = html[i+end+1:]
+	}
+}
@@ -0,0 +1,127 @@
+package email
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestAppendURLs(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"See https://example.com/a?b=c.", []string{"https://example.com/a?b=c"}},
+		{"(http://example.com/x), and https://example.org!", []string{"http://example.com/x", "https://example.org"}},
+		{`<a href="https://example.com/q">https://example.com/q</a>`, []string{"https://example.com/q", "https://example.com/q"}},
+		{"http://example.com/path\tnext", []string{"http://example.com/path"}},
+		{"httphttps://example.com httpx://example.com", []string{"https://example.com"}},
+		{"http://, https://... ftp://example.com", nil},
+		{"ends with http", nil},
+	}
+	for _, tt := range tests {
+		if got := appendURLs(nil, []byte(tt.text)); !slices.Equal(got, tt.want) {
+			t.Errorf("appendURLs(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestAppendHrefs(t *testing.T) {
+	tests := []struct {
+		html string
+		want []string
+	}{
+		{`<a href="https://example.com/a b">x</a>`, []string{"https://example.com/a b"}},
+		{`<a class=x href = 'http://example.com/?q="1"'>`, nil},
+		{`<a href='http://example.com/'><a href="mailto:a@example.com"><a href="https://">`, []string{"http://example.com/"}},
+		{`<a href=https://example.com/>`, nil},
+		{`<a href="https://example.com/`, nil},
+	}
+	for _, tt := range tests {
+		if got := appendHrefs(nil, []byte(tt.html)); !slices.Equal(got, tt.want) {
+			t.Errorf("appendHrefs(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+// stripTags returns html with each tag replaced by a space.
+func stripTags(html string) string {
+	var b strings.Builder
+	splitTags([]byte(html), func(segment []byte, tag bool) {
+		if tag {
+			b.WriteByte(' ')
+		} else {
+			b.Write(segment)
+		}
+	})
+	return b.String()
+}
+
+func TestSplitTags(t *testing.T) {
+	tests := []struct{ html, want string }{
+		{"<p>Hello <b>world</b></p>", " Hello  world  "},
+		{"1 < 2 and 3 > 2", "1   2"},
+		{"1 < 2\n<br>3 > 2", "1 < 2\n 3 > 2"},
+		{"a <", "a <"},
+	}
+	for _, tt := range tests {
+		if got := stripTags(tt.html); got != tt.want {
+			t.Errorf("splitTags(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+// FuzzScanners checks that the scanners find what the regular expressions that they
+// replaced found.
+func FuzzScanners(f *testing.F) {
+	urlRegex := regexp.MustCompile(`https?://[^\s"<>]*[^\s"<>,.?!;)]`)
+	tagRegex := regexp.MustCompile(`<.*?>`)
+	f.Add("<p>See https://example.com/a?b=c. <a href=\"http://x\">x</a></p>")
+	f.Add("1 < 2\nhttp://,https://a)\f<b\n>")
+	f.Fuzz(func(t *testing.T, text string) {
+		if got, want := appendURLs(nil, []byte(text)), urlRegex.FindAllString(text, -1); !slices.Equal(got, want) {
+			t.Errorf("appendURLs(%q) = %q, want %q", text, got, want)
+		}
+		if got, want := stripTags(text), tagRegex.ReplaceAllString(text, " "); got != want {
+			t.Errorf("splitTags(%q) = %q, want %q", text, got, want)
+		}
+	})
+}
+
+// benchmarkMessage returns a multipart message with a text and an HTML part of about
+// size bytes each, with a link every few lines, like a newsletter.
+func benchmarkMessage(size int) string {
+	var text, html strings.Builder
+	for i := 0; text.Len() < size; i++ {
+		fmt.Fprintf(&text, "Item %d of the newsletter, see https://news.example.com/items/%d?ref=mail, or reply.\r\n", i, i)
+		fmt.Fprintf(&html, "<p class=\"item\">Item %d of the <b>newsletter</b>, <a href=\"https://news.example.com/items/%d?ref=mail\">read more</a>.</p>\r\n", i, i)
+	}
+	return "From: news@example.com\r\nSubject: News\r\nContent-Type: multipart/alternative; boundary=b\r\n\r\n" +
+		"--b\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n" + text.String() +
+		"--b\r\nContent-Type: text/html; charset=utf-8\r\n\r\n" + html.String() +
+		"--b--\r\n"
+}
+
+func BenchmarkParse(b *testing.B) {
+	raw := benchmarkMessage(256 * 1024)
+	b.SetBytes(int64(len(raw)))
+	b.ReportAllocs()
+	for b.Loop() {
+		if _, err := Parse(strings.NewReader(raw)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkScanText(b *testing.B) {
+	raw := benchmarkMessage(256 * 1024)
+	_, html, _ := strings.Cut(raw, "Content-Type: text/html; charset=utf-8\r\n\r\n")
+	b.SetBytes(int64(len(html)))
+	b.ReportAllocs()
+	for b.Loop() {
+		var x extraction
+		x.scanText(strings.NewReader(html), true)
+	}
+}
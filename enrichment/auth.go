@@ -0,0 +1,177 @@
+package enrichment
+
+import (
+	"context"
+	"slices"
+	"strings"
+
+	"mail-analyzer/email"
+)
+
+// Auth reports the SPF, DKIM, DMARC and other authentication results that the receiving
+// mail server recorded in the Authentication-Results header (RFC 8601). Senders can add
+// such headers too, so only the topmost header, or the first of a trusted server, is used.
+type Auth struct {
+	// TrustedIDs are the authserv-ids of the headers to trust. If empty, the topmost
+	// header is trusted.
+	TrustedIDs []string
+}
+
+// Name implements Enricher.
+func (a *Auth) Name() string { return NameAuth }
+
+// Enrich implements Enricher.
+func (a *Auth) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	id, results, ok := a.trusted(e)
+	if !ok {
+		return nil, nil
+	}
+	r := &Result{Title: "Authentication Results (" + id + ")"}
+	for _, res := range results {
+		r.Signals = append(r.Signals, Signal{Name: res.method, Target: res.target(), Value: res.result})
+	}
+	return r, nil
+}
+
+// DMARCPass reports whether the trusted Authentication-Results header of e has a
+// dmarc=pass result for domain, which is then the domain of the From address that the
+// receiving server checked, aligned with the SPF or DKIM domain.
+func (a *Auth) DMARCPass(e *email.ParsedEmail, domain string) bool {
+	_, results, ok := a.trusted(e)
+	return ok && slices.ContainsFunc(results, func(r authResult) bool {
+		return r.method == "dmarc" && r.result == "pass" && domain != "" && strings.EqualFold(r.properties["header.from"], domain)
+	})
+}
+
+// trusted returns the authserv-id and the results of the Authentication-Results header
+// of e to trust, if there is one.
+func (a *Auth) trusted(e *email.ParsedEmail) (string, []authResult, bool) {
+	for _, value := range e.Header.Values("Authentication-Results") {
+		id, results := parseAuthResults(value)
+		if len(a.TrustedIDs) > 0 && !slices.ContainsFunc(a.TrustedIDs, func(trusted string) bool { return strings.EqualFold(trusted, id) }) {
+			continue
+		}
+		return id, results, true
+	}
+	return "", nil, false
+}
+
+// authResult is a result of an Authentication-Results header, such as
+// "dkim=pass header.d=example.com".
+type authResult struct {
+	method, result string
+	// properties are the ptype.property values of the result, such as "header.d".
+	properties map[string]string
+}
+
+// target returns the domain or address that the result is about.
+func (r authResult) target() string {
+	for _, property := range []string{"header.d", "header.i", "header.from", "smtp.mailfrom", "smtp.helo"} {
+		if value := r.properties[property]; value != "" {
+			return strings.TrimPrefix(value, "@")
+		}
+	}
+	return ""
+}
+
+// parseAuthResults returns the authserv-id and the results of an Authentication-Results
+// header value.
+func parseAuthResults(value string) (string, []authResult) {
+	parts := splitUnquoted(stripComments(value), ';')
+	fields := splitFields(parts[0])
+	if len(fields) == 0 {
+		return "", nil
+	}
+	id := fields[0]
+	var results []authResult
+	for _, part := range parts[1:] {
+		tokens := splitFields(part)
+		if len(tokens) == 0 {
+			continue
+		}
+		method, result, ok := strings.Cut(tokens[0], "=")
+		if !ok {
+			continue // "none", or a malformed result.
+		}
+		method, _, _ = strings.Cut(method, "/") // Drop the version.
+		r := authResult{method: strings.ToLower(method), result: strings.ToLower(result), properties: map[string]string{}}
+		for _, token := range tokens[1:] {
+			if name, value, ok := strings.Cut(token, "="); ok {
+				r.properties[strings.ToLower(name)] = strings.Trim(value, `"`)
+			}
+		}
+		results = append(results, r)
+	}
+	return id, results
+}
+
+// stripComments removes the parenthesized comments of a header value, which may be nested,
+// outside quoted strings.
+func stripComments(value string) string {
+	var b strings.Builder
+	depth, quoted, escaped := 0, false, false
+	for _, c := range value {
+		switch {
+		case escaped:
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case quoted:
+			if c == '"' {
+				quoted = false
+			}
+		case c == '"' && depth == 0:
+			quoted = true
+		case c == '(':
+			depth++
+			continue
+		case c == ')' && depth > 0:
+			depth--
+			continue
+		}
+		if depth == 0 {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// splitUnquoted splits s at sep outside quoted strings.
+func splitUnquoted(s string, sep rune) []string {
+	var parts []string
+	start, quoted := 0, false
+	for i, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == sep && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// splitFields splits s at white space outside quoted strings.
+func splitFields(s string) []string {
+	var fields []string
+	var field strings.Builder
+	quoted := false
+	for _, c := range s {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ' ' || c == '\t' || c == '\r' || c == '\n'):
+			if field.Len() > 0 {
+				fields = append(fields, field.String())
+				field.Reset()
+			}
+			continue
+		}
+		field.WriteRune(c)
+	}
+	if field.Len() > 0 {
+		fields = append(fields, field.String())
+	}
+	return fields
+}
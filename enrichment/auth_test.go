@@ -0,0 +1,105 @@
+package enrichment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestParseAuthResults(t *testing.T) {
+	id, results := parseAuthResults(`mx.google.com;
+       dkim=pass header.i=@example.co.jp header.s=2024 header.b=HWpBplv5;
+       arc=pass (i=1);
+       spf=softfail (google.com: domain of bounce@example.co.jp does not designate 192.0.2.1 as permitted sender; see "spf") smtp.mailfrom=bounce@example.co.jp;
+       dmarc=pass (p=NONE sp=NONE dis=NONE) header.from=example.co.jp;
+       auth/1=pass reason="TLS; verified" smtp.auth=alice`)
+	if id != "mx.google.com" {
+		t.Errorf("authserv-id = %q", id)
+	}
+	var got []string
+	for _, r := range results {
+		got = append(got, r.method+"="+r.result+" "+r.target())
+	}
+	want := []string{
+		"dkim=pass example.co.jp",
+		"arc=pass ",
+		"spf=softfail bounce@example.co.jp",
+		"dmarc=pass example.co.jp",
+		"auth=pass ",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("results = %q, want %q", got, want)
+	}
+	if reason := results[4].properties["reason"]; reason != "TLS; verified" {
+		t.Errorf("reason = %q", reason)
+	}
+
+	if id, results := parseAuthResults("relay01; none"); id != "relay01" || len(results) != 0 {
+		t.Errorf("parseAuthResults(none) = %q, %v", id, results)
+	}
+}
+
+func TestAuth(t *testing.T) {
+	headers := "Authentication-Results: mx.example.net; spf=fail smtp.mailfrom=evil@example.com; dmarc=fail header.from=example.com\n" +
+		"Authentication-Results: forged.example.com; spf=pass smtp.mailfrom=evil@example.com; dmarc=pass header.from=example.com\n" +
+		"From: evil@example.com\nSubject: Hi\n"
+	tests := []struct {
+		name    string
+		trusted []string
+		headers string
+		want    *Result
+	}{
+		{
+			name:    "Topmost header",
+			headers: headers,
+			want: &Result{Title: "Authentication Results (mx.example.net)", Signals: []Signal{
+				{Name: "spf", Target: "evil@example.com", Value: "fail"},
+				{Name: "dmarc", Target: "example.com", Value: "fail"},
+			}},
+		},
+		{
+			name:    "Trusted server",
+			trusted: []string{"Forged.example.com"},
+			headers: headers,
+			want: &Result{Title: "Authentication Results (forged.example.com)", Signals: []Signal{
+				{Name: "spf", Target: "evil@example.com", Value: "pass"},
+				{Name: "dmarc", Target: "example.com", Value: "pass"},
+			}},
+		},
+		{name: "No trusted header", trusted: []string{"mx.example.org"}, headers: headers},
+		{name: "No header", headers: "From: alice@example.com\nSubject: Hi\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := (&Auth{TrustedIDs: tt.trusted}).Enrich(context.Background(), parse(t, tt.headers))
+			if err != nil {
+				t.Fatalf("Enrich() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Enrich() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuth_DMARCPass(t *testing.T) {
+	e := parse(t, "Authentication-Results: mx.example.net; dmarc=pass header.from=Example.com\n"+
+		"Authentication-Results: forged.example.com; dmarc=pass header.from=example.org\n"+
+		"From: alice@example.com\nSubject: Hi\n")
+	tests := []struct {
+		trusted []string
+		domain  string
+		want    bool
+	}{
+		{trusted: []string{"mx.example.net"}, domain: "example.com", want: true},
+		{trusted: []string{"mx.example.net"}, domain: "example.org"},
+		{trusted: []string{"mx.example.net"}, domain: "sub.example.com"},
+		{trusted: []string{"forged.example.com"}, domain: "example.com"},
+		{trusted: []string{"mx.example.org"}, domain: "example.com"},
+	}
+	for _, tt := range tests {
+		if got := (&Auth{TrustedIDs: tt.trusted}).DMARCPass(e, tt.domain); got != tt.want {
+			t.Errorf("DMARCPass(%v, %q) = %v, want %v", tt.trusted, tt.domain, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package enrichment
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Default lifetimes of the answers of a Cache.
+const (
+	DefaultCacheTTL         = 24 * time.Hour
+	DefaultCacheNegativeTTL = time.Hour
+)
+
+// maxMemoryEntries is the number of answers that a Cache keeps in memory. Once it is
+// reached, the memory is cleared, and the answers are read from disk again if it has a
+// directory.
+const maxMemoryEntries = 100000
+
+// Cache keeps the answers of the external lookups of the enrichers, such as DNS queries,
+// so that a batch of messages of the same campaign does not repeat thousands of
+// identical queries. Answers are kept in memory and, if Dir is set, in one file per
+// lookup under Dir, so that they are shared by later runs and by other processes.
+//
+// Answers expire after TTL, or after NegativeTTL if nothing was found, since a domain
+// registered for a campaign may start resolving at any time. Failed lookups are not
+// cached. A Cache is safe for concurrent use, and concurrent lookups of the same key
+// share a single query.
+type Cache struct {
+	Dir         string
+	TTL         time.Duration
+	NegativeTTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	calls   map[string]*cacheCall
+}
+
+// cacheEntry is the on-disk format of an answer.
+type cacheEntry struct {
+	Key     string          `json:"key"`
+	Expires time.Time       `json:"expires"`
+	Found   bool            `json:"found"`
+	Value   json.RawMessage `json:"value,omitempty"`
+}
+
+// cacheCall is a lookup in progress, whose answer is set before done is closed.
+type cacheCall struct {
+	done  chan struct{}
+	entry cacheEntry
+	err   error
+}
+
+// NewCache returns a Cache that keeps answers in dir, or only in memory if dir is "".
+// Lifetimes of 0 are replaced with DefaultCacheTTL and DefaultCacheNegativeTTL.
+func NewCache(dir string, ttl, negativeTTL time.Duration) *Cache {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	if negativeTTL <= 0 {
+		negativeTTL = DefaultCacheNegativeTTL
+	}
+	return &Cache{Dir: dir, TTL: ttl, NegativeTTL: negativeTTL}
+}
+
+// Lookup returns the answer of the lookup kind of key, such as "dns" of a domain, from c
+// if it has one that has not expired, or else from fetch, which also reports whether it
+// found anything. The answer is stored as JSON. With a nil c, Lookup calls fetch.
+func Lookup[T any](ctx context.Context, c *Cache, kind, key string, fetch func(context.Context) (T, bool, error)) (T, error) {
+	var value T
+	if c == nil {
+		value, _, err := fetch(ctx)
+		return value, err
+	}
+	entry, err := c.lookup(ctx, kind+":"+key, func(ctx context.Context) (json.RawMessage, bool, error) {
+		value, found, err := fetch(ctx)
+		if err != nil {
+			return nil, false, err
+		}
+		data, err := json.Marshal(value)
+		return data, found, err
+	})
+	if err != nil {
+		return value, err
+	}
+	if err := json.Unmarshal(entry.Value, &value); err != nil {
+		return value, fmt.Errorf("error decoding the cached answer of %s %s: %w", kind, key, err)
+	}
+	return value, nil
+}
+
+func (c *Cache) lookup(ctx context.Context, key string, fetch func(context.Context) (json.RawMessage, bool, error)) (cacheEntry, error) {
+	c.mu.Lock()
+	if entry, ok := c.entries[key]; ok && time.Now().Before(entry.Expires) {
+		c.mu.Unlock()
+		return entry, nil
+	}
+	if call, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+			return call.entry, call.err
+		case <-ctx.Done():
+			return cacheEntry{}, ctx.Err()
+		}
+	}
+	call := &cacheCall{done: make(chan struct{})}
+	if c.calls == nil {
+		c.calls = map[string]*cacheCall{}
+	}
+	c.calls[key] = call
+	c.mu.Unlock()
+
+	call.entry, call.err = c.load(key)
+	if call.err != nil {
+		call.entry.Value, call.entry.Found, call.err = fetch(ctx)
+		if call.err == nil {
+			call.entry.Key = key
+			call.entry.Expires = time.Now().Add(c.ttl(call.entry.Found))
+			if err := c.store(call.entry); err != nil {
+				log.Printf("Warning: could not cache the answer of %s: %v", key, err)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	delete(c.calls, key)
+	if call.err == nil {
+		if c.entries == nil || len(c.entries) >= maxMemoryEntries {
+			c.entries = map[string]cacheEntry{}
+		}
+		c.entries[key] = call.entry
+	}
+	c.mu.Unlock()
+	close(call.done)
+	return call.entry, call.err
+}
+
+func (c *Cache) ttl(found bool) time.Duration {
+	if found {
+		return c.TTL
+	}
+	return c.NegativeTTL
+}
+
+// errNotCached is returned by load for keys without an answer on disk.
+var errNotCached = errors.New("not cached")
+
+// load returns the answer of key stored on disk. Expired answers are removed.
+func (c *Cache) load(key string) (cacheEntry, error) {
+	if c.Dir == "" {
+		return cacheEntry{}, errNotCached
+	}
+	path := cachePath(c.Dir, key)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return cacheEntry{}, errNotCached
+	}
+	if err != nil {
+		return cacheEntry{}, err
+	}
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Key != key {
+		return cacheEntry{}, errNotCached
+	}
+	if !time.Now().Before(entry.Expires) {
+		os.Remove(path)
+		return cacheEntry{}, errNotCached
+	}
+	return entry, nil
+}
+
+// store writes entry to disk. The file is replaced atomically, since other processes
+// may read it.
+func (c *Cache) store(entry cacheEntry) error {
+	if c.Dir == "" {
+		return nil
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(c.Dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(c.Dir, ".lookup-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), cachePath(c.Dir, entry.Key))
+}
+
+// cachePath returns the file of the answer of key in dir, named after its hash, since
+// keys such as the domains of hostile messages are not safe file names.
+func cachePath(dir, key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(dir, hex.EncodeToString(sum[:])+".json")
+}
+
+// CacheStats describes the answers stored in the directory of a Cache.
+type CacheStats struct {
+	Entries int
+	Expired int
+}
+
+// Stats counts the answers stored in dir, the directory of a Cache.
+func Stats(dir string) (CacheStats, error) {
+	var stats CacheStats
+	now := time.Now()
+	err := walkCache(dir, func(path string, entry cacheEntry) error {
+		stats.Entries++
+		if !now.Before(entry.Expires) {
+			stats.Expired++
+		}
+		return nil
+	})
+	return stats, err
+}
+
+// Clear removes the answers stored in dir, the directory of a Cache. Other files in dir
+// are left alone.
+func Clear(dir string) error {
+	return walkCache(dir, func(path string, entry cacheEntry) error {
+		return os.Remove(path)
+	})
+}
+
+// walkCache calls f with the answers stored in dir.
+func walkCache(dir string, f func(path string, entry cacheEntry) error) error {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		var entry cacheEntry
+		if json.Unmarshal(data, &entry) != nil || cachePath(dir, entry.Key) != file {
+			continue
+		}
+		if err := f(file, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir := t.TempDir()
+	var fetches atomic.Int32
+	fetch := func(value string, found bool, err error) func(context.Context) (string, bool, error) {
+		return func(context.Context) (string, bool, error) {
+			fetches.Add(1)
+			return value, found, err
+		}
+	}
+	c := NewCache(dir, time.Hour, time.Millisecond)
+	ctx := context.Background()
+
+	if _, err := Lookup(ctx, c, "dns", "example.com", fetch("", false, errors.New("timeout"))); err == nil {
+		t.Error("Lookup() of a failing fetch: error = nil")
+	}
+	// Failures are not cached.
+	if got, err := Lookup(ctx, c, "dns", "example.com", fetch("mx.example.com", true, nil)); err != nil || got != "mx.example.com" {
+		t.Errorf("Lookup() = %q, %v", got, err)
+	}
+	if got, err := Lookup(ctx, c, "dns", "example.com", fetch("other", true, nil)); err != nil || got != "mx.example.com" {
+		t.Errorf("Lookup() of a cached answer = %q, %v", got, err)
+	}
+	// Other processes read the answer from disk.
+	if got, err := Lookup(ctx, NewCache(dir, time.Hour, time.Hour), "dns", "example.com", fetch("other", true, nil)); err != nil || got != "mx.example.com" {
+		t.Errorf("Lookup() of an answer on disk = %q, %v", got, err)
+	}
+	if n := fetches.Load(); n != 2 {
+		t.Errorf("fetched %d times, want 2", n)
+	}
+
+	// Answers that found nothing expire after NegativeTTL.
+	Lookup(ctx, c, "dns", "new.example.net", fetch("", false, nil))
+	time.Sleep(2 * time.Millisecond)
+	if got, _ := Lookup(ctx, c, "dns", "new.example.net", fetch("mx.example.net", true, nil)); got != "mx.example.net" {
+		t.Errorf("Lookup() after the negative TTL = %q, want a new answer", got)
+	}
+
+	if stats, err := Stats(dir); err != nil || stats.Entries != 2 {
+		t.Errorf("Stats() = %+v, %v, want 2 entries", stats, err)
+	}
+	if err := Clear(dir); err != nil {
+		t.Fatal(err)
+	}
+	if stats, _ := Stats(dir); stats.Entries != 0 {
+		t.Errorf("Stats() after Clear() = %+v", stats)
+	}
+}
+
+func TestCache_Concurrent(t *testing.T) {
+	c := NewCache("", 0, 0)
+	var fetches atomic.Int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			got, err := Lookup(context.Background(), c, "dns", "example.com", func(context.Context) ([]string, bool, error) {
+				fetches.Add(1)
+				<-release
+				return []string{"mx.example.com"}, true, nil
+			})
+			if err != nil || len(got) != 1 {
+				t.Errorf("Lookup() = %q, %v", got, err)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Errorf("fetched %d times, want 1", n)
+	}
+}
@@ -0,0 +1,114 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"mail-analyzer/email"
+)
+
+// DNS reports whether the domains of the sender, the Reply-To and the envelope sender can
+// receive mail. Domains registered for a campaign often have no MX record, or do not
+// resolve at all.
+type DNS struct {
+	// Resolver is used for the lookups, or net.DefaultResolver if nil.
+	Resolver *net.Resolver
+	// Cache keeps the records of the domains, if not nil.
+	Cache *Cache
+}
+
+// mailRecords are the records of a domain that tell where its mail is delivered.
+type mailRecords struct {
+	MX []string `json:"mx,omitempty"`
+	// Addrs are only looked up for domains without MX records.
+	Addrs []string `json:"addrs,omitempty"`
+}
+
+// Name implements Enricher.
+func (d *DNS) Name() string { return NameDNS }
+
+// Enrich implements Enricher.
+func (d *DNS) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	r := &Result{Title: "DNS Records of the Sender Domains"}
+	for _, domain := range senderDomains(e) {
+		records, err := Lookup(ctx, d.Cache, NameDNS, domain, func(ctx context.Context) (mailRecords, bool, error) {
+			records, err := d.lookup(ctx, domain)
+			return records, len(records.MX) > 0 || len(records.Addrs) > 0, err
+		})
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case len(records.MX) > 0:
+			r.Signals = append(r.Signals, Signal{Name: "mx", Target: domain, Value: strings.Join(records.MX, ", ")})
+		case len(records.Addrs) == 0:
+			r.Signals = append(r.Signals, Signal{Name: "mx", Target: domain, Value: "none",
+				Detail: fmt.Sprintf("The domain %s has no MX record and does not resolve, so it cannot receive mail.", domain)})
+		default:
+			r.Signals = append(r.Signals, Signal{Name: "mx", Target: domain, Value: "none",
+				Detail: fmt.Sprintf("The domain %s has no MX record; it resolves to %s.", domain, strings.Join(records.Addrs, ", "))})
+		}
+	}
+	return r, nil
+}
+
+// lookup queries the mail records of domain.
+func (d *DNS) lookup(ctx context.Context, domain string) (mailRecords, error) {
+	resolver := d.Resolver
+	if resolver == nil {
+		resolver = net.DefaultResolver
+	}
+	var records mailRecords
+	mx, err := resolver.LookupMX(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return records, fmt.Errorf("error looking up the MX records of %s: %w", domain, err)
+	}
+	for _, record := range mx {
+		records.MX = append(records.MX, strings.TrimSuffix(record.Host, "."))
+	}
+	if len(records.MX) > 0 {
+		return records, nil
+	}
+	// Mail is delivered to the address of a domain without MX records (RFC 5321).
+	records.Addrs, err = resolver.LookupHost(ctx, domain)
+	if err != nil && !isNotFound(err) {
+		return records, fmt.Errorf("error looking up the addresses of %s: %w", domain, err)
+	}
+	return records, nil
+}
+
+// senderDomains returns the distinct domains of the From, Reply-To and Return-Path
+// addresses of e.
+func senderDomains(e *email.ParsedEmail) []string {
+	var addresses []string
+	for _, addr := range e.From {
+		addresses = append(addresses, addr.Address)
+	}
+	if replyTo, err := e.Header.AddressList("Reply-To"); err == nil {
+		for _, addr := range replyTo {
+			addresses = append(addresses, addr.Address)
+		}
+	}
+	if returnPath, err := e.Header.Text("Return-Path"); err == nil {
+		addresses = append(addresses, strings.Trim(returnPath, "<> "))
+	}
+	var domains []string
+	seen := map[string]bool{}
+	for _, address := range addresses {
+		_, domain, _ := strings.Cut(address, "@")
+		domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+		if domain != "" && !seen[domain] {
+			seen[domain] = true
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+func isNotFound(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
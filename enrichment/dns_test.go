@@ -0,0 +1,96 @@
+package enrichment
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// newFakeResolver returns a resolver that queries a DNS server answering with the MX and
+// A records of zone, and NXDOMAIN for other names.
+func newFakeResolver(t *testing.T, zone map[string][]dnsmessage.Resource) *net.Resolver {
+	t.Helper()
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var query dnsmessage.Message
+			if query.Unpack(buf[:n]) != nil || len(query.Questions) == 0 {
+				continue
+			}
+			q := query.Questions[0]
+			reply := dnsmessage.Message{
+				Header:    dnsmessage.Header{ID: query.ID, Response: true, Authoritative: true},
+				Questions: query.Questions,
+			}
+			records, ok := zone[strings.ToLower(q.Name.String())]
+			if !ok {
+				reply.RCode = dnsmessage.RCodeNameError
+			}
+			for _, r := range records {
+				if r.Header.Type == q.Type {
+					r.Header.Name, r.Header.Class = q.Name, dnsmessage.ClassINET
+					reply.Answers = append(reply.Answers, r)
+				}
+			}
+			packed, err := reply.Pack()
+			if err == nil {
+				conn.WriteTo(packed, addr)
+			}
+		}
+	}()
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "udp", conn.LocalAddr().String())
+		},
+	}
+}
+
+func TestDNS(t *testing.T) {
+	mx := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeMX},
+		Body:   &dnsmessage.MXResource{Pref: 10, MX: dnsmessage.MustNewName("mx.example.com.")},
+	}
+	a := dnsmessage.Resource{
+		Header: dnsmessage.ResourceHeader{Type: dnsmessage.TypeA},
+		Body:   &dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}},
+	}
+	resolver := newFakeResolver(t, map[string][]dnsmessage.Resource{
+		"example.com.":     {mx},
+		"web.example.net.": {a},
+	})
+
+	e := parse(t, "From: alice@example.com\nReply-To: pay@web.example.net\nReturn-Path: <bounce@gone.example.org>\nSubject: Hi\n")
+	got, err := (&DNS{Resolver: resolver}).Enrich(context.Background(), e)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	want := []Signal{
+		{Name: "mx", Target: "example.com", Value: "mx.example.com"},
+		{Name: "mx", Target: "web.example.net", Value: "none", Detail: "The domain web.example.net has no MX record; it resolves to 192.0.2.1."},
+		{Name: "mx", Target: "gone.example.org", Value: "none", Detail: "The domain gone.example.org has no MX record and does not resolve, so it cannot receive mail."},
+	}
+	if !reflect.DeepEqual(got.Signals, want) {
+		t.Errorf("Enrich() = %+v, want %+v", got.Signals, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := (&DNS{Resolver: resolver}).Enrich(ctx, e); err == nil {
+		t.Error("Enrich() with a canceled context: error = nil")
+	}
+}
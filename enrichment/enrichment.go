@@ -0,0 +1,189 @@
+// Package enrichment gathers facts about a message before it is analyzed, such as the
+// results of its authentication checks or the DNS records of its sender domain. Every
+// enricher reports its facts in the same structure, which is added to the prompt and to
+// the results.
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/org"
+)
+
+// Names of the built-in enrichers.
+const (
+	NameAuth    = "auth"
+	NameDNS     = "dns"
+	NameOrg     = "org"
+	NameHistory = "history"
+	NameRelated = "related"
+	NameForms   = "forms"
+)
+
+// Enricher gathers facts about a message.
+type Enricher interface {
+	// Name identifies the enricher in the configuration and the results.
+	Name() string
+	// Enrich returns the facts about e, or nil if there are none.
+	Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error)
+}
+
+// Result holds the facts found by an enricher.
+type Result struct {
+	Name string `json:"name"`
+	// Title is the heading of the facts in the prompt.
+	Title   string   `json:"-"`
+	Signals []Signal `json:"signals,omitempty"`
+	// Error is set when the enricher failed, in which case the result is left out of the
+	// prompt.
+	Error string `json:"error,omitempty"`
+}
+
+// Signal is a fact about a message, such as "spf" with the value "fail".
+type Signal struct {
+	Name string `json:"name"`
+	// Target is what the signal is about, such as a domain, if the message has several.
+	Target string `json:"target,omitempty"`
+	Value  string `json:"value"`
+	// Detail explains the signal to the model, in place of its name and value.
+	Detail string `json:"detail,omitempty"`
+}
+
+// String returns the line of s in the prompt.
+func (s Signal) String() string {
+	if s.Detail != "" {
+		return s.Detail
+	}
+	if s.Target != "" {
+		return fmt.Sprintf("%s of %s: %s", s.Name, s.Target, s.Value)
+	}
+	return fmt.Sprintf("%s: %s", s.Name, s.Value)
+}
+
+// Pipeline runs enrichers in order.
+type Pipeline []Enricher
+
+// Run returns the results of the enrichers that found facts about e. An enricher that
+// fails does not stop the others, and its result records the error. Once ctx is done,
+// the remaining enrichers are skipped, and the one that was cancelled has no result,
+// since the analysis is abandoned anyway.
+func (p Pipeline) Run(ctx context.Context, e *email.ParsedEmail) []Result {
+	var results []Result
+	for _, enricher := range p {
+		if ctx.Err() != nil {
+			break
+		}
+		r, err := enricher.Enrich(ctx, e)
+		if err != nil && ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			results = append(results, Result{Name: enricher.Name(), Error: err.Error()})
+			continue
+		}
+		if r != nil && len(r.Signals) > 0 {
+			r.Name = enricher.Name()
+			results = append(results, *r)
+		}
+	}
+	return results
+}
+
+// Names returns the names of the enrichers of p.
+func (p Pipeline) Names() []string {
+	var names []string
+	for _, enricher := range p {
+		names = append(names, enricher.Name())
+	}
+	return names
+}
+
+// Select returns the enrichers of p with the given names, in the order of names. It
+// returns an error for a name that is not that of an enricher of p.
+func (p Pipeline) Select(names []string) (Pipeline, error) {
+	selected := Pipeline{}
+	for _, name := range names {
+		i := slices.IndexFunc(p, func(e Enricher) bool { return e.Name() == name })
+		if i < 0 {
+			return nil, fmt.Errorf("unknown or disabled enrichment %q (enabled: %s)", name, strings.Join(p.Names(), ", "))
+		}
+		selected = append(selected, p[i])
+	}
+	return selected, nil
+}
+
+// Prompt returns the prompt sections of results, or "" if there are none.
+func Prompt(results []Result) string {
+	var b strings.Builder
+	for _, r := range results {
+		if r.Error != "" {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "--- %s ---\n", title(r))
+		for _, s := range r.Signals {
+			b.WriteString("- " + s.String() + "\n")
+		}
+	}
+	return b.String()
+}
+
+func title(r Result) string {
+	if r.Title != "" {
+		return r.Title
+	}
+	return "Enrichment: " + r.Name
+}
+
+// FromConfig returns the enrichers of cfg.Enrichments, in order. Without the setting, the
+// authentication results are reported, along with the organization context if there is
+// an org_context_file, and the sender history once the results database is set with
+// Pipeline.SetSenderHistory. The related messages of Pipeline.SetRelatedMessages are only
+// reported when enabled. The enrichers that query external services share a Cache
+// of lookup_cache_dir.
+func FromConfig(cfg *config.Config) (Pipeline, error) {
+	cache := NewCache(cfg.LookupCacheDir, time.Duration(cfg.LookupCacheTTL), time.Duration(cfg.LookupCacheNegativeTTL))
+	names := cfg.Enrichments
+	if names == nil {
+		names = []string{NameAuth, NameForms}
+		if cfg.OrgContextFile != "" {
+			names = append(names, NameOrg)
+		}
+		names = append(names, NameHistory)
+	}
+	var p Pipeline
+	for _, name := range names {
+		switch name {
+		case NameAuth:
+			p = append(p, &Auth{TrustedIDs: cfg.AuthServIDs})
+		case NameDNS:
+			p = append(p, &DNS{Cache: cache})
+		case NameOrg:
+			if cfg.OrgContextFile == "" {
+				return nil, fmt.Errorf("the %s enrichment requires org_context_file", NameOrg)
+			}
+			c, err := org.Load(cfg.OrgContextFile)
+			if err != nil {
+				return nil, fmt.Errorf("error loading the organization context: %w", err)
+			}
+			p = append(p, &Org{Context: c})
+		case NameHistory:
+			p = append(p, &History{})
+		case NameRelated:
+			p = append(p, &Related{})
+		case NameForms:
+			p = append(p, &Forms{})
+		default:
+			return nil, fmt.Errorf("unknown enrichment %q; expected %s, %s, %s, %s, %s or %s", name, NameAuth, NameDNS, NameOrg, NameHistory, NameRelated, NameForms)
+		}
+	}
+	return p, nil
+}
@@ -0,0 +1,155 @@
+package enrichment
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+)
+
+func parse(t *testing.T, headers string) *email.ParsedEmail {
+	t.Helper()
+	e, err := email.Parse(strings.NewReader(strings.ReplaceAll(headers, "\n", "\r\n") + "\r\nHello\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+// staticEnricher returns its result and error.
+type staticEnricher struct {
+	name   string
+	result *Result
+	err    error
+}
+
+func (s staticEnricher) Name() string { return s.name }
+
+func (s staticEnricher) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	return s.result, s.err
+}
+
+func TestPipeline(t *testing.T) {
+	p := Pipeline{
+		staticEnricher{name: "first", result: &Result{Title: "First Facts", Signals: []Signal{{Name: "spf", Value: "pass"}, {Name: "mx", Target: "example.com", Value: "none", Detail: "The domain example.com has no MX record."}}}},
+		staticEnricher{name: "empty", result: &Result{}},
+		staticEnricher{name: "none"},
+		staticEnricher{name: "failing", err: errors.New("timeout")},
+		staticEnricher{name: "last", result: &Result{Signals: []Signal{{Name: "mx", Target: "example.net", Value: "mx.example.net"}}}},
+	}
+	results := p.Run(context.Background(), parse(t, "Subject: Hi\n"))
+	var names []string
+	for _, r := range results {
+		names = append(names, r.Name)
+	}
+	if want := []string{"first", "failing", "last"}; !slices.Equal(names, want) {
+		t.Fatalf("Run() = %+v, want the results of %v", results, want)
+	}
+	if results[1].Error != "timeout" || results[1].Signals != nil {
+		t.Errorf("result of a failing enricher = %+v", results[1])
+	}
+
+	want := "--- First Facts ---\n" +
+		"- spf: pass\n" +
+		"- The domain example.com has no MX record.\n" +
+		"\n" +
+		"--- Enrichment: last ---\n" +
+		"- mx of example.net: mx.example.net\n"
+	if got := Prompt(results); got != want {
+		t.Errorf("Prompt() = %q, want %q", got, want)
+	}
+	if got := Prompt(nil); got != "" {
+		t.Errorf("Prompt(nil) = %q", got)
+	}
+
+	selected, err := p.Select([]string{"last", "first"})
+	if err != nil || !slices.Equal(selected.Names(), []string{"last", "first"}) {
+		t.Errorf("Select(last, first) = %v, %v", selected.Names(), err)
+	}
+	if selected, err := p.Select([]string{}); err != nil || selected == nil || len(selected) != 0 {
+		t.Errorf("Select() = %v, %v, want an empty pipeline", selected, err)
+	}
+	if _, err := p.Select([]string{"first", "whois"}); err == nil || !strings.Contains(err.Error(), `"whois"`) {
+		t.Errorf("Select(whois) error = %v", err)
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	orgFile := filepath.Join(t.TempDir(), "org.yaml")
+	if err := os.WriteFile(orgFile, []byte("internal_domains: [example.com]\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    []string
+		wantErr string
+	}{
+		{name: "Default", want: []string{NameAuth, NameForms, NameHistory}},
+		{name: "Default with an organization", cfg: config.Config{OrgContextFile: orgFile}, want: []string{NameAuth, NameForms, NameOrg, NameHistory}},
+		{name: "Disabled", cfg: config.Config{Enrichments: []string{}, OrgContextFile: orgFile}},
+		{name: "Ordered", cfg: config.Config{Enrichments: []string{NameDNS, NameAuth}}, want: []string{NameDNS, NameAuth}},
+		{name: "Unknown", cfg: config.Config{Enrichments: []string{"whois"}}, wantErr: `unknown enrichment "whois"`},
+		{name: "Organization without file", cfg: config.Config{Enrichments: []string{NameOrg}}, wantErr: "requires org_context_file"},
+		{name: "Missing organization file", cfg: config.Config{OrgContextFile: orgFile + ".missing"}, wantErr: "organization context"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := FromConfig(&tt.cfg)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("FromConfig() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("FromConfig() error = %v", err)
+			}
+			if got := p.Names(); !slices.Equal(got, tt.want) {
+				t.Errorf("FromConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPipeline_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ran []string
+	p := Pipeline{
+		staticEnricher{name: "first", result: &Result{Signals: []Signal{{Name: "spf", Value: "pass"}}}},
+		funcEnricher{name: "cancelling", fn: func(ctx context.Context) error {
+			cancel()
+			return ctx.Err()
+		}},
+		funcEnricher{name: "skipped", fn: func(ctx context.Context) error {
+			ran = append(ran, "skipped")
+			return nil
+		}},
+	}
+	results := p.Run(ctx, parse(t, "Subject: Hi\n"))
+	if len(results) != 1 || results[0].Name != "first" {
+		t.Errorf("Run() = %+v, want only the result before the cancellation", results)
+	}
+	if ran != nil {
+		t.Errorf("enrichers %v ran after the cancellation", ran)
+	}
+}
+
+// funcEnricher calls fn, and returns its error.
+type funcEnricher struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+func (f funcEnricher) Name() string { return f.name }
+
+func (f funcEnricher) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	return nil, f.fn(ctx)
+}
@@ -0,0 +1,75 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mail-analyzer/email"
+)
+
+// maxFormSignals is the number of forms of a message that are reported.
+const maxFormSignals = 5
+
+// Forms reports the HTML forms of a message: those asking for a password or a payment
+// card (credential_form), the other forms (html_form), and the forms submitted to a web
+// site (external_form_action). Legitimate messages hardly ever ask for credentials in
+// the message itself, so these are strong signs of phishing that do not depend on the
+// model noticing the form in the text of the body.
+type Forms struct{}
+
+// Name implements Enricher.
+func (f *Forms) Name() string { return NameForms }
+
+// Enrich implements Enricher.
+func (f *Forms) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	if len(e.Forms) == 0 {
+		return nil, nil
+	}
+	r := &Result{Title: "HTML Forms"}
+	for i := range e.Forms {
+		form := &e.Forms[i]
+		if i == maxFormSignals {
+			r.Signals = append(r.Signals, Signal{Name: "more_forms", Value: fmt.Sprint(len(e.Forms) - i),
+				Detail: fmt.Sprintf("The message has %d more forms.", len(e.Forms)-i)})
+			break
+		}
+		name := "html_form"
+		if form.PasswordFields > 0 || form.CardFields > 0 {
+			name = "credential_form"
+		}
+		var fields []string
+		if form.PasswordFields > 0 {
+			fields = append(fields, plural(form.PasswordFields, "password field"))
+		}
+		if form.CardFields > 0 {
+			fields = append(fields, plural(form.CardFields, "payment card field"))
+		}
+		if others := form.Fields - form.PasswordFields - form.CardFields; others > 0 {
+			fields = append(fields, plural(others, "other field"))
+		} else if len(fields) == 0 {
+			fields = append(fields, "no fields")
+		}
+		detail := fmt.Sprintf("The message has an HTML form with %s", strings.Join(fields, ", "))
+		switch {
+		case form.Standalone:
+			detail = fmt.Sprintf("The message has input fields outside of a form, which a script may submit: %s", strings.Join(fields, ", "))
+		case form.Action != "":
+			detail += fmt.Sprintf(", submitted with %s to %s", form.Method, form.Action)
+		}
+		r.Signals = append(r.Signals, Signal{Name: name, Target: form.Action, Value: "true", Detail: detail + "."})
+		if form.External() {
+			r.Signals = append(r.Signals, Signal{Name: "external_form_action", Target: form.Host(), Value: form.Action,
+				Detail: fmt.Sprintf("What is entered in the form is sent to the web site %s.", form.Host())})
+		}
+	}
+	return r, nil
+}
+
+// plural returns n and noun, in the plural unless n is 1.
+func plural(n int, noun string) string {
+	if n == 1 {
+		return "1 " + noun
+	}
+	return fmt.Sprintf("%d %ss", n, noun)
+}
@@ -0,0 +1,47 @@
+package enrichment
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"mail-analyzer/email"
+)
+
+func TestForms(t *testing.T) {
+	f := &Forms{}
+	e := parse(t, "From: it@example.org\nSubject: Session\n")
+	if got, err := f.Enrich(context.Background(), e); got != nil || err != nil {
+		t.Errorf("Enrich() without forms = %+v, %v, want nil", got, err)
+	}
+
+	e.Forms = []email.Form{
+		{Action: "https://login.example.net/auth.php", Method: "POST", Fields: 2, PasswordFields: 1},
+		{Standalone: true, Fields: 3, CardFields: 3},
+		{Action: "#", Method: "GET", Fields: 1},
+		{Method: "GET"},
+	}
+	got, err := f.Enrich(context.Background(), e)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	want := "--- HTML Forms ---\n" +
+		"- The message has an HTML form with 1 password field, 1 other field, submitted with POST to https://login.example.net/auth.php.\n" +
+		"- What is entered in the form is sent to the web site login.example.net.\n" +
+		"- The message has input fields outside of a form, which a script may submit: 3 payment card fields.\n" +
+		"- The message has an HTML form with 1 other field, submitted with GET to #.\n" +
+		"- The message has an HTML form with no fields.\n"
+	if prompt := Prompt([]Result{*got}); prompt != want {
+		t.Errorf("Prompt() = %q, want %q", prompt, want)
+	}
+	var names []string
+	for _, s := range got.Signals {
+		names = append(names, s.Name)
+	}
+	if want := []string{"credential_form", "external_form_action", "credential_form", "html_form", "html_form"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("signals = %v, want %v", names, want)
+	}
+	if s := got.Signals[1]; s.Target != "login.example.net" || s.Value != "https://login.example.net/auth.php" {
+		t.Errorf("external_form_action = %+v", s)
+	}
+}
@@ -0,0 +1,62 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"mail-analyzer/email"
+	"mail-analyzer/resultdb"
+)
+
+// SenderHistory is where the history of the senders of past messages is kept, such as a
+// results database.
+type SenderHistory interface {
+	// Sender returns the history of the address of from, or nil if it is unknown.
+	Sender(ctx context.Context, from string) (*resultdb.Sender, error)
+}
+
+// History reports whether the senders of a message wrote before, and whether their
+// earlier messages were judged suspicious. It has no facts until its Senders are set.
+type History struct {
+	Senders SenderHistory
+}
+
+// Name implements Enricher.
+func (h *History) Name() string { return NameHistory }
+
+// Enrich implements Enricher.
+func (h *History) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	if h.Senders == nil {
+		return nil, nil
+	}
+	r := &Result{Title: "Sender History"}
+	for _, from := range e.From {
+		s, err := h.Senders.Sender(ctx, from.Address)
+		if err != nil {
+			return nil, err
+		}
+		if s == nil {
+			r.Signals = append(r.Signals, Signal{Name: "first_time_sender", Target: from.Address, Value: "true",
+				Detail: fmt.Sprintf("No earlier message from %s was received.", from.Address)})
+			continue
+		}
+		r.Signals = append(r.Signals, Signal{Name: "first_time_sender", Target: from.Address, Value: "false",
+			Detail: fmt.Sprintf("%d earlier messages from %s were received since %s.", s.Messages, from.Address, s.FirstSeen.Format("2006-01-02"))})
+		if s.Flagged > 0 {
+			r.Signals = append(r.Signals, Signal{Name: "previously_flagged_sender", Target: from.Address, Value: strconv.Itoa(s.Flagged),
+				Detail: fmt.Sprintf("%d of the earlier messages from %s were judged suspicious, the latest as %s on %s.",
+					s.Flagged, from.Address, s.LastFlaggedCategory, s.LastFlaggedAt.Format("2006-01-02"))})
+		}
+	}
+	return r, nil
+}
+
+// SetSenderHistory sets the Senders of the History enrichers of p.
+func (p Pipeline) SetSenderHistory(senders SenderHistory) {
+	for _, e := range p {
+		if h, ok := e.(*History); ok {
+			h.Senders = senders
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package enrichment
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"mail-analyzer/resultdb"
+)
+
+type fakeSenders map[string]*resultdb.Sender
+
+func (f fakeSenders) Sender(ctx context.Context, from string) (*resultdb.Sender, error) {
+	return f[from], nil
+}
+
+func TestHistory(t *testing.T) {
+	h := &History{}
+	e := parse(t, "From: Billing <billing@vendor.example>\nSubject: Invoice\n")
+	if got, err := h.Enrich(context.Background(), e); got != nil || err != nil {
+		t.Errorf("Enrich() without senders = %+v, %v, want nil", got, err)
+	}
+
+	Pipeline{h}.SetSenderHistory(fakeSenders{})
+	got, err := h.Enrich(context.Background(), e)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	want := "--- Sender History ---\n" +
+		"- No earlier message from billing@vendor.example was received.\n"
+	if prompt := Prompt([]Result{*got}); prompt != want {
+		t.Errorf("Prompt() = %q, want %q", prompt, want)
+	}
+
+	day := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	h.Senders = fakeSenders{"billing@vendor.example": {
+		Address:             "billing@vendor.example",
+		Messages:            12,
+		Flagged:             2,
+		FirstSeen:           day,
+		LastFlaggedAt:       day.AddDate(0, 1, 0),
+		LastFlaggedCategory: "Phishing",
+	}}
+	got, err = h.Enrich(context.Background(), e)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	want = "--- Sender History ---\n" +
+		"- 12 earlier messages from billing@vendor.example were received since 2025-07-01.\n" +
+		"- 2 of the earlier messages from billing@vendor.example were judged suspicious, the latest as Phishing on 2025-08-01.\n"
+	if prompt := Prompt([]Result{*got}); prompt != want {
+		t.Errorf("Prompt() = %q, want %q", prompt, want)
+	}
+	if s := got.Signals[1]; s.Name != "previously_flagged_sender" || s.Value != "2" {
+		t.Errorf("signal = %+v, want previously_flagged_sender 2", s)
+	}
+}
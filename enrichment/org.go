@@ -0,0 +1,52 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"mail-analyzer/email"
+	"mail-analyzer/org"
+)
+
+// Org describes the organization of org_context_file to the model, and reports the signs
+// of its impersonation.
+type Org struct {
+	Context *org.Context
+}
+
+// Name implements Enricher.
+func (o *Org) Name() string { return NameOrg }
+
+// Enrich implements Enricher.
+func (o *Org) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	c := o.Context
+	r := &Result{Title: "Organization Context"}
+	add := func(name string, values []string, detail string) {
+		if len(values) > 0 {
+			value := strings.Join(values, ", ")
+			r.Signals = append(r.Signals, Signal{Name: name, Value: value, Detail: fmt.Sprintf(detail, value)})
+		}
+	}
+	if c.Name != "" {
+		add("organization", []string{c.Name}, "The recipient organization is %s.")
+	}
+	add("internal_domains", c.InternalDomains, "Internal domains: %s")
+	add("brands", c.Brands, "Brands: %s")
+	var executives []string
+	for _, exec := range c.Executives {
+		executives = append(executives, exec.Describe())
+	}
+	add("executives", executives, "Executives: %s")
+	add("expected_esps", c.ExpectedESPs, "Email service providers that send on behalf of the organization: %s")
+	add("partner_domains", c.PartnerDomains, "Partner domains: %s")
+
+	findings := c.Findings(e)
+	for _, f := range findings {
+		r.Signals = append(r.Signals, Signal{Name: f.Kind, Target: f.Target, Value: "true", Detail: f.Detail})
+	}
+	if len(findings) == 0 && len(r.Signals) > 0 {
+		r.Signals = append(r.Signals, Signal{Name: "impersonation", Value: "false", Detail: "No impersonation of the organization or lookalike domain was found."})
+	}
+	return r, nil
+}
@@ -0,0 +1,37 @@
+package enrichment
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"mail-analyzer/org"
+)
+
+func TestOrg(t *testing.T) {
+	o := &Org{Context: &org.Context{
+		Name:            "Example Corp",
+		InternalDomains: []string{"example.com"},
+		Executives:      []org.Executive{{Name: "Jane Doe", Title: "CEO"}},
+	}}
+	got, err := o.Enrich(context.Background(), parse(t, "From: IT <it@examp1e.com>\nSubject: Password expiry\n"))
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	want := "--- Organization Context ---\n" +
+		"- The recipient organization is Example Corp.\n" +
+		"- Internal domains: example.com\n" +
+		"- Executives: Jane Doe (CEO)\n" +
+		"- The sender domain examp1e.com looks like example.com, but is not.\n"
+	if prompt := Prompt([]Result{*got}); prompt != want {
+		t.Errorf("Prompt() = %q, want %q", prompt, want)
+	}
+	if last := got.Signals[len(got.Signals)-1]; last.Name != org.FindingLookalike || last.Target != "examp1e.com" {
+		t.Errorf("last signal = %+v, want the lookalike domain", last)
+	}
+
+	got, _ = o.Enrich(context.Background(), parse(t, "From: IT <it@example.com>\nSubject: Password expiry\n"))
+	if prompt := Prompt([]Result{*got}); !strings.HasSuffix(prompt, "- No impersonation of the organization or lookalike domain was found.\n") {
+		t.Errorf("Prompt() = %q, want no findings", prompt)
+	}
+}
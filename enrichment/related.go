@@ -0,0 +1,138 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"mail-analyzer/email"
+	"mail-analyzer/resultdb"
+)
+
+// Bounds of the Related enricher: the number of related messages reported, the length
+// of their reasons, and the Message-IDs and URLs of a message that are looked up.
+const (
+	maxRelated       = 5
+	maxRelatedReason = 300
+	maxRelatedKeys   = 20
+)
+
+// RelatedMessages is where the analyses of past messages are kept, such as a results
+// database.
+type RelatedMessages interface {
+	// Related returns the latest analysis of the messages with one of messageIDs or one
+	// of urls, newest first and at most limit of them.
+	Related(ctx context.Context, messageIDs, urls []string, limit int) ([]resultdb.Related, error)
+}
+
+// Related reports the verdicts on the earlier messages of the thread of a message, and
+// on the messages that share a URL with it, such as those of the same campaign, so that
+// the model knows, for example, that the message it replies to was confirmed as phishing.
+// It has no facts until its Messages are set.
+type Related struct {
+	Messages RelatedMessages
+}
+
+// relatedKey is the context key of the messages set by WithRelatedMessages.
+type relatedKey struct{}
+
+// relatedValue holds the messages set by WithRelatedMessages, which may be nil.
+type relatedValue struct {
+	messages RelatedMessages
+}
+
+// WithRelatedMessages returns a context in which the Related enrichers look up the
+// related messages in messages rather than in their Messages, such as in the results
+// database of the tenant of the message. If messages is nil, they have no facts.
+func WithRelatedMessages(ctx context.Context, messages RelatedMessages) context.Context {
+	return context.WithValue(ctx, relatedKey{}, relatedValue{messages})
+}
+
+// Name implements Enricher.
+func (r *Related) Name() string { return NameRelated }
+
+// Enrich implements Enricher.
+func (r *Related) Enrich(ctx context.Context, e *email.ParsedEmail) (*Result, error) {
+	messages := r.Messages
+	if v, ok := ctx.Value(relatedKey{}).(relatedValue); ok {
+		messages = v.messages
+	}
+	if messages == nil {
+		return nil, nil
+	}
+	// The nearest messages of the thread come last in References, and are looked up if
+	// it is too long.
+	inReplyTo, _ := e.Header.MsgIDList("In-Reply-To")
+	references, _ := e.Header.MsgIDList("References")
+	slices.Reverse(references)
+	var ids []string
+	for _, id := range append(inReplyTo, references...) {
+		if id != e.MessageID && !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxRelatedKeys {
+		ids = ids[:maxRelatedKeys]
+	}
+	urls := e.URLs
+	if len(urls) > maxRelatedKeys {
+		urls = urls[:maxRelatedKeys]
+	}
+	if len(ids) == 0 && len(urls) == 0 {
+		return nil, nil
+	}
+	related, err := messages.Related(ctx, ids, urls, maxRelated+1)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{Title: "Related Messages"}
+	for _, m := range related {
+		// A message analyzed again is related to itself by its URLs.
+		if m.MessageID != "" && m.MessageID == e.MessageID || len(result.Signals) == maxRelated {
+			continue
+		}
+		name, relation := "campaign_message", "which shares a URL with this one"
+		if m.Thread {
+			name, relation = "thread_message", "an earlier message of this thread"
+		}
+		category := m.Judgment.Category
+		detail := fmt.Sprintf("The message %s, %s, from %s with the subject %q, received on %s, was judged %s (confidence %.2f): %s",
+			m.MessageID, relation, strings.Join(m.From, ", "), m.Subject, m.ReceivedAt.Format("2006-01-02"),
+			m.Judgment.Category, m.Judgment.ConfidenceScore, shorten(m.Judgment.Reason, maxRelatedReason))
+		switch {
+		case m.Feedback == "":
+		case strings.EqualFold(m.Feedback, m.Judgment.Category):
+			detail += fmt.Sprintf(" A reviewer confirmed it as %s.", m.Feedback)
+			category = m.Feedback
+		default:
+			detail += fmt.Sprintf(" A reviewer corrected it to %s.", m.Feedback)
+			category = m.Feedback
+		}
+		result.Signals = append(result.Signals, Signal{Name: name, Target: m.MessageID, Value: category, Detail: detail})
+	}
+	return result, nil
+}
+
+// SetRelatedMessages sets the Messages of the Related enrichers of p.
+func (p Pipeline) SetRelatedMessages(messages RelatedMessages) {
+	for _, e := range p {
+		if r, ok := e.(*Related); ok {
+			r.Messages = messages
+		}
+	}
+}
+
+// shorten returns s, cut to at most max bytes on a character boundary, with "..." if it
+// was cut.
+func shorten(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max--
+	}
+	return s[:max] + "..."
+}
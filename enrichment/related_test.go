@@ -0,0 +1,86 @@
+package enrichment
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/llm"
+	"mail-analyzer/resultdb"
+)
+
+// fakeRelated returns its messages and records the lookups.
+type fakeRelated struct {
+	messages  []resultdb.Related
+	ids, urls []string
+}
+
+func (f *fakeRelated) Related(ctx context.Context, messageIDs, urls []string, limit int) ([]resultdb.Related, error) {
+	f.ids, f.urls = messageIDs, urls
+	return f.messages, nil
+}
+
+func TestRelated(t *testing.T) {
+	r := &Related{}
+	e := parse(t, "Message-ID: <3@example.com>\nIn-Reply-To: <2@example.com>\nReferences: <1@example.com> <2@example.com>\nSubject: Re: Invoice\n")
+	if got, err := r.Enrich(context.Background(), e); got != nil || err != nil {
+		t.Errorf("Enrich() without messages = %+v, %v, want nil", got, err)
+	}
+
+	day := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	messages := &fakeRelated{messages: []resultdb.Related{
+		{
+			Record: resultdb.Record{MessageID: "2@example.com", From: []string{"billing@vendor.example"}, Subject: "Invoice", ReceivedAt: day,
+				Judgment: llm.Judgment{Category: "Phishing", ConfidenceScore: 0.9, Reason: "Fake login page."}},
+			Thread:   true,
+			Feedback: "Phishing",
+		},
+		{
+			Record: resultdb.Record{MessageID: "9@example.com", From: []string{"it@corp.example"}, Subject: "Password", ReceivedAt: day,
+				Judgment: llm.Judgment{Category: "Spam", ConfidenceScore: 0.6, Reason: strings.Repeat("x", 400)}},
+			Feedback: "Safe",
+		},
+		// An earlier analysis of the message itself.
+		{Record: resultdb.Record{MessageID: "3@example.com", Judgment: llm.Judgment{Category: "Safe"}}},
+	}}
+	Pipeline{r}.SetRelatedMessages(messages)
+	got, err := r.Enrich(context.Background(), e)
+	if err != nil {
+		t.Fatalf("Enrich() error = %v", err)
+	}
+	if want := []string{"2@example.com", "1@example.com"}; !reflect.DeepEqual(messages.ids, want) {
+		t.Errorf("looked up Message-IDs %q, want the nearest first %q", messages.ids, want)
+	}
+	want := "--- Related Messages ---\n" +
+		`- The message 2@example.com, an earlier message of this thread, from billing@vendor.example with the subject "Invoice", received on 2025-07-01, was judged Phishing (confidence 0.90): Fake login page. A reviewer confirmed it as Phishing.` + "\n" +
+		`- The message 9@example.com, which shares a URL with this one, from it@corp.example with the subject "Password", received on 2025-07-01, was judged Spam (confidence 0.60): ` + strings.Repeat("x", maxRelatedReason) + "... A reviewer corrected it to Safe.\n"
+	if prompt := Prompt([]Result{*got}); prompt != want {
+		t.Errorf("Prompt() = %q, want %q", prompt, want)
+	}
+	if s := got.Signals[1]; s.Name != "campaign_message" || s.Target != "9@example.com" || s.Value != "Safe" {
+		t.Errorf("signal = %+v, want campaign_message Safe", s)
+	}
+
+	// A message that is neither a reply nor has URLs has no related messages to look up.
+	messages.ids = nil
+	if got, err := r.Enrich(context.Background(), parse(t, "Subject: Hello\n")); got != nil || err != nil || messages.ids != nil {
+		t.Errorf("Enrich() of an unrelated message = %+v, %v", got, err)
+	}
+}
+
+func TestRelated_WithRelatedMessages(t *testing.T) {
+	shared := &fakeRelated{messages: []resultdb.Related{{Record: resultdb.Record{MessageID: "1@example.com", Judgment: llm.Judgment{Category: "Phishing"}}, Thread: true}}}
+	own := &fakeRelated{}
+	r := &Related{Messages: shared}
+	e := parse(t, "Message-ID: <2@example.com>\nIn-Reply-To: <1@example.com>\nSubject: Re: Invoice\n")
+
+	got, err := r.Enrich(WithRelatedMessages(context.Background(), own), e)
+	if err != nil || len(got.Signals) != 0 || shared.ids != nil || own.ids == nil {
+		t.Errorf("Enrich() with other messages = %+v, %v, want those messages looked up", got, err)
+	}
+	if got, err := r.Enrich(WithRelatedMessages(context.Background(), nil), e); got != nil || err != nil || shared.ids != nil {
+		t.Errorf("Enrich() without messages = %+v, %v, want nil", got, err)
+	}
+}
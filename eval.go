@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/tabwriter"
+
+	"mail-analyzer/llm"
+)
+
+// evalCategories are the categories of the analysis tool, in the order they are reported.
+var evalCategories = []string{"Phishing", "Spam", "Safe"}
+
+// labelExt is the extension of the sidecar files that hold the label of a message.
+const labelExt = ".label"
+
+// runEval implements the "eval" command, which measures the analysis against labeled messages.
+func runEval(args []string) error {
+	flags := newFlagSet("eval", "path...",
+		"Analyze labeled messages and report how well the judgments match the labels, to\n"+
+			"compare prompts, models and providers. The label of a message is read from a\n"+
+			"sidecar file with the same name and the extension .label (msg.eml, msg.label),\n"+
+			"or else is the name of the directory of the message (phishing/msg.eml). Labels\n"+
+			"are Phishing, Spam or Safe, in any case. Results are not sent to any sink, and no\n"+
+			"action is taken.")
+	pf := pipelineFlags{analyzeOnly: true}
+	pf.registerAnalysis(flags)
+	pf.registerTimeout(flags)
+	pf.registerDeadline(flags)
+	concurrency := flags.Int("concurrency", 1, "Number of messages to analyze in parallel")
+	quiet := flags.Bool("quiet", false, "Do not report progress on standard error")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageErrorf("no files or directories given")
+	}
+	if *concurrency < 1 {
+		return usageErrorf("--concurrency must be at least 1")
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	files, err := collectMessageFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	labels := make(map[string]string, len(files))
+	for _, file := range files {
+		if labels[file], err = messageLabel(file); err != nil {
+			return err
+		}
+	}
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+
+	ctx, stop := shutdownContext()
+	defer stop()
+	ctx, cancel := pf.withDeadline(ctx)
+	defer cancel()
+	pr := newProgress(os.Stderr, len(files), isTerminal(os.Stderr))
+	pr.quiet = *quiet
+	var samples []evalSample
+	for o := range analyzeFiles(ctx, p, files, *concurrency) {
+		if ctx.Err() != nil {
+			continue // Drain the messages abandoned after an interruption.
+		}
+		s := evalSample{file: o.file, expected: labels[o.file], err: o.err}
+		if o.result != nil {
+			s.judgment = o.result.Judgment
+		}
+		samples = append(samples, s)
+		pr.update(o.err != nil)
+	}
+	pr.finish()
+
+	report := newEvalReport(samples)
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(report)
+	} else {
+		err = report.write(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	if report.Errors > 0 {
+		errs = append(errs, fmt.Errorf("%d of %d messages could not be analyzed", report.Errors, len(files)))
+	}
+	if ctx.Err() != nil {
+		errs = append(errs, stoppedError(ctx, "analyzing %d of %d messages", len(samples), len(files)))
+	}
+	return errors.Join(errs...)
+}
+
+// messageLabel returns the expected category of the message in file, from its sidecar
+// label file or else from the name of its directory.
+func messageLabel(file string) (string, error) {
+	label := filepath.Base(filepath.Dir(file))
+	sidecar := strings.TrimSuffix(file, filepath.Ext(file)) + labelExt
+	data, err := os.ReadFile(sidecar)
+	switch {
+	case err == nil:
+		label, _, _ = strings.Cut(strings.TrimSpace(string(data)), "\n")
+		label = strings.TrimSpace(label)
+	case !os.IsNotExist(err):
+		return "", err
+	}
+	category, ok := normalizeCategory(label)
+	if !ok {
+		return "", fmt.Errorf("%s: no label: %s does not exist and %q is not one of %s",
+			file, sidecar, label, strings.Join(evalCategories, ", "))
+	}
+	return category, nil
+}
+
+// normalizeCategory returns the category of evalCategories that matches s in any case.
+func normalizeCategory(s string) (string, bool) {
+	for _, c := range evalCategories {
+		if strings.EqualFold(s, c) {
+			return c, true
+		}
+	}
+	return s, false
+}
+
+// evalSample is a labeled message and its analysis.
+type evalSample struct {
+	file     string
+	expected string
+	judgment *llm.Judgment
+	err      error
+}
+
+// evalReport summarizes how the judgments of the samples match their labels. Messages
+// that could not be analyzed are counted in Errors only.
+type evalReport struct {
+	Messages   int             `json:"messages"`
+	Errors     int             `json:"errors"`
+	Correct    int             `json:"correct"`
+	Accuracy   float64         `json:"accuracy"`
+	Categories []categoryStats `json:"categories"`
+	// Confusion counts the messages by expected and then predicted category.
+	Confusion map[string]map[string]int `json:"confusion_matrix"`
+	// Diffs lists the misclassified messages and those that could not be analyzed.
+	Diffs []evalDiff `json:"diffs"`
+}
+
+type categoryStats struct {
+	Category  string  `json:"category"`
+	Precision float64 `json:"precision"`
+	Recall    float64 `json:"recall"`
+	F1        float64 `json:"f1"`
+	// Support is the number of analyzed messages labeled with the category.
+	Support int `json:"support"`
+}
+
+type evalDiff struct {
+	File       string  `json:"file"`
+	Expected   string  `json:"expected"`
+	Predicted  string  `json:"predicted,omitempty"`
+	Confidence float64 `json:"confidence,omitempty"`
+	Reason     string  `json:"reason,omitempty"`
+	Error      string  `json:"error,omitempty"`
+}
+
+func newEvalReport(samples []evalSample) *evalReport {
+	r := &evalReport{Messages: len(samples), Confusion: map[string]map[string]int{}, Diffs: []evalDiff{}}
+	categories := slices.Clone(evalCategories)
+	for _, s := range samples {
+		if s.err != nil {
+			r.Errors++
+			r.Diffs = append(r.Diffs, evalDiff{File: s.file, Expected: s.expected, Error: s.err.Error()})
+			continue
+		}
+		predicted, _ := normalizeCategory(s.judgment.Category)
+		if !slices.Contains(categories, predicted) {
+			categories = append(categories, predicted)
+		}
+		if r.Confusion[s.expected] == nil {
+			r.Confusion[s.expected] = map[string]int{}
+		}
+		r.Confusion[s.expected][predicted]++
+		if predicted == s.expected {
+			r.Correct++
+			continue
+		}
+		r.Diffs = append(r.Diffs, evalDiff{
+			File:       s.file,
+			Expected:   s.expected,
+			Predicted:  predicted,
+			Confidence: s.judgment.ConfidenceScore,
+			Reason:     s.judgment.Reason,
+		})
+	}
+	if analyzed := r.Messages - r.Errors; analyzed > 0 {
+		r.Accuracy = float64(r.Correct) / float64(analyzed)
+	}
+
+	for _, c := range categories {
+		truePositives := r.Confusion[c][c]
+		predicted := 0
+		for _, row := range r.Confusion {
+			predicted += row[c]
+		}
+		stats := categoryStats{Category: c}
+		for _, n := range r.Confusion[c] {
+			stats.Support += n
+		}
+		if predicted == 0 && stats.Support == 0 {
+			continue
+		}
+		if predicted > 0 {
+			stats.Precision = float64(truePositives) / float64(predicted)
+		}
+		if stats.Support > 0 {
+			stats.Recall = float64(truePositives) / float64(stats.Support)
+		}
+		if stats.Precision+stats.Recall > 0 {
+			stats.F1 = 2 * stats.Precision * stats.Recall / (stats.Precision + stats.Recall)
+		}
+		r.Categories = append(r.Categories, stats)
+	}
+	return r
+}
+
+// write prints the report as text.
+func (r *evalReport) write(w io.Writer) error {
+	fmt.Fprintf(w, "Messages: %d", r.Messages)
+	if r.Errors > 0 {
+		fmt.Fprintf(w, " (%d could not be analyzed)", r.Errors)
+	}
+	fmt.Fprintf(w, "\nAccuracy: %.3f (%d of %d)\n\n", r.Accuracy, r.Correct, r.Messages-r.Errors)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "CATEGORY\tPRECISION\tRECALL\tF1\tSUPPORT")
+	for _, c := range r.Categories {
+		fmt.Fprintf(tw, "%s\t%.3f\t%.3f\t%.3f\t%d\n", c.Category, c.Precision, c.Recall, c.F1, c.Support)
+	}
+	fmt.Fprintln(tw)
+	fmt.Fprint(tw, "EXPECTED \\ PREDICTED")
+	for _, c := range r.Categories {
+		fmt.Fprintf(tw, "\t%s", c.Category)
+	}
+	fmt.Fprintln(tw)
+	for _, expected := range r.Categories {
+		fmt.Fprint(tw, expected.Category)
+		for _, predicted := range r.Categories {
+			fmt.Fprintf(tw, "\t%d", r.Confusion[expected.Category][predicted.Category])
+		}
+		fmt.Fprintln(tw)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(r.Diffs) > 0 {
+		fmt.Fprintln(w, "\nDifferences:")
+	}
+	for _, d := range r.Diffs {
+		if d.Error != "" {
+			fmt.Fprintf(w, "%s: expected %s, error: %s\n", d.File, d.Expected, d.Error)
+			continue
+		}
+		fmt.Fprintf(w, "%s: expected %s, got %s (confidence %.2f): %s\n", d.File, d.Expected, d.Predicted, d.Confidence, d.Reason)
+	}
+	return nil
+}
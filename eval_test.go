@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/llm"
+)
+
+func TestMessageLabel(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"phishing/a.eml": "",
+		"inbox/b.eml":    "",
+		"inbox/b.label":  " spam\n",
+		"inbox/c.eml":    "",
+	} {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0o755)
+		os.WriteFile(path, []byte(content), 0o600)
+	}
+
+	tests := []struct {
+		file    string
+		want    string
+		wantErr bool
+	}{
+		{"phishing/a.eml", "Phishing", false},
+		{"inbox/b.eml", "Spam", false},
+		{"inbox/c.eml", "", true},
+	}
+	for _, tt := range tests {
+		got, err := messageLabel(filepath.Join(dir, tt.file))
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("messageLabel(%s) = %q, %v, want %q, error %t", tt.file, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestEvalReport(t *testing.T) {
+	judgment := func(category string) *llm.Judgment {
+		return &llm.Judgment{Category: category, ConfidenceScore: 0.8, Reason: "Because."}
+	}
+	report := newEvalReport([]evalSample{
+		{file: "1.eml", expected: "Phishing", judgment: judgment("Phishing")},
+		{file: "2.eml", expected: "Phishing", judgment: judgment("phishing")},
+		{file: "3.eml", expected: "Phishing", judgment: judgment("Safe")},
+		{file: "4.eml", expected: "Safe", judgment: judgment("Safe")},
+		{file: "5.eml", expected: "Safe", err: errors.New("timeout")},
+	})
+
+	if report.Messages != 5 || report.Errors != 1 || report.Correct != 3 || report.Accuracy != 0.75 {
+		t.Errorf("report = %d messages, %d errors, %d correct, accuracy %v, want 5, 1, 3, 0.75",
+			report.Messages, report.Errors, report.Correct, report.Accuracy)
+	}
+	wantCategories := []categoryStats{
+		{Category: "Phishing", Precision: 1, Recall: 2.0 / 3, F1: 0.8, Support: 3},
+		{Category: "Safe", Precision: 0.5, Recall: 1, F1: 2.0 / 3, Support: 1},
+	}
+	if !reflect.DeepEqual(report.Categories, wantCategories) {
+		t.Errorf("Categories = %+v, want %+v", report.Categories, wantCategories)
+	}
+	if got := report.Confusion["Phishing"]; got["Phishing"] != 2 || got["Safe"] != 1 {
+		t.Errorf("Confusion[Phishing] = %v", got)
+	}
+	wantDiffs := []evalDiff{
+		{File: "3.eml", Expected: "Phishing", Predicted: "Safe", Confidence: 0.8, Reason: "Because."},
+		{File: "5.eml", Expected: "Safe", Error: "timeout"},
+	}
+	if !reflect.DeepEqual(report.Diffs, wantDiffs) {
+		t.Errorf("Diffs = %+v, want %+v", report.Diffs, wantDiffs)
+	}
+
+	var buf bytes.Buffer
+	if err := report.write(&buf); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Accuracy: 0.750 (3 of 4)",
+		"Phishing              2         1",
+		"3.eml: expected Phishing, got Safe (confidence 0.80): Because.",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("report does not contain %q:\n%s", want, buf.String())
+		}
+	}
+}
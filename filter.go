@@ -0,0 +1,161 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-analyzer/email"
+)
+
+// errFiltered is returned by analyze for messages that do not match the filter.
+var errFiltered = errors.New("the message does not match the filter")
+
+// filterHelp describes the --filter expressions.
+const filterHelp = "Only analyze messages matching `expr`; repeat to require several. Expressions:\n" +
+	"from-domain:example.com,example.org  subject:REGEXP  since:DATE  until:DATE\n" +
+	"has-attachment[:false]  min-size:SIZE  max-size:SIZE\n" +
+	"DATE is a date (2006-01-02), an RFC 3339 timestamp or a duration ago (24h), and\n" +
+	"SIZE a number of bytes with an optional k, M or G suffix. Prefix with ! to negate."
+
+// messageFilter selects the messages to analyze: a message must match every condition.
+// It implements flag.Value, so that --filter can be repeated.
+type messageFilter []filterCondition
+
+// filterCondition is a parsed --filter expression.
+type filterCondition struct {
+	expr  string
+	match func(e *email.ParsedEmail, size int) bool
+}
+
+func (f *messageFilter) String() string {
+	var exprs []string
+	for _, c := range *f {
+		exprs = append(exprs, c.expr)
+	}
+	return strings.Join(exprs, " ")
+}
+
+func (f *messageFilter) Set(expr string) error {
+	c, err := parseFilterCondition(expr, time.Now())
+	if err != nil {
+		return err
+	}
+	*f = append(*f, c)
+	return nil
+}
+
+// match reports whether the message, of size bytes, matches every condition.
+func (f messageFilter) match(e *email.ParsedEmail, size int) bool {
+	for _, c := range f {
+		if !c.match(e, size) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseFilterCondition parses a --filter expression; durations are relative to now.
+func parseFilterCondition(expr string, now time.Time) (filterCondition, error) {
+	name, value, hasValue := strings.Cut(strings.TrimPrefix(expr, "!"), ":")
+	negate := strings.HasPrefix(expr, "!")
+	invalid := func(format string, args ...any) (filterCondition, error) {
+		return filterCondition{}, fmt.Errorf("invalid filter %q: "+format, append([]any{expr}, args...)...)
+	}
+	if !hasValue && name != "has-attachment" {
+		return invalid("expected %s:VALUE", name)
+	}
+
+	var match func(e *email.ParsedEmail, size int) bool
+	switch name {
+	case "from-domain":
+		domains := strings.Split(strings.ToLower(value), ",")
+		match = func(e *email.ParsedEmail, _ int) bool {
+			for _, addr := range e.From {
+				_, domain, _ := strings.Cut(strings.ToLower(addr.Address), "@")
+				for _, d := range domains {
+					if d = strings.TrimSpace(d); domain == d || strings.HasSuffix(domain, "."+d) {
+						return true
+					}
+				}
+			}
+			return false
+		}
+	case "subject":
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		match = func(e *email.ParsedEmail, _ int) bool { return re.MatchString(e.Subject) }
+	case "since", "until":
+		t, err := parseSince(value, now)
+		if err != nil {
+			return invalid("expected a date, timestamp or duration")
+		}
+		// Messages without a valid Date header match neither.
+		match = func(e *email.ParsedEmail, _ int) bool {
+			date, err := e.Header.Date()
+			if err != nil || date.IsZero() {
+				return false
+			}
+			if name == "since" {
+				return !date.Before(t)
+			}
+			return date.Before(t)
+		}
+	case "has-attachment":
+		want := true
+		if hasValue {
+			var err error
+			if want, err = strconv.ParseBool(value); err != nil {
+				return invalid("expected true or false")
+			}
+		}
+		match = func(e *email.ParsedEmail, _ int) bool { return (len(e.Attachments) > 0) == want }
+	case "min-size", "max-size":
+		limit, err := parseSize(value)
+		if err != nil {
+			return invalid("%v", err)
+		}
+		match = func(_ *email.ParsedEmail, size int) bool {
+			if name == "min-size" {
+				return int64(size) >= limit
+			}
+			return int64(size) <= limit
+		}
+	default:
+		return invalid("unknown condition %q", name)
+	}
+
+	if negate {
+		m := match
+		match = func(e *email.ParsedEmail, size int) bool { return !m(e, size) }
+	}
+	return filterCondition{expr: expr, match: match}, nil
+}
+
+// parseSize parses a number of bytes with an optional k, M or G suffix (powers of 1024).
+func parseSize(s string) (int64, error) {
+	number, multiplier := strings.TrimSuffix(strings.TrimSuffix(s, "B"), "i"), int64(1)
+	if n := len(number); n > 0 {
+		switch number[n-1] {
+		case 'k', 'K':
+			multiplier = 1 << 10
+		case 'm', 'M':
+			multiplier = 1 << 20
+		case 'g', 'G':
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			number = number[:n-1]
+		}
+	}
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * multiplier, nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/email"
+)
+
+func TestParseFilterCondition(t *testing.T) {
+	raw := "From: Billing <billing@mail.shop.example.com>\r\n" +
+		"Subject: Invoice 1234 overdue\r\n" +
+		"Date: Tue, 01 Jul 2025 12:00:00 +0000\r\n" +
+		"Content-Type: multipart/mixed; boundary=b\r\n" +
+		"\r\n" +
+		"--b\r\nContent-Type: text/plain\r\n\r\nPlease pay.\r\n" +
+		"--b\r\nContent-Type: application/pdf\r\nContent-Disposition: attachment; filename=invoice.pdf\r\n\r\n%PDF\r\n" +
+		"--b--\r\n"
+	parsed, err := email.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want bool
+	}{
+		{"from-domain:example.com", true},
+		{"from-domain:other.example,SHOP.example.com", true},
+		{"from-domain:hop.example.com", false},
+		{"!from-domain:example.com", false},
+		{"subject:(?i)^invoice [0-9]+", true},
+		{"subject:receipt", false},
+		{"since:2025-06-30", true},
+		{"since:12h", false},
+		{"until:48h", false},
+		{"until:2025-07-02T00:00:00Z", true},
+		{"has-attachment", true},
+		{"has-attachment:false", false},
+		{"!has-attachment", false},
+		{"min-size:1k", false},
+		{"max-size:1k", true},
+		{"min-size:100", true},
+	}
+	for _, tt := range tests {
+		c, err := parseFilterCondition(tt.expr, now)
+		if err != nil {
+			t.Errorf("parseFilterCondition(%q) error = %v", tt.expr, err)
+			continue
+		}
+		if got := c.match(parsed, len(raw)); got != tt.want {
+			t.Errorf("%q matched = %t, want %t", tt.expr, got, tt.want)
+		}
+	}
+
+	for _, expr := range []string{"subject", "subject:(", "since:yesterday", "has-attachment:maybe", "max-size:10X", "body:x"} {
+		if _, err := parseFilterCondition(expr, now); err == nil {
+			t.Errorf("parseFilterCondition(%q) succeeded, want an error", expr)
+		}
+	}
+}
+
+func TestMessageFilter(t *testing.T) {
+	var f messageFilter
+	for _, expr := range []string{"subject:Invoice", "max-size:1M"} {
+		if err := f.Set(expr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := f.String(); got != "subject:Invoice max-size:1M" {
+		t.Errorf("String() = %q", got)
+	}
+	e := &email.ParsedEmail{Subject: "Invoice"}
+	if !f.match(e, 1<<20) || f.match(e, 1<<20+1) || f.match(&email.ParsedEmail{Subject: "Hello"}, 10) {
+		t.Error("match() does not require every condition")
+	}
+	var empty messageFilter
+	if !empty.match(e, 0) {
+		t.Error("an empty filter does not match everything")
+	}
+}
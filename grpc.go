@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+	mailanalyzerv1 "mail-analyzer/proto/mailanalyzer/v1"
+)
+
+// grpcMessageOverhead is added to --max-message-size for the other fields of a request.
+const grpcMessageOverhead = 64 << 10
+
+// runGRPC implements the "grpc" command, a gRPC server for the MailAnalyzer service
+// defined in proto/mailanalyzer/v1/mail_analyzer.proto.
+func runGRPC(args []string) error {
+	fs := newFlagSet("grpc", "",
+		"Serve the MailAnalyzer gRPC service (proto/mailanalyzer/v1/mail_analyzer.proto).\n"+
+			"Analyze analyzes one message; AnalyzeStream analyzes a stream of messages in order.")
+	var pf pipelineFlags
+	pf.register(fs)
+	pf.daemon = true
+	listen := fs.String("listen", "127.0.0.1:9090", "Address to listen on")
+	maxSize := fs.Int("max-message-size", defaultMaxMessageSize, "Largest accepted message in bytes")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	live := newLivePipeline(p, &pf)
+
+	lis, err := net.Listen("tcp", *listen)
+	if err != nil {
+		return err
+	}
+	// On SIGINT or SIGTERM, the analyses in progress are cancelled and open streams end.
+	ctx, stop := shutdownContext()
+	defer stop()
+	go live.watch(ctx)
+	server := newGRPCServer(ctx, live, *maxSize)
+	go func() {
+		<-ctx.Done()
+		server.GracefulStop()
+	}()
+
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", *listen)
+	if err := server.Serve(lis); err != nil {
+		return err
+	}
+	if err := live.close(); err != nil {
+		return err
+	}
+	return interruptedError(ctx, context.Cause(ctx))
+}
+
+// newGRPCServer returns a gRPC server with the MailAnalyzer service registered. Once
+// shutdown is done, the calls in progress are cancelled.
+func newGRPCServer(shutdown context.Context, src pipelineSource, maxSize int) *grpc.Server {
+	server := grpc.NewServer(grpc.MaxRecvMsgSize(maxSize + grpcMessageOverhead))
+	mailanalyzerv1.RegisterMailAnalyzerServer(server, &grpcService{src: src, maxSize: maxSize, shutdown: shutdown, limits: &rateLimits{}})
+	return server
+}
+
+type grpcService struct {
+	mailanalyzerv1.UnimplementedMailAnalyzerServer
+	src      pipelineSource
+	maxSize  int
+	shutdown context.Context
+	limits   *rateLimits
+}
+
+// errShuttingDown is returned for the calls cancelled by a shutdown.
+var errShuttingDown = status.Error(codes.Unavailable, "the server is shutting down")
+
+func (s *grpcService) Analyze(ctx context.Context, req *mailanalyzerv1.AnalyzeRequest) (*mailanalyzerv1.AnalyzeResponse, error) {
+	return s.analyze(ctx, req)
+}
+
+func (s *grpcService) AnalyzeStream(stream mailanalyzerv1.MailAnalyzer_AnalyzeStreamServer) error {
+	for {
+		req, err := stream.Recv()
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		resp, err := s.analyze(stream.Context(), req)
+		if err != nil {
+			if stream.Context().Err() != nil || errors.Is(err, errShuttingDown) || status.Code(err) == codes.Unauthenticated {
+				return err
+			}
+			resp = &mailanalyzerv1.AnalyzeResponse{RequestId: req.RequestId, Error: status.Convert(err).Message()}
+		}
+		if err := stream.Send(resp); err != nil {
+			return err
+		}
+	}
+}
+
+// analyze analyzes the message of req, with the policy of the API token in the
+// authorization metadata of the call, if any, records the result and takes the
+// configured actions, and returns the result as the role of the token may see it, like
+// the HTTP server's /analyze endpoint.
+func (s *grpcService) analyze(ctx context.Context, req *mailanalyzerv1.AnalyzeRequest) (*mailanalyzerv1.AnalyzeResponse, error) {
+	if len(req.RawMessage) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "empty message")
+	}
+	if len(req.RawMessage) > s.maxSize {
+		return nil, status.Errorf(codes.ResourceExhausted, "message larger than %d bytes", s.maxSize)
+	}
+
+	if s.shutdown.Err() != nil {
+		return nil, errShuttingDown
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(s.shutdown, cancel)()
+
+	p, release := s.src.acquire()
+	defer release()
+	var authorization string
+	if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+		authorization = md.Get("authorization")[0]
+	}
+	client, ok := p.authenticateHeader(authorization)
+	if !ok {
+		return nil, status.Error(codes.Unauthenticated, "missing or invalid API token")
+	}
+	if policy := client.policy; policy != nil {
+		if policy.RateLimit > 0 {
+			if wait := s.limits.take(policy.Tenant, policy.RateLimit, time.Now()); wait > 0 {
+				return nil, status.Errorf(codes.ResourceExhausted, "rate limit of %d messages per minute exceeded, retry in %s", policy.RateLimit, wait.Round(time.Second))
+			}
+		}
+		ctx = withTenant(ctx, policy)
+	}
+	result, err := p.analyze(ctx, req.RawMessage, "")
+	if errors.Is(err, email.ErrParse) {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if s.shutdown.Err() != nil {
+			return nil, errShuttingDown
+		}
+		if ctx.Err() != nil {
+			return nil, status.FromContextError(ctx.Err()).Err()
+		}
+		if errors.Is(err, llm.ErrBadModelOutput) {
+			return nil, status.Error(codes.Internal, err.Error())
+		}
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	if err := errors.Join(p.record(ctx, result), p.act(ctx, result)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	result = resultFor(client.role, result)
+	resp := &mailanalyzerv1.AnalyzeResponse{
+		RequestId:  req.RequestId,
+		MessageId:  result.MessageID,
+		Subject:    result.Subject,
+		From:       result.From,
+		To:         result.To,
+		Urls:       result.URLs,
+		AnalysisId: result.AnalysisID,
+	}
+	if j := result.Judgment; j != nil {
+		resp.Judgment = &mailanalyzerv1.Judgment{
+			IsSuspicious:    j.IsSuspicious,
+			Category:        j.Category,
+			Reason:          j.Reason,
+			ConfidenceScore: j.ConfidenceScore,
+		}
+	}
+	return resp, nil
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"mail-analyzer/config"
+	mailanalyzerv1 "mail-analyzer/proto/mailanalyzer/v1"
+)
+
+// newGRPCClient returns a client of a gRPC server of p, which is stopped at the end of
+// the test.
+func newGRPCClient(t *testing.T, p *pipeline) mailanalyzerv1.MailAnalyzerClient {
+	lis := bufconn.Listen(1 << 20)
+	server := newGRPCServer(context.Background(), p, 1024)
+	go server.Serve(lis)
+	t.Cleanup(server.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufconn",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return mailanalyzerv1.NewMailAnalyzerClient(conn)
+}
+
+func TestGRPCService(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	client := newGRPCClient(t, p)
+	ctx := context.Background()
+	message := []byte("Message-ID: <1@example.com>\r\nSubject: Verify\r\n\r\nhttps://evil.example.com\r\n")
+
+	resp, err := client.Analyze(ctx, &mailanalyzerv1.AnalyzeRequest{RawMessage: message, RequestId: "a"})
+	if err != nil {
+		t.Fatalf("Analyze() error = %v", err)
+	}
+	if resp.RequestId != "a" || resp.MessageId != "1@example.com" || resp.Judgment.GetCategory() != "Phishing" || len(resp.Urls) != 1 {
+		t.Errorf("Analyze() = %v", resp)
+	}
+	if _, err := client.Analyze(ctx, &mailanalyzerv1.AnalyzeRequest{}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Analyze() of an empty message error = %v, want InvalidArgument", err)
+	}
+
+	stream, err := client.AnalyzeStream(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := []*mailanalyzerv1.AnalyzeRequest{
+		{RawMessage: message, RequestId: "1"},
+		{RawMessage: make([]byte, 2048), RequestId: "2"},
+		{RawMessage: message, RequestId: "3"},
+	}
+	for _, req := range requests {
+		if err := stream.Send(req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stream.CloseSend()
+	var got []*mailanalyzerv1.AnalyzeResponse
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Recv() error = %v", err)
+		}
+		got = append(got, resp)
+	}
+	if len(got) != 3 {
+		t.Fatalf("AnalyzeStream() returned %d responses, want 3", len(got))
+	}
+	for i, resp := range got {
+		if resp.RequestId != requests[i].RequestId {
+			t.Errorf("response %d has request_id %q, want %q", i, resp.RequestId, requests[i].RequestId)
+		}
+	}
+	if got[0].Judgment.GetCategory() != "Phishing" || got[1].Error == "" || got[1].Judgment != nil || got[2].Error != "" {
+		t.Errorf("AnalyzeStream() = %v", got)
+	}
+}
+
+func TestGRPCService_APITokens(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	cfg := &config.Config{
+		OpenAIBaseURL:       llmServer.URL,
+		ChatCompletionsPath: "/chat/completions",
+		Policies: []config.Policy{{Tenant: "acme", RateLimit: 3, APITokens: []config.APIToken{
+			{Token: "analyst-token"},
+			{Token: "redacted-token", Role: config.RoleRedacted},
+			{Token: "verdict-token", Role: config.RoleVerdict},
+		}}},
+	}
+	p, err := newPipeline(cfg, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	client := newGRPCClient(t, p)
+	req := &mailanalyzerv1.AnalyzeRequest{RawMessage: []byte("To: alice@acme.com\r\nSubject: Verify\r\n\r\nhttps://evil.example.com\r\n")}
+	withToken := func(token string) context.Context {
+		return metadata.AppendToOutgoingContext(context.Background(), "authorization", "Bearer "+token)
+	}
+
+	for _, ctx := range []context.Context{context.Background(), withToken("wrong-token")} {
+		if _, err := client.Analyze(ctx, req); status.Code(err) != codes.Unauthenticated {
+			t.Errorf("Analyze() without a valid token error = %v, want Unauthenticated", err)
+		}
+	}
+	stream, err := client.AnalyzeStream(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.Send(req)
+	if _, err := stream.Recv(); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("AnalyzeStream() without a token error = %v, want Unauthenticated", err)
+	}
+
+	for _, tt := range []struct {
+		token string
+		check func(*mailanalyzerv1.AnalyzeResponse) bool
+	}{
+		{"analyst-token", func(r *mailanalyzerv1.AnalyzeResponse) bool {
+			return r.To[0] == "<alice@acme.com>" && r.Judgment.GetReason() == "Fake login."
+		}},
+		{"redacted-token", func(r *mailanalyzerv1.AnalyzeResponse) bool {
+			return r.To[0] == "[redacted]@acme.com" && r.Judgment.GetReason() == "Fake login."
+		}},
+		{"verdict-token", func(r *mailanalyzerv1.AnalyzeResponse) bool {
+			return r.To == nil && r.Subject == "" && r.Urls == nil && r.Judgment.GetReason() == "" && r.Judgment.GetCategory() == "Phishing"
+		}},
+	} {
+		resp, err := client.Analyze(withToken(tt.token), req)
+		if err != nil {
+			t.Fatalf("Analyze() with token %q error = %v", tt.token, err)
+		}
+		if !tt.check(resp) {
+			t.Errorf("Analyze() with token %q = %v", tt.token, resp)
+		}
+	}
+	if _, err := client.Analyze(withToken("analyst-token"), req); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Analyze() beyond the rate limit error = %v, want ResourceExhausted", err)
+	}
+}
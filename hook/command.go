@@ -0,0 +1,208 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/mail"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// Command is a Hook that runs a program at one stage of the analysis. The program is
+// executed directly, without a shell. It receives a JSON document on stdin and may print
+// a changed one on stdout, or nothing to leave the message unchanged.
+//
+// Before the analysis, the document is a Message, and fields left out of the output keep
+// their values. After the analysis, it is an object with the Message as "email" and the
+// judgment as "judgment", and the output is the judgment:
+//
+//	{"is_suspicious": true, "category": "Phishing", "reason": "...", "confidence_score": 0.9}
+type Command struct {
+	stage   string
+	command []string
+	timeout time.Duration
+}
+
+// NewCommand creates a Command from the configuration of a hook.
+func NewCommand(hc config.Hook) (*Command, error) {
+	if len(hc.Command) == 0 || hc.Command[0] == "" {
+		return nil, errors.New("hook command is empty")
+	}
+	if hc.Stage != config.HookBefore && hc.Stage != config.HookAfter {
+		return nil, fmt.Errorf("hook %q: unknown stage %q", hc.Command, hc.Stage)
+	}
+	return &Command{stage: hc.Stage, command: hc.Command, timeout: time.Duration(hc.Timeout)}, nil
+}
+
+// String returns the name of the hook, for errors and logs.
+func (c *Command) String() string {
+	return fmt.Sprintf("%s hook %q", c.stage, c.command)
+}
+
+// Message is the document of a message given to the commands.
+type Message struct {
+	MessageID   string       `json:"message_id"`
+	From        []Address    `json:"from"`
+	To          []Address    `json:"to"`
+	Subject     string       `json:"subject"`
+	Headers     []Header     `json:"headers"`
+	Body        string       `json:"body"`
+	URLs        []string     `json:"urls"`
+	Attachments []Attachment `json:"attachments"`
+}
+
+// Address is an address of Message.
+type Address struct {
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+}
+
+// Header is a header field of Message, in the order of the message.
+type Header struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// Attachment describes an attachment of Message. The content is not included.
+type Attachment struct {
+	Filename    string `json:"filename"`
+	ContentType string `json:"content_type"`
+	Size        int    `json:"size"`
+}
+
+// BeforeAnalysis implements Hook.
+func (c *Command) BeforeAnalysis(ctx context.Context, e *email.ParsedEmail) error {
+	if c.stage != config.HookBefore {
+		return nil
+	}
+	in := NewMessage(e)
+	out := NewMessage(e)
+	if err := c.run(ctx, in, out); err != nil {
+		return err
+	}
+	out.apply(e, in.Headers)
+	return nil
+}
+
+// AfterAnalysis implements Hook.
+func (c *Command) AfterAnalysis(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error {
+	if c.stage != config.HookAfter {
+		return nil
+	}
+	in := struct {
+		Email    *Message      `json:"email"`
+		Judgment *llm.Judgment `json:"judgment"`
+	}{NewMessage(e), j}
+	out := *j
+	if err := c.run(ctx, in, &out); err != nil {
+		return err
+	}
+	if out.Category == "" {
+		return fmt.Errorf("%s: the judgment has no category", c)
+	}
+	*j = out
+	return nil
+}
+
+// run runs the command with in on stdin, and decodes its output, if any, into out.
+func (c *Command) run(ctx context.Context, in, out any) error {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	var stdin bytes.Buffer
+	enc := json.NewEncoder(&stdin)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(in); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdin = &stdin
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return fmt.Errorf("%s failed: %w: %s", c, err, strings.TrimSpace(stderr.String()))
+	}
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return nil
+	}
+	if err := json.Unmarshal(stdout.Bytes(), out); err != nil {
+		return fmt.Errorf("could not decode the output of %s: %w", c, err)
+	}
+	return nil
+}
+
+// NewMessage returns the document of e. Other commands that receive messages, such as
+// analyzer plugins, use the same document.
+func NewMessage(e *email.ParsedEmail) *Message {
+	m := &Message{
+		MessageID: e.MessageID,
+		From:      newAddresses(e.From),
+		To:        newAddresses(e.To),
+		Subject:   e.Subject,
+		Body:      e.Body,
+		URLs:      e.URLs,
+	}
+	fields := e.Header.Fields()
+	for fields.Next() {
+		m.Headers = append(m.Headers, Header{Name: fields.Key(), Value: fields.Value()})
+	}
+	for _, a := range e.Attachments {
+		m.Attachments = append(m.Attachments, Attachment{Filename: a.Filename, ContentType: a.ContentType, Size: a.Size})
+	}
+	return m
+}
+
+func newAddresses(addrs []*mail.Address) []Address {
+	list := []Address{}
+	for _, addr := range addrs {
+		list = append(list, Address{Name: addr.Name, Address: addr.Address})
+	}
+	return list
+}
+
+// apply sets the fields of e from m. The header of e is only replaced if the headers of m
+// differ from original, the ones given to the command.
+func (m *Message) apply(e *email.ParsedEmail, original []Header) {
+	e.MessageID = m.MessageID
+	e.From = mailAddresses(m.From)
+	e.To = mailAddresses(m.To)
+	e.Subject = m.Subject
+	e.Body = m.Body
+	e.URLs = m.URLs
+	e.Attachments = e.Attachments[:0]
+	for _, a := range m.Attachments {
+		e.Attachments = append(e.Attachments, email.Attachment{Filename: a.Filename, ContentType: a.ContentType, Size: a.Size})
+	}
+	if !slices.Equal(m.Headers, original) {
+		var header mail.Header
+		// Add puts the field before the others, so the fields are added from the last.
+		for _, h := range slices.Backward(m.Headers) {
+			header.Add(h.Name, h.Value)
+		}
+		e.Header = header
+	}
+}
+
+func mailAddresses(addrs []Address) []*mail.Address {
+	var list []*mail.Address
+	for _, addr := range addrs {
+		list = append(list, &mail.Address{Name: addr.Name, Address: addr.Address})
+	}
+	return list
+}
@@ -0,0 +1,108 @@
+// Package hook runs custom code before and after the analysis of each message, to enrich
+// the message, for example with the verdict of an internal reputation service, or to
+// post-process the judgment, without changing mail-analyzer itself.
+package hook
+
+import (
+	"context"
+	"log"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// Hook is called before and after the analysis of each message.
+type Hook interface {
+	// BeforeAnalysis may change e before the prompt is built from it.
+	BeforeAnalysis(ctx context.Context, e *email.ParsedEmail) error
+	// AfterAnalysis may change j, the judgment of e.
+	AfterAnalysis(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error
+}
+
+// Funcs is a Hook made of functions, either of which may be nil.
+type Funcs struct {
+	Before func(ctx context.Context, e *email.ParsedEmail) error
+	After  func(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error
+}
+
+// BeforeAnalysis implements Hook.
+func (f Funcs) BeforeAnalysis(ctx context.Context, e *email.ParsedEmail) error {
+	if f.Before == nil {
+		return nil
+	}
+	return f.Before(ctx, e)
+}
+
+// AfterAnalysis implements Hook.
+func (f Funcs) AfterAnalysis(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error {
+	if f.After == nil {
+		return nil
+	}
+	return f.After(ctx, e, j)
+}
+
+// Chain is a Hook that calls its hooks in order, and stops at the first error.
+type Chain []Hook
+
+// BeforeAnalysis implements Hook.
+func (c Chain) BeforeAnalysis(ctx context.Context, e *email.ParsedEmail) error {
+	for _, h := range c {
+		if err := h.BeforeAnalysis(ctx, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AfterAnalysis implements Hook.
+func (c Chain) AfterAnalysis(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error {
+	for _, h := range c {
+		if err := h.AfterAnalysis(ctx, e, j); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// FromConfig returns the hooks configured in cfg.
+func FromConfig(cfg *config.Config) (Chain, error) {
+	var chain Chain
+	for _, hc := range cfg.Hooks {
+		h, err := NewCommand(hc)
+		if err != nil {
+			return nil, err
+		}
+		if hc.IgnoreErrors {
+			chain = append(chain, IgnoreErrors(h))
+		} else {
+			chain = append(chain, h)
+		}
+	}
+	return chain, nil
+}
+
+// ignoreErrors is a Hook that logs the errors of its hook instead of returning them.
+type ignoreErrors struct {
+	Hook
+}
+
+func (h ignoreErrors) BeforeAnalysis(ctx context.Context, e *email.ParsedEmail) error {
+	if err := h.Hook.BeforeAnalysis(ctx, e); err != nil {
+		log.Printf("ERROR: %v (ignored)", err)
+	}
+	return nil
+}
+
+func (h ignoreErrors) AfterAnalysis(ctx context.Context, e *email.ParsedEmail, j *llm.Judgment) error {
+	if err := h.Hook.AfterAnalysis(ctx, e, j); err != nil {
+		log.Printf("ERROR: %v (ignored)", err)
+	}
+	return nil
+}
+
+// IgnoreErrors returns a Hook that logs the errors of h instead of failing the analysis
+// of the message.
+func IgnoreErrors(h Hook) Hook {
+	return ignoreErrors{Hook: h}
+}
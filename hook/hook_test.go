@@ -0,0 +1,207 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// writeScript writes a shell script that runs body with the document on stdin.
+func writeScript(t *testing.T, body string) []string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hook.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"/bin/sh", path}
+}
+
+func parse(t *testing.T) *email.ParsedEmail {
+	t.Helper()
+	raw := "From: Alice <alice@example.com>\r\nTo: bob@example.net\r\nSubject: Invoice\r\nX-Spam-Score: 1\r\n\r\nPay at https://pay.example.org/\r\n"
+	e, err := email.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCommand_BeforeAnalysis(t *testing.T) {
+	t.Run("Changed message", func(t *testing.T) {
+		// sed stands in for an enrichment service: it rewrites the subject and adds a header.
+		c, err := NewCommand(config.Hook{Stage: config.HookBefore, Command: writeScript(t,
+			`sed -e 's/"subject":"Invoice"/"subject":"[External] Invoice"/' -e 's/"headers":\[/"headers":[{"name":"X-Reputation","value":"bad"},/'`)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := parse(t)
+		if err := c.BeforeAnalysis(context.Background(), e); err != nil {
+			t.Fatalf("BeforeAnalysis() error = %v", err)
+		}
+		if e.Subject != "[External] Invoice" {
+			t.Errorf("Subject = %q", e.Subject)
+		}
+		if got := e.Header.Get("X-Reputation"); got != "bad" {
+			t.Errorf("X-Reputation = %q, want bad", got)
+		}
+		if got := e.Header.Get("X-Spam-Score"); got != "1" {
+			t.Errorf("X-Spam-Score = %q, want the header to be kept", got)
+		}
+		if len(e.From) != 1 || e.From[0].Address != "alice@example.com" || len(e.URLs) != 1 {
+			t.Errorf("From = %v, URLs = %v, want them unchanged", e.From, e.URLs)
+		}
+	})
+
+	t.Run("Partial output", func(t *testing.T) {
+		c, _ := NewCommand(config.Hook{Stage: config.HookBefore, Command: writeScript(t, `cat > /dev/null; echo '{"body": "Replaced"}'`)})
+		e := parse(t)
+		if err := c.BeforeAnalysis(context.Background(), e); err != nil {
+			t.Fatalf("BeforeAnalysis() error = %v", err)
+		}
+		if e.Body != "Replaced" || e.Subject != "Invoice" {
+			t.Errorf("Body = %q, Subject = %q", e.Body, e.Subject)
+		}
+	})
+
+	t.Run("No output", func(t *testing.T) {
+		c, _ := NewCommand(config.Hook{Stage: config.HookBefore, Command: writeScript(t, `cat > /dev/null`)})
+		e := parse(t)
+		if err := c.BeforeAnalysis(context.Background(), e); err != nil {
+			t.Fatalf("BeforeAnalysis() error = %v", err)
+		}
+		if e.Subject != "Invoice" {
+			t.Errorf("Subject = %q", e.Subject)
+		}
+	})
+
+	for name, script := range map[string]string{
+		"Failure":        `echo 'reputation service unavailable' >&2; exit 1`,
+		"Invalid output": `echo 'not json'`,
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, _ := NewCommand(config.Hook{Stage: config.HookBefore, Command: writeScript(t, script)})
+			e := parse(t)
+			if err := c.BeforeAnalysis(context.Background(), e); err == nil {
+				t.Fatal("BeforeAnalysis() error = nil")
+			}
+			if e.Subject != "Invoice" {
+				t.Errorf("Subject = %q, want the message unchanged", e.Subject)
+			}
+		})
+	}
+}
+
+func TestCommand_AfterAnalysis(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    llm.Judgment
+		wantErr bool
+	}{
+		{
+			name:   "Changed judgment",
+			script: `grep -q '"email":{"message_id"' && echo '{"is_suspicious": true, "category": "Phishing", "reason": "Known bad domain.", "confidence_score": 1}'`,
+			want:   llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Known bad domain.", ConfidenceScore: 1},
+		},
+		{
+			name:   "Partial output",
+			script: `cat > /dev/null; echo '{"confidence_score": 0.2}'`,
+			want:   llm.Judgment{Category: "Safe", Reason: "Looks fine.", ConfidenceScore: 0.2},
+		},
+		{
+			name:   "No output",
+			script: `cat > /dev/null`,
+			want:   llm.Judgment{Category: "Safe", Reason: "Looks fine.", ConfidenceScore: 0.7},
+		},
+		{
+			name:    "No category",
+			script:  `cat > /dev/null; echo '{"category": ""}'`,
+			want:    llm.Judgment{Category: "Safe", Reason: "Looks fine.", ConfidenceScore: 0.7},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCommand(config.Hook{Stage: config.HookAfter, Command: writeScript(t, tt.script)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			j := &llm.Judgment{Category: "Safe", Reason: "Looks fine.", ConfidenceScore: 0.7}
+			if err := c.AfterAnalysis(context.Background(), parse(t), j); (err != nil) != tt.wantErr {
+				t.Fatalf("AfterAnalysis() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(*j, tt.want) {
+				t.Errorf("judgment = %+v, want %+v", *j, tt.want)
+			}
+		})
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	failing := writeScript(t, `exit 1`)
+	cfg := &config.Config{Hooks: []config.Hook{
+		{Stage: config.HookAfter, Command: failing, IgnoreErrors: true},
+		{Stage: config.HookAfter, Command: writeScript(t, `cat > /dev/null; echo '{"category": "Spam"}'`)},
+	}}
+	chain, err := FromConfig(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The before stage does not run the after hooks.
+	if err := chain.BeforeAnalysis(context.Background(), parse(t)); err != nil {
+		t.Errorf("BeforeAnalysis() error = %v", err)
+	}
+	j := &llm.Judgment{Category: "Safe"}
+	if err := chain.AfterAnalysis(context.Background(), parse(t), j); err != nil {
+		t.Fatalf("AfterAnalysis() error = %v", err)
+	}
+	if j.Category != "Spam" {
+		t.Errorf("Category = %q, want Spam", j.Category)
+	}
+
+	chain, _ = FromConfig(&config.Config{Hooks: []config.Hook{{Stage: config.HookAfter, Command: failing}}})
+	if err := chain.AfterAnalysis(context.Background(), parse(t), j); err == nil {
+		t.Error("AfterAnalysis() error = nil, want the error of the hook")
+	}
+	if _, err := FromConfig(&config.Config{Hooks: []config.Hook{{Stage: "during", Command: failing}}}); err == nil {
+		t.Error("FromConfig() with an unknown stage: error = nil")
+	}
+}
+
+func TestFuncs(t *testing.T) {
+	errStop := errors.New("stop")
+	var calls []string
+	chain := Chain{
+		Funcs{Before: func(ctx context.Context, e *email.ParsedEmail) error {
+			calls = append(calls, "first")
+			e.Subject = "Enriched"
+			return nil
+		}},
+		Funcs{Before: func(ctx context.Context, e *email.ParsedEmail) error {
+			calls = append(calls, "second")
+			return errStop
+		}},
+		Funcs{Before: func(ctx context.Context, e *email.ParsedEmail) error {
+			calls = append(calls, "third")
+			return nil
+		}},
+	}
+	e := parse(t)
+	if err := chain.BeforeAnalysis(context.Background(), e); !errors.Is(err, errStop) {
+		t.Errorf("BeforeAnalysis() error = %v, want %v", err, errStop)
+	}
+	if strings.Join(calls, ",") != "first,second" || e.Subject != "Enriched" {
+		t.Errorf("calls = %v, Subject = %q", calls, e.Subject)
+	}
+	if err := chain.AfterAnalysis(context.Background(), e, &llm.Judgment{}); err != nil {
+		t.Errorf("AfterAnalysis() of hooks without After: error = %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+)
+
+func TestNew_Timeouts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		cfg     *config.Config
+		wantErr bool
+	}{
+		{
+			name: "No limits",
+			cfg:  &config.Config{},
+		},
+		{
+			name:    "Response header timeout exceeded",
+			cfg:     &config.Config{ResponseHeaderTimeout: config.Duration(50 * time.Millisecond)},
+			wantErr: true,
+		},
+		{
+			name:    "Request timeout exceeded",
+			cfg:     &config.Config{RequestTimeout: config.Duration(50 * time.Millisecond)},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(tt.cfg)
+			if err != nil {
+				t.Fatalf("New() error = %v", err)
+			}
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNew_Proxy(t *testing.T) {
+	tests := []struct {
+		name      string
+		cfg       *config.Config
+		target    string
+		wantProxy string
+		wantErr   bool
+	}{
+		{
+			name:      "Explicit HTTP proxy",
+			cfg:       &config.Config{ProxyURL: "http://proxy.internal:3128"},
+			target:    "https://api.openai.com/v1/chat/completions",
+			wantProxy: "http://proxy.internal:3128",
+		},
+		{
+			name:      "Explicit SOCKS5 proxy",
+			cfg:       &config.Config{ProxyURL: "socks5://proxy.internal:1080"},
+			target:    "https://api.openai.com/v1/chat/completions",
+			wantProxy: "socks5://proxy.internal:1080",
+		},
+		{
+			name:   "Host excluded by no_proxy",
+			cfg:    &config.Config{ProxyURL: "http://proxy.internal:3128", NoProxy: "llm.corp.example,.internal.example"},
+			target: "http://gw.internal.example/v1/chat/completions",
+		},
+		{
+			name:    "Unsupported scheme",
+			cfg:     &config.Config{ProxyURL: "ftp://proxy.internal"},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+
+			req, _ := http.NewRequest("POST", tt.target, nil)
+			proxy, err := client.Transport.(*http.Transport).Proxy(req)
+			if err != nil {
+				t.Fatalf("Proxy() error = %v", err)
+			}
+			got := ""
+			if proxy != nil {
+				got = proxy.String()
+			}
+			if got != tt.wantProxy {
+				t.Errorf("Proxy() = %q, want %q", got, tt.wantProxy)
+			}
+		})
+	}
+}
+
+func TestNew_TLS(t *testing.T) {
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.TLS.PeerCertificates) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	server.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	server.StartTLS()
+	defer server.Close()
+
+	// The test server's own certificate doubles as the private CA and the client certificate.
+	dir := t.TempDir()
+	serverCert := server.TLS.Certificates[0]
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	keyDER, err := x509.MarshalPKCS8PrivateKey(serverCert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: serverCert.Certificate[0]}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600)
+
+	tests := []struct {
+		name       string
+		cfg        *config.Config
+		wantStatus int
+		wantErr    bool
+		wantNewErr bool
+	}{
+		{
+			name:    "Untrusted server certificate",
+			cfg:     &config.Config{},
+			wantErr: true,
+		},
+		{
+			name:       "Custom CA without client certificate",
+			cfg:        &config.Config{CACertFile: certFile},
+			wantStatus: http.StatusUnauthorized,
+		},
+		{
+			name:       "Custom CA with client certificate",
+			cfg:        &config.Config{CACertFile: certFile, ClientCertFile: certFile, ClientKeyFile: keyFile},
+			wantStatus: http.StatusOK,
+		},
+		{
+			name:       "Client certificate without key",
+			cfg:        &config.Config{ClientCertFile: certFile},
+			wantNewErr: true,
+		},
+		{
+			name:       "CA file without certificates",
+			cfg:        &config.Config{CACertFile: keyFile},
+			wantNewErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client, err := New(tt.cfg)
+			if (err != nil) != tt.wantNewErr {
+				t.Fatalf("New() error = %v, wantErr %v", err, tt.wantNewErr)
+			}
+			if err != nil {
+				return
+			}
+			resp, err := client.Get(server.URL)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("Get() status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+		})
+	}
+}
@@ -0,0 +1,266 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha1"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-analyzer/resultdb"
+)
+
+// Types of the indicators exported by iocs, in the order they are listed.
+var iocTypes = []string{"domain", "url", "sha256"}
+
+// ioc is an indicator of compromise aggregated over the stored analyses.
+type ioc struct {
+	Type  string
+	Value string
+	// Messages is the number of analyses with the indicator, and Suspicious the number
+	// of them judged suspicious.
+	Messages   int
+	Suspicious int
+	FirstSeen  time.Time
+	LastSeen   time.Time
+	Categories []string
+	// Confidence is the highest confidence score of the judgments.
+	Confidence float64
+}
+
+// runIOCs implements the "iocs" subcommand, which exports the indicators of stored
+// analyses for blocklists.
+func runIOCs(args []string, stdout io.Writer) error {
+	fs := newFlagSet("iocs", "", "Export the domains, URLs and attachment hashes of the messages in the results database\n"+
+		"given with --db, or the PostgreSQL database in postgres_dsn, for firewalls and secure web\n"+
+		"gateways. Only the messages judged suspicious are exported unless --all is given.")
+	dbPath := fs.String("db", "", "SQLite results database to export from")
+	configPath := fs.String("config", "", "Configuration file with postgres_dsn, used when --db is not given")
+	since := fs.String("since", "", "Only export messages analyzed since a date (2006-01-02), timestamp (RFC 3339) or duration ago (24h)")
+	until := fs.String("until", "", "Only export messages analyzed before a date, timestamp or duration ago")
+	category := fs.String("category", "", "Only export messages of this category")
+	minConfidence := fs.Float64("min-confidence", 0, "Only export messages judged with at least this confidence score")
+	all := fs.Bool("all", false, "Export the messages not judged suspicious too")
+	types := fs.String("type", strings.Join(iocTypes, ","), "Comma-separated types of indicators to export: domain, url and sha256")
+	format := fs.String("format", "csv", "Output format: csv, stix (a STIX 2.1 bundle) or blocklist (one value per line)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	var selected []string
+	for _, t := range strings.Split(*types, ",") {
+		t = strings.TrimSpace(t)
+		if !slices.Contains(iocTypes, t) {
+			return usageErrorf("invalid --type %q: use domain, url or sha256", t)
+		}
+		selected = append(selected, t)
+	}
+	write, ok := iocFormats[*format]
+	if !ok {
+		return usageErrorf("invalid --format %q: use csv, stix or blocklist", *format)
+	}
+
+	filter := resultdb.Filter{Category: *category, MinConfidence: *minConfidence}
+	if !*all {
+		suspicious := true
+		filter.Suspicious = &suspicious
+	}
+	now := time.Now()
+	var err error
+	if *since != "" {
+		if filter.Since, err = parseSince(*since, now); err != nil {
+			return err
+		}
+	}
+	if *until != "" {
+		if filter.Until, err = parseSince(*until, now); err != nil {
+			return fmt.Errorf("invalid --until value %q", *until)
+		}
+	}
+
+	db, err := openQueryDB(*dbPath, *configPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	records, err := db.Query(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+	return write(stdout, aggregateIOCs(records, selected))
+}
+
+// aggregateIOCs returns the indicators of the types among those of records, sorted by
+// type and value.
+func aggregateIOCs(records []resultdb.Record, types []string) []*ioc {
+	byKey := map[[2]string]*ioc{}
+	var iocs []*ioc
+	for _, r := range records {
+		var values [][2]string
+		for _, u := range r.URLs {
+			values = append(values, [2]string{"url", u})
+			// A message may have the same domain in several URLs, and hosts that are IP
+			// addresses have none.
+			domain := strings.TrimSuffix(urlDomain(u), ".")
+			if _, err := netip.ParseAddr(domain); err != nil && domain != "" && !slices.Contains(values, [2]string{"domain", domain}) {
+				values = append(values, [2]string{"domain", domain})
+			}
+		}
+		for _, h := range r.Hashes {
+			values = append(values, [2]string{"sha256", h})
+		}
+		seen := r.ReceivedAt
+		if seen.IsZero() {
+			seen = r.AnalyzedAt
+		}
+		for _, key := range values {
+			if !slices.Contains(types, key[0]) {
+				continue
+			}
+			i := byKey[key]
+			if i == nil {
+				i = &ioc{Type: key[0], Value: key[1], FirstSeen: seen, LastSeen: seen}
+				byKey[key] = i
+				iocs = append(iocs, i)
+			}
+			i.Messages++
+			if r.Judgment.IsSuspicious {
+				i.Suspicious++
+			}
+			if seen.Before(i.FirstSeen) {
+				i.FirstSeen = seen
+			}
+			if seen.After(i.LastSeen) {
+				i.LastSeen = seen
+			}
+			if c := r.Judgment.Category; c != "" && !slices.Contains(i.Categories, c) {
+				i.Categories = append(i.Categories, c)
+			}
+			i.Confidence = max(i.Confidence, r.Judgment.ConfidenceScore)
+		}
+	}
+	for _, i := range iocs {
+		slices.Sort(i.Categories)
+	}
+	slices.SortFunc(iocs, func(a, b *ioc) int {
+		return cmp.Or(cmp.Compare(slices.Index(iocTypes, a.Type), slices.Index(iocTypes, b.Type)), strings.Compare(a.Value, b.Value))
+	})
+	return iocs
+}
+
+// iocFormats write indicators in the formats of the --format flag of iocs.
+var iocFormats = map[string]func(io.Writer, []*ioc) error{
+	"csv":       writeIOCsCSV,
+	"stix":      writeIOCsSTIX,
+	"blocklist": writeIOCsBlocklist,
+}
+
+func writeIOCsCSV(w io.Writer, iocs []*ioc) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"type", "value", "messages", "suspicious", "first_seen", "last_seen", "categories", "confidence"})
+	for _, i := range iocs {
+		cw.Write([]string{
+			i.Type, i.Value, strconv.Itoa(i.Messages), strconv.Itoa(i.Suspicious),
+			i.FirstSeen.UTC().Format(time.RFC3339), i.LastSeen.UTC().Format(time.RFC3339),
+			strings.Join(i.Categories, ";"), strconv.FormatFloat(i.Confidence, 'f', 2, 64),
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeIOCsBlocklist writes the values of the indicators, one per line, as read by
+// firewalls and proxies from a URL or a file.
+func writeIOCsBlocklist(w io.Writer, iocs []*ioc) error {
+	for _, i := range iocs {
+		if _, err := fmt.Fprintln(w, i.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// STIX 2.1 objects, limited to the properties written by the stix format.
+type stixBundle struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id"`
+	Objects []stixIndicator `json:"objects"`
+}
+
+type stixIndicator struct {
+	Type           string   `json:"type"`
+	SpecVersion    string   `json:"spec_version"`
+	ID             string   `json:"id"`
+	Created        string   `json:"created"`
+	Modified       string   `json:"modified"`
+	Name           string   `json:"name"`
+	Description    string   `json:"description"`
+	IndicatorTypes []string `json:"indicator_types"`
+	Pattern        string   `json:"pattern"`
+	PatternType    string   `json:"pattern_type"`
+	ValidFrom      string   `json:"valid_from"`
+	Confidence     int      `json:"confidence"`
+	Labels         []string `json:"labels,omitempty"`
+}
+
+// stixPatterns are the STIX patterns of the types of indicators, for the escaped value.
+var stixPatterns = map[string]string{
+	"domain": "[domain-name:value = '%s']",
+	"url":    "[url:value = '%s']",
+	"sha256": "[file:hashes.'SHA-256' = '%s']",
+}
+
+// stixNamespace is the namespace of the UUIDs of the indicators, which are derived from
+// their values so that an indicator keeps its ID in every export.
+var stixNamespace = [16]byte{0x6b, 0x1e, 0x3a, 0x4c, 0x92, 0x0d, 0x4f, 0x5e, 0x8a, 0x27, 0x3c, 0x61, 0xd5, 0x08, 0xe4, 0x9f}
+
+// writeIOCsSTIX writes the indicators as a STIX 2.1 bundle, for threat intelligence
+// platforms and TAXII servers.
+func writeIOCsSTIX(w io.Writer, iocs []*ioc) error {
+	const stixTime = "2006-01-02T15:04:05.000Z"
+	bundle := stixBundle{Type: "bundle", ID: "bundle--" + newAnalysisID(), Objects: []stixIndicator{}}
+	escape := strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+	for _, i := range iocs {
+		indicatorType := "unknown"
+		if i.Suspicious > 0 {
+			indicatorType = "malicious-activity"
+		}
+		bundle.Objects = append(bundle.Objects, stixIndicator{
+			Type:           "indicator",
+			SpecVersion:    "2.1",
+			ID:             "indicator--" + uuid5(stixNamespace, i.Type+":"+i.Value),
+			Created:        i.FirstSeen.UTC().Format(stixTime),
+			Modified:       i.LastSeen.UTC().Format(stixTime),
+			Name:           i.Value,
+			Description:    fmt.Sprintf("Seen in %d messages analyzed by mail-analyzer, %d of them judged suspicious.", i.Messages, i.Suspicious),
+			IndicatorTypes: []string{indicatorType},
+			Pattern:        fmt.Sprintf(stixPatterns[i.Type], escape.Replace(i.Value)),
+			PatternType:    "stix",
+			ValidFrom:      i.FirstSeen.UTC().Format(stixTime),
+			Confidence:     int(math.Round(i.Confidence * 100)),
+			Labels:         i.Categories,
+		})
+	}
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	return enc.Encode(bundle)
+}
+
+// uuid5 returns the name-based UUID (version 5, RFC 9562) of name in namespace.
+func uuid5(namespace [16]byte, name string) string {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+	b := h.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
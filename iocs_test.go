@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/llm"
+	"mail-analyzer/resultdb"
+	"mail-analyzer/sink"
+)
+
+func TestRunIOCs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	db, err := resultdb.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	base := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	hash := strings.Repeat("ab", 32)
+	for _, r := range []*sink.Result{
+		{MessageID: "<1@example.com>", URLs: []string{"https://login.Evil.example/verify?u=1", "https://login.evil.example/help"}, Hashes: []string{hash},
+			Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.9}, ReceivedAt: base, AnalyzedAt: base},
+		{MessageID: "<2@example.com>", URLs: []string{"https://login.evil.example/help", "http://198.51.100.7/payload"},
+			Judgment: &llm.Judgment{IsSuspicious: true, Category: "Malware", ConfidenceScore: 0.6}, ReceivedAt: base.Add(time.Hour), AnalyzedAt: base.Add(time.Hour)},
+		{MessageID: "<3@example.com>", URLs: []string{"https://www.example.com/"},
+			Judgment: &llm.Judgment{Category: "Safe", ConfidenceScore: 0.9}, ReceivedAt: base.Add(2 * time.Hour), AnalyzedAt: base.Add(2 * time.Hour)},
+	} {
+		if err := db.Send(context.Background(), r); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	db.Close()
+
+	var out bytes.Buffer
+	if err := runIOCs([]string{"--db", path}, &out); err != nil {
+		t.Fatalf("runIOCs() error = %v", err)
+	}
+	// IP addresses are not domains.
+	want := `type,value,messages,suspicious,first_seen,last_seen,categories,confidence
+domain,login.evil.example,2,2,2025-07-01T12:00:00Z,2025-07-01T13:00:00Z,Malware;Phishing,0.90
+url,http://198.51.100.7/payload,1,1,2025-07-01T13:00:00Z,2025-07-01T13:00:00Z,Malware,0.60
+url,https://login.Evil.example/verify?u=1,1,1,2025-07-01T12:00:00Z,2025-07-01T12:00:00Z,Phishing,0.90
+url,https://login.evil.example/help,2,2,2025-07-01T12:00:00Z,2025-07-01T13:00:00Z,Malware;Phishing,0.90
+sha256,` + hash + `,1,1,2025-07-01T12:00:00Z,2025-07-01T12:00:00Z,Phishing,0.90
+`
+	if out.String() != want {
+		t.Errorf("CSV output:\n%s\nwant:\n%s", out.String(), want)
+	}
+
+	out.Reset()
+	if err := runIOCs([]string{"--db", path, "--all", "--type", "domain", "--format", "blocklist"}, &out); err != nil {
+		t.Fatalf("runIOCs() error = %v", err)
+	}
+	if got, want := out.String(), "login.evil.example\nwww.example.com\n"; got != want {
+		t.Errorf("blocklist output = %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := runIOCs([]string{"--db", path, "--type", "sha256", "--min-confidence", "0.8", "--until", "2025-07-01T12:30:00Z", "--format", "stix"}, &out); err != nil {
+		t.Fatalf("runIOCs() error = %v", err)
+	}
+	var bundle stixBundle
+	if err := json.Unmarshal(out.Bytes(), &bundle); err != nil {
+		t.Fatalf("invalid STIX bundle: %v", err)
+	}
+	if len(bundle.Objects) != 1 {
+		t.Fatalf("STIX bundle has %d objects, want 1:\n%s", len(bundle.Objects), out.String())
+	}
+	indicator := bundle.Objects[0]
+	if indicator.Pattern != "[file:hashes.'SHA-256' = '"+hash+"']" || indicator.Confidence != 90 || indicator.ValidFrom != "2025-07-01T12:00:00.000Z" ||
+		indicator.ID != "indicator--"+uuid5(stixNamespace, "sha256:"+hash) || !strings.HasPrefix(bundle.ID, "bundle--") {
+		t.Errorf("unexpected STIX indicator %+v", indicator)
+	}
+
+	for _, args := range [][]string{{"--type", "ip"}, {"--format", "xml"}} {
+		if err := runIOCs(append([]string{"--db", path}, args...), &out); err == nil {
+			t.Errorf("runIOCs(%v) error = nil, want an error", args)
+		}
+	}
+}
+
+func TestUUID5(t *testing.T) {
+	// The example of RFC 9562, Appendix A.4: "www.example.com" in the DNS namespace.
+	dns := [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+	if got, want := uuid5(dns, "www.example.com"), "2ed6657d-e927-568b-95e1-2665a8aea6a2"; got != want {
+		t.Errorf("uuid5() = %s, want %s", got, want)
+	}
+}
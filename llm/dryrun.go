@@ -0,0 +1,69 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrDryRun is returned instead of a judgment by a provider in dry-run mode.
+var ErrDryRun = errors.New("dry run: request not sent")
+
+// charsPerToken is the rough number of characters per token used to estimate prompt sizes.
+const charsPerToken = 4
+
+// SetDryRun makes the provider write every chat completion request to w, in a readable
+// form, and return ErrDryRun instead of sending it.
+func (p *OpenAIProvider) SetDryRun(w io.Writer) {
+	p.dryRun = w
+}
+
+// writeDryRun writes apiRequest as it would be sent: the endpoint and model, every
+// message with its text in full, and the tool schema.
+func (p *OpenAIProvider) writeDryRun(apiRequest APIRequest) error {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Provider: OpenAI-compatible chat completions API\n")
+	fmt.Fprintf(&b, "Endpoint: %s\n", p.baseURL)
+	fmt.Fprintf(&b, "Model:    %s\n", apiRequest.Model)
+	fmt.Fprintf(&b, "Stream:   %t\n", apiRequest.Stream)
+	if apiRequest.ToolChoice != nil {
+		fmt.Fprintf(&b, "Tool choice: %v\n", apiRequest.ToolChoice)
+	}
+
+	chars := 0
+	for _, m := range apiRequest.Messages {
+		fmt.Fprintf(&b, "\n--- %s message ---\n", m.Role)
+		if len(m.Parts) == 0 {
+			b.WriteString(m.Content)
+			b.WriteString("\n")
+			chars += len(m.Content)
+			continue
+		}
+		for _, part := range m.Parts {
+			switch {
+			case part.Type == "text":
+				b.WriteString(part.Text)
+				b.WriteString("\n")
+				chars += len(part.Text)
+			case part.ImageURL != nil:
+				// The image data is replaced by its size, as it is of no use when reading the prompt.
+				mediaType, _, _ := strings.Cut(strings.TrimPrefix(part.ImageURL.URL, "data:"), ";")
+				fmt.Fprintf(&b, "[image: %s, %d bytes of data URL]\n", mediaType, len(part.ImageURL.URL))
+			}
+		}
+	}
+
+	tools, err := json.MarshalIndent(apiRequest.Tools, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&b, "\n--- tools ---\n%s\n", tools)
+	chars += len(tools)
+	fmt.Fprintf(&b, "\nEstimated prompt tokens: %d (about %d characters per token, images not included)\n", chars/charsPerToken, charsPerToken)
+
+	_, err = p.dryRun.Write(b.Bytes())
+	return err
+}
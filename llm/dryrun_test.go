@@ -0,0 +1,43 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestDryRun(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("a request was sent in dry-run mode")
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL, ChatCompletionsPath: "/chat/completions", ModelName: "gpt-test"})
+	var out bytes.Buffer
+	provider.SetDryRun(&out)
+
+	tools := []APITool{{Type: "function", Function: APIFunctionDef{Name: "report_analysis_result"}}}
+	parts := []ContentPart{TextPart("Analyze this email."), ImageDataPart("image/png", []byte("png"))}
+	_, err := provider.AnalyzeContent(context.Background(), parts, tools, "auto")
+	if !errors.Is(err, ErrDryRun) {
+		t.Fatalf("AnalyzeContent() error = %v, want ErrDryRun", err)
+	}
+	for _, want := range []string{
+		"Endpoint: " + server.URL + "/chat/completions\n",
+		"Model:    gpt-test\n",
+		"--- system message ---\n" + SystemPrompt + "\n",
+		"--- user message ---\nAnalyze this email.\n[image: image/png, ",
+		`"name": "report_analysis_result"`,
+		"Estimated prompt tokens: ",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("dry-run output does not contain %q:\n%s", want, out.String())
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// EmbeddingRequest is the request body of the OpenAI-compatible embeddings API.
+type EmbeddingRequest struct {
+	Model string   `json:"model"`
+	Input []string `json:"input"`
+}
+
+// EmbeddingResponse is the response body of the OpenAI-compatible embeddings API.
+type EmbeddingResponse struct {
+	Data  []Embedding `json:"data"`
+	Usage *Usage      `json:"usage,omitempty"`
+	Error *APIError   `json:"error,omitempty"`
+}
+
+type Embedding struct {
+	Index     int       `json:"index"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// Embed returns one embedding vector per input text, using the configured embedding model.
+func (p *OpenAIProvider) Embed(ctx context.Context, texts []string) ([][]float64, error) {
+	if p.config.EmbeddingModel == "" {
+		return nil, errors.New("no embedding model configured")
+	}
+	reqBody, err := json.Marshal(EmbeddingRequest{Model: p.config.EmbeddingModel, Input: texts})
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal embedding request: %w", err)
+	}
+
+	if err := p.inFlight.acquire(ctx); err != nil {
+		return nil, fmt.Errorf("waiting for a free request slot: %w", err)
+	}
+	defer p.inFlight.release()
+
+	url := embeddingsURL(p.config.OpenAIBaseURL, p.config.ChatCompletionsPath)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("could not create HTTP request: %w", err)
+	}
+	p.setHeaders(req)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read API response body: %w", err)
+	}
+	var embeddingResponse EmbeddingResponse
+	if err := json.Unmarshal(respBody, &embeddingResponse); err != nil {
+		return nil, responseError(resp, nil, fmt.Errorf("could not decode embedding response: %w", err))
+	}
+	if embeddingResponse.Error != nil {
+		return nil, responseError(resp, embeddingResponse.Error, fmt.Errorf("API error: [%s] %s", embeddingResponse.Error.Code, embeddingResponse.Error.Message))
+	}
+
+	vectors := make([][]float64, len(texts))
+	for _, d := range embeddingResponse.Data {
+		if d.Index < 0 || d.Index >= len(vectors) {
+			return nil, fmt.Errorf("embedding response has out-of-range index %d", d.Index)
+		}
+		vectors[d.Index] = d.Embedding
+	}
+	for i, v := range vectors {
+		if len(v) == 0 {
+			return nil, fmt.Errorf("embedding response is missing input %d", i)
+		}
+	}
+	return vectors, nil
+}
+
+// embeddingsURL returns the embeddings endpoint that sits next to the chat completions
+// endpoint, so a base URL configured as the full chat completions URL also works.
+func embeddingsURL(baseURL, chatPath string) string {
+	return siblingURL(baseURL, chatPath, "/embeddings")
+}
+
+// siblingURL returns the API endpoint path next to the chat completions endpoint.
+func siblingURL(baseURL, chatPath, path string) string {
+	base := strings.TrimRight(baseURL, "/")
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	if chatPath != "" {
+		if !strings.HasPrefix(chatPath, "/") {
+			chatPath = "/" + chatPath
+		}
+		base = strings.TrimSuffix(base, chatPath)
+	}
+	return base + path
+}
@@ -0,0 +1,59 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestOpenAIProvider_Embed(t *testing.T) {
+	var req EmbeddingRequest
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		json.NewDecoder(r.Body).Decode(&req)
+		// Results may arrive out of order; the index decides the position.
+		fmt.Fprint(w, `{"data": [{"index": 1, "embedding": [0, 1]}, {"index": 0, "embedding": [1, 0]}]}`)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: server.URL + "/v1", ChatCompletionsPath: "/chat/completions", EmbeddingModel: "test-embed"}
+	got, err := NewOpenAIProvider(cfg).Embed(context.Background(), []string{"first", "second"})
+	if err != nil {
+		t.Fatalf("Embed() error = %v", err)
+	}
+	if path != "/v1/embeddings" {
+		t.Errorf("request path = %s, want /v1/embeddings", path)
+	}
+	if req.Model != "test-embed" || !reflect.DeepEqual(req.Input, []string{"first", "second"}) {
+		t.Errorf("unexpected request: %+v", req)
+	}
+	if want := [][]float64{{1, 0}, {0, 1}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Embed() = %v, want %v", got, want)
+	}
+
+	if _, err := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL}).Embed(context.Background(), []string{"x"}); err == nil {
+		t.Error("expected an error without an embedding model")
+	}
+}
+
+func TestEmbeddingsURL(t *testing.T) {
+	tests := []struct {
+		base, path, want string
+	}{
+		{"http://localhost:11434/v1", "/chat/completions", "http://localhost:11434/v1/embeddings"},
+		{"http://localhost:11434/v1/chat/completions", "/chat/completions", "http://localhost:11434/v1/embeddings"},
+		{"", "/chat/completions", "https://api.openai.com/v1/embeddings"},
+	}
+	for _, tt := range tests {
+		if got := embeddingsURL(tt.base, tt.path); got != tt.want {
+			t.Errorf("embeddingsURL(%q, %q) = %q, want %q", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Failure classes of requests to the endpoint. Errors of the provider wrap one of them when
+// the cause is known, so that callers can tell them apart with errors.Is.
+var (
+	// ErrProviderUnavailable means that the endpoint could not be reached, failed with a
+	// server error or stopped responding. Retrying later may succeed.
+	ErrProviderUnavailable = errors.New("LLM provider unavailable")
+	// ErrRateLimited means that the endpoint refused the request because a rate limit or
+	// quota was exceeded. RetryAfter returns the wait it asked for, if any.
+	ErrRateLimited = errors.New("rate limited by the LLM provider")
+	// ErrBadModelOutput means that the response of the model contained no judgment that
+	// could be parsed, even after the corrective retry.
+	ErrBadModelOutput = errors.New("unusable model output")
+)
+
+// classError is an error of a failure class. Its message is that of the underlying error.
+type classError struct {
+	class error
+	err   error
+	// retryAfter is the wait requested by the endpoint, or 0.
+	retryAfter time.Duration
+}
+
+func (e *classError) Error() string   { return e.err.Error() }
+func (e *classError) Unwrap() []error { return []error{e.class, e.err} }
+
+// withClass returns err as an error of class.
+func withClass(class, err error) error {
+	return &classError{class: class, err: err}
+}
+
+// requestError returns the error of a request that got no response. It is of class
+// ErrProviderUnavailable unless ctx was cancelled, which is not a failure of the endpoint.
+func requestError(ctx context.Context, err error) error {
+	err = fmt.Errorf("HTTP request failed: %w", err)
+	if ctx.Err() != nil {
+		return err
+	}
+	return withClass(ErrProviderUnavailable, err)
+}
+
+// RetryAfter returns the wait before retrying that the endpoint requested with the
+// Retry-After header of a failed response, or 0 if err has none.
+func RetryAfter(err error) time.Duration {
+	var ce *classError
+	if errors.As(err, &ce) {
+		return ce.retryAfter
+	}
+	return 0
+}
+
+// responseError classifies err, the error of a response, by the status of the response and
+// the code of the API error, if any. resp is nil for an error sent in a stream.
+func responseError(resp *http.Response, apiErr *APIError, err error) error {
+	var status int
+	var retryAfter string
+	if resp != nil {
+		status, retryAfter = resp.StatusCode, resp.Header.Get("Retry-After")
+	}
+	var class error
+	switch {
+	case status == http.StatusTooManyRequests,
+		apiErr != nil && (apiErr.Code == "rate_limit_exceeded" || apiErr.Type == "rate_limit_error"):
+		class = ErrRateLimited
+	case status >= 500, status == http.StatusRequestTimeout:
+		class = ErrProviderUnavailable
+	default:
+		return err
+	}
+	return &classError{class: class, err: err, retryAfter: parseRetryAfter(retryAfter)}
+}
+
+// parseRetryAfter returns the wait of a Retry-After header, which is either a number of
+// seconds or a date.
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,122 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+)
+
+func TestErrorClasses(t *testing.T) {
+	tests := []struct {
+		name           string
+		status         int
+		header         http.Header
+		body           string
+		wantClass      error
+		wantRetryAfter time.Duration
+	}{
+		{
+			name:           "Rate limited",
+			status:         http.StatusTooManyRequests,
+			header:         http.Header{"Retry-After": {"7"}},
+			body:           `{"error": {"message": "Slow down", "code": "rate_limit_exceeded"}}`,
+			wantClass:      ErrRateLimited,
+			wantRetryAfter: 7 * time.Second,
+		},
+		{
+			name:      "Quota in the error code",
+			status:    http.StatusForbidden,
+			body:      `{"error": {"message": "Quota exceeded", "type": "rate_limit_error"}}`,
+			wantClass: ErrRateLimited,
+		},
+		{
+			name:      "Server error page",
+			status:    http.StatusBadGateway,
+			body:      "<html>Bad Gateway</html>",
+			wantClass: ErrProviderUnavailable,
+		},
+		{
+			name:      "Overloaded",
+			status:    http.StatusServiceUnavailable,
+			body:      `{"error": {"message": "Overloaded"}}`,
+			wantClass: ErrProviderUnavailable,
+		},
+		{
+			name:      "Unparsable output",
+			status:    http.StatusOK,
+			body:      `{"choices": [{"message": {"content": "I cannot help with that."}}]}`,
+			wantClass: ErrBadModelOutput,
+		},
+		{
+			name:   "Invalid API key",
+			status: http.StatusUnauthorized,
+			body:   `{"error": {"message": "Incorrect API key", "code": "invalid_api_key"}}`,
+		},
+	}
+	classes := []error{ErrProviderUnavailable, ErrRateLimited, ErrBadModelOutput}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for name, values := range tt.header {
+					w.Header()[name] = values
+				}
+				w.WriteHeader(tt.status)
+				io.WriteString(w, tt.body)
+			}))
+			defer server.Close()
+
+			provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL, DisableRepairRetry: true})
+			_, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+			if err == nil {
+				t.Fatal("AnalyzeText() error = nil")
+			}
+			for _, class := range classes {
+				if got := errors.Is(err, class); got != (class == tt.wantClass) {
+					t.Errorf("errors.Is(%v, %v) = %v", err, class, got)
+				}
+			}
+			if got := RetryAfter(err); got != tt.wantRetryAfter {
+				t.Errorf("RetryAfter() = %s, want %s", got, tt.wantRetryAfter)
+			}
+		})
+	}
+
+	t.Run("Unreachable", func(t *testing.T) {
+		server := httptest.NewServer(http.NotFoundHandler())
+		server.Close()
+		provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL})
+		_, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+		if !errors.Is(err, ErrProviderUnavailable) {
+			t.Errorf("AnalyzeText() error = %v, want ErrProviderUnavailable", err)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		_, err = provider.AnalyzeText(ctx, "Analyze this email.", nil, "")
+		if err == nil || errors.Is(err, ErrProviderUnavailable) {
+			t.Errorf("AnalyzeText() with a cancelled context: error = %v, want an unclassified error", err)
+		}
+	})
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if got := parseRetryAfter("120"); got != 2*time.Minute {
+		t.Errorf("parseRetryAfter(120) = %s", got)
+	}
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	if got := parseRetryAfter(date); got < 59*time.Minute || got > time.Hour {
+		t.Errorf("parseRetryAfter(%s) = %s", date, got)
+	}
+	for _, value := range []string{"", "soon", "-5", "Mon, 01 Jan 2001 00:00:00 GMT"} {
+		if got := parseRetryAfter(value); got != 0 {
+			t.Errorf("parseRetryAfter(%q) = %s, want 0", value, got)
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package llm
+
+import "context"
+
+// semaphore bounds the number of in-flight requests to a provider.
+// A nil semaphore imposes no limit.
+type semaphore chan struct{}
+
+// newSemaphore returns a semaphore allowing n concurrent holders, or nil if n <= 0.
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+// acquire blocks until a slot is free or ctx is done.
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a slot obtained with acquire.
+func (s semaphore) release() {
+	if s == nil {
+		return
+	}
+	<-s
+}
@@ -0,0 +1,63 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+)
+
+func TestOpenAIProvider_MaxConcurrentRequests(t *testing.T) {
+	var current, peak int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&current, 1)
+		defer atomic.AddInt32(&current, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+		json.NewEncoder(w).Encode(APIResponse{Choices: []Choice{{Message: Message{
+			ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: `{"category": "Safe"}`}}},
+		}}}})
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL, MaxConcurrentRequests: 2})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, ""); err != nil {
+				t.Errorf("AnalyzeText() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > 2 {
+		t.Errorf("observed %d concurrent requests, want at most 2", peak)
+	}
+}
+
+func TestSemaphore_AcquireCancelled(t *testing.T) {
+	s := newSemaphore(1)
+	if err := s.acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.acquire(ctx); err == nil {
+		t.Error("acquire() on a full semaphore with a cancelled context succeeded")
+	}
+}
@@ -3,13 +3,14 @@ package llm
 import (
 	"bytes"
 	"context"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
-	"regexp"
 	"strings"
 	"time"
 
@@ -18,12 +19,17 @@ import (
 
 // --- Struct Definitions ---
 
-// Judgment is the structured analysis result from the LLM.
+// Judgment is the structured analysis result from the LLM. The schema of the tool that
+// reports it is generated from its tags by SchemaFor.
 type Judgment struct {
-	IsSuspicious    bool    `json:"is_suspicious"`
-	Category        string  `json:"category"` // e.g., "Phishing", "Spam", "Safe"
-	Reason          string  `json:"reason"`
-	ConfidenceScore float64 `json:"confidence_score"`
+	IsSuspicious    bool    `json:"is_suspicious" description:"Whether the email is suspicious (phishing, spam, etc.)."`
+	Category        string  `json:"category" description:"The category of the email." enum:"Phishing,Spam,Safe"`
+	Reason          string  `json:"reason" description:"A brief explanation for the judgment."`
+	ConfidenceScore float64 `json:"confidence_score" description:"Confidence score of the analysis from 0.0 to 1.0." minimum:"0" maximum:"1"`
+	// NeedsContext are the questions on which the verdict depends, which only the
+	// organization of the recipient can answer. The model is only offered this property
+	// when the analysis options ask for it.
+	NeedsContext []string `json:"needs_context,omitempty" description:"Questions about facts that are not in the email but that the recipient's organization knows, and on which the verdict depends, e.g. \"Is partner-billing.example a known partner of the company?\". Leave empty if the email can be judged without them."`
 }
 
 // --- LLM API Related Structs ---
@@ -33,18 +39,78 @@ type APIRequest struct {
 	Messages   []Message `json:"messages"`
 	Tools      []APITool `json:"tools,omitempty"`
 	ToolChoice any       `json:"tool_choice,omitempty"`
+	Stream     bool      `json:"stream,omitempty"`
+	// StreamOptions asks streaming backends to report token usage in the final chunk.
+	StreamOptions *StreamOptions `json:"stream_options,omitempty"`
 }
 
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// CacheControl marks the end of a cacheable prompt prefix for backends with explicit
+// prompt caching (Anthropic models, directly or through OpenAI-compatible gateways).
+type CacheControl struct {
+	Type string `json:"type"`
+}
+
+// ephemeralCache is the cache_control marker used for the static prompt prefix.
+var ephemeralCache = &CacheControl{Type: "ephemeral"}
 
 type Message struct {
 	Role      string     `json:"role"`
 	Content   string     `json:"content"`
 	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// Parts, when set, is sent as the message content instead of Content,
+	// allowing text and images to be combined for vision models.
+	Parts []ContentPart `json:"-"`
+}
+
+// MarshalJSON sends Parts as an array of content parts when present.
+func (m Message) MarshalJSON() ([]byte, error) {
+	type plainMessage Message
+	if len(m.Parts) == 0 {
+		return json.Marshal(plainMessage(m))
+	}
+	return json.Marshal(struct {
+		Role      string        `json:"role"`
+		Content   []ContentPart `json:"content"`
+		ToolCalls []ToolCall    `json:"tool_calls,omitempty"`
+	}{m.Role, m.Parts, m.ToolCalls})
+}
+
+// ContentPart is one element of a multimodal message content array.
+type ContentPart struct {
+	Type         string        `json:"type"` // "text" or "image_url"
+	Text         string        `json:"text,omitempty"`
+	ImageURL     *ImageURL     `json:"image_url,omitempty"`
+	CacheControl *CacheControl `json:"cache_control,omitempty"`
+}
+
+type ImageURL struct {
+	URL    string `json:"url"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// TextPart returns a text content part.
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: "text", Text: text}
+}
+
+// ImageURLPart returns an image content part referencing an image by URL.
+func ImageURLPart(url string) ContentPart {
+	return ContentPart{Type: "image_url", ImageURL: &ImageURL{URL: url}}
+}
+
+// ImageDataPart returns an image content part carrying the image inline as a base64 data URL.
+func ImageDataPart(mediaType string, data []byte) ContentPart {
+	return ImageURLPart("data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data))
 }
 
 type APITool struct {
-	Type     string         `json:"type"`
-	Function APIFunctionDef `json:"function"`
+	Type         string         `json:"type"`
+	Function     APIFunctionDef `json:"function"`
+	CacheControl *CacheControl  `json:"cache_control,omitempty"`
 }
 
 type APIFunctionDef struct {
@@ -55,6 +121,7 @@ type APIFunctionDef struct {
 
 type APIResponse struct {
 	Choices []Choice  `json:"choices"`
+	Usage   *Usage    `json:"usage,omitempty"`
 	Error   *APIError `json:"error,omitempty"`
 }
 
@@ -67,9 +134,32 @@ type ToolCall struct {
 }
 
 type FunctionCall struct {
+	Name      string `json:"name,omitempty"`
 	Arguments string `json:"arguments"`
 }
 
+// UnmarshalJSON accepts arguments delivered as a JSON object as well as the
+// standard JSON-encoded string, keeping the raw object text in the latter case.
+func (f *FunctionCall) UnmarshalJSON(b []byte) error {
+	var raw struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return err
+	}
+	f.Name = raw.Name
+	f.Arguments = ""
+	if len(raw.Arguments) == 0 || string(raw.Arguments) == "null" {
+		return nil
+	}
+	if raw.Arguments[0] == '"' {
+		return json.Unmarshal(raw.Arguments, &f.Arguments)
+	}
+	f.Arguments = string(raw.Arguments)
+	return nil
+}
+
 type APIError struct {
 	Message string `json:"message"`
 	Type    string `json:"type"`
@@ -85,61 +175,230 @@ type LLMToolCallResponse struct {
 
 // OpenAIProvider implements the analyzer.LLMProvider interface using the OpenAI API.
 type OpenAIProvider struct {
-	client  *http.Client
-	config  *config.Config
-	baseURL string
+	client      *http.Client
+	config      *config.Config
+	baseURL     string
+	progress    func(StreamProgress)
+	normalizers []Normalizer
+	inFlight    semaphore
+	usage       usageCounter
+	dryRun      io.Writer
+	// systemPrompt returns the system prompt, if it is not SystemPrompt.
+	systemPrompt func() string
 }
 
 // NewOpenAIProvider creates a new OpenAIProvider.
 func NewOpenAIProvider(cfg *config.Config) *OpenAIProvider {
+	return NewOpenAIProviderWithClient(cfg, &http.Client{
+		Timeout: time.Duration(cfg.RequestTimeout),
+	})
+}
+
+// NewOpenAIProviderWithClient creates a new OpenAIProvider that sends requests with the given HTTP client.
+// This allows callers to customize the transport, e.g. for recording or replaying responses.
+func NewOpenAIProviderWithClient(cfg *config.Config, client *http.Client) *OpenAIProvider {
 	return &OpenAIProvider{
-		client: &http.Client{
-			Timeout: 90 * time.Second,
-		},
-		config:  cfg,
-		baseURL: cfg.OpenAIBaseURL,
+		client:      client,
+		config:      cfg,
+		baseURL:     chatCompletionsURL(cfg.OpenAIBaseURL, cfg.ChatCompletionsPath),
+		normalizers: DefaultNormalizers(),
+		inFlight:    newSemaphore(cfg.MaxConcurrentRequests),
 	}
 }
 
+// SetNormalizers replaces the normalizers applied to model output before it is parsed.
+func (p *OpenAIProvider) SetNormalizers(normalizers ...Normalizer) {
+	p.normalizers = normalizers
+}
+
+// DefaultBaseURL is the API base URL used when none is configured.
+const DefaultBaseURL = "https://api.openai.com/v1"
+
+// chatCompletionsURL returns the chat completions endpoint for baseURL. The path is appended
+// unless baseURL already ends with it, so both "http://localhost:11434/v1" and the full
+// endpoint URL work.
+func chatCompletionsURL(baseURL, path string) string {
+	base := strings.TrimRight(baseURL, "/")
+	if base == "" {
+		base = DefaultBaseURL
+	}
+	if path == "" {
+		return base
+	}
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+	if strings.HasSuffix(base, path) {
+		return base
+	}
+	return base + path
+}
+
+// OnStreamProgress registers a callback that is invoked for every chunk of a streaming response.
+func (p *OpenAIProvider) OnStreamProgress(fn func(StreamProgress)) {
+	p.progress = fn
+}
+
 // AnalyzeText sends the prompt to the OpenAI API and returns the structured judgment.
 func (p *OpenAIProvider) AnalyzeText(ctx context.Context, prompt string, tools []APITool, toolChoice string) (*Judgment, error) {
-	messages := []Message{
-		{Role: "system", Content: "You are a senior cybersecurity analyst specializing in email threat detection. Analyze the provided email data and use the specified tool to report your findings."},
-		{Role: "user", Content: prompt},
+	return p.analyze(ctx, Message{Role: "user", Content: prompt}, tools, toolChoice)
+}
+
+// AnalyzeContent is like AnalyzeText but sends a multimodal prompt made of text and image parts.
+// The configured model must support vision input.
+func (p *OpenAIProvider) AnalyzeContent(ctx context.Context, parts []ContentPart, tools []APITool, toolChoice string) (*Judgment, error) {
+	return p.analyze(ctx, Message{Role: "user", Parts: parts}, tools, toolChoice)
+}
+
+// SetSystemPrompt replaces SystemPrompt with the prompt returned by fn, which is called
+// for every request so that the prompt can change while the provider is in use.
+func (p *OpenAIProvider) SetSystemPrompt(fn func() string) {
+	p.systemPrompt = fn
+}
+
+// systemPromptKey is the context key of the system prompt set by WithSystemPrompt.
+type systemPromptKey struct{}
+
+// WithSystemPrompt returns a context with which the requests of an OpenAIProvider use
+// prompt as the system prompt, instead of that of SetSystemPrompt or SystemPrompt.
+func WithSystemPrompt(ctx context.Context, prompt string) context.Context {
+	return context.WithValue(ctx, systemPromptKey{}, prompt)
+}
+
+// modelKey is the context key of the model set by WithModel.
+type modelKey struct{}
+
+// WithModel returns a context with which the requests of an OpenAIProvider use model
+// instead of the configured model_name. An empty model restores the configured one.
+func WithModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// SystemPrompt is the default static system message sent with every request.
+const SystemPrompt = "You are a senior cybersecurity analyst specializing in email threat detection. Analyze the provided email data and use the specified tool to report your findings."
+
+// Usage returns the token usage and prompt cache statistics accumulated by this provider.
+func (p *OpenAIProvider) Usage() UsageStats {
+	return p.usage.snapshot()
+}
+
+func (p *OpenAIProvider) analyze(ctx context.Context, userMessage Message, tools []APITool, toolChoice string) (*Judgment, error) {
+	apiRequest := p.newRequest(ctx, userMessage, tools, toolChoice)
+	attempts := 2
+	if p.config.DisableRepairRetry {
+		attempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		apiResponse, err := p.send(ctx, apiRequest)
+		if err != nil {
+			return nil, err
+		}
+
+		judgment, err := p.parseJudgment(apiResponse, tools)
+		if err == nil {
+			return judgment, nil
+		}
+		if attempt >= attempts {
+			return nil, err
+		}
+
+		log.Printf("DEBUG Unusable model output, retrying with a corrective message: %v", err)
+		apiRequest.Messages = append(apiRequest.Messages, correctionMessages(apiResponse, err, tools)...)
 	}
+}
 
+// newRequest returns the chat completion request for an analysis of userMessage.
+func (p *OpenAIProvider) newRequest(ctx context.Context, userMessage Message, tools []APITool, toolChoice string) APIRequest {
+	// Static content (system prompt, tool schema) comes before the per-message content
+	// so that backends with prefix-based prompt caching can reuse it across messages.
+	systemPrompt := SystemPrompt
+	if prompt, ok := ctx.Value(systemPromptKey{}).(string); ok {
+		systemPrompt = prompt
+	} else if p.systemPrompt != nil {
+		systemPrompt = p.systemPrompt()
+	}
+	systemMessage := Message{Role: "system", Content: systemPrompt}
+	if p.config.PromptCacheControl {
+		systemMessage = Message{Role: "system", Parts: []ContentPart{{Type: "text", Text: systemPrompt, CacheControl: ephemeralCache}}}
+		if len(tools) > 0 {
+			tools = append([]APITool(nil), tools...)
+			tools[len(tools)-1].CacheControl = ephemeralCache
+		}
+	}
+	messages := []Message{systemMessage, userMessage}
+
+	model := p.config.ModelName
+	if m, ok := ctx.Value(modelKey{}).(string); ok && m != "" {
+		model = m
+	}
 	apiRequest := APIRequest{
-		Model:    p.config.ModelName,
+		Model:    model,
 		Messages: messages,
 		Tools:    tools,
+		Stream:   p.config.Stream,
+	}
+	if apiRequest.Stream {
+		apiRequest.StreamOptions = &StreamOptions{IncludeUsage: true}
 	}
 
 	if toolChoice != "" {
 		apiRequest.ToolChoice = toolChoice
 	}
+	return apiRequest
+}
+
+// send performs a chat completion request and returns the decoded response.
+// Streaming responses are assembled into the same APIResponse shape.
+func (p *OpenAIProvider) send(ctx context.Context, apiRequest APIRequest) (*APIResponse, error) {
+	if p.dryRun != nil {
+		if err := p.writeDryRun(apiRequest); err != nil {
+			return nil, err
+		}
+		return nil, ErrDryRun
+	}
 
 	reqBody, err := json.Marshal(apiRequest)
 	if err != nil {
 		return nil, fmt.Errorf("could not marshal API request: %w", err)
 	}
 
+	if err := p.inFlight.acquire(ctx); err != nil {
+		return nil, fmt.Errorf("waiting for a free request slot: %w", err)
+	}
+	defer p.inFlight.release()
+
+	// Streaming requests are cancelled through this context when the stream stalls.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
 	req, err := http.NewRequestWithContext(ctx, "POST", p.baseURL, bytes.NewBuffer(reqBody))
 	if err != nil {
 		return nil, fmt.Errorf("could not create HTTP request: %w", err)
 	}
 
-	// Only set Authorization header if API key is provided
-	if p.config.OpenAIAPIKey != "" {
-		req.Header.Set("Authorization", "Bearer "+p.config.OpenAIAPIKey)
+	p.setHeaders(req)
+	if apiRequest.Stream {
+		req.Header.Set("Accept", "text/event-stream")
 	}
-	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %w", err)
+		return nil, requestError(ctx, err)
 	}
 	defer resp.Body.Close()
 
+	if apiRequest.Stream && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		apiResponse, err := p.readStream(resp.Body, time.Duration(p.config.StreamIdleTimeout), cancel)
+		if err != nil {
+			if cause := context.Cause(ctx); errors.Is(cause, ErrStreamStalled) {
+				return nil, withClass(ErrProviderUnavailable, cause)
+			}
+			return nil, err
+		}
+		p.usage.add(apiResponse.Usage)
+		return apiResponse, nil
+	}
+
 	respBody, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("could not read API response body: %w", err)
@@ -147,72 +406,124 @@ func (p *OpenAIProvider) AnalyzeText(ctx context.Context, prompt string, tools [
 
 	var apiResponse APIResponse
 	if err := json.Unmarshal(respBody, &apiResponse); err != nil {
-		return nil, fmt.Errorf("could not decode API response: %w", err)
+		return nil, responseError(resp, nil, fmt.Errorf("could not decode API response: %w", err))
 	}
 
 	if apiResponse.Error != nil {
-		return nil, fmt.Errorf("API error: [%s] %s", apiResponse.Error.Code, apiResponse.Error.Message)
-	}
-
-	// --- Custom parsing for local LLM tool calls ---
-	// Check if the response contains a message with content that includes tool call markers
-	if len(apiResponse.Choices) > 0 && apiResponse.Choices[0].Message.Content != "" {
-		content := apiResponse.Choices[0].Message.Content
-		log.Printf("DEBUG LLM Response Content: %s", content)
-		// Regex to find the JSON string between [TOOL_REQUEST] and [END_TOOL_REQUEST]
-		re := regexp.MustCompile(`(?s)\[TOOL_REQUEST\](.*)\[END_TOOL_REQUEST\]`)
-		matches := re.FindStringSubmatch(content)
-
-		if len(matches) > 1 {
-			toolCallArgs := strings.TrimSpace(matches[1])
-			log.Printf("DEBUG Extracted Tool Call Args (from TOOL_REQUEST): %s", toolCallArgs)
-
-			var toolCallResponse LLMToolCallResponse
-			if err := json.Unmarshal([]byte(toolCallArgs), &toolCallResponse); err != nil {
-				log.Printf("ERROR: Could not unmarshal tool call response from TOOL_REQUEST: %v", err)
-				return nil, fmt.Errorf("could not unmarshal tool call response: %w", err)
-			}
+		return nil, responseError(resp, apiResponse.Error, fmt.Errorf("API error: [%s] %s", apiResponse.Error.Code, apiResponse.Error.Message))
+	}
+	p.usage.add(apiResponse.Usage)
 
-			var judgment Judgment
-			if err := json.Unmarshal([]byte(toolCallResponse.Arguments), &judgment); err != nil {
-				log.Printf("ERROR: Could not unmarshal judgment from TOOL_REQUEST arguments: %v", err)
-				return nil, fmt.Errorf("could not unmarshal judgment from tool call arguments: %w", err)
-			}
-			log.Printf("DEBUG: Successfully parsed from TOOL_REQUEST.")
-			return &judgment, nil
-		}
+	return &apiResponse, nil
+}
+
+// setHeaders sets the authentication and content headers common to all API requests.
+func (p *OpenAIProvider) setHeaders(req *http.Request) {
+	// Only set Authorization header if API key is provided
+	if p.config.OpenAIAPIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+p.config.OpenAIAPIKey)
+	}
+	if p.config.OpenAIOrganization != "" {
+		req.Header.Set("OpenAI-Organization", p.config.OpenAIOrganization)
+	}
+	if p.config.OpenAIProject != "" {
+		req.Header.Set("OpenAI-Project", p.config.OpenAIProject)
+	}
+	req.Header.Set("Content-Type", "application/json")
+}
 
-		// If no TOOL_REQUEST tags, try to parse the entire content as a JSON tool call
-		trimmedContent := strings.TrimSpace(content)
-		log.Printf("DEBUG Attempting to parse entire content as JSON: %s", trimmedContent)
-		var toolCallResponse LLMToolCallResponse
-		if err := json.Unmarshal([]byte(trimmedContent), &toolCallResponse); err == nil {
-			log.Printf("DEBUG: Successfully unmarshaled entire content to LLMToolCallResponse.")
-			var judgment Judgment
-			if err := json.Unmarshal([]byte(toolCallResponse.Arguments), &judgment); err == nil {
-				log.Printf("DEBUG: Successfully unmarshaled judgment from entire content.")
-				return &judgment, nil
-			} else {
-				log.Printf("ERROR: Could not unmarshal judgment from entire content arguments: %v", err)
+// parseJudgment extracts the judgment from an API response. Standard tool calls are
+// preferred; otherwise the message content is normalized and parsed as a JSON tool call.
+// The judgment is then validated against the schema of the first of tools, if it has
+// one, in which case an invalid judgment is returned with the error. Its errors are of
+// class ErrBadModelOutput.
+func (p *OpenAIProvider) parseJudgment(apiResponse *APIResponse, tools []APITool) (*Judgment, error) {
+	judgment, err := p.extractJudgment(apiResponse)
+	if err != nil {
+		return nil, withClass(ErrBadModelOutput, err)
+	}
+	if len(tools) > 0 {
+		if schema, ok := tools[0].Function.Parameters.(*Schema); ok {
+			if err := schema.Validate(judgment); err != nil {
+				return judgment, withClass(ErrBadModelOutput, &schemaError{err})
 			}
-		} else {
-			log.Printf("ERROR: Could not unmarshal entire content to LLMToolCallResponse: %v", err)
 		}
 	}
+	return judgment, nil
+}
 
-	// Fallback to standard tool_calls field if custom parsing fails or is not applicable
-	if len(apiResponse.Choices) > 0 && len(apiResponse.Choices[0].Message.ToolCalls) > 0 {
-		toolCallArgs := apiResponse.Choices[0].Message.ToolCalls[0].Function.Arguments
+// schemaError is the error of a judgment that does not match the schema of the tool.
+type schemaError struct {
+	err error
+}
+
+func (e *schemaError) Error() string {
+	return "judgment does not match the schema: " + strings.ReplaceAll(e.err.Error(), "\n", "; ")
+}
+
+func (e *schemaError) Unwrap() error {
+	return e.err
+}
+
+func (p *OpenAIProvider) extractJudgment(apiResponse *APIResponse) (*Judgment, error) {
+	if len(apiResponse.Choices) == 0 {
+		return nil, errors.New("API response contained no choices")
+	}
+	message := apiResponse.Choices[0].Message
+
+	if len(message.ToolCalls) > 0 {
+		toolCallArgs := message.ToolCalls[0].Function.Arguments
 		log.Printf("DEBUG Attempting to parse from standard tool_calls field: %s", toolCallArgs)
-		var judgment Judgment
-		if err := json.Unmarshal([]byte(toolCallArgs), &judgment); err != nil {
+		judgment, err := decodeJudgment(normalize(toolCallArgs, p.normalizers))
+		if err != nil {
 			log.Printf("ERROR: Could not unmarshal tool call arguments from standard field: %v", err)
 			return nil, fmt.Errorf("could not unmarshal tool call arguments from standard field: %w", err)
 		}
 		log.Printf("DEBUG: Successfully parsed from standard tool_calls field.")
-		return &judgment, nil
+		return judgment, nil
+	}
+
+	if message.Content != "" {
+		log.Printf("DEBUG LLM Response Content: %s", message.Content)
+		normalized := normalize(message.Content, p.normalizers)
+		log.Printf("DEBUG Normalized content: %s", normalized)
+		judgment, err := decodeJudgment(normalized)
+		if err != nil {
+			log.Printf("ERROR: Could not parse judgment from message content: %v", err)
+			return nil, fmt.Errorf("could not parse judgment from message content: %w", err)
+		}
+		log.Printf("DEBUG: Successfully parsed from message content.")
+		return judgment, nil
 	}
 
 	log.Printf("ERROR: API did not return a valid tool call in expected format. Response: %+v", apiResponse)
 	return nil, errors.New("API did not return a valid tool call in expected format")
-}
\ No newline at end of file
+}
+
+// correctionMessages returns the messages appended to the conversation when the model's
+// previous output could not be parsed, asking it to try again.
+func correctionMessages(apiResponse *APIResponse, parseErr error, tools []APITool) []Message {
+	var previous string
+	if len(apiResponse.Choices) > 0 {
+		message := apiResponse.Choices[0].Message
+		previous = message.Content
+		if len(message.ToolCalls) > 0 {
+			previous = message.ToolCalls[0].Function.Arguments
+		}
+	}
+
+	toolName := "the provided function"
+	if len(tools) > 0 {
+		toolName = fmt.Sprintf("the '%s' function", tools[0].Function.Name)
+	}
+
+	correction := fmt.Sprintf("Your previous response could not be parsed (%v). Call %s again. Its arguments must be a single valid JSON object with double-quoted keys and strings, and no other text.", parseErr, toolName)
+	var invalid *schemaError
+	if errors.As(parseErr, &invalid) {
+		correction = fmt.Sprintf("Your previous response does not match the parameters of %s: %s. Call it again with values that do.", toolName, strings.ReplaceAll(invalid.err.Error(), "\n", "; "))
+	}
+	return []Message{
+		{Role: "assistant", Content: previous},
+		{Role: "user", Content: correction},
+	}
+}
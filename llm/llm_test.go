@@ -93,3 +93,148 @@ func TestOpenAIProvider_AnalyzeText(t *testing.T) {
 		})
 	}
 }
+
+func TestMessage_MarshalJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		message Message
+		want    string
+	}{
+		{
+			name:    "Plain text content",
+			message: Message{Role: "user", Content: "Hello"},
+			want:    `{"role":"user","content":"Hello"}`,
+		},
+		{
+			name:    "Text and image parts",
+			message: Message{Role: "user", Parts: []ContentPart{TextPart("Look at this"), ImageDataPart("image/png", []byte("png")), ImageURLPart("https://example.com/shot.png")}},
+			want:    `{"role":"user","content":[{"type":"text","text":"Look at this"},{"type":"image_url","image_url":{"url":"data:image/png;base64,cG5n"}},{"type":"image_url","image_url":{"url":"https://example.com/shot.png"}}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.message)
+			if err != nil {
+				t.Fatalf("Marshal() error = %v", err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("Marshal() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChatCompletionsURL(t *testing.T) {
+	tests := []struct {
+		baseURL string
+		path    string
+		want    string
+	}{
+		{"http://localhost:11434/v1", "/chat/completions", "http://localhost:11434/v1/chat/completions"},
+		{"http://localhost:11434/v1/", "/chat/completions", "http://localhost:11434/v1/chat/completions"},
+		{"https://api.openai.com/v1/chat/completions", "/chat/completions", "https://api.openai.com/v1/chat/completions"},
+		{"https://gateway.example.com/openai", "v2/chat", "https://gateway.example.com/openai/v2/chat"},
+		{"https://gateway.example.com/custom/endpoint", "", "https://gateway.example.com/custom/endpoint"},
+		{"", "/chat/completions", "https://api.openai.com/v1/chat/completions"},
+	}
+
+	for _, tt := range tests {
+		if got := chatCompletionsURL(tt.baseURL, tt.path); got != tt.want {
+			t.Errorf("chatCompletionsURL(%q, %q) = %q, want %q", tt.baseURL, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestOpenAIProvider_RequestHeaders(t *testing.T) {
+	var got http.Header
+	var gotPath string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header
+		gotPath = r.URL.Path
+		json.NewEncoder(w).Encode(APIResponse{Choices: []Choice{{Message: Message{
+			ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: `{"category": "Safe"}`}}},
+		}}}})
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAIAPIKey:        "test-key",
+		OpenAIBaseURL:       server.URL + "/v1",
+		ChatCompletionsPath: config.DefaultChatCompletionsPath,
+		OpenAIOrganization:  "org-123",
+		OpenAIProject:       "proj-456",
+	}
+	if _, err := NewOpenAIProvider(cfg).AnalyzeText(context.Background(), "Analyze this email.", nil, ""); err != nil {
+		t.Fatalf("AnalyzeText() error = %v", err)
+	}
+
+	if gotPath != "/v1/chat/completions" {
+		t.Errorf("request path = %q, want /v1/chat/completions", gotPath)
+	}
+	for header, want := range map[string]string{
+		"Authorization":       "Bearer test-key",
+		"OpenAI-Organization": "org-123",
+		"OpenAI-Project":      "proj-456",
+	} {
+		if got.Get(header) != want {
+			t.Errorf("header %s = %q, want %q", header, got.Get(header), want)
+		}
+	}
+}
+
+func TestWithSystemPrompt(t *testing.T) {
+	var got APIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(APIResponse{Choices: []Choice{{Message: Message{
+			ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: `{"category": "Safe"}`}}},
+		}}}})
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL})
+	provider.SetSystemPrompt(func() string { return "You triage mail for ACME." })
+	for _, tt := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{context.Background(), "You triage mail for ACME."},
+		{WithSystemPrompt(context.Background(), "You triage mail for Globex."), "You triage mail for Globex."},
+	} {
+		if _, err := provider.AnalyzeText(tt.ctx, "Analyze this email.", nil, ""); err != nil {
+			t.Fatalf("AnalyzeText() error = %v", err)
+		}
+		if got.Messages[0].Content != tt.want {
+			t.Errorf("system prompt = %q, want %q", got.Messages[0].Content, tt.want)
+		}
+	}
+}
+
+func TestWithModel(t *testing.T) {
+	var got APIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&got)
+		json.NewEncoder(w).Encode(APIResponse{Choices: []Choice{{Message: Message{
+			ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: `{"category": "Safe"}`}}},
+		}}}})
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL, ModelName: "large-model"})
+	for _, tt := range []struct {
+		ctx  context.Context
+		want string
+	}{
+		{context.Background(), "large-model"},
+		{WithModel(context.Background(), "small-model"), "small-model"},
+		{WithModel(WithModel(context.Background(), "small-model"), ""), "large-model"},
+	} {
+		if _, err := provider.AnalyzeText(tt.ctx, "Analyze this email.", nil, ""); err != nil {
+			t.Fatalf("AnalyzeText() error = %v", err)
+		}
+		if got.Model != tt.want {
+			t.Errorf("model = %q, want %q", got.Model, tt.want)
+		}
+	}
+}
@@ -0,0 +1,305 @@
+// Package llmtest provides fakes of the LLM for tests of code that analyzes messages,
+// in this module or in programs that embed it, without network access.
+//
+// The fakes answer with scripted responses, in order:
+//
+//	provider := llmtest.NewProvider(
+//		llmtest.RateLimited(time.Second),
+//		llmtest.Verdict("Phishing", 0.9, "Fake login page."),
+//	)
+//	a := analyzer.NewEmailAnalyzer(provider)
+//
+// Provider implements analyzer.LLMProvider, to test code that uses the analyzer. Transport
+// and Server speak the chat completions API, to test the whole request path, including
+// the parsing of the responses, the retries and the error classes of the llm package.
+package llmtest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-analyzer/llm"
+)
+
+// Response is a scripted answer of the model or of its endpoint. Exactly one of its
+// fields is usually set; the functions of this package return the common ones.
+type Response struct {
+	// Judgment is reported with a call of the first tool of the request, or as the JSON
+	// content of the message if the request has no tools.
+	Judgment *llm.Judgment
+	// Content is the text of the message when Judgment is nil, e.g. prose or malformed
+	// JSON, to test the handling of bad model output.
+	Content string
+	// Status is an HTTP error status of the endpoint, with Body, in the format of the
+	// chat completions API, and Header.
+	Status int
+	Body   string
+	Header http.Header
+	// Err is returned as is, e.g. to simulate a network failure.
+	Err error
+}
+
+// Verdict returns the response of a model that judges the message to be of category,
+// which is suspicious unless it is "Safe".
+func Verdict(category string, confidence float64, reason string) Response {
+	return Response{Judgment: &llm.Judgment{
+		IsSuspicious:    !strings.EqualFold(category, "Safe"),
+		Category:        category,
+		Reason:          reason,
+		ConfidenceScore: confidence,
+	}}
+}
+
+// Text returns the response of a model that answers with content instead of a judgment.
+func Text(content string) Response {
+	return Response{Content: content}
+}
+
+// Status returns an error response of the endpoint, with message as the message of the
+// error.
+func Status(status int, message string) Response {
+	body, _ := json.Marshal(map[string]llm.APIError{"error": {Message: message}})
+	return Response{Status: status, Body: string(body)}
+}
+
+// RateLimited returns the response of an endpoint that rate limits the requests, and
+// asks to retry after retryAfter.
+func RateLimited(retryAfter time.Duration) Response {
+	r := Status(http.StatusTooManyRequests, "Rate limit reached.")
+	r.Header = http.Header{"Retry-After": {strconv.Itoa(int(retryAfter.Seconds()))}}
+	return r
+}
+
+// Fail returns a response that fails with err.
+func Fail(err error) Response {
+	return Response{Err: err}
+}
+
+// script is the sequence of responses of a fake. Each request takes the next response,
+// and the last one answers all the requests after it.
+type script struct {
+	mu        sync.Mutex
+	responses []Response
+	served    int
+}
+
+func (s *script) next() Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.responses) == 0 {
+		return Fail(fmt.Errorf("llmtest: no scripted response"))
+	}
+	r := s.responses[min(s.served, len(s.responses)-1)]
+	s.served++
+	return r
+}
+
+// Call is a call of Provider.
+type Call struct {
+	// Prompt is the prompt of AnalyzeText, or the text parts of AnalyzeContent.
+	Prompt string
+	// Parts are the parts of AnalyzeContent.
+	Parts      []llm.ContentPart
+	Tools      []llm.APITool
+	ToolChoice string
+}
+
+// Provider is a fake analyzer.LLMProvider that answers with a script of responses. It
+// records its calls. Error statuses are returned as errors of the classes of the llm
+// package, so that a RateLimited response is an llm.ErrRateLimited, but llm.RetryAfter
+// does not report their Retry-After.
+type Provider struct {
+	script script
+	mu     sync.Mutex
+	calls  []Call
+}
+
+// NewProvider returns a Provider that answers with responses, in order, and with the last
+// one once they are used up.
+func NewProvider(responses ...Response) *Provider {
+	return &Provider{script: script{responses: responses}}
+}
+
+// AnalyzeText implements analyzer.LLMProvider.
+func (p *Provider) AnalyzeText(ctx context.Context, prompt string, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+	return p.call(ctx, Call{Prompt: prompt, Tools: tools, ToolChoice: toolChoice})
+}
+
+// AnalyzeContent implements analyzer.MultimodalProvider.
+func (p *Provider) AnalyzeContent(ctx context.Context, parts []llm.ContentPart, tools []llm.APITool, toolChoice string) (*llm.Judgment, error) {
+	var prompt strings.Builder
+	for _, part := range parts {
+		prompt.WriteString(part.Text)
+	}
+	return p.call(ctx, Call{Prompt: prompt.String(), Parts: parts, Tools: tools, ToolChoice: toolChoice})
+}
+
+func (p *Provider) call(ctx context.Context, c Call) (*llm.Judgment, error) {
+	p.mu.Lock()
+	p.calls = append(p.calls, c)
+	p.mu.Unlock()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r := p.script.next()
+	switch {
+	case r.Err != nil:
+		return nil, r.Err
+	case r.Status == http.StatusTooManyRequests:
+		return nil, fmt.Errorf("%w: API request failed with status %d: %s", llm.ErrRateLimited, r.Status, r.Body)
+	case r.Status >= 500:
+		return nil, fmt.Errorf("%w: API request failed with status %d: %s", llm.ErrProviderUnavailable, r.Status, r.Body)
+	case r.Status != 0:
+		return nil, fmt.Errorf("API request failed with status %d: %s", r.Status, r.Body)
+	case r.Judgment != nil:
+		j := *r.Judgment
+		return &j, nil
+	}
+	var j llm.Judgment
+	if err := json.Unmarshal([]byte(r.Content), &j); err != nil || j.Category == "" {
+		return nil, fmt.Errorf("%w: no judgment in %q", llm.ErrBadModelOutput, r.Content)
+	}
+	return &j, nil
+}
+
+// Calls returns the calls of the provider so far.
+func (p *Provider) Calls() []Call {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]Call(nil), p.calls...)
+}
+
+// Transport is an http.RoundTripper that answers chat completion requests with a script
+// of responses, for an llm.OpenAIProvider created with llm.NewOpenAIProviderWithClient.
+// It records the requests.
+type Transport struct {
+	script   script
+	mu       sync.Mutex
+	requests []llm.APIRequest
+}
+
+// NewTransport returns a Transport that answers with responses, in order, and with the
+// last one once they are used up.
+func NewTransport(responses ...Response) *Transport {
+	return &Transport{script: script{responses: responses}}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var apiRequest llm.APIRequest
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(body, &apiRequest); err != nil {
+			return nil, fmt.Errorf("llmtest: invalid request: %w", err)
+		}
+	}
+	t.mu.Lock()
+	t.requests = append(t.requests, apiRequest)
+	t.mu.Unlock()
+	if err := req.Context().Err(); err != nil {
+		return nil, err
+	}
+
+	r := t.script.next()
+	if r.Err != nil {
+		return nil, r.Err
+	}
+	status, header, body := r.Status, r.Header.Clone(), []byte(r.Body)
+	if status == 0 {
+		status = http.StatusOK
+		var err error
+		if body, err = json.Marshal(llm.APIResponse{Choices: []llm.Choice{{Message: message(r, apiRequest)}}}); err != nil {
+			return nil, err
+		}
+	}
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+	return &http.Response{
+		StatusCode:    status,
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}, nil
+}
+
+// message returns the message of the model for the response r to req.
+func message(r Response, req llm.APIRequest) llm.Message {
+	if r.Judgment == nil {
+		return llm.Message{Role: "assistant", Content: r.Content}
+	}
+	arguments, _ := json.Marshal(r.Judgment)
+	if len(req.Tools) == 0 {
+		return llm.Message{Role: "assistant", Content: string(arguments)}
+	}
+	return llm.Message{Role: "assistant", ToolCalls: []llm.ToolCall{{Function: llm.FunctionCall{
+		Name:      req.Tools[0].Function.Name,
+		Arguments: string(arguments),
+	}}}}
+}
+
+// Requests returns the requests received so far.
+func (t *Transport) Requests() []llm.APIRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]llm.APIRequest(nil), t.requests...)
+}
+
+// Server is an HTTP server that answers chat completion requests with a script of
+// responses, for code that is configured with the URL of the endpoint, such as
+// mail-analyzer with openai_base_url. Requests to any path are answered.
+type Server struct {
+	*httptest.Server
+	transport *Transport
+}
+
+// NewServer starts a Server that answers with responses, in order, and with the last one
+// once they are used up. The caller must close it.
+func NewServer(responses ...Response) *Server {
+	t := NewTransport(responses...)
+	return &Server{
+		Server: httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			resp, err := t.RoundTrip(req)
+			if err != nil {
+				// A failure of the network is simulated by closing the connection.
+				if hj, ok := w.(http.Hijacker); ok {
+					if conn, _, err := hj.Hijack(); err == nil {
+						conn.Close()
+						return
+					}
+				}
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+			defer resp.Body.Close()
+			for name, values := range resp.Header {
+				w.Header()[name] = values
+			}
+			w.WriteHeader(resp.StatusCode)
+			io.Copy(w, resp.Body)
+		})),
+		transport: t,
+	}
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []llm.APIRequest {
+	return s.transport.Requests()
+}
@@ -0,0 +1,100 @@
+package llmtest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/analyzer"
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+func TestProvider(t *testing.T) {
+	provider := NewProvider(
+		RateLimited(time.Second),
+		Text("I think this is phishing."),
+		Verdict("Phishing", 0.9, "Fake login page."),
+	)
+	a := analyzer.NewEmailAnalyzer(provider)
+	e := &email.ParsedEmail{Subject: "Verify your account", Body: "https://evil.example.com"}
+
+	if _, err := a.Analyze(context.Background(), e, nil); !errors.Is(err, llm.ErrRateLimited) {
+		t.Errorf("first Analyze() error = %v, want ErrRateLimited", err)
+	}
+	if _, err := a.Analyze(context.Background(), e, nil); !errors.Is(err, llm.ErrBadModelOutput) {
+		t.Errorf("second Analyze() error = %v, want ErrBadModelOutput", err)
+	}
+	// The last response answers every call after it.
+	for range 2 {
+		j, err := a.Analyze(context.Background(), e, nil)
+		if err != nil {
+			t.Fatalf("Analyze() error = %v", err)
+		}
+		if want := (llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login page.", ConfidenceScore: 0.9}); !reflect.DeepEqual(*j, want) {
+			t.Errorf("Analyze() = %+v, want %+v", *j, want)
+		}
+	}
+
+	calls := provider.Calls()
+	if len(calls) != 4 || !strings.Contains(calls[0].Prompt, "Subject: Verify your account") || calls[0].Tools[0].Function.Name != "report_analysis_result" {
+		t.Errorf("Calls() = %+v", calls)
+	}
+}
+
+func TestTransport(t *testing.T) {
+	transport := NewTransport(
+		Status(http.StatusServiceUnavailable, "Overloaded."),
+		RateLimited(3*time.Second),
+		Verdict("Safe", 0.8, "Newsletter."),
+	)
+	provider := llm.NewOpenAIProviderWithClient(&config.Config{OpenAIBaseURL: "http://llm.invalid/v1"}, &http.Client{Transport: transport})
+	tools := []llm.APITool{analyzer.AnalysisTool()}
+
+	_, err := provider.AnalyzeText(context.Background(), "Analyze this email.", tools, "")
+	if !errors.Is(err, llm.ErrProviderUnavailable) || !strings.Contains(err.Error(), "Overloaded.") {
+		t.Errorf("first AnalyzeText() error = %v, want ErrProviderUnavailable", err)
+	}
+	_, err = provider.AnalyzeText(context.Background(), "Analyze this email.", tools, "")
+	if !errors.Is(err, llm.ErrRateLimited) || llm.RetryAfter(err) != 3*time.Second {
+		t.Errorf("second AnalyzeText() error = %v, want ErrRateLimited after 3s", err)
+	}
+	j, err := provider.AnalyzeText(context.Background(), "Analyze this email.", tools, "")
+	if err != nil {
+		t.Fatalf("AnalyzeText() error = %v", err)
+	}
+	if j.IsSuspicious || j.Category != "Safe" || j.Reason != "Newsletter." {
+		t.Errorf("AnalyzeText() = %+v", j)
+	}
+
+	requests := transport.Requests()
+	if len(requests) != 3 || requests[2].Messages[1].Content != "Analyze this email." || requests[2].Tools[0].Function.Name != "report_analysis_result" {
+		t.Errorf("Requests() = %+v", requests)
+	}
+}
+
+func TestServer(t *testing.T) {
+	server := NewServer(Fail(errors.New("connection reset")), Verdict("Spam", 0.7, "Bulk offer."))
+	defer server.Close()
+	provider := llm.NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL})
+
+	if _, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, ""); !errors.Is(err, llm.ErrProviderUnavailable) {
+		t.Errorf("first AnalyzeText() error = %v, want ErrProviderUnavailable", err)
+	}
+	// Without tools, the judgment is the content of the message.
+	j, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+	if err != nil {
+		t.Fatalf("AnalyzeText() error = %v", err)
+	}
+	if !j.IsSuspicious || j.Category != "Spam" {
+		t.Errorf("AnalyzeText() = %+v", j)
+	}
+	if n := len(server.Requests()); n != 2 {
+		t.Errorf("Requests() has %d requests, want 2", n)
+	}
+}
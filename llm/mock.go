@@ -0,0 +1,56 @@
+package llm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// MockTransport is an http.RoundTripper that answers every chat completions request with
+// Judgment, as a call of the first tool of the request, without any network access. It
+// tests everything but the model itself.
+type MockTransport struct {
+	Judgment Judgment
+}
+
+// NewMockTransport creates a MockTransport answering with judgment.
+func NewMockTransport(judgment Judgment) *MockTransport {
+	return &MockTransport{Judgment: judgment}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *MockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	var apiRequest APIRequest
+	if err := json.Unmarshal(reqBody, &apiRequest); err != nil {
+		return nil, fmt.Errorf("mock provider: invalid request: %w", err)
+	}
+	arguments, err := json.Marshal(t.Judgment)
+	if err != nil {
+		return nil, err
+	}
+	message := Message{Role: "assistant", Content: string(arguments)}
+	if len(apiRequest.Tools) > 0 {
+		message = Message{Role: "assistant", ToolCalls: []ToolCall{{Function: FunctionCall{
+			Name:      apiRequest.Tools[0].Function.Name,
+			Arguments: string(arguments),
+		}}}}
+	}
+	respBody, err := json.Marshal(APIResponse{Choices: []Choice{{Message: message}}})
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		StatusCode:    http.StatusOK,
+		Status:        "200 OK",
+		Header:        http.Header{"Content-Type": []string{"application/json"}},
+		Body:          io.NopCloser(bytes.NewReader(respBody)),
+		ContentLength: int64(len(respBody)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,29 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestMockTransport(t *testing.T) {
+	want := &Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 0.9}
+	cfg := &config.Config{OpenAIBaseURL: "http://mock.invalid", ModelName: "test-model"}
+	p := NewOpenAIProviderWithClient(cfg, &http.Client{Transport: NewMockTransport(*want)})
+
+	tools := []APITool{{Type: "function", Function: APIFunctionDef{Name: "report_analysis_result"}}}
+	got, err := p.AnalyzeText(context.Background(), "Analyze this email.", tools, "report_analysis_result")
+	if err != nil {
+		t.Fatalf("AnalyzeText() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeText() = %v, want %v", got, want)
+	}
+	// Without tools, the judgment is answered as the message content.
+	if got, err := p.AnalyzeText(context.Background(), "Analyze this email.", nil, ""); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeText() without tools = %v, %v, want %v", got, err, want)
+	}
+}
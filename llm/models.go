@@ -0,0 +1,64 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// ErrModelsNotSupported is returned by ListModels for endpoints without the models API.
+var ErrModelsNotSupported = errors.New("the endpoint does not implement the models API")
+
+// ModelsResponse is the response body of the OpenAI-compatible models API.
+type ModelsResponse struct {
+	Data  []Model   `json:"data"`
+	Error *APIError `json:"error,omitempty"`
+}
+
+type Model struct {
+	ID string `json:"id"`
+}
+
+// ListModels returns the IDs of the models offered by the endpoint. Not every
+// OpenAI-compatible server implements the models API.
+func (p *OpenAIProvider) ListModels(ctx context.Context) ([]string, error) {
+	url := siblingURL(p.config.OpenAIBaseURL, p.config.ChatCompletionsPath, "/models")
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create HTTP request: %w", err)
+	}
+	p.setHeaders(req)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, requestError(ctx, err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read API response body: %w", err)
+	}
+
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusMethodNotAllowed {
+		return nil, ErrModelsNotSupported
+	}
+	var modelsResponse ModelsResponse
+	jsonErr := json.Unmarshal(body, &modelsResponse)
+	if modelsResponse.Error != nil {
+		return nil, responseError(resp, modelsResponse.Error, fmt.Errorf("API error (%s): %s", resp.Status, modelsResponse.Error.Message))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, responseError(resp, nil, fmt.Errorf("unexpected response status %s", resp.Status))
+	}
+	if jsonErr != nil {
+		return nil, fmt.Errorf("could not decode models response: %w", jsonErr)
+	}
+	ids := make([]string, len(modelsResponse.Data))
+	for i, m := range modelsResponse.Data {
+		ids[i] = m.ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,42 @@
+package llm
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestListModels(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/models" {
+			t.Errorf("request path = %s", r.URL.Path)
+		}
+		if r.Header.Get("Authorization") != "Bearer good-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(`{"error": {"message": "Incorrect API key provided"}}`))
+			return
+		}
+		w.Write([]byte(`{"data": [{"id": "gpt-4o"}, {"id": "gpt-4o-mini"}]}`))
+	}))
+	defer server.Close()
+
+	// A base URL given as the full chat completions endpoint also works.
+	cfg := &config.Config{OpenAIBaseURL: server.URL + "/v1/chat/completions", ChatCompletionsPath: "/chat/completions", OpenAIAPIKey: "good-key"}
+	got, err := NewOpenAIProvider(cfg).ListModels(context.Background())
+	if err != nil {
+		t.Fatalf("ListModels() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, []string{"gpt-4o", "gpt-4o-mini"}) {
+		t.Errorf("ListModels() = %v", got)
+	}
+
+	cfg.OpenAIAPIKey = "bad-key"
+	if _, err := NewOpenAIProvider(cfg).ListModels(context.Background()); err == nil || !strings.Contains(err.Error(), "Incorrect API key") {
+		t.Errorf("ListModels() with a bad key error = %v", err)
+	}
+}
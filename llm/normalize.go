@@ -0,0 +1,210 @@
+package llm
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Normalizer rewrites free-form model output into something closer to a JSON document.
+// Normalizers return their input unchanged when they do not apply.
+type Normalizer func(content string) string
+
+// DefaultNormalizers returns the normalizers applied, in order, to message content that
+// is not delivered as a standard tool call. They cover the output quirks of common local models.
+func DefaultNormalizers() []Normalizer {
+	return []Normalizer{
+		ExtractToolRequest,
+		StripCodeFences,
+		ExtractJSONObject,
+		RepairJSON,
+	}
+}
+
+var (
+	toolRequestRegex = regexp.MustCompile(`(?s)\[TOOL_REQUEST\](.*)\[END_TOOL_REQUEST\]`)
+	codeFenceRegex   = regexp.MustCompile("(?s)```[a-zA-Z0-9_-]*\\s*\\n?(.*?)```")
+)
+
+// ExtractToolRequest returns the text between [TOOL_REQUEST] and [END_TOOL_REQUEST] markers.
+func ExtractToolRequest(content string) string {
+	if m := toolRequestRegex.FindStringSubmatch(content); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return content
+}
+
+// StripCodeFences returns the contents of the first markdown code block.
+func StripCodeFences(content string) string {
+	if m := codeFenceRegex.FindStringSubmatch(content); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return content
+}
+
+// ExtractJSONObject returns the first balanced {...} object in content that is valid JSON,
+// either as-is or after RepairJSON. If none is valid, the first balanced object is returned.
+func ExtractJSONObject(content string) string {
+	first := ""
+	for start := strings.IndexByte(content, '{'); start >= 0; {
+		end := matchingBrace(content, start)
+		if end < 0 {
+			break
+		}
+		candidate := content[start : end+1]
+		if json.Valid([]byte(candidate)) || json.Valid([]byte(RepairJSON(candidate))) {
+			return candidate
+		}
+		if first == "" {
+			first = candidate
+		}
+		next := strings.IndexByte(content[start+1:], '{')
+		if next < 0 {
+			break
+		}
+		start += next + 1
+	}
+	if first != "" {
+		return first
+	}
+	return content
+}
+
+// matchingBrace returns the index of the brace closing the one at start, or -1.
+// Braces inside single- or double-quoted strings are ignored.
+func matchingBrace(s string, start int) int {
+	depth := 0
+	var quote byte
+	for i := start; i < len(s); i++ {
+		c := s[i]
+		if quote != 0 {
+			if c == '\\' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+			continue
+		}
+		switch c {
+		case '"', '\'':
+			quote = c
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// RepairJSON fixes common syntax errors in model-generated JSON: single-quoted strings,
+// trailing commas, and Python-style True/False/None literals. Valid JSON is returned unchanged.
+func RepairJSON(content string) string {
+	if json.Valid([]byte(content)) {
+		return content
+	}
+
+	var b strings.Builder
+	var quote byte
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		if quote != 0 {
+			switch {
+			case c == '\\' && i+1 < len(content):
+				if quote == '\'' && content[i+1] == '\'' {
+					b.WriteByte('\'') // \' needs no escaping inside a double-quoted string
+				} else {
+					b.WriteByte(c)
+					b.WriteByte(content[i+1])
+				}
+				i++
+			case c == quote:
+				b.WriteByte('"')
+				quote = 0
+			case c == '"':
+				b.WriteString(`\"`) // Only reachable inside a single-quoted string
+			default:
+				b.WriteByte(c)
+			}
+			continue
+		}
+
+		switch c {
+		case '"', '\'':
+			quote = c
+			b.WriteByte('"')
+		case ',':
+			// Drop trailing commas before a closing bracket.
+			rest := strings.TrimLeft(content[i+1:], " \t\r\n")
+			if rest == "" || rest[0] == '}' || rest[0] == ']' {
+				continue
+			}
+			b.WriteByte(c)
+		default:
+			if lit, repl, ok := pythonLiteral(content[i:]); ok {
+				b.WriteString(repl)
+				i += len(lit) - 1
+				continue
+			}
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// pythonLiteral reports whether s starts with a Python literal and returns its JSON equivalent.
+func pythonLiteral(s string) (string, string, bool) {
+	for lit, repl := range map[string]string{"True": "true", "False": "false", "None": "null"} {
+		if strings.HasPrefix(s, lit) && (len(s) == len(lit) || !isIdentChar(s[len(lit)])) {
+			return lit, repl, true
+		}
+	}
+	return "", "", false
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// normalize applies normalizers in order to content.
+func normalize(content string, normalizers []Normalizer) string {
+	for _, n := range normalizers {
+		content = n(content)
+	}
+	return content
+}
+
+// decodeJudgment decodes a judgment from a JSON document that is either the judgment itself
+// or a tool call wrapper of the form {"name": ..., "arguments": ...}, where the arguments
+// may be an object or a JSON-encoded string.
+func decodeJudgment(doc string) (*Judgment, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(doc), &fields); err != nil {
+		return nil, fmt.Errorf("could not decode JSON object: %w", err)
+	}
+
+	if args, ok := fields["arguments"]; ok {
+		text := string(args)
+		var s string
+		if err := json.Unmarshal(args, &s); err == nil {
+			text = s // Arguments delivered as a JSON-encoded string
+		}
+		return decodeJudgment(RepairJSON(ExtractJSONObject(text)))
+	}
+	if _, ok := fields["category"]; !ok {
+		if _, ok := fields["is_suspicious"]; !ok {
+			return nil, errors.New("JSON object is neither a tool call nor a judgment")
+		}
+	}
+
+	var judgment Judgment
+	if err := json.Unmarshal([]byte(doc), &judgment); err != nil {
+		return nil, fmt.Errorf("could not decode judgment: %w", err)
+	}
+	return &judgment, nil
+}
@@ -0,0 +1,209 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestParseJudgment_Normalization(t *testing.T) {
+	want := &Judgment{IsSuspicious: true, Category: "Phishing", Reason: "It's a fake login page.", ConfidenceScore: 0.9}
+
+	tests := []struct {
+		name    string
+		message Message
+		wantErr bool
+	}{
+		{
+			name:    "TOOL_REQUEST markers",
+			message: Message{Content: `[TOOL_REQUEST]{"name": "report_analysis_result", "arguments": {"is_suspicious": true, "category": "Phishing", "reason": "It's a fake login page.", "confidence_score": 0.9}}[END_TOOL_REQUEST]`},
+		},
+		{
+			name:    "Markdown code fence",
+			message: Message{Content: "Here is my analysis:\n```json\n{\"is_suspicious\": true, \"category\": \"Phishing\", \"reason\": \"It's a fake login page.\", \"confidence_score\": 0.9}\n```"},
+		},
+		{
+			name:    "Prose around a JSON object",
+			message: Message{Content: `I've looked at it {briefly}. Result: {"name": "report_analysis_result", "arguments": "{\"is_suspicious\": true, \"category\": \"Phishing\", \"reason\": \"It's a fake login page.\", \"confidence_score\": 0.9}"} Hope this helps!`},
+		},
+		{
+			name:    "Single quotes, trailing commas and Python literals",
+			message: Message{Content: `{'is_suspicious': True, 'category': 'Phishing', 'reason': 'It\'s a fake login page.', 'confidence_score': 0.9,}`},
+		},
+		{
+			name:    "Standard tool call with fenced arguments",
+			message: Message{ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: "```\n{\"is_suspicious\": true, \"category\": \"Phishing\", \"reason\": \"It's a fake login page.\", \"confidence_score\": 0.9,}\n```"}}}},
+		},
+		{
+			name:    "Content without JSON",
+			message: Message{Content: "This email looks like phishing."},
+			wantErr: true,
+		},
+		{
+			name:    "JSON that is not a judgment",
+			message: Message{Content: `{"status": "ok"}`},
+			wantErr: true,
+		},
+	}
+
+	provider := NewOpenAIProvider(&config.Config{})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := provider.parseJudgment(&APIResponse{Choices: []Choice{{Message: tt.message}}}, nil)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseJudgment() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, want) {
+				t.Errorf("parseJudgment() = %+v, want %+v", got, want)
+			}
+		})
+	}
+}
+
+func TestFunctionCall_UnmarshalObjectArguments(t *testing.T) {
+	var resp APIResponse
+	body := `{"choices": [{"message": {"tool_calls": [{"function": {"name": "report_analysis_result", "arguments": {"category": "Safe"}}}]}}]}`
+	if err := json.Unmarshal([]byte(body), &resp); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	got := resp.Choices[0].Message.ToolCalls[0].Function
+	if got.Name != "report_analysis_result" || got.Arguments != `{"category": "Safe"}` {
+		t.Errorf("FunctionCall = %+v", got)
+	}
+}
+
+func TestOpenAIProvider_AnalyzeText_RepairRetry(t *testing.T) {
+	tests := []struct {
+		name         string
+		disableRetry bool
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "Retry with corrective message", wantRequests: 2},
+		{name: "Retry disabled", disableRetry: true, wantRequests: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []APIRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				var req APIRequest
+				json.Unmarshal(body, &req)
+				requests = append(requests, req)
+
+				content := "I think this is phishing."
+				if len(requests) > 1 {
+					content = `{"is_suspicious": true, "category": "Phishing", "reason": "Fake login.", "confidence_score": 0.8}`
+				}
+				json.NewEncoder(w).Encode(APIResponse{Choices: []Choice{{Message: Message{Content: content}}}})
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{OpenAIBaseURL: server.URL, DisableRepairRetry: tt.disableRetry}
+			tools := []APITool{{Type: "function", Function: APIFunctionDef{Name: "report_analysis_result"}}}
+			got, err := NewOpenAIProvider(cfg).AnalyzeText(context.Background(), "Analyze this email.", tools, "")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("AnalyzeText() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("expected %d requests, got %d", tt.wantRequests, len(requests))
+			}
+			if tt.wantErr {
+				return
+			}
+
+			if got.Category != "Phishing" {
+				t.Errorf("AnalyzeText() = %+v, want category Phishing", got)
+			}
+			retry := requests[1].Messages
+			if len(retry) != 4 || retry[2].Role != "assistant" || !strings.Contains(retry[3].Content, "report_analysis_result") {
+				t.Errorf("unexpected retry conversation: %+v", retry)
+			}
+		})
+	}
+}
+
+func TestOpenAIProvider_AnalyzeText_SchemaRetry(t *testing.T) {
+	tests := []struct {
+		name           string
+		first          string
+		wantRequests   int
+		wantCorrection string
+		wantErr        string
+	}{
+		{
+			name:         "Valid judgment",
+			first:        `{"is_suspicious": false, "category": "safe", "reason": "Newsletter.", "confidence_score": 0.9}`,
+			wantRequests: 1,
+		},
+		{
+			name:           "Unknown category and out of range confidence",
+			first:          `{"is_suspicious": true, "category": "Scam", "reason": "Fake prize.", "confidence_score": 95}`,
+			wantRequests:   2,
+			wantCorrection: `does not match the parameters of the 'report_analysis_result' function: category "Scam" is not one of Phishing, Spam, Safe; confidence_score 95 is not between 0 and 1.`,
+		},
+		{
+			name:           "Empty reason",
+			first:          `{"is_suspicious": true, "category": "Spam", "reason": "", "confidence_score": 0.7}`,
+			wantRequests:   2,
+			wantCorrection: "reason is empty",
+		},
+		{
+			name:         "Still invalid",
+			first:        `{"is_suspicious": true, "category": "Scam", "reason": "Fake prize.", "confidence_score": 0.9}`,
+			wantRequests: 2,
+			wantErr:      `judgment does not match the schema: category "Scam" is not one of Phishing, Spam, Safe`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests []APIRequest
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var req APIRequest
+				json.NewDecoder(r.Body).Decode(&req)
+				requests = append(requests, req)
+
+				args := tt.first
+				if len(requests) > 1 && tt.wantErr == "" {
+					args = `{"is_suspicious": true, "category": "Phishing", "reason": "Fake login.", "confidence_score": 0.8}`
+				}
+				json.NewEncoder(w).Encode(APIResponse{Choices: []Choice{{Message: Message{ToolCalls: []ToolCall{{Function: FunctionCall{Name: "report_analysis_result", Arguments: args}}}}}}})
+			}))
+			defer server.Close()
+
+			tools := []APITool{{Type: "function", Function: APIFunctionDef{Name: "report_analysis_result", Parameters: SchemaFor[Judgment]()}}}
+			got, err := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL}).AnalyzeText(context.Background(), "Analyze this email.", tools, "")
+			if len(requests) != tt.wantRequests {
+				t.Fatalf("expected %d requests, got %d", tt.wantRequests, len(requests))
+			}
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !errors.Is(err, ErrBadModelOutput) {
+					t.Errorf("AnalyzeText() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("AnalyzeText() error = %v", err)
+			}
+			if tt.wantCorrection != "" {
+				retry := requests[1].Messages
+				if len(retry) != 4 || retry[2].Content != tt.first || !strings.Contains(retry[3].Content, tt.wantCorrection) {
+					t.Errorf("retry conversation = %+v, want a correction with %q", retry, tt.wantCorrection)
+				}
+				if got.Category != "Phishing" {
+					t.Errorf("AnalyzeText() = %+v, want the corrected judgment", got)
+				}
+			}
+		})
+	}
+}
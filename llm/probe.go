@@ -0,0 +1,35 @@
+package llm
+
+import (
+	"context"
+	"time"
+)
+
+// ProbeResult describes how the endpoint answered a probe request.
+type ProbeResult struct {
+	// Latency is the time from sending the request to receiving the whole response.
+	Latency time.Duration
+	// ToolCall is true when the model called the tool, and false when the judgment, if
+	// any, was extracted from the message content by the normalizers.
+	ToolCall bool
+	Judgment *Judgment
+	Usage    *Usage
+}
+
+// Probe sends a single analysis request, without the corrective retry of AnalyzeText,
+// and reports how the endpoint answered. When the response contains no valid judgment,
+// Probe returns the result along with the parse or validation error.
+func (p *OpenAIProvider) Probe(ctx context.Context, prompt string, tools []APITool, toolChoice string) (*ProbeResult, error) {
+	apiRequest := p.newRequest(ctx, Message{Role: "user", Content: prompt}, tools, toolChoice)
+	start := time.Now()
+	apiResponse, err := p.send(ctx, apiRequest)
+	if err != nil {
+		return nil, err
+	}
+	result := &ProbeResult{Latency: time.Since(start), Usage: apiResponse.Usage}
+	if len(apiResponse.Choices) > 0 {
+		result.ToolCall = len(apiResponse.Choices[0].Message.ToolCalls) > 0
+	}
+	result.Judgment, err = p.parseJudgment(apiResponse, tools)
+	return result, err
+}
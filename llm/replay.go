@@ -0,0 +1,166 @@
+package llm
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+
+	"mail-analyzer/atrest"
+)
+
+// ErrNoRecording is returned by ReplayTransport when no response was recorded for a request.
+var ErrNoRecording = errors.New("no recorded response for request")
+
+// recordingPurpose is the purpose of the recorded exchanges for atrest.
+const recordingPurpose = "llm-recording"
+
+// recordedResponse is the on-disk format of a single recorded exchange.
+type recordedResponse struct {
+	Request     json.RawMessage `json:"request,omitempty"`
+	StatusCode  int             `json:"status_code"`
+	ContentType string          `json:"content_type,omitempty"`
+	Body        string          `json:"body"`
+}
+
+// RequestKey returns the key under which a request body is recorded.
+// Only the body is hashed so that a corpus recorded against one endpoint can be
+// replayed regardless of the base URL or API key in use.
+func RequestKey(body []byte) string {
+	sum := sha256.Sum256(body)
+	return hex.EncodeToString(sum[:])
+}
+
+// RecordingTransport is an http.RoundTripper that forwards requests to Base and
+// saves every response in Dir, keyed by the hash of the request body. The files are
+// encrypted with Key, if it is set, since the requests hold the messages.
+type RecordingTransport struct {
+	Dir  string
+	Base http.RoundTripper
+	Key  *atrest.Key
+}
+
+// NewRecordingTransport creates a RecordingTransport. If base is nil, http.DefaultTransport is used.
+func NewRecordingTransport(dir string, base http.RoundTripper) *RecordingTransport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RecordingTransport{Dir: dir, Base: base}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RecordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read response body for recording: %w", err)
+	}
+
+	rec := recordedResponse{
+		StatusCode:  resp.StatusCode,
+		ContentType: resp.Header.Get("Content-Type"),
+		Body:        string(respBody),
+	}
+	if json.Valid(reqBody) {
+		rec.Request = reqBody
+	}
+	data, err := json.MarshalIndent(rec, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("could not marshal recorded response: %w", err)
+	}
+	data = t.Key.Seal(data, recordingPurpose)
+	if err := os.MkdirAll(t.Dir, 0700); err != nil {
+		return nil, fmt.Errorf("could not create record directory: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(t.Dir, RequestKey(reqBody)+".json"), data, 0600); err != nil {
+		return nil, fmt.Errorf("could not write recorded response: %w", err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// ReplayTransport is an http.RoundTripper that serves responses previously saved
+// by RecordingTransport without any network access. Key decrypts the files that were
+// encrypted.
+type ReplayTransport struct {
+	Dir string
+	Key *atrest.Key
+}
+
+// NewReplayTransport creates a ReplayTransport reading from dir.
+func NewReplayTransport(dir string) *ReplayTransport {
+	return &ReplayTransport{Dir: dir}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+
+	key := RequestKey(reqBody)
+	data, err := os.ReadFile(filepath.Join(t.Dir, key+".json"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w %s", ErrNoRecording, key)
+		}
+		return nil, fmt.Errorf("could not read recorded response: %w", err)
+	}
+
+	if data, err = t.Key.Open(data, recordingPurpose); err != nil {
+		return nil, fmt.Errorf("could not read recorded response %s: %w", key, err)
+	}
+	var rec recordedResponse
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, fmt.Errorf("could not decode recorded response %s: %w", key, err)
+	}
+
+	contentType := rec.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", rec.StatusCode, http.StatusText(rec.StatusCode)),
+		StatusCode:    rec.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": []string{contentType}},
+		Body:          io.NopCloser(bytes.NewReader([]byte(rec.Body))),
+		ContentLength: int64(len(rec.Body)),
+		Request:       req,
+	}, nil
+}
+
+// readRequestBody reads the request body and restores it so the request can still be sent.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, fmt.Errorf("could not read request body: %w", err)
+	}
+	req.Body.Close()
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,98 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"mail-analyzer/atrest"
+	"mail-analyzer/config"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir := t.TempDir()
+	want := &Judgment{IsSuspicious: true, Category: "Spam", Reason: "Bulk advertising.", ConfidenceScore: 0.7}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args, _ := json.Marshal(want)
+		json.NewEncoder(w).Encode(APIResponse{
+			Choices: []Choice{{Message: Message{ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: string(args)}}}}}},
+		})
+	}))
+
+	cfg := &config.Config{OpenAIAPIKey: "test-key", OpenAIBaseURL: server.URL, ModelName: "test-model"}
+
+	recorder := NewOpenAIProviderWithClient(cfg, &http.Client{Transport: NewRecordingTransport(dir, nil)})
+	got, err := recorder.AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+	if err != nil {
+		t.Fatalf("recording AnalyzeText() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("recording AnalyzeText() = %v, want %v", got, want)
+	}
+	server.Close()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 recorded response, got %d", len(entries))
+	}
+
+	// The server is gone, so this only succeeds if the response is served from disk.
+	replayer := NewOpenAIProviderWithClient(cfg, &http.Client{Transport: NewReplayTransport(dir)})
+	got, err = replayer.AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+	if err != nil {
+		t.Fatalf("replaying AnalyzeText() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("replaying AnalyzeText() = %v, want %v", got, want)
+	}
+
+	_, err = replayer.AnalyzeText(context.Background(), "A prompt that was never recorded.", nil, "")
+	if !errors.Is(err, ErrNoRecording) {
+		t.Errorf("replaying unknown request: error = %v, want ErrNoRecording", err)
+	}
+}
+
+func TestRecordAndReplay_Encrypted(t *testing.T) {
+	dir := t.TempDir()
+	want := &Judgment{Category: "Safe", Reason: "Newsletter."}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		args, _ := json.Marshal(want)
+		json.NewEncoder(w).Encode(APIResponse{
+			Choices: []Choice{{Message: Message{ToolCalls: []ToolCall{{Function: FunctionCall{Arguments: string(args)}}}}}},
+		})
+	}))
+	defer server.Close()
+	cfg := &config.Config{OpenAIAPIKey: "test-key", OpenAIBaseURL: server.URL, ModelName: "test-model"}
+	key, _ := atrest.NewKey("Zq0mWc1d8x7Yk3vB2nT5rP9sL4hJ6gF0")
+
+	recorder := NewRecordingTransport(dir, nil)
+	recorder.Key = key
+	if _, err := NewOpenAIProviderWithClient(cfg, &http.Client{Transport: recorder}).AnalyzeText(context.Background(), "Subject: Weekly digest", nil, ""); err != nil {
+		t.Fatalf("recording AnalyzeText() error = %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	data, _ := os.ReadFile(filepath.Join(dir, entries[0].Name()))
+	if !atrest.IsSealed(data) || strings.Contains(string(data), "Weekly digest") {
+		t.Errorf("recorded file = %q, want it encrypted", data)
+	}
+
+	replayer := NewReplayTransport(dir)
+	replayer.Key = key
+	if got, err := NewOpenAIProviderWithClient(cfg, &http.Client{Transport: replayer}).AnalyzeText(context.Background(), "Subject: Weekly digest", nil, ""); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("replaying AnalyzeText() = %v, %v, want %v", got, err, want)
+	}
+	if _, err := NewOpenAIProviderWithClient(cfg, &http.Client{Transport: NewReplayTransport(dir)}).AnalyzeText(context.Background(), "Subject: Weekly digest", nil, ""); !errors.Is(err, atrest.ErrNoKey) {
+		t.Errorf("replaying without the key error = %v, want ErrNoKey", err)
+	}
+}
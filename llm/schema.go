@@ -0,0 +1,154 @@
+package llm
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Schema is a JSON schema, as declared for the parameters of a tool. It only has the
+// keywords that SchemaFor generates.
+type Schema struct {
+	Type        string             `json:"type"`
+	Description string             `json:"description,omitempty"`
+	Enum        []string           `json:"enum,omitempty"`
+	Minimum     *float64           `json:"minimum,omitempty"`
+	Maximum     *float64           `json:"maximum,omitempty"`
+	Items       *Schema            `json:"items,omitempty"`
+	Properties  map[string]*Schema `json:"properties,omitempty"`
+	Required    []string           `json:"required,omitempty"`
+}
+
+// Property returns the schema of the property name of an object, or nil.
+func (s *Schema) Property(name string) *Schema {
+	return s.Properties[name]
+}
+
+// SchemaFor returns the schema of T, a struct, generated from its fields, so that the
+// schema declared to the model follows the struct that its answer is decoded into. A
+// property is named after the json tag of its field, and is required unless the tag has
+// omitempty. These tags set the keywords of the same name:
+//
+//	description:"The category of the email."
+//	enum:"Phishing,Spam,Safe"
+//	minimum:"0" maximum:"1"
+//
+// Every call returns a new schema, which the caller may change. SchemaFor panics if T
+// has fields of other kinds than booleans, numbers, strings, slices and structs, or
+// invalid tags, which are programming errors.
+func SchemaFor[T any]() *Schema {
+	return schemaOf(reflect.TypeFor[T]())
+}
+
+func schemaOf(t reflect.Type) *Schema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &Schema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		for _, f := range reflect.VisibleFields(t) {
+			name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || f.Anonymous || name == "-" {
+				continue
+			}
+			if name == "" {
+				name = f.Name
+			}
+			s.Properties[name] = fieldSchema(f)
+			if !strings.Contains(","+opts+",", ",omitempty,") {
+				s.Required = append(s.Required, name)
+			}
+		}
+		return s
+	default:
+		panic(fmt.Sprintf("llm: no JSON schema for %s", t))
+	}
+}
+
+// fieldSchema returns the schema of the field f, with the keywords of its tags.
+func fieldSchema(f reflect.StructField) *Schema {
+	s := schemaOf(f.Type)
+	s.Description = f.Tag.Get("description")
+	if enum := f.Tag.Get("enum"); enum != "" {
+		s.Enum = strings.Split(enum, ",")
+	}
+	s.Minimum = numberTag(f, "minimum")
+	s.Maximum = numberTag(f, "maximum")
+	return s
+}
+
+func numberTag(f reflect.StructField, key string) *float64 {
+	value, ok := f.Tag.Lookup(key)
+	if !ok {
+		return nil
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		panic(fmt.Sprintf("llm: invalid %s tag of %s: %v", key, f.Name, err))
+	}
+	return &n
+}
+
+// Validate reports the fields of v, a struct of the type that s was generated for, whose
+// values break s: empty strings of required properties, strings that are not in the
+// enum of their property, compared case-insensitively, and numbers out of range.
+func (s *Schema) Validate(v any) error {
+	rv := reflect.Indirect(reflect.ValueOf(v))
+	if rv.Kind() != reflect.Struct {
+		return fmt.Errorf("cannot validate %s against an object schema", rv.Type())
+	}
+	var errs []error
+	for _, f := range reflect.VisibleFields(rv.Type()) {
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "" {
+			name = f.Name
+		}
+		p := s.Property(name)
+		if p == nil || !f.IsExported() || f.Anonymous {
+			continue
+		}
+		value := rv.FieldByIndex(f.Index)
+		switch value.Kind() {
+		case reflect.String:
+			str := value.String()
+			switch {
+			case str == "" && slices.Contains(s.Required, name):
+				errs = append(errs, fmt.Errorf("%s is empty", name))
+			case len(p.Enum) > 0 && !slices.ContainsFunc(p.Enum, func(e string) bool { return strings.EqualFold(e, str) }):
+				errs = append(errs, fmt.Errorf("%s %q is not one of %s", name, str, strings.Join(p.Enum, ", ")))
+			}
+		case reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			n := value.Convert(reflect.TypeFor[float64]()).Float()
+			if p.Minimum != nil && n < *p.Minimum || p.Maximum != nil && n > *p.Maximum {
+				errs = append(errs, fmt.Errorf("%s %g is not %s", name, n, rangeText(p)))
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// rangeText describes the range of the numbers of p.
+func rangeText(p *Schema) string {
+	switch {
+	case p.Minimum != nil && p.Maximum != nil:
+		return fmt.Sprintf("between %g and %g", *p.Minimum, *p.Maximum)
+	case p.Minimum != nil:
+		return fmt.Sprintf("at least %g", *p.Minimum)
+	default:
+		return fmt.Sprintf("at most %g", *p.Maximum)
+	}
+}
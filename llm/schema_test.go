@@ -0,0 +1,66 @@
+package llm
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSchemaFor(t *testing.T) {
+	type item struct {
+		Name string `json:"name" description:"The name."`
+	}
+	type doc struct {
+		Level    int      `json:"level" minimum:"1" maximum:"5"`
+		Tags     []string `json:"tags,omitempty" enum:"a,b"`
+		Items    []item   `json:"items"`
+		Next     *item    `json:"next,omitempty"`
+		Internal string   `json:"-"`
+		private  string
+	}
+	got, err := json.Marshal(SchemaFor[doc]())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"type":"object","properties":{` +
+		`"items":{"type":"array","items":{"type":"object","properties":{"name":{"type":"string","description":"The name."}},"required":["name"]}},` +
+		`"level":{"type":"integer","minimum":1,"maximum":5},` +
+		`"next":{"type":"object","properties":{"name":{"type":"string","description":"The name."}},"required":["name"]},` +
+		`"tags":{"type":"array","enum":["a","b"],"items":{"type":"string"}}},` +
+		`"required":["level","items"]}`
+	if string(got) != want {
+		t.Errorf("SchemaFor() = %s\nwant %s", got, want)
+	}
+}
+
+func TestSchemaFor_Judgment(t *testing.T) {
+	s := SchemaFor[Judgment]()
+	// Every field of Judgment is described to the model, and required unless it may be
+	// omitted.
+	var fields []string
+	for _, f := range reflect.VisibleFields(reflect.TypeFor[Judgment]()) {
+		name, opts, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if opts != "omitempty" {
+			fields = append(fields, name)
+		}
+		if p := s.Property(name); p == nil || p.Description == "" {
+			t.Errorf("property %q = %+v, want a description", name, p)
+		}
+	}
+	if !reflect.DeepEqual(s.Required, fields) {
+		t.Errorf("Required = %v, want %v", s.Required, fields)
+	}
+	if enum := s.Property("category").Enum; !reflect.DeepEqual(enum, []string{"Phishing", "Spam", "Safe"}) {
+		t.Errorf("category enum = %v", enum)
+	}
+	if p := s.Property("confidence_score"); p.Minimum == nil || *p.Minimum != 0 || p.Maximum == nil || *p.Maximum != 1 {
+		t.Errorf("confidence_score = %+v, want a range of 0 to 1", p)
+	}
+
+	// Callers may change the schema without changing that of other calls.
+	s.Property("category").Enum = []string{"Scam"}
+	if enum := SchemaFor[Judgment]().Property("category").Enum; len(enum) != 3 {
+		t.Errorf("category enum = %v after a change to another schema", enum)
+	}
+}
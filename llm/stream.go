@@ -0,0 +1,133 @@
+package llm
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// ErrStreamStalled is returned when a streaming response stops delivering data
+// for longer than the configured idle timeout.
+var ErrStreamStalled = errors.New("stream stalled")
+
+// StreamProgress describes how far a streaming response has progressed.
+type StreamProgress struct {
+	// Tokens is the number of content or argument deltas received so far.
+	// Backends usually send one token per delta, so it is a close approximation.
+	Tokens int
+	// PartialReason is the portion of the "reason" field generated so far, if any.
+	PartialReason string
+}
+
+// StreamChunk is a single server-sent event of a streaming chat completion.
+type StreamChunk struct {
+	Choices []StreamChoice `json:"choices"`
+	Usage   *Usage         `json:"usage,omitempty"`
+	Error   *APIError      `json:"error,omitempty"`
+}
+
+type StreamChoice struct {
+	Delta StreamDelta `json:"delta"`
+}
+
+type StreamDelta struct {
+	Content   string           `json:"content"`
+	ToolCalls []StreamToolCall `json:"tool_calls,omitempty"`
+}
+
+type StreamToolCall struct {
+	Index    int          `json:"index"`
+	Function FunctionCall `json:"function"`
+}
+
+var partialReasonRegex = regexp.MustCompile(`"reason"\s*:\s*"((?:[^"\\]|\\.)*)`)
+
+// readStream consumes a server-sent event stream and assembles the deltas into an APIResponse.
+// If idleTimeout is positive and no data arrives within it, cancel is called with ErrStreamStalled.
+func (p *OpenAIProvider) readStream(body io.Reader, idleTimeout time.Duration, cancel func(error)) (*APIResponse, error) {
+	var watchdog *time.Timer
+	if idleTimeout > 0 {
+		watchdog = time.AfterFunc(idleTimeout, func() {
+			cancel(fmt.Errorf("%w: no data received for %s", ErrStreamStalled, idleTimeout))
+		})
+		defer watchdog.Stop()
+	}
+
+	var content strings.Builder
+	var toolCalls []ToolCall
+	var usage *Usage
+	progress := StreamProgress{}
+
+	scanner := bufio.NewScanner(body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if watchdog != nil {
+			watchdog.Reset(idleTimeout)
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue // Blank separators, comments and other SSE fields
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			break
+		}
+
+		var chunk StreamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return nil, fmt.Errorf("could not decode stream chunk: %w", err)
+		}
+		if chunk.Error != nil {
+			return nil, responseError(nil, chunk.Error, fmt.Errorf("API error: [%s] %s", chunk.Error.Code, chunk.Error.Message))
+		}
+		if chunk.Usage != nil {
+			usage = chunk.Usage // Sent in the final chunk when requested via stream_options
+		}
+		if len(chunk.Choices) == 0 {
+			continue
+		}
+
+		delta := chunk.Choices[0].Delta
+		if delta.Content != "" {
+			content.WriteString(delta.Content)
+			progress.Tokens++
+		}
+		for _, tc := range delta.ToolCalls {
+			for len(toolCalls) <= tc.Index {
+				toolCalls = append(toolCalls, ToolCall{})
+			}
+			toolCalls[tc.Index].Function.Arguments += tc.Function.Arguments
+			progress.Tokens++
+		}
+
+		generated := content.String()
+		if len(toolCalls) > 0 {
+			generated = toolCalls[0].Function.Arguments
+		}
+		if m := partialReasonRegex.FindStringSubmatch(generated); m != nil {
+			progress.PartialReason = m[1]
+		}
+		if progress.Tokens%20 == 0 {
+			log.Printf("DEBUG Stream progress: %d tokens received", progress.Tokens)
+		}
+		if p.progress != nil {
+			p.progress(progress)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read API response stream: %w", err)
+	}
+	log.Printf("DEBUG Stream complete: %d tokens received", progress.Tokens)
+
+	return &APIResponse{
+		Choices: []Choice{{Message: Message{Role: "assistant", Content: content.String(), ToolCalls: toolCalls}}},
+		Usage:   usage,
+	}, nil
+}
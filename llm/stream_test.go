@@ -0,0 +1,81 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+)
+
+func TestOpenAIProvider_AnalyzeText_Stream(t *testing.T) {
+	argChunks := []string{
+		`{"is_suspicious": true, `,
+		`"category": "Phishing", `,
+		`"reason": "Fake login `,
+		`page.", "confidence_score": 0.95}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, chunk := range argChunks {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":%q}}]}}]}\n\n", chunk)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{OpenAIBaseURL: server.URL, ModelName: "test-model", Stream: true}
+	provider := NewOpenAIProvider(cfg)
+
+	var updates []StreamProgress
+	provider.OnStreamProgress(func(p StreamProgress) {
+		updates = append(updates, p)
+	})
+
+	got, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+	if err != nil {
+		t.Fatalf("AnalyzeText() error = %v", err)
+	}
+	want := &Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login page.", ConfidenceScore: 0.95}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("AnalyzeText() = %v, want %v", got, want)
+	}
+
+	if len(updates) != len(argChunks) {
+		t.Fatalf("expected %d progress updates, got %d", len(argChunks), len(updates))
+	}
+	if updates[2].PartialReason != "Fake login " {
+		t.Errorf("PartialReason after third chunk = %q, want %q", updates[2].PartialReason, "Fake login ")
+	}
+}
+
+func TestOpenAIProvider_AnalyzeText_StreamStalled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\":[{\"delta\":{\"content\":\"Thinking\"}}]}\n\n")
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{
+		OpenAIBaseURL:     server.URL,
+		ModelName:         "test-model",
+		Stream:            true,
+		StreamIdleTimeout: config.Duration(100 * time.Millisecond),
+	}
+
+	_, err := NewOpenAIProvider(cfg).AnalyzeText(context.Background(), "Analyze this email.", nil, "")
+	if !errors.Is(err, ErrStreamStalled) {
+		t.Errorf("AnalyzeText() error = %v, want ErrStreamStalled", err)
+	}
+}
@@ -0,0 +1,79 @@
+package llm
+
+import (
+	"log"
+	"sync"
+)
+
+// Usage is the token accounting reported by the API for a single request.
+type Usage struct {
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+	// Reported by Anthropic models behind OpenAI-compatible gateways.
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+}
+
+type PromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
+}
+
+// CachedTokens returns the number of prompt tokens served from the prompt cache.
+func (u *Usage) CachedTokens() int {
+	if u.PromptTokensDetails != nil && u.PromptTokensDetails.CachedTokens > 0 {
+		return u.PromptTokensDetails.CachedTokens
+	}
+	return u.CacheReadInputTokens
+}
+
+// UsageStats accumulates token usage and prompt cache statistics across requests.
+type UsageStats struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	CachedTokens     int `json:"cached_tokens"`
+	CacheWriteTokens int `json:"cache_write_tokens"`
+	// CacheHits counts requests for which at least part of the prompt was served from cache.
+	CacheHits int `json:"cache_hits"`
+}
+
+// CacheHitRatio returns the fraction of prompt tokens served from the prompt cache.
+func (s UsageStats) CacheHitRatio() float64 {
+	if s.PromptTokens == 0 {
+		return 0
+	}
+	return float64(s.CachedTokens) / float64(s.PromptTokens)
+}
+
+// usageCounter is a concurrency-safe UsageStats accumulator.
+type usageCounter struct {
+	mu    sync.Mutex
+	stats UsageStats
+}
+
+func (c *usageCounter) add(u *Usage) {
+	if u == nil {
+		return
+	}
+	cached := u.CachedTokens()
+	log.Printf("DEBUG Token usage: prompt=%d (cached=%d) completion=%d", u.PromptTokens, cached, u.CompletionTokens)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.stats.Requests++
+	c.stats.PromptTokens += u.PromptTokens
+	c.stats.CompletionTokens += u.CompletionTokens
+	c.stats.CachedTokens += cached
+	c.stats.CacheWriteTokens += u.CacheCreationInputTokens
+	if cached > 0 {
+		c.stats.CacheHits++
+	}
+}
+
+func (c *usageCounter) snapshot() UsageStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.stats
+}
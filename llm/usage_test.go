@@ -0,0 +1,109 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestOpenAIProvider_PromptCacheControl(t *testing.T) {
+	tests := []struct {
+		name         string
+		cacheControl bool
+		wantMarkers  int
+	}{
+		{name: "Disabled", cacheControl: false, wantMarkers: 0},
+		{name: "Enabled", cacheControl: true, wantMarkers: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var body string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				b, _ := io.ReadAll(r.Body)
+				body = string(b)
+				fmt.Fprint(w, `{"choices": [{"message": {"content": "{\"category\": \"Safe\"}"}}]}`)
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{OpenAIBaseURL: server.URL, PromptCacheControl: tt.cacheControl}
+			tools := []APITool{
+				{Type: "function", Function: APIFunctionDef{Name: "first"}},
+				{Type: "function", Function: APIFunctionDef{Name: "report_analysis_result"}},
+			}
+			if _, err := NewOpenAIProvider(cfg).AnalyzeText(context.Background(), "Analyze this email.", tools, ""); err != nil {
+				t.Fatalf("AnalyzeText() error = %v", err)
+			}
+			if tools[1].CacheControl != nil {
+				t.Error("caller's tools were modified")
+			}
+
+			if got := strings.Count(body, `"cache_control":{"type":"ephemeral"}`); got != tt.wantMarkers {
+				t.Errorf("expected %d cache_control markers, got %d in %s", tt.wantMarkers, got, body)
+			}
+			// The static prefix must precede the email content for prefix caching to apply.
+			if strings.Index(body, SystemPrompt) > strings.Index(body, "Analyze this email.") {
+				t.Errorf("system prompt is not sent before the user message: %s", body)
+			}
+		})
+	}
+}
+
+func TestOpenAIProvider_Usage(t *testing.T) {
+	responses := []string{
+		`{"choices": [{"message": {"content": "{\"category\": \"Safe\"}"}}], "usage": {"prompt_tokens": 1000, "completion_tokens": 50, "total_tokens": 1050}}`,
+		`{"choices": [{"message": {"content": "{\"category\": \"Safe\"}"}}], "usage": {"prompt_tokens": 1000, "completion_tokens": 40, "total_tokens": 1040, "prompt_tokens_details": {"cached_tokens": 800}}}`,
+		`{"choices": [{"message": {"content": "{\"category\": \"Safe\"}"}}], "usage": {"prompt_tokens": 1000, "completion_tokens": 30, "total_tokens": 1030, "cache_read_input_tokens": 700, "cache_creation_input_tokens": 100}}`,
+	}
+	var n int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, responses[n])
+		n++
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL})
+	for range responses {
+		if _, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, ""); err != nil {
+			t.Fatalf("AnalyzeText() error = %v", err)
+		}
+	}
+
+	want := UsageStats{Requests: 3, PromptTokens: 3000, CompletionTokens: 120, CachedTokens: 1500, CacheWriteTokens: 100, CacheHits: 2}
+	if got := provider.Usage(); got != want {
+		t.Errorf("Usage() = %+v, want %+v", got, want)
+	}
+	if got := provider.Usage().CacheHitRatio(); got != 0.5 {
+		t.Errorf("CacheHitRatio() = %v, want 0.5", got)
+	}
+}
+
+func TestOpenAIProvider_StreamUsage(t *testing.T) {
+	var req APIRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&req)
+		w.Header().Set("Content-Type", "text/event-stream")
+		fmt.Fprint(w, "data: {\"choices\": [{\"delta\": {\"content\": \"{\\\"category\\\": \\\"Safe\\\"}\"}}]}\n\n")
+		fmt.Fprint(w, "data: {\"choices\": [], \"usage\": {\"prompt_tokens\": 500, \"completion_tokens\": 10, \"prompt_tokens_details\": {\"cached_tokens\": 256}}}\n\n")
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	provider := NewOpenAIProvider(&config.Config{OpenAIBaseURL: server.URL, Stream: true})
+	if _, err := provider.AnalyzeText(context.Background(), "Analyze this email.", nil, ""); err != nil {
+		t.Fatalf("AnalyzeText() error = %v", err)
+	}
+	if req.StreamOptions == nil || !req.StreamOptions.IncludeUsage {
+		t.Errorf("expected stream_options.include_usage in request, got %+v", req.StreamOptions)
+	}
+	if got := provider.Usage(); got.PromptTokens != 500 || got.CachedTokens != 256 {
+		t.Errorf("Usage() = %+v", got)
+	}
+}
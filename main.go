@@ -1,155 +1,263 @@
 package main
 
 import (
-	"context"
-	"bytes"
-	"encoding/json"
+	"crypto/rand"
+	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"log"
 	"os"
+	"time"
 
 	"github.com/emersion/go-message/mail"
-	"mail-analyzer/analyzer"
 	"mail-analyzer/config"
+	"mail-analyzer/dkim"
 	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
 	"mail-analyzer/llm"
+	"mail-analyzer/outbreak"
+	"mail-analyzer/plugin"
+	"mail-analyzer/sandbox"
 )
 
+// version is set at build time via -ldflags "-X main.version=...".
+var version = "dev"
+
 // FinalOutput is the final JSON output structure.
 type FinalOutput struct {
-	SourceFile      string            `json:"source_file"`
+	SchemaVersion string `json:"schema_version"`
+	SourceFile    string `json:"source_file"`
+	// Summary aggregates the results of the commands that analyze many messages.
+	Summary         *Summary          `json:"summary,omitempty"`
 	AnalysisResults []*AnalysisResult `json:"analysis_results"`
 }
 
 // AnalysisResult is the result for a single email.
 type AnalysisResult struct {
-	MessageID string         `json:"message_id"`
-	Subject   string         `json:"subject"`
-	From      []string       `json:"from"`
-	To        []string       `json:"to"`
-	Judgment  *llm.Judgment  `json:"judgment"`
+	// AnalysisID is a random UUID identifying the analysis, which is also sent to the
+	// sinks and added to the eml output, to correlate the records of a result.
+	AnalysisID string        `json:"analysis_id,omitempty"`
+	MessageID  string        `json:"message_id"`
+	Subject    string        `json:"subject"`
+	From       []string      `json:"from"`
+	To         []string      `json:"to"`
+	Judgment   *llm.Judgment `json:"judgment"`
+	// Model and Provider are the LLM that judged the message, unless its policy did.
+	Model    string `json:"model,omitempty"`
+	Provider string `json:"provider,omitempty"`
+	// ReceivedAt is when the analysis of the message started, and AnalyzedAt when it
+	// ended.
+	ReceivedAt time.Time `json:"received_at,omitzero"`
+	AnalyzedAt time.Time `json:"analyzed_at,omitzero"`
+	// Enrichments are the facts that the enrichers found about the message.
+	Enrichments []enrichment.Result `json:"enrichments,omitempty"`
+	// Plugins are the verdicts of the analyzer plugins, next to the judgment of the LLM.
+	Plugins []plugin.Verdict `json:"plugins,omitempty"`
+	// Sandbox are the verdicts of the sandbox on the attachments of the message, which
+	// are merged into the judgment.
+	Sandbox []sandbox.Result `json:"sandbox,omitempty"`
+	// Tenant is the policy the message was analyzed under, if any.
+	Tenant string `json:"tenant,omitempty"`
+	// DuplicateOf is the file of the message of the same batch that this one duplicates,
+	// whose analysis the result repeats.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+	// FeedbackReport is the abuse report that the message was received in, if it was an
+	// ARF report, in which case the reported message was analyzed in its place.
+	FeedbackReport *email.FeedbackReport `json:"feedback_report,omitempty"`
+	// SampleIntegrity tells whether the message that was reported, or forwarded as
+	// attachment, was modified since its sender signed it with DKIM.
+	SampleIntegrity *dkim.Integrity `json:"sample_integrity,omitempty"`
+	// SubjectObfuscation describes the characters of the subject that make it read like
+	// another one, such as mathematical bold letters spelling a brand, if it has any.
+	SubjectObfuscation *email.Obfuscation `json:"subject_obfuscation,omitempty"`
+	// Warnings tell what was left out of a message that reached the limits of the parsing.
+	Warnings []string `json:"warnings,omitempty"`
+	// URLs found in the message, used by the summary output formats.
+	URLs []string `json:"-"`
+	// Hashes are the SHA-256 hashes of the attachments, stored in the results databases.
+	Hashes []string `json:"-"`
+	// SourceFile is the file the message was read from, for formats with one record per message.
+	SourceFile string `json:"-"`
+	// Raw is used by the eml output format.
+	Raw []byte `json:"-"`
+	// fingerprint is what the outbreak detector compares, if the pipeline has one.
+	fingerprint *outbreak.Fingerprint
 }
 
-func main() {
-	// Setup logging
-	debug := flag.Bool("debug", false, "Enable debug logging")
-	d := flag.Bool("d", false, "Enable debug logging (shorthand)")
-	flag.Parse()
-
-	if !(*debug || *d) {
-		log.SetOutput(ioutil.Discard) // Discard all log.Printf output
-	} else {
-		log.SetFlags(log.LstdFlags | log.Lshortfile) // Add file and line number to debug logs
-	}
-
-	// Adjust os.Args after flag parsing
-	args := flag.Args()
-
-	var rawMessage []byte
-	var sourceFile string
-	var err error
-
-	// 1. Load configuration
-	var cfg *config.Config // Declare cfg here
-	configPath := ""
-
-	if len(args) < 1 {
-		// Read from stdin if no file path is provided
-		log.Println("No EML file path provided. Reading from stdin...")
-		rawMessage, err = io.ReadAll(os.Stdin)
-		if err != nil {
-			log.Fatalf("Error reading from stdin: %v", err)
-		}
-		sourceFile = "stdin" // Indicate source is stdin
+// command is a subcommand of mail-analyzer.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) error
+}
 
-		// Determine config path for stdin case
-		homeDir, err := os.UserHomeDir()
-		if err != nil {
-			log.Fatalf("Error getting user home directory: %v", err)
-		}
-		configPath = fmt.Sprintf("%s/.config/mail-analyzer/config.json", homeDir)
+var commands = []command{
+	{"analyze", "Analyze one message from a file or standard input (the default)", runAnalyze},
+	{"batch", "Analyze every message in the given files and directories", runBatch},
+	{"eval", "Measure the analysis against labeled messages", runEval},
+	{"triage", "Analyze messages and review the verdicts interactively", runTriage},
+	{"serve", "Analyze messages submitted over HTTP", runServe},
+	{"grpc", "Serve the MailAnalyzer gRPC service", runGRPC},
+	{"proxy", "Analyze messages received over SMTP or LMTP and forward them", runProxy},
+	{"worker", "Analyze jobs from a Redis, NATS or AMQP queue", runWorker},
+	{"content-filter", "Analyze a message from Postfix and reinject it with verdict headers", runContentFilter},
+	{"reanalyze", "Analyze stored messages again and report the changed verdicts", runReanalyze},
+	{"query", "Search a results database", func(args []string) error { return runQuery(args, os.Stdout) }},
+	{"iocs", "Export the indicators of a results database for blocklists", func(args []string) error { return runIOCs(args, os.Stdout) }},
+	{"audit", "List the actions recorded in the audit log", func(args []string) error { return runAudit(args, os.Stdout) }},
+	{"sanitize", "Mask the recipients of a message so that it can be shared", func(args []string) error { return runSanitize(args, os.Stdin, os.Stdout) }},
+	{"schema", "Print the JSON Schema of the output", func(args []string) error { return runSchema(args, os.Stdout) }},
+	{"config", "Create, check or show the configuration", runConfig},
+	{"doctor", "Test the LLM endpoint with a sample message", runDoctor},
+	{"selftest", "Analyze a bundled set of sample messages to verify the installation", runSelftest},
+	{"cache", "Inspect or clear the pre-filter vector store and the lookup cache", runCache},
+	{"version", "Print the version", runVersion},
+}
 
-		cfg, err = config.Load(configPath)
-		if err != nil {
-			log.Fatalf("Error loading configuration: %v", err)
+func main() {
+	config.PromptPassphrase = promptPassphrase
+	args := os.Args[1:]
+	cmd := commands[0]
+	if len(args) > 0 {
+		switch args[0] {
+		case "help", "-h", "-help", "--help":
+			printUsage(os.Stdout)
+			return
 		}
-	} else {
-		emlPath := args[0]
-		if len(args) > 1 {
-			configPath = args[1] // configPath is set if provided as second argument
-		} else {
-			// If only EML path is provided, use default config path
-			homeDir, err := os.UserHomeDir()
-			if err != nil {
-				log.Fatalf("Error getting user home directory: %v", err)
+		for _, c := range commands {
+			if c.name == args[0] {
+				cmd, args = c, args[1:]
+				break
 			}
-			configPath = fmt.Sprintf("%s/.config/mail-analyzer/config.json", homeDir)
 		}
+	}
+	// Without a command name, the arguments are those of analyze, as in earlier versions:
+	// mail-analyzer [flags] [file.eml [config.json]]
 
-		cfg, err = config.Load(configPath)
-		if err != nil {
-			log.Fatalf("Error loading configuration: %v", err)
-		}
+	err := cmd.run(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != errFlagParse {
+		fmt.Fprintln(os.Stderr, err)
+	}
+	os.Exit(exitStatus(err))
+}
 
-		// 3. Read eml file
-		rawMessage, err = os.ReadFile(emlPath)
-		if err != nil {
-			log.Fatalf("Error reading eml file: %v", err)
-		}
-		sourceFile = emlPath
+// Exit statuses of the failure classes (sysexits.h), so that callers can tell a message
+// that will never be analyzed from a failure worth retrying.
+const (
+	exDataErr     = 65 // The message cannot be parsed.
+	exUnavailable = 69 // The message is bounced (content-filter).
+	exTempFail    = 75 // The LLM endpoint is unavailable or rate limited; retry later.
+	exProtocol    = 76 // The model's response contained no usable verdict.
+	exConfig      = 78 // The configuration is invalid.
+)
+
+// exitStatus returns the exit status of a command that failed with err: the status of an
+// exitCodeError, 2 for usage errors, that of the failure class, or 1.
+func exitStatus(err error) int {
+	var exitErr exitCodeError
+	var usageErr usageError
+	switch {
+	case errors.As(err, &exitErr):
+		return exitErr.code
+	case errors.As(err, &usageErr):
+		return 2
+	case errors.Is(err, config.ErrConfig):
+		return exConfig
+	case errors.Is(err, email.ErrParse):
+		return exDataErr
+	case errors.Is(err, llm.ErrProviderUnavailable), errors.Is(err, llm.ErrRateLimited):
+		return exTempFail
+	case errors.Is(err, llm.ErrBadModelOutput):
+		return exProtocol
 	}
+	return 1
+}
+
+// printUsage prints the list of commands.
+func printUsage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: mail-analyzer <command> [flags] [arguments]\n\nCommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-14s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(w, "\nRun \"mail-analyzer <command> -h\" for the flags of a command.\n")
+}
 
-	// Ensure at least one of OpenAIAPIKey or OpenAIAPIBaseURL is set
-	// If OpenAIAPIBaseURL is set, APIKey can be empty (for local LLMs)
-	if cfg.OpenAIAPIKey == "" && cfg.OpenAIBaseURL == "" {
-		log.Fatal("OPENAI_API_KEY or OPENAI_API_BASE_URL must be set in config file or environment variable.")
+// newFlagSet returns the flag set of a command, with a usage message listing its flags.
+func newFlagSet(name, arguments, description string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: mail-analyzer %s [flags] %s\n\n%s\n\nFlags:\n", name, arguments, description)
+		fs.PrintDefaults()
 	}
+	return fs
+}
 
-	// 2. Setup analyzer
-	llmProvider := llm.NewOpenAIProvider(cfg)
-	emailAnalyzer := analyzer.NewEmailAnalyzer(llmProvider)
+// usageError is an error in the command line, reported with exit status 2.
+type usageError struct {
+	error
+}
 
-	// 4. Process the message
-	var results []*AnalysisResult
-	parsedEmail, err := email.Parse(bytes.NewReader(rawMessage))
-	if err != nil {
-		log.Fatalf("Error parsing email: %v", err)
+func usageErrorf(format string, args ...any) error {
+	return usageError{fmt.Errorf(format, args...)}
+}
+
+// exitCodeError is an error reported with a specific exit status, for callers such as
+// MTAs that interpret it.
+type exitCodeError struct {
+	code int
+	error
+}
+
+// errFlagParse is returned for invalid flags, which the flag package has already
+// reported together with the usage message.
+var errFlagParse error = usageError{errors.New("invalid flags")}
+
+// parseFlags parses the flags of a command. It returns flag.ErrHelp for -h.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err == nil || errors.Is(err, flag.ErrHelp) {
+		return err
 	}
+	return errFlagParse
+}
 
-	judgment, err := emailAnalyzer.Analyze(context.Background(), parsedEmail)
-	if err != nil {
-		log.Fatalf("Error analyzing email (Message-ID: %s): %v", parsedEmail.MessageID, err)
+func runVersion(args []string) error {
+	fs := newFlagSet("version", "", "Print the version.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
 	}
+	fmt.Println("mail-analyzer", version)
+	return nil
+}
 
-	results = append(results, &AnalysisResult{
-		MessageID: parsedEmail.MessageID,
-		Subject:   parsedEmail.Subject,
-		From:      convertAddresses(parsedEmail.From),
-		To:        convertAddresses(parsedEmail.To),
-		Judgment:  judgment,
-	})
-
-	// 5. Output results as JSON
-	output := FinalOutput{
-		SourceFile:      sourceFile,
-		AnalysisResults: results,
+// newAnalysisID returns a random (version 4) UUID identifying one analysis.
+func newAnalysisID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		log.Fatalf("Error generating analysis ID: %v", err)
 	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
 
-	jsonOutput, err := json.MarshalIndent(output, "", "  ")
+// isTerminal reports whether f is attached to a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
 	if err != nil {
-		log.Fatalf("Error marshalling JSON: %v", err)
+		return false
 	}
-
-	fmt.Println(string(jsonOutput))
+	return info.Mode()&os.ModeCharDevice != 0
 }
 
 func convertAddresses(addresses []*mail.Address) []string {
 	var result []string
 	for _, addr := range addresses {
-		result = append(result, addr.String())
+		result = append(result, email.FormatAddress(addr))
 	}
 	return result
-}
\ No newline at end of file
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{errors.New("failed"), 1},
+		{usageErrorf("too many arguments"), 2},
+		{exitCodeError{exUnavailable, fmt.Errorf("error reinjecting message: %w", errors.New("rejected"))}, exUnavailable},
+		{fmt.Errorf("%w: %w", config.ErrConfig, errors.New("config.json: invalid character")), exConfig},
+		{fmt.Errorf("%w: %w", email.ErrParse, errors.New("malformed MIME header line")), exDataErr},
+		{fmt.Errorf("error analyzing email (Message-ID: 1@example.com): %w", llm.ErrProviderUnavailable), exTempFail},
+		{fmt.Errorf("error analyzing email (Message-ID: 1@example.com): %w", llm.ErrRateLimited), exTempFail},
+		{fmt.Errorf("error analyzing email (Message-ID: 1@example.com): %w", llm.ErrBadModelOutput), exProtocol},
+		// An exit status set by the command wins over the class of the error.
+		{exitCodeError{exTempFail, fmt.Errorf("%w: %w", email.ErrParse, errors.New("malformed"))}, exTempFail},
+	}
+	for _, tt := range tests {
+		if got := exitStatus(tt.err); got != tt.want {
+			t.Errorf("exitStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,207 @@
+// Package mta exchanges messages with mail transfer agents: it hands messages to the
+// sendmail command or an SMTP or LMTP server, and receives them over SMTP or LMTP.
+package mta
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Deliverer delivers a message to its recipients.
+type Deliverer interface {
+	Deliver(ctx context.Context, from string, to []string, msg []byte) error
+}
+
+// IsPermanent reports whether err is a permanent (5xx) rejection, which retrying
+// will not resolve. RecipientErrors are permanent if every rejection is.
+func IsPermanent(err error) bool {
+	var rcptErrs RecipientErrors
+	if errors.As(err, &rcptErrs) {
+		for _, err := range rcptErrs {
+			if !IsPermanent(err) {
+				return false
+			}
+		}
+		return len(rcptErrs) > 0
+	}
+	var protoErr *textproto.Error
+	return errors.As(err, &protoErr) && protoErr.Code >= 500
+}
+
+// Sendmail delivers messages with a sendmail-compatible command.
+type Sendmail struct {
+	Path string
+}
+
+func (s *Sendmail) Deliver(ctx context.Context, from string, to []string, msg []byte) error {
+	// -G marks the message as a relayed (gateway) submission, so it is not rewritten;
+	// -i keeps a line with a single dot from ending the message.
+	args := append([]string{"-G", "-i", "-f", from, "--"}, to...)
+	cmd := exec.CommandContext(ctx, s.Path, args...)
+	cmd.Stdin = bytes.NewReader(msg)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("%s: %w: %s", s.Path, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// RecipientErrors reports the recipients that were rejected when others were accepted,
+// keyed by address.
+type RecipientErrors map[string]error
+
+func (e RecipientErrors) Error() string {
+	msgs := make([]string, 0, len(e))
+	for rcpt, err := range e {
+		msgs = append(msgs, rcpt+": "+err.Error())
+	}
+	sort.Strings(msgs)
+	return "rejected recipients: " + strings.Join(msgs, "; ")
+}
+
+// SMTP delivers messages to an SMTP or LMTP server, such as the Postfix smtpd that
+// receives filtered messages or the Dovecot LMTP service. It does not authenticate or
+// use TLS, as it is meant for servers on the local host or network.
+//
+// If the server accepts some recipients and rejects others, Deliver delivers the message
+// to the accepted ones and returns RecipientErrors for the others.
+type SMTP struct {
+	Address string
+	// LMTP selects LMTP (RFC 2033), which reports the delivery to each recipient separately.
+	LMTP    bool
+	Timeout time.Duration
+
+	// dial is replaced in tests.
+	dial func(ctx context.Context) (net.Conn, error)
+}
+
+func (s *SMTP) protocol() string {
+	if s.LMTP {
+		return "lmtp"
+	}
+	return "smtp"
+}
+
+func (s *SMTP) dialServer(ctx context.Context) (net.Conn, error) {
+	if s.dial != nil {
+		return s.dial(ctx)
+	}
+	network := "tcp"
+	if strings.HasPrefix(s.Address, "/") {
+		network = "unix" // Dovecot's LMTP service usually listens on a socket.
+	}
+	return (&net.Dialer{Timeout: s.Timeout}).DialContext(ctx, network, s.Address)
+}
+
+func (s *SMTP) Deliver(ctx context.Context, from string, to []string, msg []byte) error {
+	if s.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.Timeout)
+		defer cancel()
+	}
+	conn, err := s.dialServer(ctx)
+	if err != nil {
+		return fmt.Errorf("%s: %w", s.protocol(), err)
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if err := s.session(textproto.NewConn(conn), from, to, msg); err != nil {
+		var rcptErrs RecipientErrors
+		if errors.As(err, &rcptErrs) {
+			return err
+		}
+		return fmt.Errorf("%s: %w", s.protocol(), err)
+	}
+	return nil
+}
+
+func (s *SMTP) session(c *textproto.Conn, from string, to []string, msg []byte) error {
+	if _, _, err := c.ReadResponse(220); err != nil {
+		return err
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+	hello := "EHLO"
+	if s.LMTP {
+		hello = "LHLO"
+	}
+	if err := command(c, 250, "%s %s", hello, hostname); err != nil {
+		return err
+	}
+	if err := command(c, 250, "MAIL FROM:<%s>", from); err != nil {
+		return err
+	}
+	rcptErrs := RecipientErrors{}
+	var accepted []string
+	for _, rcpt := range to {
+		if err := command(c, 25, "RCPT TO:<%s>", rcpt); err != nil {
+			var protoErr *textproto.Error
+			if !errors.As(err, &protoErr) {
+				return err
+			}
+			rcptErrs[rcpt] = err
+			continue
+		}
+		accepted = append(accepted, rcpt)
+	}
+	if len(accepted) == 0 {
+		return rcptErrs[to[0]]
+	}
+	if err := command(c, 354, "DATA"); err != nil {
+		return err
+	}
+	w := c.DotWriter()
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if !s.LMTP {
+		if _, _, err := c.ReadResponse(250); err != nil {
+			return err
+		}
+	} else {
+		// An LMTP server replies once for each accepted recipient.
+		for _, rcpt := range accepted {
+			if _, _, err := c.ReadResponse(250); err != nil {
+				var protoErr *textproto.Error
+				if !errors.As(err, &protoErr) {
+					return err
+				}
+				rcptErrs[rcpt] = err
+			}
+		}
+		if len(rcptErrs) == len(to) {
+			return rcptErrs[to[0]]
+		}
+	}
+	command(c, 221, "QUIT")
+	if len(rcptErrs) > 0 {
+		return rcptErrs
+	}
+	return nil
+}
+
+// command sends a command and reads its reply, which must have a code starting with
+// expectCode (see textproto.Conn.ReadResponse).
+func command(c *textproto.Conn, expectCode int, format string, args ...any) error {
+	if err := c.PrintfLine(format, args...); err != nil {
+		return err
+	}
+	_, _, err := c.ReadResponse(expectCode)
+	return err
+}
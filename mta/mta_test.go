@@ -0,0 +1,100 @@
+package mta
+
+import (
+	"context"
+	"net"
+	"net/textproto"
+	"strings"
+	"testing"
+)
+
+// fakeSMTPServer serves one SMTP session on conn, answering every command with the
+// reply in replies (default "250 OK"), and returns the commands and message received.
+func fakeSMTPServer(conn net.Conn, replies map[string]string) (commands []string, data string) {
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+		verb = strings.SplitN(verb, ":", 2)[0]
+		commands = append(commands, line)
+		reply, ok := replies[verb]
+		switch {
+		case ok:
+		case verb == "DATA":
+			reply = "354 Go ahead"
+		case verb == "QUIT":
+			reply = "221 Bye"
+		default:
+			reply = "250 OK"
+		}
+		c.PrintfLine("%s", reply)
+		if verb == "DATA" && strings.HasPrefix(reply, "354") {
+			b, _ := c.ReadDotBytes()
+			data = string(b)
+			c.PrintfLine("250 Queued")
+		}
+		if verb == "QUIT" {
+			return
+		}
+	}
+}
+
+func TestSMTPDeliver(t *testing.T) {
+	client, server := net.Pipe()
+	done := make(chan struct{})
+	var commands []string
+	var data string
+	go func() {
+		commands, data = fakeSMTPServer(server, nil)
+		close(done)
+	}()
+
+	s := &SMTP{dial: func(context.Context) (net.Conn, error) { return client, nil }}
+	msg := "Subject: Test\r\n\r\n.leading dot\r\nbody\r\n"
+	if err := s.Deliver(context.Background(), "alice@example.com", []string{"bob@example.com", "carol@example.com"}, []byte(msg)); err != nil {
+		t.Fatalf("Deliver() error = %v", err)
+	}
+	<-done
+
+	want := []string{"MAIL FROM:<alice@example.com>", "RCPT TO:<bob@example.com>", "RCPT TO:<carol@example.com>", "DATA", "QUIT"}
+	if len(commands) != len(want)+1 || !strings.HasPrefix(commands[0], "EHLO ") {
+		t.Fatalf("commands = %q", commands)
+	}
+	for i, w := range want {
+		if commands[i+1] != w {
+			t.Errorf("command %d = %q, want %q", i+1, commands[i+1], w)
+		}
+	}
+	// ReadDotBytes converts line endings to \n.
+	if want := strings.ReplaceAll(msg, "\r\n", "\n"); data != want {
+		t.Errorf("data = %q, want %q", data, want)
+	}
+}
+
+func TestSMTPDeliverRejected(t *testing.T) {
+	tests := []struct {
+		reply     string
+		permanent bool
+	}{
+		{"550 No such user", true},
+		{"451 Try again later", false},
+	}
+	for _, tt := range tests {
+		client, server := net.Pipe()
+		go fakeSMTPServer(server, map[string]string{"RCPT": tt.reply})
+
+		s := &SMTP{dial: func(context.Context) (net.Conn, error) { return client, nil }}
+		err := s.Deliver(context.Background(), "alice@example.com", []string{"bob@example.com"}, []byte("Subject: Test\r\n\r\n"))
+		if err == nil {
+			t.Fatalf("Deliver() with reply %q succeeded", tt.reply)
+		}
+		if IsPermanent(err) != tt.permanent {
+			t.Errorf("IsPermanent(%v) = %v, want %v", err, IsPermanent(err), tt.permanent)
+		}
+	}
+}
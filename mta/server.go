@@ -0,0 +1,309 @@
+package mta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxRecipients is the largest number of recipients accepted for one message.
+const maxRecipients = 100
+
+// HandlerFunc receives a message accepted by a Server. The message has LF line endings.
+// A *textproto.Error is returned to the client with its code; RecipientErrors are
+// returned per recipient in LMTP and as a temporary failure of the message in SMTP;
+// other errors are returned as temporary failures.
+type HandlerFunc func(ctx context.Context, from string, to []string, msg []byte) error
+
+// Server receives messages over SMTP or LMTP and passes them to Handler. It supports
+// the subset of the protocols needed to receive mail from an MTA on the local host or
+// network: no TLS or authentication.
+type Server struct {
+	// LMTP selects LMTP (RFC 2033) instead of SMTP.
+	LMTP     bool
+	Hostname string
+	// MaxMessageSize is the largest accepted message in bytes; 0 means no limit.
+	MaxMessageSize int64
+	// Timeout bounds the wait for each command from the client (default 5 minutes).
+	Timeout time.Duration
+	Handler HandlerFunc
+	// BaseContext is the context of the Handler calls (default context.Background()).
+	// Cancelling it cancels the messages being handled.
+	BaseContext context.Context
+
+	mu       sync.Mutex
+	listener net.Listener
+	closing  bool
+	sessions sync.WaitGroup
+}
+
+// ErrServerClosed is returned by Serve after Shutdown.
+var ErrServerClosed = errors.New("mta: server closed")
+
+// Serve accepts connections on l until Shutdown is called.
+func (s *Server) Serve(l net.Listener) error {
+	s.mu.Lock()
+	if s.closing {
+		s.mu.Unlock()
+		return ErrServerClosed
+	}
+	s.listener = l
+	s.mu.Unlock()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			s.mu.Lock()
+			closing := s.closing
+			s.mu.Unlock()
+			if closing {
+				return ErrServerClosed
+			}
+			return err
+		}
+		s.sessions.Add(1)
+		go func() {
+			defer s.sessions.Done()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+// Shutdown stops accepting connections and waits for the open sessions to end, or for
+// ctx to be done.
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.mu.Lock()
+	s.closing = true
+	if s.listener != nil {
+		s.listener.Close()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.sessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type session struct {
+	s    *Server
+	conn net.Conn
+	c    *textproto.Conn
+
+	greeted bool
+	from    *string
+	to      []string
+}
+
+func (s *Server) serveConn(conn net.Conn) {
+	defer conn.Close()
+	sess := &session{s: s, conn: conn, c: textproto.NewConn(conn)}
+	protocol := "ESMTP"
+	if s.LMTP {
+		protocol = "LMTP"
+	}
+	sess.reply(220, "%s %s mail-analyzer", s.hostname(), protocol)
+	for {
+		timeout := s.Timeout
+		if timeout == 0 {
+			timeout = 5 * time.Minute
+		}
+		conn.SetDeadline(time.Now().Add(timeout))
+		line, err := sess.c.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		if !sess.handle(strings.ToUpper(verb), arg) {
+			return
+		}
+	}
+}
+
+func (s *Server) hostname() string {
+	if s.Hostname != "" {
+		return s.Hostname
+	}
+	return "localhost"
+}
+
+func (sess *session) reply(code int, format string, args ...any) {
+	sess.c.PrintfLine("%d %s", code, fmt.Sprintf(format, args...))
+}
+
+func (sess *session) reset() {
+	sess.from, sess.to = nil, nil
+}
+
+// handle processes one command. It returns false when the session ends.
+func (sess *session) handle(verb, arg string) bool {
+	s := sess.s
+	switch verb {
+	case "EHLO", "HELO", "LHLO":
+		if s.LMTP != (verb == "LHLO") {
+			sess.reply(500, "5.5.1 Wrong protocol")
+			return true
+		}
+		sess.reset()
+		sess.greeted = true
+		if verb == "HELO" {
+			sess.reply(250, "%s", s.hostname())
+			return true
+		}
+		lines := []string{s.hostname(), "8BITMIME", "ENHANCEDSTATUSCODES"}
+		if s.MaxMessageSize > 0 {
+			lines = append(lines, fmt.Sprintf("SIZE %d", s.MaxMessageSize))
+		}
+		for i, l := range lines {
+			sep := "-"
+			if i == len(lines)-1 {
+				sep = " "
+			}
+			sess.c.PrintfLine("250%s%s", sep, l)
+		}
+	case "MAIL":
+		addr, ok := parsePath(arg, "FROM:")
+		switch {
+		case !sess.greeted:
+			sess.reply(503, "5.5.1 Say hello first")
+		case sess.from != nil:
+			sess.reply(503, "5.5.1 Sender already given")
+		case !ok:
+			sess.reply(501, "5.5.4 Syntax: MAIL FROM:<address>")
+		default:
+			sess.from = &addr
+			sess.reply(250, "2.1.0 OK")
+		}
+	case "RCPT":
+		addr, ok := parsePath(arg, "TO:")
+		switch {
+		case sess.from == nil:
+			sess.reply(503, "5.5.1 Need MAIL first")
+		case !ok || addr == "":
+			sess.reply(501, "5.5.4 Syntax: RCPT TO:<address>")
+		case len(sess.to) >= maxRecipients:
+			sess.reply(452, "4.5.3 Too many recipients")
+		default:
+			sess.to = append(sess.to, addr)
+			sess.reply(250, "2.1.5 OK")
+		}
+	case "DATA":
+		if len(sess.to) == 0 {
+			sess.reply(503, "5.5.1 Need RCPT first")
+			return true
+		}
+		sess.data()
+	case "RSET":
+		sess.reset()
+		sess.reply(250, "2.0.0 OK")
+	case "NOOP":
+		sess.reply(250, "2.0.0 OK")
+	case "VRFY":
+		sess.reply(252, "2.5.0 Cannot verify")
+	case "QUIT":
+		sess.reply(221, "2.0.0 Bye")
+		return false
+	default:
+		sess.reply(502, "5.5.2 Command not implemented")
+	}
+	return true
+}
+
+func (sess *session) data() {
+	s := sess.s
+	sess.reply(354, "Send message, end with <CRLF>.<CRLF>")
+	dot := sess.c.DotReader()
+	r := dot
+	if s.MaxMessageSize > 0 {
+		r = io.LimitReader(dot, s.MaxMessageSize+1)
+	}
+	msg, err := io.ReadAll(r)
+	if err != nil {
+		return
+	}
+	if s.MaxMessageSize > 0 && int64(len(msg)) > s.MaxMessageSize {
+		io.Copy(io.Discard, dot)
+		sess.replyAll(&textproto.Error{Code: 552, Msg: "5.3.4 Message too big"})
+		sess.reset()
+		return
+	}
+
+	// The client waits for the reply, so the deadline covers the handler too.
+	sess.conn.SetDeadline(time.Time{})
+	ctx := s.BaseContext
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	err = s.Handler(ctx, *sess.from, sess.to, msg)
+	sess.replyAll(err)
+	sess.reset()
+}
+
+// replyAll sends the reply to DATA: once in SMTP, and once per recipient in LMTP.
+func (sess *session) replyAll(err error) {
+	if !sess.s.LMTP {
+		var rcptErrs RecipientErrors
+		if errors.As(err, &rcptErrs) {
+			// SMTP cannot report a partial delivery, so the client retries every recipient.
+			err = errors.New(rcptErrs.Error())
+		}
+		sess.replyError(err)
+		return
+	}
+	for _, rcpt := range sess.to {
+		var rcptErrs RecipientErrors
+		if errors.As(err, &rcptErrs) {
+			sess.replyError(rcptErrs[rcpt])
+		} else {
+			sess.replyError(err)
+		}
+	}
+}
+
+func (sess *session) replyError(err error) {
+	var protoErr *textproto.Error
+	switch {
+	case err == nil:
+		sess.reply(250, "2.0.0 OK")
+	case errors.As(err, &protoErr):
+		sess.reply(protoErr.Code, "%s", oneLine(protoErr.Msg))
+	default:
+		log.Printf("Delivery failed: %v", err)
+		sess.reply(451, "4.3.0 %s", oneLine(err.Error()))
+	}
+}
+
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
+
+// parsePath parses the argument of MAIL or RCPT, such as "FROM:<a@example.com> SIZE=10".
+// Parameters are ignored.
+func parsePath(arg, prefix string) (string, bool) {
+	if len(arg) < len(prefix) || !strings.EqualFold(arg[:len(prefix)], prefix) {
+		return "", false
+	}
+	path := strings.TrimSpace(arg[len(prefix):])
+	if !strings.HasPrefix(path, "<") {
+		return "", false
+	}
+	end := strings.IndexByte(path, '>')
+	if end < 0 {
+		return "", false
+	}
+	return path[1:end], true
+}
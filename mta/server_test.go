@@ -0,0 +1,87 @@
+package mta
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/textproto"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func startServer(t *testing.T, s *Server) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve(l)
+	t.Cleanup(func() { s.Shutdown(context.Background()) })
+	return l.Addr().String()
+}
+
+func TestServerLMTP(t *testing.T) {
+	var gotFrom string
+	var gotTo []string
+	var gotMsg string
+	addr := startServer(t, &Server{LMTP: true, Handler: func(ctx context.Context, from string, to []string, msg []byte) error {
+		gotFrom, gotTo, gotMsg = from, to, string(msg)
+		return RecipientErrors{"carol@example.com": &textproto.Error{Code: 550, Msg: "5.1.1 No such user"}}
+	}})
+
+	client := &SMTP{Address: addr, LMTP: true}
+	msg := "Subject: Test\r\n\r\n..dot\r\n"
+	err := client.Deliver(context.Background(), "alice@example.com", []string{"bob@example.com", "carol@example.com"}, []byte(msg))
+	var rcptErrs RecipientErrors
+	if !errors.As(err, &rcptErrs) || len(rcptErrs) != 1 || rcptErrs["carol@example.com"] == nil || !IsPermanent(err) {
+		t.Fatalf("Deliver() error = %v, want a permanent rejection of carol@example.com", err)
+	}
+	if gotFrom != "alice@example.com" || !reflect.DeepEqual(gotTo, []string{"bob@example.com", "carol@example.com"}) {
+		t.Errorf("handler got from %q to %q", gotFrom, gotTo)
+	}
+	if want := "Subject: Test\n\n..dot\n"; gotMsg != want {
+		t.Errorf("handler got message %q, want %q", gotMsg, want)
+	}
+}
+
+func TestServerSMTP(t *testing.T) {
+	addr := startServer(t, &Server{MaxMessageSize: 64, Handler: func(ctx context.Context, from string, to []string, msg []byte) error {
+		if strings.Contains(string(msg), "fail") {
+			return errors.New("destination unreachable")
+		}
+		return nil
+	}})
+	client := &SMTP{Address: addr}
+
+	tests := []struct {
+		msg       string
+		wantErr   bool
+		permanent bool
+	}{
+		{"Subject: Test\r\n\r\nbody\r\n", false, false},
+		{"Subject: Test\r\n\r\nfail\r\n", true, false},
+		{"Subject: Test\r\n\r\n" + strings.Repeat("x", 100) + "\r\n", true, true},
+	}
+	for _, tt := range tests {
+		err := client.Deliver(context.Background(), "", []string{"bob@example.com"}, []byte(tt.msg))
+		if (err != nil) != tt.wantErr || IsPermanent(err) != tt.permanent {
+			t.Errorf("Deliver(%q) error = %v, want error %v, permanent %v", tt.msg, err, tt.wantErr, tt.permanent)
+		}
+	}
+
+	// Commands out of order are rejected.
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	c := textproto.NewConn(conn)
+	c.ReadResponse(220)
+	for _, cmd := range []string{"MAIL FROM:<a@example.com>", "LHLO x"} {
+		c.PrintfLine("%s", cmd)
+		if code, _, _ := c.ReadResponse(0); code < 500 {
+			t.Errorf("%s before EHLO = %d, want an error", cmd, code)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"mail-analyzer/analyzer"
+)
+
+// requestOptions are the analysis options that the client of a server can set for one
+// message. The zero value uses the configuration.
+type requestOptions struct {
+	Categories   []string `json:"categories,omitempty"`
+	Language     string   `json:"language,omitempty"`
+	MaxBodyBytes int      `json:"max_body_bytes,omitempty"`
+	// Enrichments are the enrichments to run; nil runs those of the configuration.
+	Enrichments []string `json:"enrichments"`
+	// Templates is the name of a template set of the configuration.
+	Templates string `json:"templates,omitempty"`
+	// HeadersOnly judges the message by its header alone, like --headers-only.
+	HeadersOnly bool `json:"headers_only,omitempty"`
+	// NeedsContext lets the model ask for context in needs_context, and Answers are the
+	// answers of the analyst to the questions of a previous analysis.
+	NeedsContext bool              `json:"needs_context,omitempty"`
+	Answers      []analyzer.Answer `json:"answers,omitempty"`
+}
+
+// queryOptions returns the options of the query parameters of a request. Lists are
+// separated by commas, and an empty enrichments parameter disables the enrichments.
+// Answers are given as repeated question and answer parameters, in the same order.
+func queryOptions(query url.Values) (*requestOptions, error) {
+	o := &requestOptions{
+		Categories: splitList(query.Get("categories")),
+		Language:   query.Get("language"),
+		Templates:  query.Get("templates"),
+	}
+	if query.Has("enrichments") {
+		o.Enrichments = append([]string{}, splitList(query.Get("enrichments"))...)
+	}
+	if value := query.Get("headers_only"); value != "" {
+		headersOnly, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid headers_only %q", value)
+		}
+		o.HeadersOnly = headersOnly
+	}
+	if value := query.Get("needs_context"); value != "" {
+		needsContext, err := strconv.ParseBool(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid needs_context %q", value)
+		}
+		o.NeedsContext = needsContext
+	}
+	questions, answers := query["question"], query["answer"]
+	if len(questions) != len(answers) {
+		return nil, fmt.Errorf("%d question parameters but %d answer parameters", len(questions), len(answers))
+	}
+	for i, question := range questions {
+		o.Answers = append(o.Answers, analyzer.Answer{Question: question, Answer: answers[i]})
+	}
+	if value := query.Get("max_body_bytes"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid max_body_bytes %q", value)
+		}
+		o.MaxBodyBytes = n
+	}
+	return o, nil
+}
+
+// splitList returns the non-empty elements of a comma-separated list.
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// analysisOptions returns the analyzer options of o, or an error if p cannot honor them.
+func (p *pipeline) analysisOptions(o *requestOptions) (*analyzer.AnalysisOptions, error) {
+	if o == nil {
+		return nil, nil
+	}
+	opts := &analyzer.AnalysisOptions{
+		Categories:   o.Categories,
+		Language:     o.Language,
+		MaxBodyBytes: o.MaxBodyBytes,
+		Enrichments:  o.Enrichments,
+		HeadersOnly:  o.HeadersOnly,
+		NeedsContext: o.NeedsContext,
+		Answers:      o.Answers,
+	}
+	if o.Templates != "" {
+		if opts.Templates = p.templateSets[o.Templates]; opts.Templates == nil {
+			names := make([]string, 0, len(p.templateSets))
+			for name := range p.templateSets {
+				names = append(names, name)
+			}
+			slices.Sort(names)
+			return nil, fmt.Errorf("unknown template set %q (configured: %s)", o.Templates, strings.Join(names, ", "))
+		}
+	}
+	if err := p.analyzer.CheckOptions(opts); err != nil {
+		return nil, err
+	}
+	return opts, nil
+}
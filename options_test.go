@@ -0,0 +1,48 @@
+package main
+
+import (
+	"net/url"
+	"reflect"
+	"testing"
+
+	"mail-analyzer/analyzer"
+)
+
+func TestQueryOptions(t *testing.T) {
+	tests := []struct {
+		query   string
+		want    *requestOptions
+		wantErr bool
+	}{
+		{query: "", want: &requestOptions{}},
+		{
+			query: "categories=Phishing,%20Scam,,Safe&language=Japanese&max_body_bytes=8000&templates=ja",
+			want:  &requestOptions{Categories: []string{"Phishing", "Scam", "Safe"}, Language: "Japanese", MaxBodyBytes: 8000, Templates: "ja"},
+		},
+		{query: "enrichments=", want: &requestOptions{Enrichments: []string{}}},
+		{query: "enrichments=dns,auth", want: &requestOptions{Enrichments: []string{"dns", "auth"}}},
+		{query: "headers_only=true", want: &requestOptions{HeadersOnly: true}},
+		{
+			query: "needs_context=1&question=Is+partner.example+a+partner%3F&answer=Yes&question=Was+an+invoice+expected%3F&answer=No",
+			want: &requestOptions{NeedsContext: true, Answers: []analyzer.Answer{
+				{Question: "Is partner.example a partner?", Answer: "Yes"},
+				{Question: "Was an invoice expected?", Answer: "No"},
+			}},
+		},
+		{query: "max_body_bytes=lots", wantErr: true},
+		{query: "needs_context=maybe", wantErr: true},
+		{query: "question=Is+partner.example+a+partner%3F", wantErr: true},
+		{query: "headers_only=maybe", wantErr: true},
+	}
+	for _, tt := range tests {
+		query, _ := url.ParseQuery(tt.query)
+		got, err := queryOptions(query)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("queryOptions(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("queryOptions(%q) = %+v, want %+v", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+// Package org describes the organization whose mail is analyzed: its domains, brands,
+// executives, email service providers and partners. It is kept in its own file, apart from
+// the operational configuration, so that the security team can maintain it, and is used
+// to detect impersonation and lookalike domains and to give the model context.
+package org
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"mail-analyzer/email"
+)
+
+// Context is the content of the organization file, such as:
+//
+//	name: Example Corp
+//	internal_domains: [example.com, example.co.jp]
+//	brands: [Example, ExamplePay]
+//	executives:
+//	  - name: Jane Doe
+//	    title: CEO
+//	    emails: [jane.doe@example.com]
+//	expected_esps: [sendgrid.net, mcsv.net]
+//	partner_domains: [supplier.example.net]
+type Context struct {
+	Name            string      `yaml:"name"`
+	InternalDomains []string    `yaml:"internal_domains"`
+	Brands          []string    `yaml:"brands"`
+	Executives      []Executive `yaml:"executives"`
+	// ExpectedESPs are the domains of the email service providers that send mail on
+	// behalf of the organization, such as newsletters and notifications.
+	ExpectedESPs   []string `yaml:"expected_esps"`
+	PartnerDomains []string `yaml:"partner_domains"`
+}
+
+// Executive is a person whose name is likely to be used in business email compromise.
+type Executive struct {
+	Name  string `yaml:"name"`
+	Title string `yaml:"title"`
+	// Emails are the addresses the executive sends from.
+	Emails []string `yaml:"emails"`
+}
+
+// Load reads the organization file at path.
+func Load(path string) (*Context, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	c := &Context{}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(c); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := c.normalize(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
+
+// normalize lowercases the domains and addresses, and checks that they are valid.
+func (c *Context) normalize() error {
+	for _, list := range []*[]string{&c.InternalDomains, &c.ExpectedESPs, &c.PartnerDomains} {
+		for i, domain := range *list {
+			domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+			if domain == "" || strings.ContainsAny(domain, "@/ ") {
+				return fmt.Errorf("invalid domain %q", (*list)[i])
+			}
+			(*list)[i] = domain
+		}
+	}
+	for i := range c.Executives {
+		exec := &c.Executives[i]
+		if strings.TrimSpace(exec.Name) == "" {
+			return fmt.Errorf("executive %d has no name", i+1)
+		}
+		for j, address := range exec.Emails {
+			if !strings.Contains(address, "@") {
+				return fmt.Errorf("executive %s: invalid address %q", exec.Name, address)
+			}
+			exec.Emails[j] = strings.ToLower(strings.TrimSpace(address))
+		}
+	}
+	return nil
+}
+
+// Kinds of Finding.
+const (
+	// FindingExecutive is the display name of an executive on another address.
+	FindingExecutive = "executive_impersonation"
+	// FindingLookalike is a domain that imitates an internal or partner domain.
+	FindingLookalike = "lookalike_domain"
+	// FindingBrand is a brand in a message from outside the organization.
+	FindingBrand = "brand_impersonation"
+	// FindingEnvelope is an internal sender whose envelope sender is outside.
+	FindingEnvelope = "external_envelope_sender"
+)
+
+// Finding is a sign of impersonation of the organization.
+type Finding struct {
+	Kind string
+	// Target is the address or domain of the message that the finding is about.
+	Target string
+	// Detail explains the finding.
+	Detail string
+}
+
+// Findings returns the signs of impersonation of the organization in e: a display name of
+// an executive on another address, lookalike domains of the internal and partner domains,
+// brands in mail from outside, and internal senders whose envelope sender is outside.
+func (c *Context) Findings(e *email.ParsedEmail) []Finding {
+	var findings []Finding
+	var from, fromDomain, displayName string
+	if len(e.From) > 0 {
+		from = strings.ToLower(e.From[0].Address)
+		fromDomain = domainOf(from)
+		displayName = e.From[0].Name
+	}
+
+	if displayName != "" {
+		for _, exec := range c.Executives {
+			if sameName(displayName, exec.Name) && !slices.Contains(exec.Emails, from) && !c.isInternal(fromDomain) {
+				findings = append(findings, Finding{FindingExecutive, from, fmt.Sprintf("The display name %q is that of the executive %s, but the address %s is not theirs.", displayName, exec.Describe(), from)})
+			}
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, candidate := range c.senderDomains(e) {
+		if seen[candidate.domain] {
+			continue
+		}
+		seen[candidate.domain] = true
+		if protected, ok := c.lookalike(candidate.domain); ok {
+			findings = append(findings, Finding{FindingLookalike, candidate.domain, fmt.Sprintf("The %s domain %s looks like %s, but is not.", candidate.source, candidate.domain, protected)})
+		}
+	}
+
+	if fromDomain != "" && !c.isKnown(fromDomain) {
+		text := strings.ToLower(displayName + " " + e.Subject)
+		for _, brand := range c.Brands {
+			if brand != "" && containsWord(text, strings.ToLower(brand)) {
+				findings = append(findings, Finding{FindingBrand, fromDomain, fmt.Sprintf("The sender or subject mentions the brand %s, but the message is from %s, outside the organization.", brand, fromDomain)})
+			}
+		}
+	}
+
+	if c.isInternal(fromDomain) {
+		if returnPath, err := e.Header.Text("Return-Path"); err == nil {
+			envelope := domainOf(strings.Trim(returnPath, "<> "))
+			if envelope != "" && !c.isInternal(envelope) && !matchesAny(envelope, c.ExpectedESPs) {
+				findings = append(findings, Finding{FindingEnvelope, envelope, fmt.Sprintf("The sender claims the internal domain %s, but the envelope sender domain %s is neither internal nor an expected email service provider.", fromDomain, envelope)})
+			}
+		}
+	}
+	return findings
+}
+
+type senderDomain struct {
+	source, domain string
+}
+
+// senderDomains returns the domains of the sender and reply addresses and of the URLs of e.
+func (c *Context) senderDomains(e *email.ParsedEmail) []senderDomain {
+	var domains []senderDomain
+	for _, addr := range e.From {
+		domains = append(domains, senderDomain{"sender", domainOf(addr.Address)})
+	}
+	if replyTo, err := e.Header.AddressList("Reply-To"); err == nil {
+		for _, addr := range replyTo {
+			domains = append(domains, senderDomain{"Reply-To", domainOf(addr.Address)})
+		}
+	}
+	for _, raw := range e.URLs {
+		if u, err := url.Parse(raw); err == nil && u.Hostname() != "" {
+			domains = append(domains, senderDomain{"link", strings.ToLower(u.Hostname())})
+		}
+	}
+	return domains
+}
+
+// lookalike returns the internal or partner domain that domain imitates, if any.
+func (c *Context) lookalike(domain string) (string, bool) {
+	if domain == "" || c.isKnown(domain) {
+		return "", false
+	}
+	for _, protected := range slices.Concat(c.InternalDomains, c.PartnerDomains) {
+		if isLookalike(domain, protected) {
+			return protected, true
+		}
+	}
+	return "", false
+}
+
+// isInternal reports whether domain is an internal domain or one of its subdomains.
+func (c *Context) isInternal(domain string) bool {
+	return domain != "" && matchesAny(domain, c.InternalDomains)
+}
+
+// isKnown reports whether domain belongs to the organization, its partners or its email
+// service providers.
+func (c *Context) isKnown(domain string) bool {
+	return matchesAny(domain, c.InternalDomains) || matchesAny(domain, c.PartnerDomains) || matchesAny(domain, c.ExpectedESPs)
+}
+
+func matchesAny(domain string, domains []string) bool {
+	for _, d := range domains {
+		if domain == d || strings.HasSuffix(domain, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// isLookalike reports whether domain imitates protected: it differs from it by one
+// character, if the name is long enough not to be so close by chance, reads the same once
+// confusable characters are replaced, or has the same name under another top-level domain.
+// Subdomains of the lookalike are included.
+func isLookalike(domain, protected string) bool {
+	name, tld := splitTLD(protected)
+	for candidate := domain; strings.Contains(candidate, "."); {
+		candidateName, candidateTLD := splitTLD(candidate)
+		switch {
+		case candidateName == name && candidateTLD != tld:
+			return true
+		case len(name) >= 5 && editDistance(candidate, protected) == 1:
+			return true
+		case skeleton(candidate) == skeleton(protected):
+			return true
+		}
+		_, candidate, _ = strings.Cut(candidate, ".")
+	}
+	return false
+}
+
+// splitTLD splits domain into its name and its last label.
+func splitTLD(domain string) (string, string) {
+	i := strings.LastIndex(domain, ".")
+	if i < 0 {
+		return domain, ""
+	}
+	return domain[:i], domain[i+1:]
+}
+
+// confusables replaces the characters and sequences that are mistaken for others.
+var confusables = strings.NewReplacer("rn", "m", "vv", "w", "0", "o", "1", "l", "i", "l", "5", "s", "-", "")
+
+func skeleton(domain string) string {
+	return confusables.Replace(domain)
+}
+
+// editDistance returns the Levenshtein distance between a and b, where swapping two
+// adjacent characters counts as one edit.
+func editDistance(a, b string) int {
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return prev[len(b)]
+}
+
+// sameName reports whether two person names are the same, ignoring case, extra spaces and
+// the "Last, First" order.
+func sameName(a, b string) bool {
+	normalize := func(name string) string {
+		name = strings.Trim(strings.ToLower(name), `"' `)
+		if last, first, ok := strings.Cut(name, ","); ok {
+			name = first + " " + last
+		}
+		return strings.Join(strings.Fields(name), " ")
+	}
+	return normalize(a) == normalize(b)
+}
+
+// containsWord reports whether text contains word, not as part of a longer word.
+func containsWord(text, word string) bool {
+	for i := 0; ; {
+		j := strings.Index(text[i:], word)
+		if j < 0 {
+			return false
+		}
+		start, end := i+j, i+j+len(word)
+		if (start == 0 || !isWordByte(text[start-1])) && (end == len(text) || !isWordByte(text[end])) {
+			return true
+		}
+		i = start + 1
+	}
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c >= 0x80
+}
+
+// Describe returns the name of the executive with their title.
+func (exec Executive) Describe() string {
+	if exec.Title != "" {
+		return exec.Name + " (" + exec.Title + ")"
+	}
+	return exec.Name
+}
+
+// domainOf returns the lowercased domain of address.
+func domainOf(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return strings.ToLower(strings.TrimSuffix(domain, "."))
+}
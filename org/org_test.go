@@ -0,0 +1,146 @@
+package org
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"mail-analyzer/email"
+)
+
+const testOrg = `
+name: Example Corp
+internal_domains: [Example.com]
+brands: [ExamplePay]
+executives:
+  - name: Jane Doe
+    title: CEO
+    emails: [Jane.Doe@example.com]
+expected_esps: [sendgrid.net]
+partner_domains: [supplier.net]
+`
+
+func writeOrg(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "org.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func parse(t *testing.T, headers string) *email.ParsedEmail {
+	t.Helper()
+	e, err := email.Parse(strings.NewReader(strings.ReplaceAll(headers, "\n", "\r\n") + "\r\nSee https://www.example.com/ for details.\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestLoad(t *testing.T) {
+	c, err := Load(writeOrg(t, testOrg))
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if !slices.Equal(c.InternalDomains, []string{"example.com"}) || !slices.Equal(c.Executives[0].Emails, []string{"jane.doe@example.com"}) {
+		t.Errorf("Load() = %+v, want lowercased domains and addresses", c)
+	}
+
+	for name, content := range map[string]string{
+		"unknown key":             "internal_domain: [example.com]\n",
+		"address as domain":       "internal_domains: [ceo@example.com]\n",
+		"executive without name":  "executives:\n  - title: CEO\n",
+		"invalid executive email": "executives:\n  - name: Jane Doe\n    emails: [jane]\n",
+	} {
+		if _, err := Load(writeOrg(t, content)); err == nil {
+			t.Errorf("Load() with %s: want error", name)
+		}
+	}
+	if _, err := Load(writeOrg(t, "")); err != nil {
+		t.Errorf("Load() of an empty file: error = %v", err)
+	}
+}
+
+func TestFindings(t *testing.T) {
+	c, err := Load(writeOrg(t, testOrg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		headers string
+		want    []string
+	}{
+		{
+			name:    "Executive on their address",
+			headers: "From: Jane Doe <jane.doe@example.com>\nReturn-Path: <bounce@example.com>\nSubject: Hello\n",
+		},
+		{
+			name:    "Executive on another address",
+			headers: "From: \"Doe, Jane\" <ceo.jane@gmail.com>\nSubject: Urgent wire transfer\n",
+			want:    []string{"executive Jane Doe (CEO)"},
+		},
+		{
+			name:    "Lookalike sender",
+			headers: "From: IT <it@examp1e.com>\nSubject: Password expiry\n",
+			want:    []string{"sender domain examp1e.com looks like example.com"},
+		},
+		{
+			name:    "Lookalike Reply-To under another TLD",
+			headers: "From: Supplier <billing@supplier.net>\nReply-To: billing@supplier.co\nSubject: New bank details\n",
+			want:    []string{"Reply-To domain supplier.co looks like supplier.net"},
+		},
+		{
+			name:    "Brand from outside",
+			headers: "From: ExamplePay Support <support@pay-help.net>\nSubject: Your account\n",
+			want:    []string{"brand ExamplePay"},
+		},
+		{
+			name:    "Internal sender through an expected ESP",
+			headers: "From: News <news@example.com>\nReturn-Path: <bounces+123@em.sendgrid.net>\nSubject: Newsletter\n",
+		},
+		{
+			name:    "Internal sender from outside",
+			headers: "From: HR <hr@example.com>\nReturn-Path: <x@mailer.example.org>\nSubject: Payroll\n",
+			want:    []string{"envelope sender domain mailer.example.org"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := c.Findings(parse(t, tt.headers))
+			if len(got) != len(tt.want) {
+				t.Fatalf("Findings() = %+v, want %d findings", got, len(tt.want))
+			}
+			for i, want := range tt.want {
+				if !strings.Contains(got[i].Detail, want) {
+					t.Errorf("Findings()[%d] = %+v, want it to contain %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestIsLookalike(t *testing.T) {
+	tests := []struct {
+		domain, protected string
+		want              bool
+	}{
+		{"examp1e.com", "example.com", true},
+		{"exampel.com", "example.com", true},
+		{"exarnple.com", "example.com", true},
+		{"example.net", "example.com", true},
+		{"login.example-com.net", "example.com", false},
+		{"login.exarnple.com", "example.com", true},
+		{"sample.com", "example.com", false},
+		{"acme.com", "acne.com", false},
+	}
+	for _, tt := range tests {
+		if got := isLookalike(tt.domain, tt.protected); got != tt.want {
+			t.Errorf("isLookalike(%q, %q) = %v, want %v", tt.domain, tt.protected, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package outbreak
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"unicode"
+
+	"mail-analyzer/email"
+)
+
+// minShingles is the number of word shingles of the shortest body that is hashed, since
+// the hashes of short bodies, such as "See the attachment.", match unrelated messages.
+const minShingles = 8
+
+// shingleSize is the number of words of a shingle.
+const shingleSize = 2
+
+// Fingerprint is what the Detector compares between messages.
+type Fingerprint struct {
+	// URLs are the scheme, host and path of the URLs of the message, without their query,
+	// which often identifies the recipient.
+	URLs []string
+	// BodyHash is the SimHash of the words of the body without its URLs, whose hashes
+	// differ in few bits for similar bodies, or 0 if the body is too short.
+	BodyHash uint64
+	// Network is the /24 (IPv4) or /48 (IPv6) network of the server that sent the message,
+	// if it is known.
+	Network netip.Prefix
+}
+
+// NewFingerprint returns the fingerprint of e.
+func NewFingerprint(e *email.ParsedEmail) *Fingerprint {
+	// The URLs are left out of the body, since they often differ for each recipient.
+	body := e.Body
+	for _, raw := range e.URLs {
+		body = strings.ReplaceAll(body, raw, " ")
+	}
+	f := &Fingerprint{BodyHash: simHash(body)}
+	for _, raw := range e.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || u.Host == "" {
+			continue
+		}
+		key := strings.ToLower(u.Scheme+"://"+u.Host) + u.EscapedPath()
+		if !slices.Contains(f.URLs, key) {
+			f.URLs = append(f.URLs, key)
+		}
+	}
+	if ip := email.SourceIP(e.Header.Values("Received"), nil); ip.IsValid() {
+		bits := 24
+		if ip.Is6() {
+			bits = 48
+		}
+		f.Network, _ = ip.Prefix(bits)
+	}
+	return f
+}
+
+// simHash returns the SimHash (Charikar) of the word shingles of text, or 0 if it has
+// fewer than minShingles.
+func simHash(text string) uint64 {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	if len(words)-shingleSize+1 < minShingles {
+		return 0
+	}
+	var weights [64]int
+	for i := 0; i+shingleSize <= len(words); i++ {
+		h := fnv.New64a()
+		h.Write([]byte(strings.Join(words[i:i+shingleSize], " ")))
+		sum := h.Sum64()
+		for b := range weights {
+			if sum&(1<<b) != 0 {
+				weights[b]++
+			} else {
+				weights[b]--
+			}
+		}
+	}
+	var hash uint64
+	for b, w := range weights {
+		if w > 0 {
+			hash |= 1 << b
+		}
+	}
+	return hash
+}
+
+// distance returns the number of bits that differ between the SimHashes a and b.
+func distance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,319 @@
+// Package outbreak detects the outbreaks of campaigns in the messages analyzed by a
+// server: many similar messages, which share a URL, their body or the network of their
+// sender, arriving within a time window, whatever their verdicts.
+package outbreak
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-analyzer/sink"
+)
+
+// Kinds of outbreaks, by what the messages share.
+const (
+	KindURL     = "url"
+	KindBody    = "body"
+	KindNetwork = "network"
+)
+
+// maxDistance is the number of bits by which the SimHashes of similar bodies may differ.
+const maxDistance = 8
+
+// maxObservations bounds the messages kept in the window, and thus the memory used
+// during a flood. The oldest ones are forgotten first.
+const maxObservations = 100_000
+
+// maxSamples is the number of senders, subjects and analysis IDs listed in an alert.
+const maxSamples = 10
+
+// bodyBands is the number of bands of the SimHash of a body by which the bodies are
+// indexed. Two hashes that differ by at most maxDistance bits have at least one band of
+// maxDistance+1 in common, so that the similar bodies are among those that share a band.
+const bodyBands = maxDistance + 1
+
+// Detector finds the outbreaks among the messages it observes. It raises one alert per
+// outbreak, as long as similar messages keep arriving within its Window of one another.
+type Detector struct {
+	// Threshold is the number of similar messages within Window that make an outbreak.
+	Threshold int
+	Window    time.Duration
+
+	now func() time.Time
+
+	mu sync.Mutex
+	// seen are the messages observed within the window, oldest first.
+	seen []*observation
+	// urls and networks index the messages of seen by their URLs and networks, and
+	// bodies by the bands of the hashes of their bodies, oldest first.
+	urls     map[string]*similar
+	networks map[string]*similar
+	bodies   map[uint32][]*observation
+	// seq numbers the observations, in order.
+	seq uint64
+}
+
+// similar are the messages of the window that share an indicator, such as a URL, oldest
+// first, and the number of them that were reported in an outbreak of the indicator.
+type similar struct {
+	seen    []*observation
+	alerted int
+}
+
+// observation is a message observed by a Detector.
+type observation struct {
+	seq      uint64
+	at       time.Time
+	urls     []string
+	bodyHash uint64
+	// buckets are the keys of the bands of bodyHash in the index of bodies.
+	buckets    [bodyBands]uint32
+	network    string
+	analysisID string
+	from       []string
+	subject    string
+	suspicious bool
+	// alerted are the indicators, such as "url:https://example.com/login" or "body", of
+	// the outbreaks that the message was reported in.
+	alerted []string
+}
+
+// New returns a Detector of threshold similar messages within window.
+func New(threshold int, window time.Duration) *Detector {
+	return &Detector{Threshold: threshold, Window: window, now: time.Now}
+}
+
+// Observe adds the message of result, with fingerprint f, to the messages of the window,
+// and returns the outbreaks that it starts.
+func (d *Detector) Observe(f *Fingerprint, result *sink.Result) []*sink.Outbreak {
+	now := d.now()
+	o := &observation{
+		at:         now,
+		bodyHash:   f.BodyHash,
+		analysisID: result.AnalysisID,
+		from:       result.From,
+		subject:    result.Subject,
+		suspicious: result.Judgment != nil && result.Judgment.IsSuspicious,
+	}
+	distinct := make(map[string]bool, len(f.URLs))
+	for _, u := range f.URLs {
+		if !distinct[u] {
+			distinct[u] = true
+			o.urls = append(o.urls, u)
+		}
+	}
+	if f.Network.IsValid() {
+		o.network = f.Network.String()
+	}
+	if o.bodyHash != 0 {
+		o.buckets = bodyBuckets(o.bodyHash)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	expired := 0
+	for expired < len(d.seen) && now.Sub(d.seen[expired].at) > d.Window {
+		expired++
+	}
+	if len(d.seen)-expired >= maxObservations {
+		expired = len(d.seen) - maxObservations + 1
+	}
+	for _, s := range d.seen[:expired] {
+		d.unindex(s)
+	}
+	d.seq++
+	o.seq = d.seq
+	d.seen = append(slices.Delete(d.seen, 0, expired), o)
+	d.index(o)
+
+	var outbreaks []*sink.Outbreak
+	check := func(kind, indicator, alertKey string, matches []*observation, alerted bool) bool {
+		if alerted || len(matches) >= d.Threshold {
+			o.alerted = append(o.alerted, alertKey)
+		}
+		if !alerted && len(matches) >= d.Threshold {
+			outbreaks = append(outbreaks, d.outbreak(kind, indicator, matches))
+		}
+		return alerted || len(matches) >= d.Threshold
+	}
+	for _, u := range o.urls {
+		s := d.urls[u]
+		if check(KindURL, u, "url:"+u, s.seen, s.alerted > 0) {
+			s.alerted++
+		}
+	}
+	if o.bodyHash != 0 {
+		matches, alerted := d.similarBodies(o)
+		check(KindBody, fmt.Sprintf("%016x", o.bodyHash), "body", matches, alerted)
+	}
+	if o.network != "" {
+		s := d.networks[o.network]
+		if check(KindNetwork, o.network, "network:"+o.network, s.seen, s.alerted > 0) {
+			s.alerted++
+		}
+	}
+	return outbreaks
+}
+
+// index adds o to the indexes of d.
+func (d *Detector) index(o *observation) {
+	if d.urls == nil {
+		d.urls, d.networks, d.bodies = map[string]*similar{}, map[string]*similar{}, map[uint32][]*observation{}
+	}
+	add := func(m map[string]*similar, key, alertKey string) {
+		s := m[key]
+		if s == nil {
+			s = &similar{}
+			m[key] = s
+		}
+		s.seen = append(s.seen, o)
+		if slices.Contains(o.alerted, alertKey) {
+			s.alerted++
+		}
+	}
+	for _, u := range o.urls {
+		add(d.urls, u, "url:"+u)
+	}
+	if o.network != "" {
+		add(d.networks, o.network, "network:"+o.network)
+	}
+	if o.bodyHash != 0 {
+		for _, band := range o.buckets {
+			d.bodies[band] = append(d.bodies[band], o)
+		}
+	}
+}
+
+// unindex removes o, one of the oldest messages of the window, from the indexes of d.
+func (d *Detector) unindex(o *observation) {
+	remove := func(list []*observation) []*observation {
+		if i := slices.Index(list, o); i >= 0 {
+			return slices.Delete(list, i, i+1)
+		}
+		return list
+	}
+	drop := func(m map[string]*similar, key, alertKey string) {
+		s := m[key]
+		if s == nil {
+			return
+		}
+		if s.seen = remove(s.seen); len(s.seen) == 0 {
+			delete(m, key)
+		} else if slices.Contains(o.alerted, alertKey) {
+			s.alerted--
+		}
+	}
+	for _, u := range o.urls {
+		drop(d.urls, u, "url:"+u)
+	}
+	if o.network != "" {
+		drop(d.networks, o.network, "network:"+o.network)
+	}
+	if o.bodyHash != 0 {
+		for _, band := range o.buckets {
+			if list := remove(d.bodies[band]); len(list) == 0 {
+				delete(d.bodies, band)
+			} else {
+				d.bodies[band] = list
+			}
+		}
+	}
+}
+
+// similarBodies returns the messages of the window whose bodies are similar to that of
+// o, oldest first, or else reports that one of them was reported in an outbreak of
+// similar bodies, which the newest ones are, in which case the others do not matter.
+func (d *Detector) similarBodies(o *observation) ([]*observation, bool) {
+	var matches []*observation
+	for i, band := range o.buckets {
+		list := d.bodies[band]
+		for j := len(list) - 1; j >= 0; j-- {
+			s := list[j]
+			if distance(s.bodyHash, o.bodyHash) > maxDistance {
+				continue
+			}
+			// A message with an earlier band in common was found in that band already.
+			if slices.ContainsFunc(o.buckets[:i], func(b uint32) bool { return s.buckets[b>>16] == b }) {
+				continue
+			}
+			if slices.Contains(s.alerted, "body") {
+				return nil, true
+			}
+			matches = append(matches, s)
+		}
+	}
+	slices.SortFunc(matches, func(a, b *observation) int { return cmp.Compare(a.seq, b.seq) })
+	return matches, false
+}
+
+// bodyBuckets returns the keys of the bands of hash in the index of bodies: the number
+// of each band and its bits.
+func bodyBuckets(hash uint64) [bodyBands]uint32 {
+	var buckets [bodyBands]uint32
+	for i := range bodyBands {
+		lo, hi := i*64/bodyBands, (i+1)*64/bodyBands
+		bits := hash >> lo & (1<<(hi-lo) - 1)
+		buckets[i] = uint32(i)<<16 | uint32(bits)
+	}
+	return buckets
+}
+
+// Inherit takes over the messages observed by old, such as the detector of the pipeline
+// that a new configuration replaces, so that an outbreak in progress is not reported
+// again.
+func (d *Detector) Inherit(old *Detector) {
+	if d == nil || old == nil || old == d {
+		return
+	}
+	old.mu.Lock()
+	// The observations are copied, since they are numbered again.
+	seen := make([]*observation, len(old.seen))
+	for i, o := range old.seen {
+		c := *o
+		seen[i] = &c
+	}
+	old.mu.Unlock()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.seen = append(seen, d.seen...)
+	d.urls, d.networks, d.bodies = nil, nil, nil
+	for _, o := range d.seen {
+		d.seq++
+		o.seq = d.seq
+		d.index(o)
+	}
+}
+
+// outbreak returns the alert about the similar messages matches, oldest first.
+func (d *Detector) outbreak(kind, indicator string, matches []*observation) *sink.Outbreak {
+	o := &sink.Outbreak{
+		Event:         "outbreak",
+		Kind:          kind,
+		Indicator:     indicator,
+		Messages:      len(matches),
+		WindowSeconds: int(d.Window.Seconds()),
+		FirstSeen:     matches[0].at.UTC(),
+		DetectedAt:    matches[len(matches)-1].at.UTC(),
+		Senders:       []string{},
+		Subjects:      []string{},
+		AnalysisIDs:   []string{},
+	}
+	sample := func(list *[]string, value string) {
+		if value != "" && len(*list) < maxSamples && !slices.Contains(*list, value) {
+			*list = append(*list, value)
+		}
+	}
+	for _, m := range matches {
+		if m.suspicious {
+			o.Suspicious++
+		}
+		sample(&o.Senders, strings.Join(m.from, ", "))
+		sample(&o.Subjects, m.subject)
+		sample(&o.AnalysisIDs, m.analysisID)
+	}
+	return o
+}
@@ -0,0 +1,180 @@
+package outbreak
+
+import (
+	"fmt"
+	"net/netip"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+	"mail-analyzer/sink"
+)
+
+const campaignBody = "Dear customer, your mailbox is almost full and will stop receiving messages today. " +
+	"Click the link below to verify your account and upgrade your storage for free."
+
+func TestNewFingerprint(t *testing.T) {
+	e, err := email.Parse(strings.NewReader("Received: from mx.example.com ([10.0.0.5]) by inbound.example.com\r\n" +
+		"Received: from mail.evil.example (mail.evil.example [198.51.100.23]) by mx.example.com\r\n" +
+		"From: billing@evil.example\r\nSubject: Your mailbox is full\r\n\r\n" +
+		campaignBody + "\r\nhttps://Login.Evil.example/verify?user=alice\r\nhttps://login.evil.example/verify?user=bob\r\n"))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	f := NewFingerprint(e)
+	if want := []string{"https://login.evil.example/verify"}; !reflect.DeepEqual(f.URLs, want) {
+		t.Errorf("URLs = %v, want %v", f.URLs, want)
+	}
+	if want := netip.MustParsePrefix("198.51.100.0/24"); f.Network != want {
+		t.Errorf("Network = %v, want %v", f.Network, want)
+	}
+	if f.BodyHash == 0 {
+		t.Error("BodyHash = 0, want the hash of the body")
+	}
+	if h := NewFingerprint(&email.ParsedEmail{Body: "See the attachment."}).BodyHash; h != 0 {
+		t.Errorf("BodyHash of a short body = %016x, want 0", h)
+	}
+
+	similar := NewFingerprint(&email.ParsedEmail{Body: strings.Replace(campaignBody, "Dear customer", "Dear Carol", 1)})
+	if d := distance(f.BodyHash, similar.BodyHash); d > maxDistance {
+		t.Errorf("distance between similar bodies = %d, want at most %d", d, maxDistance)
+	}
+	other := NewFingerprint(&email.ParsedEmail{Body: "Hi team, the minutes of the meeting of Tuesday are in the shared folder, " +
+		"please add your comments before the end of the week so that we can send them."})
+	if d := distance(f.BodyHash, other.BodyHash); d <= maxDistance {
+		t.Errorf("distance between unrelated bodies = %d, want more than %d", d, maxDistance)
+	}
+}
+
+func TestDetector(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	d := New(3, 10*time.Minute)
+	d.now = func() time.Time { return now }
+	observe := func(f *Fingerprint, id string) []*sink.Outbreak {
+		return d.Observe(f, &sink.Result{
+			AnalysisID: id,
+			From:       []string{"billing@evil.example"},
+			Subject:    "Your mailbox is full",
+			Judgment:   &llm.Judgment{IsSuspicious: id != "1"},
+		})
+	}
+	phish := &Fingerprint{URLs: []string{"https://login.evil.example/verify"}}
+
+	if got := observe(phish, "1"); got != nil {
+		t.Fatalf("first message raised %v", got)
+	}
+	now = now.Add(time.Minute)
+	if got := observe(phish, "2"); got != nil {
+		t.Fatalf("second message raised %v", got)
+	}
+	now = now.Add(time.Minute)
+	got := observe(phish, "3")
+	want := []*sink.Outbreak{{
+		Event:         "outbreak",
+		Kind:          KindURL,
+		Indicator:     "https://login.evil.example/verify",
+		Messages:      3,
+		Suspicious:    2,
+		WindowSeconds: 600,
+		FirstSeen:     time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC),
+		DetectedAt:    time.Date(2025, 7, 1, 12, 2, 0, 0, time.UTC),
+		Senders:       []string{"billing@evil.example"},
+		Subjects:      []string{"Your mailbox is full"},
+		AnalysisIDs:   []string{"1", "2", "3"},
+	}}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("third message raised %+v, want %+v", got, want)
+	}
+
+	// The outbreak is reported once while it goes on, even by a new detector.
+	now = now.Add(time.Minute)
+	if got := observe(phish, "4"); got != nil {
+		t.Errorf("outbreak in progress raised %v", got)
+	}
+	reloaded := New(3, 10*time.Minute)
+	reloaded.now = d.now
+	reloaded.Inherit(d)
+	d = reloaded
+	now = now.Add(time.Minute)
+	if got := observe(phish, "5"); got != nil {
+		t.Errorf("outbreak in progress raised %v after a reload", got)
+	}
+
+	// After a quiet window, the same URL is a new outbreak.
+	now = now.Add(11 * time.Minute)
+	for _, id := range []string{"6", "7"} {
+		if got := observe(phish, id); got != nil {
+			t.Errorf("message %s after the window raised %v", id, got)
+		}
+	}
+	if got := observe(phish, "8"); len(got) != 1 || got[0].Messages != 3 {
+		t.Errorf("new outbreak raised %+v, want one of 3 messages", got)
+	}
+}
+
+func TestDetector_Kinds(t *testing.T) {
+	d := New(2, time.Hour)
+	body := NewFingerprint(&email.ParsedEmail{Body: campaignBody}).BodyHash
+	network := netip.MustParsePrefix("198.51.100.0/24")
+	d.Observe(&Fingerprint{BodyHash: body, Network: network}, &sink.Result{})
+	got := d.Observe(&Fingerprint{BodyHash: body ^ 1, Network: network}, &sink.Result{})
+	var kinds []string
+	for _, o := range got {
+		kinds = append(kinds, o.Kind)
+	}
+	if want := []string{KindBody, KindNetwork}; !reflect.DeepEqual(kinds, want) {
+		t.Errorf("outbreaks of kinds %v, want %v", kinds, want)
+	}
+	if got := d.Observe(&Fingerprint{BodyHash: ^body}, &sink.Result{}); got != nil {
+		t.Errorf("unrelated message raised %v", got)
+	}
+}
+
+func TestDetector_Expiry(t *testing.T) {
+	now := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	d := New(2, 10*time.Minute)
+	d.now = func() time.Time { return now }
+	body := NewFingerprint(&email.ParsedEmail{Body: campaignBody}).BodyHash
+	network := netip.MustParsePrefix("198.51.100.0/24")
+	for range 2 {
+		d.Observe(&Fingerprint{URLs: []string{"https://evil.example/a"}, BodyHash: body, Network: network}, &sink.Result{})
+	}
+
+	now = now.Add(11 * time.Minute)
+	d.Observe(&Fingerprint{URLs: []string{"https://evil.example/b"}}, &sink.Result{})
+	if len(d.seen) != 1 || len(d.urls) != 1 || d.urls["https://evil.example/b"] == nil || len(d.networks) != 0 || len(d.bodies) != 0 {
+		t.Errorf("after the window, the indexes have %d URLs, %d networks and %d body bands, want the last message alone",
+			len(d.urls), len(d.networks), len(d.bodies))
+	}
+}
+
+// BenchmarkDetector_Observe observes messages with many URLs in a window of many other
+// such messages, of which those of the same campaign share their URLs.
+func BenchmarkDetector_Observe(b *testing.B) {
+	const messages, campaigns, urls = 10_000, 100, 1000
+	campaignURLs := make([][]string, campaigns)
+	for c := range campaignURLs {
+		for i := range urls {
+			campaignURLs[c] = append(campaignURLs[c], fmt.Sprintf("https://host%d.example/%d", c, i))
+		}
+	}
+	fingerprint := func(n int) *Fingerprint {
+		return &Fingerprint{
+			URLs:     campaignURLs[n%campaigns],
+			BodyHash: uint64(n+1) * 0x9E3779B97F4A7C15,
+			Network:  netip.PrefixFrom(netip.AddrFrom4([4]byte{198, 51, byte(n >> 8), byte(n)}), 24),
+		}
+	}
+	d := New(messages/campaigns/2, time.Hour)
+	for n := range messages {
+		d.Observe(fingerprint(n), &sink.Result{})
+	}
+	n := messages
+	for b.Loop() {
+		d.Observe(fingerprint(n), &sink.Result{})
+		n++
+	}
+}
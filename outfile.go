@@ -0,0 +1,196 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"mail-analyzer/analyzer"
+)
+
+// outputFile is the destination of --output. Unless appending, results are written to a
+// temporary file in the same directory, which replaces the target only on Commit, so
+// readers never see a partially written file.
+type outputFile struct {
+	f    *os.File
+	path string // Target path; empty when appending in place
+}
+
+// createOutputFile opens path for writing results. With appendMode, results are
+// appended to the file directly instead.
+func createOutputFile(path string, appendMode bool) (*outputFile, error) {
+	if appendMode {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, err
+		}
+		return &outputFile{f: f}, nil
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	mode := os.FileMode(0o644)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	if err := f.Chmod(mode); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return nil, err
+	}
+	return &outputFile{f: f, path: path}, nil
+}
+
+// Write implements io.Writer.
+func (o *outputFile) Write(p []byte) (int, error) {
+	return o.f.Write(p)
+}
+
+// Commit flushes the file to disk and moves it into place.
+func (o *outputFile) Commit() error {
+	if o.path == "" {
+		return o.f.Close()
+	}
+	err := o.f.Sync()
+	if closeErr := o.f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(o.f.Name(), o.path)
+	}
+	if err != nil {
+		os.Remove(o.f.Name())
+	}
+	return err
+}
+
+// Abort discards the temporary file, leaving the target untouched.
+func (o *outputFile) Abort() {
+	o.f.Close()
+	if o.path != "" {
+		os.Remove(o.f.Name())
+	}
+}
+
+// outputFlags are the flags controlling how results are written, shared by the
+// commands that analyze messages.
+type outputFlags struct {
+	format     string
+	path       string
+	appendMode bool
+	redact     bool
+	validate   bool
+	// templates provide the template of the report format.
+	templates *analyzer.Templates
+}
+
+func (f *outputFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.format, "output-format", FormatJSON, "Output format: json, jsonl, csv, tsv, sarif, eml or report")
+	fs.StringVar(&f.path, "output", "", "Write results to this file instead of standard output")
+	fs.StringVar(&f.path, "o", "", "Write results to this file (shorthand)")
+	fs.BoolVar(&f.appendMode, "append", false, "Append to the --output file instead of replacing it (jsonl only)")
+	fs.BoolVar(&f.redact, "redact", false, "Mask recipient addresses and names in the output so results can be shared externally")
+	fs.BoolVar(&f.validate, "validate-output", false, "Check the JSON output against its schema before writing it")
+}
+
+// check reports invalid flag combinations.
+func (f *outputFlags) check() error {
+	if _, err := newResultWriter(f.format, io.Discard, ""); err != nil {
+		return usageError{err}
+	}
+	if f.validate && f.format != FormatJSON {
+		return usageErrorf("--validate-output requires --output-format json")
+	}
+	if f.redact && f.format == FormatEML {
+		return usageErrorf("--redact cannot be used with --output-format eml, which contains the original message")
+	}
+	if f.appendMode && (f.path == "" || f.format != FormatJSONL) {
+		return usageErrorf("--append requires --output and --output-format jsonl")
+	}
+	return nil
+}
+
+// resultOutput writes results to standard output or the --output file.
+type resultOutput struct {
+	writer ResultWriter
+	file   *outputFile
+	redact bool
+}
+
+// open creates the output for a run over sourceFile.
+func (f *outputFlags) open(sourceFile string) (*resultOutput, error) {
+	o := &resultOutput{redact: f.redact}
+	var w io.Writer = os.Stdout
+	if f.path != "" {
+		var err error
+		if o.file, err = createOutputFile(f.path, f.appendMode); err != nil {
+			return nil, fmt.Errorf("error creating output file: %w", err)
+		}
+		w = o.file
+	}
+	writer, err := newResultWriter(f.format, w, sourceFile)
+	if err != nil {
+		if o.file != nil {
+			o.file.Abort()
+		}
+		return nil, err
+	}
+	if f.validate {
+		writer.(*jsonWriter).validate = true
+	}
+	if report, ok := writer.(*reportWriter); ok {
+		if f.templates != nil {
+			report.tmpl = f.templates.Report()
+		}
+		if report.tmpl == nil {
+			if o.file != nil {
+				o.file.Abort()
+			}
+			return nil, fmt.Errorf("--output-format report requires a %s file in templates_dir", analyzer.ReportFile)
+		}
+	}
+	o.writer = writer
+	return o, nil
+}
+
+// Write writes one result.
+func (o *resultOutput) Write(result *AnalysisResult) error {
+	if o.redact {
+		result = redactResult(result)
+	}
+	return o.writer.Write(result)
+}
+
+// SetSummary adds the summary of the results to the formats that include one.
+func (o *resultOutput) SetSummary(summary *Summary) {
+	if w, ok := o.writer.(summaryWriter); ok {
+		w.SetSummary(summary)
+	}
+}
+
+// Close flushes the results and, for an output file, moves it into place.
+func (o *resultOutput) Close() error {
+	err := o.writer.Close()
+	if o.file != nil {
+		if err == nil {
+			err = o.file.Commit()
+		} else {
+			o.file.Abort()
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("error writing output: %w", err)
+	}
+	return nil
+}
+
+// Abort discards an output file after a failed write, leaving any existing file untouched.
+func (o *resultOutput) Abort() {
+	if o.file != nil {
+		o.file.Abort()
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOutputFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.json")
+	os.WriteFile(path, []byte("old\n"), 0o600)
+
+	out, err := createOutputFile(path, false)
+	if err != nil {
+		t.Fatalf("createOutputFile() error = %v", err)
+	}
+	out.Write([]byte("new\n"))
+	if data, _ := os.ReadFile(path); string(data) != "old\n" {
+		t.Errorf("target changed before Commit: %q", data)
+	}
+	if err := out.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("target = %q, want the new content", data)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want the mode of the replaced file", info.Mode().Perm())
+	}
+
+	out, _ = createOutputFile(path, false)
+	out.Write([]byte("partial"))
+	out.Abort()
+	if data, _ := os.ReadFile(path); string(data) != "new\n" {
+		t.Errorf("target = %q after Abort", data)
+	}
+
+	out, _ = createOutputFile(path, true)
+	out.Write([]byte("appended\n"))
+	out.Commit()
+	if data, _ := os.ReadFile(path); string(data) != "new\nappended\n" {
+		t.Errorf("target = %q after append", data)
+	}
+
+	if entries, _ := os.ReadDir(filepath.Dir(path)); len(entries) != 1 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
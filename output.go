@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"mail-analyzer/email"
+)
+
+// Output formats accepted by --output-format.
+const (
+	FormatJSON  = "json"
+	FormatJSONL = "jsonl"
+	FormatCSV   = "csv"
+	FormatTSV   = "tsv"
+	FormatSARIF = "sarif"
+	FormatEML   = "eml"
+	// FormatReport renders the report.tmpl of templates_dir.
+	FormatReport = "report"
+)
+
+// ResultWriter writes analysis results in one of the supported output formats.
+type ResultWriter interface {
+	// Write outputs or buffers a single result.
+	Write(result *AnalysisResult) error
+	// Close flushes buffered results.
+	Close() error
+}
+
+// newResultWriter returns a ResultWriter for format that writes to w.
+func newResultWriter(format string, w io.Writer, sourceFile string) (ResultWriter, error) {
+	switch format {
+	case FormatJSON, "":
+		return &jsonWriter{w: w, output: FinalOutput{SchemaVersion: OutputSchemaVersion, SourceFile: sourceFile, AnalysisResults: []*AnalysisResult{}}}, nil
+	case FormatJSONL:
+		return &jsonlWriter{enc: json.NewEncoder(w), sourceFile: sourceFile}, nil
+	case FormatCSV:
+		return newCSVWriter(w, ',', sourceFile)
+	case FormatTSV:
+		return newCSVWriter(w, '\t', sourceFile)
+	case FormatEML:
+		return &emlWriter{w: w}, nil
+	case FormatSARIF:
+		return &sarifWriter{w: w, sourceFile: sourceFile, rules: []sarifRule{}, results: []sarifResult{}}, nil
+	case FormatReport:
+		return &reportWriter{w: w, output: FinalOutput{SchemaVersion: OutputSchemaVersion, SourceFile: sourceFile, AnalysisResults: []*AnalysisResult{}}}, nil
+	default:
+		return nil, fmt.Errorf("unknown output format %q", format)
+	}
+}
+
+// resultSource returns the file result was read from, or sourceFile if it is not known.
+func resultSource(result *AnalysisResult, sourceFile string) string {
+	if result.SourceFile != "" {
+		return result.SourceFile
+	}
+	return sourceFile
+}
+
+// jsonWriter collects all results into a single indented FinalOutput document.
+type jsonWriter struct {
+	w      io.Writer
+	output FinalOutput
+	// validate checks the document against the output schema before writing it.
+	validate bool
+}
+
+func (j *jsonWriter) Write(result *AnalysisResult) error {
+	j.output.AnalysisResults = append(j.output.AnalysisResults, result)
+	return nil
+}
+
+func (j *jsonWriter) SetSummary(summary *Summary) {
+	j.output.Summary = summary
+}
+
+func (j *jsonWriter) Close() error {
+	jsonOutput, err := json.MarshalIndent(j.output, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling JSON: %w", err)
+	}
+	if j.validate {
+		if err := validateOutput(jsonOutput); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintln(j.w, string(jsonOutput))
+	return err
+}
+
+// reportWriter renders all results with the report template of templates_dir, which is
+// executed with the FinalOutput document.
+type reportWriter struct {
+	w      io.Writer
+	tmpl   *template.Template
+	output FinalOutput
+}
+
+func (r *reportWriter) Write(result *AnalysisResult) error {
+	r.output.AnalysisResults = append(r.output.AnalysisResults, result)
+	return nil
+}
+
+func (r *reportWriter) SetSummary(summary *Summary) {
+	r.output.Summary = summary
+}
+
+func (r *reportWriter) Close() error {
+	if err := r.tmpl.Execute(r.w, r.output); err != nil {
+		return fmt.Errorf("error executing report template: %w", err)
+	}
+	return nil
+}
+
+// JSONLRecord is one line of JSON Lines output: a result together with its source.
+type JSONLRecord struct {
+	SourceFile string `json:"source_file"`
+	*AnalysisResult
+}
+
+// jsonlWriter emits each result as soon as it is written, so consumers can process
+// results while a batch is still running and keep them if it is interrupted.
+type jsonlWriter struct {
+	enc        *json.Encoder
+	sourceFile string
+}
+
+func (j *jsonlWriter) Write(result *AnalysisResult) error {
+	return j.enc.Encode(JSONLRecord{SourceFile: resultSource(result, j.sourceFile), AnalysisResult: result})
+}
+
+func (j *jsonlWriter) Close() error {
+	return nil
+}
+
+// csvHeader lists the columns of the CSV and TSV summary formats.
+var csvHeader = []string{"source", "message_id", "from", "subject", "category", "suspicious", "confidence", "top_url"}
+
+// csvWriter writes one summary row per message, for opening results in a spreadsheet.
+type csvWriter struct {
+	w          *csv.Writer
+	sourceFile string
+}
+
+func newCSVWriter(w io.Writer, comma rune, sourceFile string) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w), sourceFile: sourceFile}
+	c.w.Comma = comma
+	if err := c.w.Write(csvHeader); err != nil {
+		return nil, err
+	}
+	c.w.Flush()
+	return c, c.w.Error()
+}
+
+func (c *csvWriter) Write(result *AnalysisResult) error {
+	var category, suspicious, confidence string
+	if j := result.Judgment; j != nil {
+		category = j.Category
+		suspicious = strconv.FormatBool(j.IsSuspicious)
+		confidence = strconv.FormatFloat(j.ConfidenceScore, 'f', 2, 64)
+	}
+	var topURL string
+	if len(result.URLs) > 0 {
+		topURL = result.URLs[0]
+	}
+	row := []string{resultSource(result, c.sourceFile), result.MessageID, strings.Join(result.From, "; "), result.Subject, category, suspicious, confidence, topURL}
+	for i := range row {
+		row[i] = escapeFormula(row[i])
+	}
+	if err := c.w.Write(row); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// escapeFormula prevents spreadsheet applications from evaluating attacker-controlled
+// fields such as the subject as formulas (CSV injection).
+func escapeFormula(field string) string {
+	if field != "" && strings.ContainsRune("=+-@\t\r", rune(field[0])) {
+		return "'" + field
+	}
+	return field
+}
+
+// maxAnnotatedURLs is the number of URLs added as X-Mail-Analyzer-URL headers.
+const maxAnnotatedURLs = 10
+
+// emlWriter writes the original message with the verdict added as X-Mail-Analyzer-* headers,
+// so it can be re-injected into the mail flow.
+type emlWriter struct {
+	w io.Writer
+}
+
+func (e *emlWriter) Write(result *AnalysisResult) error {
+	_, err := e.w.Write(email.Annotate(result.Raw, annotationHeaders(result)))
+	return err
+}
+
+func (e *emlWriter) Close() error {
+	return nil
+}
+
+// annotationHeaders returns the verdict headers for result.
+func annotationHeaders(result *AnalysisResult) []email.Header {
+	headers := []email.Header{{Name: "X-Mail-Analyzer-Analysis-Id", Value: result.AnalysisID}}
+	if j := result.Judgment; j != nil {
+		headers = append(headers,
+			email.Header{Name: "X-Mail-Analyzer-Category", Value: j.Category},
+			email.Header{Name: "X-Mail-Analyzer-Suspicious", Value: strconv.FormatBool(j.IsSuspicious)},
+			email.Header{Name: "X-Mail-Analyzer-Score", Value: strconv.FormatFloat(j.ConfidenceScore, 'f', 2, 64)},
+			email.Header{Name: "X-Mail-Analyzer-Reason", Value: j.Reason},
+		)
+	}
+	for i, u := range result.URLs {
+		if i == maxAnnotatedURLs {
+			break
+		}
+		headers = append(headers, email.Header{Name: "X-Mail-Analyzer-URL", Value: u})
+	}
+	return headers
+}
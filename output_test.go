@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"text/template"
+
+	"mail-analyzer/llm"
+)
+
+func TestResultWriter(t *testing.T) {
+	results := []*AnalysisResult{
+		{MessageID: "<1@example.com>", Subject: "First", Judgment: &llm.Judgment{Category: "Safe"}},
+		{MessageID: "<2@example.com>", Subject: "Second", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing"}},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := newResultWriter(FormatJSON, &buf, "mail.eml")
+		if err != nil {
+			t.Fatalf("newResultWriter() error = %v", err)
+		}
+		for _, r := range results {
+			w.Write(r)
+		}
+		if buf.Len() != 0 {
+			t.Error("json output was written before Close")
+		}
+		if err := w.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+
+		var got FinalOutput
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("invalid JSON output: %v", err)
+		}
+		if got.SourceFile != "mail.eml" || len(got.AnalysisResults) != 2 {
+			t.Errorf("unexpected output: %+v", got)
+		}
+	})
+
+	t.Run("jsonl", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := newResultWriter(FormatJSONL, &buf, "mail.eml")
+		if err != nil {
+			t.Fatalf("newResultWriter() error = %v", err)
+		}
+		w.Write(results[0])
+		if !strings.HasSuffix(buf.String(), "\n") {
+			t.Error("jsonl result was not written immediately")
+		}
+		w.Write(results[1])
+		w.Close()
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("expected 2 lines, got %d: %s", len(lines), buf.String())
+		}
+		var got map[string]any
+		if err := json.Unmarshal([]byte(lines[1]), &got); err != nil {
+			t.Fatalf("invalid JSON line: %v", err)
+		}
+		if got["source_file"] != "mail.eml" || got["message_id"] != "<2@example.com>" || got["judgment"].(map[string]any)["category"] != "Phishing" {
+			t.Errorf("unexpected record: %v", got)
+		}
+	})
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, err := newResultWriter(FormatCSV, &buf, "mail.eml")
+		if err != nil {
+			t.Fatalf("newResultWriter() error = %v", err)
+		}
+		w.Write(&AnalysisResult{
+			MessageID: "<3@example.com>",
+			Subject:   "=HYPERLINK(\"http://evil.example.com\"), urgent",
+			From:      []string{"a@example.com", "b@example.com"},
+			Judgment:  &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.875},
+			URLs:      []string{"http://evil.example.com/login", "http://example.com"},
+		})
+		w.Close()
+
+		want := "source,message_id,from,subject,category,suspicious,confidence,top_url\n" +
+			"mail.eml,<3@example.com>,a@example.com; b@example.com,\"'=HYPERLINK(\"\"http://evil.example.com\"\"), urgent\",Phishing,true,0.88,http://evil.example.com/login\n"
+		if buf.String() != want {
+			t.Errorf("csv output =\n%s\nwant\n%s", buf.String(), want)
+		}
+	})
+
+	t.Run("tsv", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, _ := newResultWriter(FormatTSV, &buf, "mail.eml")
+		w.Write(results[0])
+		w.Close()
+
+		want := "source\tmessage_id\tfrom\tsubject\tcategory\tsuspicious\tconfidence\ttop_url\n" +
+			"mail.eml\t<1@example.com>\t\tFirst\tSafe\tfalse\t0.00\t\n"
+		if buf.String() != want {
+			t.Errorf("tsv output = %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("report", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, _ := newResultWriter(FormatReport, &buf, "mail.eml")
+		w.(*reportWriter).tmpl = template.Must(template.New("report").Parse(
+			"{{.SourceFile}}: {{len .AnalysisResults}} messages\n{{range .AnalysisResults}}- {{.Subject}}: {{.Judgment.Category}}\n{{end}}"))
+		w.Write(&AnalysisResult{Subject: "First", Judgment: &llm.Judgment{Category: "Safe"}})
+		w.Write(&AnalysisResult{Subject: "Second", Judgment: &llm.Judgment{Category: "Phishing"}})
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if want := "mail.eml: 2 messages\n- First: Safe\n- Second: Phishing\n"; buf.String() != want {
+			t.Errorf("report output = %q, want %q", buf.String(), want)
+		}
+	})
+
+	t.Run("eml", func(t *testing.T) {
+		var buf bytes.Buffer
+		w, _ := newResultWriter(FormatEML, &buf, "mail.eml")
+		w.Write(&AnalysisResult{
+			Judgment:   &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.9, Reason: "Fake login."},
+			URLs:       []string{"http://evil.example.com/login"},
+			AnalysisID: "7d4f1c9e-0b7a-4c55-9a3e-2f8d6b1e4a10",
+			Raw:        []byte("X-Mail-Analyzer-Category: Safe\nSubject: Hi\n\nBody\n"),
+		})
+		w.Close()
+
+		want := "X-Mail-Analyzer-Analysis-Id: 7d4f1c9e-0b7a-4c55-9a3e-2f8d6b1e4a10\n" +
+			"X-Mail-Analyzer-Category: Phishing\n" +
+			"X-Mail-Analyzer-Suspicious: true\n" +
+			"X-Mail-Analyzer-Score: 0.90\n" +
+			"X-Mail-Analyzer-Reason: Fake login.\n" +
+			"X-Mail-Analyzer-URL: http://evil.example.com/login\n" +
+			"Subject: Hi\n\nBody\n"
+		if buf.String() != want {
+			t.Errorf("eml output =\n%s\nwant\n%s", buf.String(), want)
+		}
+	})
+
+	if _, err := newResultWriter("xml", &bytes.Buffer{}, ""); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+}
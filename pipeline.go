@@ -0,0 +1,883 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"time"
+
+	"mail-analyzer/action"
+	"mail-analyzer/analyzer"
+	"mail-analyzer/attachtext"
+	"mail-analyzer/audit"
+	"mail-analyzer/classifier"
+	"mail-analyzer/config"
+	"mail-analyzer/dkim"
+	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
+	"mail-analyzer/hook"
+	"mail-analyzer/httpclient"
+	"mail-analyzer/llm"
+	"mail-analyzer/outbreak"
+	"mail-analyzer/plugin"
+	"mail-analyzer/resultdb"
+	"mail-analyzer/sandbox"
+	"mail-analyzer/secret"
+	"mail-analyzer/sink"
+	"mail-analyzer/vectorstore"
+)
+
+// pipelineFlags are the flags shared by the commands that analyze messages.
+type pipelineFlags struct {
+	configPath    string
+	debug         bool
+	recordDir     string
+	replayDir     string
+	dbPath        string
+	actionsDryRun bool
+	// overrides are the settings of the endpoint flags, which override the configuration.
+	overrides config.Overrides
+	// dryRun is only registered by the commands that analyze files, with registerDryRun.
+	dryRun bool
+	// analyzeOnly skips the sinks and actions, for commands that only report judgments.
+	analyzeOnly bool
+	// filter is only registered by the commands that analyze many messages, with registerFilter.
+	filter messageFilter
+	// noDedup analyzes every message of a batch, even the duplicates of another one; it is
+	// only registered by batch, with registerDedup.
+	noDedup bool
+	// mock answers every request with mockJudgment instead of calling the API; it is only
+	// registered by selftest.
+	mock bool
+	// timeout overrides the message_timeout setting; it is only registered by the commands
+	// that analyze files, with registerTimeout.
+	timeout time.Duration
+	// deadline is when a run of many messages stops; it is only registered by the commands
+	// that analyze many messages, with registerDeadline.
+	deadline time.Time
+	// daemon is set by the servers, which detect outbreaks across the messages they
+	// analyze.
+	daemon bool
+	// headersOnly judges every message by its header alone, with headers_only_model.
+	headersOnly bool
+}
+
+func (f *pipelineFlags) register(fs *flag.FlagSet) {
+	f.registerAnalysis(fs)
+	fs.StringVar(&f.dbPath, "db", "", "Store every analysis in the given SQLite database")
+	fs.BoolVar(&f.actionsDryRun, "actions-dry-run", false, "Print the configured actions that would be taken instead of taking them")
+}
+
+func (f *pipelineFlags) registerFilter(fs *flag.FlagSet) {
+	fs.Var(&f.filter, "filter", filterHelp)
+}
+
+// registerAnalysis registers the flags that affect the analysis itself, without those of
+// the sinks and actions.
+func (f *pipelineFlags) registerAnalysis(fs *flag.FlagSet) {
+	fs.StringVar(&f.configPath, "config", "", configFlagHelp)
+	fs.BoolVar(&f.debug, "debug", false, "Enable debug logging")
+	fs.BoolVar(&f.debug, "d", false, "Enable debug logging (shorthand)")
+	registerEndpointFlags(fs, &f.overrides)
+	fs.StringVar(&f.recordDir, "record", "", "Save LLM responses to the given directory, keyed by request hash")
+	fs.StringVar(&f.replayDir, "replay", "", "Serve LLM responses from the given directory instead of calling the API")
+	fs.BoolVar(&f.headersOnly, "headers-only", false, "Judge messages by their header alone (sender, authentication results, route), with headers_only_model, to pre-screen high volumes")
+}
+
+// registerEndpointFlags registers the flags that override the LLM endpoint for one run,
+// to compare models without editing the configuration.
+func registerEndpointFlags(fs *flag.FlagSet, overrides *config.Overrides) {
+	fs.StringVar(&overrides.Provider, "provider", "", "Use this LLM provider (openai, anthropic, ollama or bedrock) and its section of the configuration")
+	fs.StringVar(&overrides.Model, "model", "", "Use this model instead of the configured one")
+	fs.StringVar(&overrides.BaseURL, "base-url", "", "Use this API base URL instead of the configured one")
+}
+
+func (f *pipelineFlags) registerDedup(fs *flag.FlagSet) {
+	fs.BoolVar(&f.noDedup, "no-dedup", false, "Analyze every message, even those with the Message-ID or content of another one")
+}
+
+func (f *pipelineFlags) registerDryRun(fs *flag.FlagSet) {
+	fs.BoolVar(&f.dryRun, "dry-run", false, "Print the LLM request for each message instead of sending it")
+}
+
+func (f *pipelineFlags) registerTimeout(fs *flag.FlagSet) {
+	fs.DurationVar(&f.timeout, "timeout", 0, "Give up on a message after this long, such as 5m (default message_timeout)")
+}
+
+func (f *pipelineFlags) registerDeadline(fs *flag.FlagSet) {
+	fs.Func("deadline", "Stop the run at a time of day (06:00), timestamp (RFC 3339) or after a duration (8h), and report the messages analyzed so far", func(value string) error {
+		deadline, err := parseDeadline(value, time.Now())
+		f.deadline = deadline
+		return err
+	})
+}
+
+// parseDeadline parses the value of --deadline. A time of day is the next one to come.
+func parseDeadline(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil && d > 0 {
+		return now.Add(d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("15:04", value, now.Location()); err == nil {
+		t = time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), 0, 0, now.Location())
+		if !t.After(now) {
+			t = t.AddDate(0, 0, 1)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --deadline value %q", value)
+}
+
+// withDeadline returns a context that is cancelled with errDeadline at the time given
+// with --deadline, if any.
+func (f *pipelineFlags) withDeadline(ctx context.Context) (context.Context, context.CancelFunc) {
+	if f.deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return context.WithDeadlineCause(ctx, f.deadline, errDeadline)
+}
+
+// setup validates the flags, configures logging and loads the configuration.
+func (f *pipelineFlags) setup() (*config.Config, error) {
+	if f.recordDir != "" && f.replayDir != "" {
+		return nil, usageErrorf("--record and --replay cannot be used together")
+	}
+	if f.timeout < 0 {
+		return nil, usageErrorf("--timeout must be positive")
+	}
+	setupLogging(f.debug)
+	return f.readConfig()
+}
+
+// readConfig loads the configuration and applies the flags that override it.
+func (f *pipelineFlags) readConfig() (*config.Config, error) {
+	r, err := resolveConfig(f.configPath, f.overrides)
+	if err != nil {
+		return nil, err
+	}
+	cfg := r.Config
+	// Replay, dry-run and mock modes never touch the network, so no credentials are
+	// required there.
+	if f.replayDir == "" && !f.dryRun && !f.mock {
+		if err := cfg.CheckCredentials(); err != nil {
+			return nil, fmt.Errorf("%w: %w", config.ErrConfig, err)
+		}
+	}
+	if f.timeout > 0 {
+		cfg.MessageTimeout = config.Duration(f.timeout)
+	}
+	return cfg, nil
+}
+
+// setupLogging discards log output unless debug logging is enabled.
+func setupLogging(debug bool) {
+	if !debug {
+		log.SetOutput(io.Discard) // Discard all log.Printf output
+	} else {
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags | log.Lshortfile) // Add file and line number to debug logs
+	}
+}
+
+// projectConfigName is the name of the configuration file of a project, looked for in the
+// current directory and its parents.
+const projectConfigName = ".mail-analyzer.json"
+
+const configFlagHelp = "Configuration file, used instead of the system, user and project ones"
+
+// systemConfigPath returns the configuration file shared by every user of the machine.
+func systemConfigPath() string {
+	return systemConfigPathFor(runtime.GOOS)
+}
+
+func systemConfigPathFor(goos string) string {
+	if goos == "windows" {
+		return filepath.Join(cmp.Or(os.Getenv("ProgramData"), `C:\ProgramData`), "mail-analyzer", "config.json")
+	}
+	return "/etc/mail-analyzer/config.json"
+}
+
+// defaultConfigPath returns the configuration file of the user.
+func defaultConfigPath() (string, error) {
+	return userConfigPathFor(runtime.GOOS)
+}
+
+// userConfigPathFor returns the configuration file of the user on goos: in
+// $XDG_CONFIG_HOME if it is set, or else in %AppData% on Windows, in
+// ~/Library/Application Support on macOS, and in ~/.config elsewhere. On macOS, a file in
+// ~/.config, where earlier versions looked for it, is used if there is none in Library.
+func userConfigPathFor(goos string) (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); filepath.IsAbs(dir) {
+		return filepath.Join(dir, "mail-analyzer", "config.json"), nil
+	}
+	if goos == "windows" {
+		dir := os.Getenv("AppData")
+		if dir == "" {
+			return "", errors.New("error getting user configuration directory: %AppData% is not set")
+		}
+		return filepath.Join(dir, "mail-analyzer", "config.json"), nil
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("error getting user home directory: %w", err)
+	}
+	legacyPath := filepath.Join(homeDir, ".config", "mail-analyzer", "config.json")
+	if goos != "darwin" {
+		return legacyPath, nil
+	}
+	path := filepath.Join(homeDir, "Library", "Application Support", "mail-analyzer", "config.json")
+	if _, err := os.Stat(path); errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(legacyPath); err == nil {
+			return legacyPath, nil
+		}
+	}
+	return path, nil
+}
+
+// configPaths returns the configuration files to load, each overriding the previous ones:
+// path if it is given, or else the system, user and project files, whether they exist or
+// not. It returns an error if the project file sets settings other than
+// config.ProjectSettings.
+func configPaths(path string) ([]string, error) {
+	if path != "" {
+		return []string{path}, nil
+	}
+	userPath, err := defaultConfigPath()
+	if err != nil {
+		return nil, err
+	}
+	paths := []string{systemConfigPath(), userPath}
+	dir, err := os.Getwd()
+	if err != nil {
+		return paths, nil // The project file is optional.
+	}
+	for {
+		projectPath := filepath.Join(dir, projectConfigName)
+		if _, err := os.Stat(projectPath); err == nil {
+			if err := config.CheckProjectFile(projectPath); err != nil {
+				return nil, err
+			}
+			return append(paths, projectPath), nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return paths, nil
+		}
+		dir = parent
+	}
+}
+
+// loadConfig loads the configuration from path, or from the files found by configPaths if
+// path is empty.
+func loadConfig(path string) (*config.Config, error) {
+	r, err := resolveConfig(path, config.Overrides{})
+	if err != nil {
+		return nil, err
+	}
+	return r.Config, nil
+}
+
+// resolveConfig is loadConfig, with the settings of overrides, and where each setting
+// came from.
+func resolveConfig(path string, overrides config.Overrides) (*config.Resolved, error) {
+	paths, err := configPaths(path)
+	if err != nil {
+		return nil, err
+	}
+	return config.ResolveWith(overrides, paths...)
+}
+
+// pipeline analyzes messages and delivers the results to the configured sinks and actions.
+type pipeline struct {
+	cfg      *config.Config
+	provider *llm.OpenAIProvider
+	analyzer *analyzer.EmailAnalyzer
+	// prefilter is the pre-filter of analyzer, or nil.
+	prefilter *analyzer.Prefilter
+	sinks     []sink.Sink
+	actions   *action.Engine
+	filter    messageFilter
+	// dedup analyzes the messages of a batch with the Message-ID or content of another one
+	// only once.
+	dedup bool
+	// templates are the templates of templates_dir, or nil.
+	templates *analyzer.Templates
+	// templateSets are the templates of template_sets, by name.
+	templateSets map[string]*analyzer.Templates
+	// tenants are the sinks and actions of the policies whose settings override those of
+	// cfg, by tenant.
+	tenants map[string]*tenant
+	// hooks are called before and after the analysis of each message.
+	hooks hook.Chain
+	// plugins analyze each message next to the LLM.
+	plugins plugin.Set
+	// sandbox checks the attachments of each message next to the LLM, or is nil.
+	sandbox *sandbox.Detonator
+	// attachments extracts the text of the attachments of each message for the prompt,
+	// or is nil.
+	attachments *attachtext.Extractor
+	// ocr recognizes the text of the images of each message for the prompt, or is nil.
+	ocr *attachtext.OCR
+	// outbreaks detects the outbreaks among the messages of a server, or is nil.
+	outbreaks *outbreak.Detector
+	// headersOnly judges every message by its header alone.
+	headersOnly bool
+	// tokens are the clients of the API tokens of a server, by the SHA-256 hashes of
+	// the tokens.
+	tokens map[[sha256.Size]byte]apiClient
+	// audit records the actions taken on the messages, or is nil.
+	audit *audit.Log
+	// samples verifies the DKIM signatures of the messages forwarded as samples.
+	samples dkim.Verifier
+}
+
+// newPipeline creates the analyzer, sinks and actions for cfg.
+func newPipeline(cfg *config.Config, f *pipelineFlags) (*pipeline, error) {
+	httpClient, err := httpclient.New(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating HTTP client: %w", err)
+	}
+	apiKeyRef := cfg.OpenAIAPIKey
+	secrets, err := resolveSecrets(context.Background(), cfg)
+	if err != nil {
+		return nil, err
+	}
+	if secret.IsReference(apiKeyRef) {
+		// A rotated key is fetched again when the endpoint rejects the old one.
+		httpClient.Transport = secrets.BearerTransport(httpClient.Transport, apiKeyRef)
+	}
+	// The recordings hold the prompts, and so the messages, like the stores.
+	key, err := storageKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if f.recordDir != "" {
+		recorder := llm.NewRecordingTransport(f.recordDir, httpClient.Transport)
+		recorder.Key = key
+		httpClient.Transport = recorder
+	} else if f.replayDir != "" {
+		replayer := llm.NewReplayTransport(f.replayDir)
+		replayer.Key = key
+		httpClient.Transport = replayer
+	} else if f.mock {
+		httpClient.Transport = llm.NewMockTransport(mockJudgment)
+	}
+	p := &pipeline{cfg: cfg, provider: llm.NewOpenAIProviderWithClient(cfg, httpClient), filter: f.filter, dedup: !f.noDedup, headersOnly: f.headersOnly}
+	if p.tokens, err = apiTokens(cfg); err != nil {
+		return nil, err
+	}
+	if p.hooks, err = hook.FromConfig(cfg); err != nil {
+		return nil, fmt.Errorf("error creating hooks: %w", err)
+	}
+	if p.plugins, err = plugin.FromConfig(cfg); err != nil {
+		return nil, fmt.Errorf("error creating analyzer plugins: %w", err)
+	}
+	if p.sandbox, err = sandbox.New(cfg); err != nil {
+		return nil, fmt.Errorf("error creating sandbox: %w", err)
+	}
+	p.attachments = attachtext.New(cfg)
+	p.ocr = attachtext.NewOCR(cfg)
+	if f.dryRun {
+		p.provider.SetDryRun(os.Stdout)
+	}
+
+	var provider analyzer.LLMProvider = p.provider
+	if len(cfg.FallbackClassifierCommand) > 0 {
+		fallback, err := classifier.NewProvider(cfg.FallbackClassifierCommand)
+		if err != nil {
+			return nil, fmt.Errorf("error creating fallback classifier: %w", err)
+		}
+		provider = analyzer.NewFallbackProvider(p.provider, fallback)
+	}
+	p.analyzer = analyzer.NewEmailAnalyzer(provider)
+	p.analyzer.SetMaxImages(cfg.MaxImages)
+	if cfg.TemplatesDir != "" {
+		if p.templates, err = analyzer.LoadTemplates(cfg.TemplatesDir); err != nil {
+			return nil, fmt.Errorf("error loading templates: %w", err)
+		}
+		p.analyzer.SetTemplates(p.templates)
+		p.provider.SetSystemPrompt(p.templates.SystemPrompt)
+	}
+	p.templateSets = map[string]*analyzer.Templates{}
+	for name, dir := range cfg.TemplateSets {
+		if p.templateSets[name], err = analyzer.LoadTemplates(dir); err != nil {
+			return nil, fmt.Errorf("error loading template set %s: %w", name, err)
+		}
+	}
+	enrichers, err := enrichment.FromConfig(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("error creating enrichers: %w", err)
+	}
+	p.analyzer.SetEnrichers(enrichers)
+	// The pre-filter would call the embeddings API, so it is skipped in dry-run mode.
+	if cfg.VectorStorePath != "" && !f.dryRun {
+		store, err := vectorstore.OpenEncrypted(cfg.VectorStorePath, key)
+		if err != nil {
+			return nil, fmt.Errorf("error opening vector store: %w", err)
+		}
+		store.SetMaxEntries(cfg.VectorStoreMaxEntries)
+		p.prefilter = &analyzer.Prefilter{
+			Embedder:  p.provider,
+			Store:     store,
+			Threshold: cfg.SimilarityThreshold,
+			Mode:      cfg.PrefilterMode,
+		}
+		p.analyzer.SetPrefilter(p.prefilter)
+	}
+
+	if f.dryRun || f.analyzeOnly {
+		// There are no results to deliver or act upon.
+		p.actions, err = action.New(&config.Config{})
+		return p, err
+	}
+	sink.Version = version
+	if p.sinks, err = sink.FromConfig(cfg); err != nil {
+		return nil, fmt.Errorf("error creating output sinks: %w", err)
+	}
+	// The results databases are shared by the tenants whose results are not kept apart.
+	// The sender history and the related messages are those of the PostgreSQL database if
+	// there is one, since other hosts add to it too.
+	var databases []sink.Sink
+	var history *resultdb.DB
+	if f.dbPath != "" {
+		db, err := openResultDB(cfg, f.dbPath)
+		if err != nil {
+			return nil, fmt.Errorf("error opening results database: %w", err)
+		}
+		databases = append(databases, db)
+		history = db
+	}
+	if cfg.PostgresDSN != "" {
+		db, err := openResultDB(cfg, "")
+		if err != nil {
+			return nil, fmt.Errorf("error opening PostgreSQL results database: %w", err)
+		}
+		databases = append(databases, db)
+		history = db
+	}
+	global := p.sinks
+	p.sinks = append(slices.Clone(p.sinks), databases...)
+	if history != nil {
+		enrichers.SetSenderHistory(history)
+		enrichers.SetRelatedMessages(history)
+	}
+
+	if f.daemon && cfg.OutbreakThreshold > 0 {
+		p.outbreaks = outbreak.New(cfg.OutbreakThreshold, time.Duration(cfg.OutbreakWindow))
+	}
+
+	if cfg.AuditLog != "" {
+		if p.audit, err = audit.Open(cfg.AuditLog); err != nil {
+			return nil, err
+		}
+	}
+	if p.actions, err = action.New(cfg); err != nil {
+		return nil, fmt.Errorf("error creating actions: %w", err)
+	}
+	if f.actionsDryRun {
+		p.actions.SetDryRun(os.Stderr)
+	}
+	if p.tenants, err = newTenants(cfg, f, global, p.actions, databases); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// errMessageTimeout is the cause of the cancellation of an analysis that took longer than
+// message_timeout.
+var errMessageTimeout = errors.New("message timeout exceeded")
+
+// analyze parses and analyzes one message.
+func (p *pipeline) analyze(ctx context.Context, rawMessage []byte, sourceFile string) (*AnalysisResult, error) {
+	return p.analyzeWith(ctx, rawMessage, sourceFile, nil)
+}
+
+// analyzeWith is analyze, with the analysis options of a request, which may be nil.
+func (p *pipeline) analyzeWith(ctx context.Context, rawMessage []byte, sourceFile string, opts *analyzer.AnalysisOptions) (*AnalysisResult, error) {
+	a, err := p.parse(ctx, rawMessage, sourceFile, opts)
+	if err != nil {
+		return nil, err
+	}
+	p.enrich(a)
+	return p.judge(a)
+}
+
+// analysis is a message between the steps of its analysis, parse, enrich and judge,
+// which analyzeFiles runs in separate stages so that messages are parsed while others
+// wait for the LLM.
+type analysis struct {
+	ctx         context.Context
+	raw         []byte
+	sourceFile  string
+	opts        *analyzer.AnalysisOptions
+	email       *email.ParsedEmail
+	report      *email.FeedbackReport
+	policy      *config.Policy
+	judgment    *llm.Judgment
+	enrichments []enrichment.Result
+	// hashes are the SHA-256 hashes of the attachments.
+	hashes []string
+	// sandbox are the results of the attachments checked in the sandbox.
+	sandbox []sandbox.Result
+	// integrity is that of the sample reported or forwarded in the message, if any.
+	integrity *dkim.Integrity
+	// received is when the analysis started.
+	received time.Time
+	// model and provider are the LLM that judged the message, if one did.
+	model, provider string
+	// spent is the time spent in the steps so far, out of message_timeout.
+	spent time.Duration
+}
+
+// step runs f with a context that is done once the message has spent message_timeout in
+// the steps of its analysis. The time a message waits between steps is not counted, so
+// that the messages queued in a batch do not time out.
+func (p *pipeline) step(a *analysis, f func(ctx context.Context) error) error {
+	if p.cfg.MessageTimeout <= 0 {
+		return f(a.ctx)
+	}
+	ctx, cancel := context.WithTimeoutCause(a.ctx, time.Duration(p.cfg.MessageTimeout)-a.spent, errMessageTimeout)
+	defer cancel()
+	start := time.Now()
+	defer func() { a.spent += time.Since(start) }()
+	return f(ctx)
+}
+
+// parse parses a message, runs the before hooks and looks up its policy, unless the
+// context has one from withTenant. The timeout
+// covers the parsing too, which takes a while for large attachments. An ARF abuse report
+// is replaced with the message it reports, and a message without a body, such as a
+// pasted header block, is judged by its header alone. The DKIM signatures of the
+// reported message, or of the message forwarded as attachment, are verified. The text of
+// the attachments and images is extracted before the hooks, unless the message is judged
+// by its header alone.
+func (p *pipeline) parse(ctx context.Context, rawMessage []byte, sourceFile string, opts *analyzer.AnalysisOptions) (*analysis, error) {
+	if p.headersOnly {
+		opts = headersOnly(opts)
+	}
+	a := &analysis{ctx: ctx, raw: rawMessage, sourceFile: sourceFile, opts: opts, received: time.Now().UTC()}
+	err := p.step(a, func(ctx context.Context) error {
+		report, original, err := email.ParseFeedbackReport(rawMessage)
+		if err != nil {
+			return err
+		}
+		if report != nil {
+			a.report, a.raw, rawMessage = report, original, original
+		}
+		header, headerBlock := email.HeaderBlock(rawMessage)
+		if headerBlock {
+			a.raw, rawMessage = header, header
+			a.opts = headersOnly(a.opts)
+		}
+		a.email, err = email.ParseWithLimits(ctx, bytes.NewReader(rawMessage), email.Limits{
+			ParseTime:    time.Duration(p.cfg.MaxParseTime),
+			DecodedBytes: p.cfg.MaxDecodedBytes,
+			URLs:         p.cfg.MaxURLs,
+		})
+		if err != nil {
+			if context.Cause(ctx) == errMessageTimeout {
+				return fmt.Errorf("error parsing email: gave up after %s: %w", time.Duration(p.cfg.MessageTimeout), err)
+			}
+			return err
+		}
+		if headerBlock {
+			a.email.Warnings = append(a.email.Warnings, "the message has no body, so only its header was analyzed")
+		}
+		if !p.filter.match(a.email, len(rawMessage)) {
+			return errFiltered
+		}
+		// The body of a reported message of which only the header was kept cannot be
+		// verified.
+		sample := email.ForwardedMessage(rawMessage)
+		if a.report != nil {
+			sample = nil
+			if !headerBlock {
+				sample = rawMessage
+			}
+		}
+		if sample != nil {
+			a.integrity = p.samples.Verify(ctx, sample)
+		}
+		if len(a.email.Attachments) > 0 {
+			// Every attachment is hashed as it is decoded, but only those whose text is
+			// extracted are kept in memory.
+			var keep func(filename, contentType string) int64
+			extract := p.attachments != nil && (a.opts == nil || !a.opts.HeadersOnly)
+			if extract {
+				keep = p.attachments.MaxFileSize
+			}
+			files, err := email.ReadAttachmentsWithLimits(ctx, rawMessage, email.Limits{
+				ParseTime:    time.Duration(p.cfg.MaxParseTime),
+				DecodedBytes: p.cfg.MaxDecodedBytes,
+			}, keep)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Warning: %v", err)
+				a.email.Warnings = append(a.email.Warnings, err.Error())
+			}
+			a.hashes = attachmentHashes(files)
+			if extract {
+				a.email.AttachmentTexts = p.attachments.Extract(ctx, files)
+			}
+		}
+		if p.ocr != nil && len(a.email.Images) > 0 && (a.opts == nil || !a.opts.HeadersOnly) {
+			a.email.ImageTexts = p.ocr.Recognize(ctx, a.email.Images)
+		}
+		if err := p.hooks.BeforeAnalysis(ctx, a.email); err != nil {
+			return fmt.Errorf("error running hooks (Message-ID: %s): %w", a.email.MessageID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if policy, ok := ctx.Value(tenantKey{}).(*config.Policy); ok {
+		a.policy = policy
+	} else {
+		a.policy = p.policyFor(a.email)
+	}
+	if a.policy != nil && allowedSender(a.policy, p.cfg.AuthServIDs, a.email) {
+		a.judgment = &llm.Judgment{
+			Category:        "Safe",
+			Reason:          fmt.Sprintf("The sender domain is allowed by the policy of %s.", a.policy.Tenant),
+			ConfidenceScore: 1,
+		}
+	}
+	return a, nil
+}
+
+// headersOnly returns opts with HeadersOnly set.
+func headersOnly(opts *analyzer.AnalysisOptions) *analyzer.AnalysisOptions {
+	o := analyzer.AnalysisOptions{HeadersOnly: true}
+	if opts != nil {
+		o = *opts
+		o.HeadersOnly = true
+	}
+	return &o
+}
+
+// attachmentHashes returns the distinct SHA-256 hashes of the attachment files, as
+// indicators of compromise.
+func attachmentHashes(files []email.File) []string {
+	var hashes []string
+	for _, f := range files {
+		if !slices.Contains(hashes, f.SHA256) {
+			hashes = append(hashes, f.SHA256)
+		}
+	}
+	return hashes
+}
+
+// enrich gathers the facts about a message that is to be judged by the LLM. The related
+// messages of a tenant whose results are kept apart are only looked up among its own.
+func (p *pipeline) enrich(a *analysis) {
+	if a.judgment != nil {
+		return
+	}
+	p.step(a, func(ctx context.Context) error {
+		if a.policy != nil {
+			if t := p.tenants[a.policy.Tenant]; t != nil && t.isolated {
+				ctx = enrichment.WithRelatedMessages(ctx, t.related)
+			}
+		}
+		a.enrichments = p.analyzer.Enrich(ctx, a.email, a.opts)
+		return nil
+	})
+}
+
+// judge asks the LLM, and the analyzer plugins, for the judgment of a message unless its
+// policy decided it, merges the verdicts of the sandbox on its attachments into it, runs
+// the after hooks and returns the result. The sandbox is skipped for the messages judged
+// by their header alone.
+func (p *pipeline) judge(a *analysis) (*AnalysisResult, error) {
+	var verdicts []plugin.Verdict
+	err := p.step(a, func(ctx context.Context) error {
+		if a.judgment == nil {
+			// The plugins and the sandbox run while the LLM analyzes the message.
+			waitPlugins := p.plugins.Start(ctx, a.email)
+			waitSandbox := func() []sandbox.Result { return nil }
+			headersOnly := a.opts != nil && a.opts.HeadersOnly
+			if len(a.email.Attachments) > 0 && !headersOnly {
+				waitSandbox = p.sandbox.Start(ctx, a.raw)
+			}
+			model := p.cfg.ModelName
+			if headersOnly && p.cfg.HeadersOnlyModel != "" {
+				model = p.cfg.HeadersOnlyModel
+				ctx = llm.WithModel(ctx, model)
+			}
+			judgment, err := p.analyzer.AnalyzeEnriched(ctx, a.email, a.enrichments, a.opts)
+			verdicts = waitPlugins()
+			a.sandbox = waitSandbox()
+			if err != nil {
+				if context.Cause(ctx) == errMessageTimeout {
+					// The error itself only says that the context deadline was exceeded.
+					err = fmt.Errorf("gave up after %s: %w", time.Duration(p.cfg.MessageTimeout), err)
+				}
+				return fmt.Errorf("error analyzing email (Message-ID: %s): %w", a.email.MessageID, err)
+			}
+			a.judgment = p.sandbox.Merge(judgment, a.sandbox)
+			a.model, a.provider = model, p.cfg.Provider
+		}
+		if err := p.hooks.AfterAnalysis(ctx, a.email, a.judgment); err != nil {
+			return fmt.Errorf("error running hooks (Message-ID: %s): %w", a.email.MessageID, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return p.newResult(a, verdicts), nil
+}
+
+// newResult returns the result of a, once it is judged.
+func (p *pipeline) newResult(a *analysis, verdicts []plugin.Verdict) *AnalysisResult {
+	parsedEmail := a.email
+	result := &AnalysisResult{
+		AnalysisID:         newAnalysisID(),
+		MessageID:          parsedEmail.MessageID,
+		Subject:            parsedEmail.Subject,
+		From:               convertAddresses(parsedEmail.From),
+		To:                 convertAddresses(parsedEmail.To),
+		Judgment:           a.judgment,
+		Model:              a.model,
+		Provider:           a.provider,
+		ReceivedAt:         a.received,
+		AnalyzedAt:         time.Now().UTC(),
+		Enrichments:        a.enrichments,
+		Plugins:            verdicts,
+		Sandbox:            a.sandbox,
+		FeedbackReport:     a.report,
+		SampleIntegrity:    a.integrity,
+		SubjectObfuscation: email.SubjectObfuscation(parsedEmail.Subject),
+		Warnings:           parsedEmail.Warnings,
+		URLs:               parsedEmail.URLs,
+		Hashes:             a.hashes,
+		SourceFile:         a.sourceFile,
+		Raw:                a.raw,
+	}
+	if a.policy != nil {
+		result.Tenant = a.policy.Tenant
+		result.Judgment = applyPolicy(a.policy, a.judgment)
+	}
+	if p.outbreaks != nil {
+		result.fingerprint = outbreak.NewFingerprint(parsedEmail)
+	}
+	return result
+}
+
+// record sends result to the output sinks.
+func (p *pipeline) record(ctx context.Context, result *AnalysisResult) error {
+	ctx = p.withAudit(ctx)
+	sinks := p.sinks
+	if t := p.tenants[result.Tenant]; t != nil {
+		sinks = t.sinks
+	}
+	sinkResult := p.sinkResult(result)
+	err := sink.SendAll(ctx, sinks, sinkResult)
+	p.detectOutbreaks(ctx, result, sinkResult)
+	if err != nil {
+		return fmt.Errorf("error delivering result to output sinks: %w", err)
+	}
+	return nil
+}
+
+// detectOutbreaks adds result to the messages of the outbreak detector, and sends the
+// alerts of the outbreaks it starts to the global sinks, since an outbreak may span the
+// messages of several tenants.
+func (p *pipeline) detectOutbreaks(ctx context.Context, result *AnalysisResult, sinkResult *sink.Result) {
+	if p.outbreaks == nil || result.fingerprint == nil {
+		return
+	}
+	for _, o := range p.outbreaks.Observe(result.fingerprint, sinkResult) {
+		log.Printf("Outbreak: %d similar messages in %s share the %s %s", o.Messages, time.Duration(p.cfg.OutbreakWindow), o.Kind, o.Indicator)
+		if err := sink.SendOutbreak(ctx, p.sinks, o); err != nil {
+			log.Printf("Error delivering outbreak alert to output sinks: %v", err)
+		}
+	}
+}
+
+// act takes the configured actions for result. It must only be called once the result
+// has been recorded, since an action may move the source file.
+func (p *pipeline) act(ctx context.Context, result *AnalysisResult) error {
+	actions := p.actions
+	if t := p.tenants[result.Tenant]; t != nil {
+		actions = t.actions
+	}
+	if err := actions.Run(p.withAudit(ctx), &action.Message{Result: p.sinkResult(result), Raw: result.Raw}); err != nil {
+		return fmt.Errorf("error taking actions: %w", err)
+	}
+	return nil
+}
+
+// withAudit returns ctx with the audit log of p, if it has one.
+func (p *pipeline) withAudit(ctx context.Context) context.Context {
+	if p.audit == nil {
+		return ctx
+	}
+	return audit.WithLog(ctx, p.audit)
+}
+
+func (p *pipeline) sinkResult(result *AnalysisResult) *sink.Result {
+	return &sink.Result{
+		AnalysisID: result.AnalysisID,
+		SourceFile: result.SourceFile,
+		MessageID:  result.MessageID,
+		Subject:    result.Subject,
+		From:       result.From,
+		To:         result.To,
+		URLs:       result.URLs,
+		Hashes:     result.Hashes,
+		Judgment:   result.Judgment,
+		Model:      result.Model,
+		Provider:   result.Provider,
+		Tenant:     result.Tenant,
+		ReceivedAt: result.ReceivedAt,
+		AnalyzedAt: result.AnalyzedAt,
+	}
+}
+
+// close flushes the sinks, which may batch results, stops the analyzer plugins and logs
+// the LLM usage.
+func (p *pipeline) close() error {
+	if usage := p.provider.Usage(); usage.Requests > 0 {
+		log.Print(formatUsage(usage))
+	}
+	if err := p.plugins.Close(); err != nil {
+		log.Printf("Error stopping analyzer plugins: %v", err)
+	}
+	if p.prefilter != nil {
+		if err := p.prefilter.Close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not save the vector store: %v\n", err)
+		}
+	}
+	errs := []error{sink.CloseAll(p.sinks)}
+	for _, t := range p.tenants {
+		errs = append(errs, sink.CloseAll(t.own))
+	}
+	if p.audit != nil {
+		if err := p.audit.Close(); err != nil {
+			log.Printf("Error closing the audit log: %v", err)
+		}
+	}
+	if err := errors.Join(errs...); err != nil {
+		return fmt.Errorf("error delivering result to output sinks: %w", err)
+	}
+	return nil
+}
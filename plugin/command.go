@@ -0,0 +1,263 @@
+package plugin
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/hook"
+	"mail-analyzer/llm"
+)
+
+// ProtocolVersion is the version of the requests sent to the commands. It is increased
+// for changes that commands must handle.
+const ProtocolVersion = 1
+
+// Command is an Analyzer that runs a program, executed directly without a shell. For
+// each message, it writes a request to the program on stdin, on one line:
+//
+//	{"version": 1, "email": {"message_id": "...", "subject": "...", ...}}
+//
+// where "email" is the document given to hooks (see hook.Message). The program answers
+// with a judgment on one line of stdout:
+//
+//	{"category": "Phishing", "confidence_score": 0.9, "reason": "..."}
+//
+// is_suspicious defaults to true for any category other than "Safe". An empty object, or
+// no output at all, means that the program has no judgment of the message, and an object
+// with only "error" reports a failure.
+//
+// By default, the program is run for each message and reads one request. A persistent
+// command keeps one process running, which reads requests until stdin is closed and must
+// answer each one before it reads the next. The process is started again if it exits or
+// does not answer in time.
+type Command struct {
+	name       string
+	command    []string
+	timeout    time.Duration
+	persistent bool
+
+	mu   sync.Mutex // serializes the requests to proc
+	proc *process
+}
+
+// NewCommand creates a Command from the configuration of a plugin.
+func NewCommand(pc config.AnalyzerPlugin) (*Command, error) {
+	if len(pc.Command) == 0 || pc.Command[0] == "" {
+		return nil, fmt.Errorf("analyzer plugin %q: command is empty", pc.Name)
+	}
+	return &Command{name: pc.Name, command: pc.Command, timeout: time.Duration(pc.Timeout), persistent: pc.Persistent}, nil
+}
+
+// Name implements Analyzer.
+func (c *Command) Name() string {
+	return c.name
+}
+
+// String returns the name of the plugin, for errors and logs.
+func (c *Command) String() string {
+	return fmt.Sprintf("analyzer plugin %q", c.name)
+}
+
+type request struct {
+	Version int           `json:"version"`
+	Email   *hook.Message `json:"email"`
+}
+
+type response struct {
+	IsSuspicious    *bool   `json:"is_suspicious"`
+	Category        string  `json:"category"`
+	Reason          string  `json:"reason"`
+	ConfidenceScore float64 `json:"confidence_score"`
+	Error           string  `json:"error"`
+}
+
+// Analyze implements Analyzer.
+func (c *Command) Analyze(ctx context.Context, e *email.ParsedEmail) (*llm.Judgment, error) {
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+	var in bytes.Buffer
+	enc := json.NewEncoder(&in)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(request{Version: ProtocolVersion, Email: hook.NewMessage(e)}); err != nil {
+		return nil, err
+	}
+	var out []byte
+	var err error
+	if c.persistent {
+		out, err = c.roundTrip(ctx, in.Bytes())
+	} else {
+		out, err = c.run(ctx, in.Bytes())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return c.judgment(out)
+}
+
+// judgment returns the judgment of the output of the command.
+func (c *Command) judgment(out []byte) (*llm.Judgment, error) {
+	if len(bytes.TrimSpace(out)) == 0 {
+		return nil, nil
+	}
+	var r response
+	if err := json.Unmarshal(out, &r); err != nil {
+		return nil, fmt.Errorf("could not decode the output of %s: %w", c, err)
+	}
+	switch {
+	case r.Error != "":
+		return nil, fmt.Errorf("%s: %s", c, r.Error)
+	case r.Category == "":
+		return nil, nil
+	case r.ConfidenceScore < 0 || r.ConfidenceScore > 1:
+		return nil, fmt.Errorf("%s: confidence score %g is not between 0 and 1", c, r.ConfidenceScore)
+	}
+	j := &llm.Judgment{
+		IsSuspicious:    r.Category != "Safe",
+		Category:        r.Category,
+		Reason:          r.Reason,
+		ConfidenceScore: r.ConfidenceScore,
+	}
+	if r.IsSuspicious != nil {
+		j.IsSuspicious = *r.IsSuspicious
+	}
+	if j.Reason == "" {
+		j.Reason = fmt.Sprintf("Classified as %s by %s.", r.Category, c.name)
+	}
+	return j, nil
+}
+
+// run runs the command with in on stdin and returns its output.
+func (c *Command) run(ctx context.Context, in []byte) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, c.command[0], c.command[1:]...)
+	cmd.Stdin = bytes.NewReader(in)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		return nil, fmt.Errorf("%s failed: %w: %s", c, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+// process is the running process of a persistent command.
+type process struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout *bufio.Reader
+	// exited is closed once the process has exited, and its pipes are closed.
+	exited chan struct{}
+}
+
+// roundTrip sends the request line in to the process of the command, started if needed,
+// and returns its answer. The process is stopped if it does not answer before ctx is done.
+func (c *Command) roundTrip(ctx context.Context, in []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.proc == nil {
+		proc, err := c.start()
+		if err != nil {
+			return nil, err
+		}
+		c.proc = proc
+	}
+
+	type answer struct {
+		line []byte
+		err  error
+	}
+	done := make(chan answer, 1)
+	go func(proc *process) {
+		if _, err := proc.stdin.Write(in); err != nil {
+			done <- answer{err: err}
+			return
+		}
+		line, err := proc.stdout.ReadBytes('\n')
+		if errors.Is(err, io.EOF) && len(line) == 0 {
+			err = errors.New("the process exited")
+		} else if errors.Is(err, io.EOF) {
+			err = nil // the last answer of the process
+		}
+		done <- answer{line, err}
+	}(c.proc)
+	select {
+	case a := <-done:
+		if a.err != nil {
+			c.stop()
+			return nil, fmt.Errorf("%s failed: %w", c, a.err)
+		}
+		return a.line, nil
+	case <-ctx.Done():
+		c.stop()
+		return nil, fmt.Errorf("%s failed: %w", c, ctx.Err())
+	}
+}
+
+// start starts the process of the command. Its stderr goes to that of mail-analyzer.
+func (c *Command) start() (*process, error) {
+	cmd := exec.Command(c.command[0], c.command[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("could not start %s: %w", c, err)
+	}
+	proc := &process{cmd: cmd, stdin: stdin, stdout: bufio.NewReader(stdout), exited: make(chan struct{})}
+	go func() {
+		// Wait closes stdout once the process has exited, which ends a pending read.
+		cmd.Wait()
+		close(proc.exited)
+	}()
+	return proc, nil
+}
+
+// stop kills the process of the command, if any. c.mu must be held.
+func (c *Command) stop() {
+	if c.proc == nil {
+		return
+	}
+	c.proc.cmd.Process.Kill()
+	<-c.proc.exited
+	c.proc = nil
+}
+
+// Close stops the process of a persistent command. It closes its stdin and gives it a
+// moment to exit before killing it.
+func (c *Command) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.proc == nil {
+		return nil
+	}
+	c.proc.stdin.Close()
+	select {
+	case <-c.proc.exited:
+		c.proc = nil
+	case <-time.After(5 * time.Second):
+		c.stop()
+	}
+	return nil
+}
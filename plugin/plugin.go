@@ -0,0 +1,94 @@
+// Package plugin runs third-party analyzers, such as a custom ML model, next to the LLM.
+// Their verdicts are reported alongside the judgment of the LLM in the results, without
+// changing it, so that they can be compared, or combined by a later stage.
+package plugin
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// Analyzer analyzes messages independently of the LLM.
+type Analyzer interface {
+	// Name identifies the analyzer in the results.
+	Name() string
+	// Analyze returns the judgment of e, or nil if the analyzer has none, e.g. because
+	// the message is in a language that its model does not handle.
+	Analyze(ctx context.Context, e *email.ParsedEmail) (*llm.Judgment, error)
+}
+
+// Verdict is the result of an analyzer.
+type Verdict struct {
+	Name     string        `json:"name"`
+	Judgment *llm.Judgment `json:"judgment,omitempty"`
+	// Error is set when the analyzer failed, in which case there is no judgment.
+	Error string `json:"error,omitempty"`
+}
+
+// Set is a set of analyzers that run concurrently.
+type Set []Analyzer
+
+// Start starts the analyzers on e and returns a function that waits for their verdicts,
+// in the order of the set. Analyzers without a judgment are left out. An analyzer that
+// fails does not stop the others, and its verdict records the error.
+func (s Set) Start(ctx context.Context, e *email.ParsedEmail) (wait func() []Verdict) {
+	verdicts := make([]Verdict, len(s))
+	var wg sync.WaitGroup
+	for i, a := range s {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			verdicts[i].Name = a.Name()
+			j, err := a.Analyze(ctx, e)
+			if err != nil {
+				verdicts[i].Error = err.Error()
+				return
+			}
+			verdicts[i].Judgment = j
+		}()
+	}
+	return func() []Verdict {
+		wg.Wait()
+		var list []Verdict
+		for _, v := range verdicts {
+			if v.Judgment != nil || v.Error != "" {
+				list = append(list, v)
+			}
+		}
+		return list
+	}
+}
+
+// Run returns the verdicts of the analyzers on e. See Start.
+func (s Set) Run(ctx context.Context, e *email.ParsedEmail) []Verdict {
+	return s.Start(ctx, e)()
+}
+
+// Close stops the processes of the analyzers that keep one running.
+func (s Set) Close() error {
+	var errs []error
+	for _, a := range s {
+		if c, ok := a.(interface{ Close() error }); ok {
+			errs = append(errs, c.Close())
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// FromConfig returns the analyzer plugins configured in cfg.
+func FromConfig(cfg *config.Config) (Set, error) {
+	var set Set
+	for _, pc := range cfg.AnalyzerPlugins {
+		c, err := NewCommand(pc)
+		if err != nil {
+			return nil, err
+		}
+		set = append(set, c)
+	}
+	return set, nil
+}
@@ -0,0 +1,168 @@
+package plugin
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// writeScript writes a shell script that runs body with the requests on stdin.
+func writeScript(t *testing.T, body string) []string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "plugin.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body+"\n"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return []string{"/bin/sh", path}
+}
+
+func parse(t *testing.T) *email.ParsedEmail {
+	t.Helper()
+	raw := "From: Alice <alice@example.com>\r\nTo: bob@example.net\r\nSubject: Invoice\r\n\r\nPay at https://pay.example.org/\r\n"
+	e, err := email.Parse(strings.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return e
+}
+
+func TestCommand_Analyze(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    *llm.Judgment
+		wantErr string
+	}{
+		{
+			name:   "Judgment",
+			script: `grep -q '^{"version":1,"email":{"message_id":"","from":\[{"name":"Alice"' && echo '{"category": "Phishing", "confidence_score": 0.9, "reason": "Invoice lure."}'`,
+			want:   &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Invoice lure.", ConfidenceScore: 0.9},
+		},
+		{
+			name:   "Defaults",
+			script: `cat > /dev/null; echo '{"category": "Safe", "confidence_score": 0.6}'`,
+			want:   &llm.Judgment{Category: "Safe", Reason: "Classified as Safe by ml.", ConfidenceScore: 0.6},
+		},
+		{
+			name:   "Explicit is_suspicious",
+			script: `cat > /dev/null; echo '{"category": "Marketing", "is_suspicious": false}'`,
+			want:   &llm.Judgment{Category: "Marketing", Reason: "Classified as Marketing by ml."},
+		},
+		{name: "No output", script: `cat > /dev/null`},
+		{name: "Empty object", script: `cat > /dev/null; echo '{}'`},
+		{name: "Reported error", script: `cat > /dev/null; echo '{"error": "model not loaded"}'`, wantErr: "model not loaded"},
+		{name: "Failure", script: `echo 'out of memory' >&2; exit 1`, wantErr: "out of memory"},
+		{name: "Invalid output", script: `echo 'not json'`, wantErr: "could not decode"},
+		{name: "Invalid confidence", script: `echo '{"category": "Spam", "confidence_score": 90}'`, wantErr: "not between 0 and 1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := NewCommand(config.AnalyzerPlugin{Name: "ml", Command: writeScript(t, tt.script)})
+			if err != nil {
+				t.Fatal(err)
+			}
+			j, err := c.Analyze(context.Background(), parse(t))
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Analyze() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Analyze() error = %v", err)
+			}
+			if !reflect.DeepEqual(j, tt.want) {
+				t.Errorf("Analyze() = %+v, want %+v", j, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommand_Persistent(t *testing.T) {
+	// The script counts the requests it answers, to show that one process serves them all.
+	starts := filepath.Join(t.TempDir(), "starts")
+	c, err := NewCommand(config.AnalyzerPlugin{
+		Name:       "ml",
+		Persistent: true,
+		Timeout:    config.Duration(time.Second),
+		Command: writeScript(t, `echo start >> `+starts+`
+n=0
+while read -r line; do
+	n=$((n + 1))
+	case "$line" in
+	*'"subject":"Hang"'*) read -r never ;;
+	*) echo "{\"category\": \"Spam\", \"reason\": \"Request $n.\"}" ;;
+	esac
+done`),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	for _, want := range []string{"Request 1.", "Request 2."} {
+		j, err := c.Analyze(context.Background(), parse(t))
+		if err != nil {
+			t.Fatalf("Analyze() error = %v", err)
+		}
+		if j.Reason != want || !j.IsSuspicious {
+			t.Errorf("Analyze() = %+v, want reason %q", j, want)
+		}
+	}
+
+	// A process that does not answer in time is replaced.
+	hang := parse(t)
+	hang.Subject = "Hang"
+	if _, err := c.Analyze(context.Background(), hang); err == nil || !strings.Contains(err.Error(), "deadline exceeded") {
+		t.Errorf("Analyze() error = %v, want a timeout", err)
+	}
+	j, err := c.Analyze(context.Background(), parse(t))
+	if err != nil {
+		t.Fatalf("Analyze() after a timeout: error = %v", err)
+	}
+	if j.Reason != "Request 1." {
+		t.Errorf("Analyze() after a timeout = %+v, want the answer of a new process", j)
+	}
+	if err := c.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+	if b, _ := os.ReadFile(starts); strings.Count(string(b), "start") != 2 {
+		t.Errorf("the process was started %d times, want 2", strings.Count(string(b), "start"))
+	}
+}
+
+func TestSet_Run(t *testing.T) {
+	set, err := FromConfig(&config.Config{AnalyzerPlugins: []config.AnalyzerPlugin{
+		{Name: "slow", Command: writeScript(t, `cat > /dev/null; sleep 0.2; echo '{"category": "Phishing", "confidence_score": 0.8}'`)},
+		{Name: "abstaining", Command: writeScript(t, `cat > /dev/null`)},
+		{Name: "broken", Command: writeScript(t, `exit 3`)},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	verdicts := set.Run(context.Background(), parse(t))
+	if len(verdicts) != 2 {
+		t.Fatalf("Run() = %+v, want the verdicts of slow and broken", verdicts)
+	}
+	if v := verdicts[0]; v.Name != "slow" || v.Judgment == nil || v.Judgment.Category != "Phishing" || v.Error != "" {
+		t.Errorf("verdicts[0] = %+v", v)
+	}
+	if v := verdicts[1]; v.Name != "broken" || v.Judgment != nil || !strings.Contains(v.Error, "exit status 3") {
+		t.Errorf("verdicts[1] = %+v", v)
+	}
+	if err := set.Close(); err != nil {
+		t.Errorf("Close() error = %v", err)
+	}
+
+	if _, err := FromConfig(&config.Config{AnalyzerPlugins: []config.AnalyzerPlugin{{Name: "empty"}}}); err == nil {
+		t.Error("FromConfig() with an empty command: error = nil")
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"mail-analyzer/action"
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
+	"mail-analyzer/llm"
+	"mail-analyzer/sink"
+)
+
+// tenant holds the sinks and actions of a policy whose settings override those of the
+// configuration, or whose results are stored apart.
+type tenant struct {
+	// sinks are the sinks of the tenant followed by its results databases.
+	sinks []sink.Sink
+	// own are the sinks of the tenant alone, which are closed with the pipeline.
+	own     []sink.Sink
+	actions *action.Engine
+	// isolated is whether the results of the tenant are kept apart from those of the
+	// others, in which case its related messages are looked up in related, its own
+	// results database, or nowhere if it has none.
+	isolated bool
+	related  enrichment.RelatedMessages
+}
+
+// newTenants creates the sinks and actions of the policies of cfg that have settings,
+// a results database or API tokens. The results of the other tenants go to the sinks of
+// cfg, global, and the shared results databases, which are also those of the tenants
+// without a results database of their own, unless they have API tokens.
+func newTenants(cfg *config.Config, f *pipelineFlags, global []sink.Sink, actions *action.Engine, databases []sink.Sink) (map[string]*tenant, error) {
+	tenants := map[string]*tenant{}
+	for i := range cfg.Policies {
+		policy := &cfg.Policies[i]
+		if len(policy.Settings) == 0 && policy.ResultsDB == "" && len(policy.APITokens) == 0 {
+			continue
+		}
+		t := &tenant{actions: actions}
+		t.sinks = slices.Clone(global)
+		if len(policy.Settings) > 0 {
+			tenantCfg, err := policy.Apply(cfg)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := resolveSecrets(context.Background(), tenantCfg); err != nil {
+				return nil, fmt.Errorf("policy %q: %w", policy.Tenant, err)
+			}
+			if t.own, err = sink.FromConfig(tenantCfg); err != nil {
+				return nil, fmt.Errorf("error creating output sinks of policy %q: %w", policy.Tenant, err)
+			}
+			t.sinks = slices.Clone(t.own)
+			if t.actions, err = action.New(tenantCfg); err != nil {
+				return nil, fmt.Errorf("error creating actions of policy %q: %w", policy.Tenant, err)
+			}
+			if f.actionsDryRun {
+				t.actions.SetDryRun(os.Stderr)
+			}
+		}
+		switch {
+		case policy.ResultsDB != "":
+			db, err := openResultDB(cfg, policy.ResultsDB)
+			if err != nil {
+				sink.CloseAll(t.own)
+				return nil, fmt.Errorf("error opening results database of policy %q: %w", policy.Tenant, err)
+			}
+			t.own = append(t.own, db)
+			t.sinks = append(t.sinks, db)
+			t.isolated, t.related = true, db
+		case len(policy.APITokens) == 0:
+			t.sinks = append(t.sinks, databases...)
+		default:
+			t.isolated = true
+		}
+		tenants[policy.Tenant] = t
+	}
+	return tenants, nil
+}
+
+// tenantKey is the context key of the policy set by withTenant.
+type tenantKey struct{}
+
+// withTenant returns a context with which the messages are analyzed under policy, such
+// as that of the API token of a request, instead of that of their recipients.
+func withTenant(ctx context.Context, policy *config.Policy) context.Context {
+	return context.WithValue(ctx, tenantKey{}, policy)
+}
+
+// policyFor returns the policy of the recipients of e, or nil. The envelope recipient
+// recorded by the delivering MTA is used when there is one, since the To and Cc headers
+// may list recipients of other tenants, or none at all for Bcc recipients.
+func (p *pipeline) policyFor(e *email.ParsedEmail) *config.Policy {
+	if len(p.cfg.Policies) == 0 {
+		return nil
+	}
+	var domains []string
+	for _, name := range []string{"Delivered-To", "X-Original-To"} {
+		for _, value := range e.Header.Values(name) {
+			domains = append(domains, addressDomain(value))
+		}
+	}
+	if len(domains) > 0 {
+		return p.cfg.PolicyFor(domains...)
+	}
+	recipients := e.To
+	if cc, err := e.Header.AddressList("Cc"); err == nil {
+		recipients = append(slices.Clone(recipients), cc...)
+	}
+	for _, addr := range recipients {
+		domains = append(domains, addressDomain(addr.Address))
+	}
+	return p.cfg.PolicyFor(domains...)
+}
+
+// allowedSender reports whether the sender of e is allowed by policy. The From header
+// can be forged, so the sender is only allowed if a receiving server of authServIDs
+// recorded that it passed DMARC. Without authServIDs, no Authentication-Results header
+// is trusted, since the sender may have added the topmost one, such as in a file that
+// no receiving server has seen.
+func allowedSender(policy *config.Policy, authServIDs []string, e *email.ParsedEmail) bool {
+	if len(e.From) != 1 || len(authServIDs) == 0 {
+		return false
+	}
+	domain := addressDomain(e.From[0].Address)
+	auth := &enrichment.Auth{TrustedIDs: authServIDs}
+	return policy.AllowsSender(domain) && auth.DMARCPass(e, domain)
+}
+
+// applyPolicy returns j with the confidence threshold and the categories of policy.
+func applyPolicy(policy *config.Policy, j *llm.Judgment) *llm.Judgment {
+	adjusted := *j
+	if adjusted.IsSuspicious && adjusted.ConfidenceScore < policy.MinConfidence {
+		adjusted.IsSuspicious = false
+		adjusted.Reason = strings.TrimSpace(fmt.Sprintf("%s (Below the confidence threshold %.2f of %s.)", adjusted.Reason, policy.MinConfidence, policy.Tenant))
+	}
+	adjusted.Category = policy.Category(adjusted.Category)
+	return &adjusted
+}
+
+// addressDomain returns the domain of an address such as "<user@example.com>".
+func addressDomain(address string) string {
+	_, domain, _ := strings.Cut(address, "@")
+	return strings.ToLower(strings.Trim(domain, " <>"))
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+	"mail-analyzer/sink"
+)
+
+func TestPipeline_Policies(t *testing.T) {
+	var llmRequests atomic.Int32
+	fakeLLM := newFakeLLM(t)
+	llmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		llmRequests.Add(1)
+		fakeLLM.Config.Handler.ServeHTTP(w, r)
+	}))
+	defer llmServer.Close()
+	var mu sync.Mutex
+	delivered := map[string][]sink.Result{}
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var result sink.Result
+		json.NewDecoder(r.Body).Decode(&result)
+		mu.Lock()
+		delivered[r.URL.Path] = append(delivered[r.URL.Path], result)
+		mu.Unlock()
+	}))
+	defer webhook.Close()
+
+	cfg := &config.Config{
+		OpenAIBaseURL:       llmServer.URL,
+		ChatCompletionsPath: "/chat/completions",
+		WebhookURL:          webhook.URL + "/base",
+		AuthServIDs:         []string{"mx.acme.com"},
+		Policies: []config.Policy{{
+			Tenant:         "acme",
+			Domains:        []string{"acme.com"},
+			MinConfidence:  0.95,
+			Categories:     map[string]string{"phishing": "Credential Phishing"},
+			AllowedSenders: []string{"payroll.example"},
+			Settings:       json.RawMessage(`{"webhook_url": "` + webhook.URL + `/acme"}`),
+		}},
+	}
+	p, err := newPipeline(cfg, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	for _, test := range []struct {
+		message    string
+		tenant     string
+		want       llm.Judgment
+		llmRequest bool
+	}{
+		{
+			message:    "To: user@mail.acme.com\r\nSubject: Verify\r\n\r\nBody\r\n",
+			tenant:     "acme",
+			want:       llm.Judgment{Category: "Credential Phishing", Reason: "Fake login. (Below the confidence threshold 0.95 of acme.)", ConfidenceScore: 0.9},
+			llmRequest: true,
+		},
+		{
+			message: "Authentication-Results: mx.acme.com; dmarc=pass header.from=payroll.example\r\n" +
+				"From: hr@payroll.example\r\nTo: user@acme.com\r\nSubject: Payslip\r\n\r\nBody\r\n",
+			tenant: "acme",
+			want:   llm.Judgment{Category: "Safe", Reason: "The sender domain is allowed by the policy of acme.", ConfidenceScore: 1},
+		},
+		{
+			// A spoofed From header, with a pass recorded by a server that is not trusted.
+			message: "Authentication-Results: mx.attacker.example; dmarc=pass header.from=payroll.example\r\n" +
+				"Authentication-Results: mx.acme.com; dmarc=fail header.from=payroll.example\r\n" +
+				"From: hr@payroll.example\r\nTo: user@acme.com\r\nSubject: Payslip\r\n\r\nBody\r\n",
+			tenant:     "acme",
+			want:       llm.Judgment{Category: "Credential Phishing", Reason: "Fake login. (Below the confidence threshold 0.95 of acme.)", ConfidenceScore: 0.9},
+			llmRequest: true,
+		},
+		{
+			// A file without Authentication-Results.
+			message:    "From: hr@payroll.example\r\nTo: user@acme.com\r\nSubject: Payslip\r\n\r\nBody\r\n",
+			tenant:     "acme",
+			want:       llm.Judgment{Category: "Credential Phishing", Reason: "Fake login. (Below the confidence threshold 0.95 of acme.)", ConfidenceScore: 0.9},
+			llmRequest: true,
+		},
+		{
+			// The envelope recipient decides over the headers.
+			message:    "Delivered-To: user@other.example\r\nTo: user@acme.com\r\nSubject: Verify\r\n\r\nBody\r\n",
+			want:       llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 0.9},
+			llmRequest: true,
+		},
+	} {
+		before := llmRequests.Load()
+		result, err := p.analyze(context.Background(), []byte(test.message), "")
+		if err != nil {
+			t.Fatalf("analyze() error = %v", err)
+		}
+		if result.Tenant != test.tenant || !reflect.DeepEqual(*result.Judgment, test.want) {
+			t.Errorf("analyze(%q) = %q %+v, want %q %+v", test.message, result.Tenant, *result.Judgment, test.tenant, test.want)
+		}
+		if called := llmRequests.Load() > before; called != test.llmRequest {
+			t.Errorf("analyze(%q) called the model: %v", test.message, called)
+		}
+		if err := p.record(context.Background(), result); err != nil {
+			t.Fatalf("record() error = %v", err)
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+	if len(delivered["/acme"]) != 4 || len(delivered["/base"]) != 1 || delivered["/acme"][0].Tenant != "acme" {
+		t.Errorf("delivered %+v, want the results of acme to its own webhook", delivered)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Intervals between progress updates: a terminal shows a live status line, while other
+// outputs, such as log files, get a line now and then.
+const (
+	terminalProgressInterval = 200 * time.Millisecond
+	logProgressInterval      = 30 * time.Second
+)
+
+// progress reports the progress of a run over many messages: the number of messages
+// done, the rate, the estimated time remaining and the number of errors.
+type progress struct {
+	w        io.Writer
+	total    int
+	terminal bool
+	// quiet suppresses the progress, but not the messages printed with printf.
+	quiet bool
+	now   func() time.Time
+
+	start, last  time.Time
+	done, failed int
+	// shown is true while a status line is displayed on the terminal.
+	shown bool
+}
+
+func newProgress(w io.Writer, total int, terminal bool) *progress {
+	pr := &progress{w: w, total: total, terminal: terminal, now: time.Now}
+	pr.start = pr.now()
+	pr.last = pr.start
+	return pr
+}
+
+// update records a finished message and reports the progress when it is time to.
+func (pr *progress) update(failed bool) {
+	pr.done++
+	if failed {
+		pr.failed++
+	}
+	now := pr.now()
+	interval := logProgressInterval
+	if pr.terminal {
+		interval = terminalProgressInterval
+	}
+	if pr.quiet || now.Sub(pr.last) < interval && pr.done < pr.total {
+		return
+	}
+	pr.last = now
+	if pr.terminal {
+		fmt.Fprintf(pr.w, "\r\033[K%s", pr.status(now))
+		pr.shown = true
+	} else {
+		fmt.Fprintln(pr.w, pr.status(now))
+	}
+}
+
+// printf prints a message, such as an error, without garbling the status line.
+func (pr *progress) printf(format string, args ...any) {
+	if pr.shown {
+		fmt.Fprint(pr.w, "\r\033[K")
+		pr.shown = false
+	}
+	fmt.Fprintf(pr.w, format, args...)
+}
+
+// finish ends the status line on the terminal.
+func (pr *progress) finish() {
+	if pr.shown {
+		fmt.Fprintln(pr.w)
+		pr.shown = false
+	}
+}
+
+func (pr *progress) status(now time.Time) string {
+	s := fmt.Sprintf("%d of %d messages", pr.done, pr.total)
+	if pr.failed > 0 {
+		s += fmt.Sprintf(", %d errors", pr.failed)
+	}
+	elapsed := now.Sub(pr.start)
+	if pr.done == 0 || elapsed <= 0 {
+		return s
+	}
+	rate := float64(pr.done) / elapsed.Seconds()
+	s += fmt.Sprintf(", %.1f/s", rate)
+	if remaining := pr.total - pr.done; remaining > 0 {
+		eta := time.Duration(float64(remaining) / rate * float64(time.Second))
+		s += ", ETA " + eta.Round(time.Second).String()
+	}
+	return s
+}
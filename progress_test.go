@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestProgress(t *testing.T) {
+	var buf bytes.Buffer
+	now := time.Date(2025, 7, 1, 0, 0, 0, 0, time.UTC)
+	pr := newProgress(&buf, 4, false)
+	pr.now = func() time.Time { return now }
+	pr.start, pr.last = now, now
+
+	// Updates are throttled.
+	now = now.Add(10 * time.Second)
+	pr.update(false)
+	if buf.Len() != 0 {
+		t.Errorf("update() before the interval printed %q", buf.String())
+	}
+	now = now.Add(30 * time.Second)
+	pr.update(true)
+	if want := "2 of 4 messages, 1 errors, 0.1/s, ETA 40s\n"; buf.String() != want {
+		t.Errorf("update() printed %q, want %q", buf.String(), want)
+	}
+
+	// The last message is always reported.
+	buf.Reset()
+	pr.update(false)
+	pr.update(false)
+	if want := "4 of 4 messages, 1 errors, 0.1/s\n"; buf.String() != want {
+		t.Errorf("update() of the last message printed %q, want %q", buf.String(), want)
+	}
+}
+
+func TestProgressTerminal(t *testing.T) {
+	var buf bytes.Buffer
+	pr := newProgress(&buf, 2, true)
+	now := pr.start.Add(time.Second)
+	pr.now = func() time.Time { return now }
+	pr.update(false)
+	pr.printf("Error: %s\n", "failed")
+	pr.update(false)
+	pr.finish()
+	want := "\r\033[K1 of 2 messages, 1.0/s, ETA 1s\r\033[KError: failed\n\r\033[K2 of 2 messages, 2.0/s\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
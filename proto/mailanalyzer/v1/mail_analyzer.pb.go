@@ -0,0 +1,354 @@
+// gRPC interface of mail-analyzer, served by "mail-analyzer grpc".
+//
+// Regenerate the Go code after changing this file with:
+//
+//   protoc --go_out=. --go_opt=paths=source_relative \
+//     --go-grpc_out=. --go-grpc_opt=paths=source_relative \
+//     proto/mailanalyzer/v1/mail_analyzer.proto
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.9
+// 	protoc        v5.29.3
+// source: proto/mailanalyzer/v1/mail_analyzer.proto
+
+package mailanalyzerv1
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type AnalyzeRequest struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The raw RFC 5322 message.
+	RawMessage []byte `protobuf:"bytes,1,opt,name=raw_message,json=rawMessage,proto3" json:"raw_message,omitempty"`
+	// An identifier chosen by the client, echoed in the response so that
+	// streamed responses can be matched to their requests.
+	RequestId     string `protobuf:"bytes,2,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyzeRequest) Reset() {
+	*x = AnalyzeRequest{}
+	mi := &file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeRequest) ProtoMessage() {}
+
+func (x *AnalyzeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeRequest.ProtoReflect.Descriptor instead.
+func (*AnalyzeRequest) Descriptor() ([]byte, []int) {
+	return file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *AnalyzeRequest) GetRawMessage() []byte {
+	if x != nil {
+		return x.RawMessage
+	}
+	return nil
+}
+
+func (x *AnalyzeRequest) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+type AnalyzeResponse struct {
+	state protoimpl.MessageState `protogen:"open.v1"`
+	// The request_id of the request.
+	RequestId string    `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+	MessageId string    `protobuf:"bytes,2,opt,name=message_id,json=messageId,proto3" json:"message_id,omitempty"`
+	Subject   string    `protobuf:"bytes,3,opt,name=subject,proto3" json:"subject,omitempty"`
+	From      []string  `protobuf:"bytes,4,rep,name=from,proto3" json:"from,omitempty"`
+	To        []string  `protobuf:"bytes,5,rep,name=to,proto3" json:"to,omitempty"`
+	Judgment  *Judgment `protobuf:"bytes,6,opt,name=judgment,proto3" json:"judgment,omitempty"`
+	// The URLs found in the message.
+	Urls []string `protobuf:"bytes,7,rep,name=urls,proto3" json:"urls,omitempty"`
+	// A unique identifier of this analysis.
+	AnalysisId string `protobuf:"bytes,8,opt,name=analysis_id,json=analysisId,proto3" json:"analysis_id,omitempty"`
+	// Set instead of judgment if the message could not be analyzed (streams only;
+	// Analyze returns a gRPC error instead).
+	Error         string `protobuf:"bytes,9,opt,name=error,proto3" json:"error,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *AnalyzeResponse) Reset() {
+	*x = AnalyzeResponse{}
+	mi := &file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *AnalyzeResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*AnalyzeResponse) ProtoMessage() {}
+
+func (x *AnalyzeResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use AnalyzeResponse.ProtoReflect.Descriptor instead.
+func (*AnalyzeResponse) Descriptor() ([]byte, []int) {
+	return file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *AnalyzeResponse) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetMessageId() string {
+	if x != nil {
+		return x.MessageId
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetSubject() string {
+	if x != nil {
+		return x.Subject
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetFrom() []string {
+	if x != nil {
+		return x.From
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetTo() []string {
+	if x != nil {
+		return x.To
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetJudgment() *Judgment {
+	if x != nil {
+		return x.Judgment
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetUrls() []string {
+	if x != nil {
+		return x.Urls
+	}
+	return nil
+}
+
+func (x *AnalyzeResponse) GetAnalysisId() string {
+	if x != nil {
+		return x.AnalysisId
+	}
+	return ""
+}
+
+func (x *AnalyzeResponse) GetError() string {
+	if x != nil {
+		return x.Error
+	}
+	return ""
+}
+
+type Judgment struct {
+	state        protoimpl.MessageState `protogen:"open.v1"`
+	IsSuspicious bool                   `protobuf:"varint,1,opt,name=is_suspicious,json=isSuspicious,proto3" json:"is_suspicious,omitempty"`
+	// e.g. "Phishing", "Spam", "Malware-Distribution", "Safe"
+	Category        string  `protobuf:"bytes,2,opt,name=category,proto3" json:"category,omitempty"`
+	Reason          string  `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	ConfidenceScore float64 `protobuf:"fixed64,4,opt,name=confidence_score,json=confidenceScore,proto3" json:"confidence_score,omitempty"`
+	unknownFields   protoimpl.UnknownFields
+	sizeCache       protoimpl.SizeCache
+}
+
+func (x *Judgment) Reset() {
+	*x = Judgment{}
+	mi := &file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes[2]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *Judgment) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Judgment) ProtoMessage() {}
+
+func (x *Judgment) ProtoReflect() protoreflect.Message {
+	mi := &file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes[2]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Judgment.ProtoReflect.Descriptor instead.
+func (*Judgment) Descriptor() ([]byte, []int) {
+	return file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *Judgment) GetIsSuspicious() bool {
+	if x != nil {
+		return x.IsSuspicious
+	}
+	return false
+}
+
+func (x *Judgment) GetCategory() string {
+	if x != nil {
+		return x.Category
+	}
+	return ""
+}
+
+func (x *Judgment) GetReason() string {
+	if x != nil {
+		return x.Reason
+	}
+	return ""
+}
+
+func (x *Judgment) GetConfidenceScore() float64 {
+	if x != nil {
+		return x.ConfidenceScore
+	}
+	return 0
+}
+
+var File_proto_mailanalyzer_v1_mail_analyzer_proto protoreflect.FileDescriptor
+
+const file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDesc = "" +
+	"\n" +
+	")proto/mailanalyzer/v1/mail_analyzer.proto\x12\x0fmailanalyzer.v1\"P\n" +
+	"\x0eAnalyzeRequest\x12\x1f\n" +
+	"\vraw_message\x18\x01 \x01(\fR\n" +
+	"rawMessage\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x02 \x01(\tR\trequestId\"\x8f\x02\n" +
+	"\x0fAnalyzeResponse\x12\x1d\n" +
+	"\n" +
+	"request_id\x18\x01 \x01(\tR\trequestId\x12\x1d\n" +
+	"\n" +
+	"message_id\x18\x02 \x01(\tR\tmessageId\x12\x18\n" +
+	"\asubject\x18\x03 \x01(\tR\asubject\x12\x12\n" +
+	"\x04from\x18\x04 \x03(\tR\x04from\x12\x0e\n" +
+	"\x02to\x18\x05 \x03(\tR\x02to\x125\n" +
+	"\bjudgment\x18\x06 \x01(\v2\x19.mailanalyzer.v1.JudgmentR\bjudgment\x12\x12\n" +
+	"\x04urls\x18\a \x03(\tR\x04urls\x12\x1f\n" +
+	"\vanalysis_id\x18\b \x01(\tR\n" +
+	"analysisId\x12\x14\n" +
+	"\x05error\x18\t \x01(\tR\x05error\"\x8e\x01\n" +
+	"\bJudgment\x12#\n" +
+	"\ris_suspicious\x18\x01 \x01(\bR\fisSuspicious\x12\x1a\n" +
+	"\bcategory\x18\x02 \x01(\tR\bcategory\x12\x16\n" +
+	"\x06reason\x18\x03 \x01(\tR\x06reason\x12)\n" +
+	"\x10confidence_score\x18\x04 \x01(\x01R\x0fconfidenceScore2\xb4\x01\n" +
+	"\fMailAnalyzer\x12L\n" +
+	"\aAnalyze\x12\x1f.mailanalyzer.v1.AnalyzeRequest\x1a .mailanalyzer.v1.AnalyzeResponse\x12V\n" +
+	"\rAnalyzeStream\x12\x1f.mailanalyzer.v1.AnalyzeRequest\x1a .mailanalyzer.v1.AnalyzeResponse(\x010\x01B4Z2mail-analyzer/proto/mailanalyzer/v1;mailanalyzerv1b\x06proto3"
+
+var (
+	file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescOnce sync.Once
+	file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescData []byte
+)
+
+func file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescGZIP() []byte {
+	file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescOnce.Do(func() {
+		file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDesc), len(file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDesc)))
+	})
+	return file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDescData
+}
+
+var file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_proto_mailanalyzer_v1_mail_analyzer_proto_goTypes = []any{
+	(*AnalyzeRequest)(nil),  // 0: mailanalyzer.v1.AnalyzeRequest
+	(*AnalyzeResponse)(nil), // 1: mailanalyzer.v1.AnalyzeResponse
+	(*Judgment)(nil),        // 2: mailanalyzer.v1.Judgment
+}
+var file_proto_mailanalyzer_v1_mail_analyzer_proto_depIdxs = []int32{
+	2, // 0: mailanalyzer.v1.AnalyzeResponse.judgment:type_name -> mailanalyzer.v1.Judgment
+	0, // 1: mailanalyzer.v1.MailAnalyzer.Analyze:input_type -> mailanalyzer.v1.AnalyzeRequest
+	0, // 2: mailanalyzer.v1.MailAnalyzer.AnalyzeStream:input_type -> mailanalyzer.v1.AnalyzeRequest
+	1, // 3: mailanalyzer.v1.MailAnalyzer.Analyze:output_type -> mailanalyzer.v1.AnalyzeResponse
+	1, // 4: mailanalyzer.v1.MailAnalyzer.AnalyzeStream:output_type -> mailanalyzer.v1.AnalyzeResponse
+	3, // [3:5] is the sub-list for method output_type
+	1, // [1:3] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_proto_mailanalyzer_v1_mail_analyzer_proto_init() }
+func file_proto_mailanalyzer_v1_mail_analyzer_proto_init() {
+	if File_proto_mailanalyzer_v1_mail_analyzer_proto != nil {
+		return
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDesc), len(file_proto_mailanalyzer_v1_mail_analyzer_proto_rawDesc)),
+			NumEnums:      0,
+			NumMessages:   3,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_proto_mailanalyzer_v1_mail_analyzer_proto_goTypes,
+		DependencyIndexes: file_proto_mailanalyzer_v1_mail_analyzer_proto_depIdxs,
+		MessageInfos:      file_proto_mailanalyzer_v1_mail_analyzer_proto_msgTypes,
+	}.Build()
+	File_proto_mailanalyzer_v1_mail_analyzer_proto = out.File
+	file_proto_mailanalyzer_v1_mail_analyzer_proto_goTypes = nil
+	file_proto_mailanalyzer_v1_mail_analyzer_proto_depIdxs = nil
+}
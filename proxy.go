@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"mail-analyzer/mta"
+)
+
+// runProxy implements the "proxy" command, an SMTP or LMTP server that forwards the
+// messages it receives, with verdict headers, to the real destination server.
+func runProxy(args []string) error {
+	fs := newFlagSet("proxy", "--forward host:port",
+		"Receive messages over SMTP or LMTP, add the verdict as X-Mail-Analyzer-* headers and\n"+
+			"forward them to the destination server, for example in front of Dovecot's LMTP service.\n"+
+			"Addresses starting with / are Unix sockets. If a message cannot be analyzed, it is\n"+
+			"forwarded without a verdict unless --fail-closed is set.")
+	var pf pipelineFlags
+	pf.register(fs)
+	pf.daemon = true
+	listen := fs.String("listen", "127.0.0.1:10024", "Address to listen on")
+	lmtp := fs.Bool("lmtp", false, "Speak LMTP instead of SMTP to clients")
+	forward := fs.String("forward", "", "Address of the destination server")
+	forwardLMTP := fs.Bool("forward-lmtp", false, "Speak LMTP instead of SMTP to the destination server")
+	maxSize := fs.Int64("max-message-size", defaultMaxMessageSize, "Largest accepted message in bytes")
+	failClosed := fs.Bool("fail-closed", false, "Reject messages that cannot be analyzed with a temporary error instead of forwarding them")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+	if *forward == "" {
+		return usageErrorf("--forward is required")
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	live := newLivePipeline(p, &pf)
+
+	destination := &mta.SMTP{Address: *forward, LMTP: *forwardLMTP, Timeout: reinjectTimeout}
+	hostname, _ := os.Hostname()
+	server := &mta.Server{
+		LMTP:           *lmtp,
+		Hostname:       hostname,
+		MaxMessageSize: *maxSize,
+		Handler:        newProxyHandler(live, destination, *failClosed),
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(*listen, "/") {
+		network = "unix"
+		os.Remove(*listen) // A stale socket from a previous run
+	}
+	lis, err := net.Listen(network, *listen)
+	if err != nil {
+		return err
+	}
+	// On SIGINT or SIGTERM, the messages being analyzed are cancelled and deferred, so that
+	// the MTA delivers them again later.
+	ctx, stop := shutdownContext()
+	defer stop()
+	server.BaseContext = ctx
+	go live.watch(ctx)
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Listening on %s, forwarding to %s\n", *listen, *forward)
+	if err := server.Serve(lis); !errors.Is(err, mta.ErrServerClosed) {
+		return err
+	}
+	if err := live.close(); err != nil {
+		return err
+	}
+	return interruptedError(ctx, context.Cause(ctx))
+}
+
+// newProxyHandler returns the handler of the proxy command, which analyzes each message
+// and forwards it to destination.
+func newProxyHandler(src pipelineSource, destination mta.Deliverer, failClosed bool) mta.HandlerFunc {
+	return func(ctx context.Context, from string, to []string, msg []byte) error {
+		p, release := src.acquire()
+		defer release()
+		filtered, result, err := filterMessage(ctx, p, msg, "", failClosed)
+		if ctx.Err() != nil {
+			return &textproto.Error{Code: 451, Msg: "4.3.2 Shutting down, try again later"}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return &textproto.Error{Code: 451, Msg: "4.3.0 Message could not be analyzed, try again later"}
+		}
+
+		err = destination.Deliver(ctx, from, to, filtered)
+		var rcptErrs mta.RecipientErrors
+		if err != nil && !errors.As(err, &rcptErrs) {
+			// Nothing was delivered.
+			return err
+		}
+		if result != nil {
+			if err := p.act(ctx, result); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			}
+		}
+		return err
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+type fakeDeliverer struct {
+	to  []string
+	msg string
+	err error
+}
+
+func (d *fakeDeliverer) Deliver(ctx context.Context, from string, to []string, msg []byte) error {
+	d.to, d.msg = to, string(msg)
+	return d.err
+}
+
+func TestProxyHandler(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	message := []byte("Message-ID: <1@example.com>\nSubject: Verify\n\nhttps://evil.example.com\n")
+
+	destination := &fakeDeliverer{}
+	handler := newProxyHandler(p, destination, false)
+	if err := handler(context.Background(), "alice@example.com", []string{"bob@example.com"}, message); err != nil {
+		t.Fatalf("handler error = %v", err)
+	}
+	if !strings.Contains(destination.msg, "X-Mail-Analyzer-Category: Phishing\n") || !strings.HasSuffix(destination.msg, string(message)) {
+		t.Errorf("forwarded message =\n%s", destination.msg)
+	}
+
+	// Errors of the destination are passed on to the client.
+	destination.err = &textproto.Error{Code: 550, Msg: "5.1.1 No such user"}
+	err = handler(context.Background(), "alice@example.com", []string{"bob@example.com"}, message)
+	var protoErr *textproto.Error
+	if !errors.As(err, &protoErr) || protoErr.Code != 550 {
+		t.Errorf("handler error = %v, want the destination's rejection", err)
+	}
+}
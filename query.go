@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"mail-analyzer/atrest"
+	"mail-analyzer/config"
+	"mail-analyzer/resultdb"
+)
+
+// runQuery implements the "query" subcommand, which searches a results database.
+func runQuery(args []string, stdout io.Writer) error {
+	fs := newFlagSet("query", "", "Search the results database given with --db, or the PostgreSQL database in postgres_dsn.")
+	dbPath := fs.String("db", "", "SQLite results database to search")
+	configPath := fs.String("config", "", "Configuration file with postgres_dsn, used when --db is not given")
+	category := fs.String("category", "", "Only show messages of this category")
+	suspicious := fs.String("suspicious", "", "Only show suspicious (true) or unsuspicious (false) messages")
+	since := fs.String("since", "", "Only show messages analyzed since a date (2006-01-02), timestamp (RFC 3339) or duration ago (24h)")
+	sender := fs.String("sender", "", "Only show messages whose From address contains this string")
+	messageID := fs.String("message-id", "", "Only show the message with this Message-ID")
+	analysisID := fs.String("analysis-id", "", "Only show the analysis with this analysis_id")
+	url := fs.String("url", "", "Only show messages with a URL containing this string")
+	limit := fs.Int("limit", 50, "Maximum number of results; 0 for no limit")
+	asJSON := fs.Bool("json", false, "Print results as JSON Lines instead of a table")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	db, err := openQueryDB(*dbPath, *configPath)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	filter := resultdb.Filter{Category: *category, Sender: *sender, MessageID: *messageID, UUID: *analysisID, URL: *url, Limit: *limit}
+	if *suspicious != "" {
+		b, err := strconv.ParseBool(*suspicious)
+		if err != nil {
+			return fmt.Errorf("invalid --suspicious value %q", *suspicious)
+		}
+		filter.Suspicious = &b
+	}
+	if *since != "" {
+		t, err := parseSince(*since, time.Now())
+		if err != nil {
+			return err
+		}
+		filter.Since = t
+	}
+
+	records, err := db.Query(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetEscapeHTML(false)
+		for _, r := range records {
+			if err := enc.Encode(r); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ANALYZED AT\tCATEGORY\tCONFIDENCE\tFROM\tSUBJECT\tMESSAGE-ID")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%.2f\t%s\t%s\t%s\n",
+			r.AnalyzedAt.Local().Format("2006-01-02 15:04"), r.Judgment.Category, r.Judgment.ConfidenceScore,
+			tableField(strings.Join(r.From, ", ")), tableField(r.Subject), tableField(r.MessageID))
+	}
+	return tw.Flush()
+}
+
+// openQueryDB opens the SQLite database at dbPath or, without one, the PostgreSQL
+// database configured in the configuration file or POSTGRES_DSN, with the storage key of
+// the configuration.
+func openQueryDB(dbPath, configPath string) (*resultdb.DB, error) {
+	if dbPath != "" {
+		if _, err := os.Stat(dbPath); err != nil {
+			return nil, fmt.Errorf("could not open results database: %w", err)
+		}
+	}
+	cfg, err := loadConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if dbPath == "" && cfg.PostgresDSN == "" {
+		return nil, fmt.Errorf("--db is required unless postgres_dsn is configured")
+	}
+	return openResultDB(cfg, dbPath)
+}
+
+// storageKey returns the storage key of cfg, or nil if there is none.
+func storageKey(cfg *config.Config) (*atrest.Key, error) {
+	key, err := atrest.NewKey(cfg.StorageKey)
+	if err != nil {
+		return nil, fmt.Errorf("storage_key: %w", err)
+	}
+	if cfg.StorageAllowPlaintext {
+		key.AllowPlaintext()
+	}
+	return key, nil
+}
+
+// openResultDB opens the SQLite results database at path or, if path is "", the
+// PostgreSQL database of postgres_dsn, with the storage key of cfg.
+func openResultDB(cfg *config.Config, path string) (*resultdb.DB, error) {
+	key, err := storageKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var db *resultdb.DB
+	if path != "" {
+		db, err = resultdb.Open(path)
+	} else {
+		db, err = resultdb.OpenPostgres(cfg.PostgresDSN)
+	}
+	if err != nil {
+		return nil, err
+	}
+	db.SetKey(key)
+	return db, nil
+}
+
+// parseSince parses a --since value as a date, an RFC 3339 timestamp or a duration before now.
+func parseSince(value string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --since value %q", value)
+}
+
+// tableField keeps untrusted header values from breaking the table layout.
+func tableField(s string) string {
+	s = strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+	if len(s) > 60 {
+		s = s[:57] + "..."
+	}
+	return s
+}
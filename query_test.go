@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+	"mail-analyzer/resultdb"
+	"mail-analyzer/sink"
+)
+
+func TestRunQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	db, err := resultdb.Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	db.Send(context.Background(), &sink.Result{MessageID: "<1@example.com>", Subject: "Verify\tnow", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.9}})
+	db.Send(context.Background(), &sink.Result{MessageID: "<2@example.com>", Subject: "Lunch", Judgment: &llm.Judgment{Category: "Safe"}})
+	db.Close()
+
+	var out bytes.Buffer
+	if err := runQuery([]string{"--db", path, "--suspicious", "true"}, &out); err != nil {
+		t.Fatalf("runQuery() error = %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 || !strings.Contains(lines[1], "Phishing") || !strings.Contains(lines[1], "Verify now") {
+		t.Errorf("unexpected table output:\n%s", out.String())
+	}
+
+	out.Reset()
+	if err := runQuery([]string{"--db", path, "--json", "--category", "Safe"}, &out); err != nil {
+		t.Fatalf("runQuery() error = %v", err)
+	}
+	if !strings.Contains(out.String(), `"message_id":"<2@example.com>"`) || strings.Count(out.String(), "\n") != 1 {
+		t.Errorf("unexpected JSON output: %s", out.String())
+	}
+
+	t.Setenv("POSTGRES_DSN", "")
+	if err := runQuery([]string{"--config", filepath.Join(t.TempDir(), "config.json"), "--category", "Safe"}, &out); err == nil {
+		t.Error("expected an error without --db or postgres_dsn")
+	}
+	if err := runQuery([]string{"--db", filepath.Join(t.TempDir(), "missing.sqlite")}, &out); err == nil {
+		t.Error("expected an error for a missing database")
+	}
+}
+
+func TestRunQuery_StorageKey(t *testing.T) {
+	const storageKey = "Zq0mWc1d8x7Yk3vB2nT5rP9sL4hJ6gF0"
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	db, err := openResultDB(&config.Config{StorageKey: storageKey}, path)
+	if err != nil {
+		t.Fatalf("openResultDB() error = %v", err)
+	}
+	db.Send(context.Background(), &sink.Result{MessageID: "<1@example.com>", Subject: "Payroll update", Judgment: &llm.Judgment{Category: "Phishing"}})
+	db.Close()
+
+	var out bytes.Buffer
+	configPath := filepath.Join(t.TempDir(), "config.json")
+	t.Setenv("STORAGE_KEY", storageKey)
+	if err := runQuery([]string{"--db", path, "--config", configPath}, &out); err != nil || !strings.Contains(out.String(), "Payroll update") {
+		t.Errorf("runQuery() with the storage key = %q, %v, want the decrypted subject", out.String(), err)
+	}
+	t.Setenv("STORAGE_KEY", "")
+	if err := runQuery([]string{"--db", path, "--config", configPath}, &out); err == nil || !strings.Contains(err.Error(), "storage_key") {
+		t.Errorf("runQuery() without the storage key error = %v", err)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "24h", want: now.Add(-24 * time.Hour)},
+		{value: "2025-07-01T08:00:00Z", want: time.Date(2025, 7, 1, 8, 0, 0, 0, time.UTC)},
+		{value: "2025-07-01", want: time.Date(2025, 7, 1, 0, 0, 0, 0, time.Local)},
+		{value: "yesterday", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseSince(tt.value, now)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseSince(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !got.Equal(tt.want) {
+			t.Errorf("parseSince(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package queue
+
+import (
+	"context"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpQueue uses durable AMQP queues, declared if they do not exist. Jobs are
+// acknowledged once their result is published, so that the broker delivers the jobs of a
+// worker that dies to another one. Results are published persistently to the results
+// queue and, for jobs with a reply-to queue, as the reply with the correlation ID of the
+// job.
+type amqpQueue struct {
+	conn       *amqp.Connection
+	channel    *amqp.Channel
+	deliveries <-chan amqp.Delivery
+	results    string
+}
+
+func openAMQP(rawURL string, opts Options) (*amqpQueue, error) {
+	conn, err := amqp.Dial(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := &amqpQueue{conn: conn, results: opts.Results}
+	if err := q.setup(opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return q, nil
+}
+
+func (q *amqpQueue) setup(opts Options) error {
+	var err error
+	if q.channel, err = q.conn.Channel(); err != nil {
+		return err
+	}
+	for _, name := range []string{opts.Jobs, opts.Results} {
+		if _, err := q.channel.QueueDeclare(name, true, false, false, false, nil); err != nil {
+			return err
+		}
+	}
+	if err := q.channel.Qos(opts.Prefetch, 0, false); err != nil {
+		return err
+	}
+	q.deliveries, err = q.channel.Consume(opts.Jobs, "", false, false, false, false, nil)
+	return err
+}
+
+func (q *amqpQueue) Receive(ctx context.Context) (Job, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case d, ok := <-q.deliveries:
+		if !ok {
+			return nil, ErrClosed
+		}
+		return &amqpJob{q: q, delivery: d}, nil
+	}
+}
+
+func (q *amqpQueue) Close() error {
+	return q.conn.Close()
+}
+
+type amqpJob struct {
+	q        *amqpQueue
+	delivery amqp.Delivery
+}
+
+func (j *amqpJob) Body() []byte { return j.delivery.Body }
+
+func (j *amqpJob) Complete(ctx context.Context, result []byte) error {
+	msg := amqp.Publishing{
+		ContentType:   "application/json",
+		DeliveryMode:  amqp.Persistent,
+		CorrelationId: j.delivery.CorrelationId,
+		Body:          result,
+	}
+	if err := j.q.channel.PublishWithContext(ctx, "", j.q.results, false, false, msg); err != nil {
+		return err
+	}
+	if j.delivery.ReplyTo != "" {
+		if err := j.q.channel.PublishWithContext(ctx, "", j.delivery.ReplyTo, false, false, msg); err != nil {
+			return err
+		}
+	}
+	return j.delivery.Ack(false)
+}
+
+func (j *amqpJob) Release(ctx context.Context) error {
+	return j.delivery.Nack(false, true)
+}
@@ -0,0 +1,79 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+// natsQueueGroup is the queue group of the workers, which share the jobs between them.
+const natsQueueGroup = "mail-analyzer"
+
+// natsQueue uses core NATS subjects, whose delivery is at most once: jobs the workers
+// have not received yet are lost when they stop. Results are published to the results
+// subject and, for jobs sent as requests, as the reply.
+type natsQueue struct {
+	conn    *nats.Conn
+	sub     *nats.Subscription
+	jobs    string
+	results string
+}
+
+func openNATS(rawURL string, opts Options) (*natsQueue, error) {
+	conn, err := nats.Connect(rawURL, nats.Name("mail-analyzer"), nats.MaxReconnects(-1), nats.Timeout(10*time.Second))
+	if err != nil {
+		return nil, err
+	}
+	sub, err := conn.QueueSubscribeSync(opts.Jobs, natsQueueGroup)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return &natsQueue{conn: conn, sub: sub, jobs: opts.Jobs, results: opts.Results}, nil
+}
+
+func (q *natsQueue) Receive(ctx context.Context) (Job, error) {
+	msg, err := q.sub.NextMsgWithContext(ctx)
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, nats.ErrConnectionClosed) || errors.Is(err, nats.ErrBadSubscription) {
+		return nil, ErrClosed
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &natsJob{q: q, msg: msg}, nil
+}
+
+func (q *natsQueue) Close() error {
+	q.sub.Unsubscribe()
+	err := q.conn.FlushTimeout(10 * time.Second)
+	q.conn.Close()
+	return err
+}
+
+type natsJob struct {
+	q   *natsQueue
+	msg *nats.Msg
+}
+
+func (j *natsJob) Body() []byte { return j.msg.Data }
+
+func (j *natsJob) Complete(ctx context.Context, result []byte) error {
+	if err := j.q.conn.Publish(j.q.results, result); err != nil {
+		return err
+	}
+	if j.msg.Reply != "" {
+		return j.msg.Respond(result)
+	}
+	return nil
+}
+
+func (j *natsJob) Release(ctx context.Context) error {
+	// Publishing the job again hands it to another member of the queue group, keeping
+	// the reply subject of a request.
+	return j.q.conn.PublishMsg(&nats.Msg{Subject: j.q.jobs, Reply: j.msg.Reply, Data: j.msg.Data})
+}
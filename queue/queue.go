@@ -0,0 +1,63 @@
+// Package queue receives analysis jobs from a message queue and publishes their results,
+// so that several analyzer hosts can share the work of one queue.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// ErrClosed is returned by Receive once the connection to the queue is lost for good.
+var ErrClosed = errors.New("queue connection closed")
+
+// Queue is a source of jobs.
+type Queue interface {
+	// Receive blocks until a job is available or ctx is done.
+	Receive(ctx context.Context) (Job, error)
+	Close() error
+}
+
+// Job is a received job. Exactly one of Complete and Release must be called.
+type Job interface {
+	Body() []byte
+	// Complete publishes the result of the job and removes the job from the queue.
+	Complete(ctx context.Context, result []byte) error
+	// Release returns the job to the queue, for this or another worker to process.
+	Release(ctx context.Context) error
+}
+
+// Options configures the queues of Open.
+type Options struct {
+	// Jobs and Results name the queues, lists or subjects the jobs are read from and the
+	// results are published to.
+	Jobs    string
+	Results string
+	// Prefetch is the number of unfinished jobs the broker hands to this worker at a
+	// time, where the broker supports it.
+	Prefetch int
+}
+
+// Open connects to the queue at rawURL, whose scheme selects the backend: redis or rediss
+// for Redis lists, nats for NATS, and amqp or amqps for AMQP 0-9-1 brokers.
+func Open(rawURL string, opts Options) (Queue, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid queue URL: %w", err)
+	}
+	if opts.Jobs == "" || opts.Results == "" {
+		return nil, errors.New("the job and result queue names are required")
+	}
+	opts.Prefetch = max(opts.Prefetch, 1)
+	switch u.Scheme {
+	case "redis", "rediss":
+		return openRedis(rawURL, opts)
+	case "nats":
+		return openNATS(rawURL, opts)
+	case "amqp", "amqps":
+		return openAMQP(rawURL, opts)
+	default:
+		return nil, fmt.Errorf("unsupported queue URL scheme %q: must be redis, rediss, nats, amqp or amqps", u.Scheme)
+	}
+}
@@ -0,0 +1,226 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/nats-io/nats.go"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+func TestOpen_Invalid(t *testing.T) {
+	opts := Options{Jobs: "jobs", Results: "results"}
+	if _, err := Open("kafka://broker:9092", opts); err == nil {
+		t.Error("Open() with an unsupported scheme succeeded")
+	}
+	if _, err := Open("redis://localhost:6379", Options{Jobs: "jobs"}); err == nil {
+		t.Error("Open() without a results queue succeeded")
+	}
+}
+
+func TestRedisQueue(t *testing.T) {
+	server := miniredis.RunT(t)
+	q, err := Open("redis://"+server.Addr(), Options{Jobs: "jobs", Results: "results"})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer q.Close()
+	server.Lpush("jobs", "first")
+	server.Lpush("jobs", "second")
+	ctx := context.Background()
+
+	job, err := q.Receive(ctx)
+	if err != nil || string(job.Body()) != "first" {
+		t.Fatalf("Receive() = %v, %v, want the first job", job, err)
+	}
+	if list, _ := server.List("jobs.processing"); len(list) != 1 || list[0] != "first" {
+		t.Errorf("processing list = %q, want the received job", list)
+	}
+	if err := job.Complete(ctx, []byte("result")); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if list, _ := server.List("results"); len(list) != 1 || list[0] != "result" {
+		t.Errorf("results = %q", list)
+	}
+	if server.Exists("jobs.processing") {
+		t.Error("the completed job is still being processed")
+	}
+
+	job, err = q.Receive(ctx)
+	if err != nil || string(job.Body()) != "second" {
+		t.Fatalf("Receive() = %v, %v, want the second job", job, err)
+	}
+	if err := job.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if job, err = q.Receive(ctx); err != nil || string(job.Body()) != "second" {
+		t.Fatalf("Receive() after Release() = %v, %v, want the released job", job, err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := q.Receive(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Receive() from an empty queue = %v, want the context error", err)
+	}
+}
+
+func TestRedisQueue_Stale(t *testing.T) {
+	server := miniredis.RunT(t)
+	opened, err := Open("redis://"+server.Addr(), Options{Jobs: "jobs", Results: "results"})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer opened.Close()
+	q := opened.(*redisQueue)
+	server.Lpush("jobs", "working")
+	// stranded was received by a worker that died before taking its lease.
+	server.Lpush("jobs.processing", "stranded")
+	ctx := context.Background()
+
+	job, err := q.Receive(ctx)
+	if err != nil || string(job.Body()) != "working" {
+		t.Fatalf("Receive() = %v, %v, want the working job", job, err)
+	}
+
+	if n, err := q.requeueStale(ctx, time.Now()); err != nil || n != 0 {
+		t.Errorf("requeueStale() = %d, %v, want no job before the leases end", n, err)
+	}
+	if members, _ := server.ZMembers("jobs.leases"); len(members) != 2 {
+		t.Errorf("leases = %q, want one for each job being processed", members)
+	}
+	if n, err := q.requeueStale(ctx, time.Now().Add(2*redisVisibilityTimeout)); err != nil || n != 2 {
+		t.Errorf("requeueStale() = %d, %v, want both jobs requeued", n, err)
+	}
+	if list, _ := server.List("jobs"); len(list) != 2 {
+		t.Errorf("jobs = %q, want the requeued jobs", list)
+	}
+	if server.Exists("jobs.processing") || server.Exists("jobs.leases") {
+		t.Error("the requeued jobs are still being processed")
+	}
+
+	// The worker that was slow rather than dead still publishes its result.
+	if err := job.Complete(ctx, []byte("result")); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if list, _ := server.List("results"); len(list) != 1 {
+		t.Errorf("results = %q", list)
+	}
+}
+
+// TestNATSQueue runs against the server in MAIL_ANALYZER_TEST_NATS_URL.
+func TestNATSQueue(t *testing.T) {
+	url := os.Getenv("MAIL_ANALYZER_TEST_NATS_URL")
+	if url == "" {
+		t.Skip("MAIL_ANALYZER_TEST_NATS_URL not set")
+	}
+	opts := Options{Jobs: "mail-analyzer.test.jobs", Results: "mail-analyzer.test.results"}
+	q, err := Open(url, opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer q.Close()
+	client, err := nats.Connect(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	results, err := client.SubscribeSync(opts.Results)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	reply := make(chan *nats.Msg, 1)
+	go func() {
+		msg, err := client.RequestWithContext(ctx, opts.Jobs, []byte("job"))
+		if err == nil {
+			reply <- msg
+		}
+		close(reply)
+	}()
+	job, err := q.Receive(ctx)
+	if err != nil || string(job.Body()) != "job" {
+		t.Fatalf("Receive() = %v, %v", job, err)
+	}
+	if err := job.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if job, err = q.Receive(ctx); err != nil || string(job.Body()) != "job" {
+		t.Fatalf("Receive() after Release() = %v, %v", job, err)
+	}
+	if err := job.Complete(ctx, []byte("result")); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	if msg, err := results.NextMsgWithContext(ctx); err != nil || string(msg.Data) != "result" {
+		t.Errorf("result = %v, %v", msg, err)
+	}
+	if msg := <-reply; msg == nil || string(msg.Data) != "result" {
+		t.Errorf("reply = %v", msg)
+	}
+}
+
+// TestAMQPQueue runs against the broker in MAIL_ANALYZER_TEST_AMQP_URL.
+func TestAMQPQueue(t *testing.T) {
+	url := os.Getenv("MAIL_ANALYZER_TEST_AMQP_URL")
+	if url == "" {
+		t.Skip("MAIL_ANALYZER_TEST_AMQP_URL not set")
+	}
+	opts := Options{Jobs: "mail-analyzer.test.jobs", Results: "mail-analyzer.test.results"}
+	q, err := Open(url, opts)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer q.Close()
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	ch, err := conn.Channel()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ch.QueuePurge(opts.Results, false); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := ch.PublishWithContext(ctx, "", opts.Jobs, false, false, amqp.Publishing{CorrelationId: "42", Body: []byte("job")}); err != nil {
+		t.Fatal(err)
+	}
+	job, err := q.Receive(ctx)
+	if err != nil || string(job.Body()) != "job" {
+		t.Fatalf("Receive() = %v, %v", job, err)
+	}
+	if err := job.Release(ctx); err != nil {
+		t.Fatalf("Release() error = %v", err)
+	}
+	if job, err = q.Receive(ctx); err != nil || string(job.Body()) != "job" {
+		t.Fatalf("Receive() after Release() = %v, %v", job, err)
+	}
+	if err := job.Complete(ctx, []byte("result")); err != nil {
+		t.Fatalf("Complete() error = %v", err)
+	}
+	for {
+		msg, ok, err := ch.Get(opts.Results, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ok {
+			if string(msg.Body) != "result" || msg.CorrelationId != "42" {
+				t.Errorf("result = %q (correlation ID %q)", msg.Body, msg.CorrelationId)
+			}
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatal("no result published")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
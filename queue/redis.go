@@ -0,0 +1,181 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisPollInterval bounds how long a blocking receive waits before checking its context.
+const redisPollInterval = time.Second
+
+// redisVisibilityTimeout is how long a received job stays in the processing list without
+// its worker renewing its lease before it is pushed back to the jobs list.
+const redisVisibilityTimeout = time.Minute
+
+// redisQueue uses Redis lists. Producers LPUSH jobs onto the jobs list; a received job is
+// moved atomically to the "<jobs>.processing" list until it is completed. The worker
+// holding a job renews its lease in the "<jobs>.leases" sorted set, scored by deadline,
+// and every worker requeues the jobs whose lease has expired, so that the jobs of a
+// worker that dies are processed again by another one. Results are LPUSHed onto the
+// results list.
+type redisQueue struct {
+	client     *redis.Client
+	jobs       string
+	processing string
+	leases     string
+	results    string
+	stop       context.CancelFunc
+}
+
+func openRedis(rawURL string, opts Options) (*redisQueue, error) {
+	redisOpts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	client := redis.NewClient(redisOpts)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, err
+	}
+	q := &redisQueue{
+		client:     client,
+		jobs:       opts.Jobs,
+		processing: opts.Jobs + ".processing",
+		leases:     opts.Jobs + ".leases",
+		results:    opts.Results,
+	}
+	reapCtx, stop := context.WithCancel(context.Background())
+	q.stop = stop
+	go q.reap(reapCtx)
+	return q, nil
+}
+
+// requeueScript gives a lease ending at ARGV[2] to the jobs of the processing list that
+// have none, such as those of a worker that died before taking its lease, then pushes the
+// jobs whose lease ended before ARGV[1] back to the head of the jobs list. Identical jobs
+// share a lease. It returns the number of jobs requeued.
+var requeueScript = redis.NewScript(`
+for _, body in ipairs(redis.call('LRANGE', KEYS[1], 0, -1)) do
+	redis.call('ZADD', KEYS[3], 'NX', ARGV[2], body)
+end
+local requeued = 0
+for _, body in ipairs(redis.call('ZRANGEBYSCORE', KEYS[3], '-inf', ARGV[1])) do
+	redis.call('ZREM', KEYS[3], body)
+	local n = redis.call('LREM', KEYS[1], 0, body)
+	for i = 1, n do
+		redis.call('RPUSH', KEYS[2], body)
+	end
+	requeued = requeued + n
+end
+return requeued
+`)
+
+// requeueStale pushes the jobs whose lease ended before now back to the jobs list.
+func (q *redisQueue) requeueStale(ctx context.Context, now time.Time) (int, error) {
+	keys := []string{q.processing, q.jobs, q.leases}
+	return requeueScript.Run(ctx, q.client, keys, now.UnixMilli(), now.Add(redisVisibilityTimeout).UnixMilli()).Int()
+}
+
+// reap requeues the stale jobs until ctx is done. Errors are retried at the next tick.
+func (q *redisQueue) reap(ctx context.Context) {
+	ticker := time.NewTicker(redisVisibilityTimeout / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			q.requeueStale(ctx, now)
+		}
+	}
+}
+
+func (q *redisQueue) Receive(ctx context.Context) (Job, error) {
+	for {
+		body, err := q.client.BLMove(ctx, q.jobs, q.processing, "RIGHT", "LEFT", redisPollInterval).Bytes()
+		if ctx.Err() != nil {
+			if err == nil {
+				// The job was taken just as ctx was cancelled: put it back.
+				job := &redisJob{q: q, body: body, stop: func() {}}
+				job.Release(context.Background())
+			}
+			return nil, ctx.Err()
+		}
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if errors.Is(err, redis.ErrClosed) {
+			return nil, ErrClosed
+		}
+		if err != nil {
+			return nil, err
+		}
+		return q.lease(body), nil
+	}
+}
+
+// lease returns the job of body, whose lease is renewed until it is completed or
+// released.
+func (q *redisQueue) lease(body []byte) *redisJob {
+	ctx, cancel := context.WithCancel(context.Background())
+	renew := func() {
+		q.client.ZAdd(ctx, q.leases, redis.Z{Score: float64(time.Now().Add(redisVisibilityTimeout).UnixMilli()), Member: body})
+	}
+	renew()
+	go func() {
+		ticker := time.NewTicker(redisVisibilityTimeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				renew()
+			}
+		}
+	}()
+	return &redisJob{q: q, body: body, stop: cancel}
+}
+
+func (q *redisQueue) Close() error {
+	q.stop()
+	return q.client.Close()
+}
+
+type redisJob struct {
+	q    *redisQueue
+	body []byte
+	// stop stops renewing the lease. A renewal racing with Complete or Release may leave
+	// a lease without a job, which the reaper drops.
+	stop context.CancelFunc
+}
+
+func (j *redisJob) Body() []byte { return j.body }
+
+func (j *redisJob) Complete(ctx context.Context, result []byte) error {
+	j.stop()
+	_, err := j.q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, j.q.results, result)
+		pipe.LRem(ctx, j.q.processing, 1, j.body)
+		pipe.ZRem(ctx, j.q.leases, j.body)
+		return nil
+	})
+	return err
+}
+
+func (j *redisJob) Release(ctx context.Context) error {
+	// RPUSH puts the job at the head of the queue again, since jobs are taken from the right.
+	j.stop()
+	_, err := j.q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, j.q.processing, 1, j.body)
+		pipe.ZRem(ctx, j.q.leases, j.body)
+		pipe.RPush(ctx, j.q.jobs, j.body)
+		return nil
+	})
+	return err
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"mail-analyzer/llm"
+	"mail-analyzer/resultdb"
+)
+
+// runReanalyze implements the "reanalyze" command, which analyzes stored messages again
+// and reports the verdicts that changed.
+func runReanalyze(args []string) error {
+	flags := newFlagSet("reanalyze", "",
+		"Analyze the messages of past analyses in a results database again, for example with\n"+
+			"another model, and report the verdicts that changed, to validate an upgrade against\n"+
+			"history before rolling it out. The database does not keep the messages themselves:\n"+
+			"they are read again from the files they were analyzed from, and analyses of other\n"+
+			"sources, or of files that no longer hold the same message, are skipped.")
+	pf := pipelineFlags{analyzeOnly: true}
+	pf.registerAnalysis(flags)
+	pf.registerTimeout(flags)
+	pf.registerDeadline(flags)
+	dbPath := flags.String("db", "", "SQLite results database with the past analyses")
+	category := flags.String("category", "", "Only re-analyze messages of this category")
+	since := flags.String("since", "", "Only re-analyze messages analyzed since a date (2006-01-02), timestamp (RFC 3339) or duration ago (24h)")
+	limit := flags.Int("limit", 0, "Re-analyze at most this many of the most recent messages; 0 for no limit")
+	concurrency := flags.Int("concurrency", 1, "Number of messages to analyze in parallel")
+	store := flags.Bool("store", false, "Store the new analyses in the database")
+	asJSON := flags.Bool("json", false, "Print the report as JSON")
+	if err := parseConfigFlags(flags, args); err != nil {
+		return err
+	}
+	if *concurrency < 1 {
+		return usageErrorf("--concurrency must be at least 1")
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	// Judgments reused by the pre-filter would hide the changes being looked for.
+	cfg.VectorStorePath = ""
+	var db *resultdb.DB
+	switch {
+	case *dbPath != "":
+		if _, err := os.Stat(*dbPath); err != nil {
+			return fmt.Errorf("could not open results database: %w", err)
+		}
+		db, err = openResultDB(cfg, *dbPath)
+	case cfg.PostgresDSN != "":
+		db, err = openResultDB(cfg, "")
+	default:
+		return usageErrorf("--db is required unless postgres_dsn is configured")
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	filter := resultdb.Filter{Category: *category}
+	if *since != "" {
+		if filter.Since, err = parseSince(*since, time.Now()); err != nil {
+			return err
+		}
+	}
+	records, err := db.Query(context.Background(), filter)
+	if err != nil {
+		return err
+	}
+	records = latestPerFile(records, *limit)
+
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	ctx, stop := shutdownContext()
+	defer stop()
+	ctx, cancel := pf.withDeadline(ctx)
+	defer cancel()
+	var storeResult func(*AnalysisResult) error
+	if *store {
+		storeResult = func(result *AnalysisResult) error {
+			_, err := db.Insert(ctx, p.sinkResult(result))
+			return err
+		}
+	}
+	report := reanalyze(ctx, p, records, *concurrency, storeResult)
+
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.SetEscapeHTML(false)
+		err = enc.Encode(report)
+	} else {
+		err = report.write(os.Stdout)
+	}
+	if err != nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return stoppedError(ctx, "re-analyzing %d of %d messages", report.Analyzed+report.Errors, len(records))
+	}
+	if report.Errors > 0 {
+		return fmt.Errorf("%d of %d messages could not be analyzed", report.Errors, len(records))
+	}
+	return nil
+}
+
+// latestPerFile keeps the most recent of the records, which are sorted newest first, for
+// each source file, up to limit records if limit is positive. Messages read from standard
+// input have no file to read them from again and are dropped.
+func latestPerFile(records []resultdb.Record, limit int) []resultdb.Record {
+	seen := map[string]bool{}
+	var latest []resultdb.Record
+	for _, r := range records {
+		if r.SourceFile == "" || r.SourceFile == "stdin" || seen[r.SourceFile] {
+			continue
+		}
+		seen[r.SourceFile] = true
+		latest = append(latest, r)
+		if limit > 0 && len(latest) == limit {
+			break
+		}
+	}
+	return latest
+}
+
+// reanalysisReport compares new analyses of stored messages with the stored ones.
+type reanalysisReport struct {
+	Model    string `json:"model"`
+	Messages int    `json:"messages"`
+	Analyzed int    `json:"analyzed"`
+	Changed  int    `json:"changed"`
+	Skipped  int    `json:"skipped"`
+	Errors   int    `json:"errors"`
+	// Transitions counts the changed verdicts by "Old → New" category.
+	Transitions map[string]int `json:"transitions"`
+	// Diffs lists the changed verdicts, skipped messages and errors.
+	Diffs []verdictDiff `json:"diffs"`
+}
+
+// verdictDiff is a stored analysis whose verdict changed, or that could not be repeated.
+type verdictDiff struct {
+	File      string        `json:"file"`
+	MessageID string        `json:"message_id"`
+	OldModel  string        `json:"old_model"`
+	Old       llm.Judgment  `json:"old"`
+	New       *llm.Judgment `json:"new,omitempty"`
+	// Skipped or Error explains why there is no new judgment.
+	Skipped string `json:"skipped,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// reanalyze analyzes the messages of records again and compares the verdicts. If store
+// is not nil, it is called with every new result. The messages are analyzed, and their
+// diffs listed, in the order of their files rather than of the records, which follows
+// the time of the last analyses, so that the reports of two runs can be compared.
+func reanalyze(ctx context.Context, p *pipeline, records []resultdb.Record, concurrency int, store func(*AnalysisResult) error) *reanalysisReport {
+	report := &reanalysisReport{Model: p.cfg.ModelName, Messages: len(records), Transitions: map[string]int{}, Diffs: []verdictDiff{}}
+	records = slices.Clone(records)
+	slices.SortStableFunc(records, func(a, b resultdb.Record) int { return strings.Compare(a.SourceFile, b.SourceFile) })
+	byFile := make(map[string]resultdb.Record, len(records))
+	var files []string
+	for _, r := range records {
+		if _, err := os.Stat(r.SourceFile); err != nil {
+			report.Skipped++
+			report.Diffs = append(report.Diffs, verdictDiff{File: r.SourceFile, MessageID: r.MessageID, OldModel: r.Model, Old: r.Judgment, Skipped: "the file no longer exists"})
+			continue
+		}
+		byFile[r.SourceFile] = r
+		files = append(files, r.SourceFile)
+	}
+
+	for o := range analyzeFiles(ctx, p, files, concurrency) {
+		if ctx.Err() != nil {
+			continue // Drain the messages abandoned after an interruption.
+		}
+		r := byFile[o.file]
+		diff := verdictDiff{File: r.SourceFile, MessageID: r.MessageID, OldModel: r.Model, Old: r.Judgment}
+		switch {
+		case o.err != nil:
+			report.Errors++
+			diff.Error = o.err.Error()
+		case o.result.MessageID != r.MessageID:
+			report.Skipped++
+			diff.Skipped = fmt.Sprintf("the file now holds another message (%s)", o.result.MessageID)
+		default:
+			report.Analyzed++
+			if store != nil {
+				if err := store(o.result); err != nil {
+					diff.Error = fmt.Sprintf("could not store the new analysis: %v", err)
+				}
+			}
+			diff.New = o.result.Judgment
+			oldCategory, _ := normalizeCategory(r.Judgment.Category)
+			newCategory, _ := normalizeCategory(diff.New.Category)
+			changed := oldCategory != newCategory || r.Judgment.IsSuspicious != diff.New.IsSuspicious
+			if changed {
+				report.Changed++
+				report.Transitions[oldCategory+" → "+newCategory]++
+			}
+			if !changed && diff.Error == "" {
+				continue
+			}
+		}
+		report.Diffs = append(report.Diffs, diff)
+	}
+	slices.SortStableFunc(report.Diffs, func(a, b verdictDiff) int { return strings.Compare(a.File, b.File) })
+	return report
+}
+
+// write prints the report as text.
+func (r *reanalysisReport) write(w io.Writer) error {
+	fmt.Fprintf(w, "Re-analyzed %d of %d messages with %s", r.Analyzed, r.Messages, r.Model)
+	if r.Skipped > 0 || r.Errors > 0 {
+		fmt.Fprintf(w, " (%d skipped, %d errors)", r.Skipped, r.Errors)
+	}
+	fmt.Fprintf(w, "\nChanged verdicts: %d", r.Changed)
+	if r.Analyzed > 0 {
+		fmt.Fprintf(w, " (%.1f%%)", 100*float64(r.Changed)/float64(r.Analyzed))
+	}
+	fmt.Fprintln(w)
+
+	transitions := make([]string, 0, len(r.Transitions))
+	for t := range r.Transitions {
+		transitions = append(transitions, t)
+	}
+	sort.Strings(transitions)
+	for _, t := range transitions {
+		fmt.Fprintf(w, "  %-22s %d\n", t, r.Transitions[t])
+	}
+
+	if len(r.Diffs) > 0 {
+		fmt.Fprintln(w, "\nDifferences:")
+	}
+	for _, d := range r.Diffs {
+		old := fmt.Sprintf("%s (%.2f, %s)", d.Old.Category, d.Old.ConfidenceScore, d.OldModel)
+		switch {
+		case d.New != nil && d.Error != "":
+			fmt.Fprintf(w, "%s: %s → %s (%.2f); %s\n", d.File, old, d.New.Category, d.New.ConfidenceScore, d.Error)
+		case d.New != nil:
+			fmt.Fprintf(w, "%s: %s → %s (%.2f): %s\n", d.File, old, d.New.Category, d.New.ConfidenceScore, d.New.Reason)
+		case d.Skipped != "":
+			fmt.Fprintf(w, "%s: %s, skipped: %s\n", d.File, old, d.Skipped)
+		default:
+			fmt.Fprintf(w, "%s: %s, error: %s\n", d.File, old, d.Error)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+	"mail-analyzer/resultdb"
+)
+
+func TestLatestPerFile(t *testing.T) {
+	records := []resultdb.Record{
+		{ID: 4, SourceFile: "a.eml"},
+		{ID: 3, SourceFile: "stdin"},
+		{ID: 2, SourceFile: "b.eml"},
+		{ID: 1, SourceFile: "a.eml"},
+	}
+	var ids []int64
+	for _, r := range latestPerFile(records, 0) {
+		ids = append(ids, r.ID)
+	}
+	if want := []int64{4, 2}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("latestPerFile() = %v, want %v", ids, want)
+	}
+	if got := latestPerFile(records, 1); len(got) != 1 || got[0].ID != 4 {
+		t.Errorf("latestPerFile(limit 1) = %+v", got)
+	}
+}
+
+func TestReanalyze(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	cfg := &config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", ModelName: "new-model"}
+	p, err := newPipeline(cfg, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	dir := t.TempDir()
+	file := func(name, messageID string) string {
+		path := filepath.Join(dir, name)
+		os.WriteFile(path, []byte("Message-ID: <"+messageID+">\r\nSubject: Verify\r\n\r\nBody\r\n"), 0o600)
+		return path
+	}
+	records := []resultdb.Record{
+		{SourceFile: file("same.eml", "1@example.com"), MessageID: "1@example.com", Model: "old-model", Judgment: llm.Judgment{IsSuspicious: true, Category: "Phishing"}},
+		{SourceFile: file("changed.eml", "2@example.com"), MessageID: "2@example.com", Model: "old-model", Judgment: llm.Judgment{Category: "Safe", ConfidenceScore: 0.6}},
+		{SourceFile: file("replaced.eml", "other@example.com"), MessageID: "3@example.com", Model: "old-model"},
+		{SourceFile: filepath.Join(dir, "missing.eml"), MessageID: "4@example.com", Model: "old-model"},
+	}
+
+	var stored []string
+	report := reanalyze(context.Background(), p, records, 2, func(r *AnalysisResult) error {
+		stored = append(stored, r.MessageID)
+		return nil
+	})
+	if report.Model != "new-model" || report.Messages != 4 || report.Analyzed != 2 || report.Changed != 1 || report.Skipped != 2 || report.Errors != 0 {
+		t.Errorf("report = %+v", report)
+	}
+	if want := map[string]int{"Safe → Phishing": 1}; !reflect.DeepEqual(report.Transitions, want) {
+		t.Errorf("Transitions = %v, want %v", report.Transitions, want)
+	}
+	// Diffs are in the order of the files.
+	if len(report.Diffs) != 3 || report.Diffs[0].New == nil || report.Diffs[0].MessageID != "2@example.com" || report.Diffs[1].Skipped == "" || report.Diffs[2].Skipped == "" {
+		t.Errorf("Diffs = %+v", report.Diffs)
+	}
+	if want := []string{"2@example.com", "1@example.com"}; !reflect.DeepEqual(stored, want) {
+		t.Errorf("stored = %v, want %v", stored, want)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"net/mail"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"mail-analyzer/enrichment"
+)
+
+// redactedText replaces recipient details removed by --redact.
+const redactedText = "[redacted]"
+
+// minRedactedTokenLength is the shortest name or local part that is masked in free text,
+// so that very short names do not mask unrelated words.
+const minRedactedTokenLength = 3
+
+// redactResult returns a copy of result that can be shared outside the organization:
+// recipient addresses are masked, keeping only their domain, and recipient names and
+// addresses are removed from the subject, its normalized form in subject_obfuscation, and
+// the reason. The recipients of a feedback report and the details of the related
+// messages, which quote their senders, subjects and reasons, are removed. The sender,
+// URLs and verdict are kept.
+func redactResult(result *AnalysisResult) *AnalysisResult {
+	redacted := *result
+	redacted.Raw = nil
+
+	redacted.Enrichments = redactEnrichments(result.Enrichments)
+	if report := result.FeedbackReport; report != nil && report.OriginalRcptTo != nil {
+		masked := *report
+		masked.OriginalRcptTo = nil
+		redacted.FeedbackReport = &masked
+	}
+
+	var tokens []string
+	redacted.To = make([]string, len(result.To))
+	for i, to := range result.To {
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			redacted.To[i] = redactedText
+			tokens = append(tokens, to)
+			continue
+		}
+		local, domain, _ := strings.Cut(addr.Address, "@")
+		redacted.To[i] = redactedText + "@" + domain
+		tokens = append(tokens, addr.Address, local, addr.Name)
+	}
+	if result.To == nil {
+		redacted.To = nil
+	}
+
+	if re := tokenRegexp(tokens); re != nil {
+		redacted.Subject = re.ReplaceAllString(result.Subject, redactedText)
+		if result.SubjectObfuscation != nil {
+			obfuscation := *result.SubjectObfuscation
+			obfuscation.Normalized = re.ReplaceAllString(obfuscation.Normalized, redactedText)
+			redacted.SubjectObfuscation = &obfuscation
+		}
+		if result.Judgment != nil {
+			judgment := *result.Judgment
+			judgment.Reason = re.ReplaceAllString(judgment.Reason, redactedText)
+			redacted.Judgment = &judgment
+		}
+	}
+	return &redacted
+}
+
+// redactEnrichments returns a copy of results without the details of the signals of
+// the related enricher.
+func redactEnrichments(results []enrichment.Result) []enrichment.Result {
+	i := slices.IndexFunc(results, func(r enrichment.Result) bool { return r.Name == enrichment.NameRelated })
+	if i < 0 {
+		return results
+	}
+	results = slices.Clone(results)
+	signals := slices.Clone(results[i].Signals)
+	for j := range signals {
+		signals[j].Detail = ""
+	}
+	results[i].Signals = signals
+	return results
+}
+
+// tokenRegexp returns a case-insensitive regexp matching any of tokens, longest first,
+// or nil if no token is long enough to be masked.
+func tokenRegexp(tokens []string) *regexp.Regexp {
+	var quoted []string
+	for _, t := range tokens {
+		if t = strings.TrimSpace(t); len(t) >= minRedactedTokenLength {
+			quoted = append(quoted, regexp.QuoteMeta(t))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	sort.Slice(quoted, func(i, j int) bool { return len(quoted[i]) > len(quoted[j]) })
+	return regexp.MustCompile("(?i)" + strings.Join(quoted, "|"))
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"mail-analyzer/email"
+	"mail-analyzer/enrichment"
+	"mail-analyzer/llm"
+)
+
+func TestRedactResult(t *testing.T) {
+	result := &AnalysisResult{
+		MessageID: "<1@evil.example.com>",
+		Subject:   "Taro, your mailbox taro.yamada@corp.example.com is full",
+		From:      []string{"<it@evil.example.com>"},
+		To:        []string{`"Taro" <taro.yamada@corp.example.com>`, "<bo@corp.example.com>"},
+		Judgment:  &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "It addresses TARO.YAMADA by name.", ConfidenceScore: 0.9},
+		URLs:      []string{"https://evil.example.com/login"},
+		Raw:       []byte("Subject: secret\n\nbody"),
+	}
+	got := redactResult(result)
+
+	want := &AnalysisResult{
+		MessageID: "<1@evil.example.com>",
+		Subject:   "[redacted], your mailbox [redacted] is full",
+		From:      []string{"<it@evil.example.com>"},
+		To:        []string{"[redacted]@corp.example.com", "[redacted]@corp.example.com"},
+		Judgment:  &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "It addresses [redacted] by name.", ConfidenceScore: 0.9},
+		URLs:      []string{"https://evil.example.com/login"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("redactResult() = %+v, want %+v", got, want)
+	}
+	if result.To[0] != `"Taro" <taro.yamada@corp.example.com>` || result.Judgment.Reason != "It addresses TARO.YAMADA by name." {
+		t.Error("redactResult() modified its input")
+	}
+}
+
+func TestRedactResult_SubjectObfuscation(t *testing.T) {
+	codepoints := []email.Codepoint{{Codepoint: "U+0410", Char: "А", Kind: email.KindMixedScript, Count: 1}}
+	result := &AnalysisResult{
+		Subject: "Taro, your Аpple ID is locked",
+		To:      []string{`"Taro" <taro@corp.example.com>`},
+		SubjectObfuscation: &email.Obfuscation{
+			Scripts:    []string{"Latin", "Cyrillic"},
+			Normalized: "Taro, your Apple ID is locked",
+			Codepoints: codepoints,
+		},
+	}
+	got := redactResult(result)
+
+	want := &email.Obfuscation{
+		Scripts:    []string{"Latin", "Cyrillic"},
+		Normalized: "[redacted], your Apple ID is locked",
+		Codepoints: codepoints,
+	}
+	if got.Subject != "[redacted], your Аpple ID is locked" {
+		t.Errorf("redactResult().Subject = %q", got.Subject)
+	}
+	if !reflect.DeepEqual(got.SubjectObfuscation, want) {
+		t.Errorf("redactResult().SubjectObfuscation = %+v, want %+v", got.SubjectObfuscation, want)
+	}
+	if result.SubjectObfuscation.Normalized != "Taro, your Apple ID is locked" {
+		t.Error("redactResult() modified its input")
+	}
+}
+
+func TestRedactResult_RelatedMessages(t *testing.T) {
+	result := &AnalysisResult{Enrichments: []enrichment.Result{
+		{Name: enrichment.NameAuth, Signals: []enrichment.Signal{{Name: "dmarc", Value: "pass", Detail: "DMARC passed."}}},
+		{Name: enrichment.NameRelated, Signals: []enrichment.Signal{{Name: "thread_message", Target: "1@vendor.example", Value: "Phishing",
+			Detail: `The message 1@vendor.example, an earlier message of this thread, from billing@vendor.example with the subject "Taro's invoice" ...`}}},
+	}}
+	got := redactResult(result)
+
+	want := []enrichment.Result{
+		{Name: enrichment.NameAuth, Signals: []enrichment.Signal{{Name: "dmarc", Value: "pass", Detail: "DMARC passed."}}},
+		{Name: enrichment.NameRelated, Signals: []enrichment.Signal{{Name: "thread_message", Target: "1@vendor.example", Value: "Phishing"}}},
+	}
+	if !reflect.DeepEqual(got.Enrichments, want) {
+		t.Errorf("redactResult().Enrichments = %+v, want %+v", got.Enrichments, want)
+	}
+	if result.Enrichments[1].Signals[0].Detail == "" {
+		t.Error("redactResult() modified its input")
+	}
+}
+
+func TestRedactResult_FeedbackReport(t *testing.T) {
+	result := &AnalysisResult{FeedbackReport: &email.FeedbackReport{
+		FeedbackType: "abuse", SourceIP: "203.0.113.5", OriginalRcptTo: []string{"taro@corp.example.com"},
+	}}
+	got := redactResult(result)
+
+	want := &email.FeedbackReport{FeedbackType: "abuse", SourceIP: "203.0.113.5"}
+	if !reflect.DeepEqual(got.FeedbackReport, want) {
+		t.Errorf("redactResult().FeedbackReport = %+v, want %+v", got.FeedbackReport, want)
+	}
+	if len(result.FeedbackReport.OriginalRcptTo) != 1 {
+		t.Error("redactResult() modified its input")
+	}
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDelay is how long the configuration files must be left alone before they are
+// reloaded, since editors and deployment tools write them in several steps.
+const reloadDelay = 500 * time.Millisecond
+
+// pipelineSource provides the pipeline that servers analyze a message with.
+type pipelineSource interface {
+	// acquire returns the pipeline, and a function to call once the message is done.
+	acquire() (*pipeline, func())
+}
+
+// acquire implements pipelineSource for a pipeline that is never replaced.
+func (p *pipeline) acquire() (*pipeline, func()) {
+	return p, func() {}
+}
+
+// livePipeline is the pipeline of a server, which is replaced with a new one when the
+// configuration is reloaded. Messages being analyzed finish with the pipeline they were
+// started with, which is closed once they are done, so that no message is dropped.
+type livePipeline struct {
+	flags *pipelineFlags
+
+	mu      sync.Mutex
+	current *pipelineGeneration
+	// stamp is the hash of the configuration files the current pipeline was created from.
+	stamp [sha256.Size]byte
+	// retired are the replaced pipelines that are being closed.
+	retired sync.WaitGroup
+	closed  bool
+}
+
+// pipelineGeneration is a pipeline along with the messages being analyzed with it.
+type pipelineGeneration struct {
+	p     *pipeline
+	users sync.WaitGroup
+}
+
+// newLivePipeline returns a livePipeline that starts with p, created with flags.
+func newLivePipeline(p *pipeline, flags *pipelineFlags) *livePipeline {
+	l := &livePipeline{flags: flags, current: &pipelineGeneration{p: p}}
+	l.stamp = l.configStamp()
+	return l
+}
+
+func (l *livePipeline) acquire() (*pipeline, func()) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	g := l.current
+	g.users.Add(1)
+	return g.p, g.users.Done
+}
+
+// reload creates a pipeline from the configuration files, and uses it for the messages
+// that arrive from now on. If the configuration is invalid, the current pipeline is kept.
+func (l *livePipeline) reload() error {
+	stamp := l.configStamp()
+	cfg, err := l.flags.readConfig()
+	if err != nil {
+		return err
+	}
+	p, err := newPipeline(cfg, l.flags)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	if l.closed {
+		// The server shut down while the pipeline was being created.
+		l.mu.Unlock()
+		return p.close()
+	}
+	old := l.current
+	p.outbreaks.Inherit(old.p.outbreaks)
+	p.inheritVectorStore(old.p)
+	l.current = &pipelineGeneration{p: p}
+	l.stamp = stamp
+	l.retired.Add(1)
+	l.mu.Unlock()
+	go func() {
+		defer l.retired.Done()
+		old.users.Wait()
+		if err := old.p.close(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+	}()
+	return nil
+}
+
+// inheritVectorStore makes p share the vector store of old if they use the same file, so
+// that the judgments remembered by either are kept, and saving one does not overwrite
+// the judgments of the other.
+func (p *pipeline) inheritVectorStore(old *pipeline) {
+	if p.prefilter == nil || old.prefilter == nil || p.prefilter.Store.Path() != old.prefilter.Store.Path() {
+		return
+	}
+	store := old.prefilter.Store
+	store.SetKey(p.prefilter.Store.Key())
+	store.SetMaxEntries(p.cfg.VectorStoreMaxEntries)
+	p.prefilter.Store = store
+}
+
+// close closes the current pipeline, once the replaced ones are closed. The server must
+// be done with every message.
+func (l *livePipeline) close() error {
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	l.retired.Wait()
+	return l.current.p.close()
+}
+
+// watch reloads the configuration on SIGHUP and when the configuration files change,
+// until ctx is done.
+func (l *livePipeline) watch(ctx context.Context) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	changed := l.watchFiles(ctx)
+	for {
+		var reason string
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			reason = "SIGHUP"
+		case <-changed:
+			if l.configStamp() == l.stamp {
+				continue
+			}
+			reason = "configuration change"
+		}
+		if err := l.reload(); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: could not reload the configuration after %s, keeping the current one: %v\n", reason, err)
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "Reloaded the configuration after %s\n", reason)
+	}
+}
+
+// watchFiles returns a channel that receives a value once the directories of the
+// configuration files have not changed for reloadDelay after a change. The directories
+// are watched rather than the files, which editors and tools such as Kubernetes replace
+// instead of writing them. Without file notifications, the channel never receives.
+func (l *livePipeline) watchFiles(ctx context.Context) <-chan struct{} {
+	changed := make(chan struct{}, 1)
+	paths, err := configPaths(l.flags.configPath)
+	if err != nil {
+		return changed
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: configuration changes are not watched, send SIGHUP to reload it: %v\n", err)
+		return changed
+	}
+	watched := 0
+	for _, path := range paths {
+		if watcher.Add(filepath.Dir(path)) == nil {
+			watched++
+		}
+	}
+	if watched == 0 {
+		watcher.Close()
+		return changed
+	}
+
+	go func() {
+		defer watcher.Close()
+		timer := time.NewTimer(0)
+		<-timer.C
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-watcher.Events:
+				timer.Reset(reloadDelay)
+			case <-watcher.Errors:
+			case <-timer.C:
+				select {
+				case changed <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+	return changed
+}
+
+// configStamp returns the hash of the content of the configuration files, to tell real
+// changes from the other events in their directories.
+func (l *livePipeline) configStamp() [sha256.Size]byte {
+	h := sha256.New()
+	paths, _ := configPaths(l.flags.configPath)
+	for _, path := range paths {
+		data, _ := os.ReadFile(path)
+		fmt.Fprintf(h, "%s %d\n", path, len(data))
+		h.Write(data)
+	}
+	var stamp [sha256.Size]byte
+	h.Sum(stamp[:0])
+	return stamp
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLivePipeline(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	path := filepath.Join(t.TempDir(), "config.json")
+	writeConfig := func(model string) {
+		t.Helper()
+		config := `{"openai_base_url": "` + llmServer.URL + `", "model_name": "` + model + `"}`
+		if err := os.WriteFile(path, []byte(config), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeConfig("first")
+	pf := &pipelineFlags{configPath: path}
+	cfg, err := pf.readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPipeline(cfg, pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := newLivePipeline(p, pf)
+
+	// A message being analyzed keeps its pipeline across a reload.
+	inFlight, release := live.acquire()
+	writeConfig("second")
+	if err := live.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	next, releaseNext := live.acquire()
+	releaseNext()
+	if inFlight.cfg.ModelName != "first" || next.cfg.ModelName != "second" {
+		t.Errorf("models = %q and %q, want the in-flight message to keep the first one", inFlight.cfg.ModelName, next.cfg.ModelName)
+	}
+	if _, err := inFlight.analyze(context.Background(), []byte("Subject: Test\r\n\r\nBody\r\n"), ""); err != nil {
+		t.Errorf("analyze() with the replaced pipeline error = %v", err)
+	}
+	release()
+
+	// An invalid configuration leaves the current pipeline in use.
+	os.WriteFile(path, []byte(`{"model_name": `), 0o600)
+	if err := live.reload(); err == nil {
+		t.Error("reload() of an invalid configuration succeeded")
+	}
+	if current, release := live.acquire(); current != next {
+		t.Error("reload() of an invalid configuration replaced the pipeline")
+	} else {
+		release()
+	}
+
+	// Changes of the file are picked up without a signal.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go live.watch(ctx)
+	time.Sleep(50 * time.Millisecond) // Let the watcher start.
+	writeConfig("third")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		current, release := live.acquire()
+		release()
+		if current.cfg.ModelName == "third" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("model = %q after the file changed, want it reloaded", current.cfg.ModelName)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	cancel()
+	if err := live.close(); err != nil {
+		t.Errorf("close() error = %v", err)
+	}
+}
+
+func TestLivePipeline_VectorStore(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.json")
+	config := `{"openai_base_url": "` + llmServer.URL + `", "vector_store_path": "` + filepath.Join(dir, "store.json") + `"}`
+	os.WriteFile(path, []byte(config), 0o600)
+	pf := &pipelineFlags{configPath: path}
+	cfg, err := pf.readConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := newPipeline(cfg, pf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	live := newLivePipeline(p, pf)
+	if err := live.reload(); err != nil {
+		t.Fatalf("reload() error = %v", err)
+	}
+	next, release := live.acquire()
+	release()
+	// Both pipelines add to and save the same store, so neither overwrites the other.
+	if next.prefilter.Store != p.prefilter.Store {
+		t.Error("the reloaded pipeline opened the vector store again")
+	}
+	if err := live.close(); err != nil {
+		t.Errorf("close() error = %v", err)
+	}
+}
@@ -0,0 +1,261 @@
+package resultdb
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dialect holds the database-specific parts of the results database.
+type dialect struct {
+	driver string
+	// migrations[i] upgrades the schema from version i to i+1.
+	migrations []string
+	// schemaVersion and setSchemaVersion read and record the applied migration version
+	// within the migration transaction.
+	schemaVersion    func(ctx context.Context, tx *sql.Tx) (int, error)
+	setSchemaVersion func(ctx context.Context, tx *sql.Tx, version int) error
+	// lock serializes migrations between processes, if the database needs it.
+	lock func(ctx context.Context, tx *sql.Tx) error
+	// timeValue converts a timestamp to the value stored in received_at, analyzed_at and
+	// created_at.
+	timeValue func(time.Time) any
+	// numbered placeholders ($1, $2, ...) instead of ?.
+	numbered bool
+	// indicatorOrder is the column that keeps indicators in insertion order.
+	indicatorOrder string
+}
+
+var sqliteDialect = &dialect{
+	driver: "sqlite",
+	migrations: []string{`
+CREATE TABLE IF NOT EXISTS analyses (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	source_file   TEXT NOT NULL,
+	message_id    TEXT NOT NULL,
+	subject       TEXT NOT NULL,
+	from_addrs    TEXT NOT NULL,
+	to_addrs      TEXT NOT NULL,
+	is_suspicious INTEGER NOT NULL,
+	category      TEXT NOT NULL,
+	reason        TEXT NOT NULL,
+	confidence    REAL NOT NULL,
+	model         TEXT NOT NULL,
+	analyzed_at   TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS analyses_message_id ON analyses(message_id);
+CREATE INDEX IF NOT EXISTS analyses_analyzed_at ON analyses(analyzed_at);
+CREATE TABLE IF NOT EXISTS indicators (
+	analysis_id INTEGER NOT NULL REFERENCES analyses(id) ON DELETE CASCADE,
+	type        TEXT NOT NULL,
+	value       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS indicators_value ON indicators(value);
+`, `
+CREATE TABLE IF NOT EXISTS feedback (
+	id            INTEGER PRIMARY KEY AUTOINCREMENT,
+	analysis_id   INTEGER NOT NULL REFERENCES analyses(id) ON DELETE CASCADE,
+	category      TEXT NOT NULL,
+	is_suspicious INTEGER NOT NULL,
+	reviewer      TEXT NOT NULL,
+	created_at    TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS feedback_analysis_id ON feedback(analysis_id);
+`, `
+ALTER TABLE analyses ADD COLUMN uuid TEXT NOT NULL DEFAULT '';
+ALTER TABLE analyses ADD COLUMN provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE analyses ADD COLUMN received_at TEXT NOT NULL DEFAULT '';
+UPDATE analyses SET received_at = analyzed_at;
+CREATE INDEX IF NOT EXISTS analyses_uuid ON analyses(uuid);
+`, `
+CREATE TABLE IF NOT EXISTS senders (
+	address               TEXT PRIMARY KEY,
+	messages              INTEGER NOT NULL,
+	flagged               INTEGER NOT NULL,
+	first_seen            TEXT NOT NULL,
+	last_seen             TEXT NOT NULL,
+	last_flagged_at       TEXT,
+	last_flagged_category TEXT NOT NULL
+);
+`, `
+ALTER TABLE analyses ADD COLUMN sealed TEXT NOT NULL DEFAULT '';
+`},
+	schemaVersion: func(ctx context.Context, tx *sql.Tx) (int, error) {
+		var version int
+		err := tx.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version)
+		return version, err
+	},
+	setSchemaVersion: func(ctx context.Context, tx *sql.Tx, version int) error {
+		_, err := tx.ExecContext(ctx, fmt.Sprintf("PRAGMA user_version = %d", version))
+		return err
+	},
+	lock: func(ctx context.Context, tx *sql.Tx) error { return nil },
+	timeValue: func(t time.Time) any {
+		return t.UTC().Format(time.RFC3339)
+	},
+	indicatorOrder: "rowid",
+}
+
+// migrationLockID is the PostgreSQL advisory lock key that serializes migrations
+// when several analyzer hosts start at the same time.
+const migrationLockID = 0x6d61696c // "mail"
+
+var postgresDialect = &dialect{
+	driver: "pgx",
+	migrations: []string{`
+CREATE TABLE IF NOT EXISTS analyses (
+	id            BIGSERIAL PRIMARY KEY,
+	source_file   TEXT NOT NULL,
+	message_id    TEXT NOT NULL,
+	subject       TEXT NOT NULL,
+	from_addrs    TEXT NOT NULL,
+	to_addrs      TEXT NOT NULL,
+	is_suspicious BOOLEAN NOT NULL,
+	category      TEXT NOT NULL,
+	reason        TEXT NOT NULL,
+	confidence    DOUBLE PRECISION NOT NULL,
+	model         TEXT NOT NULL,
+	analyzed_at   TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS analyses_message_id ON analyses(message_id);
+CREATE INDEX IF NOT EXISTS analyses_analyzed_at ON analyses(analyzed_at);
+CREATE TABLE IF NOT EXISTS indicators (
+	id          BIGSERIAL PRIMARY KEY,
+	analysis_id BIGINT NOT NULL REFERENCES analyses(id) ON DELETE CASCADE,
+	type        TEXT NOT NULL,
+	value       TEXT NOT NULL
+);
+CREATE INDEX IF NOT EXISTS indicators_value ON indicators(value);
+`, `
+CREATE TABLE IF NOT EXISTS feedback (
+	id            BIGSERIAL PRIMARY KEY,
+	analysis_id   BIGINT NOT NULL REFERENCES analyses(id) ON DELETE CASCADE,
+	category      TEXT NOT NULL,
+	is_suspicious BOOLEAN NOT NULL,
+	reviewer      TEXT NOT NULL,
+	created_at    TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX IF NOT EXISTS feedback_analysis_id ON feedback(analysis_id);
+`, `
+ALTER TABLE analyses ADD COLUMN IF NOT EXISTS uuid TEXT NOT NULL DEFAULT '';
+ALTER TABLE analyses ADD COLUMN IF NOT EXISTS provider TEXT NOT NULL DEFAULT '';
+ALTER TABLE analyses ADD COLUMN IF NOT EXISTS received_at TIMESTAMPTZ;
+UPDATE analyses SET received_at = analyzed_at WHERE received_at IS NULL;
+ALTER TABLE analyses ALTER COLUMN received_at SET NOT NULL;
+CREATE INDEX IF NOT EXISTS analyses_uuid ON analyses(uuid);
+`, `
+CREATE TABLE IF NOT EXISTS senders (
+	address               TEXT PRIMARY KEY,
+	messages              BIGINT NOT NULL,
+	flagged               BIGINT NOT NULL,
+	first_seen            TIMESTAMPTZ NOT NULL,
+	last_seen             TIMESTAMPTZ NOT NULL,
+	last_flagged_at       TIMESTAMPTZ,
+	last_flagged_category TEXT NOT NULL
+);
+`, `
+ALTER TABLE analyses ADD COLUMN IF NOT EXISTS sealed TEXT NOT NULL DEFAULT '';
+`},
+	schemaVersion: func(ctx context.Context, tx *sql.Tx) (int, error) {
+		if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+			version    INTEGER PRIMARY KEY,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+		)`); err != nil {
+			return 0, err
+		}
+		var version int
+		err := tx.QueryRowContext(ctx, "SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+		return version, err
+	},
+	setSchemaVersion: func(ctx context.Context, tx *sql.Tx, version int) error {
+		_, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version)
+		return err
+	},
+	lock: func(ctx context.Context, tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", migrationLockID)
+		return err
+	},
+	timeValue:      func(t time.Time) any { return t.UTC() },
+	numbered:       true,
+	indicatorOrder: "id",
+}
+
+// migrate applies pending migrations in a single transaction.
+func (d *dialect) migrate(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := d.lock(ctx, tx); err != nil {
+		return fmt.Errorf("could not lock schema: %w", err)
+	}
+	version, err := d.schemaVersion(ctx, tx)
+	if err != nil {
+		return fmt.Errorf("could not read schema version: %w", err)
+	}
+	if version > len(d.migrations) {
+		return fmt.Errorf("results database has schema version %d, newer than supported version %d", version, len(d.migrations))
+	}
+	for v := version; v < len(d.migrations); v++ {
+		if _, err := tx.ExecContext(ctx, d.migrations[v]); err != nil {
+			return fmt.Errorf("migration to schema version %d failed: %w", v+1, err)
+		}
+		if err := d.setSchemaVersion(ctx, tx, v+1); err != nil {
+			return fmt.Errorf("could not record schema version: %w", err)
+		}
+	}
+	return tx.Commit()
+}
+
+// rebind rewrites ? placeholders for dialects with numbered placeholders.
+// Queries in this package never contain ? inside string literals.
+func (d *dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// dbTime scans the timestamps, which are TEXT in SQLite and TIMESTAMPTZ in PostgreSQL. NULL
+// is the zero time.
+type dbTime struct{ time.Time }
+
+func (t *dbTime) Scan(src any) error {
+	switch v := src.(type) {
+	case nil:
+		t.Time = time.Time{}
+		return nil
+	case time.Time:
+		t.Time = v.UTC()
+		return nil
+	case string:
+		return t.parse(v)
+	case []byte:
+		return t.parse(string(v))
+	}
+	return fmt.Errorf("cannot scan %T into a timestamp", src)
+}
+
+func (t *dbTime) parse(s string) error {
+	parsed, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return err
+	}
+	t.Time = parsed
+	return nil
+}
@@ -0,0 +1,35 @@
+package resultdb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDialect_Rebind(t *testing.T) {
+	query := "SELECT id FROM analyses WHERE category = ? AND model = ? LIMIT ?"
+	if got := sqliteDialect.rebind(query); got != query {
+		t.Errorf("sqlite rebind() = %q, want unchanged", got)
+	}
+	want := "SELECT id FROM analyses WHERE category = $1 AND model = $2 LIMIT $3"
+	if got := postgresDialect.rebind(query); got != want {
+		t.Errorf("postgres rebind() = %q, want %q", got, want)
+	}
+}
+
+func TestDBTime_Scan(t *testing.T) {
+	want := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	for _, src := range []any{"2025-07-01T12:00:00Z", []byte("2025-07-01T12:00:00Z"), want.In(time.FixedZone("JST", 9*3600))} {
+		var got dbTime
+		if err := got.Scan(src); err != nil {
+			t.Errorf("Scan(%v) error = %v", src, err)
+			continue
+		}
+		if !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("Scan(%v) = %v, want %v", src, got.Time, want)
+		}
+	}
+	var got dbTime
+	if err := got.Scan(int64(0)); err == nil {
+		t.Error("expected an error for an integer")
+	}
+}
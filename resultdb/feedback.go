@@ -0,0 +1,53 @@
+package resultdb
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Feedback is the verdict of a reviewer on a stored analysis, either confirming or
+// correcting its judgment.
+type Feedback struct {
+	AnalysisID   int64     `json:"analysis_id"`
+	Category     string    `json:"category"`
+	IsSuspicious bool      `json:"is_suspicious"`
+	Reviewer     string    `json:"reviewer"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// AddFeedback stores f. The analysis it refers to must exist.
+func (d *DB) AddFeedback(ctx context.Context, f Feedback) error {
+	createdAt := f.CreatedAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	_, err := d.db.ExecContext(ctx, d.dialect.rebind(`INSERT INTO feedback
+		(analysis_id, category, is_suspicious, reviewer, created_at) VALUES (?, ?, ?, ?, ?)`),
+		f.AnalysisID, f.Category, f.IsSuspicious, f.Reviewer, d.dialect.timeValue(createdAt))
+	if err != nil {
+		return fmt.Errorf("resultdb: could not insert feedback: %w", err)
+	}
+	return nil
+}
+
+// Feedback returns the feedback on the analysis with the given ID, oldest first.
+func (d *DB) Feedback(ctx context.Context, analysisID int64) ([]Feedback, error) {
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(`SELECT analysis_id, category, is_suspicious, reviewer, created_at
+		FROM feedback WHERE analysis_id = ? ORDER BY id`), analysisID)
+	if err != nil {
+		return nil, fmt.Errorf("resultdb: %w", err)
+	}
+	defer rows.Close()
+	var feedback []Feedback
+	for rows.Next() {
+		var f Feedback
+		var createdAt dbTime
+		if err := rows.Scan(&f.AnalysisID, &f.Category, &f.IsSuspicious, &f.Reviewer, &createdAt); err != nil {
+			return nil, fmt.Errorf("resultdb: %w", err)
+		}
+		f.CreatedAt = createdAt.Time
+		feedback = append(feedback, f)
+	}
+	return feedback, rows.Err()
+}
@@ -0,0 +1,74 @@
+package resultdb
+
+import (
+	"context"
+	"slices"
+	"strings"
+)
+
+// Related is the stored analysis of a message related to another one.
+type Related struct {
+	Record
+	// Thread is whether the message is one of those referred to by the other one, such
+	// as an earlier message of its thread, rather than one sharing a URL with it.
+	Thread bool `json:"thread"`
+	// Feedback is the category set by the latest feedback of a reviewer, or "".
+	Feedback string `json:"feedback,omitempty"`
+}
+
+// Related returns the latest analysis of the messages with one of messageIDs, such as
+// the earlier messages of a thread, and of those with one of urls, such as the messages
+// of a campaign, newest first and at most limit of them.
+func (d *DB) Related(ctx context.Context, messageIDs, urls []string, limit int) ([]Related, error) {
+	var conditions []string
+	var args []any
+	if len(messageIDs) > 0 {
+		conditions = append(conditions, "message_id IN ("+placeholders(len(messageIDs))+")")
+		for _, id := range messageIDs {
+			args = append(args, id)
+		}
+	}
+	if len(urls) > 0 {
+		conditions = append(conditions, "id IN (SELECT analysis_id FROM indicators WHERE type = 'url' AND value IN ("+placeholders(len(urls))+"))")
+		for _, u := range urls {
+			args = append(args, u)
+		}
+	}
+	if len(conditions) == 0 {
+		return nil, nil
+	}
+	// Messages stored by reanalyze --store have several analyses, of which only the
+	// latest one is kept.
+	records, err := d.records(ctx, []string{"(" + strings.Join(conditions, " OR ") + ")"}, args, 4*limit)
+	if err != nil {
+		return nil, err
+	}
+	var related []Related
+	seen := map[string]bool{}
+	for _, r := range records {
+		if len(related) == limit {
+			break
+		}
+		if r.MessageID != "" {
+			if seen[r.MessageID] {
+				continue
+			}
+			seen[r.MessageID] = true
+		}
+		feedback, err := d.Feedback(ctx, r.ID)
+		if err != nil {
+			return nil, err
+		}
+		rel := Related{Record: r, Thread: slices.Contains(messageIDs, r.MessageID)}
+		if len(feedback) > 0 {
+			rel.Feedback = feedback[len(feedback)-1].Category
+		}
+		related = append(related, rel)
+	}
+	return related, nil
+}
+
+// placeholders returns n comma-separated placeholders.
+func placeholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
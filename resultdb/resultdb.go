@@ -0,0 +1,389 @@
+// Package resultdb persists analysis results in a local SQLite database or a shared
+// PostgreSQL database, so that past verdicts can be searched like a lightweight case store.
+//
+// Schema (version 5):
+//
+//	analyses     one row per analyzed message
+//	  id             INTEGER PRIMARY KEY
+//	  source_file    TEXT     file the message was read from, or "stdin"
+//	  message_id     TEXT     Message-ID header
+//	  subject        TEXT
+//	  from_addrs     TEXT     JSON array of From addresses
+//	  to_addrs       TEXT     JSON array of To addresses
+//	  is_suspicious  INTEGER  0 or 1 (BOOLEAN in PostgreSQL)
+//	  category       TEXT     Phishing, Spam, Safe, ...
+//	  reason         TEXT
+//	  confidence     REAL     0.0 to 1.0
+//	  model          TEXT     model that produced the judgment
+//	  analyzed_at    TEXT     RFC 3339 timestamp in UTC (TIMESTAMPTZ in PostgreSQL)
+//	  sealed         TEXT     subject, to_addrs and reason encrypted with the storage key,
+//	                          which are then empty, or "" (version 5)
+//
+//	indicators   indicators of compromise extracted from a message
+//	  analysis_id    INTEGER  references analyses(id)
+//	  type           TEXT     "url", or "sha256" for the hash of an attachment
+//	  value          TEXT
+//
+//	feedback     verdicts of reviewers on stored analyses (version 2)
+//	  id             INTEGER PRIMARY KEY
+//	  analysis_id    INTEGER  references analyses(id)
+//	  category       TEXT     category confirmed or set by the reviewer
+//	  is_suspicious  INTEGER  0 or 1 (BOOLEAN in PostgreSQL)
+//	  reviewer       TEXT
+//	  created_at     TEXT     RFC 3339 timestamp in UTC (TIMESTAMPTZ in PostgreSQL)
+//
+//	senders      history of the messages from each address (version 4)
+//	  address                TEXT     PRIMARY KEY, in lower case
+//	  messages               INTEGER  number of messages received
+//	  flagged                INTEGER  number of them judged suspicious
+//	  first_seen             TEXT     received_at of the first message
+//	  last_seen              TEXT     received_at of the latest message
+//	  last_flagged_at        TEXT     received_at of the latest suspicious message, or NULL
+//	  last_flagged_category  TEXT     its category
+//
+// The schema is created and upgraded automatically when the database is opened. SQLite
+// stores the schema version in PRAGMA user_version, PostgreSQL in a schema_migrations table.
+package resultdb
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	_ "github.com/jackc/pgx/v5/stdlib"
+	_ "modernc.org/sqlite"
+
+	"mail-analyzer/atrest"
+	"mail-analyzer/llm"
+	"mail-analyzer/sink"
+)
+
+// DB is a results database. It implements sink.Sink, so every analysis
+// delivered to it is stored.
+type DB struct {
+	db      *sql.DB
+	dialect *dialect
+	key     *atrest.Key
+}
+
+// sealedPurpose is the purpose of the sealed column for atrest.
+const sealedPurpose = "resultdb"
+
+// sealedFields are the columns of an analysis that are encrypted with the storage key.
+// The other columns are kept in the clear, since they are searched or aggregated: the
+// senders, the verdicts and the indicators.
+type sealedFields struct {
+	Subject string   `json:"subject"`
+	To      []string `json:"to"`
+	Reason  string   `json:"reason"`
+}
+
+// Record is a stored analysis.
+type Record struct {
+	ID int64 `json:"id"`
+	// UUID is the analysis_id of the result in the output and the other sinks.
+	UUID       string   `json:"uuid,omitempty"`
+	SourceFile string   `json:"source_file"`
+	MessageID  string   `json:"message_id"`
+	Subject    string   `json:"subject"`
+	From       []string `json:"from"`
+	To         []string `json:"to"`
+	URLs       []string `json:"urls"`
+	// Hashes are the SHA-256 hashes of the attachments of the message.
+	Hashes     []string     `json:"hashes,omitempty"`
+	Judgment   llm.Judgment `json:"judgment"`
+	Model      string       `json:"model"`
+	Provider   string       `json:"provider,omitempty"`
+	ReceivedAt time.Time    `json:"received_at"`
+	AnalyzedAt time.Time    `json:"analyzed_at"`
+}
+
+// Open opens or creates the SQLite database at path and applies pending migrations.
+// A new database file is only readable by the owner.
+func Open(path string) (*DB, error) {
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("could not create results database: %w", err)
+		}
+		f.Close()
+	}
+	return open(sqliteDialect, "file:"+path+"?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)")
+}
+
+// OpenPostgres connects to the PostgreSQL database at dsn and applies pending migrations.
+// dsn is a connection URL or keyword/value string as accepted by libpq.
+func OpenPostgres(dsn string) (*DB, error) {
+	return open(postgresDialect, dsn)
+}
+
+func open(d *dialect, dsn string) (*DB, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open results database: %w", err)
+	}
+	if err := d.migrate(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("could not prepare results database: %w", err)
+	}
+	return &DB{db: db, dialect: d}, nil
+}
+
+// SetKey sets the storage key with which the subjects, recipients and reasons of the
+// analyses stored from now on are encrypted, and those stored encrypted are read. The
+// analyses stored before a key was set are only read if the key allows plaintext. A nil
+// key stores them in the clear.
+func (d *DB) SetKey(key *atrest.Key) {
+	d.key = key
+}
+
+// Send implements sink.Sink by storing result and adding it to the history of its
+// senders.
+func (d *DB) Send(ctx context.Context, result *sink.Result) error {
+	_, err := d.insert(ctx, result, true)
+	return err
+}
+
+// Close implements sink.Sink.
+func (d *DB) Close() error {
+	return d.db.Close()
+}
+
+// Insert stores result and returns its ID. Unlike Send, it leaves the history of the
+// senders as is, since the analyses stored this way repeat those of messages that were
+// counted already.
+func (d *DB) Insert(ctx context.Context, result *sink.Result) (int64, error) {
+	return d.insert(ctx, result, false)
+}
+
+func (d *DB) insert(ctx context.Context, result *sink.Result, countSenders bool) (int64, error) {
+	judgment := llm.Judgment{}
+	if result.Judgment != nil {
+		judgment = *result.Judgment
+	}
+	analyzedAt := result.AnalyzedAt
+	if analyzedAt.IsZero() {
+		analyzedAt = time.Now()
+	}
+	receivedAt := result.ReceivedAt
+	if receivedAt.IsZero() {
+		receivedAt = analyzedAt
+	}
+	from, _ := json.Marshal(nonNil(result.From))
+	to, _ := json.Marshal(nonNil(result.To))
+	subject, reason, sealed := result.Subject, judgment.Reason, ""
+	if d.key != nil {
+		data, _ := json.Marshal(sealedFields{Subject: subject, To: nonNil(result.To), Reason: reason})
+		sealed = base64.StdEncoding.EncodeToString(d.key.Seal(data, sealedPurpose))
+		subject, to, reason = "", []byte("[]"), ""
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("resultdb: %w", err)
+	}
+	defer tx.Rollback()
+
+	var id int64
+	err = tx.QueryRowContext(ctx, d.dialect.rebind(`INSERT INTO analyses
+		(uuid, source_file, message_id, subject, from_addrs, to_addrs, is_suspicious, category, reason, confidence, model, provider, received_at, analyzed_at, sealed)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`),
+		result.AnalysisID, result.SourceFile, result.MessageID, subject, string(from), string(to),
+		judgment.IsSuspicious, judgment.Category, reason, judgment.ConfidenceScore,
+		result.Model, result.Provider, d.dialect.timeValue(receivedAt), d.dialect.timeValue(analyzedAt), sealed).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("resultdb: could not insert analysis: %w", err)
+	}
+	for _, indicator := range [...]struct {
+		kind   string
+		values []string
+	}{{"url", result.URLs}, {"sha256", result.Hashes}} {
+		for _, v := range indicator.values {
+			if _, err := tx.ExecContext(ctx, d.dialect.rebind(`INSERT INTO indicators (analysis_id, type, value) VALUES (?, ?, ?)`), id, indicator.kind, v); err != nil {
+				return 0, fmt.Errorf("resultdb: could not insert indicator: %w", err)
+			}
+		}
+	}
+	if countSenders {
+		if err := d.countSenders(ctx, tx, result.From, judgment.IsSuspicious, judgment.Category, receivedAt); err != nil {
+			return 0, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("resultdb: %w", err)
+	}
+	return id, nil
+}
+
+// Filter selects records in Query. Zero values match everything.
+type Filter struct {
+	Category   string
+	Suspicious *bool
+	// Since and Until bound analyzed_at.
+	Since time.Time
+	Until time.Time
+	// MinConfidence selects the judgments with at least this confidence score.
+	MinConfidence float64
+	// Sender matches From addresses containing the string, case-insensitively.
+	Sender    string
+	MessageID string
+	// UUID selects the record of the analysis with this analysis_id.
+	UUID string
+	// URL matches messages with an indicator containing the string.
+	URL string
+	// Limit caps the number of records; zero means no limit.
+	Limit int
+}
+
+// Query returns the records matching f, newest first.
+func (d *DB) Query(ctx context.Context, f Filter) ([]Record, error) {
+	var where []string
+	var args []any
+	if f.Category != "" {
+		where = append(where, "lower(category) = lower(?)")
+		args = append(args, f.Category)
+	}
+	if f.Suspicious != nil {
+		where = append(where, "is_suspicious = ?")
+		args = append(args, *f.Suspicious)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "analyzed_at >= ?")
+		args = append(args, d.dialect.timeValue(f.Since))
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "analyzed_at < ?")
+		args = append(args, d.dialect.timeValue(f.Until))
+	}
+	if f.MinConfidence > 0 {
+		where = append(where, "confidence >= ?")
+		args = append(args, f.MinConfidence)
+	}
+	if f.Sender != "" {
+		where = append(where, "lower(from_addrs) LIKE lower(?) ESCAPE '\\'")
+		args = append(args, "%"+escapeLike(f.Sender)+"%")
+	}
+	if f.MessageID != "" {
+		where = append(where, "message_id = ?")
+		args = append(args, f.MessageID)
+	}
+	if f.UUID != "" {
+		where = append(where, "uuid = ?")
+		args = append(args, f.UUID)
+	}
+	if f.URL != "" {
+		where = append(where, "id IN (SELECT analysis_id FROM indicators WHERE type = 'url' AND value LIKE ? ESCAPE '\\')")
+		args = append(args, "%"+escapeLike(f.URL)+"%")
+	}
+
+	return d.records(ctx, where, args, f.Limit)
+}
+
+// records returns the records matching the conditions of where, which are joined with
+// AND, newest first and at most limit of them unless it is zero.
+func (d *DB) records(ctx context.Context, where []string, args []any, limit int) ([]Record, error) {
+	query := `SELECT id, uuid, source_file, message_id, subject, from_addrs, to_addrs, is_suspicious, category, reason, confidence, model, provider, received_at, analyzed_at, sealed FROM analyses`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY analyzed_at DESC, id DESC"
+	if limit > 0 {
+		query += " LIMIT ?"
+		args = append(args, limit)
+	}
+
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(query), args...)
+	if err != nil {
+		return nil, fmt.Errorf("resultdb: query failed: %w", err)
+	}
+	defer rows.Close()
+
+	var records []Record
+	for rows.Next() {
+		var r Record
+		var from, to, sealed string
+		var receivedAt, analyzedAt dbTime
+		if err := rows.Scan(&r.ID, &r.UUID, &r.SourceFile, &r.MessageID, &r.Subject, &from, &to,
+			&r.Judgment.IsSuspicious, &r.Judgment.Category, &r.Judgment.Reason, &r.Judgment.ConfidenceScore,
+			&r.Model, &r.Provider, &receivedAt, &analyzedAt, &sealed); err != nil {
+			return nil, fmt.Errorf("resultdb: %w", err)
+		}
+		json.Unmarshal([]byte(from), &r.From)
+		json.Unmarshal([]byte(to), &r.To)
+		if sealed != "" {
+			if err := d.unseal(&r, sealed); err != nil {
+				return nil, err
+			}
+		} else if err := d.key.CheckPlaintext(); err != nil {
+			return nil, fmt.Errorf("resultdb: analysis %d: %w", r.ID, err)
+		}
+		r.ReceivedAt = receivedAt.Time
+		r.AnalyzedAt = analyzedAt.Time
+		records = append(records, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("resultdb: %w", err)
+	}
+
+	for i := range records {
+		if err := d.indicators(ctx, &records[i]); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// unseal sets the encrypted fields of r from its sealed column.
+func (d *DB) unseal(r *Record, sealed string) error {
+	data, err := base64.StdEncoding.DecodeString(sealed)
+	if err == nil {
+		data, err = d.key.Open(data, sealedPurpose)
+	}
+	var fields sealedFields
+	if err == nil {
+		err = json.Unmarshal(data, &fields)
+	}
+	if err != nil {
+		return fmt.Errorf("resultdb: analysis %d: %w", r.ID, err)
+	}
+	r.Subject, r.To, r.Judgment.Reason = fields.Subject, fields.To, fields.Reason
+	return nil
+}
+
+// indicators reads the URLs and hashes of r.
+func (d *DB) indicators(ctx context.Context, r *Record) error {
+	rows, err := d.db.QueryContext(ctx, d.dialect.rebind(`SELECT type, value FROM indicators WHERE analysis_id = ? ORDER BY `+d.dialect.indicatorOrder), r.ID)
+	if err != nil {
+		return fmt.Errorf("resultdb: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var kind, value string
+		if err := rows.Scan(&kind, &value); err != nil {
+			return fmt.Errorf("resultdb: %w", err)
+		}
+		switch kind {
+		case "url":
+			r.URLs = append(r.URLs, value)
+		case "sha256":
+			r.Hashes = append(r.Hashes, value)
+		}
+	}
+	return rows.Err()
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+func nonNil(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
@@ -0,0 +1,344 @@
+package resultdb
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"mail-analyzer/atrest"
+	"mail-analyzer/llm"
+	"mail-analyzer/sink"
+)
+
+func TestDB_InsertAndQuery(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("database file mode = %o, want 600", perm)
+	}
+	testInsertAndQuery(t, db)
+}
+
+// TestPostgres_InsertAndQuery runs against the database in MAIL_ANALYZER_TEST_POSTGRES_DSN.
+// The tables of the results database in it are dropped.
+func TestPostgres_InsertAndQuery(t *testing.T) {
+	dsn := os.Getenv("MAIL_ANALYZER_TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("MAIL_ANALYZER_TEST_POSTGRES_DSN not set")
+	}
+	db, err := OpenPostgres(dsn)
+	if err != nil {
+		t.Fatalf("OpenPostgres() error = %v", err)
+	}
+	defer db.Close()
+	if _, err := db.db.Exec("DROP TABLE IF EXISTS senders, feedback, indicators, analyses, schema_migrations"); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	// Opening twice must not re-apply migrations.
+	for i := 0; i < 2; i++ {
+		if db, err = OpenPostgres(dsn); err != nil {
+			t.Fatalf("OpenPostgres() error = %v", err)
+		}
+		if i == 0 {
+			db.Close()
+		}
+	}
+	testInsertAndQuery(t, db)
+}
+
+func testInsertAndQuery(t *testing.T, db *DB) {
+	t.Helper()
+	base := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	results := []*sink.Result{
+		{AnalysisID: "0b5c3e7e-6f3a-4c44-9d7e-2f6c1a9e8b01", SourceFile: "a.eml", MessageID: "<1@example.com>", Subject: "Verify", From: []string{"attacker@evil.example.com"}, URLs: []string{"http://evil.example.com/login", "http://example.com"}, Hashes: []string{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"}, Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 0.9}, Model: "gpt-4o", Provider: "openai", ReceivedAt: base.Add(-time.Minute), AnalyzedAt: base},
+		{SourceFile: "b.eml", MessageID: "<2@example.com>", Subject: "Lunch", From: []string{"colleague@example.com"}, Judgment: &llm.Judgment{Category: "Safe", ConfidenceScore: 0.8}, AnalyzedAt: base.Add(time.Hour)},
+		{SourceFile: "c.eml", MessageID: "<3@example.com>", Subject: "100% off", From: []string{"promo@shop.example.com"}, Judgment: &llm.Judgment{IsSuspicious: true, Category: "Spam", ConfidenceScore: 0.7}, AnalyzedAt: base.Add(2 * time.Hour)},
+	}
+	for _, r := range results {
+		if err := db.Send(context.Background(), r); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	yes, no := true, false
+	tests := []struct {
+		name   string
+		filter Filter
+		want   []string
+	}{
+		{name: "All, newest first", filter: Filter{}, want: []string{"<3@example.com>", "<2@example.com>", "<1@example.com>"}},
+		{name: "Category", filter: Filter{Category: "phishing"}, want: []string{"<1@example.com>"}},
+		{name: "Suspicious", filter: Filter{Suspicious: &yes}, want: []string{"<3@example.com>", "<1@example.com>"}},
+		{name: "Not suspicious", filter: Filter{Suspicious: &no}, want: []string{"<2@example.com>"}},
+		{name: "Since", filter: Filter{Since: base.Add(30 * time.Minute)}, want: []string{"<3@example.com>", "<2@example.com>"}},
+		{name: "Sender", filter: Filter{Sender: "EVIL.example"}, want: []string{"<1@example.com>"}},
+		{name: "URL", filter: Filter{URL: "evil.example.com"}, want: []string{"<1@example.com>"}},
+		{name: "Analysis ID", filter: Filter{UUID: "0b5c3e7e-6f3a-4c44-9d7e-2f6c1a9e8b01"}, want: []string{"<1@example.com>"}},
+		{name: "LIKE wildcards are literal", filter: Filter{Sender: "%"}, want: nil},
+		{name: "Limit", filter: Filter{Limit: 1}, want: []string{"<3@example.com>"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := db.Query(context.Background(), tt.filter)
+			if err != nil {
+				t.Fatalf("Query() error = %v", err)
+			}
+			var got []string
+			for _, r := range records {
+				got = append(got, r.MessageID)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Query() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	records, _ := db.Query(context.Background(), Filter{MessageID: "<1@example.com>"})
+	want := Record{
+		ID: records[0].ID, UUID: "0b5c3e7e-6f3a-4c44-9d7e-2f6c1a9e8b01", SourceFile: "a.eml", MessageID: "<1@example.com>", Subject: "Verify",
+		From: []string{"attacker@evil.example.com"}, To: []string{},
+		URLs:     []string{"http://evil.example.com/login", "http://example.com"},
+		Hashes:   []string{"9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"},
+		Judgment: llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 0.9},
+		Model:    "gpt-4o", Provider: "openai", ReceivedAt: base.Add(-time.Minute), AnalyzedAt: base,
+	}
+	if len(records) != 1 || !reflect.DeepEqual(records[0], want) {
+		t.Errorf("Query() = %+v, want %+v", records, want)
+	}
+}
+
+func TestOpen_Reopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	db.Send(context.Background(), &sink.Result{MessageID: "<1@example.com>"})
+	db.Close()
+
+	db, err = Open(path)
+	if err != nil {
+		t.Fatalf("Open() existing database error = %v", err)
+	}
+	defer db.Close()
+	records, err := db.Query(context.Background(), Filter{})
+	if err != nil || len(records) != 1 {
+		t.Errorf("Query() = %v, %v; want 1 record", records, err)
+	}
+}
+
+func TestDB_SetKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	db, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	// An analysis stored before the key was set stays readable while plaintext is allowed.
+	db.Send(ctx, &sink.Result{MessageID: "<1@example.com>", Subject: "Lunch", Judgment: &llm.Judgment{Category: "Safe"}})
+	key, err := atrest.NewKey("Zq0mWc1d8x7Yk3vB2nT5rP9sL4hJ6gF0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.SetKey(key)
+	judgment := &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Asks alice for her password."}
+	if err := db.Send(ctx, &sink.Result{MessageID: "<2@example.com>", Subject: "Password expiry", From: []string{"it@evil.example.com"}, To: []string{"alice@example.com"}, Judgment: judgment}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	var subject, to, reason string
+	if err := db.db.QueryRow(`SELECT subject, to_addrs, reason FROM analyses WHERE message_id = '<2@example.com>'`).Scan(&subject, &to, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if subject != "" || to != "[]" || reason != "" {
+		t.Errorf("stored subject %q, to %s and reason %q, want them encrypted", subject, to, reason)
+	}
+	data, _ := os.ReadFile(path)
+	if bytes.Contains(data, []byte("alice")) || bytes.Contains(data, []byte("Password expiry")) {
+		t.Error("the database file contains the recipient or the subject")
+	}
+
+	records, err := db.Query(ctx, Filter{Sender: "evil.example.com"})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Query() = %v, %v; want 1 record", records, err)
+	}
+	if r := records[0]; r.Subject != "Password expiry" || !reflect.DeepEqual(r.To, []string{"alice@example.com"}) || !reflect.DeepEqual(r.Judgment, *judgment) {
+		t.Errorf("Query() = %+v, want the decrypted subject, recipients and reason", r)
+	}
+	if _, err := db.Query(ctx, Filter{MessageID: "<1@example.com>"}); !errors.Is(err, atrest.ErrPlaintext) {
+		t.Errorf("Query() of an analysis stored in the clear error = %v, want ErrPlaintext", err)
+	}
+	key.AllowPlaintext()
+	if records, err := db.Query(ctx, Filter{MessageID: "<1@example.com>"}); err != nil || len(records) != 1 || records[0].Subject != "Lunch" {
+		t.Errorf("Query() of an analysis stored in the clear = %v, %v", records, err)
+	}
+
+	db.SetKey(nil)
+	if _, err := db.Query(ctx, Filter{}); !errors.Is(err, atrest.ErrNoKey) {
+		t.Errorf("Query() without the key error = %v, want ErrNoKey", err)
+	}
+}
+
+func TestOpen_Migrate(t *testing.T) {
+	// A database of an earlier version, without the analysis IDs and reception times.
+	path := filepath.Join(t.TempDir(), "results.sqlite")
+	earlier := *sqliteDialect
+	earlier.migrations = earlier.migrations[:2]
+	db, err := open(&earlier, "file:"+path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyzedAt := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	if _, err := db.db.Exec(`INSERT INTO analyses (source_file, message_id, subject, from_addrs, to_addrs, is_suspicious, category, reason, confidence, model, analyzed_at)
+		VALUES ('a.eml', '<1@example.com>', '', '[]', '[]', 0, 'Safe', '', 0.5, 'gpt-4o', ?)`, earlier.timeValue(analyzedAt)); err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+
+	if db, err = Open(path); err != nil {
+		t.Fatalf("Open() of an earlier database error = %v", err)
+	}
+	defer db.Close()
+	records, err := db.Query(context.Background(), Filter{})
+	if err != nil || len(records) != 1 {
+		t.Fatalf("Query() = %v, %v; want 1 record", records, err)
+	}
+	if r := records[0]; r.UUID != "" || !r.ReceivedAt.Equal(analyzedAt) {
+		t.Errorf("migrated record = %+v, want no UUID and received_at = analyzed_at", r)
+	}
+}
+
+func TestDB_Feedback(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.sqlite"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	id, err := db.Insert(ctx, &sink.Result{MessageID: "<1@example.com>", Judgment: &llm.Judgment{Category: "Safe"}})
+	if err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	want := []Feedback{
+		{AnalysisID: id, Category: "Safe", Reviewer: "alice", CreatedAt: time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)},
+		{AnalysisID: id, Category: "Phishing", IsSuspicious: true, Reviewer: "bob", CreatedAt: time.Date(2025, 7, 2, 12, 0, 0, 0, time.UTC)},
+	}
+	for _, f := range want {
+		if err := db.AddFeedback(ctx, f); err != nil {
+			t.Fatalf("AddFeedback() error = %v", err)
+		}
+	}
+	got, err := db.Feedback(ctx, id)
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Feedback() = %+v, %v; want %+v", got, err, want)
+	}
+
+	if err := db.AddFeedback(ctx, Feedback{AnalysisID: id + 1, Category: "Spam"}); err == nil {
+		t.Error("AddFeedback() for a missing analysis succeeded")
+	}
+}
+
+func TestDB_Sender(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.sqlite"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	base := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	for i, r := range []*sink.Result{
+		{From: []string{`"Billing" <Billing@Vendor.example>`}, Judgment: &llm.Judgment{Category: "Safe"}, ReceivedAt: base},
+		{From: []string{"billing@vendor.example"}, Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing"}, ReceivedAt: base.Add(time.Hour)},
+		{From: []string{"billing@vendor.example"}, Judgment: &llm.Judgment{Category: "Safe"}, ReceivedAt: base.Add(2 * time.Hour)},
+	} {
+		if err := db.Send(ctx, r); err != nil {
+			t.Fatalf("Send(%d) error = %v", i, err)
+		}
+	}
+	// Stored analyses of messages that were counted already are not counted again.
+	if _, err := db.Insert(ctx, &sink.Result{From: []string{"billing@vendor.example"}, Judgment: &llm.Judgment{IsSuspicious: true, Category: "Spam"}, ReceivedAt: base.Add(3 * time.Hour)}); err != nil {
+		t.Fatalf("Insert() error = %v", err)
+	}
+
+	got, err := db.Sender(ctx, "Billing <billing@vendor.example>")
+	want := &Sender{
+		Address:             "billing@vendor.example",
+		Messages:            3,
+		Flagged:             1,
+		FirstSeen:           base,
+		LastSeen:            base.Add(2 * time.Hour),
+		LastFlaggedAt:       base.Add(time.Hour),
+		LastFlaggedCategory: "Phishing",
+	}
+	if err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Sender() = %+v, %v; want %+v", got, err, want)
+	}
+	if got, err := db.Sender(ctx, "new@vendor.example"); got != nil || err != nil {
+		t.Errorf("Sender(unknown) = %+v, %v; want nil", got, err)
+	}
+}
+
+func TestDB_Related(t *testing.T) {
+	db, err := Open(filepath.Join(t.TempDir(), "results.sqlite"))
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+	base := time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC)
+	var ids []int64
+	for i, r := range []*sink.Result{
+		{MessageID: "1@example.com", Judgment: &llm.Judgment{Category: "Safe"}, AnalyzedAt: base},
+		{MessageID: "1@example.com", Judgment: &llm.Judgment{Category: "Spam"}, AnalyzedAt: base.Add(time.Hour)},
+		{MessageID: "2@example.com", URLs: []string{"https://evil.example/login"}, Judgment: &llm.Judgment{Category: "Phishing"}, AnalyzedAt: base.Add(2 * time.Hour)},
+		{MessageID: "3@example.com", URLs: []string{"https://evil.example/login?x"}, Judgment: &llm.Judgment{Category: "Safe"}, AnalyzedAt: base.Add(3 * time.Hour)},
+	} {
+		id, err := db.Insert(ctx, r)
+		if err != nil {
+			t.Fatalf("Insert(%d) error = %v", i, err)
+		}
+		ids = append(ids, id)
+	}
+	if err := db.AddFeedback(ctx, Feedback{AnalysisID: ids[2], Category: "Phishing", IsSuspicious: true, Reviewer: "alice"}); err != nil {
+		t.Fatal(err)
+	}
+
+	related, err := db.Related(ctx, []string{"1@example.com", "0@example.com"}, []string{"https://evil.example/login"}, 5)
+	if err != nil {
+		t.Fatalf("Related() error = %v", err)
+	}
+	var got []string
+	for _, r := range related {
+		got = append(got, fmt.Sprintf("%s %s thread=%t feedback=%s", r.MessageID, r.Judgment.Category, r.Thread, r.Feedback))
+	}
+	// URLs match exactly, and only the latest analysis of a message is returned.
+	want := []string{"2@example.com Phishing thread=false feedback=Phishing", "1@example.com Spam thread=true feedback="}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Related() = %q, want %q", got, want)
+	}
+	if related, err := db.Related(ctx, []string{"1@example.com"}, []string{"https://evil.example/login"}, 1); err != nil || len(related) != 1 {
+		t.Errorf("Related() with a limit of 1 = %+v, %v", related, err)
+	}
+	if related, err := db.Related(ctx, nil, nil, 5); related != nil || err != nil {
+		t.Errorf("Related() of nothing = %+v, %v, want nil", related, err)
+	}
+}
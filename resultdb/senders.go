@@ -0,0 +1,80 @@
+package resultdb
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/mail"
+	"strings"
+	"time"
+)
+
+// Sender is the history of the messages from an address.
+type Sender struct {
+	Address string `json:"address"`
+	// Messages is the number of messages received from the address, and Flagged the
+	// number of them that were judged suspicious.
+	Messages  int       `json:"messages"`
+	Flagged   int       `json:"flagged"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	// LastFlaggedAt and LastFlaggedCategory are the reception time and category of the
+	// latest suspicious message, if any.
+	LastFlaggedAt       time.Time `json:"last_flagged_at,omitzero"`
+	LastFlaggedCategory string    `json:"last_flagged_category,omitempty"`
+}
+
+// Sender returns the history of the address of from, which may have a display name, or
+// nil if no message from it was stored.
+func (d *DB) Sender(ctx context.Context, from string) (*Sender, error) {
+	s := Sender{Address: senderAddress(from)}
+	var firstSeen, lastSeen, lastFlaggedAt dbTime
+	err := d.db.QueryRowContext(ctx, d.dialect.rebind(`SELECT messages, flagged, first_seen, last_seen, last_flagged_at, last_flagged_category
+		FROM senders WHERE address = ?`), s.Address).Scan(&s.Messages, &s.Flagged, &firstSeen, &lastSeen, &lastFlaggedAt, &s.LastFlaggedCategory)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("resultdb: %w", err)
+	}
+	s.FirstSeen, s.LastSeen, s.LastFlaggedAt = firstSeen.Time, lastSeen.Time, lastFlaggedAt.Time
+	return &s, nil
+}
+
+// countSenders adds a message received at receivedAt, with the given verdict, to the
+// history of its senders.
+func (d *DB) countSenders(ctx context.Context, tx *sql.Tx, from []string, flagged bool, category string, receivedAt time.Time) error {
+	n, lastFlaggedAt, lastFlaggedCategory := 0, any(nil), ""
+	if flagged {
+		n, lastFlaggedAt, lastFlaggedCategory = 1, d.dialect.timeValue(receivedAt), category
+	}
+	for _, f := range from {
+		address := senderAddress(f)
+		if address == "" {
+			continue
+		}
+		_, err := tx.ExecContext(ctx, d.dialect.rebind(`INSERT INTO senders
+			(address, messages, flagged, first_seen, last_seen, last_flagged_at, last_flagged_category)
+			VALUES (?, 1, ?, ?, ?, ?, ?)
+			ON CONFLICT (address) DO UPDATE SET
+				messages = senders.messages + 1,
+				flagged = senders.flagged + excluded.flagged,
+				last_seen = excluded.last_seen,
+				last_flagged_at = COALESCE(excluded.last_flagged_at, senders.last_flagged_at),
+				last_flagged_category = CASE WHEN excluded.flagged > 0 THEN excluded.last_flagged_category ELSE senders.last_flagged_category END`),
+			address, n, d.dialect.timeValue(receivedAt), d.dialect.timeValue(receivedAt), lastFlaggedAt, lastFlaggedCategory)
+		if err != nil {
+			return fmt.Errorf("resultdb: could not update sender history: %w", err)
+		}
+	}
+	return nil
+}
+
+// senderAddress returns the address of from, in lower case, without its display name.
+func senderAddress(from string) string {
+	if addr, err := mail.ParseAddress(from); err == nil {
+		from = addr.Address
+	}
+	return strings.ToLower(strings.Trim(strings.TrimSpace(from), "<>"))
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// The scores of an rspamd reply. A suspicious message scores its confidence times
+// rspamdRequiredScore, and the action follows the default thresholds of rspamd, so that
+// MTAs configured for rspamd act on the verdict without changes.
+const (
+	rspamdRequiredScore  = 15
+	rspamdAddHeaderScore = 6
+)
+
+// rspamdReply is the reply of rspamd to /checkv2, with the fields that MTAs and the
+// rspamd proxy use.
+type rspamdReply struct {
+	IsSkipped     bool                    `json:"is_skipped"`
+	Score         float64                 `json:"score"`
+	RequiredScore float64                 `json:"required_score"`
+	Action        string                  `json:"action"`
+	Symbols       map[string]rspamdSymbol `json:"symbols"`
+	MessageID     string                  `json:"message-id,omitempty"`
+	Milter        rspamdMilter            `json:"milter"`
+}
+
+type rspamdSymbol struct {
+	Name        string  `json:"name"`
+	Score       float64 `json:"score"`
+	MetricScore float64 `json:"metric_score"`
+	Description string  `json:"description,omitempty"`
+}
+
+// rspamdMilter lists the changes of the headers that the MTA applies to the message: the
+// verdict headers, after the removal of forged ones.
+type rspamdMilter struct {
+	AddHeaders    map[string][]rspamdHeader `json:"add_headers"`
+	RemoveHeaders map[string]int            `json:"remove_headers"`
+}
+
+type rspamdHeader struct {
+	Value string `json:"value"`
+	Order int    `json:"order"`
+}
+
+// newRspamdReply returns the rspamd reply for result. The verdict is a symbol named
+// after its category, such as MAIL_ANALYZER_PHISHING.
+func newRspamdReply(result *AnalysisResult) *rspamdReply {
+	reply := &rspamdReply{
+		RequiredScore: rspamdRequiredScore,
+		Action:        "no action",
+		Symbols:       map[string]rspamdSymbol{},
+		MessageID:     result.MessageID,
+		Milter: rspamdMilter{
+			AddHeaders:    map[string][]rspamdHeader{},
+			RemoveHeaders: map[string]int{},
+		},
+	}
+	if j := result.Judgment; j != nil {
+		if j.IsSuspicious {
+			reply.Score = j.ConfidenceScore * rspamdRequiredScore
+		}
+		name := rspamdSymbolName(j.Category)
+		reply.Symbols[name] = rspamdSymbol{Name: name, Score: reply.Score, MetricScore: reply.Score, Description: j.Reason}
+	}
+	switch {
+	case reply.Score >= rspamdRequiredScore:
+		reply.Action = "reject"
+	case reply.Score >= rspamdAddHeaderScore:
+		reply.Action = "add header"
+	}
+	for _, h := range annotationHeaders(result) {
+		// 0 removes every header of the name.
+		reply.Milter.RemoveHeaders[h.Name] = 0
+		reply.Milter.AddHeaders[h.Name] = append(reply.Milter.AddHeaders[h.Name], rspamdHeader{Value: h.EncodedValue()})
+	}
+	return reply
+}
+
+// rspamdSymbolName returns the symbol of category: MAIL_ANALYZER_ and the category in
+// upper case, with underscores for the characters other than letters and digits.
+func rspamdSymbolName(category string) string {
+	return "MAIL_ANALYZER_" + strings.Map(func(r rune) rune {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			return unicode.ToUpper(r)
+		}
+		return '_'
+	}, category)
+}
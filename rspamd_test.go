@@ -0,0 +1,65 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"mail-analyzer/llm"
+)
+
+func TestNewRspamdReply(t *testing.T) {
+	tests := []struct {
+		name       string
+		judgment   *llm.Judgment
+		wantScore  float64
+		wantAction string
+		wantSymbol string
+	}{
+		{"Confident phishing", &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 1}, 15, "reject", "MAIL_ANALYZER_PHISHING"},
+		{"Likely spam", &llm.Judgment{IsSuspicious: true, Category: "Spam", Reason: "Bulk.", ConfidenceScore: 0.6}, 9, "add header", "MAIL_ANALYZER_SPAM"},
+		{"Doubtful scam", &llm.Judgment{IsSuspicious: true, Category: "Gift-card Scam", Reason: "Odd.", ConfidenceScore: 0.2}, 3, "no action", "MAIL_ANALYZER_GIFT_CARD_SCAM"},
+		{"Safe", &llm.Judgment{Category: "Safe", Reason: "Newsletter.", ConfidenceScore: 0.9}, 0, "no action", "MAIL_ANALYZER_SAFE"},
+		{"No judgment", nil, 0, "no action", ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reply := newRspamdReply(&AnalysisResult{AnalysisID: "id", MessageID: "1@example.com", Judgment: tt.judgment})
+			if reply.Score != tt.wantScore || reply.Action != tt.wantAction || reply.RequiredScore != 15 || reply.MessageID != "1@example.com" {
+				t.Errorf("newRspamdReply() = score %v, action %q, required %v, message ID %q, want %v, %q", reply.Score, reply.Action, reply.RequiredScore, reply.MessageID, tt.wantScore, tt.wantAction)
+			}
+			if tt.wantSymbol == "" {
+				if len(reply.Symbols) != 0 {
+					t.Errorf("Symbols = %v, want none", reply.Symbols)
+				}
+				return
+			}
+			want := map[string]rspamdSymbol{tt.wantSymbol: {Name: tt.wantSymbol, Score: tt.wantScore, MetricScore: tt.wantScore, Description: tt.judgment.Reason}}
+			if !reflect.DeepEqual(reply.Symbols, want) {
+				t.Errorf("Symbols = %v, want %v", reply.Symbols, want)
+			}
+		})
+	}
+}
+
+func TestNewRspamdReply_Milter(t *testing.T) {
+	reply := newRspamdReply(&AnalysisResult{
+		AnalysisID: "id",
+		Judgment:   &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "偽のログイン", ConfidenceScore: 0.9},
+		URLs:       []string{"https://a.example", "https://b.example"},
+	})
+	milter := reply.Milter
+	if got := milter.AddHeaders["X-Mail-Analyzer-Category"]; !reflect.DeepEqual(got, []rspamdHeader{{Value: "Phishing"}}) {
+		t.Errorf("X-Mail-Analyzer-Category = %v", got)
+	}
+	if got := milter.AddHeaders["X-Mail-Analyzer-Reason"]; len(got) != 1 || got[0].Value != "=?utf-8?q?=E5=81=BD=E3=81=AE=E3=83=AD=E3=82=B0=E3=82=A4=E3=83=B3?=" {
+		t.Errorf("X-Mail-Analyzer-Reason = %v, want it RFC 2047-encoded", got)
+	}
+	if got := milter.AddHeaders["X-Mail-Analyzer-URL"]; len(got) != 2 {
+		t.Errorf("X-Mail-Analyzer-URL = %v, want 2 headers", got)
+	}
+	for name := range milter.AddHeaders {
+		if n, ok := milter.RemoveHeaders[name]; !ok || n != 0 {
+			t.Errorf("RemoveHeaders[%q] = %d, %v, want the forged headers removed", name, n, ok)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sort"
+)
+
+// sampleFiles returns a random subset of files, in their original order: n files if n is
+// positive, or else each file with probability rate. The same seed gives the same subset.
+func sampleFiles(files []string, n int, rate float64, seed uint64) []string {
+	rng := rand.New(rand.NewPCG(seed, seed))
+	var picked []int
+	switch {
+	case n > 0 && n >= len(files):
+		return files
+	case n > 0:
+		picked = rng.Perm(len(files))[:n]
+		slices.Sort(picked)
+	default:
+		for i := range files {
+			if rng.Float64() < rate {
+				picked = append(picked, i)
+			}
+		}
+	}
+	sample := make([]string, len(picked))
+	for i, j := range picked {
+		sample[i] = files[j]
+	}
+	return sample
+}
+
+// z95 is the quantile of the standard normal distribution for 95% confidence intervals.
+const z95 = 1.959964
+
+// wilsonInterval returns the 95% Wilson score interval of a proportion of k in n.
+func wilsonInterval(k, n int) (low, high float64) {
+	if n == 0 {
+		return 0, 1
+	}
+	p, nf := float64(k)/float64(n), float64(n)
+	denominator := 1 + z95*z95/nf
+	center := (p + z95*z95/(2*nf)) / denominator
+	margin := z95 * math.Sqrt(p*(1-p)/nf+z95*z95/(4*nf*nf)) / denominator
+	return max(0, center-margin), min(1, center+margin)
+}
+
+// writePrevalence estimates the share of each category among population messages from
+// the categories of a sample of them.
+func writePrevalence(w io.Writer, categories map[string]int, population int) {
+	analyzed := 0
+	var names []string
+	for name, k := range categories {
+		analyzed += k
+		names = append(names, name)
+	}
+	if analyzed == 0 {
+		return
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if categories[names[i]] != categories[names[j]] {
+			return categories[names[i]] > categories[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	fmt.Fprintf(w, "Estimated prevalence among %d messages, from %d analyzed (95%% confidence):\n", population, analyzed)
+	for _, name := range names {
+		k := categories[name]
+		low, high := wilsonInterval(k, analyzed)
+		share := float64(k) / float64(analyzed)
+		fmt.Fprintf(w, "  %-10s %5.1f%% (%.1f%% to %.1f%%), about %d messages\n",
+			name, 100*share, 100*low, 100*high, int(math.Round(share*float64(population))))
+	}
+}
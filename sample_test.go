@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+func TestSampleFiles(t *testing.T) {
+	var files []string
+	for i := range 1000 {
+		files = append(files, fmt.Sprintf("%04d.eml", i))
+	}
+
+	sample := sampleFiles(files, 50, 0, 42)
+	if len(sample) != 50 || !slices.IsSorted(sample) {
+		t.Errorf("sampleFiles(50) = %d files, sorted %t", len(sample), slices.IsSorted(sample))
+	}
+	if again := sampleFiles(files, 50, 0, 42); !reflect.DeepEqual(again, sample) {
+		t.Error("the same seed gave a different sample")
+	}
+	if other := sampleFiles(files, 50, 0, 43); reflect.DeepEqual(other, sample) {
+		t.Error("another seed gave the same sample")
+	}
+	if all := sampleFiles(files, 5000, 0, 42); len(all) != len(files) {
+		t.Errorf("sampleFiles(5000) = %d files, want all %d", len(all), len(files))
+	}
+
+	sample = sampleFiles(files, 0, 0.1, 7)
+	if len(sample) < 70 || len(sample) > 130 || !slices.IsSorted(sample) {
+		t.Errorf("sampleFiles(rate 0.1) = %d files, want about 100 in order", len(sample))
+	}
+	if again := sampleFiles(files, 0, 0.1, 7); !reflect.DeepEqual(again, sample) {
+		t.Error("the same seed gave a different sample")
+	}
+}
+
+func TestWilsonInterval(t *testing.T) {
+	tests := []struct {
+		k, n      int
+		low, high float64
+	}{
+		{10, 100, 0.0552, 0.1744},
+		{0, 50, 0, 0.0714},
+		{50, 50, 0.9286, 1},
+	}
+	for _, tt := range tests {
+		low, high := wilsonInterval(tt.k, tt.n)
+		if math.Abs(low-tt.low) > 1e-4 || math.Abs(high-tt.high) > 1e-4 {
+			t.Errorf("wilsonInterval(%d, %d) = %.4f, %.4f, want %.4f, %.4f", tt.k, tt.n, low, high, tt.low, tt.high)
+		}
+	}
+}
+
+func TestWritePrevalence(t *testing.T) {
+	var buf bytes.Buffer
+	writePrevalence(&buf, map[string]int{"Safe": 90, "Phishing": 10}, 10000)
+	want := "Estimated prevalence among 10000 messages, from 100 analyzed (95% confidence):\n" +
+		"  Safe        90.0% (82.6% to 94.5%), about 9000 messages\n" +
+		"  Phishing    10.0% (5.5% to 17.4%), about 1000 messages\n"
+	if got := buf.String(); got != want {
+		t.Errorf("writePrevalence() = %q, want %q", got, want)
+	}
+	buf.Reset()
+	if writePrevalence(&buf, map[string]int{}, 10); buf.Len() != 0 {
+		t.Errorf("writePrevalence() without results = %q", buf.String())
+	}
+}
@@ -0,0 +1,136 @@
+package sandbox
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"mail-analyzer/email"
+)
+
+// CAPE is the REST API (apiv2) of a CAPE sandbox, which scores files from 0 to 10.
+type CAPE struct {
+	Client *http.Client
+	URL    string
+	// APIKey is the token of the API, if it requires authentication.
+	APIKey string
+}
+
+// capeResponse is the envelope of the responses of the API.
+type capeResponse[T any] struct {
+	Error      bool   `json:"error"`
+	ErrorValue string `json:"error_value"`
+	Data       T      `json:"data"`
+}
+
+type capeTask struct {
+	ID     int    `json:"id"`
+	Status string `json:"status"`
+}
+
+// Lookup implements Service.
+func (c *CAPE) Lookup(ctx context.Context, sha256 string) (*Report, error) {
+	var resp capeResponse[[]capeTask]
+	if err := c.do(ctx, http.MethodGet, "/apiv2/tasks/search/sha256/"+sha256+"/", nil, "", &resp); err != nil {
+		return nil, err
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	latest := -1
+	for _, task := range resp.Data {
+		if task.Status == "reported" && task.ID > latest {
+			latest = task.ID
+		}
+	}
+	if latest < 0 {
+		return nil, nil
+	}
+	return c.report(ctx, latest)
+}
+
+// Submit implements Service.
+func (c *CAPE) Submit(ctx context.Context, file *email.File) (string, error) {
+	body, contentType := formBody(nil, "file", file)
+	var resp capeResponse[struct {
+		TaskIDs []int `json:"task_ids"`
+	}]
+	if err := c.do(ctx, http.MethodPost, "/apiv2/tasks/create/file/", body, contentType, &resp); err != nil {
+		return "", err
+	}
+	if err := resp.err(); err != nil {
+		return "", err
+	}
+	if len(resp.Data.TaskIDs) == 0 {
+		return "", fmt.Errorf("cape: no task created for %s", file.Filename)
+	}
+	return strconv.Itoa(resp.Data.TaskIDs[0]), nil
+}
+
+// Poll implements Service.
+func (c *CAPE) Poll(ctx context.Context, id string) (*Report, error) {
+	var resp capeResponse[capeTask]
+	if err := c.do(ctx, http.MethodGet, "/apiv2/tasks/view/"+id+"/", nil, "", &resp); err != nil {
+		return nil, err
+	}
+	if err := resp.err(); err != nil {
+		return nil, err
+	}
+	switch status := resp.Data.Status; {
+	case status == "reported":
+		taskID, _ := strconv.Atoi(id)
+		return c.report(ctx, taskID)
+	case strings.HasPrefix(status, "failed"):
+		return nil, fmt.Errorf("cape: task %s %s", id, strings.ReplaceAll(status, "_", " "))
+	}
+	return nil, nil
+}
+
+// report returns the report of the task id.
+func (c *CAPE) report(ctx context.Context, id int) (*Report, error) {
+	var report struct {
+		MalScore  float64 `json:"malscore"`
+		MalStatus string  `json:"malstatus"`
+	}
+	if err := c.do(ctx, http.MethodGet, fmt.Sprintf("/apiv2/tasks/get/report/%d/", id), nil, "", &report); err != nil {
+		return nil, err
+	}
+	return &Report{
+		Score:   min(report.MalScore/10, 1),
+		Verdict: strings.ToLower(report.MalStatus),
+		URL:     fmt.Sprintf("%s/analysis/%d/", strings.TrimRight(c.URL, "/"), id),
+	}, nil
+}
+
+func (c *CAPE) do(ctx context.Context, method, path string, body io.Reader, contentType string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(c.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if c.APIKey != "" {
+		req.Header.Set("Authorization", "Token "+c.APIKey)
+	}
+	found, err := doJSON(c.Client, req, v)
+	if err != nil {
+		return fmt.Errorf("cape: %w", err)
+	}
+	if !found {
+		return fmt.Errorf("cape: %s %s: not found", method, path)
+	}
+	return nil
+}
+
+// err returns the error reported in r, if any.
+func (r *capeResponse[T]) err() error {
+	if !r.Error {
+		return nil
+	}
+	return fmt.Errorf("cape: %s", cmp.Or(r.ErrorValue, "request failed"))
+}
@@ -0,0 +1,110 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"mail-analyzer/email"
+)
+
+// DefaultHybridAnalysisURL is the API of Hybrid Analysis.
+const DefaultHybridAnalysisURL = "https://hybrid-analysis.com"
+
+// hybridAnalysisEnvironment is the environment that submitted files are run in:
+// Windows 10 64-bit.
+const hybridAnalysisEnvironment = "160"
+
+// HybridAnalysis is the API (v2) of Hybrid Analysis and of Falcon Sandbox, which score
+// files from 0 to 100.
+type HybridAnalysis struct {
+	Client *http.Client
+	URL    string
+	APIKey string
+}
+
+// hybridAnalysisSummary is the verdict of the analyses of a file or of a job.
+type hybridAnalysisSummary struct {
+	SHA256      string `json:"sha256"`
+	Verdict     string `json:"verdict"`
+	ThreatScore int    `json:"threat_score"`
+}
+
+// Lookup implements Service.
+func (h *HybridAnalysis) Lookup(ctx context.Context, sha256 string) (*Report, error) {
+	var overview hybridAnalysisSummary
+	found, err := h.do(ctx, http.MethodGet, "/api/v2/overview/"+sha256, nil, "", &overview)
+	if err != nil || !found {
+		return nil, err
+	}
+	if overview.SHA256 == "" {
+		overview.SHA256 = sha256
+	}
+	return h.report(&overview), nil
+}
+
+// Submit implements Service.
+func (h *HybridAnalysis) Submit(ctx context.Context, file *email.File) (string, error) {
+	body, contentType := formBody(map[string]string{"environment_id": hybridAnalysisEnvironment}, "file", file)
+	var resp struct {
+		JobID string `json:"job_id"`
+	}
+	if _, err := h.do(ctx, http.MethodPost, "/api/v2/submit/file", body, contentType, &resp); err != nil {
+		return "", err
+	}
+	return resp.JobID, nil
+}
+
+// Poll implements Service.
+func (h *HybridAnalysis) Poll(ctx context.Context, id string) (*Report, error) {
+	var state struct {
+		State        string `json:"state"`
+		ErrorMessage string `json:"error"`
+	}
+	if _, err := h.do(ctx, http.MethodGet, "/api/v2/report/"+id+"/state", nil, "", &state); err != nil {
+		return nil, err
+	}
+	switch state.State {
+	case "SUCCESS":
+	case "ERROR":
+		return nil, fmt.Errorf("hybrid_analysis: job %s failed: %s", id, state.ErrorMessage)
+	default:
+		return nil, nil
+	}
+	var summary hybridAnalysisSummary
+	if _, err := h.do(ctx, http.MethodGet, "/api/v2/report/"+id+"/summary", nil, "", &summary); err != nil {
+		return nil, err
+	}
+	return h.report(&summary), nil
+}
+
+func (h *HybridAnalysis) report(s *hybridAnalysisSummary) *Report {
+	return &Report{
+		Score:   min(float64(s.ThreatScore)/100, 1),
+		Verdict: s.Verdict,
+		URL:     strings.TrimRight(h.URL, "/") + "/sample/" + s.SHA256,
+	}
+}
+
+func (h *HybridAnalysis) do(ctx context.Context, method, path string, body io.Reader, contentType string, v any) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, method, strings.TrimRight(h.URL, "/")+path, body)
+	if err != nil {
+		return false, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("api-key", h.APIKey)
+	// The API rejects the default user agents of HTTP libraries.
+	req.Header.Set("User-Agent", "Falcon Sandbox")
+	found, err := doJSON(h.Client, req, v)
+	if err != nil {
+		return false, fmt.Errorf("hybrid_analysis: %w", err)
+	}
+	if !found && method == http.MethodPost {
+		return false, fmt.Errorf("hybrid_analysis: %s %s: not found", method, path)
+	}
+	return found, nil
+}
@@ -0,0 +1,118 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"mail-analyzer/email"
+)
+
+// DefaultJoeURL is the API of Joe Sandbox Cloud.
+const DefaultJoeURL = "https://jbxcloud.joesecurity.org"
+
+// Joe is the API (v2) of Joe Sandbox, which scores files from 0 to 100.
+type Joe struct {
+	Client *http.Client
+	URL    string
+	APIKey string
+}
+
+// joeAnalysis is an analysis of a file, as described by analysis/info and in the
+// submission/info of a finished submission.
+type joeAnalysis struct {
+	WebID     string `json:"webid"`
+	Status    string `json:"status"`
+	Detection string `json:"detection"`
+	Score     int    `json:"score"`
+}
+
+// Lookup implements Service.
+func (j *Joe) Lookup(ctx context.Context, sha256 string) (*Report, error) {
+	var search struct {
+		Data []joeAnalysis `json:"data"`
+	}
+	if err := j.post(ctx, "/api/v2/analysis/search", url.Values{"q": {sha256}}, &search); err != nil {
+		return nil, err
+	}
+	for _, a := range search.Data {
+		var info struct {
+			Data joeAnalysis `json:"data"`
+		}
+		if err := j.post(ctx, "/api/v2/analysis/info", url.Values{"webid": {a.WebID}}, &info); err != nil {
+			return nil, err
+		}
+		if info.Data.Status == "finished" {
+			return j.report(&info.Data), nil
+		}
+	}
+	return nil, nil
+}
+
+// Submit implements Service.
+func (j *Joe) Submit(ctx context.Context, file *email.File) (string, error) {
+	// Joe Sandbox Cloud requires the acceptance of its terms and conditions.
+	body, contentType := formBody(map[string]string{"apikey": j.APIKey, "accept-tac": "1"}, "sample", file)
+	var resp struct {
+		Data struct {
+			SubmissionID string `json:"submission_id"`
+		} `json:"data"`
+	}
+	if err := j.do(ctx, "/api/v2/submission/new", body, contentType, &resp); err != nil {
+		return "", err
+	}
+	return resp.Data.SubmissionID, nil
+}
+
+// Poll implements Service.
+func (j *Joe) Poll(ctx context.Context, id string) (*Report, error) {
+	var resp struct {
+		Data struct {
+			Status               string       `json:"status"`
+			MostRelevantAnalysis *joeAnalysis `json:"most_relevant_analysis"`
+		} `json:"data"`
+	}
+	if err := j.post(ctx, "/api/v2/submission/info", url.Values{"submission_id": {id}}, &resp); err != nil {
+		return nil, err
+	}
+	if resp.Data.Status != "finished" {
+		return nil, nil
+	}
+	if resp.Data.MostRelevantAnalysis == nil {
+		return nil, fmt.Errorf("joe: submission %s finished without an analysis", id)
+	}
+	return j.report(resp.Data.MostRelevantAnalysis), nil
+}
+
+func (j *Joe) report(a *joeAnalysis) *Report {
+	return &Report{
+		Score:   min(float64(a.Score)/100, 1),
+		Verdict: a.Detection,
+		URL:     strings.TrimRight(j.URL, "/") + "/analysis/" + a.WebID + "/0/html",
+	}
+}
+
+// post sends the form fields, with the API key, to path.
+func (j *Joe) post(ctx context.Context, path string, fields url.Values, v any) error {
+	fields.Set("apikey", j.APIKey)
+	return j.do(ctx, path, strings.NewReader(fields.Encode()), "application/x-www-form-urlencoded", v)
+}
+
+func (j *Joe) do(ctx context.Context, path string, body io.Reader, contentType string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(j.URL, "/")+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	found, err := doJSON(j.Client, req, v)
+	if err != nil {
+		return fmt.Errorf("joe: %w", err)
+	}
+	if !found {
+		return fmt.Errorf("joe: POST %s: not found", path)
+	}
+	return nil
+}
@@ -0,0 +1,258 @@
+// Package sandbox detonates the attachments of messages in an external malware sandbox,
+// such as CAPE, Joe Sandbox or Hybrid Analysis, and merges its verdicts into the
+// judgment of the message.
+package sandbox
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/email"
+	"mail-analyzer/httpclient"
+	"mail-analyzer/llm"
+)
+
+// MaxFileSize is the size of the largest attachment that is checked.
+const MaxFileSize = 32 * 1024 * 1024
+
+// Service is the API of a sandbox.
+type Service interface {
+	// Lookup returns the report of the latest analysis of the file with the SHA-256 hash
+	// sha256, or nil if the sandbox has not analyzed it.
+	Lookup(ctx context.Context, sha256 string) (*Report, error)
+	// Submit uploads a file to be analyzed and returns the ID of the analysis.
+	Submit(ctx context.Context, file *email.File) (string, error)
+	// Poll returns the report of the analysis id, or nil while it is running.
+	Poll(ctx context.Context, id string) (*Report, error)
+}
+
+// Report is the verdict of a sandbox about a file.
+type Report struct {
+	// Score is the score of the sandbox, scaled from 0 (clean) to 1 (malicious).
+	Score float64
+	// Verdict is the verdict of the sandbox, such as malicious, suspicious or clean, if
+	// it has one.
+	Verdict string
+	// URL is the page of the analysis in the sandbox, if known.
+	URL string
+}
+
+// Result is the outcome of the check of an attachment.
+type Result struct {
+	Filename string `json:"filename"`
+	SHA256   string `json:"sha256"`
+	// Verdict is the verdict of the sandbox, or "unknown" if it has not analyzed the
+	// file and the file was not submitted.
+	Verdict   string  `json:"verdict,omitempty"`
+	Score     float64 `json:"score"`
+	ReportURL string  `json:"report_url,omitempty"`
+	// Error is set when the file could not be checked, or its analysis did not end in
+	// time.
+	Error string `json:"error,omitempty"`
+}
+
+// Detonator checks the attachments of messages with a sandbox.
+type Detonator struct {
+	Service Service
+	// Submit uploads the files that the sandbox has not analyzed yet. Otherwise, only
+	// their hashes are looked up, and no file leaves the organization.
+	Submit bool
+	// Timeout bounds the check of the attachments of a message, including the wait for
+	// the analyses, which are polled every PollInterval.
+	Timeout      time.Duration
+	PollInterval time.Duration
+	// MinScore is the score from which a file makes its message suspicious.
+	MinScore float64
+}
+
+// New creates the Detonator of the sandbox_* settings of cfg, or returns nil if there is
+// no sandbox.
+func New(cfg *config.Config) (*Detonator, error) {
+	if cfg.SandboxType == "" {
+		return nil, nil
+	}
+	client, err := httpclient.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	var service Service
+	switch cfg.SandboxType {
+	case config.SandboxCAPE:
+		if cfg.SandboxURL == "" {
+			return nil, fmt.Errorf("the %s sandbox requires sandbox_url", cfg.SandboxType)
+		}
+		service = &CAPE{Client: client, URL: cfg.SandboxURL, APIKey: cfg.SandboxAPIKey}
+	case config.SandboxJoe:
+		service = &Joe{Client: client, URL: cmp.Or(cfg.SandboxURL, DefaultJoeURL), APIKey: cfg.SandboxAPIKey}
+	case config.SandboxHybridAnalysis:
+		service = &HybridAnalysis{Client: client, URL: cmp.Or(cfg.SandboxURL, DefaultHybridAnalysisURL), APIKey: cfg.SandboxAPIKey}
+	default:
+		return nil, fmt.Errorf("unknown sandbox_type %q", cfg.SandboxType)
+	}
+	return &Detonator{
+		Service:      service,
+		Submit:       cfg.SandboxSubmit,
+		Timeout:      time.Duration(cfg.SandboxTimeout),
+		PollInterval: time.Duration(cfg.SandboxPollInterval),
+		MinScore:     cfg.SandboxMinScore,
+	}, nil
+}
+
+// Start checks the attachments of the message raw in the background, and returns a
+// function that waits for their results. A nil Detonator checks nothing.
+func (d *Detonator) Start(ctx context.Context, raw []byte) (wait func() []Result) {
+	if d == nil {
+		return func() []Result { return nil }
+	}
+	done := make(chan []Result, 1)
+	go func() {
+		files, err := email.ReadAttachments(raw, MaxFileSize)
+		if err != nil {
+			log.Printf("Warning: could not read the attachments to check in the sandbox: %v", err)
+		}
+		if len(files) == 0 {
+			done <- nil
+			return
+		}
+		done <- d.Check(ctx, files)
+	}()
+	return func() []Result { return <-done }
+}
+
+// Check checks files concurrently and returns their results, in order. A file that
+// cannot be checked does not stop the others, and its result records the error.
+func (d *Detonator) Check(ctx context.Context, files []email.File) []Result {
+	if d.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, d.Timeout)
+		defer cancel()
+	}
+	results := make([]Result, len(files))
+	var wg sync.WaitGroup
+	for i := range files {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i] = d.check(ctx, &files[i])
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func (d *Detonator) check(ctx context.Context, file *email.File) Result {
+	result := Result{Filename: file.Filename, SHA256: file.SHA256}
+	report, err := d.Service.Lookup(ctx, file.SHA256)
+	if err == nil && report == nil && d.Submit {
+		report, err = d.detonate(ctx, file)
+	}
+	switch {
+	case err != nil:
+		result.Error = err.Error()
+	case report == nil:
+		result.Verdict = "unknown"
+	default:
+		result.Verdict = report.Verdict
+		result.Score = report.Score
+		result.ReportURL = report.URL
+	}
+	return result
+}
+
+// detonate submits file and waits for its report.
+func (d *Detonator) detonate(ctx context.Context, file *email.File) (*Report, error) {
+	id, err := d.Service.Submit(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+	ticker := time.NewTicker(d.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no verdict of analysis %s: %w", id, ctx.Err())
+		case <-ticker.C:
+		}
+		report, err := d.Service.Poll(ctx, id)
+		if err != nil || report != nil {
+			return report, err
+		}
+	}
+}
+
+// Merge returns j with the results of the files that scored at least MinScore: the
+// message is suspicious, of the Malware category unless j found it suspicious already,
+// with at least the highest of their scores as confidence. j is returned as is if no
+// file scored MinScore, or the Detonator is nil.
+func (d *Detonator) Merge(j *llm.Judgment, results []Result) *llm.Judgment {
+	if d == nil {
+		return j
+	}
+	var worst *Result
+	for i := range results {
+		if r := &results[i]; r.Error == "" && r.Score >= d.MinScore && (worst == nil || r.Score > worst.Score) {
+			worst = r
+		}
+	}
+	if worst == nil || j == nil {
+		return j
+	}
+	merged := *j
+	if !merged.IsSuspicious {
+		merged.IsSuspicious = true
+		merged.Category = "Malware"
+	}
+	merged.ConfidenceScore = max(merged.ConfidenceScore, worst.Score)
+	merged.Reason = strings.TrimSpace(fmt.Sprintf("%s (The sandbox rated the attachment %s malicious, with a score of %.2f.)", j.Reason, worst.Filename, worst.Score))
+	return &merged
+}
+
+// doJSON sends req and decodes its JSON response into v. It reports whether the
+// resource exists: a 404 response is not an error.
+func doJSON(client *http.Client, req *http.Request, v any) (bool, error) {
+	req.Header.Set("Accept", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return false, fmt.Errorf("%s %s: status %s", req.Method, req.URL.Path, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("%s %s: %w", req.Method, req.URL.Path, err)
+	}
+	return true, nil
+}
+
+// formBody returns a multipart/form-data body with fields, and file as the field named
+// fileField, and its content type.
+func formBody(fields map[string]string, fileField string, file *email.File) (io.Reader, string) {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for name, value := range fields {
+		w.WriteField(name, value)
+	}
+	filename := file.Filename
+	if filename == "" {
+		filename = file.SHA256
+	}
+	part, _ := w.CreateFormFile(fileField, filename)
+	part.Write(file.Data)
+	w.Close()
+	return &body, w.FormDataContentType()
+}
@@ -0,0 +1,244 @@
+package sandbox
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// fakeService knows the reports of some hashes, and finishes the analysis of a submitted
+// file at its polls-th poll, with score.
+type fakeService struct {
+	mu      sync.Mutex
+	known   map[string]*Report
+	score   float64
+	polls   int
+	submits []string
+}
+
+func (s *fakeService) Lookup(ctx context.Context, sha256 string) (*Report, error) {
+	if sha256 == "broken" {
+		return nil, fmt.Errorf("lookup failed")
+	}
+	return s.known[sha256], nil
+}
+
+func (s *fakeService) Submit(ctx context.Context, file *email.File) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.submits = append(s.submits, file.Filename)
+	return file.SHA256, nil
+}
+
+func (s *fakeService) Poll(ctx context.Context, id string) (*Report, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.polls--; s.polls > 0 {
+		return nil, nil
+	}
+	return &Report{Score: s.score, Verdict: "malicious", URL: "https://sandbox.example/" + id}, nil
+}
+
+func TestDetonator_Check(t *testing.T) {
+	files := []email.File{
+		{Filename: "invoice.pdf", SHA256: "known"},
+		{Filename: "payload.exe", SHA256: "new"},
+		{Filename: "broken.doc", SHA256: "broken"},
+	}
+	known := map[string]*Report{"known": {Score: 0.1, Verdict: "clean"}}
+
+	t.Run("Lookup only", func(t *testing.T) {
+		service := &fakeService{known: known}
+		d := &Detonator{Service: service, Timeout: time.Second, PollInterval: time.Millisecond}
+		want := []Result{
+			{Filename: "invoice.pdf", SHA256: "known", Verdict: "clean", Score: 0.1},
+			{Filename: "payload.exe", SHA256: "new", Verdict: "unknown"},
+			{Filename: "broken.doc", SHA256: "broken", Error: "lookup failed"},
+		}
+		if got := d.Check(context.Background(), files); !reflect.DeepEqual(got, want) {
+			t.Errorf("Check() = %+v, want %+v", got, want)
+		}
+		if len(service.submits) != 0 {
+			t.Errorf("submitted %v without Submit", service.submits)
+		}
+	})
+
+	t.Run("Submit", func(t *testing.T) {
+		service := &fakeService{known: known, score: 0.9, polls: 3}
+		d := &Detonator{Service: service, Submit: true, Timeout: time.Second, PollInterval: time.Millisecond}
+		got := d.Check(context.Background(), files)
+		want := Result{Filename: "payload.exe", SHA256: "new", Verdict: "malicious", Score: 0.9, ReportURL: "https://sandbox.example/new"}
+		if got[1] != want {
+			t.Errorf("Check()[1] = %+v, want %+v", got[1], want)
+		}
+		if !reflect.DeepEqual(service.submits, []string{"payload.exe"}) {
+			t.Errorf("submitted %v, want only payload.exe", service.submits)
+		}
+	})
+
+	t.Run("Timeout", func(t *testing.T) {
+		service := &fakeService{known: known, polls: 1 << 30}
+		d := &Detonator{Service: service, Submit: true, Timeout: 20 * time.Millisecond, PollInterval: time.Millisecond}
+		got := d.Check(context.Background(), files[1:2])
+		if !strings.Contains(got[0].Error, "no verdict") {
+			t.Errorf("Check() = %+v, want a timeout error", got[0])
+		}
+	})
+}
+
+func TestDetonator_Merge(t *testing.T) {
+	d := &Detonator{MinScore: 0.7}
+	clean := &llm.Judgment{IsSuspicious: false, Category: "Safe", Reason: "A newsletter.", ConfidenceScore: 0.8}
+	results := []Result{
+		{Filename: "a.pdf", Score: 0.2},
+		{Filename: "b.exe", Score: 0.95},
+		{Filename: "c.exe", Score: 1, Error: "timeout"},
+	}
+
+	got := d.Merge(clean, results)
+	want := &llm.Judgment{IsSuspicious: true, Category: "Malware", ConfidenceScore: 0.95,
+		Reason: "A newsletter. (The sandbox rated the attachment b.exe malicious, with a score of 0.95.)"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Merge() = %+v, want %+v", got, want)
+	}
+	if clean.IsSuspicious {
+		t.Error("Merge() modified its judgment")
+	}
+
+	phishing := &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "A fake login.", ConfidenceScore: 0.99}
+	if got := d.Merge(phishing, results); got.Category != "Phishing" || got.ConfidenceScore != 0.99 {
+		t.Errorf("Merge(phishing) = %+v, want Phishing with confidence 0.99", got)
+	}
+	if got := d.Merge(clean, results[:1]); got != clean {
+		t.Errorf("Merge(low scores) = %+v, want the judgment as is", got)
+	}
+}
+
+// serve returns a server that answers the requests for the paths of responses, and 404
+// for any other.
+func serve(t *testing.T, check func(*http.Request), responses map[string]string) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		check(r)
+		body, ok := responses[r.Method+" "+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestCAPE(t *testing.T) {
+	srv := serve(t, func(r *http.Request) {
+		if got := r.Header.Get("Authorization"); got != "Token key" {
+			t.Errorf("Authorization = %q", got)
+		}
+	}, map[string]string{
+		"GET /apiv2/tasks/search/sha256/abc/": `{"error": false, "data": [{"id": 3, "status": "reported"}, {"id": 7, "status": "reported"}, {"id": 9, "status": "running"}]}`,
+		"GET /apiv2/tasks/search/sha256/new/": `{"error": false, "data": []}`,
+		"GET /apiv2/tasks/get/report/7/":      `{"malscore": 8.5, "malstatus": "Malicious"}`,
+		"POST /apiv2/tasks/create/file/":      `{"error": false, "data": {"task_ids": [12]}}`,
+		"GET /apiv2/tasks/view/12/":           `{"error": false, "data": {"id": 12, "status": "running"}}`,
+		"GET /apiv2/tasks/view/13/":           `{"error": false, "data": {"id": 13, "status": "failed_analysis"}}`,
+	})
+	c := &CAPE{Client: srv.Client(), URL: srv.URL, APIKey: "key"}
+	ctx := context.Background()
+
+	report, err := c.Lookup(ctx, "abc")
+	want := &Report{Score: 0.85, Verdict: "malicious", URL: srv.URL + "/analysis/7/"}
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Errorf("Lookup() = %+v, %v, want %+v", report, err, want)
+	}
+	if report, err := c.Lookup(ctx, "new"); report != nil || err != nil {
+		t.Errorf("Lookup(new) = %+v, %v, want nil", report, err)
+	}
+	if id, err := c.Submit(ctx, &email.File{Filename: "a.exe", Data: []byte("MZ")}); id != "12" || err != nil {
+		t.Errorf("Submit() = %q, %v, want 12", id, err)
+	}
+	if report, err := c.Poll(ctx, "12"); report != nil || err != nil {
+		t.Errorf("Poll(running) = %+v, %v, want nil", report, err)
+	}
+	if _, err := c.Poll(ctx, "13"); err == nil {
+		t.Error("Poll(failed) succeeded")
+	}
+}
+
+func TestJoe(t *testing.T) {
+	srv := serve(t, func(r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("method = %s, want POST", r.Method)
+		}
+		if got := r.FormValue("apikey"); got != "key" {
+			t.Errorf("apikey = %q", got)
+		}
+	}, map[string]string{
+		"POST /api/v2/analysis/search": `{"data": [{"webid": "101"}]}`,
+		"POST /api/v2/analysis/info":   `{"data": {"webid": "101", "status": "finished", "detection": "malicious", "score": 92}}`,
+		"POST /api/v2/submission/new":  `{"data": {"submission_id": "55"}}`,
+		"POST /api/v2/submission/info": `{"data": {"status": "finished", "most_relevant_analysis": {"webid": "102", "detection": "clean", "score": 5}}}`,
+	})
+	j := &Joe{Client: srv.Client(), URL: srv.URL, APIKey: "key"}
+	ctx := context.Background()
+
+	report, err := j.Lookup(ctx, "abc")
+	want := &Report{Score: 0.92, Verdict: "malicious", URL: srv.URL + "/analysis/101/0/html"}
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Errorf("Lookup() = %+v, %v, want %+v", report, err, want)
+	}
+	if id, err := j.Submit(ctx, &email.File{Filename: "a.exe", Data: []byte("MZ")}); id != "55" || err != nil {
+		t.Errorf("Submit() = %q, %v, want 55", id, err)
+	}
+	report, err = j.Poll(ctx, "55")
+	want = &Report{Score: 0.05, Verdict: "clean", URL: srv.URL + "/analysis/102/0/html"}
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Errorf("Poll() = %+v, %v, want %+v", report, err, want)
+	}
+}
+
+func TestHybridAnalysis(t *testing.T) {
+	srv := serve(t, func(r *http.Request) {
+		if got := r.Header.Get("api-key"); got != "key" {
+			t.Errorf("api-key = %q", got)
+		}
+	}, map[string]string{
+		"GET /api/v2/overview/abc":      `{"sha256": "abc", "verdict": "malicious", "threat_score": 100}`,
+		"POST /api/v2/submit/file":      `{"job_id": "j1", "sha256": "def"}`,
+		"GET /api/v2/report/j1/state":   `{"state": "SUCCESS"}`,
+		"GET /api/v2/report/j1/summary": `{"sha256": "def", "verdict": "no specific threat", "threat_score": 10}`,
+		"GET /api/v2/report/j2/state":   `{"state": "IN_PROGRESS"}`,
+	})
+	h := &HybridAnalysis{Client: srv.Client(), URL: srv.URL, APIKey: "key"}
+	ctx := context.Background()
+
+	report, err := h.Lookup(ctx, "abc")
+	want := &Report{Score: 1, Verdict: "malicious", URL: srv.URL + "/sample/abc"}
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Errorf("Lookup() = %+v, %v, want %+v", report, err, want)
+	}
+	if report, err := h.Lookup(ctx, "new"); report != nil || err != nil {
+		t.Errorf("Lookup(new) = %+v, %v, want nil", report, err)
+	}
+	if id, err := h.Submit(ctx, &email.File{Filename: "a.exe", Data: []byte("MZ")}); id != "j1" || err != nil {
+		t.Errorf("Submit() = %q, %v, want j1", id, err)
+	}
+	report, err = h.Poll(ctx, "j1")
+	want = &Report{Score: 0.1, Verdict: "no specific threat", URL: srv.URL + "/sample/def"}
+	if err != nil || !reflect.DeepEqual(report, want) {
+		t.Errorf("Poll() = %+v, %v, want %+v", report, err, want)
+	}
+	if report, err := h.Poll(ctx, "j2"); report != nil || err != nil {
+		t.Errorf("Poll(in progress) = %+v, %v, want nil", report, err)
+	}
+}
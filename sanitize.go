@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"mail-analyzer/email"
+)
+
+// runSanitize implements the "sanitize" subcommand, which masks the details of the
+// recipients of a message so that it can be shared.
+func runSanitize(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := newFlagSet("sanitize", "[file.eml]",
+		"Write the message, read from the file or standard input, to standard output with the\n"+
+			"addresses and names of its recipients and its account and card numbers masked, so that\n"+
+			"a suspicious sample can be shared with a vendor or attached to a public bug report. The\n"+
+			"structure of the message, its other header fields, URLs and attachments are kept.")
+	var masks []string
+	fs.Func("mask", "Also mask this `string`, such as the name of a colleague mentioned in the message; may be repeated", func(value string) error {
+		masks = append(masks, value)
+		return nil
+	})
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageErrorf("too many arguments")
+	}
+
+	var raw []byte
+	var err error
+	if fs.NArg() == 0 {
+		if raw, err = io.ReadAll(stdin); err != nil {
+			return fmt.Errorf("error reading from stdin: %w", err)
+		}
+	} else if raw, err = os.ReadFile(fs.Arg(0)); err != nil {
+		return fmt.Errorf("error reading eml file: %w", err)
+	}
+	_, err = stdout.Write(email.Sanitize(raw, masks))
+	return err
+}
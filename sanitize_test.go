@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunSanitize(t *testing.T) {
+	raw := "To: Alice <alice@corp.example>\r\nSubject: Hi\r\n\r\nHi Alice, Dave says your account is 12345678.\r\n"
+	path := filepath.Join(t.TempDir(), "sample.eml")
+	if err := os.WriteFile(path, []byte(raw), 0600); err != nil {
+		t.Fatal(err)
+	}
+	want := "To: \"Recipient\" <recipient@corp.example>\r\nSubject: Hi\r\n\r\nHi Recipient, [redacted] says your account is XXXXXXXX.\r\n"
+
+	var out bytes.Buffer
+	if err := runSanitize([]string{"--mask", "Dave", path}, nil, &out); err != nil {
+		t.Fatalf("runSanitize() error = %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("runSanitize() = %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	if err := runSanitize([]string{"--mask", "Dave"}, strings.NewReader(raw), &out); err != nil {
+		t.Fatalf("runSanitize() error = %v", err)
+	}
+	if out.String() != want {
+		t.Errorf("runSanitize() from stdin = %q, want %q", out.String(), want)
+	}
+
+	if err := runSanitize([]string{path, path}, nil, &out); err == nil {
+		t.Error("expected an error for two files")
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// SARIF 2.1.0 document types, limited to the properties emitted by the sarif format.
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+type sarifRun struct {
+	Tool    sarifTool     `json:"tool"`
+	Results []sarifResult `json:"results"`
+}
+
+type sarifTool struct {
+	Driver sarifDriver `json:"driver"`
+}
+
+type sarifDriver struct {
+	Name           string      `json:"name"`
+	Version        string      `json:"version,omitempty"`
+	InformationURI string      `json:"informationUri,omitempty"`
+	Rules          []sarifRule `json:"rules"`
+}
+
+type sarifRule struct {
+	ID               string       `json:"id"`
+	Name             string       `json:"name"`
+	ShortDescription sarifMessage `json:"shortDescription"`
+}
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifResult struct {
+	RuleID              string            `json:"ruleId"`
+	RuleIndex           int               `json:"ruleIndex"`
+	Level               string            `json:"level"`
+	Message             sarifMessage      `json:"message"`
+	Locations           []sarifLocation   `json:"locations"`
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"`
+	Properties          map[string]any    `json:"properties,omitempty"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+}
+
+type sarifArtifactLocation struct {
+	URI string `json:"uri"`
+}
+
+type sarifLogicalLocation struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+const sarifSchema = "https://json.schemastore.org/sarif-2.1.0.json"
+
+// sarifWriter collects suspicious messages as SARIF results. Messages judged safe
+// are not findings and are left out. Each category becomes a rule.
+type sarifWriter struct {
+	w          io.Writer
+	sourceFile string
+	rules      []sarifRule
+	results    []sarifResult
+}
+
+func (s *sarifWriter) Write(result *AnalysisResult) error {
+	j := result.Judgment
+	if j == nil || !j.IsSuspicious {
+		return nil
+	}
+
+	category := j.Category
+	if category == "" {
+		category = "Suspicious"
+	}
+	ruleIndex := s.ruleIndex(category)
+
+	location := sarifLocation{PhysicalLocation: sarifPhysicalLocation{ArtifactLocation: sarifArtifactLocation{URI: resultSource(result, s.sourceFile)}}}
+	if result.MessageID != "" {
+		location.LogicalLocations = []sarifLogicalLocation{{Name: result.MessageID, Kind: "message"}}
+	}
+	r := sarifResult{
+		RuleID:    s.rules[ruleIndex].ID,
+		RuleIndex: ruleIndex,
+		Level:     sarifLevel(j.ConfidenceScore),
+		Message:   sarifMessage{Text: fmt.Sprintf("%s: %s", result.Subject, j.Reason)},
+		Locations: []sarifLocation{location},
+		Properties: map[string]any{
+			"confidence": j.ConfidenceScore,
+			"from":       result.From,
+			"subject":    result.Subject,
+		},
+	}
+	if result.MessageID != "" {
+		r.PartialFingerprints = map[string]string{"messageId/v1": result.MessageID}
+	}
+	s.results = append(s.results, r)
+	return nil
+}
+
+// ruleIndex returns the index of the rule for category, adding it if needed.
+func (s *sarifWriter) ruleIndex(category string) int {
+	id := "mail-analyzer/" + strings.ToLower(strings.ReplaceAll(category, " ", "-"))
+	for i, rule := range s.rules {
+		if rule.ID == id {
+			return i
+		}
+	}
+	s.rules = append(s.rules, sarifRule{
+		ID:               id,
+		Name:             category,
+		ShortDescription: sarifMessage{Text: fmt.Sprintf("Email classified as %s", category)},
+	})
+	return len(s.rules) - 1
+}
+
+// sarifLevel maps a confidence score to a SARIF result level.
+func sarifLevel(confidence float64) string {
+	switch {
+	case confidence >= 0.8:
+		return "error"
+	case confidence >= 0.5:
+		return "warning"
+	default:
+		return "note"
+	}
+}
+
+func (s *sarifWriter) Close() error {
+	doc := sarifLog{
+		Schema:  sarifSchema,
+		Version: "2.1.0",
+		Runs: []sarifRun{{
+			Tool:    sarifTool{Driver: sarifDriver{Name: "mail-analyzer", Version: version, Rules: s.rules}},
+			Results: s.results,
+		}},
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling SARIF: %w", err)
+	}
+	_, err = fmt.Fprintln(s.w, string(out))
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"mail-analyzer/llm"
+)
+
+func TestSARIFWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newResultWriter(FormatSARIF, &buf, "mail.eml")
+	if err != nil {
+		t.Fatalf("newResultWriter() error = %v", err)
+	}
+	w.Write(&AnalysisResult{MessageID: "<1@example.com>", Subject: "Verify now", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 0.9}})
+	w.Write(&AnalysisResult{MessageID: "<2@example.com>", Subject: "Lunch", Judgment: &llm.Judgment{Category: "Safe", ConfidenceScore: 0.9}})
+	w.Write(&AnalysisResult{MessageID: "<3@example.com>", Subject: "Sale", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Spam", ConfidenceScore: 0.6}})
+	w.Write(&AnalysisResult{MessageID: "<4@example.com>", Subject: "Reset", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.3}})
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	var got sarifLog
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("invalid SARIF output: %v", err)
+	}
+	if got.Version != "2.1.0" || len(got.Runs) != 1 {
+		t.Fatalf("unexpected SARIF log: %+v", got)
+	}
+	run := got.Runs[0]
+	if len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].ID != "mail-analyzer/phishing" || run.Tool.Driver.Rules[1].ID != "mail-analyzer/spam" {
+		t.Errorf("unexpected rules: %+v", run.Tool.Driver.Rules)
+	}
+
+	wantResults := []struct{ ruleID, level, messageID string }{
+		{"mail-analyzer/phishing", "error", "<1@example.com>"},
+		{"mail-analyzer/spam", "warning", "<3@example.com>"},
+		{"mail-analyzer/phishing", "note", "<4@example.com>"},
+	}
+	if len(run.Results) != len(wantResults) {
+		t.Fatalf("expected %d results, got %d", len(wantResults), len(run.Results))
+	}
+	for i, want := range wantResults {
+		r := run.Results[i]
+		if r.RuleID != want.ruleID || r.Level != want.level || r.PartialFingerprints["messageId/v1"] != want.messageID {
+			t.Errorf("result %d = %+v, want %+v", i, r, want)
+		}
+		if r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "mail.eml" {
+			t.Errorf("result %d location = %+v", i, r.Locations)
+		}
+	}
+}
+
+func TestSARIFWriter_NoFindings(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := newResultWriter(FormatSARIF, &buf, "mail.eml")
+	w.Close()
+	if !bytes.Contains(buf.Bytes(), []byte(`"results": []`)) {
+		t.Errorf("expected an empty results array, got %s", buf.String())
+	}
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+// OutputSchemaVersion is the version of output.schema.json, written to the
+// schema_version field of the JSON output. The minor version is increased for
+// backward-compatible additions and the major version for breaking changes.
+const OutputSchemaVersion = "1.13"
+
+//go:embed output.schema.json
+var outputSchema []byte
+
+var compileOutputSchema = sync.OnceValues(func() (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	if err := compiler.AddResource("output.schema.json", bytes.NewReader(outputSchema)); err != nil {
+		return nil, err
+	}
+	return compiler.Compile("output.schema.json")
+})
+
+// validateOutput checks a JSON output document against the output schema.
+func validateOutput(doc []byte) error {
+	schema, err := compileOutputSchema()
+	if err != nil {
+		return fmt.Errorf("invalid output schema: %w", err)
+	}
+	var v any
+	if err := json.Unmarshal(doc, &v); err != nil {
+		return err
+	}
+	if err := schema.Validate(v); err != nil {
+		return fmt.Errorf("output does not match schema version %s: %w", OutputSchemaVersion, err)
+	}
+	return nil
+}
+
+// runSchema implements the "schema" subcommand, which prints the JSON Schema of the
+// --output-format json document.
+func runSchema(args []string, stdout io.Writer) error {
+	fs := newFlagSet("schema", "", "Print the JSON Schema of the --output-format json document.")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+	_, err := stdout.Write(outputSchema)
+	return err
+}
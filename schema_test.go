@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/dkim"
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+	"mail-analyzer/plugin"
+	"mail-analyzer/sandbox"
+)
+
+func TestValidateOutput(t *testing.T) {
+	var buf bytes.Buffer
+	w, _ := newResultWriter(FormatJSON, &buf, "mail.eml")
+	w.(*jsonWriter).validate = true
+	w.Write(&AnalysisResult{
+		AnalysisID: newAnalysisID(),
+		MessageID:  "<1@example.com>",
+		Subject:    "Hello",
+		From:       []string{`"Sender" <sender@example.com>`},
+		Judgment:   &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login.", ConfidenceScore: 0.9},
+		Model:      "gpt-4o",
+		Provider:   "openai",
+		ReceivedAt: time.Now().UTC(),
+		AnalyzedAt: time.Now().UTC(),
+		Plugins: []plugin.Verdict{
+			{Name: "ml", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Classified as Phishing by ml.", ConfidenceScore: 0.7}},
+			{Name: "rules", Error: "exit status 1"},
+		},
+		Sandbox: []sandbox.Result{
+			{Filename: "invoice.exe", SHA256: "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08", Verdict: "malicious", Score: 0.95, ReportURL: "https://cape.example.com/analysis/7/"},
+			{Filename: "notes.pdf", SHA256: "60303ae22b998861bce3b28f33eec1be758a213c86c93c076dbe9f558c11c752", Error: "no verdict of analysis 8: context deadline exceeded"},
+		},
+		SampleIntegrity: &dkim.Integrity{Status: dkim.StatusBodyModified, Signatures: []dkim.Signature{
+			{Domain: "example.com", Selector: "mail", Result: dkim.ResultFail, BodyHash: "mismatch", Reason: "the body hash does not match"},
+		}},
+		SubjectObfuscation: email.SubjectObfuscation("𝐏𝐚𝐲𝐏𝐚𝐥 Аccount"),
+		DuplicateOf:        "reported/1.eml",
+		Warnings:           []string{"kept only the first 1000 distinct URLs"},
+		URLs:               []string{"http://evil.example.com"},
+	})
+	summary := newSummaryBuilder()
+	summary.add(&AnalysisResult{From: []string{"sender@example.com"}, Judgment: &llm.Judgment{Category: "Safe"}, URLs: []string{"https://example.com/"}})
+	summary.fail()
+	summary.setUsage(llm.UsageStats{Requests: 1, PromptTokens: 1200, CachedTokens: 1024, CompletionTokens: 80})
+	w.(summaryWriter).SetSummary(summary.build())
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `"schema_version": "`+OutputSchemaVersion+`"`) {
+		t.Errorf("output has no schema_version: %s", buf.String())
+	}
+
+	invalid := []string{
+		`{"source_file": "mail.eml", "analysis_results": []}`,
+		`{"schema_version": "1.0", "source_file": "mail.eml", "analysis_results": [{"message_id": "", "subject": "", "from": null, "to": null, "judgment": {"is_suspicious": true, "category": "Spam", "reason": "", "confidence_score": 2}}]}`,
+		`{"schema_version": "1.0", "source_file": "mail.eml", "analysis_results": [], "extra": true}`,
+	}
+	for _, doc := range invalid {
+		if err := validateOutput([]byte(doc)); err == nil {
+			t.Errorf("validateOutput(%s) = nil, want an error", doc)
+		}
+	}
+}
+
+func TestRunSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := runSchema(nil, &buf); err != nil {
+		t.Fatalf("runSchema() error = %v", err)
+	}
+	var schema map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	if schema["$id"] != "urn:mail-analyzer:output:"+OutputSchemaVersion {
+		t.Errorf("schema $id = %v, want version %s", schema["$id"], OutputSchemaVersion)
+	}
+}
+
+func TestOutputSchema_Judgment(t *testing.T) {
+	// The judgment of the output is the one reported by the model, whose schema is
+	// generated from llm.Judgment.
+	var schema struct {
+		Defs struct {
+			Judgment struct {
+				Required   []string `json:"required"`
+				Properties map[string]struct {
+					Type string `json:"type"`
+				} `json:"properties"`
+			} `json:"judgment"`
+		} `json:"$defs"`
+	}
+	if err := json.Unmarshal(outputSchema, &schema); err != nil {
+		t.Fatal(err)
+	}
+	want := llm.SchemaFor[llm.Judgment]()
+	got := schema.Defs.Judgment
+	if !slices.Equal(got.Required, want.Required) || len(got.Properties) != len(want.Properties) {
+		t.Errorf("judgment of output.schema.json has %v of %v, want %v", got.Required, got.Properties, want.Required)
+	}
+	for name, p := range want.Properties {
+		if got.Properties[name].Type != p.Type {
+			t.Errorf("type of judgment.%s in output.schema.json = %q, want %q", name, got.Properties[name].Type, p.Type)
+		}
+	}
+}
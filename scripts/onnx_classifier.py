@@ -0,0 +1,51 @@
+#!/usr/bin/env python3
+"""Fallback classifier for mail-analyzer using a local ONNX text classification model.
+
+Reads the analysis prompt on stdin and writes a JSON judgment to stdout:
+
+    {"category": "Phishing", "confidence_score": 0.82}
+
+Usage:
+    onnx_classifier.py MODEL.onnx TOKENIZER.json LABEL [LABEL ...]
+
+LABELs name the model's output classes in order and must be one of
+Phishing, Spam or Safe. Requires the onnxruntime, tokenizers and numpy packages.
+"""
+
+import json
+import sys
+
+import numpy as np
+import onnxruntime as ort
+from tokenizers import Tokenizer
+
+MAX_TOKENS = 512
+
+
+def main():
+    if len(sys.argv) < 4:
+        sys.exit(__doc__)
+    model_path, tokenizer_path, labels = sys.argv[1], sys.argv[2], sys.argv[3:]
+
+    tokenizer = Tokenizer.from_file(tokenizer_path)
+    tokenizer.enable_truncation(MAX_TOKENS)
+    encoding = tokenizer.encode(sys.stdin.read())
+
+    session = ort.InferenceSession(model_path, providers=["CPUExecutionProvider"])
+    feeds = {
+        "input_ids": np.array([encoding.ids], dtype=np.int64),
+        "attention_mask": np.array([encoding.attention_mask], dtype=np.int64),
+    }
+    # Only pass the inputs the model declares (some models also take token_type_ids).
+    if "token_type_ids" in {i.name for i in session.get_inputs()}:
+        feeds["token_type_ids"] = np.array([encoding.type_ids], dtype=np.int64)
+    logits = session.run(None, feeds)[0][0]
+
+    probs = np.exp(logits - logits.max())
+    probs /= probs.sum()
+    best = int(probs.argmax())
+    json.dump({"category": labels[best], "confidence_score": float(probs[best])}, sys.stdout)
+
+
+if __name__ == "__main__":
+    main()
@@ -0,0 +1,221 @@
+package secret
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// imdsTimeout bounds the requests to the EC2 instance metadata service, which does not
+// answer outside EC2.
+const imdsTimeout = 2 * time.Second
+
+// awsCredentials are the credentials requests to AWS are signed with. Temporary ones
+// expire.
+type awsCredentials struct {
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	SessionToken    string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+// fetchAWS returns the value of the secret secretID, a name or an ARN, from AWS Secrets
+// Manager.
+func (m *Manager) fetchAWS(ctx context.Context, secretID string) (string, error) {
+	region := cmp.Or(m.opts.AWSRegion, os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"))
+	if arn := strings.Split(secretID, ":"); len(arn) > 3 && arn[0] == "arn" {
+		region = arn[3]
+	}
+	if region == "" {
+		return "", errors.New("aws_region is not set")
+	}
+	creds, err := m.awsCredentials(ctx)
+	if err != nil {
+		return "", fmt.Errorf("no AWS credentials: %w", err)
+	}
+
+	endpoint := cmp.Or(m.opts.AWSEndpoint, os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER"), "https://secretsmanager."+region+".amazonaws.com")
+	body, err := json.Marshal(map[string]string{"SecretId": secretID})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimRight(endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	signV4(req, body, creds, region, "secretsmanager", time.Now())
+	var resp struct {
+		SecretString *string
+		SecretBinary []byte
+	}
+	if err := m.getJSON(req, &resp); err != nil {
+		return "", err
+	}
+	if resp.SecretString != nil {
+		return *resp.SecretString, nil
+	}
+	return string(resp.SecretBinary), nil
+}
+
+// awsCredentials returns the credentials of the environment variables, of the ECS task
+// role, or of the EC2 instance role, in that order, like the AWS SDKs.
+func (m *Manager) awsCredentials(ctx context.Context) (awsCredentials, error) {
+	if id, key := os.Getenv("AWS_ACCESS_KEY_ID"), os.Getenv("AWS_SECRET_ACCESS_KEY"); id != "" && key != "" {
+		return awsCredentials{AccessKeyID: id, SecretAccessKey: key, SessionToken: os.Getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	if m.aws.AccessKeyID != "" && time.Until(m.aws.Expiration) > 5*time.Minute {
+		return m.aws, nil
+	}
+
+	var creds awsCredentials
+	var err error
+	if uri, fullURI := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"), os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI"); uri != "" || fullURI != "" {
+		if fullURI == "" {
+			fullURI = "http://169.254.170.2" + uri
+		}
+		creds, err = m.containerCredentials(ctx, fullURI)
+	} else {
+		creds, err = m.instanceCredentials(ctx)
+	}
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	m.aws = creds
+	return creds, nil
+}
+
+func (m *Manager) containerCredentials(ctx context.Context, uri string) (awsCredentials, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	if token := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN"); token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	var creds awsCredentials
+	err = m.getJSON(req, &creds)
+	return creds, err
+}
+
+// instanceCredentials returns the credentials of the role of the EC2 instance, with
+// version 2 of the instance metadata service.
+func (m *Manager) instanceCredentials(ctx context.Context) (awsCredentials, error) {
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	endpoint := strings.TrimRight(cmp.Or(os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT"), "http://169.254.169.254"), "/")
+	imds := func(method, path string, header http.Header) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, method, endpoint+path, nil)
+		if err == nil && header != nil {
+			req.Header = header
+		}
+		return req, err
+	}
+
+	req, err := imds(http.MethodPut, "/latest/api/token", http.Header{"X-Aws-Ec2-Metadata-Token-Ttl-Seconds": {"300"}})
+	if err != nil {
+		return awsCredentials{}, err
+	}
+	token, err := m.getText(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance metadata service: %w", err)
+	}
+	header := http.Header{"X-Aws-Ec2-Metadata-Token": {token}}
+	if req, err = imds(http.MethodGet, "/latest/meta-data/iam/security-credentials/", header); err != nil {
+		return awsCredentials{}, err
+	}
+	role, err := m.getText(req)
+	if err != nil {
+		return awsCredentials{}, fmt.Errorf("instance role: %w", err)
+	}
+	role, _, _ = strings.Cut(role, "\n")
+	if req, err = imds(http.MethodGet, "/latest/meta-data/iam/security-credentials/"+role, header); err != nil {
+		return awsCredentials{}, err
+	}
+	var creds awsCredentials
+	err = m.getJSON(req, &creds)
+	return creds, err
+}
+
+// getText sends req and returns its text response.
+func (m *Manager) getText(req *http.Request) (string, error) {
+	resp, err := m.opts.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<16))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// signV4 signs req, whose body is body, with Signature Version 4. The host, the
+// Content-Type and the X-Amz-* headers are signed.
+func signV4(req *http.Request, body []byte, creds awsCredentials, region, service string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	req.Header.Set("X-Amz-Date", amzDate)
+	if creds.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", creds.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		name = strings.ToLower(name)
+		if name == "content-type" || strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	canonicalRequest := strings.Join([]string{req.Method, path, req.URL.RawQuery, canonicalHeaders.String(), signedHeaders, hexSHA256(body)}, "\n")
+
+	scope := amzDate[:8] + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hexSHA256([]byte(canonicalRequest))
+	key := []byte("AWS4" + creds.SecretAccessKey)
+	for _, part := range []string{amzDate[:8], region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+creds.AccessKeyID+"/"+scope+", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+func hexSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
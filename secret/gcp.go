@@ -0,0 +1,68 @@
+package secret
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// fetchGCP returns the value of the secret version name, such as
+// projects/my-project/secrets/openai-key, from GCP Secret Manager. The latest version is
+// used unless name ends with /versions/N.
+func (m *Manager) fetchGCP(ctx context.Context, name string) (string, error) {
+	parts := strings.Split(strings.Trim(name, "/"), "/")
+	switch {
+	case len(parts) == 4 && parts[0] == "projects" && parts[2] == "secrets":
+		name += "/versions/latest"
+	case len(parts) == 6 && parts[0] == "projects" && parts[2] == "secrets" && parts[4] == "versions":
+	default:
+		return "", errors.New("the secret must be projects/PROJECT/secrets/SECRET, optionally followed by /versions/VERSION")
+	}
+	token, err := m.gcpToken(ctx)
+	if err != nil {
+		return "", fmt.Errorf("no GCP credentials: %w", err)
+	}
+
+	endpoint := strings.TrimRight(cmp.Or(m.opts.GCPEndpoint, "https://secretmanager.googleapis.com"), "/")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/v1/"+strings.Trim(name, "/")+":access", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		Payload struct {
+			Data []byte `json:"data"`
+		} `json:"payload"`
+	}
+	if err := m.getJSON(req, &resp); err != nil {
+		return "", err
+	}
+	return string(resp.Payload.Data), nil
+}
+
+// gcpToken returns the access token of GOOGLE_OAUTH_ACCESS_TOKEN or, on Google Cloud, of
+// the service account of the instance, from the metadata server.
+func (m *Manager) gcpToken(ctx context.Context) (string, error) {
+	if token := os.Getenv("GOOGLE_OAUTH_ACCESS_TOKEN"); token != "" {
+		return token, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, imdsTimeout)
+	defer cancel()
+	host := cmp.Or(os.Getenv("GCE_METADATA_HOST"), "metadata.google.internal")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+host+"/computeMetadata/v1/instance/service-accounts/default/token", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := m.getJSON(req, &resp); err != nil {
+		return "", fmt.Errorf("metadata server: %w", err)
+	}
+	return resp.AccessToken, nil
+}
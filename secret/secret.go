@@ -0,0 +1,220 @@
+// Package secret fetches secrets from secret managers: HashiCorp Vault, AWS Secrets
+// Manager and GCP Secret Manager. Secrets are cached for a while, and fetched again after
+// that or when a service rejects them, so that rotated secrets are picked up.
+package secret
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultRefreshInterval is how long a secret is cached when Options.RefreshInterval is 0.
+const DefaultRefreshInterval = 5 * time.Minute
+
+// The prefixes of references to secrets.
+const (
+	prefixVault = "vault://"
+	prefixAWS   = "aws-sm://"
+	prefixGCP   = "gcp-sm://"
+)
+
+// IsReference reports whether value refers to a secret in a secret manager, such as
+// "vault://secret/data/mail-analyzer#openai_api_key".
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, prefixVault) || strings.HasPrefix(value, prefixAWS) || strings.HasPrefix(value, prefixGCP)
+}
+
+// Options configures a Manager.
+type Options struct {
+	// Client is used for every request to the secret managers.
+	Client *http.Client
+	// RefreshInterval is how long a secret is used before it is fetched again.
+	RefreshInterval time.Duration
+
+	// VaultAddr is the address of the Vault server, such as https://vault.example.com:8200.
+	VaultAddr string
+	// VaultToken authenticates to Vault. If it is empty, the token is read from
+	// VaultTokenFile, which a Vault agent may rewrite at any time, or from ~/.vault-token.
+	VaultToken     string
+	VaultTokenFile string
+	VaultNamespace string
+
+	// AWSRegion is the region of the secrets in AWS Secrets Manager, unless given by ARN.
+	AWSRegion string
+	// AWSEndpoint and GCPEndpoint replace the public endpoints of the cloud secret
+	// managers, for private endpoints.
+	AWSEndpoint string
+	GCPEndpoint string
+}
+
+// Manager fetches and caches secrets.
+type Manager struct {
+	opts  Options
+	mu    sync.Mutex
+	cache map[string]cachedSecret
+	aws   awsCredentials
+}
+
+// cachedSecret is a fetched secret document, before a field is selected from it.
+type cachedSecret struct {
+	value   string
+	expires time.Time
+}
+
+// NewManager returns a Manager.
+func NewManager(opts Options) *Manager {
+	if opts.Client == nil {
+		opts.Client = http.DefaultClient
+	}
+	if opts.RefreshInterval <= 0 {
+		opts.RefreshInterval = DefaultRefreshInterval
+	}
+	return &Manager{opts: opts, cache: map[string]cachedSecret{}}
+}
+
+// Get returns the secret ref refers to, from the cache if it was fetched recently.
+func (m *Manager) Get(ctx context.Context, ref string) (string, error) {
+	return m.get(ctx, ref, false)
+}
+
+// Refresh fetches the secret ref refers to again, for when it was rejected.
+func (m *Manager) Refresh(ctx context.Context, ref string) (string, error) {
+	return m.get(ctx, ref, true)
+}
+
+func (m *Manager) get(ctx context.Context, ref string, refresh bool) (string, error) {
+	location, field, _ := strings.Cut(ref, "#")
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cached, ok := m.cache[location]
+	if !ok || refresh || time.Now().After(cached.expires) {
+		value, ttl, err := m.fetch(ctx, location)
+		if err != nil {
+			return "", fmt.Errorf("%s: %w", location, err)
+		}
+		if ttl <= 0 || ttl > m.opts.RefreshInterval {
+			ttl = m.opts.RefreshInterval
+		}
+		cached = cachedSecret{value, time.Now().Add(ttl)}
+		m.cache[location] = cached
+	}
+	value, err := selectField(cached.value, field)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+	return value, nil
+}
+
+// fetch returns the secret document at location and, if the secret manager tells it, how
+// long it is valid.
+func (m *Manager) fetch(ctx context.Context, location string) (string, time.Duration, error) {
+	switch {
+	case strings.HasPrefix(location, prefixVault):
+		return m.fetchVault(ctx, strings.TrimPrefix(location, prefixVault))
+	case strings.HasPrefix(location, prefixAWS):
+		value, err := m.fetchAWS(ctx, strings.TrimPrefix(location, prefixAWS))
+		return value, 0, err
+	case strings.HasPrefix(location, prefixGCP):
+		value, err := m.fetchGCP(ctx, strings.TrimPrefix(location, prefixGCP))
+		return value, 0, err
+	}
+	return "", 0, errors.New("unknown secret manager")
+}
+
+// selectField returns the field of a JSON object secret, or the whole secret if field is
+// empty.
+func selectField(secret, field string) (string, error) {
+	if field == "" {
+		return secret, nil
+	}
+	var fields map[string]any
+	if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+		return "", fmt.Errorf("the secret is not a JSON object: %w", err)
+	}
+	switch value := fields[field].(type) {
+	case string:
+		return value, nil
+	case nil:
+		return "", fmt.Errorf("the secret has no field %q", field)
+	default:
+		return fmt.Sprint(value), nil
+	}
+}
+
+// BearerTransport returns a RoundTripper that authenticates the requests of base with the
+// secret ref refers to as a bearer token. If a request is rejected with status 401, the
+// secret is fetched again and, if it was rotated, the request is sent again with it.
+func (m *Manager) BearerTransport(base http.RoundTripper, ref string) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &bearerTransport{m, base, ref}
+}
+
+type bearerTransport struct {
+	m    *Manager
+	base http.RoundTripper
+	ref  string
+}
+
+func (t *bearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.m.Get(req.Context(), t.ref)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(withBearer(req, token))
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || (req.Body != nil && req.GetBody == nil) {
+		return resp, err
+	}
+	rotated, err := t.m.Refresh(req.Context(), t.ref)
+	if err != nil || rotated == token {
+		return resp, nil
+	}
+	retry := withBearer(req, rotated)
+	if req.GetBody != nil {
+		if retry.Body, err = req.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return t.base.RoundTrip(retry)
+}
+
+func withBearer(req *http.Request, token string) *http.Request {
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+// getJSON sends req and decodes its JSON response into v, returning the API's own error
+// message for statuses other than 200.
+func (m *Manager) getJSON(req *http.Request, v any) error {
+	resp, err := m.opts.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		if msg := bytes.TrimSpace(body); len(msg) > 0 && len(msg) < 1024 {
+			return fmt.Errorf("%s: %s", resp.Status, msg)
+		}
+		return errors.New(resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("could not decode response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSignV4(t *testing.T) {
+	// The get-vanilla case of the AWS Signature Version 4 test suite.
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	creds := awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signV4(req, nil, creds, "us-east-1", "service", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization = %q, want %q", got, want)
+	}
+}
+
+func TestManager_Vault(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.URL.Path != "/v1/secret/data/mail-analyzer" || r.Header.Get("X-Vault-Token") != "s.token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		io.WriteString(w, `{"lease_duration": 0, "data": {"data": {"openai_api_key": "sk-vault", "port": 8080}, "metadata": {"version": 3}}}`)
+	}))
+	defer server.Close()
+	m := NewManager(Options{VaultAddr: server.URL, VaultToken: "s.token"})
+	ctx := context.Background()
+
+	for ref, want := range map[string]string{
+		"vault://secret/data/mail-analyzer#openai_api_key": "sk-vault",
+		"vault://secret/data/mail-analyzer#port":           "8080",
+	} {
+		if got, err := m.Get(ctx, ref); err != nil || got != want {
+			t.Errorf("Get(%q) = %q, %v, want %q", ref, got, err, want)
+		}
+	}
+	if n := requests.Load(); n != 1 {
+		t.Errorf("%d requests, want the secret to be fetched once", n)
+	}
+	if _, err := m.Refresh(ctx, "vault://secret/data/mail-analyzer#openai_api_key"); err != nil || requests.Load() != 2 {
+		t.Errorf("Refresh() error = %v after %d requests, want it fetched again", err, requests.Load())
+	}
+	if _, err := m.Get(ctx, "vault://secret/data/mail-analyzer#missing"); err == nil || !strings.Contains(err.Error(), `no field "missing"`) {
+		t.Errorf("Get() of a missing field error = %v", err)
+	}
+	if _, err := m.Get(ctx, "vault://secret/data/other#key"); err == nil || !strings.Contains(err.Error(), "permission denied") {
+		t.Errorf("Get() of a forbidden secret error = %v", err)
+	}
+}
+
+func TestManager_AWS(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_SESSION_TOKEN", "")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&body)
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" || body.SecretId != "prod/mail-analyzer" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/") ||
+			!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/secretsmanager/aws4_request") {
+			http.Error(w, `{"__type":"AccessDeniedException"}`, http.StatusBadRequest)
+			return
+		}
+		io.WriteString(w, `{"Name": "prod/mail-analyzer", "SecretString": "{\"thehive_api_key\": \"hive-key\"}"}`)
+	}))
+	defer server.Close()
+	m := NewManager(Options{AWSRegion: "eu-west-1", AWSEndpoint: server.URL})
+	if got, err := m.Get(context.Background(), "aws-sm://prod/mail-analyzer#thehive_api_key"); err != nil || got != "hive-key" {
+		t.Errorf("Get() = %q, %v", got, err)
+	}
+}
+
+func TestManager_GCP(t *testing.T) {
+	t.Setenv("GOOGLE_OAUTH_ACCESS_TOKEN", "ya29.token")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/projects/p/secrets/openai-key/versions/latest:access" || r.Header.Get("Authorization") != "Bearer ya29.token" {
+			http.NotFound(w, r)
+			return
+		}
+		io.WriteString(w, `{"payload": {"data": "c2stZ2NwCg=="}}`) // "sk-gcp\n"
+	}))
+	defer server.Close()
+	m := NewManager(Options{GCPEndpoint: server.URL})
+	if got, err := m.Get(context.Background(), "gcp-sm://projects/p/secrets/openai-key"); err != nil || got != "sk-gcp\n" {
+		t.Errorf("Get() = %q, %v", got, err)
+	}
+	if _, err := m.Get(context.Background(), "gcp-sm://openai-key"); err == nil {
+		t.Error("Get() of a malformed name succeeded")
+	}
+}
+
+func TestBearerTransport(t *testing.T) {
+	var key atomic.Value
+	key.Store("sk-old")
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"data": map[string]string{"key": key.Load().(string)}})
+	}))
+	defer vault.Close()
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Authorization") != "Bearer sk-new" {
+			http.Error(w, "invalid key", http.StatusUnauthorized)
+			return
+		}
+		w.Write(body)
+	}))
+	defer api.Close()
+
+	m := NewManager(Options{VaultAddr: vault.URL, VaultToken: "s.token", RefreshInterval: time.Hour})
+	client := &http.Client{Transport: m.BearerTransport(nil, "vault://kv/mail-analyzer#key")}
+	if resp, err := client.Post(api.URL, "text/plain", strings.NewReader("hello")); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("POST with the old key = %v, %v, want 401", resp, err)
+	}
+
+	// Once the key is rotated, the rejected request is sent again with the new key.
+	key.Store("sk-new")
+	resp, err := client.Post(api.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Errorf("POST after the rotation = %s %q", resp.Status, body)
+	}
+}
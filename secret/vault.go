@@ -0,0 +1,76 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// fetchVault reads the secret at path, such as secret/data/mail-analyzer for the KV
+// version 2 engine mounted at secret/, and returns its data as a JSON object.
+func (m *Manager) fetchVault(ctx context.Context, path string) (string, time.Duration, error) {
+	if m.opts.VaultAddr == "" {
+		return "", 0, errors.New("vault_addr is not set")
+	}
+	token, err := m.vaultToken()
+	if err != nil {
+		return "", 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimRight(m.opts.VaultAddr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if m.opts.VaultNamespace != "" {
+		req.Header.Set("X-Vault-Namespace", m.opts.VaultNamespace)
+	}
+	var resp struct {
+		LeaseDuration int             `json:"lease_duration"`
+		Data          json.RawMessage `json:"data"`
+	}
+	if err := m.getJSON(req, &resp); err != nil {
+		return "", 0, err
+	}
+	// The KV version 2 engine nests the secret in data.data, next to its metadata.
+	var kv2 struct {
+		Data     json.RawMessage `json:"data"`
+		Metadata json.RawMessage `json:"metadata"`
+	}
+	data := resp.Data
+	if json.Unmarshal(resp.Data, &kv2) == nil && kv2.Data != nil && kv2.Metadata != nil {
+		data = kv2.Data
+	}
+	if len(data) == 0 || string(data) == "null" {
+		return "", 0, errors.New("the secret has no data")
+	}
+	return string(data), time.Duration(resp.LeaseDuration) * time.Second, nil
+}
+
+// vaultToken returns the Vault token of the options, of the token file, or of the Vault
+// command line.
+func (m *Manager) vaultToken() (string, error) {
+	if m.opts.VaultToken != "" {
+		return m.opts.VaultToken, nil
+	}
+	path := m.opts.VaultTokenFile
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", errors.New("vault_token is not set")
+		}
+		path = filepath.Join(home, ".vault-token")
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) && m.opts.VaultTokenFile == "" {
+			return "", errors.New("vault_token is not set")
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
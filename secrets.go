@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+	"mail-analyzer/secret"
+)
+
+// secretSetting is a setting that may hold a secret.
+type secretSetting struct {
+	name  string
+	value *string
+}
+
+// secretSettings returns the settings of cfg that may hold secrets.
+func secretSettings(cfg *config.Config) []secretSetting {
+	return []secretSetting{
+		{"openai_api_key", &cfg.OpenAIAPIKey},
+		{"splunk_hec_token", &cfg.SplunkHECToken},
+		{"webhook_secret", &cfg.WebhookSecret},
+		{"thehive_api_key", &cfg.TheHiveAPIKey},
+		{"sandbox_api_key", &cfg.SandboxAPIKey},
+		{"imap_password", &cfg.IMAPPassword},
+	}
+}
+
+// resolveSecrets replaces the settings of cfg that refer to secrets in a secret manager
+// with the secrets. It returns the manager, which fetches them again once they may have
+// been rotated, or nil if there are no references.
+func resolveSecrets(ctx context.Context, cfg *config.Config) (*secret.Manager, error) {
+	var m *secret.Manager
+	for _, s := range secretSettings(cfg) {
+		if !secret.IsReference(*s.value) {
+			continue
+		}
+		if m == nil {
+			client, err := httpclient.New(cfg)
+			if err != nil {
+				return nil, fmt.Errorf("error creating HTTP client: %w", err)
+			}
+			m = secret.NewManager(secret.Options{
+				Client:          client,
+				RefreshInterval: time.Duration(cfg.SecretRefreshInterval),
+				VaultAddr:       cfg.VaultAddr,
+				VaultToken:      cfg.VaultToken,
+				VaultTokenFile:  cfg.VaultTokenFile,
+				VaultNamespace:  cfg.VaultNamespace,
+				AWSRegion:       cfg.AWSRegion,
+			})
+		}
+		value, err := m.Get(ctx, *s.value)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s from the secret manager: %w", s.name, err)
+		}
+		*s.value = value
+	}
+	return m, nil
+}
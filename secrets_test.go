@@ -0,0 +1,41 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestResolveSecrets(t *testing.T) {
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, `{"data": {"data": {"openai_api_key": "sk-vault", "webhook_secret": "hmac-key"}, "metadata": {}}}`)
+	}))
+	defer vault.Close()
+	cfg := &config.Config{
+		VaultAddr:     vault.URL,
+		VaultToken:    "s.token",
+		OpenAIAPIKey:  "vault://secret/data/mail-analyzer#openai_api_key",
+		WebhookSecret: "vault://secret/data/mail-analyzer#webhook_secret",
+		IMAPPassword:  "plain",
+	}
+	m, err := resolveSecrets(context.Background(), cfg)
+	if err != nil || m == nil {
+		t.Fatalf("resolveSecrets() = %v, %v", m, err)
+	}
+	if cfg.OpenAIAPIKey != "sk-vault" || cfg.WebhookSecret != "hmac-key" || cfg.IMAPPassword != "plain" {
+		t.Errorf("resolveSecrets() left %+v", cfg)
+	}
+
+	cfg.TheHiveAPIKey = "vault://secret/data/mail-analyzer#thehive_api_key"
+	if _, err := resolveSecrets(context.Background(), cfg); err == nil || !strings.Contains(err.Error(), "thehive_api_key") {
+		t.Errorf("resolveSecrets() of a missing secret error = %v", err)
+	}
+	if m, err := resolveSecrets(context.Background(), &config.Config{OpenAIAPIKey: "sk-plain"}); m != nil || err != nil {
+		t.Errorf("resolveSecrets() without references = %v, %v", m, err)
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// selftestCorpus holds the synthetic messages analyzed by selftest.
+//
+//go:embed selftest/*.eml
+var selftestCorpus embed.FS
+
+// mockJudgment is the answer of the mock provider of selftest --mock.
+var mockJudgment = llm.Judgment{
+	IsSuspicious:    false,
+	Category:        "Safe",
+	Reason:          "Mock judgment: the message was not sent to a model.",
+	ConfidenceScore: 1,
+}
+
+// selftestSample is a message of the corpus and what parsing it must give.
+type selftestSample struct {
+	file         string
+	subject      string
+	bodyContains string
+	url          string
+	attachments  int
+	// suspicious is whether a working model should flag the message.
+	suspicious bool
+}
+
+var selftestSamples = []selftestSample{
+	{file: "plain.eml", subject: "Monthly product update", bodyContains: "dark mode and faster search", url: "https://www.example.com/blog/july-update"},
+	{file: "html.eml", subject: "Your order has shipped", bodyContains: "was shipped today", url: "https://store.example.com/orders/1001/tracking"},
+	{file: "multipart.eml", subject: "Invoice for June", bodyContains: "Payment terms are 30 days", url: "https://partner.example.org/contact", attachments: 1},
+	{file: "iso-2022-jp.eml", subject: "会議のお知らせ", bodyContains: "第二会議室", url: "https://intranet.example.co.jp/meetings/weekly"},
+	{file: "phishing.eml", subject: "Urgent: your account will be suspended", bodyContains: "Verify your identity and password", url: "http://examp1e-bank.example.net.secure-login.example.net/verify?id=8841", suspicious: true},
+}
+
+// runSelftest implements the "selftest" command, which analyzes the bundled corpus.
+func runSelftest(args []string) error {
+	fs := newFlagSet("selftest", "",
+		"Analyze a bundled set of synthetic messages (plain text, HTML, multipart with an\n"+
+			"attachment, ISO-2022-JP and phishing) with the full pipeline, to verify an installation\n"+
+			"end to end. With --mock, no request is sent and every message is judged safe, which\n"+
+			"tests everything but the model. Results are not sent to sinks and no action is taken.")
+	pf := pipelineFlags{analyzeOnly: true}
+	pf.registerAnalysis(fs)
+	fs.BoolVar(&pf.mock, "mock", false, "Answer with a canned judgment instead of calling the LLM endpoint")
+	timeout := fs.Duration("timeout", 5*time.Minute, "Give up after this long")
+	if err := parseConfigFlags(fs, args); err != nil {
+		return err
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	// The corpus must not be added to the pre-filter store, and the mock does not stream.
+	cfg.VectorStorePath = ""
+	if pf.mock {
+		cfg.Stream = false
+	}
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+
+	if pf.mock {
+		fmt.Println("Provider: mock, no requests are sent")
+	} else {
+		fmt.Printf("Endpoint: %s\nModel: %s\n", strings.TrimRight(cmp.Or(cfg.OpenAIBaseURL, llm.DefaultBaseURL), "/"), cfg.ModelName)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	return runSelftestSamples(ctx, os.Stdout, p, !pf.mock)
+}
+
+// runSelftestSamples parses and analyzes every sample of the corpus with p and reports
+// every problem found. If checkVerdicts is set, verdicts other than the expected ones
+// are reported as warnings.
+func runSelftestSamples(ctx context.Context, out io.Writer, p *pipeline, checkVerdicts bool) error {
+	failed := 0
+	for _, sample := range selftestSamples {
+		problems := sample.check()
+		if len(problems) > 0 {
+			failed++
+			for _, problem := range problems {
+				fmt.Fprintf(out, "ERROR: %s: %s\n", sample.file, problem)
+			}
+			continue
+		}
+		start := time.Now()
+		result, err := p.analyze(ctx, sample.raw(), path.Join("selftest", sample.file))
+		if err == nil {
+			err = writeSelftestResult(result)
+		}
+		if err != nil {
+			failed++
+			fmt.Fprintf(out, "ERROR: %s: %v\n", sample.file, err)
+			continue
+		}
+		j := result.Judgment
+		fmt.Fprintf(out, "%-16s ok, %s (%.2f) in %s\n", sample.file, j.Category, j.ConfidenceScore, time.Since(start).Round(time.Millisecond))
+		if _, ok := normalizeCategory(j.Category); !ok {
+			fmt.Fprintf(out, "WARNING: %s: unknown category %q, expected one of %s\n", sample.file, j.Category, strings.Join(evalCategories, ", "))
+		}
+		if checkVerdicts && sample.suspicious && !j.IsSuspicious {
+			fmt.Fprintf(out, "WARNING: %s: the message was not judged suspicious, although it is an obvious phishing attempt\n", sample.file)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d samples failed", failed, len(selftestSamples))
+	}
+	fmt.Fprintf(out, "All %d samples passed.\n", len(selftestSamples))
+	return nil
+}
+
+// raw returns the message of the sample.
+func (s selftestSample) raw() []byte {
+	data, err := selftestCorpus.ReadFile(path.Join("selftest", s.file))
+	if err != nil {
+		panic(err) // The corpus is embedded in the binary.
+	}
+	return data
+}
+
+// check parses the sample and returns how the result differs from the expected one.
+func (s selftestSample) check() []string {
+	parsed, err := email.Parse(bytes.NewReader(s.raw()))
+	if err != nil {
+		return []string{err.Error()}
+	}
+	var problems []string
+	if parsed.Subject != s.subject {
+		problems = append(problems, fmt.Sprintf("subject is %q, want %q", parsed.Subject, s.subject))
+	}
+	if !strings.Contains(strings.Join(strings.Fields(parsed.Body), " "), s.bodyContains) {
+		problems = append(problems, fmt.Sprintf("body does not contain %q: %q", s.bodyContains, parsed.Body))
+	}
+	if !slices.Contains(parsed.URLs, s.url) {
+		problems = append(problems, fmt.Sprintf("URLs are %q, want %q", parsed.URLs, s.url))
+	}
+	if len(parsed.Attachments) != s.attachments {
+		problems = append(problems, fmt.Sprintf("%d attachments, want %d", len(parsed.Attachments), s.attachments))
+	}
+	return problems
+}
+
+// writeSelftestResult checks that result can be written in every output format.
+func writeSelftestResult(result *AnalysisResult) error {
+	var errs []error
+	for _, format := range []string{FormatJSON, FormatJSONL, FormatCSV, FormatTSV, FormatSARIF, FormatEML} {
+		w, err := newResultWriter(format, io.Discard, result.SourceFile)
+		if err == nil {
+			err = errors.Join(w.Write(result), w.Close())
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s output: %w", format, err))
+		}
+	}
+	return errors.Join(errs...)
+}
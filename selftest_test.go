@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+)
+
+func TestRunSelftestSamples(t *testing.T) {
+	p, err := newPipeline(&config.Config{ModelName: "test-model"}, &pipelineFlags{mock: true, analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	var out bytes.Buffer
+	if err := runSelftestSamples(context.Background(), &out, p, false); err != nil {
+		t.Fatalf("runSelftestSamples() error = %v\n%s", err, out.String())
+	}
+	if !strings.HasSuffix(out.String(), "All 5 samples passed.\n") || strings.Contains(out.String(), "WARNING") {
+		t.Errorf("output = %q", out.String())
+	}
+
+	// With verdicts checked, the phishing sample judged safe by the mock is reported.
+	out.Reset()
+	if err := runSelftestSamples(context.Background(), &out, p, true); err != nil {
+		t.Fatalf("runSelftestSamples() error = %v", err)
+	}
+	if !strings.Contains(out.String(), "WARNING: phishing.eml: the message was not judged suspicious") {
+		t.Errorf("output = %q, want a warning for phishing.eml", out.String())
+	}
+}
+
+func TestRunSelftestSamples_Failure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "overloaded", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	cfg := &config.Config{OpenAIBaseURL: server.URL, ChatCompletionsPath: "/chat/completions", DisableRepairRetry: true}
+	p, err := newPipeline(cfg, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	var out bytes.Buffer
+	err = runSelftestSamples(context.Background(), &out, p, true)
+	if err == nil || err.Error() != "5 of 5 samples failed" {
+		t.Errorf("runSelftestSamples() error = %v, want every sample to fail", err)
+	}
+	if !strings.Contains(out.String(), "ERROR: plain.eml: ") {
+		t.Errorf("output = %q", out.String())
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"mail-analyzer/email"
+	"mail-analyzer/llm"
+)
+
+// defaultMaxMessageSize is the largest message accepted by the server.
+const defaultMaxMessageSize = 25 << 20
+
+// runServe implements the "serve" command, an HTTP server that analyzes submitted messages.
+func runServe(args []string) error {
+	fs := newFlagSet("serve", "",
+		"Analyze messages submitted over HTTP. POST a raw message (message/rfc822) to\n"+
+			"/analyze to receive the analysis result as JSON, or to /checkv2 to receive an\n"+
+			"rspamd-compatible reply. GET /healthz reports readiness.")
+	var pf pipelineFlags
+	pf.register(fs)
+	pf.daemon = true
+	listen := fs.String("listen", "127.0.0.1:8080", "Address to listen on")
+	maxSize := fs.Int64("max-message-size", defaultMaxMessageSize, "Largest accepted message in bytes")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	live := newLivePipeline(p, &pf)
+
+	// On SIGINT or SIGTERM, the server stops accepting connections and the analyses in
+	// progress are cancelled, as requests inherit the context.
+	ctx, stop := shutdownContext()
+	defer stop()
+	go live.watch(ctx)
+	server := &http.Server{
+		Addr:              *listen,
+		Handler:           newServeHandler(live, *maxSize),
+		ReadHeaderTimeout: 10 * time.Second,
+		BaseContext:       func(net.Listener) context.Context { return ctx },
+	}
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	fmt.Fprintf(os.Stderr, "Listening on %s\n", *listen)
+	if err := server.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	if err := live.close(); err != nil {
+		return err
+	}
+	return interruptedError(ctx, context.Cause(ctx))
+}
+
+// newServeHandler returns the HTTP handler of the serve command.
+func newServeHandler(src pipelineSource, maxSize int64) http.Handler {
+	limits := &rateLimits{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("POST /analyze", func(w http.ResponseWriter, r *http.Request) {
+		if result, ok := serveAnalysis(w, r, src, maxSize, limits); ok {
+			writeJSON(w, result)
+		}
+	})
+	// The rspamd protocol, for MTAs and proxies that already use an rspamd scanner.
+	mux.HandleFunc("POST /checkv2", func(w http.ResponseWriter, r *http.Request) {
+		if result, ok := serveAnalysis(w, r, src, maxSize, limits); ok {
+			writeJSON(w, newRspamdReply(result))
+		}
+	})
+	return mux
+}
+
+// serveAnalysis analyzes the message in the body of r, with the analysis options of its
+// query and the policy of its API token, if any, delivers the result to the sinks and
+// actions, and returns it as the role of the token may see it. If the message cannot be
+// analyzed, it writes the error response and returns false.
+func serveAnalysis(w http.ResponseWriter, r *http.Request, src pipelineSource, maxSize int64, limits *rateLimits) (*AnalysisResult, bool) {
+	p, release := src.acquire()
+	defer release()
+	ctx := r.Context()
+	client, ok := p.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="mail-analyzer"`)
+		writeJSONError(w, http.StatusUnauthorized, errors.New("missing or invalid API token"))
+		return nil, false
+	}
+	if policy := client.policy; policy != nil {
+		if policy.RateLimit > 0 {
+			if wait := limits.take(policy.Tenant, policy.RateLimit, time.Now()); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				writeJSONError(w, http.StatusTooManyRequests, fmt.Errorf("rate limit of %d messages per minute exceeded", policy.RateLimit))
+				return nil, false
+			}
+		}
+		ctx = withTenant(ctx, policy)
+	}
+
+	reqOpts, err := queryOptions(r.URL.Query())
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	rawMessage, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxSize))
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeJSONError(w, http.StatusRequestEntityTooLarge, err)
+			return nil, false
+		}
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	if len(rawMessage) == 0 {
+		writeJSONError(w, http.StatusBadRequest, errors.New("empty message"))
+		return nil, false
+	}
+
+	opts, err := p.analysisOptions(reqOpts)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	result, err := p.analyzeWith(ctx, rawMessage, "", opts)
+	if errors.Is(err, email.ErrParse) {
+		writeJSONError(w, http.StatusBadRequest, err)
+		return nil, false
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		if r.Context().Err() != nil {
+			writeJSONError(w, http.StatusServiceUnavailable, errors.New("the server is shutting down"))
+			return nil, false
+		}
+		if errors.Is(err, llm.ErrProviderUnavailable) || errors.Is(err, llm.ErrRateLimited) {
+			// Another attempt may succeed later, unlike one after a bad response.
+			if wait := llm.RetryAfter(err); wait > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			}
+			writeJSONError(w, http.StatusServiceUnavailable, err)
+			return nil, false
+		}
+		writeJSONError(w, http.StatusBadGateway, err)
+		return nil, false
+	}
+	// Failed deliveries and actions are reported, but do not change the verdict
+	// returned to the client.
+	if err := errors.Join(p.record(ctx, result), p.act(ctx, result)); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	return resultFor(client.role, result), true
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	enc.Encode(v)
+}
+
+func writeJSONError(w http.ResponseWriter, status int, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm/llmtest"
+	"mail-analyzer/resultdb"
+)
+
+// newFakeLLM returns a chat completions server that judges every message as phishing.
+func newFakeLLM(t *testing.T) *llmtest.Server {
+	server := llmtest.NewServer(llmtest.Verdict("Phishing", 0.9, "Fake login."))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestServeHandler(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	server := httptest.NewServer(newServeHandler(p, 1024))
+	defer server.Close()
+
+	resp, err := http.Post(server.URL+"/analyze", "message/rfc822", strings.NewReader("Message-ID: <1@example.com>\r\nSubject: Verify\r\n\r\nhttps://evil.example.com\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result AnalysisResult
+	json.NewDecoder(resp.Body).Decode(&result)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || result.MessageID != "1@example.com" || result.Judgment == nil || result.Judgment.Category != "Phishing" {
+		t.Errorf("POST /analyze = %s %+v", resp.Status, result)
+	}
+
+	resp, err = http.Post(server.URL+"/checkv2", "message/rfc822", strings.NewReader("Message-ID: <1@example.com>\r\nSubject: Verify\r\n\r\nhttps://evil.example.com\r\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reply rspamdReply
+	json.NewDecoder(resp.Body).Decode(&reply)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || reply.Action != "add header" || reply.Symbols["MAIL_ANALYZER_PHISHING"].Score != reply.Score {
+		t.Errorf("POST /checkv2 = %s %+v", resp.Status, reply)
+	}
+
+	message := "Subject: Verify\r\n\r\nhttps://evil.example.com\r\n"
+	tests := []struct {
+		query string
+		body  string
+		want  int
+	}{
+		{"", "", http.StatusBadRequest},
+		{"", strings.Repeat("x", 2048), http.StatusRequestEntityTooLarge},
+		{"?categories=Phishing,Scam,Safe&language=Japanese&max_body_bytes=100&enrichments=", message, http.StatusOK},
+		{"?enrichments=auth", message, http.StatusOK},
+		{"?enrichments=whois", message, http.StatusBadRequest},
+		{"?max_body_bytes=many", message, http.StatusBadRequest},
+		{"?templates=french", message, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Post(server.URL+"/analyze"+tt.query, "message/rfc822", strings.NewReader(tt.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("POST /analyze%s with %d bytes = %s, want %d", tt.query, len(tt.body), resp.Status, tt.want)
+		}
+	}
+
+	if resp, _ := http.Get(server.URL + "/analyze"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("GET /analyze = %s", resp.Status)
+	}
+}
+
+func TestServeHandler_Unavailable(t *testing.T) {
+	tests := []struct {
+		name           string
+		response       llmtest.Response
+		want           int
+		wantRetryAfter string
+	}{
+		{"Rate limited", llmtest.RateLimited(30 * time.Second), http.StatusServiceUnavailable, "30"},
+		{"Overloaded", llmtest.Status(http.StatusServiceUnavailable, "Overloaded"), http.StatusServiceUnavailable, ""},
+		{"Bad output", llmtest.Text("No idea."), http.StatusBadGateway, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llmServer := llmtest.NewServer(tt.response)
+			defer llmServer.Close()
+			p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions", DisableRepairRetry: true}, &pipelineFlags{})
+			if err != nil {
+				t.Fatalf("newPipeline() error = %v", err)
+			}
+			req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader("Subject: Verify\r\n\r\nhttps://evil.example.com\r\n"))
+			rec := httptest.NewRecorder()
+			newServeHandler(p, 1024).ServeHTTP(rec, req)
+			if rec.Code != tt.want || rec.Header().Get("Retry-After") != tt.wantRetryAfter {
+				t.Errorf("POST /analyze = %d (Retry-After %q), want %d (Retry-After %q)", rec.Code, rec.Header().Get("Retry-After"), tt.want, tt.wantRetryAfter)
+			}
+		})
+	}
+}
+
+func TestServeHandler_Shutdown(t *testing.T) {
+	release := make(chan struct{})
+	llmServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release // The model does not answer before the shutdown.
+	}))
+	defer llmServer.Close()
+	defer close(release)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	// Requests inherit the context cancelled by the shutdown.
+	shutdown, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(100*time.Millisecond, cancel)
+	req := httptest.NewRequestWithContext(shutdown, http.MethodPost, "/analyze", strings.NewReader("Subject: Verify\r\n\r\nhttps://evil.example.com\r\n"))
+	rec := httptest.NewRecorder()
+	newServeHandler(p, 1024).ServeHTTP(rec, req)
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("POST /analyze during shutdown = %d, want 503", rec.Code)
+	}
+}
+
+func TestServeHandler_Outbreak(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	var mu sync.Mutex
+	var outbreaks []map[string]any
+	webhook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event map[string]any
+		json.NewDecoder(r.Body).Decode(&event)
+		if event["event"] == "outbreak" {
+			mu.Lock()
+			outbreaks = append(outbreaks, event)
+			mu.Unlock()
+		}
+	}))
+	defer webhook.Close()
+	cfg := &config.Config{
+		OpenAIBaseURL:       llmServer.URL,
+		ChatCompletionsPath: "/chat/completions",
+		WebhookURL:          webhook.URL,
+		OutbreakThreshold:   2,
+		OutbreakWindow:      config.Duration(time.Minute),
+	}
+	p, err := newPipeline(cfg, &pipelineFlags{daemon: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	handler := newServeHandler(p, 1024)
+	for _, to := range []string{"alice@example.com", "bob@example.com", "carol@example.com"} {
+		req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader("To: "+to+"\r\nSubject: Verify\r\n\r\nhttps://evil.example.com/login?u="+to+"\r\n"))
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /analyze = %d", rec.Code)
+		}
+	}
+	if len(outbreaks) != 1 || outbreaks[0]["kind"] != "url" || outbreaks[0]["indicator"] != "https://evil.example.com/login" || outbreaks[0]["messages"] != 2.0 {
+		t.Errorf("webhook received the outbreaks %v, want one of 2 messages sharing https://evil.example.com/login", outbreaks)
+	}
+}
+
+func TestServeHandler_APITokens(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	dir := t.TempDir()
+	// The token of globex is given by its hash.
+	globexHash := sha256.Sum256([]byte("globex-token"))
+	cfg := &config.Config{
+		OpenAIBaseURL:       llmServer.URL,
+		ChatCompletionsPath: "/chat/completions",
+		Policies: []config.Policy{
+			{Tenant: "acme", APITokens: []config.APIToken{{Token: "acme-token"}}, RateLimit: 2, ResultsDB: filepath.Join(dir, "acme.db")},
+			{Tenant: "globex", Domains: []string{"globex.com"}, APITokens: []config.APIToken{{Token: "sha256:" + hex.EncodeToString(globexHash[:])}}},
+		},
+	}
+	p, err := newPipeline(cfg, &pipelineFlags{dbPath: filepath.Join(dir, "shared.db")})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	handler := newServeHandler(p, 1024)
+
+	for _, tt := range []struct {
+		token      string
+		wantStatus int
+		wantTenant string
+	}{
+		{"", http.StatusUnauthorized, ""},
+		{"wrong-token", http.StatusUnauthorized, ""},
+		{"globex-token", http.StatusOK, "globex"},
+		// The token decides over the recipients.
+		{"acme-token", http.StatusOK, "acme"},
+		{"acme-token", http.StatusOK, "acme"},
+		{"acme-token", http.StatusTooManyRequests, ""},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader("To: user@globex.com\r\nSubject: Verify\r\n\r\nhttps://evil.example.com\r\n"))
+		if tt.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		var result AnalysisResult
+		json.NewDecoder(rec.Body).Decode(&result)
+		if rec.Code != tt.wantStatus || result.Tenant != tt.wantTenant {
+			t.Errorf("POST /analyze with token %q = %d, tenant %q, want %d, tenant %q", tt.token, rec.Code, result.Tenant, tt.wantStatus, tt.wantTenant)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "30" {
+			t.Errorf("Retry-After = %q, want 30", rec.Header().Get("Retry-After"))
+		}
+	}
+	if err := p.close(); err != nil {
+		t.Fatalf("close() error = %v", err)
+	}
+
+	// The results of acme are only in its own database, and those of globex in none.
+	for name, want := range map[string]int{"acme.db": 2, "shared.db": 0} {
+		db, err := resultdb.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		records, err := db.Query(context.Background(), resultdb.Filter{})
+		db.Close()
+		if err != nil || len(records) != want {
+			t.Errorf("%s has %d results (error %v), want %d", name, len(records), err, want)
+		}
+	}
+}
+
+func TestServeHandler_APITokenRoles(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	cfg := &config.Config{
+		OpenAIBaseURL:       llmServer.URL,
+		ChatCompletionsPath: "/chat/completions",
+		Policies: []config.Policy{{Tenant: "acme", APITokens: []config.APIToken{
+			{Token: "analyst-token"},
+			{Token: "redacted-token", Role: config.RoleRedacted},
+			{Token: "verdict-token", Role: config.RoleVerdict},
+		}}},
+	}
+	p, err := newPipeline(cfg, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	handler := newServeHandler(p, 1024)
+
+	for _, tt := range []struct {
+		token   string
+		want    []string
+		notWant []string
+	}{
+		{"analyst-token", []string{"alice@acme.com", `"Verify"`, `"Fake login."`}, nil},
+		{"redacted-token", []string{`"[redacted]@acme.com"`, `"Fake login."`}, []string{"alice"}},
+		{"verdict-token", []string{`"category":"Phishing"`, `"tenant":"acme"`}, []string{"alice", "Verify", "Fake login.", "evil.example.com"}},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/analyze", strings.NewReader("To: alice@acme.com\r\nSubject: Verify\r\n\r\nhttps://evil.example.com\r\n"))
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK {
+			t.Fatalf("POST /analyze with token %q = %d %s", tt.token, rec.Code, body)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(body, want) {
+				t.Errorf("POST /analyze with token %q = %s, want it to contain %s", tt.token, body, want)
+			}
+		}
+		for _, notWant := range tt.notWant {
+			if strings.Contains(body, notWant) {
+				t.Errorf("POST /analyze with token %q = %s, want no %s", tt.token, body, notWant)
+			}
+		}
+	}
+}
+
+func TestRateLimits(t *testing.T) {
+	var limits rateLimits
+	now := time.Date(2026, 10, 5, 10, 0, 0, 0, time.UTC)
+	for i, tt := range []struct {
+		tenant string
+		after  time.Duration
+		want   time.Duration
+	}{
+		{"acme", 0, 0},
+		{"acme", 0, 0},
+		{"acme", 0, 30 * time.Second},
+		{"globex", 0, 0},
+		{"acme", 15 * time.Second, 15 * time.Second},
+		{"acme", 15 * time.Second, 0},
+		{"acme", 0, 30 * time.Second},
+	} {
+		now = now.Add(tt.after)
+		if got := limits.take(tt.tenant, 2, now); got != tt.want {
+			t.Errorf("%d: take(%q) = %s, want %s", i, tt.tenant, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// signalError is the cause of the cancellation of a shutdown context.
+type signalError struct {
+	sig os.Signal
+}
+
+func (e signalError) Error() string {
+	return "interrupted by " + e.name()
+}
+
+func (e signalError) name() string {
+	switch e.sig {
+	case os.Interrupt:
+		return "SIGINT"
+	case syscall.SIGTERM:
+		return "SIGTERM"
+	}
+	return e.sig.String()
+}
+
+// exitCode returns the exit status of a process killed by the signal: 130 for SIGINT and
+// 143 for SIGTERM, so that scripts and service managers can tell an interrupted run from
+// a completed or failed one.
+func (e signalError) exitCode() int {
+	if sig, ok := e.sig.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+	return 1
+}
+
+// shutdownContext returns a context that is cancelled on the first SIGINT or SIGTERM,
+// with a signalError as its cause: commands then stop starting new work, cancel the work
+// in progress and write what they have. A second signal exits at once. stop releases the
+// signals.
+func shutdownContext() (ctx context.Context, stop func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-signals:
+			cancel(signalError{sig})
+			fmt.Fprintf(os.Stderr, "\nShutting down after %s; send it again to exit at once\n", signalError{sig}.name())
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-signals:
+			os.Exit(signalError{sig}.exitCode())
+		case <-done:
+		}
+	}()
+	return ctx, func() {
+		signal.Stop(signals)
+		close(done)
+		cancel(context.Canceled)
+	}
+}
+
+// errDeadline is the cause of the cancellation of a run that reached its --deadline.
+var errDeadline = errors.New("deadline reached")
+
+// stoppedError returns the error of a run that stopped before its end because ctx was
+// done, such as "interrupted after analyzing 3 of 10 messages", with the exit status of
+// the signal that stopped it, if a signal did.
+func stoppedError(ctx context.Context, format string, args ...any) error {
+	reason := "interrupted"
+	if errors.Is(context.Cause(ctx), errDeadline) {
+		reason = errDeadline.Error()
+	}
+	return interruptedError(ctx, fmt.Errorf(reason+" after "+format, args...))
+}
+
+// interruptedError returns err with the exit status of the signal that cancelled ctx, if
+// a signal did.
+func interruptedError(ctx context.Context, err error) error {
+	var sigErr signalError
+	if errors.As(context.Cause(ctx), &sigErr) {
+		return exitCodeError{sigErr.exitCode(), err}
+	}
+	return err
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownContext(t *testing.T) {
+	ctx, stop := shutdownContext()
+	defer stop()
+	process, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		t.Skipf("cannot signal the test process: %v", err)
+	}
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("the context was not cancelled by SIGTERM")
+	}
+
+	err = interruptedError(ctx, errors.New("interrupted after analyzing 1 of 2 messages"))
+	var exitErr exitCodeError
+	if !errors.As(err, &exitErr) || exitErr.code != 143 || err.Error() != "interrupted after analyzing 1 of 2 messages" {
+		t.Errorf("interruptedError() = %v, want exit status 143", err)
+	}
+}
+
+func TestInterruptedError_NoSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	want := errors.New("interrupted")
+	if err := interruptedError(ctx, want); err != want {
+		t.Errorf("interruptedError() without a signal = %#v, want the error unchanged", err)
+	}
+	ctx, cancelCause := context.WithCancelCause(context.Background())
+	cancelCause(signalError{os.Interrupt})
+	var exitErr exitCodeError
+	if err := interruptedError(ctx, want); !errors.As(err, &exitErr) || exitErr.code != 130 {
+		t.Errorf("interruptedError() after SIGINT = %v, want exit status 130", err)
+	}
+}
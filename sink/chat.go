@@ -0,0 +1,206 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+)
+
+// Chat platforms supported by ChatAlert.
+const (
+	ChatSlack = "slack"
+	ChatTeams = "teams"
+)
+
+// maxAlertURLs is the number of URLs listed in an alert card.
+const maxAlertURLs = 5
+
+// ChatAlert posts a card to a Slack or Microsoft Teams incoming webhook when a
+// message is judged suspicious with at least the configured confidence.
+type ChatAlert struct {
+	client        *http.Client
+	platform      string
+	url           string
+	minConfidence float64
+	maxRetries    int
+	retryDelay    time.Duration
+}
+
+// NewChatAlert creates an alert sink for platform (ChatSlack or ChatTeams) posting to webhookURL.
+func NewChatAlert(cfg *config.Config, platform, webhookURL string) (*ChatAlert, error) {
+	if platform != ChatSlack && platform != ChatTeams {
+		return nil, fmt.Errorf("unsupported chat platform %q", platform)
+	}
+	client, err := httpclient.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &ChatAlert{
+		client:        client,
+		platform:      platform,
+		url:           webhookURL,
+		minConfidence: cfg.AlertMinConfidence,
+		maxRetries:    2,
+		retryDelay:    defaultRetryDelay,
+	}, nil
+}
+
+// Send implements Sink.
+func (c *ChatAlert) Send(ctx context.Context, result *Result) error {
+	j := result.Judgment
+	if j == nil || !j.IsSuspicious || j.ConfidenceScore < c.minConfidence {
+		return nil
+	}
+	return audited(ctx, result, c.platform, urlHost(c.url), c.post(ctx, alertTitle(result), alertFields(result)))
+}
+
+// SendOutbreak implements OutbreakSink.
+func (c *ChatAlert) SendOutbreak(ctx context.Context, o *Outbreak) error {
+	indicator := o.Indicator
+	if o.Kind == "url" {
+		indicator = Defang(indicator)
+	}
+	return c.post(ctx, fmt.Sprintf("Outbreak detected: %d similar messages", o.Messages), [][2]string{
+		{"Shared " + o.Kind, indicator},
+		{"Messages", fmt.Sprintf("%d in %s, %d judged suspicious", o.Messages, time.Duration(o.WindowSeconds)*time.Second, o.Suspicious)},
+		{"First seen", o.FirstSeen.Format(time.RFC3339)},
+		{"From", strings.Join(o.Senders, "\n")},
+		{"Subjects", defangText(strings.Join(o.Subjects, "\n"))},
+	})
+}
+
+// post posts a card with title and the labeled values fields.
+func (c *ChatAlert) post(ctx context.Context, title string, fields [][2]string) error {
+	var payload any
+	if c.platform == ChatSlack {
+		payload = slackPayload(title, fields)
+	} else {
+		payload = teamsPayload(title, fields)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.platform, err)
+	}
+
+	err = deliver(ctx, c.client, c.maxRetries, c.retryDelay, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", c.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.platform, err)
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (c *ChatAlert) Close() error {
+	return nil
+}
+
+// alertFields returns the labeled values shown on an alert card.
+func alertFields(result *Result) [][2]string {
+	j := result.Judgment
+	urls := make([]string, 0, maxAlertURLs)
+	for i, u := range result.URLs {
+		if i == maxAlertURLs {
+			urls = append(urls, fmt.Sprintf("(%d more)", len(result.URLs)-maxAlertURLs))
+			break
+		}
+		urls = append(urls, Defang(u))
+	}
+	if len(urls) == 0 {
+		urls = append(urls, "None")
+	}
+	return [][2]string{
+		{"Verdict", fmt.Sprintf("%s (confidence %.2f)", j.Category, j.ConfidenceScore)},
+		{"From", strings.Join(result.From, ", ")},
+		{"Subject", defangText(result.Subject)},
+		{"Message-ID", result.MessageID},
+		{"URLs", strings.Join(urls, "\n")},
+		{"Reason", defangText(j.Reason)},
+	}
+}
+
+func alertTitle(result *Result) string {
+	return fmt.Sprintf("Suspicious email detected: %s", result.Judgment.Category)
+}
+
+// slackPayload builds a Block Kit message. Untrusted values are escaped so they
+// cannot inject mentions or links.
+func slackPayload(title string, fields [][2]string) map[string]any {
+	var lines []string
+	for _, f := range fields {
+		lines = append(lines, fmt.Sprintf("*%s:*\n%s", f[0], slackEscape(f[1])))
+	}
+	return map[string]any{
+		"text": title,
+		"blocks": []map[string]any{
+			{"type": "header", "text": map[string]any{"type": "plain_text", "text": title}},
+			{"type": "section", "text": map[string]any{"type": "mrkdwn", "text": strings.Join(lines, "\n")}},
+		},
+	}
+}
+
+// teamsPayload builds an Adaptive Card message, accepted by Teams incoming webhooks and Workflows.
+func teamsPayload(title string, fields [][2]string) map[string]any {
+	var facts []map[string]string
+	for _, f := range fields {
+		facts = append(facts, map[string]string{"title": f[0], "value": f[1]})
+	}
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]any{
+					{"type": "TextBlock", "text": title, "weight": "Bolder", "size": "Medium", "color": "Attention", "wrap": true},
+					{"type": "FactSet", "facts": facts},
+				},
+			},
+		}},
+	}
+}
+
+// Defang rewrites a URL so that it is not clickable or auto-linked,
+// e.g. "https://evil.example.com/a.php" becomes "hxxps[://]evil[.]example[.]com/a.php".
+func Defang(u string) string {
+	if scheme, rest, ok := strings.Cut(u, "://"); ok {
+		scheme = strings.Replace(strings.ToLower(scheme), "http", "hxxp", 1)
+		host, path, hasPath := strings.Cut(rest, "/")
+		u = scheme + "[://]" + strings.ReplaceAll(host, ".", "[.]")
+		if hasPath {
+			u += "/" + path
+		}
+		return u
+	}
+	return strings.ReplaceAll(u, ".", "[.]")
+}
+
+var textURLRegex = regexp.MustCompile(`(?i)\b(?:https?|ftp)://[^\s<>"'()\[\]]+`)
+
+// defangText defangs the URLs in free text such as the subject or the model's reason,
+// so that neither platform renders them as links.
+func defangText(s string) string {
+	return textURLRegex.ReplaceAllStringFunc(s, Defang)
+}
+
+var slackEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func slackEscape(s string) string {
+	return slackEscaper.Replace(s)
+}
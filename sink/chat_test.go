@@ -0,0 +1,92 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+func TestDefang(t *testing.T) {
+	tests := map[string]string{
+		"https://evil.example.com/a.php?x=1": "hxxps[://]evil[.]example[.]com/a.php?x=1",
+		"HTTP://evil.example.com":            "hxxp[://]evil[.]example[.]com",
+		"ftp://files.example.com/":           "ftp[://]files[.]example[.]com/",
+		"evil.example.com":                   "evil[.]example[.]com",
+	}
+	for in, want := range tests {
+		if got := Defang(in); got != want {
+			t.Errorf("Defang(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestChatAlert_Send(t *testing.T) {
+	phishing := &Result{
+		MessageID: "<1@example.com>",
+		Subject:   "<!channel> Verify now",
+		From:      []string{"attacker@evil.example.com"},
+		URLs:      []string{"https://evil.example.com/login"},
+		Judgment:  &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake [login](https://evil.example.com).", ConfidenceScore: 0.9},
+	}
+	lowConfidence := &Result{Judgment: &llm.Judgment{IsSuspicious: true, Category: "Spam", ConfidenceScore: 0.3}}
+	safe := &Result{Judgment: &llm.Judgment{Category: "Safe", ConfidenceScore: 0.99}}
+
+	for _, platform := range []string{ChatSlack, ChatTeams} {
+		t.Run(platform, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+			}))
+			defer server.Close()
+
+			c, err := NewChatAlert(&config.Config{AlertMinConfidence: 0.5}, platform, server.URL)
+			if err != nil {
+				t.Fatalf("NewChatAlert() error = %v", err)
+			}
+			for _, r := range []*Result{phishing, lowConfidence, safe} {
+				if err := c.Send(context.Background(), r); err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+			}
+			if len(bodies) != 1 {
+				t.Fatalf("expected 1 alert, got %d", len(bodies))
+			}
+
+			var payload map[string]any
+			if err := json.Unmarshal([]byte(bodies[0]), &payload); err != nil {
+				t.Fatalf("invalid payload: %v", err)
+			}
+			body := bodies[0]
+			for _, want := range []string{"Suspicious email detected: Phishing", "hxxps[://]evil[.]example[.]com/login", "confidence 0.90", "attacker@evil.example.com"} {
+				if !strings.Contains(body, want) {
+					t.Errorf("payload does not contain %q: %s", want, body)
+				}
+			}
+			if strings.Contains(body, "https://evil.example.com/login") {
+				t.Errorf("payload contains a live URL: %s", body)
+			}
+			switch platform {
+			case ChatSlack:
+				if strings.Contains(body, "<!channel>") {
+					t.Errorf("Slack mention was not escaped: %s", body)
+				}
+			case ChatTeams:
+				if payload["type"] != "message" || !strings.Contains(body, `Fake [login](hxxps[://]evil[.]example[.]com).`) {
+					t.Errorf("unexpected Teams payload: %s", body)
+				}
+			}
+		})
+	}
+
+	if _, err := NewChatAlert(&config.Config{}, "irc", "http://localhost"); err == nil {
+		t.Error("expected an error for an unknown platform")
+	}
+}
@@ -0,0 +1,56 @@
+package sink
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// defaultRetryDelay is the delay before the first retry of a failed delivery.
+const defaultRetryDelay = time.Second
+
+// deliver sends the request built by newRequest, retrying network errors and
+// 429/5xx responses up to maxRetries times with exponential backoff from delay.
+// newRequest is called for every attempt so that each gets a fresh body.
+func deliver(ctx context.Context, client *http.Client, maxRetries int, delay time.Duration, newRequest func(ctx context.Context) (*http.Request, error)) error {
+	for attempt := 0; ; attempt++ {
+		retry, err := deliverOnce(ctx, client, newRequest)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= maxRetries {
+			return err
+		}
+		log.Printf("DEBUG Delivery to sink failed, retrying in %s: %v", delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// deliverOnce sends one request and reports whether a failure is worth retrying.
+func deliverOnce(ctx context.Context, client *http.Client, newRequest func(ctx context.Context) (*http.Request, error)) (bool, error) {
+	req, err := newRequest(ctx)
+	if err != nil {
+		return false, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return false, nil
+	}
+	err = fmt.Errorf("%s returned %s: %s", req.URL.Host, resp.Status, strings.TrimSpace(string(body)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
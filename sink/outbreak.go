@@ -0,0 +1,49 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Outbreak is an alert about many similar messages that arrived within a short time,
+// such as the messages of a campaign, whatever their verdicts.
+type Outbreak struct {
+	// Event is always "outbreak", to tell the alerts from the results in a shared stream.
+	Event string `json:"event"`
+	// Kind is what the messages share: "url", "body" or "network".
+	Kind string `json:"kind"`
+	// Indicator is the shared URL, without its query, the SimHash of the body, or the
+	// network of the sending servers.
+	Indicator string `json:"indicator"`
+	// Messages is the number of similar messages within the window, and Suspicious the
+	// number of them judged suspicious.
+	Messages      int       `json:"messages"`
+	Suspicious    int       `json:"suspicious"`
+	WindowSeconds int       `json:"window_seconds"`
+	FirstSeen     time.Time `json:"first_seen"`
+	DetectedAt    time.Time `json:"detected_at"`
+	// Senders, Subjects and AnalysisIDs are samples of the messages.
+	Senders     []string `json:"senders"`
+	Subjects    []string `json:"subjects"`
+	AnalysisIDs []string `json:"analysis_ids"`
+}
+
+// OutbreakSink is implemented by the sinks that deliver outbreak alerts too.
+type OutbreakSink interface {
+	SendOutbreak(ctx context.Context, o *Outbreak) error
+}
+
+// SendOutbreak sends o to every sink that implements OutbreakSink and returns the joined
+// errors.
+func SendOutbreak(ctx context.Context, sinks []Sink, o *Outbreak) error {
+	var errs []error
+	for _, s := range sinks {
+		if target, ok := s.(OutbreakSink); ok {
+			if err := target.SendOutbreak(ctx, o); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,72 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+)
+
+var testOutbreak = &Outbreak{
+	Event:         "outbreak",
+	Kind:          "url",
+	Indicator:     "https://login.evil.example/verify",
+	Messages:      25,
+	Suspicious:    20,
+	WindowSeconds: 600,
+	FirstSeen:     time.Date(2025, 7, 1, 11, 55, 0, 0, time.UTC),
+	DetectedAt:    time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC),
+	Senders:       []string{"a@evil.example", "b@evil.example"},
+	Subjects:      []string{"Verify your account"},
+	AnalysisIDs:   []string{"0b5c3e7e-6f3a-4c44-9d7e-2f6c1a9e8b01"},
+}
+
+func TestSendOutbreak(t *testing.T) {
+	var got *Outbreak
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(SignatureHeader) == "" {
+			t.Error("outbreak alert is not signed")
+		}
+		got = &Outbreak{}
+		if err := json.Unmarshal(body, got); err != nil {
+			t.Errorf("invalid alert: %v", err)
+		}
+	}))
+	defer server.Close()
+
+	// The filters of the results do not apply to outbreaks.
+	w, err := NewWebhook(&config.Config{WebhookURL: server.URL, WebhookSecret: "s3cret", WebhookOnlySuspicious: true, WebhookMinConfidence: 0.9})
+	if err != nil {
+		t.Fatalf("NewWebhook() error = %v", err)
+	}
+	s, err := NewSyslog(&config.Config{SyslogAddress: "udp://127.0.0.1:514"})
+	if err != nil {
+		t.Fatalf("NewSyslog() error = %v", err)
+	}
+	// Sinks without outbreak alerts are skipped.
+	if err := SendOutbreak(context.Background(), []Sink{&TheHive{}, w}, testOutbreak); err != nil {
+		t.Fatalf("SendOutbreak() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, testOutbreak) {
+		t.Errorf("webhook received %+v, want %+v", got, testOutbreak)
+	}
+
+	line := s.FormatOutbreak(testOutbreak)
+	for _, want := range []string{
+		"<133>1 2025-07-01T12:00:00Z ",
+		"CEF:0|magifd2|mail-analyzer|dev|Outbreak|Outbreak of 25 similar messages|8|",
+		"cnt=25 cs1=https://login.evil.example/verify cs1Label=Indicator cs2=url cs2Label=Shared start=1751370900000 suser=a@evil.example, b@evil.example",
+	} {
+		if !strings.Contains(line, want) {
+			t.Errorf("FormatOutbreak() = %q, want it to contain %q", line, want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+// Package sink delivers analysis results to external systems such as SIEMs,
+// in addition to the report written to standard output.
+package sink
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"mail-analyzer/audit"
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+// Result is an analysis result as delivered to sinks.
+type Result struct {
+	// AnalysisID is the random UUID of the analysis, which is also in the output of
+	// mail-analyzer, to correlate the records of the result in several systems.
+	AnalysisID string   `json:"analysis_id,omitempty"`
+	SourceFile string   `json:"source_file"`
+	MessageID  string   `json:"message_id"`
+	Subject    string   `json:"subject"`
+	From       []string `json:"from"`
+	To         []string `json:"to"`
+	URLs       []string `json:"urls"`
+	// Hashes are the hex SHA-256 hashes of the attachments of the message.
+	Hashes     []string      `json:"hashes,omitempty"`
+	Judgment   *llm.Judgment `json:"judgment"`
+	Model      string        `json:"model,omitempty"`
+	Provider   string        `json:"provider,omitempty"`
+	Tenant     string        `json:"tenant,omitempty"`
+	ReceivedAt time.Time     `json:"received_at"`
+	AnalyzedAt time.Time     `json:"analyzed_at"`
+}
+
+// Sink receives analysis results.
+type Sink interface {
+	Send(ctx context.Context, result *Result) error
+	Close() error
+}
+
+// FromConfig creates the sinks enabled in cfg.
+func FromConfig(cfg *config.Config) ([]Sink, error) {
+	var sinks []Sink
+	if cfg.SyslogAddress != "" {
+		s, err := NewSyslog(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.WebhookURL != "" {
+		s, err := NewWebhook(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.SlackWebhookURL != "" {
+		s, err := NewChatAlert(cfg, ChatSlack, cfg.SlackWebhookURL)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.TeamsWebhookURL != "" {
+		s, err := NewChatAlert(cfg, ChatTeams, cfg.TeamsWebhookURL)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.TheHiveURL != "" {
+		s, err := NewTheHive(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	if cfg.SplunkHECURL != "" {
+		s, err := NewSplunkHEC(cfg)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
+
+// SendAll sends result to every sink and returns the joined errors.
+func SendAll(ctx context.Context, sinks []Sink, result *Result) error {
+	var errs []error
+	for _, s := range sinks {
+		if err := s.Send(ctx, result); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// audited records the delivery of result by the sink of type kind to target in the audit
+// log of ctx, and returns err, the error of the delivery.
+func audited(ctx context.Context, result *Result, kind, target string, err error) error {
+	audit.Record(ctx, audit.Entry{
+		AnalysisID: result.AnalysisID,
+		MessageID:  result.MessageID,
+		Tenant:     result.Tenant,
+		Action:     kind,
+		Target:     target,
+		Error:      audit.ErrorString(err),
+	})
+	return err
+}
+
+// urlHost returns the host of rawURL, which is how the audit log names the endpoints of
+// the sinks, since their URLs may hold secrets such as the tokens of chat webhooks.
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// CloseAll closes every sink and returns the joined errors.
+func CloseAll(sinks []Sink) error {
+	var errs []error
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Field returns the named field of r as a string. It is used by configurable field
+// mappings; ok is false for unknown names.
+func (r *Result) Field(name string) (value string, ok bool) {
+	j := r.Judgment
+	if j == nil {
+		j = &llm.Judgment{}
+	}
+	switch name {
+	case "analysis_id":
+		return r.AnalysisID, true
+	case "source_file":
+		return r.SourceFile, true
+	case "message_id":
+		return r.MessageID, true
+	case "subject":
+		return r.Subject, true
+	case "from":
+		return strings.Join(r.From, ", "), true
+	case "to":
+		return strings.Join(r.To, ", "), true
+	case "top_url":
+		if len(r.URLs) > 0 {
+			return r.URLs[0], true
+		}
+		return "", true
+	case "urls":
+		return strings.Join(r.URLs, " "), true
+	case "category":
+		return j.Category, true
+	case "is_suspicious":
+		return strconv.FormatBool(j.IsSuspicious), true
+	case "confidence":
+		return strconv.FormatFloat(j.ConfidenceScore, 'f', 2, 64), true
+	case "reason":
+		return j.Reason, true
+	case "model":
+		return r.Model, true
+	case "provider":
+		return r.Provider, true
+	case "tenant":
+		return r.Tenant, true
+	case "received_at":
+		return r.ReceivedAt.UTC().Format(time.RFC3339), true
+	case "analyzed_at":
+		return r.AnalyzedAt.UTC().Format(time.RFC3339), true
+	}
+	return "", false
+}
@@ -0,0 +1,168 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+)
+
+const hecEventPath = "/services/collector/event"
+
+// hecEnvelope is the envelope of a Splunk HTTP Event Collector event.
+type hecEnvelope struct {
+	Time       float64 `json:"time"`
+	Host       string  `json:"host,omitempty"`
+	Source     string  `json:"source"`
+	Sourcetype string  `json:"sourcetype,omitempty"`
+	Index      string  `json:"index,omitempty"`
+}
+
+// hecEvent is the event of a result.
+type hecEvent struct {
+	hecEnvelope
+	Event *Result `json:"event"`
+}
+
+// hecOutbreakEvent is the event of an outbreak alert.
+type hecOutbreakEvent struct {
+	hecEnvelope
+	Event *Outbreak `json:"event"`
+}
+
+// SplunkHEC sends results to a Splunk HTTP Event Collector. Events are batched
+// and the batch is sent when it is full or the sink is closed. Failed requests
+// are retried with exponential backoff when the error is transient.
+type SplunkHEC struct {
+	client     *http.Client
+	url        string
+	token      string
+	index      string
+	sourcetype string
+	host       string
+	batchSize  int
+	maxRetries int
+	retryDelay time.Duration
+
+	mu sync.Mutex
+	// batch holds hecEvent and hecOutbreakEvent values.
+	batch []any
+}
+
+// NewSplunkHEC creates a Splunk HEC sink from the splunk_* settings in cfg.
+func NewSplunkHEC(cfg *config.Config) (*SplunkHEC, error) {
+	if cfg.SplunkHECToken == "" {
+		return nil, errors.New("splunk_hec_token is required when splunk_hec_url is set")
+	}
+	client, err := httpclient.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimRight(cfg.SplunkHECURL, "/")
+	if !strings.HasSuffix(url, hecEventPath) {
+		url += hecEventPath
+	}
+	s := &SplunkHEC{
+		client:     client,
+		url:        url,
+		token:      cfg.SplunkHECToken,
+		index:      cfg.SplunkIndex,
+		sourcetype: cfg.SplunkSourcetype,
+		batchSize:  cfg.SplunkBatchSize,
+		maxRetries: cfg.SplunkMaxRetries,
+		retryDelay: defaultRetryDelay,
+	}
+	if s.batchSize < 1 {
+		s.batchSize = 1
+	}
+	s.host, _ = os.Hostname()
+	return s, nil
+}
+
+// Send implements Sink.
+func (s *SplunkHEC) Send(ctx context.Context, result *Result) error {
+	analyzedAt := result.AnalyzedAt
+	if analyzedAt.IsZero() {
+		analyzedAt = time.Now()
+	}
+
+	return s.add(ctx, hecEvent{hecEnvelope: s.envelope(analyzedAt), Event: result})
+}
+
+// SendOutbreak implements OutbreakSink. The alert is sent at once, with the buffered
+// events.
+func (s *SplunkHEC) SendOutbreak(ctx context.Context, o *Outbreak) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch = append(s.batch, hecOutbreakEvent{hecEnvelope: s.envelope(o.DetectedAt), Event: o})
+	return s.flush(ctx)
+}
+
+func (s *SplunkHEC) envelope(t time.Time) hecEnvelope {
+	return hecEnvelope{
+		Time:       float64(t.UnixMilli()) / 1000,
+		Host:       s.host,
+		Source:     "mail-analyzer",
+		Sourcetype: s.sourcetype,
+		Index:      s.index,
+	}
+}
+
+// add buffers event, and sends the batch once it is full.
+func (s *SplunkHEC) add(ctx context.Context, event any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.batch = append(s.batch, event)
+	if len(s.batch) < s.batchSize {
+		return nil
+	}
+	return s.flush(ctx)
+}
+
+// Close implements Sink by sending any buffered events.
+func (s *SplunkHEC) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flush(context.Background())
+}
+
+// flush sends the buffered events. The caller must hold s.mu.
+func (s *SplunkHEC) flush(ctx context.Context) error {
+	if len(s.batch) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	enc := json.NewEncoder(&body)
+	for _, e := range s.batch {
+		if err := enc.Encode(e); err != nil {
+			return fmt.Errorf("splunk: could not encode event: %w", err)
+		}
+	}
+
+	err := deliver(ctx, s.client, s.maxRetries, s.retryDelay, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", s.url, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Authorization", "Splunk "+s.token)
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
+	// The batch is dropped either way so that one bad event does not block later ones.
+	dropped := len(s.batch)
+	s.batch = s.batch[:0]
+	if err != nil {
+		return fmt.Errorf("splunk: %d events not delivered: %w", dropped, err)
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package sink
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+func TestSplunkHEC_Batching(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]hecEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/services/collector/event" || r.Header.Get("Authorization") != "Splunk secret-token" {
+			t.Errorf("unexpected request: %s %v", r.URL.Path, r.Header)
+		}
+		body, _ := io.ReadAll(r.Body)
+		var batch []hecEvent
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		for scanner.Scan() {
+			var e hecEvent
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				t.Errorf("invalid event: %v", err)
+			}
+			batch = append(batch, e)
+		}
+		mu.Lock()
+		batches = append(batches, batch)
+		mu.Unlock()
+		w.Write([]byte(`{"text": "Success", "code": 0}`))
+	}))
+	defer server.Close()
+
+	s, err := NewSplunkHEC(&config.Config{SplunkHECURL: server.URL + "/", SplunkHECToken: "secret-token", SplunkIndex: "mail", SplunkSourcetype: "mail-analyzer:result", SplunkBatchSize: 2})
+	if err != nil {
+		t.Fatalf("NewSplunkHEC() error = %v", err)
+	}
+	for _, id := range []string{"<1@example.com>", "<2@example.com>", "<3@example.com>"} {
+		if err := s.Send(context.Background(), &Result{MessageID: id, Judgment: &llm.Judgment{Category: "Spam"}}); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+	if len(batches) != 1 {
+		t.Fatalf("expected 1 batch before Close, got %d", len(batches))
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if len(batches) != 2 || len(batches[0]) != 2 || len(batches[1]) != 1 {
+		t.Fatalf("unexpected batches: %+v", batches)
+	}
+	e := batches[1][0]
+	if e.Index != "mail" || e.Sourcetype != "mail-analyzer:result" || e.Event.MessageID != "<3@example.com>" || e.Event.Judgment.Category != "Spam" {
+		t.Errorf("unexpected event: %+v", e)
+	}
+}
+
+func TestSplunkHEC_Retry(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		wantRequests int
+		wantErr      bool
+	}{
+		{name: "Transient failure", statuses: []int{503, 429, 200}, wantRequests: 3},
+		{name: "Retries exhausted", statuses: []int{503, 503, 503, 503}, wantRequests: 3, wantErr: true},
+		{name: "Invalid token is not retried", statuses: []int{403}, wantRequests: 1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(tt.statuses[requests])
+				requests++
+				w.Write([]byte(`{"text": "error", "code": 9}`))
+			}))
+			defer server.Close()
+
+			s, err := NewSplunkHEC(&config.Config{SplunkHECURL: server.URL, SplunkHECToken: "t", SplunkMaxRetries: 2})
+			if err != nil {
+				t.Fatalf("NewSplunkHEC() error = %v", err)
+			}
+			s.retryDelay = 0
+			err = s.Send(context.Background(), &Result{MessageID: "<1@example.com>"})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Send() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("expected %d requests, got %d", tt.wantRequests, requests)
+			}
+		})
+	}
+
+	if _, err := NewSplunkHEC(&config.Config{SplunkHECURL: "http://localhost:8088"}); err == nil {
+		t.Error("expected an error without a token")
+	}
+}
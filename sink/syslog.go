@@ -0,0 +1,309 @@
+package sink
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+)
+
+// Syslog event formats.
+const (
+	FormatCEF  = "cef"
+	FormatLEEF = "leef"
+)
+
+// DefaultCEFMapping maps CEF extension keys to result fields. A value starting
+// with "=" is a literal, which is how the custom string labels are set.
+var DefaultCEFMapping = map[string]string{
+	"fname":     "source_file",
+	"cs1":       "message_id",
+	"cs1Label":  "=MessageID",
+	"suser":     "from",
+	"duser":     "to",
+	"cs2":       "subject",
+	"cs2Label":  "=Subject",
+	"cat":       "category",
+	"cfp1":      "confidence",
+	"cfp1Label": "=Confidence",
+	"msg":       "reason",
+	"request":   "top_url",
+}
+
+// DefaultLEEFMapping maps LEEF attribute keys to result fields.
+var DefaultLEEFMapping = map[string]string{
+	"fname":      "source_file",
+	"messageId":  "message_id",
+	"suser":      "from",
+	"duser":      "to",
+	"subject":    "subject",
+	"cat":        "category",
+	"confidence": "confidence",
+	"reason":     "reason",
+	"url":        "top_url",
+}
+
+const (
+	syslogFacility = 16 // local0
+	syslogAppName  = "mail-analyzer"
+	vendor         = "magifd2"
+	product        = "mail-analyzer"
+)
+
+// Version is reported as the product version in CEF and LEEF headers.
+var Version = "dev"
+
+// Syslog sends one CEF or LEEF event per result over UDP, TCP or TLS, using the
+// RFC 5424 header and newline framing.
+type Syslog struct {
+	network   string
+	address   string
+	format    string
+	mapping   map[string]string
+	tlsConfig *tls.Config
+	timeout   time.Duration
+	hostname  string
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+// NewSyslog creates a syslog sink from the syslog_* settings in cfg.
+// The address has the form udp://host:port, tcp://host:port or tls://host:port.
+func NewSyslog(cfg *config.Config) (*Syslog, error) {
+	u, err := url.Parse(cfg.SyslogAddress)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog address: %w", err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid syslog address %q: expected scheme://host:port", cfg.SyslogAddress)
+	}
+
+	s := &Syslog{address: u.Host, format: strings.ToLower(cfg.SyslogFormat), timeout: time.Duration(cfg.ConnectTimeout)}
+	switch u.Scheme {
+	case "udp", "tcp":
+		s.network = u.Scheme
+	case "tls":
+		s.network = "tcp"
+		s.tlsConfig, err = httpclient.TLSConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported syslog scheme %q", u.Scheme)
+	}
+
+	switch s.format {
+	case "", FormatCEF:
+		s.format = FormatCEF
+		s.mapping = DefaultCEFMapping
+	case FormatLEEF:
+		s.mapping = DefaultLEEFMapping
+	default:
+		return nil, fmt.Errorf("unsupported syslog format %q", cfg.SyslogFormat)
+	}
+	if len(cfg.SyslogFieldMapping) > 0 {
+		s.mapping = cfg.SyslogFieldMapping
+	}
+	probe := &Result{}
+	for key, field := range s.mapping {
+		if _, ok := probe.Field(field); !ok && !strings.HasPrefix(field, "=") {
+			return nil, fmt.Errorf("syslog field mapping %q: unknown field %q", key, field)
+		}
+	}
+
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	return s, nil
+}
+
+// Send implements Sink. A broken connection is re-established once.
+func (s *Syslog) Send(ctx context.Context, result *Result) error {
+	return s.write(ctx, s.Format(result)+"\n")
+}
+
+// SendOutbreak implements OutbreakSink.
+func (s *Syslog) SendOutbreak(ctx context.Context, o *Outbreak) error {
+	return s.write(ctx, s.FormatOutbreak(o)+"\n")
+}
+
+func (s *Syslog) write(ctx context.Context, line string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var err error
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.conn == nil {
+			if s.conn, err = s.dial(ctx); err != nil {
+				return fmt.Errorf("syslog: %w", err)
+			}
+		}
+		if _, err = s.conn.Write([]byte(line)); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	return fmt.Errorf("syslog: %w", err)
+}
+
+func (s *Syslog) dial(ctx context.Context) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: s.timeout}
+	if s.tlsConfig != nil {
+		return (&tls.Dialer{NetDialer: dialer, Config: s.tlsConfig}).DialContext(ctx, s.network, s.address)
+	}
+	return dialer.DialContext(ctx, s.network, s.address)
+}
+
+// Close implements Sink.
+func (s *Syslog) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
+
+// Format returns the syslog line for result, without the trailing newline.
+func (s *Syslog) Format(result *Result) string {
+	timestamp := result.AnalyzedAt
+	if timestamp.IsZero() {
+		timestamp = time.Now()
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ", syslogFacility*8+syslogSeverity(result), timestamp.UTC().Format(time.RFC3339), s.hostname, syslogAppName, os.Getpid())
+	if s.format == FormatLEEF {
+		return header + s.leef(result)
+	}
+	return header + s.cef(result)
+}
+
+// outbreakSeverity is the CEF/LEEF severity of outbreak alerts.
+const outbreakSeverity = 8
+
+// FormatOutbreak returns the syslog line for o, without the trailing newline. The field
+// mapping only applies to results, so the fields of outbreaks are fixed.
+func (s *Syslog) FormatOutbreak(o *Outbreak) string {
+	// Outbreaks are notices, between the warnings of suspicious messages and the
+	// informational results of the others.
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ", syslogFacility*8+5, o.DetectedAt.UTC().Format(time.RFC3339), s.hostname, syslogAppName, os.Getpid())
+	name := fmt.Sprintf("Outbreak of %d similar messages", o.Messages)
+	fields := [][2]string{
+		{"cat", "Outbreak"},
+		{"cnt", strconv.Itoa(o.Messages)},
+		{"cs1", o.Indicator},
+		{"cs1Label", "Indicator"},
+		{"cs2", o.Kind},
+		{"cs2Label", "Shared"},
+		{"start", strconv.FormatInt(o.FirstSeen.UnixMilli(), 10)},
+		{"suser", strings.Join(o.Senders, ", ")},
+	}
+	if s.format == FormatLEEF {
+		attrs := []string{fmt.Sprintf("sev=%d", outbreakSeverity)}
+		for _, f := range fields {
+			attrs = append(attrs, f[0]+"="+leefEscaper.Replace(f[1]))
+		}
+		return header + fmt.Sprintf("LEEF:1.0|%s|%s|%s|Outbreak|%s",
+			leefEscaper.Replace(vendor), leefEscaper.Replace(product), leefEscaper.Replace(Version), strings.Join(attrs, "\t"))
+	}
+	var ext []string
+	for _, f := range fields {
+		ext = append(ext, f[0]+"="+cefExtensionEscaper.Replace(f[1]))
+	}
+	return header + fmt.Sprintf("CEF:0|%s|%s|%s|Outbreak|%s|%d|%s",
+		cefHeaderEscaper.Replace(vendor), cefHeaderEscaper.Replace(product), cefHeaderEscaper.Replace(Version),
+		cefHeaderEscaper.Replace(name), outbreakSeverity, strings.Join(ext, " "))
+}
+
+// cef formats result as an ArcSight Common Event Format event.
+func (s *Syslog) cef(result *Result) string {
+	category, _ := result.Field("category")
+	if category == "" {
+		category = "Unknown"
+	}
+	var ext []string
+	for _, key := range sortedKeys(s.mapping) {
+		ext = append(ext, key+"="+cefExtensionEscaper.Replace(s.value(result, key)))
+	}
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefHeaderEscaper.Replace(vendor), cefHeaderEscaper.Replace(product), cefHeaderEscaper.Replace(Version),
+		cefHeaderEscaper.Replace(category), cefHeaderEscaper.Replace("Email classified as "+category),
+		eventSeverity(result), strings.Join(ext, " "))
+}
+
+// leef formats result as an IBM QRadar Log Event Extended Format 1.0 event.
+func (s *Syslog) leef(result *Result) string {
+	category, _ := result.Field("category")
+	if category == "" {
+		category = "Unknown"
+	}
+	attrs := []string{fmt.Sprintf("sev=%d", eventSeverity(result))}
+	for _, key := range sortedKeys(s.mapping) {
+		attrs = append(attrs, key+"="+leefEscaper.Replace(s.value(result, key)))
+	}
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		leefEscaper.Replace(vendor), leefEscaper.Replace(product), leefEscaper.Replace(Version),
+		leefEscaper.Replace(category), strings.Join(attrs, "\t"))
+}
+
+func (s *Syslog) value(result *Result, key string) string {
+	field := s.mapping[key]
+	if literal, ok := strings.CutPrefix(field, "="); ok {
+		return literal
+	}
+	v, _ := result.Field(field)
+	return v
+}
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+	leefEscaper         = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ", "|", " ")
+)
+
+// eventSeverity maps a judgment to the 0-10 CEF/LEEF severity scale.
+func eventSeverity(result *Result) int {
+	j := result.Judgment
+	if j == nil || !j.IsSuspicious {
+		return 0
+	}
+	sev := int(j.ConfidenceScore*10 + 0.5)
+	if sev < 1 {
+		sev = 1
+	}
+	if sev > 10 {
+		sev = 10
+	}
+	return sev
+}
+
+// syslogSeverity maps a judgment to a syslog severity: warning for suspicious
+// messages and informational otherwise.
+func syslogSeverity(result *Result) int {
+	if result.Judgment != nil && result.Judgment.IsSuspicious {
+		return 4
+	}
+	return 6
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,133 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+var testResult = &Result{
+	SourceFile: "mail.eml",
+	MessageID:  "<1@example.com>",
+	Subject:    "Verify | your=account",
+	From:       []string{"attacker@example.com"},
+	To:         []string{"user@example.com"},
+	URLs:       []string{"http://evil.example.com/login"},
+	Judgment:   &llm.Judgment{IsSuspicious: true, Category: "Phishing", Reason: "Fake login\npage.", ConfidenceScore: 0.87},
+	AnalyzedAt: time.Date(2025, 7, 1, 12, 0, 0, 0, time.UTC),
+}
+
+func TestSyslog_Format(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     config.Config
+		want    []string
+		wantErr bool
+	}{
+		{
+			name: "CEF with default mapping",
+			cfg:  config.Config{SyslogAddress: "udp://127.0.0.1:514"},
+			want: []string{
+				`<132>1 2025-07-01T12:00:00Z `,
+				`CEF:0|magifd2|mail-analyzer|dev|Phishing|Email classified as Phishing|9|`,
+				`cs2=Verify | your\=account`,
+				`cs2Label=Subject`,
+				`msg=Fake login\npage.`,
+				`request=http://evil.example.com/login`,
+			},
+		},
+		{
+			name: "LEEF with default mapping",
+			cfg:  config.Config{SyslogAddress: "tcp://127.0.0.1:514", SyslogFormat: "leef"},
+			want: []string{
+				"LEEF:1.0|magifd2|mail-analyzer|dev|Phishing|sev=9\t",
+				"\tsubject=Verify   your=account",
+				"\treason=Fake login page.",
+			},
+		},
+		{
+			name: "Custom mapping",
+			cfg:  config.Config{SyslogAddress: "udp://127.0.0.1:514", SyslogFieldMapping: map[string]string{"suser": "from", "cs1": "subject", "cs1Label": "=Mail Subject"}},
+			want: []string{`|9|cs1=Verify | your\=account cs1Label=Mail Subject suser=attacker@example.com`},
+		},
+		{name: "Unknown field", cfg: config.Config{SyslogAddress: "udp://127.0.0.1:514", SyslogFieldMapping: map[string]string{"suser": "sender"}}, wantErr: true},
+		{name: "Unknown format", cfg: config.Config{SyslogAddress: "udp://127.0.0.1:514", SyslogFormat: "json"}, wantErr: true},
+		{name: "Unknown scheme", cfg: config.Config{SyslogAddress: "http://127.0.0.1:514"}, wantErr: true},
+		{name: "Missing scheme", cfg: config.Config{SyslogAddress: "127.0.0.1:514"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := NewSyslog(&tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewSyslog() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := s.Format(testResult)
+			if strings.Contains(got, "\n") {
+				t.Errorf("event contains a newline: %q", got)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("Format() = %q, want it to contain %q", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSyslog_SendTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 2)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		scanner := bufio.NewScanner(conn)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	s, err := NewSyslog(&config.Config{SyslogAddress: "tcp://" + ln.Addr().String()})
+	if err != nil {
+		t.Fatalf("NewSyslog() error = %v", err)
+	}
+	defer s.Close()
+	safe := &Result{MessageID: "<2@example.com>", Judgment: &llm.Judgment{Category: "Safe"}}
+	for _, r := range []*Result{testResult, safe} {
+		if err := s.Send(context.Background(), r); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	wants := []*regexp.Regexp{
+		regexp.MustCompile(`^<132>1 \S+ \S+ mail-analyzer \d+ - - CEF:0\|.*\|Phishing\|.*\|9\|`),
+		regexp.MustCompile(`^<134>1 .*\|Safe\|Email classified as Safe\|0\|`),
+	}
+	for _, want := range wants {
+		select {
+		case line := <-lines:
+			if !want.MatchString(line) {
+				t.Errorf("received %q, want match for %s", line, want)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("timed out waiting for syslog event")
+		}
+	}
+}
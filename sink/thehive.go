@@ -0,0 +1,175 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+)
+
+// TheHive opens an alert in TheHive 5 for messages of the configured categories with at
+// least the configured confidence. The sender, subject and URLs become observables, so
+// they can be enriched by Cortex analyzers from the alert.
+type TheHive struct {
+	client        *http.Client
+	url           string
+	apiKey        string
+	organisation  string
+	categories    []string
+	minConfidence float64
+	maxRetries    int
+	retryDelay    time.Duration
+}
+
+// NewTheHive creates a TheHive sink from the thehive_* settings in cfg.
+func NewTheHive(cfg *config.Config) (*TheHive, error) {
+	if cfg.TheHiveAPIKey == "" {
+		return nil, errors.New("thehive_api_key is required")
+	}
+	client, err := httpclient.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return &TheHive{
+		client:        client,
+		url:           strings.TrimRight(cfg.TheHiveURL, "/") + "/api/v1/alert",
+		apiKey:        cfg.TheHiveAPIKey,
+		organisation:  cfg.TheHiveOrganisation,
+		categories:    cfg.TheHiveCategories,
+		minConfidence: cfg.TheHiveMinConfidence,
+		maxRetries:    2,
+		retryDelay:    defaultRetryDelay,
+	}, nil
+}
+
+// theHiveAlert is the body of POST /api/v1/alert.
+type theHiveAlert struct {
+	Type        string              `json:"type"`
+	Source      string              `json:"source"`
+	SourceRef   string              `json:"sourceRef"`
+	Title       string              `json:"title"`
+	Description string              `json:"description"`
+	Severity    int                 `json:"severity"`
+	Tags        []string            `json:"tags"`
+	Observables []theHiveObservable `json:"observables"`
+}
+
+type theHiveObservable struct {
+	DataType string `json:"dataType"`
+	Data     string `json:"data"`
+}
+
+// Send implements Sink.
+func (t *TheHive) Send(ctx context.Context, result *Result) error {
+	if !t.matches(result) {
+		return nil
+	}
+	alert := newTheHiveAlert(result)
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return fmt.Errorf("thehive: %w", err)
+	}
+
+	err = deliver(ctx, t.client, t.maxRetries, t.retryDelay, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", t.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer "+t.apiKey)
+		if t.organisation != "" {
+			req.Header.Set("X-Organisation", t.organisation)
+		}
+		return req, nil
+	})
+	if err != nil {
+		err = fmt.Errorf("thehive: %w", err)
+	}
+	return audited(ctx, result, "thehive", fmt.Sprintf("alert %s on %s", alert.SourceRef, urlHost(t.url)), err)
+}
+
+// Close implements Sink.
+func (t *TheHive) Close() error {
+	return nil
+}
+
+func (t *TheHive) matches(result *Result) bool {
+	j := result.Judgment
+	if j == nil || j.ConfidenceScore < t.minConfidence {
+		return false
+	}
+	for _, c := range t.categories {
+		if strings.EqualFold(c, j.Category) {
+			return true
+		}
+	}
+	return false
+}
+
+// newTheHiveAlert returns the alert created for result. The sourceRef is derived from the
+// Message-ID, so that TheHive rejects a second alert for the same message.
+func newTheHiveAlert(result *Result) theHiveAlert {
+	j := result.Judgment
+	ref := result.MessageID
+	if ref == "" {
+		ref = result.SourceFile + "\x00" + result.AnalyzedAt.String()
+	}
+	sum := sha256.Sum256([]byte(ref))
+
+	var observables []theHiveObservable
+	seen := map[string]bool{}
+	add := func(dataType, data string) {
+		key := dataType + "\x00" + data
+		if data == "" || seen[key] {
+			return
+		}
+		seen[key] = true
+		observables = append(observables, theHiveObservable{DataType: dataType, Data: data})
+	}
+	for _, from := range result.From {
+		if addr, err := mail.ParseAddress(from); err == nil {
+			add("mail", addr.Address)
+		}
+	}
+	add("mail-subject", result.Subject)
+	for _, u := range result.URLs {
+		add("url", u)
+		if parsed, err := url.Parse(u); err == nil {
+			add("domain", parsed.Hostname())
+		}
+	}
+	if observables == nil {
+		observables = []theHiveObservable{}
+	}
+
+	return theHiveAlert{
+		Type:      "mail-analyzer",
+		Source:    "mail-analyzer",
+		SourceRef: hex.EncodeToString(sum[:8]),
+		Title:     fmt.Sprintf("%s email: %s", j.Category, result.Subject),
+		Description: fmt.Sprintf("**Verdict:** %s (confidence %.2f)\n\n**From:** %s\n\n**To:** %s\n\n**Message-ID:** %s\n\n**Reason:** %s",
+			j.Category, j.ConfidenceScore, strings.Join(result.From, ", "), strings.Join(result.To, ", "), result.MessageID, j.Reason),
+		Severity:    theHiveSeverity(j.ConfidenceScore),
+		Tags:        []string{"mail-analyzer", "category:" + strings.ToLower(j.Category)},
+		Observables: observables,
+	}
+}
+
+// theHiveSeverity maps a confidence score to TheHive's severity (2 = medium, 3 = high).
+func theHiveSeverity(confidence float64) int {
+	if confidence >= 0.9 {
+		return 3
+	}
+	return 2
+}
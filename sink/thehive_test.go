@@ -0,0 +1,74 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+func TestTheHive_Send(t *testing.T) {
+	var alerts []theHiveAlert
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v1/alert" {
+			t.Errorf("unexpected path %s", r.URL.Path)
+		}
+		headers = r.Header
+		var alert theHiveAlert
+		json.NewDecoder(r.Body).Decode(&alert)
+		alerts = append(alerts, alert)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	h, err := NewTheHive(&config.Config{
+		TheHiveURL: server.URL + "/", TheHiveAPIKey: "key", TheHiveOrganisation: "soc",
+		TheHiveCategories: []string{"Phishing"}, TheHiveMinConfidence: 0.8,
+	})
+	if err != nil {
+		t.Fatalf("NewTheHive() error = %v", err)
+	}
+	results := []*Result{
+		{
+			MessageID: "<1@example.com>",
+			Subject:   "Verify now",
+			From:      []string{`"Bank" <attacker@evil.example.com>`},
+			URLs:      []string{"https://evil.example.com/login", "https://evil.example.com/reset"},
+			Judgment:  &llm.Judgment{IsSuspicious: true, Category: "phishing", Reason: "Fake login.", ConfidenceScore: 0.95},
+		},
+		{Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.5}},
+		{Judgment: &llm.Judgment{IsSuspicious: true, Category: "Spam", ConfidenceScore: 0.99}},
+	}
+	for _, r := range results {
+		if err := h.Send(context.Background(), r); err != nil {
+			t.Fatalf("Send() error = %v", err)
+		}
+	}
+
+	if len(alerts) != 1 {
+		t.Fatalf("expected 1 alert, got %d", len(alerts))
+	}
+	if headers.Get("Authorization") != "Bearer key" || headers.Get("X-Organisation") != "soc" {
+		t.Errorf("unexpected headers: %v", headers)
+	}
+	alert := alerts[0]
+	if alert.Title != "phishing email: Verify now" || alert.Severity != 3 || len(alert.SourceRef) != 16 {
+		t.Errorf("unexpected alert: %+v", alert)
+	}
+	want := []theHiveObservable{
+		{DataType: "mail", Data: "attacker@evil.example.com"},
+		{DataType: "mail-subject", Data: "Verify now"},
+		{DataType: "url", Data: "https://evil.example.com/login"},
+		{DataType: "domain", Data: "evil.example.com"},
+		{DataType: "url", Data: "https://evil.example.com/reset"},
+	}
+	if !reflect.DeepEqual(alert.Observables, want) {
+		t.Errorf("observables = %+v, want %+v", alert.Observables, want)
+	}
+}
@@ -0,0 +1,158 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"text/template"
+	"time"
+
+	"mail-analyzer/config"
+	"mail-analyzer/httpclient"
+)
+
+// Webhook signature headers. The signature is the hex HMAC-SHA256 of
+// "<timestamp>.<body>" with the shared secret, so receivers can reject replays.
+const (
+	SignatureHeader = "X-Mail-Analyzer-Signature"
+	TimestampHeader = "X-Mail-Analyzer-Timestamp"
+)
+
+// Webhook POSTs results as JSON to a URL, optionally only for suspicious messages.
+type Webhook struct {
+	client         *http.Client
+	url            string
+	secret         []byte
+	tmpl           *template.Template
+	minConfidence  float64
+	onlySuspicious bool
+	maxRetries     int
+	retryDelay     time.Duration
+	now            func() time.Time
+}
+
+// NewWebhook creates a webhook sink from the webhook_* settings in cfg.
+func NewWebhook(cfg *config.Config) (*Webhook, error) {
+	client, err := httpclient.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	w := &Webhook{
+		client:         client,
+		url:            cfg.WebhookURL,
+		secret:         []byte(cfg.WebhookSecret),
+		minConfidence:  cfg.WebhookMinConfidence,
+		onlySuspicious: cfg.WebhookOnlySuspicious,
+		maxRetries:     cfg.WebhookMaxRetries,
+		retryDelay:     defaultRetryDelay,
+		now:            time.Now,
+	}
+	if cfg.WebhookTemplate != "" {
+		text, err := os.ReadFile(cfg.WebhookTemplate)
+		if err != nil {
+			return nil, fmt.Errorf("could not read webhook template: %w", err)
+		}
+		w.tmpl, err = template.New("webhook").Funcs(template.FuncMap{"json": toJSON}).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return nil, fmt.Errorf("invalid webhook template: %w", err)
+		}
+	}
+	return w, nil
+}
+
+// Send implements Sink.
+func (w *Webhook) Send(ctx context.Context, result *Result) error {
+	if !w.wants(result) {
+		return nil
+	}
+	body, err := w.body(result)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return audited(ctx, result, "webhook", urlHost(w.url), w.post(ctx, body))
+}
+
+// SendOutbreak implements OutbreakSink. Outbreak alerts are sent as JSON, without the
+// template and the filters of the results.
+func (w *Webhook) SendOutbreak(ctx context.Context, o *Outbreak) error {
+	body, err := json.Marshal(o)
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return w.post(ctx, body)
+}
+
+// post sends body, signed with the secret if there is one.
+func (w *Webhook) post(ctx context.Context, body []byte) error {
+	err := deliver(ctx, w.client, w.maxRetries, w.retryDelay, func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", w.url, bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		if len(w.secret) > 0 {
+			timestamp := strconv.FormatInt(w.now().Unix(), 10)
+			req.Header.Set(TimestampHeader, timestamp)
+			req.Header.Set(SignatureHeader, "sha256="+Sign(w.secret, timestamp, body))
+		}
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("webhook: %w", err)
+	}
+	return nil
+}
+
+// Close implements Sink.
+func (w *Webhook) Close() error {
+	return nil
+}
+
+// wants reports whether result passes the webhook filters.
+func (w *Webhook) wants(result *Result) bool {
+	j := result.Judgment
+	if j == nil {
+		return !w.onlySuspicious && w.minConfidence == 0
+	}
+	if w.onlySuspicious && !j.IsSuspicious {
+		return false
+	}
+	return j.ConfidenceScore >= w.minConfidence
+}
+
+// body renders the request body with the template, or encodes result as JSON without one.
+func (w *Webhook) body(result *Result) ([]byte, error) {
+	if w.tmpl == nil {
+		return json.Marshal(result)
+	}
+	var buf bytes.Buffer
+	if err := w.tmpl.Execute(&buf, result); err != nil {
+		return nil, fmt.Errorf("could not render template: %w", err)
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template did not produce valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+// Sign returns the hex HMAC-SHA256 signature of a webhook body.
+func Sign(secret []byte, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// toJSON encodes v as JSON so that templates can embed untrusted values safely.
+func toJSON(v any) (string, error) {
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,178 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"mail-analyzer/audit"
+	"mail-analyzer/config"
+	"mail-analyzer/llm"
+)
+
+func TestWebhook_Send(t *testing.T) {
+	phishing := &Result{MessageID: "<1@example.com>", Subject: `Say "hi"`, Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.9}}
+	lowSpam := &Result{MessageID: "<2@example.com>", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Spam", ConfidenceScore: 0.4}}
+	safe := &Result{MessageID: "<3@example.com>", Judgment: &llm.Judgment{Category: "Safe", ConfidenceScore: 0.95}}
+
+	templatePath := filepath.Join(t.TempDir(), "body.tmpl")
+	os.WriteFile(templatePath, []byte(`{"text": {{json .Subject}}, "severity": "{{.Judgment.Category}}"}`), 0600)
+
+	tests := []struct {
+		name     string
+		cfg      config.Config
+		results  []*Result
+		wantIDs  []string
+		wantBody string
+	}{
+		{name: "All results", results: []*Result{phishing, safe}, wantIDs: []string{"<1@example.com>", "<3@example.com>"}},
+		{name: "Only suspicious", cfg: config.Config{WebhookOnlySuspicious: true}, results: []*Result{phishing, lowSpam, safe}, wantIDs: []string{"<1@example.com>", "<2@example.com>"}},
+		{name: "Suspicious above threshold", cfg: config.Config{WebhookOnlySuspicious: true, WebhookMinConfidence: 0.5}, results: []*Result{phishing, lowSpam, safe}, wantIDs: []string{"<1@example.com>"}},
+		{name: "Template", cfg: config.Config{WebhookTemplate: templatePath}, results: []*Result{phishing}, wantBody: `{"text": "Say \"hi\"", "severity": "Phishing"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodies []string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				body, _ := io.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+			}))
+			defer server.Close()
+
+			cfg := tt.cfg
+			cfg.WebhookURL = server.URL
+			w, err := NewWebhook(&cfg)
+			if err != nil {
+				t.Fatalf("NewWebhook() error = %v", err)
+			}
+			for _, r := range tt.results {
+				if err := w.Send(context.Background(), r); err != nil {
+					t.Fatalf("Send() error = %v", err)
+				}
+			}
+
+			if tt.wantBody != "" {
+				if len(bodies) != 1 || bodies[0] != tt.wantBody {
+					t.Errorf("bodies = %v, want %s", bodies, tt.wantBody)
+				}
+				return
+			}
+			var ids []string
+			for _, b := range bodies {
+				var r Result
+				json.Unmarshal([]byte(b), &r)
+				ids = append(ids, r.MessageID)
+			}
+			if len(ids) != len(tt.wantIDs) {
+				t.Fatalf("sent %v, want %v", ids, tt.wantIDs)
+			}
+			for i := range ids {
+				if ids[i] != tt.wantIDs[i] {
+					t.Errorf("sent %v, want %v", ids, tt.wantIDs)
+				}
+			}
+		})
+	}
+}
+
+func TestWebhook_Signature(t *testing.T) {
+	now := time.Unix(1751371200, 0)
+	var got *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	w, err := NewWebhook(&config.Config{WebhookURL: server.URL, WebhookSecret: "s3cret"})
+	if err != nil {
+		t.Fatalf("NewWebhook() error = %v", err)
+	}
+	w.now = func() time.Time { return now }
+	if err := w.Send(context.Background(), &Result{MessageID: "<1@example.com>"}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	timestamp := got.Header.Get(TimestampHeader)
+	if timestamp != strconv.FormatInt(now.Unix(), 10) {
+		t.Errorf("timestamp header = %q", timestamp)
+	}
+	want := "sha256=" + Sign([]byte("s3cret"), timestamp, body)
+	if got.Header.Get(SignatureHeader) != want {
+		t.Errorf("signature header = %q, want %q", got.Header.Get(SignatureHeader), want)
+	}
+	// Known-answer check so that receivers in other languages can verify the scheme.
+	// printf '1.{}' | openssl dgst -sha256 -hmac key
+	if sig := Sign([]byte("key"), "1", []byte("{}")); sig != "1ba6b8171186efc613e8bcc0cbdab2748f24984d7c5a84faa2637afa0e40d224" {
+		t.Errorf("Sign() = %s", sig)
+	}
+}
+
+func TestWebhook_Retry(t *testing.T) {
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests < 3 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer server.Close()
+
+	w, _ := NewWebhook(&config.Config{WebhookURL: server.URL, WebhookMaxRetries: 3})
+	w.retryDelay = 0
+	if err := w.Send(context.Background(), &Result{}); err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+	if requests != 3 {
+		t.Errorf("expected 3 requests, got %d", requests)
+	}
+}
+
+func TestWebhook_Audit(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	logPath := filepath.Join(t.TempDir(), "audit.jsonl")
+	l, _ := audit.Open(logPath)
+
+	w, _ := NewWebhook(&config.Config{WebhookURL: server.URL + "/hooks/s3cret-token", WebhookOnlySuspicious: true})
+	ctx := audit.WithLog(context.Background(), l)
+	w.Send(ctx, &Result{AnalysisID: "a1", MessageID: "<1@example.com>", Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing"}})
+	w.Send(ctx, &Result{AnalysisID: "a2", Judgment: &llm.Judgment{Category: "Safe"}})
+	l.Close()
+
+	data, _ := os.ReadFile(logPath)
+	entries, _ := audit.Read(bytes.NewReader(data))
+	if len(entries) != 1 {
+		t.Fatalf("audit log = %s, want the one result sent", data)
+	}
+	if e := entries[0]; e.AnalysisID != "a1" || e.Action != "webhook" || e.Target != strings.TrimPrefix(server.URL, "http://") {
+		t.Errorf("audit entry = %+v, want the host of the webhook", e)
+	}
+	if strings.Contains(string(data), "s3cret") {
+		t.Errorf("audit log holds the path of the webhook URL: %s", data)
+	}
+}
+
+func TestWebhook_InvalidTemplateOutput(t *testing.T) {
+	templatePath := filepath.Join(t.TempDir(), "body.tmpl")
+	os.WriteFile(templatePath, []byte(`{"text": "{{.Subject}}"}`), 0600)
+	w, err := NewWebhook(&config.Config{WebhookURL: "http://127.0.0.1:1", WebhookTemplate: templatePath})
+	if err != nil {
+		t.Fatalf("NewWebhook() error = %v", err)
+	}
+	// Unescaped quotes in the subject break the JSON; the request must not be sent.
+	if err := w.Send(context.Background(), &Result{Subject: `"}`}); err == nil {
+		t.Error("expected an error for invalid JSON")
+	}
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"mail-analyzer/llm"
+)
+
+// maxSummaryTop is the number of sender domains, URL domains and URLs listed in a Summary.
+const maxSummaryTop = 10
+
+// Summary aggregates the results of a run over many messages, so that consumers do not
+// have to recompute it from every result. It is written by batch and by analyze
+// --separator in the json and report output formats.
+type Summary struct {
+	// Messages is the number of results, and Errors the number of messages that could
+	// not be analyzed.
+	Messages   int `json:"messages"`
+	Errors     int `json:"errors"`
+	Suspicious int `json:"suspicious"`
+	// Categories counts the results of each category.
+	Categories        map[string]int `json:"categories"`
+	AverageConfidence float64        `json:"average_confidence"`
+	TopSenderDomains  []Count        `json:"top_sender_domains"`
+	TopURLDomains     []Count        `json:"top_url_domains"`
+	TopURLs           []Count        `json:"top_urls"`
+	DurationSeconds   float64        `json:"duration_seconds"`
+	// LLMUsage is the token usage of the LLM requests of the run, or nil if it made none.
+	LLMUsage *LLMUsage `json:"llm_usage,omitempty"`
+}
+
+// LLMUsage is the token usage of the LLM requests of a run, which tells what it cost and
+// how much of the prompts the prompt cache served.
+type LLMUsage struct {
+	Requests         int `json:"requests"`
+	PromptTokens     int `json:"prompt_tokens"`
+	CachedTokens     int `json:"cached_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	// CacheHitRatio is the fraction of the prompt tokens served from the prompt cache.
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+}
+
+// Count is a value listed in a Summary and the number of results it appears in.
+type Count struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// summaryWriter is implemented by the ResultWriters of the formats that include a
+// Summary.
+type summaryWriter interface {
+	SetSummary(summary *Summary)
+}
+
+// summaryBuilder accumulates the results of a run into its Summary.
+type summaryBuilder struct {
+	start         time.Time
+	summary       Summary
+	confidence    float64
+	judged        int
+	senderDomains map[string]int
+	urlDomains    map[string]int
+	urls          map[string]int
+}
+
+func newSummaryBuilder() *summaryBuilder {
+	return &summaryBuilder{
+		start:         time.Now(),
+		summary:       Summary{Categories: map[string]int{}},
+		senderDomains: map[string]int{},
+		urlDomains:    map[string]int{},
+		urls:          map[string]int{},
+	}
+}
+
+// add counts a result.
+func (b *summaryBuilder) add(result *AnalysisResult) {
+	b.summary.Messages++
+	if j := result.Judgment; j != nil {
+		b.summary.Categories[j.Category]++
+		if j.IsSuspicious {
+			b.summary.Suspicious++
+		}
+		b.confidence += j.ConfidenceScore
+		b.judged++
+	}
+	countDistinct(b.senderDomains, result.From, senderDomain)
+	countDistinct(b.urls, result.URLs, func(u string) string { return u })
+	countDistinct(b.urlDomains, result.URLs, urlDomain)
+}
+
+// fail counts a message that could not be analyzed.
+func (b *summaryBuilder) fail() {
+	b.summary.Errors++
+}
+
+// setUsage sets the token usage of the run, which is left out if it made no request.
+func (b *summaryBuilder) setUsage(usage llm.UsageStats) {
+	b.summary.LLMUsage = nil
+	if usage.Requests > 0 {
+		b.summary.LLMUsage = &LLMUsage{
+			Requests:         usage.Requests,
+			PromptTokens:     usage.PromptTokens,
+			CachedTokens:     usage.CachedTokens,
+			CompletionTokens: usage.CompletionTokens,
+			CacheHitRatio:    usage.CacheHitRatio(),
+		}
+	}
+}
+
+// formatUsage returns a line describing the token usage of a run.
+func formatUsage(usage llm.UsageStats) string {
+	return fmt.Sprintf("LLM usage: %d requests, %d prompt tokens (%d cached, %.0f%% hit ratio), %d completion tokens",
+		usage.Requests, usage.PromptTokens, usage.CachedTokens, usage.CacheHitRatio()*100, usage.CompletionTokens)
+}
+
+// build returns the Summary of the results counted so far.
+func (b *summaryBuilder) build() *Summary {
+	summary := b.summary
+	if b.judged > 0 {
+		summary.AverageConfidence = b.confidence / float64(b.judged)
+	}
+	summary.TopSenderDomains = topCounts(b.senderDomains)
+	summary.TopURLDomains = topCounts(b.urlDomains)
+	summary.TopURLs = topCounts(b.urls)
+	summary.DurationSeconds = time.Since(b.start).Seconds()
+	return &summary
+}
+
+// countDistinct adds one to counts for each distinct non-empty key of values.
+func countDistinct(counts map[string]int, values []string, key func(string) string) {
+	seen := map[string]bool{}
+	for _, v := range values {
+		if k := key(v); k != "" && !seen[k] {
+			seen[k] = true
+			counts[k]++
+		}
+	}
+}
+
+// topCounts returns the maxSummaryTop most frequent values of counts, the most frequent
+// first and equal counts in alphabetical order, so that the summary is stable.
+func topCounts(counts map[string]int) []Count {
+	top := make([]Count, 0, len(counts))
+	for value, count := range counts {
+		top = append(top, Count{Value: value, Count: count})
+	}
+	slices.SortFunc(top, func(a, b Count) int {
+		return cmp.Or(cmp.Compare(b.Count, a.Count), strings.Compare(a.Value, b.Value))
+	})
+	return top[:min(len(top), maxSummaryTop)]
+}
+
+// senderDomain returns the lower-case domain of a From address of a result.
+func senderDomain(address string) string {
+	if addr, err := mail.ParseAddress(address); err == nil {
+		address = addr.Address
+	}
+	i := strings.LastIndexByte(address, '@')
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(strings.Trim(address[i+1:], "<> "))
+}
+
+// urlDomain returns the lower-case host name of a URL.
+func urlDomain(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"mail-analyzer/llm"
+)
+
+func TestSummaryBuilder(t *testing.T) {
+	b := newSummaryBuilder()
+	b.add(&AnalysisResult{
+		From:     []string{`"Bank" <alerts@Bank.example.com>`},
+		Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.9},
+		URLs:     []string{"http://login.evil.example/a", "http://login.evil.example/b", "https://bank.example.com/"},
+	})
+	b.add(&AnalysisResult{
+		From:     []string{"news@shop.example.org"},
+		Judgment: &llm.Judgment{Category: "Safe", ConfidenceScore: 0.5},
+		URLs:     []string{"http://login.evil.example/a"},
+	})
+	b.add(&AnalysisResult{
+		From:     []string{"info@bank.example.com"},
+		Judgment: &llm.Judgment{IsSuspicious: true, Category: "Phishing", ConfidenceScore: 0.7},
+	})
+	b.fail()
+
+	got := b.build()
+	if got.Messages != 3 || got.Errors != 1 || got.Suspicious != 2 {
+		t.Errorf("messages, errors, suspicious = %d, %d, %d, want 3, 1, 2", got.Messages, got.Errors, got.Suspicious)
+	}
+	if want := map[string]int{"Phishing": 2, "Safe": 1}; !reflect.DeepEqual(got.Categories, want) {
+		t.Errorf("Categories = %v, want %v", got.Categories, want)
+	}
+	if got.AverageConfidence < 0.69 || got.AverageConfidence > 0.71 {
+		t.Errorf("AverageConfidence = %v, want 0.7", got.AverageConfidence)
+	}
+	if want := []Count{{"bank.example.com", 2}, {"shop.example.org", 1}}; !reflect.DeepEqual(got.TopSenderDomains, want) {
+		t.Errorf("TopSenderDomains = %v, want %v", got.TopSenderDomains, want)
+	}
+	// Domains are counted once per message.
+	if want := []Count{{"login.evil.example", 2}, {"bank.example.com", 1}}; !reflect.DeepEqual(got.TopURLDomains, want) {
+		t.Errorf("TopURLDomains = %v, want %v", got.TopURLDomains, want)
+	}
+	if want := []Count{{"http://login.evil.example/a", 2}, {"http://login.evil.example/b", 1}, {"https://bank.example.com/", 1}}; !reflect.DeepEqual(got.TopURLs, want) {
+		t.Errorf("TopURLs = %v, want %v", got.TopURLs, want)
+	}
+	if got.DurationSeconds < 0 {
+		t.Errorf("DurationSeconds = %v", got.DurationSeconds)
+	}
+
+	if got.LLMUsage != nil {
+		t.Errorf("LLMUsage without requests = %+v, want none", got.LLMUsage)
+	}
+	b.setUsage(llm.UsageStats{Requests: 3, PromptTokens: 4000, CachedTokens: 3000, CompletionTokens: 300})
+	if want := (&LLMUsage{Requests: 3, PromptTokens: 4000, CachedTokens: 3000, CompletionTokens: 300, CacheHitRatio: 0.75}); !reflect.DeepEqual(b.build().LLMUsage, want) {
+		t.Errorf("LLMUsage = %+v, want %+v", b.build().LLMUsage, want)
+	}
+
+	// Empty runs have empty lists rather than null.
+	if empty := newSummaryBuilder().build(); empty.TopURLs == nil || empty.AverageConfidence != 0 {
+		t.Errorf("empty summary = %+v", empty)
+	}
+}
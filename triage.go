@@ -0,0 +1,485 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"unicode"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+
+	"mail-analyzer/analyzer"
+	"mail-analyzer/email"
+	"mail-analyzer/resultdb"
+)
+
+// triageHeaders are the headers shown in the detail pane, when present.
+var triageHeaders = []string{"From", "To", "Cc", "Reply-To", "Return-Path", "Subject", "Date", "Message-ID", "Authentication-Results"}
+
+// triageKeys lists the keys of the triage view.
+const triageKeys = "↑/↓ select  PgUp/PgDn scroll  c confirm  1 Phishing  2 Spam  3 Safe  a answer  r re-analyze  q quit"
+
+var (
+	selectedStyle = lipgloss.NewStyle().Reverse(true)
+	titleStyle    = lipgloss.NewStyle().Bold(true)
+	dimStyle      = lipgloss.NewStyle().Faint(true)
+)
+
+// runTriage implements the "triage" command, an interactive review of a batch of messages.
+func runTriage(args []string) error {
+	flags := newFlagSet("triage", "path...",
+		"Analyze messages like batch and review the verdicts in a terminal UI. Confirmed and\n"+
+			"corrected verdicts are stored as feedback in the results database given with --db,\n"+
+			"or in the PostgreSQL database in postgres_dsn. Results are not sent to any sink, and\n"+
+			"no action is taken. The model may ask questions about the context of a message, which\n"+
+			"are answered with the a key, and the message is then analyzed again with the answers.")
+	pf := pipelineFlags{analyzeOnly: true}
+	pf.registerAnalysis(flags)
+	dbPath := flags.String("db", "", "SQLite results database to store the analyses and feedback in")
+	concurrency := flags.Int("concurrency", 1, "Number of messages to analyze in parallel")
+	reviewer := flags.String("reviewer", "", "Name recorded with the feedback (default the user name)")
+	if err := parseFlags(flags, args); err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		return usageErrorf("no files or directories given")
+	}
+	if *concurrency < 1 {
+		return usageErrorf("--concurrency must be at least 1")
+	}
+	if !isTerminal(os.Stdin) || !isTerminal(os.Stdout) {
+		return fmt.Errorf("triage needs a terminal")
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	files, err := collectMessageFiles(flags.Args())
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no messages found")
+	}
+	var db *resultdb.DB
+	switch {
+	case *dbPath != "":
+		db, err = openResultDB(cfg, *dbPath)
+	case cfg.PostgresDSN != "":
+		db, err = openResultDB(cfg, "")
+	default:
+		return usageErrorf("--db is required unless postgres_dsn is configured")
+	}
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	defer p.close()
+	if *reviewer == "" {
+		if u, err := user.Current(); err == nil {
+			*reviewer = u.Username
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel() // Abandon the analyses still running on exit.
+	m := newTriageModel(ctx, p, db, files, *concurrency, *reviewer)
+	_, err = tea.NewProgram(m, tea.WithAltScreen()).Run()
+	return err
+}
+
+// triageItem is a message under review.
+type triageItem struct {
+	file      string
+	analyzing bool
+	result    *AnalysisResult
+	parsed    *email.ParsedEmail
+	err       error
+	// analysisID is the ID of the analysis in the results database, once stored.
+	analysisID int64
+	// feedback is the category confirmed or set by the reviewer.
+	feedback string
+	// answers are those of the reviewer to the questions of the model, which the
+	// analyses of the message take into account.
+	answers []analyzer.Answer
+}
+
+// analyzedMsg reports the analysis of items[index].
+type analyzedMsg struct {
+	index  int
+	result *AnalysisResult
+	parsed *email.ParsedEmail
+	err    error
+}
+
+// feedbackMsg reports that feedback on items[index] was stored, or could not be.
+type feedbackMsg struct {
+	index      int
+	category   string
+	analysisID int64
+	err        error
+}
+
+// triageModel is the state of the triage view.
+type triageModel struct {
+	ctx      context.Context
+	p        *pipeline
+	db       *resultdb.DB
+	reviewer string
+	// slots bounds the number of analyses running at a time.
+	slots chan struct{}
+
+	items         []*triageItem
+	cursor        int
+	offset        int
+	scroll        int
+	width, height int
+	status        string
+
+	// answering is the answer of the reviewer being typed to the questions of the
+	// selected message, or nil.
+	answering *answerInput
+}
+
+// answerInput is the state of the answers being typed to the questions of a message.
+type answerInput struct {
+	questions []string
+	answers   []analyzer.Answer
+	text      []rune
+}
+
+func newTriageModel(ctx context.Context, p *pipeline, db *resultdb.DB, files []string, concurrency int, reviewer string) *triageModel {
+	m := &triageModel{ctx: ctx, p: p, db: db, reviewer: reviewer, slots: make(chan struct{}, concurrency), width: 80, height: 24}
+	for _, file := range files {
+		m.items = append(m.items, &triageItem{file: file})
+	}
+	return m
+}
+
+func (m *triageModel) Init() tea.Cmd {
+	cmds := make([]tea.Cmd, len(m.items))
+	for i := range m.items {
+		cmds[i] = m.analyze(i)
+	}
+	return tea.Batch(cmds...)
+}
+
+// analyze starts the analysis of items[i].
+func (m *triageModel) analyze(i int) tea.Cmd {
+	item := m.items[i]
+	item.analyzing = true
+	item.err = nil
+	answers := item.answers
+	return func() tea.Msg {
+		select {
+		case m.slots <- struct{}{}:
+		case <-m.ctx.Done():
+			return analyzedMsg{index: i, err: m.ctx.Err()}
+		}
+		defer func() { <-m.slots }()
+		rawMessage, err := os.ReadFile(item.file)
+		if err != nil {
+			return analyzedMsg{index: i, err: err}
+		}
+		result, err := m.p.analyzeWith(m.ctx, rawMessage, item.file, &analyzer.AnalysisOptions{NeedsContext: true, Answers: answers})
+		msg := analyzedMsg{index: i, result: result, err: err}
+		if err == nil {
+			msg.parsed, _ = email.ParseContext(m.ctx, bytes.NewReader(result.Raw))
+		}
+		return msg
+	}
+}
+
+// giveFeedback stores category as the verdict of the reviewer on items[i], after storing
+// the analysis itself if it is not stored yet.
+func (m *triageModel) giveFeedback(i int, category string) tea.Cmd {
+	item := m.items[i]
+	if item.result == nil {
+		m.status = "The message has no verdict to review."
+		return nil
+	}
+	result, analysisID := item.result, item.analysisID
+	return func() tea.Msg {
+		var err error
+		if analysisID == 0 {
+			if analysisID, err = m.db.Insert(m.ctx, m.p.sinkResult(result)); err != nil {
+				return feedbackMsg{index: i, err: err}
+			}
+		}
+		err = m.db.AddFeedback(m.ctx, resultdb.Feedback{
+			AnalysisID:   analysisID,
+			Category:     category,
+			IsSuspicious: category != "Safe",
+			Reviewer:     m.reviewer,
+		})
+		return feedbackMsg{index: i, category: category, analysisID: analysisID, err: err}
+	}
+}
+
+func (m *triageModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.width, m.height = msg.Width, msg.Height
+	case analyzedMsg:
+		item := m.items[msg.index]
+		item.analyzing = false
+		item.result, item.parsed, item.err = msg.result, msg.parsed, msg.err
+		item.analysisID, item.feedback = 0, ""
+	case feedbackMsg:
+		item := m.items[msg.index]
+		if msg.err != nil {
+			m.status = fmt.Sprintf("Could not store feedback: %v", msg.err)
+			break
+		}
+		item.analysisID, item.feedback = msg.analysisID, msg.category
+		m.status = fmt.Sprintf("Recorded %s for %s.", msg.category, item.file)
+	case tea.KeyMsg:
+		m.status = ""
+		if m.answering != nil {
+			return m, m.answer(msg)
+		}
+		switch msg.String() {
+		case "q", "ctrl+c", "esc":
+			return m, tea.Quit
+		case "up", "k":
+			m.move(-1)
+		case "down", "j":
+			m.move(1)
+		case "home", "g":
+			m.move(-len(m.items))
+		case "end", "G":
+			m.move(len(m.items))
+		case "pgdown", " ":
+			m.scroll += m.detailHeight() / 2
+		case "pgup", "b":
+			m.scroll = max(0, m.scroll-m.detailHeight()/2)
+		case "c":
+			if item := m.items[m.cursor]; item.result != nil {
+				category, _ := normalizeCategory(item.result.Judgment.Category)
+				return m, m.giveFeedback(m.cursor, category)
+			}
+			m.status = "The message has no verdict to review."
+		case "1", "2", "3":
+			return m, m.giveFeedback(m.cursor, evalCategories[msg.String()[0]-'1'])
+		case "a":
+			item := m.items[m.cursor]
+			if item.result == nil || item.analyzing || len(item.result.Judgment.NeedsContext) == 0 {
+				m.status = "The model has no questions about this message."
+				break
+			}
+			m.answering = &answerInput{questions: item.result.Judgment.NeedsContext}
+		case "r":
+			if m.items[m.cursor].analyzing {
+				break
+			}
+			m.scroll = 0
+			return m, m.analyze(m.cursor)
+		}
+	}
+	return m, nil
+}
+
+// answer handles a key typed while answering the questions of the selected message.
+// Enter moves to the next question, and after the last one the message is analyzed
+// again with the answers, added to those given before; esc drops them.
+func (m *triageModel) answer(msg tea.KeyMsg) tea.Cmd {
+	in := m.answering
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return tea.Quit
+	case tea.KeyEsc:
+		m.answering = nil
+	case tea.KeyBackspace:
+		if len(in.text) > 0 {
+			in.text = in.text[:len(in.text)-1]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		in.text = append(in.text, msg.Runes...)
+	case tea.KeyEnter:
+		question := in.questions[len(in.answers)]
+		in.answers = append(in.answers, analyzer.Answer{Question: question, Answer: strings.TrimSpace(string(in.text))})
+		in.text = nil
+		if len(in.answers) < len(in.questions) {
+			break
+		}
+		m.answering = nil
+		item := m.items[m.cursor]
+		item.answers = append(item.answers, in.answers...)
+		m.scroll = 0
+		return m.analyze(m.cursor)
+	}
+	return nil
+}
+
+// move moves the selection by delta messages.
+func (m *triageModel) move(delta int) {
+	m.cursor = min(max(m.cursor+delta, 0), len(m.items)-1)
+	m.scroll = 0
+}
+
+// listHeight is the number of messages shown at a time; the detail pane gets the rest
+// of the screen below the title, the separator and the status line.
+func (m *triageModel) listHeight() int {
+	return min(len(m.items), max(3, (m.height-3)/3))
+}
+
+func (m *triageModel) detailHeight() int {
+	return max(1, m.height-3-m.listHeight())
+}
+
+func (m *triageModel) View() string {
+	var b strings.Builder
+	analyzed, reviewed := 0, 0
+	for _, item := range m.items {
+		if !item.analyzing {
+			analyzed++
+		}
+		if item.feedback != "" {
+			reviewed++
+		}
+	}
+	b.WriteString(titleStyle.Render(ansi.Truncate(fmt.Sprintf("mail-analyzer triage: %d messages, %d analyzed, %d reviewed",
+		len(m.items), analyzed, reviewed), m.width, "…")))
+	b.WriteString("\n")
+
+	// Keep the selection in the visible part of the list.
+	height := m.listHeight()
+	if m.cursor < m.offset {
+		m.offset = m.cursor
+	} else if m.cursor >= m.offset+height {
+		m.offset = m.cursor - height + 1
+	}
+	for i := m.offset; i < m.offset+height; i++ {
+		line := ansi.Truncate(m.listLine(m.items[i]), m.width, "…")
+		if i == m.cursor {
+			line = selectedStyle.Render(line + strings.Repeat(" ", max(0, m.width-ansi.StringWidth(line))))
+		}
+		b.WriteString(line)
+		b.WriteString("\n")
+	}
+	b.WriteString(dimStyle.Render(strings.Repeat("─", m.width)))
+	b.WriteString("\n")
+
+	detail := m.detailLines(m.items[m.cursor])
+	m.scroll = min(m.scroll, max(0, len(detail)-m.detailHeight()))
+	for i := range m.detailHeight() {
+		if j := m.scroll + i; j < len(detail) {
+			b.WriteString(ansi.Truncate(detail[j], m.width, "…"))
+		}
+		b.WriteString("\n")
+	}
+
+	status := m.status
+	if in := m.answering; in != nil {
+		status = fmt.Sprintf("Q%d/%d %s > %s", len(in.answers)+1, len(in.questions),
+			terminalText(in.questions[len(in.answers)]), terminalText(string(in.text)))
+	} else if status == "" {
+		status = triageKeys
+	}
+	b.WriteString(dimStyle.Render(ansi.Truncate(status, m.width, "…")))
+	return b.String()
+}
+
+// listLine summarizes item: its review mark, verdict, subject and sender.
+func (m *triageModel) listLine(item *triageItem) string {
+	mark, verdict := " ", ""
+	switch {
+	case item.analyzing:
+		verdict = "analyzing…"
+	case item.err != nil:
+		mark, verdict = "!", "error"
+	default:
+		j := item.result.Judgment
+		verdict = fmt.Sprintf("%-8s %.2f", j.Category, j.ConfidenceScore)
+		if item.feedback != "" {
+			mark = "✓"
+			if category, _ := normalizeCategory(j.Category); category != item.feedback {
+				verdict = fmt.Sprintf("%-8s → %s", j.Category, item.feedback)
+			}
+		}
+	}
+	subject, from := item.file, ""
+	if item.result != nil {
+		subject = terminalText(item.result.Subject)
+		from = terminalText(strings.Join(item.result.From, ", "))
+	}
+	return fmt.Sprintf("%s %-20s %s  %s", mark, verdict, subject, from)
+}
+
+// detailLines describes item: its file, verdict, headers, URLs and body.
+func (m *triageModel) detailLines(item *triageItem) []string {
+	lines := []string{"File: " + terminalText(item.file)}
+	switch {
+	case item.analyzing:
+		return append(lines, "", "Analyzing…")
+	case item.err != nil:
+		return append(lines, "", "Error: "+terminalText(item.err.Error()))
+	}
+	j := item.result.Judgment
+	lines = append(lines, titleStyle.Render(fmt.Sprintf("Verdict: %s (suspicious: %t, confidence %.2f)", j.Category, j.IsSuspicious, j.ConfidenceScore)))
+	if item.feedback != "" {
+		lines = append(lines, fmt.Sprintf("Reviewed: %s", item.feedback))
+	}
+	lines = append(lines, wrapText("Reason: "+terminalText(j.Reason), m.width)...)
+	if len(j.NeedsContext) > 0 {
+		lines = append(lines, "", titleStyle.Render("Questions (a to answer)"))
+		for _, question := range j.NeedsContext {
+			lines = append(lines, wrapText("- "+terminalText(question), m.width)...)
+		}
+	}
+	if len(item.answers) > 0 {
+		lines = append(lines, "", titleStyle.Render("Answers"))
+		for _, a := range item.answers {
+			lines = append(lines, wrapText(terminalText(a.Question+" → "+a.Answer), m.width)...)
+		}
+	}
+
+	lines = append(lines, "", titleStyle.Render("Headers"))
+	if item.parsed != nil {
+		for _, name := range triageHeaders {
+			for _, value := range item.parsed.Header.Values(name) {
+				lines = append(lines, terminalText(name+": "+value))
+			}
+		}
+	}
+	lines = append(lines, "", titleStyle.Render(fmt.Sprintf("URLs (%d)", len(item.result.URLs))))
+	for _, u := range item.result.URLs {
+		lines = append(lines, terminalText(u))
+	}
+	lines = append(lines, "", titleStyle.Render("Body"))
+	if item.parsed != nil {
+		for _, line := range strings.Split(item.parsed.Body, "\n") {
+			lines = append(lines, wrapText(terminalText(strings.TrimRight(line, "\r")), m.width)...)
+		}
+	}
+	return lines
+}
+
+// wrapText splits s into lines of at most width columns, breaking at spaces if possible.
+func wrapText(s string, width int) []string {
+	if width <= 0 {
+		return []string{s}
+	}
+	return strings.Split(ansi.Wrap(s, width, ""), "\n")
+}
+
+// terminalText replaces the control characters in s, such as line breaks and the escape
+// sequences an attacker could put in a message, with spaces.
+func terminalText(s string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
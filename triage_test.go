@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"mail-analyzer/config"
+	"mail-analyzer/llm/llmtest"
+	"mail-analyzer/resultdb"
+)
+
+func TestTriageModel(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	db, err := resultdb.Open(filepath.Join(t.TempDir(), "results.sqlite"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	file := filepath.Join(t.TempDir(), "a.eml")
+	os.WriteFile(file, []byte("Message-ID: <1@example.com>\r\nSubject: Verify\r\n\r\nLog in \x1b[31mnow\r\n"), 0o600)
+
+	m := newTriageModel(context.Background(), p, db, []string{file}, 1, "alice")
+	m.Update(m.analyze(0)())
+	if view := m.View(); !strings.Contains(view, "Phishing") || !strings.Contains(view, "Verify") || strings.Contains(view, "\x1b[31m") {
+		t.Errorf("View() after the analysis = %q", view)
+	}
+
+	// Correct the verdict to Spam.
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("2")})
+	if cmd == nil {
+		t.Fatal("Update() of key 2 returned no command")
+	}
+	m.Update(cmd())
+	item := m.items[0]
+	if item.feedback != "Spam" || item.analysisID == 0 {
+		t.Fatalf("item after feedback = %+v", item)
+	}
+	feedback, err := db.Feedback(context.Background(), item.analysisID)
+	if err != nil || len(feedback) != 1 || feedback[0].Category != "Spam" || !feedback[0].IsSuspicious || feedback[0].Reviewer != "alice" {
+		t.Errorf("Feedback() = %+v, %v", feedback, err)
+	}
+	if view := m.View(); !strings.Contains(view, "✓") || !strings.Contains(view, "→ Spam") {
+		t.Errorf("View() after feedback = %q", view)
+	}
+
+	// Re-analysis starts over.
+	if _, cmd := m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("r")}); cmd == nil || !item.analyzing {
+		t.Fatal("Update() of key r did not start an analysis")
+	} else {
+		m.Update(cmd())
+	}
+	if item.analyzing || item.feedback != "" || item.analysisID != 0 {
+		t.Errorf("item after re-analysis = %+v", item)
+	}
+}
+
+func TestTriageModel_Answers(t *testing.T) {
+	asks := llmtest.Verdict("Phishing", 0.6, "Unknown invoice sender.")
+	asks.Judgment.NeedsContext = []string{"Is billing.example a supplier?"}
+	llmServer := llmtest.NewServer(asks, llmtest.Verdict("Safe", 0.9, "Invoice of a known supplier."))
+	t.Cleanup(llmServer.Close)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{analyzeOnly: true})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	file := filepath.Join(t.TempDir(), "a.eml")
+	os.WriteFile(file, []byte("From: billing@billing.example\r\nSubject: Invoice\r\n\r\nPlease pay.\r\n"), 0o600)
+
+	m := newTriageModel(context.Background(), p, nil, []string{file}, 1, "alice")
+	m.Update(m.analyze(0)())
+	if view := m.View(); !strings.Contains(view, "Is billing.example a supplier?") {
+		t.Errorf("View() after the analysis = %q, want the question", view)
+	}
+	if tools, _ := json.Marshal(llmServer.Requests()[0].Tools); !strings.Contains(string(tools), `"needs_context"`) {
+		t.Errorf("tools of the request = %s, want needs_context", tools)
+	}
+
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("yes, since 2019x")})
+	m.Update(tea.KeyMsg{Type: tea.KeyBackspace})
+	if view := m.View(); !strings.Contains(view, "Q1/1") || !strings.Contains(view, "yes, since 2019") {
+		t.Errorf("View() while answering = %q", view)
+	}
+	_, cmd := m.Update(tea.KeyMsg{Type: tea.KeyEnter})
+	if cmd == nil || !m.items[0].analyzing || m.answering != nil {
+		t.Fatal("Update() of the last answer did not start an analysis")
+	}
+	m.Update(cmd())
+	item := m.items[0]
+	if item.result == nil || item.result.Judgment.Category != "Safe" {
+		t.Fatalf("item after the answers = %+v", item)
+	}
+	prompt := llmServer.Requests()[1].Messages[len(llmServer.Requests()[1].Messages)-1].Content
+	if !strings.Contains(prompt, "Q: Is billing.example a supplier?\nA: yes, since 2019\n") {
+		t.Errorf("prompt of the follow-up analysis = %q, want the answer", prompt)
+	}
+
+	// Without questions, there is nothing to answer.
+	m.Update(tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune("a")})
+	if m.answering != nil {
+		t.Error("Update() of key a started answering a message without questions")
+	}
+}
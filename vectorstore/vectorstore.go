@@ -0,0 +1,210 @@
+// Package vectorstore keeps embeddings of previously judged emails so that
+// near-identical messages can be recognized without another LLM call.
+package vectorstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"mail-analyzer/atrest"
+	"mail-analyzer/llm"
+)
+
+// Entry is a previously judged email.
+type Entry struct {
+	MessageID string       `json:"message_id"`
+	Vector    []float64    `json:"vector"`
+	Judgment  llm.Judgment `json:"judgment"`
+}
+
+// Store is a labeled vector store persisted as a JSON file. It is safe for concurrent use.
+type Store struct {
+	path string
+
+	// saveMu serializes Save, so that an older snapshot is never renamed over a newer one.
+	saveMu sync.Mutex
+	key    *atrest.Key
+
+	mu      sync.RWMutex
+	entries []Entry
+	// maxEntries is the number of entries kept, or 0 for no limit.
+	maxEntries int
+	// unsaved is the number of entries added since the last Save.
+	unsaved int
+}
+
+// storePurpose is the purpose of the file of a Store for atrest.
+const storePurpose = "vectorstore"
+
+// Open loads the store at path. A missing file yields an empty store that is created on Save.
+func Open(path string) (*Store, error) {
+	return OpenEncrypted(path, nil)
+}
+
+// OpenEncrypted is Open for a store encrypted with key. A store that was saved in the
+// clear is only read if key allows plaintext, and is encrypted when it is saved again.
+func OpenEncrypted(path string, key *atrest.Key) (*Store, error) {
+	s := &Store{path: path, key: key}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not read vector store: %w", err)
+	}
+	if data, err = key.Open(data, storePurpose); err != nil {
+		return nil, fmt.Errorf("could not read vector store %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &s.entries); err != nil {
+		return nil, fmt.Errorf("could not decode vector store %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Path returns the file of the store.
+func (s *Store) Path() string {
+	return s.path
+}
+
+// Key returns the key the store is encrypted with, or nil.
+func (s *Store) Key() *atrest.Key {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	return s.key
+}
+
+// SetKey sets the key the store is encrypted with from the next Save.
+func (s *Store) SetKey(key *atrest.Key) {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.key = key
+}
+
+// SetMaxEntries limits the store to its newest n entries, dropping the oldest ones. Zero
+// means no limit.
+func (s *Store) SetMaxEntries(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxEntries = n
+	s.trim()
+}
+
+// trim drops the oldest entries beyond maxEntries. s.mu must be held.
+func (s *Store) trim() {
+	if s.maxEntries > 0 && len(s.entries) > s.maxEntries {
+		s.entries = slices.Delete(s.entries, 0, len(s.entries)-s.maxEntries)
+	}
+}
+
+// Unsaved returns the number of entries added since the last Save.
+func (s *Store) Unsaved() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.unsaved
+}
+
+// Len returns the number of entries in the store.
+func (s *Store) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.entries)
+}
+
+// Categories returns the number of entries per judgment category.
+func (s *Store) Categories() map[string]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	counts := make(map[string]int)
+	for _, e := range s.entries {
+		counts[e.Judgment.Category]++
+	}
+	return counts
+}
+
+// Nearest returns the entry most similar to vector and its cosine similarity.
+// It returns nil if the store is empty.
+func (s *Store) Nearest(vector []float64) (*Entry, float64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var best *Entry
+	bestScore := -1.0
+	for i := range s.entries {
+		score := CosineSimilarity(vector, s.entries[i].Vector)
+		if score > bestScore {
+			best, bestScore = &s.entries[i], score
+		}
+	}
+	if best == nil {
+		return nil, 0
+	}
+	entry := *best
+	return &entry, bestScore
+}
+
+// Add appends an entry to the store. Call Save to persist it.
+func (s *Store) Add(entry Entry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries = append(s.entries, entry)
+	s.unsaved++
+	s.trim()
+}
+
+// Save writes the store to disk. The file is replaced atomically and is only
+// readable by the owner, since judgments describe the contents of private mail.
+func (s *Store) Save() error {
+	s.saveMu.Lock()
+	defer s.saveMu.Unlock()
+	s.mu.RLock()
+	data, err := json.Marshal(s.entries)
+	saved := s.unsaved
+	s.mu.RUnlock()
+	if err != nil {
+		return fmt.Errorf("could not encode vector store: %w", err)
+	}
+	data = s.key.Seal(data, storePurpose)
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".vectorstore-*")
+	if err != nil {
+		return fmt.Errorf("could not create temporary file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write vector store: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not write vector store: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	s.unsaved -= saved
+	s.mu.Unlock()
+	return nil
+}
+
+// CosineSimilarity returns the cosine similarity of a and b, or 0 if their
+// lengths differ or either is a zero vector.
+func CosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
@@ -0,0 +1,156 @@
+package vectorstore
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"mail-analyzer/atrest"
+	"mail-analyzer/llm"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b []float64
+		want float64
+	}{
+		{name: "Identical", a: []float64{1, 2, 3}, b: []float64{1, 2, 3}, want: 1},
+		{name: "Scaled", a: []float64{1, 2, 3}, b: []float64{2, 4, 6}, want: 1},
+		{name: "Orthogonal", a: []float64{1, 0}, b: []float64{0, 1}, want: 0},
+		{name: "Opposite", a: []float64{1, 0}, b: []float64{-1, 0}, want: -1},
+		{name: "Length mismatch", a: []float64{1, 0}, b: []float64{1, 0, 0}, want: 0},
+		{name: "Zero vector", a: []float64{0, 0}, b: []float64{1, 0}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := CosineSimilarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("CosineSimilarity() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStore_SaveAndNearest(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if entry, _ := store.Nearest([]float64{1, 0}); entry != nil {
+		t.Errorf("Nearest() on empty store = %+v, want nil", entry)
+	}
+
+	store.Add(Entry{MessageID: "<a@example.com>", Vector: []float64{1, 0}, Judgment: llm.Judgment{Category: "Phishing"}})
+	store.Add(Entry{MessageID: "<b@example.com>", Vector: []float64{0, 1}, Judgment: llm.Judgment{Category: "Safe"}})
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Stat() error = %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("store file mode = %o, want 600", perm)
+	}
+
+	reopened, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	entry, score := reopened.Nearest([]float64{0.1, 0.9})
+	if entry == nil || entry.MessageID != "<b@example.com>" || entry.Judgment.Category != "Safe" {
+		t.Fatalf("Nearest() = %+v, want <b@example.com>", entry)
+	}
+	if score < 0.99 {
+		t.Errorf("Nearest() score = %v, want > 0.99", score)
+	}
+}
+
+func TestOpenEncrypted(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	// A store saved in the clear is encrypted when it is saved with a key that allows
+	// reading it.
+	store, _ := Open(path)
+	store.Add(Entry{MessageID: "<a@example.com>", Vector: []float64{1, 0}, Judgment: llm.Judgment{Category: "Phishing", Reason: "Fake invoice."}})
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	key, _ := atrest.NewKey("Zq0mWc1d8x7Yk3vB2nT5rP9sL4hJ6gF0")
+	if _, err := OpenEncrypted(path, key); !errors.Is(err, atrest.ErrPlaintext) {
+		t.Fatalf("OpenEncrypted() of a store saved in the clear error = %v, want ErrPlaintext", err)
+	}
+	key.AllowPlaintext()
+	if store, err := OpenEncrypted(path, key); err != nil || store.Len() != 1 {
+		t.Fatalf("OpenEncrypted() of a store saved in the clear = %v, %v", store, err)
+	} else if err := store.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, _ := os.ReadFile(path)
+	if !atrest.IsSealed(data) || strings.Contains(string(data), "invoice") {
+		t.Errorf("saved store = %q, want it encrypted", data)
+	}
+
+	store, err := OpenEncrypted(path, key)
+	if err != nil || store.Len() != 1 {
+		t.Fatalf("OpenEncrypted() = %v, %v, want 1 entry", store, err)
+	}
+	if entry, _ := store.Nearest([]float64{1, 0}); entry == nil || entry.Judgment.Reason != "Fake invoice." {
+		t.Errorf("Nearest() = %+v, want the decrypted entry", entry)
+	}
+	if _, err := Open(path); !errors.Is(err, atrest.ErrNoKey) {
+		t.Errorf("Open() of an encrypted store error = %v, want ErrNoKey", err)
+	}
+}
+
+func TestStore_MaxEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, _ := Open(path)
+	store.SetMaxEntries(2)
+	for _, id := range []string{"<a@example.com>", "<b@example.com>", "<c@example.com>"} {
+		store.Add(Entry{MessageID: id, Vector: []float64{1, 0}})
+	}
+	if store.Len() != 2 || store.Unsaved() != 3 {
+		t.Fatalf("Len() = %d, Unsaved() = %d, want 2 and 3", store.Len(), store.Unsaved())
+	}
+	if entry, _ := store.Nearest([]float64{1, 0}); entry.MessageID != "<b@example.com>" {
+		t.Errorf("Nearest() = %+v, want the oldest entry kept", entry)
+	}
+	if err := store.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	if store.Unsaved() != 0 {
+		t.Errorf("Unsaved() after Save() = %d", store.Unsaved())
+	}
+	reopened, _ := Open(path)
+	reopened.SetMaxEntries(1)
+	if reopened.Len() != 1 {
+		t.Errorf("Len() after SetMaxEntries(1) = %d", reopened.Len())
+	}
+}
+
+func TestStore_ConcurrentSave(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, _ := Open(path)
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			store.Add(Entry{MessageID: fmt.Sprintf("<%d@example.com>", i), Vector: []float64{1, 0}})
+			if err := store.Save(); err != nil {
+				t.Errorf("Save() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	// The last save renames the newest snapshot, which has every entry.
+	if reopened, _ := Open(path); reopened.Len() != 20 {
+		t.Errorf("saved store has %d entries, want 20", reopened.Len())
+	}
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"mail-analyzer/analyzer"
+	"mail-analyzer/config"
+	"mail-analyzer/queue"
+)
+
+// Timeouts of the worker command.
+const (
+	// queueRetryDelay is the wait before receiving again after a queue error.
+	queueRetryDelay = 5 * time.Second
+	// queuePublishTimeout bounds the publication of a result or the release of a job.
+	queuePublishTimeout = 30 * time.Second
+)
+
+// runWorker implements the "worker" command, which analyzes jobs received from a
+// message queue and publishes the results.
+func runWorker(args []string) error {
+	fs := newFlagSet("worker", "",
+		"Analyze messages received as jobs from a Redis list, NATS subject or AMQP queue and\n"+
+			"publish the results to another one. Start workers on several hosts to share the jobs\n"+
+			"of one queue. On SIGINT or SIGTERM, the jobs being analyzed are returned to the queue.")
+	var pf pipelineFlags
+	pf.register(fs)
+	pf.daemon = true
+	queueURL := fs.String("queue", "", "URL of the queue, instead of queue_url")
+	concurrency := fs.Int("concurrency", 1, "Number of jobs to analyze in parallel")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return usageErrorf("unexpected arguments: %v", fs.Args())
+	}
+	if *concurrency < 1 {
+		return usageErrorf("--concurrency must be at least 1")
+	}
+
+	cfg, err := pf.setup()
+	if err != nil {
+		return err
+	}
+	if *queueURL != "" {
+		cfg.QueueURL = *queueURL
+		// The defaults of the queue names only apply with a configured queue_url.
+		cfg.QueueJobs = cmp.Or(cfg.QueueJobs, config.DefaultQueueJobs)
+		cfg.QueueResults = cmp.Or(cfg.QueueResults, config.DefaultQueueResults)
+	}
+	if cfg.QueueURL == "" {
+		return usageErrorf("--queue is required unless queue_url is configured")
+	}
+	p, err := newPipeline(cfg, &pf)
+	if err != nil {
+		return err
+	}
+	q, err := queue.Open(cfg.QueueURL, queue.Options{
+		Jobs:     cfg.QueueJobs,
+		Results:  cfg.QueueResults,
+		Prefetch: *concurrency,
+	})
+	if err != nil {
+		return fmt.Errorf("error connecting to the queue: %w", err)
+	}
+
+	live := newLivePipeline(p, &pf)
+	ctx, stop := shutdownContext()
+	defer stop()
+	go live.watch(ctx)
+	fmt.Fprintf(os.Stderr, "Waiting for jobs on %s\n", cfg.QueueJobs)
+	err = runWorkers(ctx, live, q, *concurrency)
+	if err := errors.Join(err, q.Close(), live.close()); err != nil {
+		return err
+	}
+	return interruptedError(ctx, context.Cause(ctx))
+}
+
+// queueJob is a job received from the queue: either this JSON object, or the raw message.
+type queueJob struct {
+	// RequestID is returned with the result, to match results with jobs.
+	RequestID string `json:"request_id"`
+	// RawMessage is the message in RFC 5322 format, encoded in base64.
+	RawMessage []byte `json:"raw_message"`
+	// Options are the analysis options of the job, or nil.
+	Options *requestOptions `json:"options,omitempty"`
+}
+
+// queueResult is the result of a job published to the queue.
+type queueResult struct {
+	RequestID string          `json:"request_id,omitempty"`
+	Result    *AnalysisResult `json:"result,omitempty"`
+	Error     string          `json:"error,omitempty"`
+}
+
+// parseJob returns the job of a message received from the queue. A message that is not a
+// JSON object is the raw message to analyze.
+func parseJob(body []byte) (queueJob, error) {
+	if !bytes.HasPrefix(bytes.TrimSpace(body), []byte("{")) {
+		return queueJob{RawMessage: body}, nil
+	}
+	var job queueJob
+	if err := json.Unmarshal(body, &job); err != nil {
+		return job, fmt.Errorf("invalid job: %w", err)
+	}
+	if len(job.RawMessage) == 0 {
+		return job, errors.New("invalid job: raw_message is required")
+	}
+	return job, nil
+}
+
+// runWorkers processes jobs from q with concurrency workers until ctx is done or the
+// connection to the queue is lost.
+func runWorkers(ctx context.Context, src pipelineSource, q queue.Queue, concurrency int) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	var wg sync.WaitGroup
+	for range concurrency {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, err := q.Receive(ctx)
+				switch {
+				case ctx.Err() != nil:
+					if job != nil {
+						releaseJob(job)
+					}
+					return
+				case errors.Is(err, queue.ErrClosed):
+					cancel(err)
+					return
+				case err != nil:
+					fmt.Fprintf(os.Stderr, "Error: error receiving jobs: %v\n", err)
+					select {
+					case <-ctx.Done():
+					case <-time.After(queueRetryDelay):
+					}
+					continue
+				}
+				p, release := src.acquire()
+				processJob(ctx, p, job)
+				release()
+			}
+		}()
+	}
+	wg.Wait()
+	if err := context.Cause(ctx); !errors.Is(err, context.Canceled) {
+		return err
+	}
+	return nil
+}
+
+// processJob analyzes a job and publishes its result. Jobs that cannot be analyzed are
+// completed with an error result, unless ctx is cancelled first: they are then released.
+func processJob(ctx context.Context, p *pipeline, job queue.Job) {
+	var result queueResult
+	j, err := parseJob(job.Body())
+	result.RequestID = j.RequestID
+	var opts *analyzer.AnalysisOptions
+	if err == nil {
+		opts, err = p.analysisOptions(j.Options)
+	}
+	if err == nil {
+		result.Result, err = p.analyzeWith(ctx, j.RawMessage, "", opts)
+	}
+	if ctx.Err() != nil {
+		releaseJob(job)
+		return
+	}
+	if err != nil {
+		result.Error = err.Error()
+	} else if err := errors.Join(p.record(ctx, result.Result), p.act(ctx, result.Result)); err != nil {
+		// As with the other servers, failed deliveries and actions do not fail the job.
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.Encode(result)
+	publishCtx, cancel := context.WithTimeout(context.Background(), queuePublishTimeout)
+	defer cancel()
+	if err := job.Complete(publishCtx, bytes.TrimSuffix(buf.Bytes(), []byte("\n"))); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: error publishing result: %v\n", err)
+		releaseJob(job)
+	}
+}
+
+// releaseJob returns a job to the queue, reporting failures.
+func releaseJob(job queue.Job) {
+	ctx, cancel := context.WithTimeout(context.Background(), queuePublishTimeout)
+	defer cancel()
+	if err := job.Release(ctx); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: error returning job to the queue: %v\n", err)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+
+	"mail-analyzer/config"
+	"mail-analyzer/queue"
+)
+
+func TestParseJob(t *testing.T) {
+	raw := "Message-ID: <1@example.com>\r\nSubject: Hi\r\n\r\nHello\r\n"
+	job, err := parseJob([]byte(raw))
+	if err != nil || job.RequestID != "" || string(job.RawMessage) != raw {
+		t.Errorf("parseJob(raw message) = %+v, %v", job, err)
+	}
+	body := `{"request_id": "42", "raw_message": "` + base64.StdEncoding.EncodeToString([]byte(raw)) + `"}`
+	job, err = parseJob([]byte(body))
+	if err != nil || job.RequestID != "42" || string(job.RawMessage) != raw {
+		t.Errorf("parseJob(JSON) = %+v, %v", job, err)
+	}
+	for _, body := range []string{`{"request_id": "42"}`, `{"raw_message": "not base64!"}`, `{`} {
+		if _, err := parseJob([]byte(body)); err == nil {
+			t.Errorf("parseJob(%s) succeeded, want an error", body)
+		}
+	}
+}
+
+func TestRunWorkers(t *testing.T) {
+	llmServer := newFakeLLM(t)
+	p, err := newPipeline(&config.Config{OpenAIBaseURL: llmServer.URL, ChatCompletionsPath: "/chat/completions"}, &pipelineFlags{})
+	if err != nil {
+		t.Fatalf("newPipeline() error = %v", err)
+	}
+	server := miniredis.RunT(t)
+	q, err := queue.Open("redis://"+server.Addr(), queue.Options{Jobs: "jobs", Results: "results"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer q.Close()
+
+	raw := "Message-ID: <1@example.com>\r\nSubject: Verify\r\n\r\nhttps://evil.example.com\r\n"
+	server.Lpush("jobs", raw)
+	server.Lpush("jobs", `{"request_id": "42", "raw_message": "`+base64.StdEncoding.EncodeToString([]byte(raw))+`"}`)
+	server.Lpush("jobs", `{"request_id": "43"}`)
+	server.Lpush("jobs", `{"request_id": "44", "options": {"language": "Japanese", "enrichments": []}, "raw_message": "`+base64.StdEncoding.EncodeToString([]byte(raw))+`"}`)
+	server.Lpush("jobs", `{"request_id": "45", "options": {"enrichments": ["whois"]}, "raw_message": "`+base64.StdEncoding.EncodeToString([]byte(raw))+`"}`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- runWorkers(ctx, p, q, 2) }()
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if list, _ := server.List("results"); len(list) == 5 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+	if err := <-done; err != nil {
+		t.Errorf("runWorkers() error = %v", err)
+	}
+
+	list, _ := server.List("results")
+	results := map[string]queueResult{}
+	for _, body := range list {
+		var r queueResult
+		if err := json.Unmarshal([]byte(body), &r); err != nil {
+			t.Fatalf("invalid result %s: %v", body, err)
+		}
+		results[r.RequestID] = r
+	}
+	if r := results[""]; r.Result == nil || r.Result.MessageID != "1@example.com" || r.Result.Judgment.Category != "Phishing" {
+		t.Errorf("result of the raw message = %+v", r)
+	}
+	if r := results["42"]; r.Result == nil || r.Result.Judgment.Category != "Phishing" {
+		t.Errorf("result of job 42 = %+v", r)
+	}
+	if r := results["43"]; r.Result != nil || r.Error == "" {
+		t.Errorf("result of the invalid job 43 = %+v, want an error", r)
+	}
+	if r := results["44"]; r.Result == nil || r.Result.Judgment.Category != "Phishing" {
+		t.Errorf("result of job 44 with options = %+v", r)
+	}
+	if r := results["45"]; r.Result != nil || r.Error == "" {
+		t.Errorf("result of job 45 with an unknown enrichment = %+v, want an error", r)
+	}
+	if server.Exists("jobs") || server.Exists("jobs.processing") {
+		t.Error("jobs remain in the queue")
+	}
+}